@@ -1,51 +1,15 @@
 package main
 
 import (
-	"net"
-	"time"
-
-	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitorcmd"
 )
 
 var log = logrus.New()
 
 func main() {
-	var rootCmd = &cobra.Command{
-		Use:   "dnsmasqmonitor path_to_kubeconfig path_to_host_file_cfg_template path_to_config",
-		Short: "Monitors dnsmasq host configmap",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) != 3 {
-				cmd.Help()
-				return nil
-			}
-			apiVip, err := cmd.Flags().GetIP("api-vip")
-			if err != nil {
-				apiVip = nil
-			}
-			apiVips, err := cmd.Flags().GetIPSlice("api-vips")
-			if err != nil {
-				apiVips = []net.IP{}
-			}
-			// If we were passed a VIP using the old interface, coerce it into the list
-			// format that the rest of the code now expects.
-			if len(apiVips) < 1 && apiVip != nil {
-				apiVips = []net.IP{apiVip}
-			}
-
-			checkInterval, err := cmd.Flags().GetDuration("check-interval")
-			if err != nil {
-				return err
-			}
-
-			return monitor.DnsmasqWatch(args[0], args[1], args[2], apiVips, checkInterval)
-		},
-	}
-	rootCmd.Flags().Duration("check-interval", time.Second*30, "Time between coredns watch checks")
-	rootCmd.Flags().IP("api-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift API")
-	rootCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
-	if err := rootCmd.Execute(); err != nil {
+	if err := monitorcmd.NewDnsmasqCommand("dnsmasqmonitor").Execute(); err != nil {
 		log.Fatalf("Failed due to %s", err)
 	}
 }
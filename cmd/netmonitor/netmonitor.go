@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitorcmd"
+)
+
+var log = logrus.New()
+
+func main() {
+	if err := monitorcmd.NewNetmonitorCommand("netmonitor").Execute(); err != nil {
+		log.Fatalf("Failed due to %s", err)
+	}
+}
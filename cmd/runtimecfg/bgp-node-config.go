@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/controller"
+)
+
+var (
+	bgpNodeConfigCmd = &cobra.Command{
+		Use: `bgp-node-config [path to kubeconfig]
+			It renders the frr.conf of every node from the BGP settings of the cluster Infrastructure and copies it onto the nodes`,
+		Short: "Reconciles the per-node FRR configuration of the BGP advertised VIPs",
+		RunE:  runBGPNodeConfig,
+	}
+)
+
+func init() {
+	bgpNodeConfigCmd.Flags().StringP("namespace", "n", os.Getenv("POD_NAMESPACE"), "Namespace of the per-node ConfigMaps and copy pods")
+	bgpNodeConfigCmd.Flags().String("template", "/config/frr.conf.tmpl", "Path to the frr.conf template rendered for every node")
+	bgpNodeConfigCmd.Flags().String("copy-image", os.Getenv("RUNTIMECFG_IMAGE"), "Image of the pods copying frr.conf onto the nodes")
+	bgpNodeConfigCmd.Flags().String("host-dir", "/etc/frr", "Directory of frr.conf on the nodes")
+	bgpNodeConfigCmd.Flags().Duration("interval", 30*time.Second, "Time between reconciles")
+	bgpNodeConfigCmd.Flags().String("metrics-address", "", "Address (e.g. :29450) where the reconcile /metrics are served. Disabled when empty")
+	bgpNodeConfigCmd.Flags().Bool("once", false, "Reconcile once and exit")
+	rootCmd.AddCommand(bgpNodeConfigCmd)
+}
+
+func runBGPNodeConfig(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+	r := &controller.BGPNodeConfigReconciler{KubeconfigPath: kubeCfgPath}
+	var err error
+	if r.Namespace, err = cmd.Flags().GetString("namespace"); err != nil {
+		return err
+	}
+	if r.TemplatePath, err = cmd.Flags().GetString("template"); err != nil {
+		return err
+	}
+	if r.CopyImage, err = cmd.Flags().GetString("copy-image"); err != nil {
+		return err
+	}
+	if r.HostDir, err = cmd.Flags().GetString("host-dir"); err != nil {
+		return err
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		return err
+	}
+	if once {
+		return r.Reconcile(context.Background())
+	}
+	metricsAddr, err := cmd.Flags().GetString("metrics-address")
+	if err != nil {
+		return err
+	}
+	serveMetrics(metricsAddr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := reconcile("bgp-node-config", func() error {
+			return r.Reconcile(context.Background())
+		}); err != nil {
+			log.WithFields(logrus.Fields{
+				"namespace": r.Namespace,
+			}).WithError(err).Error("Failed to reconcile the FRR node configuration")
+		}
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
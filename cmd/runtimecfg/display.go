@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
 	"github.com/davecgh/go-spew/spew"
+	"github.com/ghodss/yaml"
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
 	"github.com/spf13/cobra"
-	"net"
 )
 
 var (
@@ -19,6 +24,8 @@ var (
 func init() {
 	displayCmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
 	displayCmd.Flags().Bool("verbose", false, "Display extra information about the rendering")
+	displayCmd.Flags().StringP("output", "o", "spew", "Output format, one of spew, json or yaml")
+	displayCmd.Flags().Bool("redact", false, "Replace the values that identify the site, like the cluster domain and MAC addresses")
 	displayCmd.Flags().IP("api-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift API")
 	displayCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
 	displayCmd.Flags().IP("ingress-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift Ingress Routers")
@@ -31,6 +38,8 @@ func init() {
 	displayCmd.Flags().IPSlice("cloud-ext-lb-ips", nil, "IP Addresses of Cloud External Load Balancers for OpenShift API")
 	displayCmd.Flags().IPSlice("cloud-int-lb-ips", nil, "IP Addresses of Cloud Internal Load Balancers for OpenShift API")
 	displayCmd.Flags().IPSlice("cloud-ingress-lb-ips", nil, "IP Addresses of Cloud Ingress Load Balancers")
+	displayCmd.Flags().Bool("user-managed-lb", false, "The API and ingress go through load balancers of the user, the --cloud-*-lb-ips or else the VIPs, which the DNS records point at")
+	displayCmd.Flags().Bool("offline", false, "Only use local sources, for the first boot of a node before the API answers. The fields that need the API are left empty and listed in .Unresolved")
 	rootCmd.AddCommand(displayCmd)
 }
 
@@ -100,13 +109,54 @@ func runDisplay(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		ingressLBIPs = []net.IP{}
 	}
-	clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs}
+	userManagedLB, err := cmd.Flags().GetBool("user-managed-lb")
+	if err != nil {
+		return err
+	}
+	cfgOpts := config.DefaultOptions()
+	if cfgOpts.Offline, err = cmd.Flags().GetBool("offline"); err != nil {
+		return err
+	}
+	clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs, UserManaged: userManagedLB}
+
+	config, err := config.GetConfig(cfgOpts, kubeCfgPath, clusterConfigPath, resolveConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+	if err != nil {
+		return err
+	}
 
-	config, err := config.GetConfig(kubeCfgPath, clusterConfigPath, resolveConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+	output, err := cmd.Flags().GetString("output")
 	if err != nil {
 		return err
 	}
+	redact, err := cmd.Flags().GetBool("redact")
+	if err != nil {
+		return err
+	}
+	return displayConfig(config.ForDisplay(redact), output)
+}
 
-	spew.Dump(config)
-	return err
+// displayConfig prints the node in the output format. The json fields follow
+// the struct order and the yaml keys are sorted, so the output is stable and
+// the configs of two nodes can be diffed.
+func displayConfig(node config.Node, output string) error {
+	switch output {
+	case "spew":
+		spew.Dump(node)
+		return nil
+	case "json":
+		out, err := json.MarshalIndent(node, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	case "yaml":
+		out, err := yaml.Marshal(node)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return fmt.Errorf("Unknown output format %q", output)
 }
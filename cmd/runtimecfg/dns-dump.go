@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/api"
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dnsDumpCmd = &cobra.Command{
+		Use: `dns-dump [path to kubeconfig]
+			It prints the DNS records runtimecfg renders, in the hosts file format`,
+		Short: "Prints the api, api-int, *.apps, node and PTR records the node-local DNS server is expected to serve",
+		RunE:  runDNSDump,
+	}
+)
+
+func init() {
+	dnsDumpCmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
+	dnsDumpCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
+	dnsDumpCmd.Flags().IPSlice("ingress-vips", nil, "Virtual IP Addresses to reach the OpenShift Ingress Routers")
+	dnsDumpCmd.Flags().StringP("resolvconf-path", "r", "/etc/resolv.conf", "Optional path to a resolv.conf file to use to get upstream DNS servers")
+	dnsDumpCmd.Flags().IPSlice("cloud-ext-lb-ips", nil, "IP Addresses of Cloud External Load Balancers for OpenShift API")
+	dnsDumpCmd.Flags().IPSlice("cloud-int-lb-ips", nil, "IP Addresses of Cloud Internal Load Balancers for OpenShift API")
+	dnsDumpCmd.Flags().IPSlice("cloud-ingress-lb-ips", nil, "IP Addresses of Cloud Ingress Load Balancers")
+	dnsDumpCmd.Flags().Bool("user-managed-lb", false, "The API and ingress go through load balancers of the user, the --cloud-*-lb-ips or else the VIPs, which the DNS records point at")
+	dnsDumpCmd.Flags().String("dns-view", config.DNSViewInternal, "Clients api.<domain> is resolved for, "+config.DNSViewInternal+" or "+config.DNSViewExternal)
+	dnsDumpCmd.Flags().Bool("ingress-shards", false, "Also print the wildcards of the IngressControllers annotated with "+config.IngressShardVIPsAnnotation)
+	dnsDumpCmd.Flags().String("out", "", "File the records are written to instead of the standard output")
+	rootCmd.AddCommand(dnsDumpCmd)
+}
+
+func runDNSDump(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+	var err error
+	builder := api.RuntimeConfigBuilder{KubeconfigPath: kubeCfgPath}
+	if builder.ClusterConfigPath, err = cmd.Flags().GetString("cluster-config"); err != nil {
+		return err
+	}
+	if builder.APIVIPs, err = cmd.Flags().GetIPSlice("api-vips"); err != nil {
+		return err
+	}
+	if builder.IngressVIPs, err = cmd.Flags().GetIPSlice("ingress-vips"); err != nil {
+		return err
+	}
+	if builder.ResolvConfPath, err = cmd.Flags().GetString("resolvconf-path"); err != nil {
+		return err
+	}
+	if builder.CloudLB.ApiLBIPs, err = cmd.Flags().GetIPSlice("cloud-ext-lb-ips"); err != nil {
+		return err
+	}
+	if builder.CloudLB.ApiIntLBIPs, err = cmd.Flags().GetIPSlice("cloud-int-lb-ips"); err != nil {
+		return err
+	}
+	if builder.CloudLB.IngressLBIPs, err = cmd.Flags().GetIPSlice("cloud-ingress-lb-ips"); err != nil {
+		return err
+	}
+	if builder.CloudLB.UserManaged, err = cmd.Flags().GetBool("user-managed-lb"); err != nil {
+		return err
+	}
+	view, err := cmd.Flags().GetString("dns-view")
+	if err != nil {
+		return err
+	}
+	shards, err := cmd.Flags().GetBool("ingress-shards")
+	if err != nil {
+		return err
+	}
+	outPath, err := cmd.Flags().GetString("out")
+	if err != nil {
+		return err
+	}
+
+	// The config is populated as the coredns monitor does before rendering
+	// the Corefile
+	node, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if !node.UserManagedLB {
+		if node, err = config.PopulateCloudLBIPAddresses(builder.CloudLB, node); err != nil {
+			return err
+		}
+	}
+	if err := config.PopulateAPIResolution(&node, view); err != nil {
+		return err
+	}
+	if shards {
+		if err := config.PopulateIngressShards(config.DefaultOptions(), kubeCfgPath, &node); err != nil {
+			return err
+		}
+	}
+	config.PopulateNodeAddresses(kubeCfgPath, &node)
+	if len(node.Cluster.NodeAddresses) == 0 {
+		return fmt.Errorf("Failed to list the nodes of the cluster")
+	}
+	config.PopulateReverseZones(&node)
+
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	fmt.Fprintf(out, "# DNS records of %s for the %s view\n", node.Cluster.Domain, view)
+	return config.WriteHosts(out, config.DNSRecords(node))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/verify"
+	"github.com/spf13/cobra"
+)
+
+var (
+	failoverTestCmd = &cobra.Command{
+		Use: `failover-test --vip <ip>
+			It stops the local keepalived and checks that another node takes the VIP over`,
+		Short: "Simulates a failover of a VIP held by this node and prints a pass/fail report",
+		Long: `Stops the local keepalived through its control socket, so that it gives the VIP up,
+checks that another node answers ARP or NDP for the VIP and that the API answers through it,
+then starts keepalived again. It must run as root on the node holding the VIP.`,
+		RunE: runFailoverTest,
+		// A failed test is already explained by the report
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	failoverTestCmd.Flags().IP("vip", nil, "VIP held by this node to move to another node")
+	failoverTestCmd.Flags().String("control-socket", keepalivedSock, "Control socket of the local keepalived")
+	failoverTestCmd.Flags().Uint16("api-port", 6443, "Port where the API is probed through the VIP, 0 to skip the probe")
+	failoverTestCmd.Flags().Duration("timeout", time.Minute, "How long the VIP may take to move and the API to answer through it")
+	failoverTestCmd.Flags().StringP("output", "o", "text", "Report format, one of text or json")
+	failoverTestCmd.MarkFlagRequired("vip")
+	rootCmd.AddCommand(failoverTestCmd)
+}
+
+func runFailoverTest(cmd *cobra.Command, args []string) error {
+	vip, err := cmd.Flags().GetIP("vip")
+	if err != nil {
+		return err
+	}
+	controlSocket, err := cmd.Flags().GetString("control-socket")
+	if err != nil {
+		return err
+	}
+	apiPort, err := cmd.Flags().GetUint16("api-port")
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if output != "text" && output != "json" {
+		return fmt.Errorf("Unknown output format %q", output)
+	}
+	// Writing to the control socket and probing ARP/NDP with raw sockets
+	// both need root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("failover-test must run as root")
+	}
+
+	report := verify.Failover(verify.FailoverOptions{
+		VIP:           vip,
+		ControlSocket: controlSocket,
+		APIPort:       apiPort,
+		Timeout:       timeout,
+	})
+	if output == "json" {
+		var out []byte
+		out, err = json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			fmt.Println(string(out))
+		}
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.Passed {
+		return fmt.Errorf("Failover test failed")
+	}
+	return nil
+}
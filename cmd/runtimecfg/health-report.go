@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
+)
+
+var (
+	healthReportCmd = &cobra.Command{
+		Use: `health-report [path to kubeconfig]
+			It aggregates the health the monitors publish on their node into ClusterOperator conditions`,
+		Short: "Reports the on-prem networking health of the nodes as ClusterOperator conditions",
+		RunE:  runHealthReport,
+	}
+)
+
+func init() {
+	healthReportCmd.Flags().String("cluster-operator", "on-prem-networking", "Name of the ClusterOperator whose conditions are set")
+	healthReportCmd.Flags().Duration("interval", time.Minute, "Time between updates of the conditions")
+	healthReportCmd.Flags().Duration("stale-after", 5*time.Minute, "How long a node can go without publishing the health of a component before it counts as degraded")
+	healthReportCmd.Flags().String("metrics-address", "", "Address (e.g. :29448) where the reconcile and per-node /metrics are served. Disabled when empty")
+	healthReportCmd.Flags().Bool("once", false, "Update the conditions once and exit")
+	rootCmd.AddCommand(healthReportCmd)
+}
+
+func runHealthReport(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+	name, err := cmd.Flags().GetString("cluster-operator")
+	if err != nil {
+		return err
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	staleAfter, err := cmd.Flags().GetDuration("stale-after")
+	if err != nil {
+		return err
+	}
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		return err
+	}
+	if once {
+		return health.UpdateClusterOperator(kubeCfgPath, name, staleAfter)
+	}
+	metricsAddr, err := cmd.Flags().GetString("metrics-address")
+	if err != nil {
+		return err
+	}
+	serveMetrics(metricsAddr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := reconcile("health-report", func() error {
+			return health.UpdateClusterOperator(kubeCfgPath, name, staleAfter)
+		}); err != nil {
+			log.WithFields(logrus.Fields{
+				"clusterOperator": name,
+			}).WithError(err).Error("Failed to update the ClusterOperator conditions")
+		}
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
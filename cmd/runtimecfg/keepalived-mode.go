@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/modemigration"
+)
+
+var (
+	keepalivedModeCmd = &cobra.Command{
+		Use: `keepalived-mode unicast|multicast [path to kubeconfig]
+			It switches the keepalived of every node to the mode once all their monitors acknowledged it`,
+		Short:     "Coordinates the switch of keepalived between unicast and multicast",
+		ValidArgs: []string{modemigration.ModeUnicast, modemigration.ModeMulticast},
+		Args:      cobra.RangeArgs(1, 2),
+		RunE:      runKeepalivedMode,
+	}
+)
+
+func init() {
+	keepalivedModeCmd.Flags().StringP("namespace", "n", os.Getenv("POD_NAMESPACE"), "Namespace of the keepalived monitors")
+	keepalivedModeCmd.Flags().String("node-selector", "", "Label selector of the nodes running keepalived, every node when empty")
+	keepalivedModeCmd.Flags().Duration("timeout", 10*time.Minute, "How long to wait for the nodes to acknowledge and then to apply the mode")
+	keepalivedModeCmd.Flags().Duration("poll-interval", 5*time.Second, "Time between checks of the nodes")
+	rootCmd.AddCommand(keepalivedModeCmd)
+}
+
+func runKeepalivedMode(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 1 {
+		kubeCfgPath = args[1]
+	}
+	namespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return err
+	}
+	nodeSelector, err := cmd.Flags().GetString("node-selector")
+	if err != nil {
+		return err
+	}
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	poll, err := cmd.Flags().GetDuration("poll-interval")
+	if err != nil {
+		return err
+	}
+	return modemigration.Migrate(kubeCfgPath, namespace, args[0], selector, timeout, poll)
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/cmddoc"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitorcmd"
 )
 
 var (
@@ -15,6 +18,15 @@ var (
 	log = logrus.New()
 )
 
+func init() {
+	rootCmd.AddCommand(
+		cmddoc.NewCompletionCommand(),
+		cmddoc.NewDocsCommand(func() []*cobra.Command {
+			return append([]*cobra.Command{rootCmd}, monitorcmd.Binaries()...)
+		}),
+	)
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalf("Error executing runtimecfg: %v", err)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_reconcile_total",
+		Help: "Number of reconciles of the long running runtimecfg commands by result",
+	}, []string{"controller", "result"})
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "baremetal_runtimecfg_reconcile_duration_seconds",
+		Help:    "Duration of the reconciles of the long running runtimecfg commands",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller"})
+)
+
+func init() {
+	prometheus.MustRegister(reconcileTotal, reconcileDuration)
+}
+
+// reconcile runs f and records its result and duration for controller
+func reconcile(controller string, f func() error) error {
+	start := time.Now()
+	err := f()
+	reconcileDuration.WithLabelValues(controller).Observe(time.Since(start).Seconds())
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconcileTotal.WithLabelValues(controller, result).Inc()
+	return err
+}
+
+// serveMetrics serves /metrics on addr, for a ServiceMonitor to scrape. An
+// empty addr disables it.
+func serveMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.WithFields(logrus.Fields{
+			"address": addr,
+		}).Info("Serving metrics endpoint")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.WithFields(logrus.Fields{
+				"address": addr,
+			}).WithError(err).Error("Metrics endpoint stopped")
+		}
+	}()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/openshift/baremetal-runtimecfg/pkg/api"
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
 	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 )
@@ -25,7 +27,7 @@ const (
 	nodeIpNotMatchesVipsFile    = "/run/nodeip-configuration/remote-worker"
 	crioSvcOverridePath         = "/etc/systemd/system/crio.service.d/20-nodenet.conf"
 	remoteWorkerLabel           = "node.openshift.io/remote-worker"
-	ovn                         = "OVNKubernetes"
+	ovn                         = api.NetworkTypeOVNKubernetes
 	maxSecondsToSuitableIPsLoop = 300 // 5 minutes
 	addSecondsToSuitableIPsLoop = 2
 )
@@ -69,6 +71,11 @@ var params struct {
 	userManagedLB bool
 	networkType   string
 	platform      string
+	excluded      []string
+	ovnSubnets    []string
+	networkConfig string
+	probeAddress  string
+	probeTimeout  time.Duration
 }
 
 // init executes upon import
@@ -80,16 +87,43 @@ func init() {
 	nodeIPCmd.PersistentFlags().StringVarP(&params.networkType, "network-type", "n", ovn, "CNI network type")
 	nodeIPCmd.PersistentFlags().BoolVarP(&params.userManagedLB, "user-managed-lb", "l", false, "User managed load balancer")
 	nodeIPCmd.PersistentFlags().StringVarP(&params.platform, "platform", "p", "", "Cluster platform")
+	nodeIPCmd.PersistentFlags().StringSliceVar(&params.excluded, "exclude-devices", nil, "Glob patterns of the devices whose addresses are never chosen, on top of the OVN and pod interfaces. A driver: prefix matches the device driver, e.g. driver:iavf")
+	nodeIPCmd.PersistentFlags().StringSliceVar(&params.ovnSubnets, "ovn-internal-subnets", nil, "OVN masquerade subnets whose addresses are never chosen with OVNKubernetes. Read from --network-config or defaulted to "+strings.Join(utils.DefaultOVNInternalSubnets(), ",")+" when empty")
+	nodeIPCmd.PersistentFlags().StringVar(&params.networkConfig, "network-config", "", "Path of the networks.operator.openshift.io manifest the OVN masquerade subnets are read from")
+	nodeIPCmd.PersistentFlags().StringVar(&params.probeAddress, "api-probe-address", "", "host:port of the API (e.g. api-int.<cluster>.<domain>:6443) a TCP connection from the chosen node IP must reach, rejecting an address on an isolated network. Not probed when empty")
+	nodeIPCmd.PersistentFlags().DurationVar(&params.probeTimeout, "api-probe-timeout", api.DefaultProbeTimeout, "How long the API probe of the chosen node IP waits for the connection")
 	rootCmd.AddCommand(nodeIPCmd)
 }
 
+func setAddressOptions() error {
+	if err := utils.ValidateExcludedDevices(params.excluded); err != nil {
+		return err
+	}
+
+	if len(params.ovnSubnets) == 0 && params.networkConfig != "" {
+		var err error
+		if params.ovnSubnets, err = config.GetOVNInternalSubnets(params.networkConfig); err != nil {
+			return fmt.Errorf("Failed to read the OVN internal subnets from %s: %w", params.networkConfig, err)
+		}
+	}
+	if len(params.ovnSubnets) > 0 {
+		log.Infof("Ignoring the addresses within the OVN internal subnets %v", params.ovnSubnets)
+		_, err := utils.OVNNodeAddressFilter(params.ovnSubnets)
+		return err
+	}
+	return nil
+}
+
 func show(cmd *cobra.Command, args []string) error {
+	if err := setAddressOptions(); err != nil {
+		return err
+	}
 	vips, err := parseIPs(args)
 	if err != nil {
 		return err
 	}
 
-	chosenAddresses, _, err := getSuitableIPs(params.retry, vips, params.preferIPv6, params.networkType)
+	chosenAddresses, _, err := getSuitableIPs(params.retry, vips)
 	if err != nil {
 		return err
 	}
@@ -109,12 +143,15 @@ func set(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if err := setAddressOptions(); err != nil {
+		return err
+	}
 	vips, err := parseIPs(args)
 	if err != nil {
 		return err
 	}
 
-	chosenAddresses, matchesVips, err := getSuitableIPs(params.retry, vips, params.preferIPv6, params.networkType)
+	chosenAddresses, matchesVips, err := getSuitableIPs(params.retry, vips)
 	if err != nil {
 		return err
 	}
@@ -205,67 +242,34 @@ func writeToFile(path string, data string) error {
 	return nil
 }
 
-func checkAddressUsable(chosen []net.IP) (err error) {
-	// If using IPv6, verify that the choosen address isn't tentative
-	// i.e. we can actually bind to it
-	if len(chosen) > 0 && net.IPv6len == len(chosen[0]) {
-		_, err = net.Listen("tcp", "["+chosen[0].String()+"]:")
-		if err != nil {
-			log.Errorf("Chosen node IP is not usable")
-			return err
-		}
-	}
-	return err
-}
-
-func getSuitableIPs(retry bool, vips []net.IP, preferIPv6 bool, networkType string) (chosen []net.IP, matchesVips bool, err error) {
+func getSuitableIPs(retry bool, vips []net.IP) (chosen []net.IP, matchesVips bool, err error) {
 	// timerLoop will hold a time in Seconds to be used with time.Sleep() before going
 	// for the next loop interation.
 	timerLoop := 1
 
-	ipFilterFunc := utils.ValidNodeAddress
+	selection := api.NodeIPSelection{
+		VIPs:               vips,
+		PreferIPv6:         params.preferIPv6,
+		NetworkType:        params.networkType,
+		ProbeAddress:       params.probeAddress,
+		ProbeTimeout:       params.probeTimeout,
+		ExcludedDevices:    params.excluded,
+		OVNInternalSubnets: params.ovnSubnets,
+	}
 	for {
 		timerLoop = timerLoop * addSecondsToSuitableIPsLoop
-		if len(vips) > 0 {
-			chosen, err = utils.AddressesRouting(vips, ipFilterFunc, preferIPv6)
-			if len(chosen) > 0 || err != nil {
-				if err == nil {
-					err = checkAddressUsable(chosen)
-				}
-				if err != nil {
-					if !retry {
-						return nil, false, fmt.Errorf("Failed to find node IP")
-					}
-					time.Sleep(time.Second)
-					continue
-				}
-				return chosen, true, err
-			}
-		}
-		if len(chosen) == 0 {
-			// we should check ovn specific in case vips were not set
-			if networkType == ovn {
-				ipFilterFunc = utils.ValidOVNNodeAddress
-			}
-			chosen, err = utils.AddressesDefault(preferIPv6, ipFilterFunc)
-			if len(chosen) > 0 || err != nil {
-				if err == nil {
-					err = checkAddressUsable(chosen)
-				}
-				if err != nil {
-					if !retry {
-						return nil, false, fmt.Errorf("Failed to find node IP")
-					}
-					chosen = []net.IP{}
-					time.Sleep(time.Second)
-					continue
-				}
-				return chosen, false, err
-			}
+		chosen, matchesVips, err = selection.Select()
+		if err == nil {
+			return chosen, matchesVips, nil
 		}
 		if !retry {
 			return nil, false, fmt.Errorf("Failed to find node IP")
 		}
+		if !errors.Is(err, api.ErrNoNodeIP) {
+			log.Errorf("Chosen node IP is not usable: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
 
 		log.Errorf("Failed to find a suitable node IP")
 		if timerLoop >= maxSecondsToSuitableIPsLoop {
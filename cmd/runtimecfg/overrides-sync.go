@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/overrides"
+)
+
+var (
+	overridesSyncCmd = &cobra.Command{
+		Use: `overrides-sync [path to kubeconfig]
+			It writes the overrides of the RuntimeNetConfig objects into the per-node ConfigMaps the monitors read`,
+		Short: "Syncs the RuntimeNetConfig node overrides into per-node ConfigMaps",
+		RunE:  runOverridesSync,
+	}
+)
+
+func init() {
+	overridesSyncCmd.Flags().StringP("namespace", "n", os.Getenv("POD_NAMESPACE"), "Namespace of the RuntimeNetConfig objects and of the ConfigMaps, the one of the monitors")
+	overridesSyncCmd.Flags().Duration("interval", 30*time.Second, "Time between syncs")
+	overridesSyncCmd.Flags().String("metrics-address", "", "Address (e.g. :29449) where the reconcile /metrics are served. Disabled when empty")
+	overridesSyncCmd.Flags().Bool("once", false, "Sync once and exit")
+	rootCmd.AddCommand(overridesSyncCmd)
+}
+
+func runOverridesSync(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+	namespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return err
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		return err
+	}
+	if once {
+		return overrides.Sync(kubeCfgPath, namespace)
+	}
+	metricsAddr, err := cmd.Flags().GetString("metrics-address")
+	if err != nil {
+		return err
+	}
+	serveMetrics(metricsAddr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := reconcile("overrides-sync", func() error {
+			return overrides.Sync(kubeCfgPath, namespace)
+		}); err != nil {
+			log.WithFields(logrus.Fields{
+				"namespace": namespace,
+			}).WithError(err).Error("Failed to sync the node overrides")
+		}
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
 	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/vishvananda/netlink"
+	"gopkg.in/fsnotify.v1"
 )
 
 var (
@@ -24,6 +33,15 @@ func init() {
 
 	renderCmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
 	renderCmd.Flags().Bool("verbose", false, "Display extra information about the rendering")
+	renderCmd.Flags().StringSlice("include", nil, "Only render the templates whose rendered file name matches one of these globs")
+	renderCmd.Flags().StringSlice("exclude", nil, "Skip the templates whose rendered file name matches one of these globs")
+	renderCmd.Flags().String("mode", "", "Octal mode of the rendered files, the mode of their template by default")
+	renderCmd.Flags().String("owner", "", "Owner of the rendered files as user[:group], by name or numeric ID")
+	renderCmd.Flags().Bool("atomic", false, "Write each rendered file to a temporary file and rename it into place")
+	renderCmd.Flags().Bool("watch", false, "Keep running and render the templates again whenever the runtime configuration changes")
+	renderCmd.Flags().Duration("watch-interval", 30*time.Second, "How often the runtime configuration is polled in watch mode")
+	renderCmd.Flags().StringToString("secret-file", nil, "Secret values used by the templates as .Secrets.<name>, given as name=path of the file holding the value, e.g. of a mounted Secret. The rendered files containing one are only readable by their owner. Can be repeated")
+	renderCmd.Flags().Bool("strict", false, "Fail when a template uses missing data or a rendered keepalived, haproxy, Corefile or dnsmasq file does not validate")
 	renderCmd.Flags().IP("api-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift API")
 	renderCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
 	renderCmd.Flags().IP("ingress-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift Ingress Routers")
@@ -32,10 +50,16 @@ func init() {
 	renderCmd.Flags().Uint16("api-port", 6443, "Port where the OpenShift API listens at")
 	renderCmd.Flags().Uint16("lb-port", 9445, "Port where the API HAProxy LB will listen at")
 	renderCmd.Flags().Uint16("stat-port", 29445, "Port where the HAProxy stats API will listen at")
+	renderCmd.Flags().StringArray("extra-api-port", nil, "Port the API is also reached on through the API VIPs, as port:lbport where lbport is the port HAProxy listens on for it, e.g. 443:9443. Rendered as .LBConfig.Frontends. Can be repeated")
 	renderCmd.Flags().StringP("resolvconf-path", "r", "/etc/resolv.conf", "Optional path to a resolv.conf file to use to get upstream DNS servers")
 	renderCmd.Flags().IPSlice("cloud-ext-lb-ips", nil, "IP Addresses of Cloud External Load Balancers for OpenShift API")
 	renderCmd.Flags().IPSlice("cloud-int-lb-ips", nil, "IP Addresses of Cloud Internal Load Balancers for OpenShift Internal API")
 	renderCmd.Flags().IPSlice("cloud-ingress-lb-ips", nil, "IP Addresses of Cloud Ingress Load Balancers")
+	renderCmd.Flags().Bool("user-managed-lb", false, "The API and ingress go through load balancers of the user, the --cloud-*-lb-ips or else the VIPs, which the DNS records point at")
+	renderCmd.Flags().Bool("offline", false, "Only use local sources, for the first boot of a node before the API answers. The fields that need the API are left empty and listed in .Unresolved")
+	renderCmd.Flags().Int("vrrp-priority-base", config.DefaultOptions().Priorities.Base, "keepalived priority of the nodes before their role bonus and hostname offset, must match the keepalived monitor")
+	renderCmd.Flags().Int("vrrp-priority-spread", config.DefaultOptions().Priorities.Spread, "Number of offsets the hash of the hostname of a node spreads the priorities over, must match the keepalived monitor")
+	renderCmd.Flags().StringArray("vrrp-priority-role-bonus", nil, "Priority bonus of the nodes with a node-role.kubernetes.io/<role> label as role=bonus, must match the keepalived monitor. Can be repeated")
 	rootCmd.AddCommand(renderCmd)
 }
 
@@ -83,6 +107,17 @@ func runRender(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	extraAPIPorts, err := cmd.Flags().GetStringArray("extra-api-port")
+	if err != nil {
+		return err
+	}
+	cfgOpts := config.DefaultOptions()
+	if cfgOpts.ExtraAPIPorts, err = config.ParseAPIPorts(extraAPIPorts); err != nil {
+		return err
+	}
+	if err := config.ValidateAPIPorts(config.APIPorts(apiPort, lbPort, cfgOpts.ExtraAPIPorts)); err != nil {
+		return err
+	}
 	clusterConfigPath, err := cmd.Flags().GetString("cluster-config")
 	if err != nil {
 		return err
@@ -104,11 +139,44 @@ func runRender(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		ingressLBIPs = []net.IP{}
 	}
-	clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs}
-	config, err := config.GetConfig(kubeCfgPath, clusterConfigPath, resolveConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+	userManagedLB, err := cmd.Flags().GetBool("user-managed-lb")
+	if err != nil {
+		return err
+	}
+	priorities := config.PriorityOptions{}
+	if priorities.Base, err = cmd.Flags().GetInt("vrrp-priority-base"); err != nil {
+		return err
+	}
+	if priorities.Spread, err = cmd.Flags().GetInt("vrrp-priority-spread"); err != nil {
+		return err
+	}
+	roleBonuses, err := cmd.Flags().GetStringArray("vrrp-priority-role-bonus")
+	if err != nil {
+		return err
+	}
+	if priorities.RoleBonus, err = config.ParsePriorityRoleBonuses(roleBonuses); err != nil {
+		return err
+	}
+	if err := priorities.Validate(); err != nil {
+		return err
+	}
+	cfgOpts.Priorities = priorities
+	if cfgOpts.Offline, err = cmd.Flags().GetBool("offline"); err != nil {
+		return err
+	}
+	clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs, UserManaged: userManagedLB}
+	secretFiles, err := cmd.Flags().GetStringToString("secret-file")
 	if err != nil {
 		return err
 	}
+	getConfig := func() (interface{}, error) {
+		node, err := config.GetConfig(cfgOpts, kubeCfgPath, clusterConfigPath, resolveConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+		if err != nil {
+			return node, err
+		}
+		node.Secrets, err = render.ReadSecrets(secretFiles)
+		return node, err
+	}
 
 	outDir, err := cmd.Flags().GetString("out-dir")
 	if outDir == "" {
@@ -122,5 +190,131 @@ func runRender(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	return render.Render(outDir, args[1:], config)
+	opts, err := getRenderOptions(cmd)
+	if err != nil {
+		return err
+	}
+	watch, err := cmd.Flags().GetBool("watch")
+	if err != nil {
+		return err
+	}
+	if watch {
+		interval, err := cmd.Flags().GetDuration("watch-interval")
+		if err != nil {
+			return err
+		}
+		return watchAndRender(outDir, args[1:], opts, getConfig, interval, resolveConfPath)
+	}
+
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	return render.RenderWithOptions(outDir, args[1:], cfg, opts)
+}
+
+// watchAndRender renders the templates whenever the config changes until
+// the process is told to stop. The config is polled every interval, which
+// picks up the changes of the cluster nodes, and right away when the
+// addresses of the host or resolvConfPath change.
+func watchAndRender(outDir string, paths []string, opts render.Options, getConfig func() (interface{}, error), interval time.Duration, resolvConfPath string) error {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		close(done)
+	}()
+
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	addrUpdates := make(chan netlink.AddrUpdate)
+	if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
+		return err
+	}
+	go func() {
+		for range addrUpdates {
+			notify()
+		}
+	}()
+
+	// resolv.conf is often replaced rather than written, so its directory
+	// is watched
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(resolvConfPath)); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(resolvConfPath) {
+					notify()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warn("resolv.conf watcher error")
+			}
+		}
+	}()
+
+	log.WithFields(logrus.Fields{
+		"outDir":   outDir,
+		"interval": interval,
+	}).Info("Watching the config to render the templates")
+	render.Watch(outDir, paths, opts, getConfig, interval, trigger, done)
+	return nil
+}
+
+func getRenderOptions(cmd *cobra.Command) (render.Options, error) {
+	opts := render.Options{}
+	var err error
+	if opts.Strict, err = cmd.Flags().GetBool("strict"); err != nil {
+		return opts, err
+	}
+	if opts.Atomic, err = cmd.Flags().GetBool("atomic"); err != nil {
+		return opts, err
+	}
+	if opts.Include, err = cmd.Flags().GetStringSlice("include"); err != nil {
+		return opts, err
+	}
+	if opts.Exclude, err = cmd.Flags().GetStringSlice("exclude"); err != nil {
+		return opts, err
+	}
+	mode, err := cmd.Flags().GetString("mode")
+	if err != nil {
+		return opts, err
+	}
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > uint64(os.ModePerm) {
+			return opts, fmt.Errorf("Invalid file mode %q", mode)
+		}
+		opts.Mode = os.FileMode(perm)
+	}
+	owner, err := cmd.Flags().GetString("owner")
+	if err != nil {
+		return opts, err
+	}
+	if owner != "" {
+		if opts.Owner, err = render.ParseOwner(owner); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+var (
+	rollbackCmd = &cobra.Command{
+		Use: `rollback <rendered file> [-n 1]
+			It restores a previous generation of a rendered file kept with --keep-generations and reloads its service`,
+		Short: "Restores a previous version of a rendered file and reloads keepalived or HAProxy",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRollback,
+	}
+)
+
+func init() {
+	rollbackCmd.Flags().IntP("generation", "n", 1, "Generation to restore, 1 for the latest previous version")
+	rollbackCmd.Flags().Bool("list", false, "Only list the kept generations with when they were rendered and their generation ID")
+	rollbackCmd.Flags().String("service", "auto", "Service reloaded after the rollback: keepalived, haproxy, none, or auto to pick it from the file name")
+	rollbackCmd.Flags().String("control", monitor.ServiceControlSocket, "How the service is reloaded: socket through the control socket of its container, systemd with systemctl for a host service, or pidfile by signaling the process of --pid-file")
+	rollbackCmd.Flags().String("unit", "", "Systemd unit of the service, with --control systemd, <service>.service when empty")
+	rollbackCmd.Flags().String("pid-file", "", "Pid file of the service, with --control pidfile")
+	rollbackCmd.Flags().Duration("timeout", 30*time.Second, "How long the reload can take")
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+// The monitors render the file again on their next change, or right away
+// when they render drifted files again, so a rollback is a mitigation until
+// the configuration is fixed.
+
+// serviceOf returns the service reloaded for the rendered file renderPath,
+// empty when its service picks up changes on its own, like CoreDNS
+func serviceOf(renderPath string) string {
+	base := filepath.Base(renderPath)
+	switch {
+	case strings.HasPrefix(base, "keepalived"):
+		return "keepalived"
+	case strings.HasPrefix(base, "haproxy"):
+		return "haproxy"
+	}
+	return ""
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	renderPath := args[0]
+	list, err := cmd.Flags().GetBool("list")
+	if err != nil {
+		return err
+	}
+	if list {
+		generations, err := render.ListGenerations(renderPath)
+		if err != nil {
+			return err
+		}
+		if len(generations) == 0 {
+			fmt.Printf("No generation of %s is kept\n", renderPath)
+		}
+		for _, g := range generations {
+			fmt.Printf("%d\t%s\t%s\t%s\n", g.N, g.Time.Format(time.RFC3339), g.ID, g.Path)
+		}
+		return nil
+	}
+
+	n, err := cmd.Flags().GetInt("generation")
+	if err != nil {
+		return err
+	}
+	service, err := cmd.Flags().GetString("service")
+	if err != nil {
+		return err
+	}
+	if service == "auto" {
+		service = serviceOf(renderPath)
+	}
+	opts := monitor.ServiceControlOptions{}
+	if opts.Mechanism, err = cmd.Flags().GetString("control"); err != nil {
+		return err
+	}
+	if opts.Unit, err = cmd.Flags().GetString("unit"); err != nil {
+		return err
+	}
+	if opts.Unit == "" {
+		opts.Unit = service + ".service"
+	}
+	if opts.PidFile, err = cmd.Flags().GetString("pid-file"); err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	switch service {
+	case "keepalived", "haproxy":
+	case "", "none":
+		service = ""
+	default:
+		return fmt.Errorf("Unknown service %s, must be keepalived, haproxy, none or auto", service)
+	}
+	if service != "" {
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := render.Rollback(renderPath, n); err != nil {
+		return err
+	}
+	if service == "" {
+		fmt.Printf("Rolled back %s to generation %d\n", renderPath, n)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := monitor.ReloadService(ctx, service, opts); err != nil {
+		return fmt.Errorf("Rolled back %s to generation %d but failed to reload %s: %w", renderPath, n, service, err)
+	}
+	fmt.Printf("Rolled back %s to generation %d and reloaded %s\n", renderPath, n, service)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/api"
+	"github.com/openshift/baremetal-runtimecfg/pkg/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotCmd = &cobra.Command{
+		Use: `snapshot [path to kubeconfig] --out <dir>
+			It gathers the runtime configuration of the node for a bug report`,
+		Short: "Gathers the rendered configurations, the runtime configuration, the addresses, routes and firewall rules, and the monitor state files",
+		RunE:  runSnapshot,
+	}
+)
+
+func init() {
+	snapshotCmd.Flags().String("out", "", "Directory where the snapshot is gathered")
+	snapshotCmd.Flags().Bool("archive", true, "Also package the directory as <out>.tar.gz")
+	snapshotCmd.Flags().StringSlice("files", snapshot.DefaultFiles, "Globs of the rendered configurations and state files to copy")
+	snapshotCmd.Flags().Bool("redact", false, "Replace the values of the runtime configuration that identify the site, like the cluster domain and MAC addresses")
+	snapshotCmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
+	snapshotCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
+	snapshotCmd.Flags().IPSlice("ingress-vips", nil, "Virtual IP Addresses to reach the OpenShift Ingress Routers")
+	snapshotCmd.Flags().Uint16("api-port", 6443, "Port where the OpenShift API listens at")
+	snapshotCmd.Flags().Uint16("lb-port", 9445, "Port where the API HAProxy LB will listen at")
+	snapshotCmd.Flags().Uint16("stat-port", 29445, "Port where the HAProxy stats API will listen at")
+	snapshotCmd.Flags().StringP("resolvconf-path", "r", "/etc/resolv.conf", "Optional path to a resolv.conf file to use to get upstream DNS servers")
+	snapshotCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(snapshotCmd)
+}
+
+func runSnapshot(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+	out, err := cmd.Flags().GetString("out")
+	if err != nil {
+		return err
+	}
+	archive, err := cmd.Flags().GetBool("archive")
+	if err != nil {
+		return err
+	}
+	files, err := cmd.Flags().GetStringSlice("files")
+	if err != nil {
+		return err
+	}
+	redact, err := cmd.Flags().GetBool("redact")
+	if err != nil {
+		return err
+	}
+	builder := api.RuntimeConfigBuilder{KubeconfigPath: kubeCfgPath}
+	if builder.ClusterConfigPath, err = cmd.Flags().GetString("cluster-config"); err != nil {
+		return err
+	}
+	if builder.APIVIPs, err = cmd.Flags().GetIPSlice("api-vips"); err != nil {
+		return err
+	}
+	if builder.IngressVIPs, err = cmd.Flags().GetIPSlice("ingress-vips"); err != nil {
+		return err
+	}
+	if builder.APIPort, err = cmd.Flags().GetUint16("api-port"); err != nil {
+		return err
+	}
+	if builder.LBPort, err = cmd.Flags().GetUint16("lb-port"); err != nil {
+		return err
+	}
+	if builder.StatPort, err = cmd.Flags().GetUint16("stat-port"); err != nil {
+		return err
+	}
+	if builder.ResolvConfPath, err = cmd.Flags().GetString("resolvconf-path"); err != nil {
+		return err
+	}
+
+	// A failure to get the config is recorded in the snapshot like any
+	// other failure to gather
+	node, cfgErr := builder.Build()
+	if err := snapshot.Take(out, snapshot.Options{
+		Files:     files,
+		Config:    node,
+		ConfigErr: cfgErr,
+		Redact:    redact,
+	}); err != nil {
+		return err
+	}
+	if !archive {
+		fmt.Printf("Snapshot gathered in %s\n", out)
+		return nil
+	}
+
+	archivePath := filepath.Clean(out) + ".tar.gz"
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := snapshot.Archive(out, f); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot gathered in %s and packaged as %s\n", out, archivePath)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/verify"
+	"github.com/spf13/cobra"
+)
+
+const (
+	keepalivedSock    = "/var/run/keepalived/keepalived.sock"
+	haproxyMasterSock = "/var/run/haproxy/haproxy-master.sock"
+)
+
+var (
+	verifyCmd = &cobra.Command{
+		Use: `verify [path to kubeconfig]
+			It checks the runtime configuration and what it depends on`,
+		Short: "Checks the runtime configuration and prints a pass/fail report",
+		RunE:  runVerify,
+		// A failed verification is already explained by the report
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	verifyCmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
+	verifyCmd.Flags().StringP("output", "o", "text", "Report format, one of text or json")
+	verifyCmd.Flags().StringSlice("sockets", []string{keepalivedSock, haproxyMasterSock}, "Control sockets of the local services that must exist, empty to skip the check")
+	verifyCmd.Flags().Duration("dns-timeout", 2*time.Second, "How long to wait for each DNS upstream to answer")
+	verifyCmd.Flags().IP("api-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift API")
+	verifyCmd.Flags().IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
+	verifyCmd.Flags().IP("ingress-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift Ingress Routers")
+	verifyCmd.Flags().IPSlice("ingress-vips", nil, "Virtual IP Addresses to reach the OpenShift Ingress Routers")
+	verifyCmd.Flags().IP("dns-vip", nil, "DEPRECATED: Virtual IP Address to reach an OpenShift node resolving DNS server")
+	verifyCmd.Flags().Uint16("api-port", 6443, "Port where the OpenShift API listens at")
+	verifyCmd.Flags().Uint16("lb-port", 9445, "Port where the API HAProxy LB will listen at")
+	verifyCmd.Flags().Uint16("stat-port", 29445, "Port where the HAProxy stats API will listen at")
+	verifyCmd.Flags().StringP("resolvconf-path", "r", "/etc/resolv.conf", "Optional path to a resolv.conf file to use to get upstream DNS servers")
+	verifyCmd.Flags().IPSlice("cloud-ext-lb-ips", nil, "IP Addresses of Cloud External Load Balancers for OpenShift API")
+	verifyCmd.Flags().IPSlice("cloud-int-lb-ips", nil, "IP Addresses of Cloud Internal Load Balancers for OpenShift API")
+	verifyCmd.Flags().IPSlice("cloud-ingress-lb-ips", nil, "IP Addresses of Cloud Ingress Load Balancers")
+	verifyCmd.Flags().Bool("user-managed-lb", false, "The API and ingress go through load balancers of the user, the --cloud-*-lb-ips or else the VIPs, which the DNS records point at")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	kubeCfgPath := "./kubeconfig"
+	if len(args) > 0 {
+		kubeCfgPath = args[0]
+	}
+
+	apiVip, err := cmd.Flags().GetIP("api-vip")
+	if err != nil {
+		apiVip = nil
+	}
+	apiVips, err := cmd.Flags().GetIPSlice("api-vips")
+	if err != nil {
+		apiVips = []net.IP{}
+	}
+	// If we were passed a VIP using the old interface, coerce it into the list
+	// format that the rest of the code now expects.
+	if len(apiVips) < 1 && apiVip != nil {
+		apiVips = []net.IP{apiVip}
+	}
+	ingressVip, err := cmd.Flags().GetIP("ingress-vip")
+	if err != nil {
+		ingressVip = nil
+	}
+	ingressVips, err := cmd.Flags().GetIPSlice("ingress-vips")
+	if err != nil {
+		ingressVips = []net.IP{}
+	}
+	// If we were passed a VIP using the old interface, coerce it into the list
+	// format that the rest of the code now expects.
+	if len(ingressVips) < 1 && ingressVip != nil {
+		ingressVips = []net.IP{ingressVip}
+	}
+	apiPort, err := cmd.Flags().GetUint16("api-port")
+	if err != nil {
+		return err
+	}
+	lbPort, err := cmd.Flags().GetUint16("lb-port")
+	if err != nil {
+		return err
+	}
+	statPort, err := cmd.Flags().GetUint16("stat-port")
+	if err != nil {
+		return err
+	}
+	clusterConfigPath, err := cmd.Flags().GetString("cluster-config")
+	if err != nil {
+		return err
+	}
+
+	resolveConfPath, err := cmd.Flags().GetString("resolvconf-path")
+	if err != nil {
+		return err
+	}
+
+	apiLBIPs, err := cmd.Flags().GetIPSlice("cloud-ext-lb-ips")
+	if err != nil {
+		apiLBIPs = []net.IP{}
+	}
+	apiIntLBIPs, err := cmd.Flags().GetIPSlice("cloud-int-lb-ips")
+	if err != nil {
+		apiIntLBIPs = []net.IP{}
+	}
+	ingressLBIPs, err := cmd.Flags().GetIPSlice("cloud-ingress-lb-ips")
+	if err != nil {
+		ingressLBIPs = []net.IP{}
+	}
+	userManagedLB, err := cmd.Flags().GetBool("user-managed-lb")
+	if err != nil {
+		return err
+	}
+	clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs, UserManaged: userManagedLB}
+
+	// A failure to get the config is reported like the failure of any
+	// other check
+	config, cfgErr := config.GetConfig(config.DefaultOptions(), kubeCfgPath, clusterConfigPath, resolveConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	sockets, err := cmd.Flags().GetStringSlice("sockets")
+	if err != nil {
+		return err
+	}
+	dnsTimeout, err := cmd.Flags().GetDuration("dns-timeout")
+	if err != nil {
+		return err
+	}
+
+	report := verify.Verify(config, cfgErr, verify.Options{
+		KubeconfigPath: kubeCfgPath,
+		Sockets:        sockets,
+		DNSTimeout:     dnsTimeout,
+	})
+	switch output {
+	case "text":
+		err = report.WriteText(os.Stdout)
+	case "json":
+		var out []byte
+		out, err = json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			fmt.Println(string(out))
+		}
+	default:
+		return fmt.Errorf("Unknown output format %q", output)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.Passed {
+		return fmt.Errorf("Verification failed")
+	}
+	return nil
+}
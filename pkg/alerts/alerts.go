@@ -0,0 +1,233 @@
+// Package alerts posts the conditions of the monitors that need the attention
+// of an operator to a webhook, e.g. a Slack incoming webhook, for clusters
+// without a monitoring stack.
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
+
+const (
+	// ConditionFlapping is a component reloaded more often than the
+	// threshold, e.g. keepalived failing over back and forth
+	ConditionFlapping = "Flapping"
+	// ConditionUnhealthy is a component unhealthy for longer than allowed
+	ConditionUnhealthy = "Unhealthy"
+	// ConditionRenderFailed is a configuration that could not be rendered
+	ConditionRenderFailed = "RenderFailed"
+
+	// DefaultPayloadTemplate is understood by Slack and by most chat
+	// webhooks
+	DefaultPayloadTemplate = `{"text": {{ printf "[%s] %s on %s: %s" .Condition .Component .Node .Message | json }}}`
+
+	postTimeout = 10 * time.Second
+)
+
+var log = logging.Logger("alerts")
+
+// Options configure the alerts, which are disabled when WebhookURL is empty
+type Options struct {
+	// WebhookURL receives a POST of the rendered PayloadTemplate per alert
+	WebhookURL string
+	// PayloadTemplate is a text/template of the JSON body, executed with an
+	// Alert. Its json function quotes a string.
+	PayloadTemplate string
+	// MinInterval is the shortest time between two alerts of a condition
+	MinInterval time.Duration
+	// ReloadThreshold reloads within ReloadWindow raise ConditionFlapping
+	ReloadThreshold int
+	ReloadWindow    time.Duration
+	// UnhealthyFor is how long a component is unhealthy before raising
+	// ConditionUnhealthy
+	UnhealthyFor time.Duration
+}
+
+// DefaultOptions are the options of the flags
+var DefaultOptions = Options{
+	PayloadTemplate: DefaultPayloadTemplate,
+	MinInterval:     15 * time.Minute,
+	ReloadThreshold: 3,
+	ReloadWindow:    5 * time.Minute,
+	UnhealthyFor:    5 * time.Minute,
+}
+
+// Alert is what the payload template is executed with
+type Alert struct {
+	Condition string
+	Component string
+	Node      string
+	Message   string
+	Time      time.Time
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("payload").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// Validate checks the options before a Notifier is created from them
+func (o Options) Validate() error {
+	if o.WebhookURL == "" {
+		return nil
+	}
+	u, err := url.Parse(o.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid alert webhook URL %s, must be an http or https URL", o.WebhookURL)
+	}
+	if _, err := parseTemplate(o.PayloadTemplate); err != nil {
+		return fmt.Errorf("Invalid alert payload template: %w", err)
+	}
+	if o.ReloadThreshold < 1 {
+		return fmt.Errorf("The alert reload threshold must be positive")
+	}
+	return nil
+}
+
+// Notifier raises the alerts of a component of a node. A nil Notifier
+// ignores the conditions, which is how the alerts are disabled.
+type Notifier struct {
+	options   Options
+	node      string
+	component string
+	payload   *template.Template
+
+	lock sync.Mutex
+	// reloads are the times of the reloads within the reload window
+	reloads []time.Time
+	// unhealthySince is zero while the component is healthy
+	unhealthySince time.Time
+	// sent is the time of the last alert of each condition
+	sent map[string]time.Time
+
+	// swapped out by the tests
+	post func(body []byte) error
+	now  func() time.Time
+}
+
+// NewNotifier returns the notifier of component on node, nil when the alerts
+// are disabled
+func NewNotifier(options Options, node, component string) (*Notifier, error) {
+	if options.WebhookURL == "" {
+		return nil, nil
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	payload, _ := parseTemplate(options.PayloadTemplate)
+	client := &http.Client{Timeout: postTimeout}
+	return &Notifier{
+		options:   options,
+		node:      node,
+		component: component,
+		payload:   payload,
+		sent:      map[string]time.Time{},
+		post: func(body []byte) error {
+			resp, err := client.Post(options.WebhookURL, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return fmt.Errorf("The alert webhook answered %s", resp.Status)
+			}
+			return nil
+		},
+		now: time.Now,
+	}, nil
+}
+
+// Reloaded records a reload of the component, raising ConditionFlapping
+// once the reloads within the window reach the threshold
+func (n *Notifier) Reloaded() {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := n.now()
+	reloads := []time.Time{}
+	for _, t := range n.reloads {
+		if now.Sub(t) < n.options.ReloadWindow {
+			reloads = append(reloads, t)
+		}
+	}
+	n.reloads = append(reloads, now)
+	if len(n.reloads) >= n.options.ReloadThreshold {
+		n.raise(ConditionFlapping, fmt.Sprintf("Reloaded %d times in the last %v", len(n.reloads), n.options.ReloadWindow))
+	}
+}
+
+// Health records the health of the component, raising ConditionUnhealthy
+// while it stays unhealthy for longer than UnhealthyFor
+func (n *Notifier) Health(healthy bool, message string) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := n.now()
+	if healthy {
+		n.unhealthySince = time.Time{}
+		return
+	}
+	if n.unhealthySince.IsZero() {
+		n.unhealthySince = now
+	}
+	if unhealthy := now.Sub(n.unhealthySince); unhealthy >= n.options.UnhealthyFor {
+		n.raise(ConditionUnhealthy, fmt.Sprintf("Unhealthy for %v: %s", unhealthy.Round(time.Second), message))
+	}
+}
+
+// RenderFailed raises ConditionRenderFailed
+func (n *Notifier) RenderFailed(err error) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.raise(ConditionRenderFailed, "Failed to render the configuration: "+err.Error())
+}
+
+// raise posts the alert of condition unless one was posted within
+// MinInterval. A failed post is logged and retried on the next occurrence of
+// the condition.
+func (n *Notifier) raise(condition, message string) {
+	now := n.now()
+	if last, ok := n.sent[condition]; ok && now.Sub(last) < n.options.MinInterval {
+		return
+	}
+	body := &bytes.Buffer{}
+	alert := Alert{Condition: condition, Component: n.component, Node: n.node, Message: message, Time: now}
+	if err := n.payload.Execute(body, alert); err != nil {
+		log.WithFields(logrus.Fields{
+			"condition": condition,
+		}).WithError(err).Error("Failed to render the alert payload")
+		return
+	}
+	if err := n.post(body.Bytes()); err != nil {
+		log.WithFields(logrus.Fields{
+			"condition": condition,
+		}).WithError(err).Warn("Failed to post alert")
+		return
+	}
+	n.sent[condition] = now
+	log.WithFields(logrus.Fields{
+		"condition": condition,
+		"message":   message,
+	}).Info("Posted alert")
+}
@@ -0,0 +1,136 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Notifier", func() {
+	var n *Notifier
+	var posted []string
+	var now time.Time
+
+	BeforeEach(func() {
+		options := DefaultOptions
+		options.WebhookURL = "https://hooks.example.com/services/T0/B0/X"
+		var err error
+		n, err = NewNotifier(options, "master-0", "keepalived-monitor")
+		Expect(err).NotTo(HaveOccurred())
+		posted = []string{}
+		n.post = func(body []byte) error {
+			posted = append(posted, string(body))
+			return nil
+		}
+		now = time.Unix(1700000000, 0)
+		n.now = func() time.Time { return now }
+	})
+
+	It("renders_the_default_payload_as_json", func() {
+		n.RenderFailed(errors.New(`template: "vrrp" is undefined`))
+		Expect(posted).To(HaveLen(1))
+		payload := map[string]string{}
+		Expect(json.Unmarshal([]byte(posted[0]), &payload)).To(Succeed())
+		Expect(payload["text"]).To(Equal(`[RenderFailed] keepalived-monitor on master-0: Failed to render the configuration: template: "vrrp" is undefined`))
+	})
+
+	It("raises_flapping_after_the_reload_threshold_within_the_window", func() {
+		n.Reloaded()
+		now = now.Add(4 * time.Minute)
+		n.Reloaded()
+		// The first reload left the window
+		now = now.Add(2 * time.Minute)
+		n.Reloaded()
+		Expect(posted).To(BeEmpty())
+		now = now.Add(time.Minute)
+		n.Reloaded()
+		Expect(posted).To(HaveLen(1))
+		Expect(posted[0]).To(ContainSubstring("Flapping"))
+	})
+
+	It("raises_unhealthy_once_it_persists", func() {
+		n.Health(false, "API is not reachable through HAProxy")
+		now = now.Add(4 * time.Minute)
+		n.Health(false, "API is not reachable through HAProxy")
+		Expect(posted).To(BeEmpty())
+		// Recovering restarts the period
+		n.Health(true, "")
+		now = now.Add(4 * time.Minute)
+		n.Health(false, "API is not reachable through HAProxy")
+		now = now.Add(5 * time.Minute)
+		n.Health(false, "API is not reachable through HAProxy")
+		Expect(posted).To(HaveLen(1))
+		Expect(posted[0]).To(ContainSubstring("Unhealthy for 5m0s"))
+	})
+
+	It("rate_limits_each_condition", func() {
+		n.RenderFailed(errors.New("first"))
+		now = now.Add(time.Minute)
+		n.RenderFailed(errors.New("second"))
+		Expect(posted).To(HaveLen(1))
+		n.Health(false, "down")
+		now = now.Add(5 * time.Minute)
+		n.Health(false, "down")
+		Expect(posted).To(HaveLen(2))
+		now = now.Add(15 * time.Minute)
+		n.RenderFailed(errors.New("third"))
+		Expect(posted).To(HaveLen(3))
+	})
+
+	It("retries_a_failed_post", func() {
+		n.post = func(body []byte) error { return errors.New("connection refused") }
+		n.RenderFailed(errors.New("first"))
+		n.post = func(body []byte) error {
+			posted = append(posted, string(body))
+			return nil
+		}
+		n.RenderFailed(errors.New("second"))
+		Expect(posted).To(HaveLen(1))
+	})
+
+	It("renders_a_custom_template", func() {
+		options := DefaultOptions
+		options.WebhookURL = "http://alertmanager.example.com/hook"
+		options.PayloadTemplate = `{"node": {{ json .Node }}, "condition": {{ json .Condition }}}`
+		var err error
+		n, err = NewNotifier(options, "master-1", "haproxy-monitor")
+		Expect(err).NotTo(HaveOccurred())
+		n.post = func(body []byte) error {
+			posted = append(posted, string(body))
+			return nil
+		}
+		n.RenderFailed(errors.New("failed"))
+		Expect(posted).To(Equal([]string{`{"node": "master-1", "condition": "RenderFailed"}`}))
+	})
+
+	It("ignores_the_conditions_when_disabled", func() {
+		disabled, err := NewNotifier(DefaultOptions, "master-0", "keepalived-monitor")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(disabled).To(BeNil())
+		disabled.Reloaded()
+		disabled.Health(false, "down")
+		disabled.RenderFailed(errors.New("failed"))
+	})
+})
+
+var _ = Describe("Options", func() {
+	It("validates_the_url_and_the_template", func() {
+		options := DefaultOptions
+		Expect(options.Validate()).To(Succeed())
+		options.WebhookURL = "hooks.example.com"
+		Expect(options.Validate()).NotTo(Succeed())
+		options.WebhookURL = "https://hooks.example.com"
+		Expect(options.Validate()).To(Succeed())
+		options.PayloadTemplate = `{"text": {{ .Message }`
+		Expect(options.Validate()).NotTo(Succeed())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alerts tests")
+}
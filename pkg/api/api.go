@@ -0,0 +1,11 @@
+// Package api is the library entrypoint of baremetal-runtimecfg for the
+// components that compute the node IPs, the keepalived virtual router IDs or
+// the runtime configuration of a node instead of running the binaries.
+//
+// The exported identifiers of this package keep their meaning and their
+// signature for a given major Version: fields and functions are only added in
+// minor versions. Nothing else of this module is covered by that guarantee.
+package api
+
+// Version of this package, the major is bumped on breaking changes
+const Version = "1.0.0"
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("NodeIPSelection", func() {
+	var (
+		origAddressesRouting = addressesRouting
+		origAddressesDefault = addressesDefault
+		origAddressUsable    = addressUsable
+		origAPIReachable     = apiReachable
+	)
+	routed := []net.IP{net.ParseIP("192.168.111.20")}
+	defaulted := []net.IP{net.ParseIP("10.0.0.20")}
+
+	BeforeEach(func() {
+		addressesRouting = func([]net.IP, utils.AddressFilter, bool, []string) ([]net.IP, error) { return routed, nil }
+		addressesDefault = func(bool, utils.AddressFilter, []string) ([]net.IP, error) { return defaulted, nil }
+		addressUsable = func([]net.IP) error { return nil }
+		apiReachable = func(net.IP, string, time.Duration) error { return nil }
+	})
+
+	AfterEach(func() {
+		addressesRouting = origAddressesRouting
+		addressesDefault = origAddressesDefault
+		addressUsable = origAddressUsable
+		apiReachable = origAPIReachable
+	})
+
+	It("prefers the address routing to the VIPs", func() {
+		ips, matches, err := NodeIPSelection{VIPs: []net.IP{net.ParseIP("192.168.111.5")}}.Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips).To(Equal(routed))
+		Expect(matches).To(BeTrue())
+	})
+
+	It("falls back to the default route", func() {
+		addressesRouting = func([]net.IP, utils.AddressFilter, bool, []string) ([]net.IP, error) { return nil, nil }
+		ips, matches, err := NodeIPSelection{VIPs: []net.IP{net.ParseIP("192.168.111.5")}}.Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips).To(Equal(defaulted))
+		Expect(matches).To(BeFalse())
+
+		ips, _, err = NodeIPSelection{}.Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips).To(Equal(defaulted))
+	})
+
+	It("reports when no address qualifies", func() {
+		addressesDefault = func(bool, utils.AddressFilter, []string) ([]net.IP, error) { return nil, nil }
+		_, _, err := NodeIPSelection{NetworkType: NetworkTypeOVNKubernetes}.Select()
+		Expect(errors.Is(err, ErrNoNodeIP)).To(BeTrue())
+	})
+
+	It("fails on an unusable address", func() {
+		addressUsable = func([]net.IP) error { return errors.New("tentative") }
+		_, _, err := NodeIPSelection{VIPs: []net.IP{net.ParseIP("192.168.111.5")}}.Select()
+		Expect(err).To(MatchError("tentative"))
+	})
+
+	It("probes the API from the first node IP", func() {
+		var source net.IP
+		var address string
+		apiReachable = func(ip net.IP, addr string, timeout time.Duration) error {
+			source, address = ip, addr
+			Expect(timeout).To(Equal(DefaultProbeTimeout))
+			return nil
+		}
+		_, _, err := NodeIPSelection{}.Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(BeNil())
+
+		_, _, err = NodeIPSelection{ProbeAddress: "api-int.ostest.test.metalkube.org:6443"}.Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(Equal(defaulted[0]))
+		Expect(address).To(Equal("api-int.ostest.test.metalkube.org:6443"))
+	})
+
+	It("rejects a node IP the API is unreachable from", func() {
+		apiReachable = func(net.IP, string, time.Duration) error { return errors.New("connection refused") }
+		_, _, err := NodeIPSelection{ProbeAddress: "192.168.111.5:6443"}.Select()
+		Expect(errors.Is(err, ErrUnreachable)).To(BeTrue())
+	})
+
+	It("connects from the source address", func() {
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer l.Close()
+		go func() {
+			if conn, err := l.Accept(); err == nil {
+				conn.Close()
+			}
+		}()
+		Expect(checkAPIReachable(net.ParseIP("127.0.0.1"), l.Addr().String(), time.Second)).To(Succeed())
+
+		// The listener is IPv4 only
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		Expect(checkAPIReachable(net.ParseIP("127.0.0.1"), net.JoinHostPort("::1", port), time.Second)).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("VRIDCalculation", func() {
+	It("matches the rendered configuration", func() {
+		cluster := config.Cluster{Name: "ostest"}
+		Expect(cluster.PopulateVRIDs()).To(Succeed())
+		vrids, err := VRIDCalculation{ClusterName: "ostest"}.Calculate()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vrids).To(Equal(VRIDs{API: cluster.APIVirtualRouterID, Ingress: cluster.IngressVirtualRouterID}))
+		Expect(vrids.API).NotTo(Equal(vrids.Ingress))
+	})
+
+	It("requires a cluster name", func() {
+		_, err := VRIDCalculation{}.Calculate()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("RuntimeConfigBuilder", func() {
+	origGetConfig := getConfig
+
+	AfterEach(func() {
+		getConfig = origGetConfig
+	})
+
+	It("defaults the resolv.conf path", func() {
+		var resolvConf string
+		getConfig = func(opts config.Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig config.ClusterLBConfig) (config.Node, error) {
+			resolvConf = resolvConfPath
+			return config.Node{Cluster: config.Cluster{Name: "ostest"}}, nil
+		}
+		node, err := RuntimeConfigBuilder{KubeconfigPath: "kubeconfig", APIPort: 6443}.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node.Cluster.Name).To(Equal("ostest"))
+		Expect(resolvConf).To(Equal("/etc/resolv.conf"))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API tests")
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// NetworkTypeOVNKubernetes restricts the node IPs picked from the default
+// route to the ones OVN-Kubernetes can use
+const NetworkTypeOVNKubernetes = "OVNKubernetes"
+
+// ErrNoNodeIP is returned by NodeIPSelection.Select when no address of the
+// node qualifies. It is worth retrying, e.g. while the network comes up.
+var ErrNoNodeIP = errors.New("No suitable node IP")
+
+// ErrUnreachable is returned by NodeIPSelection.Select when the API can't be
+// reached from the chosen node IP, e.g. because it sits on an isolated
+// network
+var ErrUnreachable = errors.New("API unreachable from the node IP")
+
+// DefaultProbeTimeout bounds the connection to NodeIPSelection.ProbeAddress
+const DefaultProbeTimeout = 5 * time.Second
+
+// swapped out by the tests
+var (
+	addressesRouting = utils.AddressesRouting
+	addressesDefault = utils.AddressesDefault
+	addressUsable    = checkAddressUsable
+	apiReachable     = checkAPIReachable
+)
+
+// NodeIPSelection selects the node IPs the way runtimecfg node-ip does
+type NodeIPSelection struct {
+	// VIPs are the API and ingress VIPs. An address that directly routes to
+	// one of them is preferred to the addresses of the default route.
+	VIPs       []net.IP
+	PreferIPv6 bool
+	// NetworkType is the CNI network type of the cluster, e.g.
+	// NetworkTypeOVNKubernetes
+	NetworkType string
+	// ProbeAddress is the host:port of the API, e.g. the API VIP or
+	// api-int.<cluster>.<domain>:6443, a TCP connection from the first node
+	// IP must reach. Not probed when empty.
+	ProbeAddress string
+	// ProbeTimeout bounds the probe, DefaultProbeTimeout when zero
+	ProbeTimeout time.Duration
+	// ExcludedDevices are the glob patterns of the devices whose addresses
+	// are never selected on top of utils.DefaultExcludedDevices
+	ExcludedDevices []string
+	// OVNInternalSubnets are the subnets whose addresses are never selected
+	// from the default route with NetworkTypeOVNKubernetes,
+	// utils.DefaultOVNInternalSubnets when empty
+	OVNInternalSubnets []string
+}
+
+// Select returns the node IPs, the first one of the preferred family and at
+// most one of the other family, and whether they directly route to the VIPs
+func (s NodeIPSelection) Select() (ips []net.IP, matchesVIPs bool, err error) {
+	if len(s.VIPs) > 0 {
+		ips, err = addressesRouting(s.VIPs, utils.ValidNodeAddress, s.PreferIPv6, s.ExcludedDevices)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(ips) > 0 {
+			if err := s.verify(ips); err != nil {
+				return nil, false, err
+			}
+			return ips, true, nil
+		}
+	}
+
+	// The OVN filter only applies when the VIPs could not select the address
+	filter := utils.ValidNodeAddress
+	if s.NetworkType == NetworkTypeOVNKubernetes {
+		subnets := s.OVNInternalSubnets
+		if len(subnets) == 0 {
+			subnets = utils.DefaultOVNInternalSubnets()
+		}
+		if filter, err = utils.OVNNodeAddressFilter(subnets); err != nil {
+			return nil, false, err
+		}
+	}
+	ips, err = addressesDefault(s.PreferIPv6, filter, s.ExcludedDevices)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(ips) == 0 {
+		return nil, false, ErrNoNodeIP
+	}
+	if err := s.verify(ips); err != nil {
+		return nil, false, err
+	}
+	return ips, false, nil
+}
+
+// verify checks the chosen node IPs can be bound and, with a ProbeAddress,
+// reach the API
+func (s NodeIPSelection) verify(ips []net.IP) error {
+	if err := addressUsable(ips); err != nil {
+		return err
+	}
+	if s.ProbeAddress == "" {
+		return nil
+	}
+	timeout := s.ProbeTimeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	if err := apiReachable(ips[0], s.ProbeAddress, timeout); err != nil {
+		return fmt.Errorf("%w: %s from %s: %v", ErrUnreachable, s.ProbeAddress, ips[0], err)
+	}
+	return nil
+}
+
+// checkAPIReachable opens a TCP connection to address from the source IP, so
+// the traffic leaves through the interface of the node IP and the API
+// answers on the path the kubelet will use
+func checkAPIReachable(source net.IP, address string, timeout time.Duration) error {
+	network := "tcp4"
+	if utils.IsIPv6(source) {
+		network = "tcp6"
+	}
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: source},
+		Timeout:   timeout,
+	}
+	conn, err := dialer.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkAddressUsable verifies that an IPv6 address is not tentative, i.e. we
+// can actually bind to it
+func checkAddressUsable(ips []net.IP) error {
+	if len(ips) > 0 && net.IPv6len == len(ips[0]) {
+		l, err := net.Listen("tcp", "["+ips[0].String()+"]:")
+		if err != nil {
+			return err
+		}
+		l.Close()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+const defaultResolvConfPath = "/etc/resolv.conf"
+
+// RuntimeConfig is the configuration rendered into the templates, see the
+// fields of config.Node
+type RuntimeConfig = config.Node
+
+// CloudLoadBalancers are the IPs of the cloud load balancers of the cluster
+type CloudLoadBalancers = config.ClusterLBConfig
+
+// ConfigOptions are the settings of the computation of the configuration, as
+// set by the flags of runtimecfg render
+type ConfigOptions = config.Options
+
+// getConfig is swapped out by the tests
+var getConfig = config.GetConfig
+
+// RuntimeConfigBuilder builds the runtime configuration of the node, as
+// runtimecfg render does
+type RuntimeConfigBuilder struct {
+	KubeconfigPath string
+	// ClusterConfigPath is the path of the cluster-config ConfigMap, which
+	// runtimecfg falls back to for the install config
+	ClusterConfigPath string
+	// ResolvConfPath defaults to /etc/resolv.conf
+	ResolvConfPath string
+	APIVIPs        []net.IP
+	IngressVIPs    []net.IP
+	APIPort        uint16
+	LBPort         uint16
+	StatPort       uint16
+	CloudLB        CloudLoadBalancers
+	// Options default to the ones of runtimecfg render without flags
+	Options *ConfigOptions
+}
+
+// Build returns the runtime configuration of the node
+func (b RuntimeConfigBuilder) Build() (RuntimeConfig, error) {
+	resolvConfPath := b.ResolvConfPath
+	if resolvConfPath == "" {
+		resolvConfPath = defaultResolvConfPath
+	}
+	opts := config.DefaultOptions()
+	if b.Options != nil {
+		opts = *b.Options
+	}
+	return getConfig(opts, b.KubeconfigPath, b.ClusterConfigPath, resolvConfPath, b.APIVIPs, b.IngressVIPs, b.APIPort, b.LBPort, b.StatPort, b.CloudLB)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// VRIDs are the keepalived virtual router IDs of a cluster
+type VRIDs struct {
+	API     uint8
+	Ingress uint8
+}
+
+// VRIDCalculation derives the virtual router IDs from the cluster name, as
+// the keepalived configuration rendered by runtimecfg does
+type VRIDCalculation struct {
+	ClusterName string
+}
+
+// Calculate returns the virtual router IDs, an error if the cluster name is
+// empty
+func (c VRIDCalculation) Calculate() (VRIDs, error) {
+	cluster := config.Cluster{Name: c.ClusterName}
+	if err := cluster.PopulateVRIDs(); err != nil {
+		return VRIDs{}, err
+	}
+	return VRIDs{API: cluster.APIVirtualRouterID, Ingress: cluster.IngressVirtualRouterID}, nil
+}
@@ -0,0 +1,116 @@
+// Package cmddoc provides the completion and docs subcommands shared by the
+// binaries of the repository.
+package cmddoc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Flag describes a command line flag
+type Flag struct {
+	Name       string `json:"name"`
+	Shorthand  string `json:"shorthand,omitempty"`
+	Type       string `json:"type"`
+	Default    string `json:"default"`
+	Usage      string `json:"usage"`
+	Deprecated string `json:"deprecated,omitempty"`
+}
+
+// Command describes a command, its flags and its subcommands
+type Command struct {
+	Name  string `json:"name"`
+	Use   string `json:"use"`
+	Short string `json:"short,omitempty"`
+	// Flags are defined by the command, InheritedFlags by its parents
+	Flags          []Flag    `json:"flags,omitempty"`
+	InheritedFlags []Flag    `json:"inheritedFlags,omitempty"`
+	Commands       []Command `json:"commands,omitempty"`
+}
+
+func describeFlags(flags *pflag.FlagSet) []Flag {
+	docs := []Flag{}
+	// VisitAll walks the flags sorted by name
+	flags.VisitAll(func(f *pflag.Flag) {
+		// Deprecated flags are hidden but still accepted
+		if f.Hidden && f.Deprecated == "" {
+			return
+		}
+		docs = append(docs, Flag{
+			Name:       f.Name,
+			Shorthand:  f.Shorthand,
+			Type:       f.Value.Type(),
+			Default:    f.DefValue,
+			Usage:      f.Usage,
+			Deprecated: f.Deprecated,
+		})
+	})
+	return docs
+}
+
+// DescribeCommand returns the tree of cmd. Hidden and deprecated commands
+// and the help command are left out, like in the help output.
+func DescribeCommand(cmd *cobra.Command) Command {
+	doc := Command{
+		Name:           cmd.Name(),
+		Use:            cmd.Use,
+		Short:          cmd.Short,
+		Flags:          describeFlags(cmd.NonInheritedFlags()),
+		InheritedFlags: describeFlags(cmd.InheritedFlags()),
+	}
+	// Commands returns the subcommands sorted by name
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			doc.Commands = append(doc.Commands, DescribeCommand(sub))
+		}
+	}
+	return doc
+}
+
+// NewDocsCommand returns the hidden docs command, which prints the trees of
+// the commands returned by binaries as JSON. binaries is called when the
+// command runs, once the command tree it belongs to is complete.
+func NewDocsCommand(binaries func() []*cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:    "docs",
+		Short:  "Prints the commands and flags of the binaries as JSON",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			docs := []Command{}
+			for _, binary := range binaries() {
+				docs = append(docs, DescribeCommand(binary))
+			}
+			out, err := json.MarshalIndent(docs, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return err
+		},
+	}
+}
+
+// NewCompletionCommand returns the completion command, which prints the
+// completion script of the root command for a shell
+func NewCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion bash|zsh|fish",
+		Short:     "Prints the shell completion script",
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root, out := cmd.Root(), cmd.OutOrStdout()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(out, true)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			}
+			return root.GenFishCompletion(out, true)
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package cmddoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+)
+
+func testRoot() *cobra.Command {
+	root := &cobra.Command{Use: "tool", Short: "A tool"}
+	root.PersistentFlags().Bool("verbose", false, "Log more")
+	sub := &cobra.Command{Use: "run [path]", Short: "Runs", Run: func(*cobra.Command, []string) {}}
+	sub.Flags().Uint16P("port", "p", 80, "Port to use")
+	sub.Flags().String("old", "", "Old flag")
+	sub.Flags().MarkDeprecated("old", "use --port")
+	sub.Flags().String("secret", "", "Hidden flag")
+	sub.Flags().MarkHidden("secret")
+	hidden := &cobra.Command{Use: "internal", Hidden: true, Run: func(*cobra.Command, []string) {}}
+	root.AddCommand(sub, hidden)
+	return root
+}
+
+var _ = Describe("DescribeCommand", func() {
+	It("describes the commands and flags", func() {
+		Expect(DescribeCommand(testRoot())).To(Equal(Command{
+			Name:  "tool",
+			Use:   "tool",
+			Short: "A tool",
+			Flags: []Flag{{Name: "verbose", Type: "bool", Default: "false", Usage: "Log more"}},
+			// Hidden commands are left out
+			Commands: []Command{{
+				Name:  "run",
+				Use:   "run [path]",
+				Short: "Runs",
+				Flags: []Flag{
+					{Name: "old", Type: "string", Usage: "Old flag", Deprecated: "use --port"},
+					{Name: "port", Shorthand: "p", Type: "uint16", Default: "80", Usage: "Port to use"},
+				},
+				InheritedFlags: []Flag{{Name: "verbose", Type: "bool", Default: "false", Usage: "Log more"}},
+			}},
+			InheritedFlags: []Flag{},
+		}))
+	})
+})
+
+var _ = Describe("NewDocsCommand", func() {
+	It("prints the trees of the binaries as JSON", func() {
+		docs := NewDocsCommand(func() []*cobra.Command { return []*cobra.Command{testRoot(), testRoot()} })
+		out := &bytes.Buffer{}
+		docs.SetOut(out)
+		Expect(docs.RunE(docs, nil)).To(Succeed())
+
+		parsed := []Command{}
+		Expect(json.Unmarshal(out.Bytes(), &parsed)).To(Succeed())
+		Expect(parsed).To(HaveLen(2))
+		Expect(parsed[0].Commands[0].Name).To(Equal("run"))
+	})
+})
+
+var _ = Describe("NewCompletionCommand", func() {
+	It("prints the script of the root command", func() {
+		root := testRoot()
+		root.AddCommand(NewCompletionCommand())
+		out := &bytes.Buffer{}
+		root.SetOut(out)
+		root.SetArgs([]string{"completion", "fish"})
+		Expect(root.Execute()).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("fish completion for tool"))
+	})
+
+	It("rejects unknown shells", func() {
+		root := testRoot()
+		root.AddCommand(NewCompletionCommand())
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs([]string{"completion", "tcsh"})
+		Expect(root.Execute()).ToNot(Succeed())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cmddoc tests")
+}
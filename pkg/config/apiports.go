@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// APIPort is a port the API is reached on through the VIPs. Its traffic is
+// redirected to the port HAProxy listens on for it, which balances it to the
+// API port of the masters.
+type APIPort struct {
+	// Port is where the clients reach the API on the VIPs
+	Port uint16
+	// LbPort is where HAProxy listens for the traffic of Port
+	LbPort uint16
+}
+
+// APIPorts returns the API port mapped to lbPort followed by extra
+func APIPorts(apiPort, lbPort uint16, extra []APIPort) []APIPort {
+	return append([]APIPort{{Port: apiPort, LbPort: lbPort}}, extra...)
+}
+
+// ParseAPIPorts parses port:lbport values
+func ParseAPIPorts(values []string) ([]APIPort, error) {
+	ports := []APIPort{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid API port %s, expected port:lbport", value)
+		}
+		port, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("Invalid API port %s", parts[0])
+		}
+		lbPort, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || lbPort == 0 {
+			return nil, fmt.Errorf("Invalid HAProxy port %s of API port %s", parts[1], parts[0])
+		}
+		ports = append(ports, APIPort{Port: uint16(port), LbPort: uint16(lbPort)})
+	}
+	return ports, nil
+}
+
+// ValidateAPIPorts checks that the ports of the mappings are distinct, so
+// that each VIP port is redirected once and each HAProxy port bound once
+func ValidateAPIPorts(ports []APIPort) error {
+	seen := map[uint16]bool{}
+	seenLb := map[uint16]bool{}
+	for _, p := range ports {
+		if seen[p.Port] {
+			return fmt.Errorf("API port %d is mapped more than once", p.Port)
+		}
+		if seenLb[p.LbPort] {
+			return fmt.Errorf("HAProxy port %d is used by more than one API port", p.LbPort)
+		}
+		seen[p.Port] = true
+		seenLb[p.LbPort] = true
+	}
+	return nil
+}
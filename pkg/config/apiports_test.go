@@ -0,0 +1,30 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("APIPorts", func() {
+	It("parses the port mappings", func() {
+		ports, err := ParseAPIPorts([]string{"443:9443", "8443:9444"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ports).To(Equal([]APIPort{{Port: 443, LbPort: 9443}, {Port: 8443, LbPort: 9444}}))
+
+		for _, value := range []string{"443", "443:", "0:9443", "443:70000", "https:9443"} {
+			_, err := ParseAPIPorts([]string{value})
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+
+	It("lists the API port first", func() {
+		Expect(APIPorts(6443, 9445, nil)).To(Equal([]APIPort{{Port: 6443, LbPort: 9445}}))
+		Expect(APIPorts(6443, 9445, []APIPort{{Port: 443, LbPort: 9443}})).To(Equal([]APIPort{{Port: 6443, LbPort: 9445}, {Port: 443, LbPort: 9443}}))
+	})
+
+	It("rejects ports mapped twice", func() {
+		Expect(ValidateAPIPorts([]APIPort{{Port: 6443, LbPort: 9445}, {Port: 443, LbPort: 9443}})).To(Succeed())
+		Expect(ValidateAPIPorts([]APIPort{{Port: 6443, LbPort: 9445}, {Port: 6443, LbPort: 9443}})).NotTo(Succeed())
+		Expect(ValidateAPIPorts([]APIPort{{Port: 6443, LbPort: 9445}, {Port: 443, LbPort: 9445}})).NotTo(Succeed())
+	})
+})
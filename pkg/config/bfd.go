@@ -0,0 +1,53 @@
+package config
+
+import "fmt"
+
+// BFDConfig describes the BFD sessions with the routing peers of the node,
+// which detect a failed node in DetectMultiplier * ReceiveInterval instead
+// of the seconds a routing protocol takes.
+type BFDConfig struct {
+	Enabled bool
+	// Peers have a BFD session, every routing peer when empty
+	Peers []string
+	// ReceiveInterval and TransmitInterval are in milliseconds
+	ReceiveInterval  uint32
+	TransmitInterval uint32
+	DetectMultiplier uint8
+}
+
+// Validate checks the timers against the ranges FRR accepts
+func (b BFDConfig) Validate() error {
+	if !b.Enabled {
+		return nil
+	}
+	for name, interval := range map[string]uint32{"receive": b.ReceiveInterval, "transmit": b.TransmitInterval} {
+		if interval < 10 || interval > 60000 {
+			return fmt.Errorf("BFD %s interval %dms is out of the 10-60000ms range", name, interval)
+		}
+	}
+	if b.DetectMultiplier < 2 {
+		return fmt.Errorf("BFD detect multiplier %d is lower than 2", b.DetectMultiplier)
+	}
+	return nil
+}
+
+// PeersOf returns the routing peers that have a BFD session
+func (b BFDConfig) PeersOf(routingPeers []string) []string {
+	if !b.Enabled {
+		return []string{}
+	}
+	if len(b.Peers) == 0 {
+		return routingPeers
+	}
+	enabled := map[string]bool{}
+	for _, peer := range b.Peers {
+		enabled[peer] = true
+	}
+	peers := []string{}
+	for _, peer := range routingPeers {
+		if enabled[peer] {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
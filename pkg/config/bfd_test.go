@@ -0,0 +1,33 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BFDConfig", func() {
+	bfd := BFDConfig{Enabled: true, ReceiveInterval: 300, TransmitInterval: 300, DetectMultiplier: 3}
+	routingPeers := []string{"192.168.111.1", "192.168.111.2"}
+
+	It("validates_the_timers", func() {
+		Expect(bfd.Validate()).To(Succeed())
+		Expect(BFDConfig{}.Validate()).To(Succeed())
+
+		tooFast := bfd
+		tooFast.TransmitInterval = 5
+		Expect(tooFast.Validate()).To(MatchError(ContainSubstring("transmit interval 5ms")))
+
+		noMultiplier := bfd
+		noMultiplier.DetectMultiplier = 1
+		Expect(noMultiplier.Validate()).To(HaveOccurred())
+	})
+
+	It("selects_the_peers", func() {
+		Expect(bfd.PeersOf(routingPeers)).To(Equal(routingPeers))
+		Expect(BFDConfig{}.PeersOf(routingPeers)).To(BeEmpty())
+
+		some := bfd
+		some.Peers = []string{"192.168.111.2", "10.0.0.1"}
+		Expect(some.PeersOf(routingPeers)).To(Equal([]string{"192.168.111.2"}))
+	})
+})
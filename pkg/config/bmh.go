@@ -0,0 +1,116 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// StaticLease is a DHCP host reservation rendered for dnsmasq as
+// dhcp-host=MAC,IP,Hostname.
+type StaticLease struct {
+	MAC      string
+	IP       string
+	Hostname string
+	Ipv6     bool
+}
+
+// bareMetalHostList holds the subset of metal3.io/v1alpha1 BareMetalHost
+// fields needed to build static leases.
+type bareMetalHostList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			BootMACAddress string `json:"bootMACAddress"`
+		} `json:"spec"`
+		Status struct {
+			HardwareDetails *struct {
+				Hostname string `json:"hostname"`
+				NIC      []struct {
+					MAC string `json:"mac"`
+					IP  string `json:"ip"`
+				} `json:"nics"`
+			} `json:"hardware"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// parseStaticLeases builds one lease per boot MAC address and IP found in
+// the inspected hardware details of a BareMetalHost list.
+func parseStaticLeases(data []byte) ([]StaticLease, error) {
+	hosts := bareMetalHostList{}
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return nil, err
+	}
+
+	leases := []StaticLease{}
+	for _, host := range hosts.Items {
+		bootMAC, err := net.ParseMAC(host.Spec.BootMACAddress)
+		if err != nil {
+			log.Debugf("BareMetalHost %s has no valid boot MAC address, skipping", host.Metadata.Name)
+			continue
+		}
+		if host.Status.HardwareDetails == nil {
+			log.Debugf("BareMetalHost %s has not been inspected yet, skipping", host.Metadata.Name)
+			continue
+		}
+		hostname := host.Status.HardwareDetails.Hostname
+		if hostname == "" {
+			hostname = host.Metadata.Name
+		}
+		hostname = strings.Split(hostname, ".")[0]
+		for _, nic := range host.Status.HardwareDetails.NIC {
+			mac, err := net.ParseMAC(nic.MAC)
+			if err != nil || mac.String() != bootMAC.String() {
+				continue
+			}
+			ip := net.ParseIP(nic.IP)
+			if ip == nil || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			leases = append(leases, StaticLease{
+				MAC:      mac.String(),
+				IP:       ip.String(),
+				Hostname: hostname,
+				Ipv6:     utils.IsIPv6(ip),
+			})
+		}
+	}
+	sort.SliceStable(leases, func(i, j int) bool {
+		if leases[i].Hostname == leases[j].Hostname {
+			return leases[i].IP < leases[j].IP
+		}
+		return leases[i].Hostname < leases[j].Hostname
+	})
+	return leases, nil
+}
+
+// GetStaticLeases lists the BareMetalHosts in namespace and returns the DHCP
+// reservations for their boot interfaces.
+func GetStaticLeases(kubeconfigPath, namespace string) ([]StaticLease, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/metal3.io/v1alpha1/namespaces", namespace, "baremetalhosts").
+		DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list BareMetalHosts in %s: %w", namespace, err)
+	}
+	return parseStaticLeases(data)
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseStaticLeases", func() {
+	It("builds leases for the boot interface of inspected hosts", func() {
+		leases, err := parseStaticLeases([]byte(`{"items": [
+			{"metadata": {"name": "worker-1"},
+			 "spec": {"bootMACAddress": "52:54:00:AA:BB:02"},
+			 "status": {"hardware": {"hostname": "worker-1.example.com", "nics": [
+				{"mac": "52:54:00:aa:bb:02", "ip": "172.22.0.21"},
+				{"mac": "52:54:00:aa:bb:02", "ip": "fe80::1"},
+				{"mac": "52:54:00:aa:bb:02", "ip": "fd2e:6f44:5dd8::21"},
+				{"mac": "52:54:00:aa:cc:02", "ip": "192.168.111.21"}]}}},
+			{"metadata": {"name": "worker-0"},
+			 "spec": {"bootMACAddress": "52:54:00:aa:bb:01"},
+			 "status": {"hardware": {"nics": [{"mac": "52:54:00:aa:bb:01", "ip": "172.22.0.20"}]}}},
+			{"metadata": {"name": "not-inspected"},
+			 "spec": {"bootMACAddress": "52:54:00:aa:bb:03"},
+			 "status": {}},
+			{"metadata": {"name": "no-mac"},
+			 "spec": {},
+			 "status": {"hardware": {"nics": [{"mac": "52:54:00:aa:bb:04", "ip": "172.22.0.23"}]}}}
+		]}`))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(leases).To(Equal([]StaticLease{
+			{MAC: "52:54:00:aa:bb:01", IP: "172.22.0.20", Hostname: "worker-0"},
+			{MAC: "52:54:00:aa:bb:02", IP: "172.22.0.21", Hostname: "worker-1"},
+			{MAC: "52:54:00:aa:bb:02", IP: "fd2e:6f44:5dd8::21", Hostname: "worker-1", Ipv6: true},
+		}))
+	})
+
+	It("fails on invalid data", func() {
+		_, err := parseStaticLeases([]byte("not json"))
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// BondKindBond and BondKindTeam are the aggregate devices a VRRP
+	// interface can be
+	BondKindBond = "bond"
+	BondKindTeam = "team"
+)
+
+// sysClassNet and interfaceByName are swapped out by the tests
+var (
+	sysClassNet     = "/sys/class/net"
+	interfaceByName = net.InterfaceByName
+)
+
+// Bond describes the bond or team device a VRRP interface is. keepalived
+// has to advertise on it rather than on one of its slaves, whose link state
+// changes when the bond fails over to another slave.
+type Bond struct {
+	// Kind is BondKindBond or BondKindTeam
+	Kind string
+	// Mode is the bonding mode, e.g. active-backup or 802.3ad. It is empty
+	// for teams, whose runner is only known to teamd.
+	Mode string
+	// ActiveSlave is the slave carrying the traffic in the active-backup
+	// modes, empty otherwise
+	ActiveSlave string
+	Slaves      []string
+}
+
+// readSysClassNet returns the trimmed content of a sysfs attribute of the
+// device name, empty when it can not be read
+func readSysClassNet(name string, attribute ...string) string {
+	data, err := os.ReadFile(filepath.Join(append([]string{sysClassNet, name}, attribute...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// bondKind returns BondKindBond or BondKindTeam for such a device, empty
+// for any other
+func bondKind(name string) string {
+	if _, err := os.Stat(filepath.Join(sysClassNet, name, "bonding")); err == nil {
+		return BondKindBond
+	}
+	for _, line := range strings.Split(readSysClassNet(name, "uevent"), "\n") {
+		if line == "DEVTYPE=team" {
+			return BondKindTeam
+		}
+	}
+	return ""
+}
+
+// GetBond returns the bond or team the device name is, nil when it is
+// neither
+func GetBond(name string) *Bond {
+	if name == "" {
+		return nil
+	}
+	kind := bondKind(name)
+	if kind == "" {
+		return nil
+	}
+	bond := &Bond{Kind: kind, Slaves: []string{}}
+	if kind == BondKindTeam {
+		entries, err := os.ReadDir(filepath.Join(sysClassNet, name))
+		if err == nil {
+			for _, entry := range entries {
+				if slave := strings.TrimPrefix(entry.Name(), "lower_"); slave != entry.Name() {
+					bond.Slaves = append(bond.Slaves, slave)
+				}
+			}
+		}
+		return bond
+	}
+	// e.g. "active-backup 1"
+	if fields := strings.Fields(readSysClassNet(name, "bonding", "mode")); len(fields) > 0 {
+		bond.Mode = fields[0]
+	}
+	bond.ActiveSlave = readSysClassNet(name, "bonding", "active_slave")
+	bond.Slaves = append(bond.Slaves, strings.Fields(readSysClassNet(name, "bonding", "slaves"))...)
+	return bond
+}
+
+// bondMaster returns the bond or team iface is a slave of, iface when it is
+// none
+func bondMaster(iface net.Interface) net.Interface {
+	link, err := os.Readlink(filepath.Join(sysClassNet, iface.Name, "master"))
+	if err != nil {
+		return iface
+	}
+	name := filepath.Base(link)
+	if bondKind(name) == "" {
+		return iface
+	}
+	master, err := interfaceByName(name)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"interface": iface.Name,
+			"master":    name,
+		}).WithError(err).Warn("Failed to get the bond master, using its slave")
+		return iface
+	}
+	log.WithFields(logrus.Fields{
+		"interface": iface.Name,
+		"master":    name,
+	}).Debug("Using the bond master of the VRRP interface")
+	return *master
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bond", func() {
+	var dir string
+
+	// device creates the sysfs directory of name with its attributes
+	device := func(name string, attributes map[string]string) {
+		for attribute, value := range attributes {
+			path := filepath.Join(dir, name, attribute)
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(os.WriteFile(path, []byte(value+"\n"), 0644)).To(Succeed())
+		}
+	}
+	// enslave makes slave a port of master
+	enslave := func(slave, master string) {
+		Expect(os.MkdirAll(filepath.Join(dir, slave), 0755)).To(Succeed())
+		Expect(os.Symlink("../"+master, filepath.Join(dir, slave, "master"))).To(Succeed())
+		Expect(os.Symlink("../"+slave, filepath.Join(dir, master, "lower_"+slave))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "sysclassnet")
+		Expect(err).ShouldNot(HaveOccurred())
+		sysClassNet = dir
+		interfaceByName = func(name string) (*net.Interface, error) {
+			if name == "missing0" {
+				return nil, errors.New("no such network interface")
+			}
+			return &net.Interface{Index: 10, Name: name}, nil
+		}
+
+		device("bond0", map[string]string{
+			"uevent":               "DEVTYPE=bond\nINTERFACE=bond0",
+			"bonding/mode":         "active-backup 1",
+			"bonding/active_slave": "eno1",
+			"bonding/slaves":       "eno1 eno2",
+		})
+		enslave("eno1", "bond0")
+		enslave("eno2", "bond0")
+		device("team0", map[string]string{"uevent": "DEVTYPE=team\nINTERFACE=team0"})
+		enslave("ens1", "team0")
+		device("br-ex", map[string]string{"uevent": "DEVTYPE=bridge\nINTERFACE=br-ex", "bridge/stp_state": "0"})
+		enslave("ens2", "br-ex")
+	})
+
+	AfterEach(func() {
+		sysClassNet = "/sys/class/net"
+		interfaceByName = net.InterfaceByName
+		os.RemoveAll(dir)
+	})
+
+	It("describes_bonds_and_teams", func() {
+		Expect(GetBond("bond0")).To(Equal(&Bond{Kind: BondKindBond, Mode: "active-backup", ActiveSlave: "eno1", Slaves: []string{"eno1", "eno2"}}))
+		Expect(GetBond("team0")).To(Equal(&Bond{Kind: BondKindTeam, Slaves: []string{"ens1"}}))
+		Expect(GetBond("br-ex")).To(BeNil())
+		Expect(GetBond("eno1")).To(BeNil())
+		Expect(GetBond("")).To(BeNil())
+	})
+
+	It("selects_the_bond_master", func() {
+		Expect(bondMaster(net.Interface{Index: 2, Name: "eno2"}).Name).To(Equal("bond0"))
+		Expect(bondMaster(net.Interface{Index: 3, Name: "ens1"}).Name).To(Equal("team0"))
+		// Bridges keep their ports
+		Expect(bondMaster(net.Interface{Index: 4, Name: "ens2"}).Name).To(Equal("ens2"))
+		Expect(bondMaster(net.Interface{Index: 10, Name: "bond0"}).Name).To(Equal("bond0"))
+		Expect(bondMaster(net.Interface{Index: 5, Name: "eth0"}).Name).To(Equal("eth0"))
+	})
+
+	It("keeps_the_slave_without_its_master", func() {
+		device("missing0", map[string]string{"bonding/mode": "802.3ad 4"})
+		enslave("eno3", "missing0")
+		Expect(bondMaster(net.Interface{Index: 6, Name: "eno3"}).Name).To(Equal("eno3"))
+	})
+})
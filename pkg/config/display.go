@@ -0,0 +1,67 @@
+package config
+
+// RedactedValue replaces the sensitive values of a redacted Node
+const RedactedValue = "REDACTED"
+
+// ForDisplay returns a copy of the node meant to be printed. Configs holds
+// the nested nodes without their own Configs, since the first of them is
+// usually the node itself, which makes the structure recursive. With redact
+// the values that identify the site are replaced by RedactedValue: the
+// cluster domain, the external API addresses, the TLS server names of the DNS
+// forwarders and the MAC addresses and hostnames of the static leases.
+func (n Node) ForDisplay(redact bool) Node {
+	display := n.withoutConfigs(redact)
+	if n.Configs != nil {
+		configs := make([]Node, 0, len(*n.Configs))
+		for _, c := range *n.Configs {
+			configs = append(configs, c.withoutConfigs(redact))
+		}
+		display.Configs = &configs
+	}
+	return display
+}
+
+func (n Node) withoutConfigs(redact bool) Node {
+	n.Configs = nil
+	if !redact {
+		return n
+	}
+
+	n.Cluster.Domain = RedactedValue
+	n.Cluster.APILBIPs = redactAll(n.Cluster.APILBIPs)
+	n.Cluster.APIExternalIPs = redactAll(n.Cluster.APIExternalIPs)
+	// The slices are shared with n, so they are copied before redaction
+	if n.DNSForwarders != nil {
+		forwarders := make([]DNSForwarder, len(n.DNSForwarders))
+		for i, f := range n.DNSForwarders {
+			if f.TLSServerName != "" {
+				f.TLSServerName = RedactedValue
+			}
+			forwarders[i] = f
+		}
+		n.DNSForwarders = forwarders
+	}
+	if n.DHCPStaticLeases != nil {
+		leases := make([]StaticLease, len(n.DHCPStaticLeases))
+		for i, l := range n.DHCPStaticLeases {
+			l.MAC = RedactedValue
+			if l.Hostname != "" {
+				l.Hostname = RedactedValue
+			}
+			leases[i] = l
+		}
+		n.DHCPStaticLeases = leases
+	}
+	return n
+}
+
+func redactAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	redacted := make([]string, len(values))
+	for i := range values {
+		redacted[i] = RedactedValue
+	}
+	return redacted
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ForDisplay", func() {
+	var node Node
+
+	BeforeEach(func() {
+		node = Node{
+			Cluster: Cluster{
+				Name:           "ostest",
+				Domain:         "ostest.example.com",
+				APIVIP:         "192.168.111.5",
+				APIExternalIPs: []string{"203.0.113.10"},
+			},
+			DNSForwarders:    []DNSForwarder{{Zone: "corp.example.com", Upstreams: []string{"10.0.0.53"}, Protocol: "tls", TLSServerName: "dns.example.com"}},
+			DHCPStaticLeases: []StaticLease{{MAC: "52:54:00:aa:bb:01", IP: "172.22.0.20", Hostname: "worker-0"}},
+		}
+		configs := []Node{node}
+		node.Configs = &configs
+	})
+
+	It("drops the nested configs of the nested nodes", func() {
+		display := node.ForDisplay(false)
+		Expect(*display.Configs).To(HaveLen(1))
+		Expect((*display.Configs)[0].Configs).To(BeNil())
+		Expect((*display.Configs)[0].Cluster.Domain).To(Equal("ostest.example.com"))
+
+		_, err := json.Marshal(display)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("redacts the values that identify the site", func() {
+		display := node.ForDisplay(true)
+		for _, n := range []Node{display, (*display.Configs)[0]} {
+			Expect(n.Cluster.Name).To(Equal("ostest"))
+			Expect(n.Cluster.APIVIP).To(Equal("192.168.111.5"))
+			Expect(n.Cluster.Domain).To(Equal(RedactedValue))
+			Expect(n.Cluster.APIExternalIPs).To(Equal([]string{RedactedValue}))
+			Expect(n.DNSForwarders[0].TLSServerName).To(Equal(RedactedValue))
+			Expect(n.DNSForwarders[0].Upstreams).To(Equal([]string{"10.0.0.53"}))
+			Expect(n.DHCPStaticLeases).To(Equal([]StaticLease{{MAC: RedactedValue, IP: "172.22.0.20", Hostname: RedactedValue}}))
+		}
+	})
+
+	It("leaves the original node untouched", func() {
+		node.ForDisplay(true)
+		Expect(node.Cluster.Domain).To(Equal("ostest.example.com"))
+		Expect(node.Cluster.APIExternalIPs).To(Equal([]string{"203.0.113.10"}))
+		Expect(node.DNSForwarders[0].TLSServerName).To(Equal("dns.example.com"))
+		Expect(node.DHCPStaticLeases[0].MAC).To(Equal("52:54:00:aa:bb:01"))
+	})
+})
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	dnsForwardingConfigMap = "dns-forwarding"
+	dnsForwardingKey       = "forwarders"
+)
+
+// DNSForwarder describes a conditional forwarder for a single zone.
+type DNSForwarder struct {
+	Zone      string   `json:"zone"`
+	Upstreams []string `json:"upstreams"`
+	// Protocol is either "dns" (default) or "tls".
+	Protocol string `json:"protocol,omitempty"`
+	// TLSServerName is the name used to verify the upstream certificate
+	// when Protocol is "tls".
+	TLSServerName string `json:"tlsServerName,omitempty"`
+}
+
+// parseDNSForwarders validates the content of the dns-forwarding ConfigMap
+// and returns the forwarders sorted by zone.
+func parseDNSForwarders(data string) ([]DNSForwarder, error) {
+	forwarders := []DNSForwarder{}
+	if err := yaml.Unmarshal([]byte(data), &forwarders); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, f := range forwarders {
+		zone := strings.TrimSuffix(strings.TrimSpace(f.Zone), ".")
+		if zone == "" {
+			return nil, fmt.Errorf("Forwarder %d has an empty zone", i)
+		}
+		if seen[zone] {
+			return nil, fmt.Errorf("Zone %s is defined more than once", zone)
+		}
+		seen[zone] = true
+		if len(f.Upstreams) == 0 {
+			return nil, fmt.Errorf("Zone %s has no upstreams", zone)
+		}
+		for _, upstream := range f.Upstreams {
+			host, _, err := net.SplitHostPort(upstream)
+			if err != nil {
+				host = upstream
+			}
+			if net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("Upstream %s for zone %s is not an IP address", upstream, zone)
+			}
+		}
+		switch f.Protocol {
+		case "":
+			f.Protocol = "dns"
+		case "dns", "tls":
+		default:
+			return nil, fmt.Errorf("Unsupported protocol %s for zone %s", f.Protocol, zone)
+		}
+		f.Zone = zone
+		forwarders[i] = f
+	}
+	sort.SliceStable(forwarders, func(i, j int) bool {
+		return forwarders[i].Zone < forwarders[j].Zone
+	})
+	return forwarders, nil
+}
+
+// GetDNSForwarders reads the per-zone conditional forwarders from the
+// dns-forwarding ConfigMap in the pod namespace. A missing ConfigMap is not an
+// error and results in no forwarders.
+func GetDNSForwarders(kubeconfigPath string) ([]DNSForwarder, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(os.Getenv("POD_NAMESPACE")).Get(context.TODO(), dnsForwardingConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return []DNSForwarder{}, nil
+		}
+		return nil, err
+	}
+	forwarders, err := parseDNSForwarders(cm.Data[dnsForwardingKey])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"configmap": dnsForwardingConfigMap,
+		}).WithError(err).Error("Invalid DNS forwarding configuration")
+		return nil, err
+	}
+	return forwarders, nil
+}
+
+const (
+	// DNSViewInternal makes the node-local DNS answer api.<domain> with the
+	// targets used by in-cluster clients.
+	DNSViewInternal = "internal"
+	// DNSViewExternal makes the node-local DNS answer api.<domain> with the
+	// targets used by clients outside the cluster.
+	DNSViewExternal = "external"
+)
+
+// ValidateDNSView checks that view is a known DNS view
+func ValidateDNSView(view string) error {
+	switch view {
+	case DNSViewInternal, DNSViewExternal:
+		return nil
+	}
+	return fmt.Errorf("Unknown DNS view %q, expected %s or %s", view, DNSViewInternal, DNSViewExternal)
+}
+
+// PopulateAPIResolution computes the internal and external targets for
+// api.<domain> and selects the ones served according to view. Internal
+// clients prefer the on-prem VIPs and fall back to the internal cloud LBs,
+// external clients prefer the external cloud LBs and fall back to the VIPs.
+func PopulateAPIResolution(node *Node, view string) error {
+	vips := []string{}
+	if node.Configs != nil {
+		for _, c := range *node.Configs {
+			if c.Cluster.APIVIP != "" {
+				vips = append(vips, c.Cluster.APIVIP)
+			}
+		}
+	} else if node.Cluster.APIVIP != "" {
+		vips = append(vips, node.Cluster.APIVIP)
+	}
+
+	node.Cluster.APIInternalIPs = vips
+	if len(node.Cluster.APIInternalIPs) == 0 {
+		node.Cluster.APIInternalIPs = node.Cluster.APIIntLBIPs
+	}
+	node.Cluster.APIExternalIPs = node.Cluster.APILBIPs
+	if len(node.Cluster.APIExternalIPs) == 0 {
+		node.Cluster.APIExternalIPs = vips
+	}
+
+	switch view {
+	case "", DNSViewInternal:
+		node.Cluster.APIServedIPs = node.Cluster.APIInternalIPs
+	case DNSViewExternal:
+		node.Cluster.APIServedIPs = node.Cluster.APIExternalIPs
+	default:
+		return fmt.Errorf("Unknown DNS view %s, must be %s or %s", view, DNSViewInternal, DNSViewExternal)
+	}
+	return nil
+}
+
+// PTRRecord maps Address, by its reverse lookup name PTRName, to Target
+type PTRRecord struct {
+	Address string
+	PTRName string
+	Target  string
+}
+
+// ReverseZone is the in-addr.arpa or ip6.arpa zone of a machine network with
+// the PTR records of the nodes and VIPs in it. Zone is the network rounded
+// down to an octet (IPv4) or nibble (IPv6) boundary, e.g. 1.168.192.in-addr.arpa
+// for 192.168.1.0/24 and 168.192.in-addr.arpa for 192.168.0.0/22.
+type ReverseZone struct {
+	Zone    string
+	Network string
+	Records []PTRRecord
+}
+
+// machineNetworkOf returns the local network holding ip, swapped out by the
+// tests
+var machineNetworkOf = func(ip net.IP) (*net.IPNet, error) {
+	_, network, err := utils.GetInterfaceWithCidrByIP(ip, false)
+	return network, err
+}
+
+// reverseZoneName returns the reverse zone of network, rounded down to an
+// octet or nibble boundary
+func reverseZoneName(network *net.IPNet) string {
+	ones, bits := network.Mask.Size()
+	labels := strings.Split(utils.ReverseAddr(network.IP), ".")
+	// The address labels are followed by in-addr.arpa or ip6.arpa
+	addressLabels := len(labels) - 2
+	if network.IP.To4() != nil {
+		ones -= bits - 8*net.IPv4len
+		return strings.Join(labels[addressLabels-ones/8:], ".")
+	}
+	return strings.Join(labels[addressLabels-ones/4:], ".")
+}
+
+// ReverseZones returns the reverse zones of networks with the PTR records of
+// the addresses of targets in them, sorted by zone and address. targets maps
+// the addresses to their names, an address outside of networks gets no record.
+func ReverseZones(networks []*net.IPNet, targets map[string]string) []ReverseZone {
+	zones := []ReverseZone{}
+	seen := map[string]bool{}
+	for _, network := range networks {
+		if seen[network.String()] {
+			continue
+		}
+		seen[network.String()] = true
+
+		zone := ReverseZone{Zone: reverseZoneName(network), Network: network.String(), Records: []PTRRecord{}}
+		for address, target := range targets {
+			ip := net.ParseIP(address)
+			if ip == nil || !network.Contains(ip) {
+				continue
+			}
+			zone.Records = append(zone.Records, PTRRecord{Address: ip.String(), PTRName: utils.ReverseAddr(ip), Target: target})
+		}
+		sort.Slice(zone.Records, func(i, j int) bool {
+			return zone.Records[i].PTRName < zone.Records[j].PTRName
+		})
+		zones = append(zones, zone)
+	}
+	sort.SliceStable(zones, func(i, j int) bool {
+		return zones[i].Zone < zones[j].Zone
+	})
+	return zones
+}
+
+// PopulateReverseZones computes the reverse zones of the machine networks of
+// the VIPs with the PTR records of the nodes, <name>.<domain>, the API VIP,
+// api-int.<domain>, and the ingress VIP, ingress.apps.<domain> which the
+// *.apps wildcard resolves back. It must run after the node addresses are
+// populated.
+func PopulateReverseZones(node *Node) {
+	clusters := []Cluster{node.Cluster}
+	if node.Configs != nil {
+		clusters = []Cluster{}
+		for _, c := range *node.Configs {
+			clusters = append(clusters, c.Cluster)
+		}
+	}
+
+	domain := node.Cluster.Domain
+	targets := map[string]string{}
+	networks := []*net.IPNet{}
+	addVIP := func(vip, target string) {
+		ip := net.ParseIP(vip)
+		if ip == nil {
+			return
+		}
+		targets[ip.String()] = target
+		network, err := machineNetworkOf(ip)
+		if err != nil || network == nil {
+			log.WithFields(logrus.Fields{
+				"vip": vip,
+			}).WithError(err).Debug("No local machine network for the VIP, not serving its reverse zone")
+			return
+		}
+		networks = append(networks, network)
+	}
+	for _, c := range clusters {
+		addVIP(c.APIVIP, "api-int."+domain)
+		addVIP(c.IngressVIP, "ingress.apps."+domain)
+	}
+	for _, address := range node.Cluster.NodeAddresses {
+		if ip := net.ParseIP(address.Address); ip != nil {
+			if _, ok := targets[ip.String()]; !ok {
+				targets[ip.String()] = address.Name + "." + domain
+			}
+		}
+	}
+	node.Cluster.ReverseZones = ReverseZones(networks, targets)
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("parseDNSForwarders", func() {
+	It("parses, defaults and sorts forwarders", func() {
+		forwarders, err := parseDNSForwarders(`
+- zone: corp.example.com.
+  upstreams: ["10.0.0.53", "10.0.1.53:5353"]
+- zone: bar.example.com
+  upstreams: ["fd00::53"]
+  protocol: tls
+  tlsServerName: dns.example.com
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(forwarders).To(Equal([]DNSForwarder{
+			{Zone: "bar.example.com", Upstreams: []string{"fd00::53"}, Protocol: "tls", TLSServerName: "dns.example.com"},
+			{Zone: "corp.example.com", Upstreams: []string{"10.0.0.53", "10.0.1.53:5353"}, Protocol: "dns"},
+		}))
+	})
+
+	It("returns no forwarders for empty data", func() {
+		forwarders, err := parseDNSForwarders("")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(forwarders).To(BeEmpty())
+	})
+
+	It("rejects invalid entries", func() {
+		for _, data := range []string{
+			`[{"zone": "", "upstreams": ["10.0.0.53"]}]`,
+			`[{"zone": "a.example.com", "upstreams": []}]`,
+			`[{"zone": "a.example.com", "upstreams": ["dns.example.com"]}]`,
+			`[{"zone": "a.example.com", "upstreams": ["10.0.0.53"], "protocol": "https"}]`,
+			`[{"zone": "a.example.com", "upstreams": ["10.0.0.53"]}, {"zone": "a.example.com.", "upstreams": ["10.0.0.54"]}]`,
+		} {
+			_, err := parseDNSForwarders(data)
+			Expect(err).Should(HaveOccurred(), data)
+		}
+	})
+})
+
+var _ = Describe("PopulateAPIResolution", func() {
+	hybrid := func() Node {
+		n := Node{Cluster: Cluster{
+			APIVIP:      "192.168.1.5",
+			APILBIPs:    []string{"203.0.113.10"},
+			APIIntLBIPs: []string{"10.0.0.10"},
+		}}
+		return n
+	}
+
+	It("serves the VIP for the internal view", func() {
+		n := hybrid()
+		Expect(PopulateAPIResolution(&n, DNSViewInternal)).To(Succeed())
+		Expect(n.Cluster.APIInternalIPs).To(Equal([]string{"192.168.1.5"}))
+		Expect(n.Cluster.APIExternalIPs).To(Equal([]string{"203.0.113.10"}))
+		Expect(n.Cluster.APIServedIPs).To(Equal([]string{"192.168.1.5"}))
+	})
+
+	It("serves the external LB for the external view", func() {
+		n := hybrid()
+		Expect(PopulateAPIResolution(&n, DNSViewExternal)).To(Succeed())
+		Expect(n.Cluster.APIServedIPs).To(Equal([]string{"203.0.113.10"}))
+	})
+
+	It("falls back when one side is missing", func() {
+		n := Node{Cluster: Cluster{APIIntLBIPs: []string{"10.0.0.10"}}}
+		Expect(PopulateAPIResolution(&n, DNSViewInternal)).To(Succeed())
+		Expect(n.Cluster.APIServedIPs).To(Equal([]string{"10.0.0.10"}))
+
+		n = Node{Cluster: Cluster{APIVIP: "192.168.1.5"}}
+		Expect(PopulateAPIResolution(&n, DNSViewExternal)).To(Succeed())
+		Expect(n.Cluster.APIServedIPs).To(Equal([]string{"192.168.1.5"}))
+	})
+
+	It("rejects unknown views", func() {
+		n := hybrid()
+		Expect(PopulateAPIResolution(&n, "public")).ToNot(Succeed())
+		Expect(ValidateDNSView(DNSViewExternal)).To(Succeed())
+		Expect(ValidateDNSView("public")).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("PopulateReverseZones", func() {
+	var networks map[string]string
+
+	BeforeEach(func() {
+		networks = map[string]string{
+			"192.168.1.5":  "192.168.0.0/22",
+			"192.168.1.10": "192.168.0.0/22",
+			"fd00::5":      "fd00::/64",
+		}
+		machineNetworkOf = func(ip net.IP) (*net.IPNet, error) {
+			cidr, ok := networks[ip.String()]
+			if !ok {
+				return nil, fmt.Errorf("No interface holds %s", ip)
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			return network, err
+		}
+	})
+
+	AfterEach(func() {
+		machineNetworkOf = func(ip net.IP) (*net.IPNet, error) {
+			_, network, err := utils.GetInterfaceWithCidrByIP(ip, false)
+			return network, err
+		}
+	})
+
+	It("names the zones on octet and nibble boundaries", func() {
+		for cidr, zone := range map[string]string{
+			"192.168.1.0/24":      "1.168.192.in-addr.arpa",
+			"192.168.0.0/22":      "168.192.in-addr.arpa",
+			"10.0.0.0/8":          "10.in-addr.arpa",
+			"fd00::/64":           "0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa",
+			"2001:db8:a:b0::/62":  "b.0.0.a.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+			"::ffff:10.1.0.0/112": "1.10.in-addr.arpa",
+		} {
+			_, network, err := net.ParseCIDR(cidr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reverseZoneName(network)).To(Equal(zone), cidr)
+		}
+	})
+
+	It("serves the records of the nodes and VIPs in the machine networks", func() {
+		n := Node{Cluster: Cluster{
+			Domain:     "ostest.test.metalkube.org",
+			APIVIP:     "192.168.1.5",
+			IngressVIP: "192.168.1.10",
+			NodeAddresses: []NodeAddress{
+				{Address: "192.168.3.21", Name: "master-1"},
+				{Address: "192.168.0.20", Name: "master-0"},
+				{Address: "10.0.0.20", Name: "master-0"},
+			},
+		}}
+		PopulateReverseZones(&n)
+		Expect(n.Cluster.ReverseZones).To(Equal([]ReverseZone{{
+			Zone:    "168.192.in-addr.arpa",
+			Network: "192.168.0.0/22",
+			Records: []PTRRecord{
+				{Address: "192.168.1.10", PTRName: "10.1.168.192.in-addr.arpa", Target: "ingress.apps.ostest.test.metalkube.org"},
+				{Address: "192.168.0.20", PTRName: "20.0.168.192.in-addr.arpa", Target: "master-0.ostest.test.metalkube.org"},
+				{Address: "192.168.3.21", PTRName: "21.3.168.192.in-addr.arpa", Target: "master-1.ostest.test.metalkube.org"},
+				{Address: "192.168.1.5", PTRName: "5.1.168.192.in-addr.arpa", Target: "api-int.ostest.test.metalkube.org"},
+			},
+		}}))
+	})
+
+	It("serves a zone per family of a dual stack cluster", func() {
+		v4 := Node{Cluster: Cluster{Domain: "example.com", APIVIP: "192.168.1.5"}}
+		v6 := Node{Cluster: Cluster{Domain: "example.com", APIVIP: "fd00::5", IngressVIP: "fd00::7"}}
+		n := Node{
+			Cluster: Cluster{
+				Domain:        "example.com",
+				APIVIP:        "192.168.1.5",
+				NodeAddresses: []NodeAddress{{Address: "fd00::20", Name: "master-0"}},
+			},
+			Configs: &[]Node{v4, v6},
+		}
+		PopulateReverseZones(&n)
+		Expect(n.Cluster.ReverseZones).To(HaveLen(2))
+		Expect(n.Cluster.ReverseZones[0].Zone).To(Equal("0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa"))
+		Expect(n.Cluster.ReverseZones[0].Records).To(ConsistOf(
+			PTRRecord{Address: "fd00::5", PTRName: utils.ReverseAddr(net.ParseIP("fd00::5")), Target: "api-int.example.com"},
+			PTRRecord{Address: "fd00::7", PTRName: utils.ReverseAddr(net.ParseIP("fd00::7")), Target: "ingress.apps.example.com"},
+			PTRRecord{Address: "fd00::20", PTRName: utils.ReverseAddr(net.ParseIP("fd00::20")), Target: "master-0.example.com"},
+		))
+		Expect(n.Cluster.ReverseZones[1].Zone).To(Equal("168.192.in-addr.arpa"))
+		Expect(n.Cluster.ReverseZones[1].Records).To(HaveLen(1))
+	})
+})
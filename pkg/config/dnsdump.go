@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// appsRepresentatives are routes of every cluster, standing for the *.apps
+// wildcard in the DNS records
+var appsRepresentatives = []string{"console-openshift-console", "oauth-openshift", "canary-openshift-ingress-canary"}
+
+// shardRepresentative stands for the wildcard of an ingress shard. Any name
+// under the domain of the shard resolves to its VIP.
+const shardRepresentative = "canary-openshift-ingress-canary"
+
+// DNSRecord is a record the node-local DNS server is expected to serve
+type DNSRecord struct {
+	Name   string
+	Type   string
+	Target string
+}
+
+// addressRecord returns the A or AAAA record of name for address
+func addressRecord(name, address string) DNSRecord {
+	recordType := "A"
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		recordType = "AAAA"
+	}
+	return DNSRecord{Name: name, Type: recordType, Target: address}
+}
+
+// DNSRecords returns the records runtimecfg renders for node, sorted by name
+// and target: api-int and api, the *.apps wildcard through a few routes of
+// every cluster, the wildcards of the ingress shards through one name each,
+// the nodes and the PTR records of the reverse zones. node is expected to be
+// populated as the coredns monitor does.
+func DNSRecords(node Node) []DNSRecord {
+	clusters := []Cluster{node.Cluster}
+	if node.Configs != nil {
+		clusters = []Cluster{}
+		for _, c := range *node.Configs {
+			clusters = append(clusters, c.Cluster)
+		}
+	}
+	domain := node.Cluster.Domain
+
+	records := []DNSRecord{}
+	seen := map[DNSRecord]bool{}
+	add := func(r DNSRecord) {
+		if r.Target != "" && !seen[r] {
+			seen[r] = true
+			records = append(records, r)
+		}
+	}
+
+	for _, c := range clusters {
+		add(addressRecord("api-int."+domain, c.APIVIP))
+	}
+	for _, ip := range node.Cluster.APIServedIPs {
+		add(addressRecord("api."+domain, ip))
+	}
+	if !node.Cluster.NoReadyRouters {
+		ingressIPs := node.Cluster.IngressLBIPs
+		if len(ingressIPs) == 0 {
+			for _, c := range clusters {
+				ingressIPs = append(ingressIPs, c.IngressVIP)
+			}
+		}
+		for _, route := range appsRepresentatives {
+			for _, ip := range ingressIPs {
+				add(addressRecord(route+".apps."+domain, ip))
+			}
+		}
+	}
+	for _, shard := range node.IngressShards {
+		add(addressRecord(shardRepresentative+"."+shard.Domain, shard.VIP))
+	}
+	for _, address := range node.Cluster.NodeAddresses {
+		add(addressRecord(address.Name+"."+domain, address.Address))
+	}
+	for _, zone := range node.Cluster.ReverseZones {
+		for _, r := range zone.Records {
+			add(DNSRecord{Name: r.PTRName, Type: "PTR", Target: r.Target})
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Target < records[j].Target
+	})
+	return records
+}
+
+// WriteHosts writes records in the hosts file format, one address and name
+// per line. The PTR records, which have no hosts form, are written as
+// comments.
+func WriteHosts(w io.Writer, records []DNSRecord) error {
+	for _, r := range records {
+		line := fmt.Sprintf("%s %s\n", r.Target, r.Name)
+		if r.Type == "PTR" {
+			line = fmt.Sprintf("# %s PTR %s\n", r.Name, r.Target)
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DNSRecords", func() {
+	node := func() Node {
+		return Node{
+			Cluster: Cluster{
+				Domain:       "ostest.test.metalkube.org",
+				APIVIP:       "192.168.111.5",
+				IngressVIP:   "192.168.111.4",
+				APIServedIPs: []string{"192.168.111.5"},
+				NodeAddresses: []NodeAddress{
+					{Address: "192.168.111.20", Name: "master-0"},
+					{Address: "fd2e:6f44:5dd8:c956::14", Name: "master-0"},
+				},
+				ReverseZones: []ReverseZone{{
+					Zone:    "111.168.192.in-addr.arpa",
+					Records: []PTRRecord{{Address: "192.168.111.20", PTRName: "20.111.168.192.in-addr.arpa", Target: "master-0.ostest.test.metalkube.org"}},
+				}},
+			},
+			IngressShards: []IngressShard{{Name: "internal", Domain: "internal.example.com", VIP: "192.168.111.9"}},
+		}
+	}
+
+	It("lists the records of the node-local DNS server", func() {
+		var out bytes.Buffer
+		Expect(WriteHosts(&out, DNSRecords(node()))).To(Succeed())
+		Expect(out.String()).To(Equal(`# 20.111.168.192.in-addr.arpa PTR master-0.ostest.test.metalkube.org
+192.168.111.5 api-int.ostest.test.metalkube.org
+192.168.111.5 api.ostest.test.metalkube.org
+192.168.111.4 canary-openshift-ingress-canary.apps.ostest.test.metalkube.org
+192.168.111.9 canary-openshift-ingress-canary.internal.example.com
+192.168.111.4 console-openshift-console.apps.ostest.test.metalkube.org
+192.168.111.20 master-0.ostest.test.metalkube.org
+fd2e:6f44:5dd8:c956::14 master-0.ostest.test.metalkube.org
+192.168.111.4 oauth-openshift.apps.ostest.test.metalkube.org
+`))
+	})
+
+	It("prefers the ingress load balancers and leaves out the gated wildcard", func() {
+		n := node()
+		n.Cluster.IngressLBIPs = []string{"10.0.0.4"}
+		Expect(DNSRecords(n)).To(ContainElement(DNSRecord{Name: "oauth-openshift.apps.ostest.test.metalkube.org", Type: "A", Target: "10.0.0.4"}))
+		Expect(DNSRecords(n)).NotTo(ContainElement(HaveField("Target", "192.168.111.4")))
+
+		n.Cluster.NoReadyRouters = true
+		Expect(DNSRecords(n)).NotTo(ContainElement(HaveField("Target", "10.0.0.4")))
+		Expect(DNSRecords(n)).To(ContainElement(DNSRecord{Name: "master-0.ostest.test.metalkube.org", Type: "AAAA", Target: "fd2e:6f44:5dd8:c956::14"}))
+	})
+})
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// BackendSourceNodes derives the API backends from the master nodes
+	BackendSourceNodes = "nodes"
+	// BackendSourceEndpointSlices derives the API backends from the ready
+	// endpoints of the default/kubernetes service, so a master whose
+	// kube-apiserver is down is not a backend even if the node is Ready
+	BackendSourceEndpointSlices = "endpointslices"
+
+	apiServiceNamespace = "default"
+	apiServiceName      = "kubernetes"
+
+	// The service of the routers of the default IngressController
+	routerServiceNamespace = "openshift-ingress"
+	routerServiceName      = "router-internal-default"
+)
+
+// ValidateBackendSource checks that source is a known backend source
+func ValidateBackendSource(source string) error {
+	switch source {
+	case BackendSourceNodes, BackendSourceEndpointSlices:
+		return nil
+	}
+	return fmt.Errorf("Unknown API backend source %q, expected %s or %s", source, BackendSourceNodes, BackendSourceEndpointSlices)
+}
+
+// listServiceEndpointSlices returns the EndpointSlices of the service
+// namespace/name
+func listServiceEndpointSlices(apiServerURL, kubeconfigPath, namespace, name string) ([]discoveryv1.EndpointSlice, error) {
+	clientset, err := utils.SharedKubeClient(apiServerURL, kubeconfigPath).Clientset()
+	if err != nil {
+		return nil, err
+	}
+	slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Items, nil
+}
+
+// listAPIEndpointSlices is swapped out by the tests
+var listAPIEndpointSlices = func(apiServerURL, kubeconfigPath string) ([]discoveryv1.EndpointSlice, error) {
+	return listServiceEndpointSlices(apiServerURL, kubeconfigPath, apiServiceNamespace, apiServiceName)
+}
+
+// listRouterEndpointSlices is swapped out by the tests
+var listRouterEndpointSlices = func(kubeconfigPath string) ([]discoveryv1.EndpointSlice, error) {
+	return listServiceEndpointSlices("", kubeconfigPath, routerServiceNamespace, routerServiceName)
+}
+
+// hasReadyEndpoint returns whether an endpoint with an address in slices is
+// ready, of any IP family
+func hasReadyEndpoint(slices []discoveryv1.EndpointSlice) bool {
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if len(endpoint.Addresses) > 0 && (endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoutersReady returns whether at least one router of the default
+// IngressController is a ready endpoint of its service, i.e. whether the
+// ingress VIP has a healthy backend to send the *.apps traffic to
+func RoutersReady(kubeconfigPath string) (bool, error) {
+	slices, err := listRouterEndpointSlices(kubeconfigPath)
+	if err != nil {
+		return false, err
+	}
+	return hasReadyEndpoint(slices), nil
+}
+
+// readyEndpointAddresses returns the addresses of the ready endpoints in
+// slices that belong to the IP family of vip. An endpoint without a ready
+// condition is ready, as the API defines it.
+func readyEndpointAddresses(slices []discoveryv1.EndpointSlice, vip net.IP) []string {
+	addresses := []string{}
+	seen := map[string]bool{}
+	for _, slice := range slices {
+		if utils.IsIPv6(vip) && slice.AddressType != discoveryv1.AddressTypeIPv6 ||
+			!utils.IsIPv6(vip) && slice.AddressType != discoveryv1.AddressTypeIPv4 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				if !seen[address] {
+					seen[address] = true
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+	return addresses
+}
+
+// endpointBackends returns a backend per address, named after the node
+// that has the address, or after the address when no node has it
+func endpointBackends(addresses []string, nodes []v1.Node) []Backend {
+	hosts := map[string]string{}
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			hosts[address.Address] = node.Name
+		}
+	}
+	backends := []Backend{}
+	for _, address := range addresses {
+		host, ok := hosts[address]
+		if !ok {
+			host = address
+		}
+		backends = append(backends, Backend{Host: host, Address: address})
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Address < backends[j].Address
+	})
+	return backends
+}
+
+// EndpointSliceBackends returns the API backends, one per ready endpoint of
+// the default/kubernetes service sorted by address. When no endpoint is
+// ready, which is also the case before the first kube-apiserver published
+// its endpoint, the backends are derived from the master nodes instead.
+func (c *NodeCache) EndpointSliceBackends(opts Options, apiServerURL, kubeconfigPath string, vips []net.IP) ([]Backend, error) {
+	if len(vips) == 0 {
+		return []Backend{}, fmt.Errorf("Trying to build config using empty VIPs")
+	}
+	slices, err := listAPIEndpointSlices(apiServerURL, kubeconfigPath)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Info("Failed to get the API EndpointSlices")
+		return []Backend{}, err
+	}
+	addresses := readyEndpointAddresses(slices, vips[0])
+	if len(addresses) == 0 {
+		log.Warn("No ready API endpoint, falling back to the master nodes")
+		return c.Backends(opts, apiServerURL, kubeconfigPath, vips)
+	}
+	nodes, err := c.List(apiServerURL, kubeconfigPath, labelNodeRolePrefix+"master=")
+	if err != nil {
+		// The backends are still usable, only named after their address
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Warn("Failed to get master Nodes list to name the API backends")
+	}
+	arbiters, err := c.List(apiServerURL, kubeconfigPath, labelNodeRoleArbiter+"=")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Warn("Failed to get arbiter Nodes list to leave them out of the API backends")
+	}
+	addresses = withoutNodeAddresses(addresses, arbiters)
+	if opts.Sites.Enabled() {
+		all, err := c.List(apiServerURL, kubeconfigPath, "")
+		if err != nil {
+			return []Backend{}, err
+		}
+		_, others, err := c.splitSites(opts.Sites, apiServerURL, kubeconfigPath, all)
+		if err != nil {
+			return []Backend{}, err
+		}
+		addresses = withoutNodeAddresses(addresses, others)
+	}
+	return endpointBackends(addresses, nodes), nil
+}
+
+// withoutNodeAddresses returns the addresses that none of nodes has
+func withoutNodeAddresses(addresses []string, nodes []v1.Node) []string {
+	owned := map[string]bool{}
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			owned[address.Address] = true
+		}
+	}
+	kept := []string{}
+	for _, address := range addresses {
+		if !owned[address] {
+			kept = append(kept, address)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("EndpointSlice backends", func() {
+	ready, notReady := true, false
+	slices := []discoveryv1.EndpointSlice{{
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"192.168.111.22"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+			{Addresses: []string{"192.168.111.21"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+			{Addresses: []string{"192.168.111.20"}},
+		},
+	}, {
+		AddressType: discoveryv1.AddressTypeIPv6,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"fd2e:6f44:5dd8:c956::14"}}},
+	}}
+
+	It("keeps_the_ready_endpoints_of_the_vip_family", func() {
+		Expect(readyEndpointAddresses(slices, net.ParseIP("192.168.111.5"))).To(Equal([]string{"192.168.111.22", "192.168.111.20"}))
+		Expect(readyEndpointAddresses(slices, net.ParseIP("fd2e:6f44:5dd8:c956::16"))).To(Equal([]string{"fd2e:6f44:5dd8:c956::14"}))
+	})
+
+	It("names_the_backends_after_their_node", func() {
+		nodes := []v1.Node{{
+			ObjectMeta: metav1.ObjectMeta{Name: "master-0"},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.111.20"}}},
+		}}
+		Expect(endpointBackends([]string{"192.168.111.22", "192.168.111.20"}, nodes)).To(Equal([]Backend{
+			{Host: "master-0", Address: "192.168.111.20"},
+			{Host: "192.168.111.22", Address: "192.168.111.22"},
+		}))
+	})
+
+	It("finds_a_ready_router", func() {
+		defer func(list func(string) ([]discoveryv1.EndpointSlice, error)) { listRouterEndpointSlices = list }(listRouterEndpointSlices)
+		routers := []discoveryv1.EndpointSlice{}
+		listRouterEndpointSlices = func(string) ([]discoveryv1.EndpointSlice, error) { return routers, nil }
+
+		Expect(RoutersReady("")).To(BeFalse())
+		routers = []discoveryv1.EndpointSlice{{
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"192.168.111.24"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}}},
+		}}
+		Expect(RoutersReady("")).To(BeFalse())
+		routers = append(routers, slices[1])
+		Expect(RoutersReady("")).To(BeTrue())
+	})
+
+	It("validates_the_source", func() {
+		Expect(ValidateBackendSource(BackendSourceEndpointSlices)).To(Succeed())
+		Expect(ValidateBackendSource("endpoints")).NotTo(Succeed())
+	})
+})
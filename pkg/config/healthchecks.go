@@ -0,0 +1,193 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	healthChecksConfigMap = "keepalived-health-checks"
+	// healthCheckInstanceAPI and healthCheckInstanceIngress are the VRRP
+	// instances a health check can track
+	healthCheckInstanceAPI     = "api"
+	healthCheckInstanceIngress = "ingress"
+)
+
+// healthCheckName is what a key of the ConfigMap can be, it ends up in the
+// name of the vrrp_script
+var healthCheckName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// healthCheckSpec is a value of the keepalived-health-checks ConfigMap.
+// Exactly one of URL, Port and Path is set.
+type healthCheckSpec struct {
+	// URL is an http or https URL that must answer with a success
+	URL string `yaml:"url"`
+	// Port is a local TCP port that must accept connections
+	Port int `yaml:"port"`
+	// Path is an executable that must exit with 0
+	Path string `yaml:"path"`
+	// Interval is how often the check runs in seconds, 1 when not set
+	Interval int `yaml:"interval"`
+	// Weight is added to the priority of the node while the check passes
+	// when positive, subtracted while it fails when negative
+	Weight int `yaml:"weight"`
+	// Instances are the VRRP instances that track the check, api and
+	// ingress when not set
+	Instances []string `yaml:"instances"`
+}
+
+// HealthCheck is a vrrp_script of the keepalived configuration defined by
+// the user in the keepalived-health-checks ConfigMap
+type HealthCheck struct {
+	Name      string
+	Script    string
+	Interval  int
+	Weight    int
+	Instances []string
+}
+
+// Tracks tells whether the VRRP instance, api or ingress, tracks the check
+func (c HealthCheck) Tracks(instance string) bool {
+	for _, i := range c.Instances {
+		if i == instance {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHealthCheck validates the check name defined by value and builds
+// its script
+func parseHealthCheck(name, value string) (HealthCheck, error) {
+	check := HealthCheck{Name: name}
+	if !healthCheckName.MatchString(name) {
+		return check, fmt.Errorf("Invalid health check name %s, must be up to 32 lowercase letters, digits and underscores", name)
+	}
+	spec := healthCheckSpec{}
+	if err := yaml.UnmarshalStrict([]byte(value), &spec); err != nil {
+		return check, fmt.Errorf("Invalid health check %s: %w", name, err)
+	}
+
+	set := 0
+	for _, s := range []bool{spec.URL != "", spec.Port != 0, spec.Path != ""} {
+		if s {
+			set++
+		}
+	}
+	if set != 1 {
+		return check, fmt.Errorf("Health check %s must have exactly one of url, port and path", name)
+	}
+	// The script ends up between double quotes in the configuration
+	for _, s := range []string{spec.URL, spec.Path} {
+		if strings.ContainsAny(s, "\"'\\ \t\n") {
+			return check, fmt.Errorf("Health check %s must not have quotes, backslashes or spaces", name)
+		}
+	}
+	switch {
+	case spec.URL != "":
+		u, err := url.Parse(spec.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return check, fmt.Errorf("Invalid health check %s url %s, must be an http or https URL", name, spec.URL)
+		}
+	case spec.Port != 0:
+		if spec.Port < 1 || spec.Port > 65535 {
+			return check, fmt.Errorf("Invalid health check %s port %d", name, spec.Port)
+		}
+	default:
+		if !strings.HasPrefix(spec.Path, "/") {
+			return check, fmt.Errorf("Invalid health check %s path %s, must be absolute", name, spec.Path)
+		}
+	}
+
+	check.Interval = spec.Interval
+	if check.Interval == 0 {
+		check.Interval = 1
+	}
+	if check.Interval < 1 || check.Interval > 3600 {
+		return check, fmt.Errorf("Invalid health check %s interval %d, must be 1 to 3600 seconds", name, spec.Interval)
+	}
+	check.Weight = spec.Weight
+	if check.Weight < -253 || check.Weight > 253 {
+		return check, fmt.Errorf("Invalid health check %s weight %d, must be -253 to 253", name, spec.Weight)
+	}
+	check.Instances = spec.Instances
+	if len(check.Instances) == 0 {
+		check.Instances = []string{healthCheckInstanceAPI, healthCheckInstanceIngress}
+	}
+	for _, i := range check.Instances {
+		if i != healthCheckInstanceAPI && i != healthCheckInstanceIngress {
+			return check, fmt.Errorf("Invalid health check %s instance %s, must be api or ingress", name, i)
+		}
+	}
+
+	switch {
+	case spec.URL != "":
+		// A check must not outlive its interval, keepalived would run the
+		// next one concurrently
+		check.Script = fmt.Sprintf("curl -o /dev/null -kLfs --max-time %d %s", check.Interval, spec.URL)
+	case spec.Port != 0:
+		check.Script = fmt.Sprintf("timeout %d bash -c '</dev/tcp/127.0.0.1/%d'", check.Interval, spec.Port)
+	default:
+		check.Script = spec.Path
+	}
+	return check, nil
+}
+
+// parseHealthChecks validates the data of the keepalived-health-checks
+// ConfigMap. The checks are sorted by name, so that the rendered
+// configuration does not change with the order of the map.
+func parseHealthChecks(data map[string]string) ([]HealthCheck, error) {
+	checks := []HealthCheck{}
+	for name, value := range data {
+		check, err := parseHealthCheck(name, value)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	return checks, nil
+}
+
+// GetHealthChecks reads the user defined health checks from the
+// keepalived-health-checks ConfigMap in the pod namespace. A missing
+// ConfigMap is not an error and results in no checks.
+func GetHealthChecks(kubeconfigPath string) ([]HealthCheck, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(os.Getenv("POD_NAMESPACE")).Get(context.TODO(), healthChecksConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	checks, err := parseHealthChecks(cm.Data)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"configmap": healthChecksConfigMap,
+		}).WithError(err).Error("Invalid health checks")
+		return nil, err
+	}
+	return checks, nil
+}
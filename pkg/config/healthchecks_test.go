@@ -0,0 +1,43 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthChecks", func() {
+	It("builds the script of each kind of check", func() {
+		checks, err := parseHealthChecks(map[string]string{
+			"web":    "url: http://127.0.0.1:8080/healthz\ninterval: 2\nweight: 20\ninstances: [ingress]\n",
+			"db":     "port: 5432\nweight: -30\n",
+			"custom": "path: /usr/local/bin/check\n",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(checks).To(Equal([]HealthCheck{
+			{Name: "custom", Script: "/usr/local/bin/check", Interval: 1, Instances: []string{"api", "ingress"}},
+			{Name: "db", Script: "timeout 1 bash -c '</dev/tcp/127.0.0.1/5432'", Interval: 1, Weight: -30, Instances: []string{"api", "ingress"}},
+			{Name: "web", Script: "curl -o /dev/null -kLfs --max-time 2 http://127.0.0.1:8080/healthz", Interval: 2, Weight: 20, Instances: []string{"ingress"}},
+		}))
+		Expect(checks[2].Tracks("api")).To(BeFalse())
+		Expect(checks[2].Tracks("ingress")).To(BeTrue())
+	})
+
+	It("rejects invalid checks", func() {
+		for name, value := range map[string]string{
+			"Bad-Name": "port: 80\n",
+			"none":     "interval: 2\n",
+			"both":     "port: 80\npath: /bin/true\n",
+			"scheme":   "url: ftp://127.0.0.1/\n",
+			"quotes":   "url: http://127.0.0.1/\"\n",
+			"relative": "path: bin/check\n",
+			"port":     "port: 70000\n",
+			"interval": "port: 80\ninterval: 5000\n",
+			"weight":   "port: 80\nweight: 254\n",
+			"instance": "port: 80\ninstances: [dns]\n",
+			"unknown":  "port: 80\ntimeout: 2\n",
+		} {
+			_, err := parseHealthCheck(name, value)
+			Expect(err).To(HaveOccurred(), name)
+		}
+	})
+})
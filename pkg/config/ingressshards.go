@@ -0,0 +1,176 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	ingressControllerNamespace = "openshift-ingress-operator"
+	// IngressShardVIPsAnnotation lists the VIPs of a sharded IngressController,
+	// comma separated. The controllers without it are served by the ingress
+	// VIPs of the cluster.
+	IngressShardVIPsAnnotation = "baremetal-runtimecfg.openshift.io/ingress-vips"
+)
+
+// IngressShard is one VIP of an IngressController that has its own ingress
+// VIPs. A controller with an IPv4 and an IPv6 VIP has a shard for each.
+type IngressShard struct {
+	// Name is the name of the IngressController
+	Name string
+	// Domain is the domain of the routes of the controller, *.Domain
+	// resolves to VIP
+	Domain          string
+	VIP             string
+	VIPRecordType   string
+	VIPEmptyType    string
+	VirtualRouterID uint8
+	// Peers are the addresses of the nodes matching the node placement of
+	// the controller, the keepalived unicast peers of VIP
+	Peers []string
+	// Eligible is set when the node matches the node placement, only those
+	// nodes may hold VIP
+	Eligible bool
+}
+
+type ingressControllerList struct {
+	Items []struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			Domain        string `json:"domain"`
+			NodePlacement *struct {
+				NodeSelector *metav1.LabelSelector `json:"nodeSelector"`
+			} `json:"nodePlacement"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// parseIngressShards returns the shards of the IngressControllers in data
+// that have IngressShardVIPsAnnotation, in the order of the list, and the
+// node selector of each
+func parseIngressShards(data []byte) ([]IngressShard, []labels.Selector, error) {
+	list := ingressControllerList{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, nil, err
+	}
+	shards := []IngressShard{}
+	selectors := []labels.Selector{}
+	for _, ic := range list.Items {
+		vips := ic.Metadata.Annotations[IngressShardVIPsAnnotation]
+		if vips == "" {
+			continue
+		}
+		selector := labels.Everything()
+		if ic.Spec.NodePlacement != nil && ic.Spec.NodePlacement.NodeSelector != nil {
+			var err error
+			if selector, err = metav1.LabelSelectorAsSelector(ic.Spec.NodePlacement.NodeSelector); err != nil {
+				return nil, nil, fmt.Errorf("Invalid node placement of IngressController %s: %w", ic.Metadata.Name, err)
+			}
+		}
+		for _, vip := range strings.Split(vips, ",") {
+			ip := net.ParseIP(strings.TrimSpace(vip))
+			if ip == nil {
+				return nil, nil, fmt.Errorf("Invalid VIP %q of IngressController %s", vip, ic.Metadata.Name)
+			}
+			shard := IngressShard{Name: ic.Metadata.Name, Domain: ic.Spec.Domain, VIP: ip.String(), VIPRecordType: "A", VIPEmptyType: "AAAA"}
+			if ip.To4() == nil {
+				shard.VIPRecordType = "AAAA"
+				shard.VIPEmptyType = "A"
+			}
+			shards = append(shards, shard)
+			selectors = append(selectors, selector)
+		}
+	}
+	return shards, selectors, nil
+}
+
+// swapped out by the tests
+var listIngressControllers = func(kubeconfigPath string) ([]byte, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/operator.openshift.io/v1/namespaces", ingressControllerNamespace, "ingresscontrollers").
+		DoRaw(context.TODO())
+}
+
+// shardVRID returns the VRRP ID of the shard named name, which differs from
+// the API and ingress ones of c
+func shardVRID(c Cluster, name string) uint8 {
+	vrid := utils.FletcherChecksum8(c.Name+"-ingress-"+name) + 1
+	for vrid == c.APIVirtualRouterID || vrid == c.IngressVirtualRouterID {
+		vrid++
+	}
+	return vrid
+}
+
+// shardNodes fills the VRRP ID, peers and eligibility of shard from the
+// nodes that selector matches
+func (s *IngressShard) shardNodes(opts Options, c Cluster, shortHostname string, selector labels.Selector, nodes []v1.Node, debug bool) {
+	s.VirtualRouterID = shardVRID(c, s.Name)
+	matching := []v1.Node{}
+	for _, node := range nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			matching = append(matching, node)
+			if strings.SplitN(node.Name, ".", 2)[0] == shortHostname {
+				s.Eligible = true
+			}
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+	for _, peer := range nodePeerAddresses(opts, matching, []string{s.VIP}, debug) {
+		s.Peers = append(s.Peers, peer.Address)
+	}
+}
+
+// PopulateIngressShards sets the ingress shards of node from the
+// IngressControllers that have their own VIPs. Their peers are the nodes of
+// the site of node that match the node placement of the controller.
+func PopulateIngressShards(opts Options, kubeconfigPath string, node *Node) error {
+	data, err := listIngressControllers(kubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("Failed to list the IngressControllers: %w", err)
+	}
+	shards, selectors, err := parseIngressShards(data)
+	if err != nil {
+		return err
+	}
+	if len(shards) > 0 {
+		nodes, err := SharedNodeCache().List("", kubeconfigPath, "")
+		if err != nil {
+			return err
+		}
+		if nodes, _, err = SharedNodeCache().splitSites(opts.Sites, "", kubeconfigPath, nodes); err != nil {
+			return err
+		}
+		debug, err := nodeIPDebug("", kubeconfigPath)
+		if err != nil {
+			return err
+		}
+		for i := range shards {
+			shards[i].shardNodes(opts, node.Cluster, node.ShortHostname, selectors[i], withoutArbiters(nodes), debug)
+		}
+	}
+	node.IngressShards = shards
+	return nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("IngressShards", func() {
+	controllers := `{"items": [
+		{"metadata": {"name": "default"}, "spec": {"domain": "apps.ostest.test.metalkube.org"}},
+		{"metadata": {"name": "internal", "annotations": {"baremetal-runtimecfg.openshift.io/ingress-vips": "192.168.111.6, fd2e:6f44:5dd8::6"}},
+		 "spec": {"domain": "internal.ostest.test.metalkube.org", "nodePlacement": {"nodeSelector": {"matchLabels": {"shard": "internal"}}}}}
+	]}`
+
+	It("parses the controllers with their own VIPs", func() {
+		shards, selectors, err := parseIngressShards([]byte(controllers))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(shards).To(Equal([]IngressShard{
+			{Name: "internal", Domain: "internal.ostest.test.metalkube.org", VIP: "192.168.111.6", VIPRecordType: "A", VIPEmptyType: "AAAA"},
+			{Name: "internal", Domain: "internal.ostest.test.metalkube.org", VIP: "fd2e:6f44:5dd8::6", VIPRecordType: "AAAA", VIPEmptyType: "A"},
+		}))
+		Expect(selectors).To(HaveLen(2))
+		Expect(selectors[0].String()).To(Equal("shard=internal"))
+	})
+
+	It("rejects invalid VIPs", func() {
+		_, _, err := parseIngressShards([]byte(`{"items": [{"metadata": {"name": "internal", "annotations": {"baremetal-runtimecfg.openshift.io/ingress-vips": "192.168.111"}}}]}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("restricts the peers to the node placement", func() {
+		shards, selectors, err := parseIngressShards([]byte(controllers))
+		Expect(err).ShouldNot(HaveOccurred())
+		nodes := []v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-0.ostest", Labels: map[string]string{"shard": "internal"}},
+				Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.111.30"}}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-1.ostest"},
+				Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.111.31"}}},
+			},
+		}
+		cluster := Cluster{Name: "ostest"}
+		cluster.PopulateVRIDs()
+
+		shard := shards[0]
+		shard.shardNodes(DefaultOptions(), cluster, "worker-0", selectors[0], nodes, false)
+		Expect(shard.Peers).To(Equal([]string{"192.168.111.30"}))
+		Expect(shard.Eligible).To(BeTrue())
+		Expect(shard.VirtualRouterID).NotTo(BeElementOf(cluster.APIVirtualRouterID, cluster.IngressVirtualRouterID))
+
+		shard = shards[0]
+		shard.shardNodes(DefaultOptions(), cluster, "worker-1", selectors[0], nodes, false)
+		Expect(shard.Eligible).To(BeFalse())
+	})
+})
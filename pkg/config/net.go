@@ -31,91 +31,144 @@ func GetIpFromFile(filePath string) (net.IP, error) {
 	return ip, err
 }
 
-func getInterfaceAndNonVIPAddrFromFile(vip net.IP) (*net.Interface, *net.IPNet, error) {
-	ipFile := NodeIpIpV4File
-	if utils.IsIPv6(vip) {
-		ipFile = NodeIpIpV6File
-	}
+// The node IP files of the node ip service and the links and addresses of
+// the node, swapped out by the tests
+var (
+	nodeIPFiles      = map[bool]string{false: NodeIpIpV4File, true: NodeIpIpV6File}
+	netInterfaces    = net.Interfaces
+	interfaceAddrs   = func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }
+	addressesRouting = utils.AddressesRouting
+	addressesDefault = utils.AddressesDefault
+)
 
-	ip, err := GetIpFromFile(ipFile)
+// getInterfaceAndNonVIPAddrFromFile returns the interface carrying the node
+// IP of the node ip service for the IP version of vip
+func getInterfaceAndNonVIPAddrFromFile(vip net.IP) (*net.Interface, *net.IPNet, error) {
+	ip, err := GetIpFromFile(nodeIPFiles[utils.IsIPv6(vip)])
 	if err != nil {
 		return nil, nil, err
 	}
-	return utils.GetInterfaceWithCidrByIP(ip, true)
-}
-
-// NOTE(bnemec): All addresses in the vips array must be the same ip version
-func getInterfaceAndNonVIPAddr(vips []net.IP) (vipIface net.Interface, nonVipAddr *net.IPNet, err error) {
-	if len(vips) < 1 {
-		return vipIface, nonVipAddr, fmt.Errorf("at least one correct IPv4/IPv6 VIP needs to be fed to this function")
-	}
-	vipMap := make(map[string]net.IP)
-	for _, vip := range vips {
-		vipMap[vip.String()] = vip
+	iface, addr, found, err := findInterfaceAddr(func(n *net.IPNet) bool { return n.IP.Equal(ip) })
+	if err != nil {
+		return nil, nil, err
 	}
-
-	// try to get interface from ip file filled by node ip service
-	iface, addr, err := getInterfaceAndNonVIPAddrFromFile(vips[0])
-	if err == nil {
-		return *iface, addr, err
+	if !found {
+		return nil, nil, fmt.Errorf("failed find a interface for the ip %s", ip)
 	}
+	return &iface, addr, nil
+}
 
-	ifaces, err := net.Interfaces()
+// findInterfaceAddr returns the first interface with an address matching
+// match and that address. found is false when there is none.
+func findInterfaceAddr(match func(*net.IPNet) bool) (vipIface net.Interface, nonVipAddr *net.IPNet, found bool, err error) {
+	ifaces, err := netInterfaces()
 	if err != nil {
-		return vipIface, nonVipAddr, err
+		return vipIface, nonVipAddr, false, err
 	}
-
 	for _, iface := range ifaces {
-		addrs, err := iface.Addrs()
+		addrs, err := interfaceAddrs(iface)
 		if err != nil {
-			return vipIface, nonVipAddr, err
+			return vipIface, nonVipAddr, false, err
 		}
 		for _, addr := range addrs {
 			switch n := addr.(type) {
 			case *net.IPNet:
-				if _, ok := vipMap[n.IP.String()]; ok {
-					continue // This is a VIP, let's skip
-				}
-				_, nn, _ := net.ParseCIDR(strings.Replace(addr.String(), "/128", "/64", 1))
-
-				if nn.Contains(vips[0]) {
-					// Since IPV6 subnet is set to /64 we should also verify that
-					// the candidate address and VIP address are L2 connected.
-					// To make sure that the correct interface being chosen for cases like:
-					// 2 interfaces , subnetA: 1001:db8::/120 , subnetB: 1001:db8::f00/120 and VIP address  1001:db8::64
-					nodeAddrs, err := utils.AddressesRouting(vips, utils.ValidNodeAddress, utils.IsIPv6(vips[0]))
-					if err == nil && len(nodeAddrs) > 0 && n.IP.Equal(nodeAddrs[0]) {
-						return iface, n, nil
-					}
+				if match(n) {
+					return iface, n, true, nil
 				}
 			default:
 				fmt.Println("not supported addr")
 			}
 		}
 	}
+	return vipIface, nonVipAddr, false, nil
+}
 
-	nodeAddrs, err := utils.AddressesDefault(false, utils.ValidNodeAddress)
+// getOnLinkInterface returns the interface with an address of the node in
+// the network of vips[0], skipping the addresses that are vips. found is
+// false when there is none.
+func getOnLinkInterface(vips []net.IP, excludedDevices []string) (vipIface net.Interface, nonVipAddr *net.IPNet, found bool, err error) {
+	vipMap := make(map[string]net.IP)
+	for _, vip := range vips {
+		vipMap[vip.String()] = vip
+	}
+	var nodeAddrs []net.IP
+	return findInterfaceAddr(func(n *net.IPNet) bool {
+		if _, ok := vipMap[n.IP.String()]; ok {
+			return false // This is a VIP, let's skip
+		}
+		_, nn, _ := net.ParseCIDR(strings.Replace(n.String(), "/128", "/64", 1))
+		if !nn.Contains(vips[0]) {
+			return false
+		}
+		// Since IPV6 subnet is set to /64 we should also verify that
+		// the candidate address and VIP address are L2 connected.
+		// To make sure that the correct interface being chosen for cases like:
+		// 2 interfaces , subnetA: 1001:db8::/120 , subnetB: 1001:db8::f00/120 and VIP address  1001:db8::64
+		if nodeAddrs == nil {
+			var err error
+			if nodeAddrs, err = addressesRouting(vips, utils.ValidNodeAddress, utils.IsIPv6(vips[0]), excludedDevices); err != nil {
+				nodeAddrs = []net.IP{}
+			}
+		}
+		return len(nodeAddrs) > 0 && n.IP.Equal(nodeAddrs[0])
+	})
+}
+
+// getDefaultInterface returns the interface of the default route and the
+// address of the node on it
+func getDefaultInterface(excludedDevices []string) (vipIface net.Interface, nonVipAddr *net.IPNet, err error) {
+	nodeAddrs, err := addressesDefault(false, utils.ValidNodeAddress, excludedDevices)
 	if err != nil {
 		return vipIface, nonVipAddr, err
 	}
 	if len(nodeAddrs) == 0 {
 		return vipIface, nonVipAddr, fmt.Errorf("No interface nor address found")
 	}
-	for _, iface := range ifaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
-			return vipIface, nonVipAddr, err
-		}
-		for _, addr := range addrs {
-			switch n := addr.(type) {
-			case *net.IPNet:
-				if n.IP.String() == nodeAddrs[0].String() {
-					return iface, n, nil
-				}
-			default:
-				fmt.Println("not supported addr")
-			}
+	vipIface, nonVipAddr, found, err := findInterfaceAddr(func(n *net.IPNet) bool { return n.IP.Equal(nodeAddrs[0]) })
+	if err == nil && !found {
+		err = fmt.Errorf("No interface nor address found")
+	}
+	return vipIface, nonVipAddr, err
+}
+
+// getVIPInterface returns the interface of vip, skipping the addresses of
+// the node that are one of others. The node IP of the node ip service only
+// wins when vip is in its network, so that a VIP of another machine network
+// gets the interface of that network. A VIP that is on no link of the node,
+// e.g. one advertised with BGP, still gets the interface of the node IP, and
+// the interface of the default route without one.
+func getVIPInterface(vip net.IP, others []net.IP, excludedDevices []string) (vipIface net.Interface, nonVipAddr *net.IPNet, err error) {
+	fileIface, fileAddr, fileErr := getInterfaceAndNonVIPAddrFromFile(vip)
+	if fileErr == nil && fileAddr.Contains(vip) {
+		return *fileIface, fileAddr, nil
+	}
+	vips := []net.IP{vip}
+	for _, other := range others {
+		if utils.IsIPv6(other) == utils.IsIPv6(vip) {
+			vips = append(vips, other)
 		}
 	}
-	return vipIface, nonVipAddr, fmt.Errorf("No interface nor address found")
+	vipIface, nonVipAddr, found, err := getOnLinkInterface(vips, excludedDevices)
+	if err != nil || found {
+		return vipIface, nonVipAddr, err
+	}
+	if fileErr == nil {
+		return *fileIface, fileAddr, nil
+	}
+	return getDefaultInterface(excludedDevices)
+}
+
+// vlanID returns the 802.1Q VLAN ID of the interface name, 0 when it is
+// untagged
+func vlanID(name string) int {
+	iface, err := interfaceByName(name)
+	if err != nil {
+		return 0
+	}
+	vlan, err := utils.GetVLAN(*iface)
+	if err != nil || vlan == nil {
+		return 0
+	}
+	return vlan.ID
 }
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("GetVRRPInterfaces", func() {
+	var dir string
+	var links map[string][]string
+	var defaultAddrs []net.IP
+
+	ifaces := []net.Interface{
+		{Index: 2, Name: "eth0"},
+		{Index: 3, Name: "eth1"},
+		{Index: 4, Name: "eno1"},
+		{Index: 5, Name: "eth2"},
+	}
+	cidr := func(s string) *net.IPNet {
+		ip, n, err := net.ParseCIDR(s)
+		Expect(err).ShouldNot(HaveOccurred())
+		n.IP = ip
+		return n
+	}
+	ips := func(addrs ...string) []net.IP {
+		parsed := []net.IP{}
+		for _, a := range addrs {
+			parsed = append(parsed, net.ParseIP(a))
+		}
+		return parsed
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "vrrpinterfaces")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// eno1 is a slave of bond0
+		sysClassNet = filepath.Join(dir, "net")
+		Expect(os.MkdirAll(filepath.Join(sysClassNet, "bond0", "bonding"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sysClassNet, "eno1"), 0755)).To(Succeed())
+		Expect(os.Symlink("../bond0", filepath.Join(sysClassNet, "eno1", "master"))).To(Succeed())
+		interfaceByName = func(name string) (*net.Interface, error) {
+			if name != "bond0" {
+				return nil, errors.New("no such network interface")
+			}
+			return &net.Interface{Index: 10, Name: name}, nil
+		}
+
+		nodeIPFile := filepath.Join(dir, "ipv4")
+		Expect(os.WriteFile(nodeIPFile, []byte("192.168.111.20"), 0644)).To(Succeed())
+		nodeIPFiles = map[bool]string{false: nodeIPFile, true: filepath.Join(dir, "ipv6")}
+
+		links = map[string][]string{
+			"eth0": {"192.168.111.20/24"},
+			// eth1 holds the ingress VIP of the second machine network
+			"eth1": {"10.0.0.4/24", "10.0.0.20/24"},
+			"eno1": {"172.22.0.20/24"},
+			"eth2": {"fd2e:6f44:5dd8::20/64"},
+		}
+		defaultAddrs = ips("192.168.111.20")
+
+		netInterfaces = func() ([]net.Interface, error) {
+			return ifaces, nil
+		}
+		interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+			addrs := []net.Addr{}
+			for _, a := range links[iface.Name] {
+				addrs = append(addrs, cidr(a))
+			}
+			return addrs, nil
+		}
+		addressesRouting = func(vips []net.IP, af utils.AddressFilter, preferIPv6 bool, excludedDevices []string) ([]net.IP, error) {
+			isVIP := map[string]bool{}
+			for _, vip := range vips {
+				isVIP[vip.String()] = true
+			}
+			for _, iface := range ifaces {
+				for _, a := range links[iface.Name] {
+					if n := cidr(a); n.Contains(vips[0]) && !isVIP[n.IP.String()] {
+						return []net.IP{n.IP}, nil
+					}
+				}
+			}
+			return nil, nil
+		}
+		addressesDefault = func(preferIPv6 bool, af utils.AddressFilter, excludedDevices []string) ([]net.IP, error) {
+			return defaultAddrs, nil
+		}
+	})
+
+	AfterEach(func() {
+		sysClassNet = "/sys/class/net"
+		interfaceByName = net.InterfaceByName
+		nodeIPFiles = map[bool]string{false: NodeIpIpV4File, true: NodeIpIpV6File}
+		netInterfaces = net.Interfaces
+		interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }
+		addressesRouting = utils.AddressesRouting
+		addressesDefault = utils.AddressesDefault
+		os.RemoveAll(dir)
+	})
+
+	It("selects the interface of each VIP", func() {
+		for _, c := range []struct {
+			name   string
+			vips   []net.IP
+			ifaces []string
+			addrs  []string
+		}{
+			{
+				name:   "VIPs on the network of the node IP",
+				vips:   ips("192.168.111.5", "192.168.111.4"),
+				ifaces: []string{"eth0", "eth0"},
+				addrs:  []string{"192.168.111.20", "192.168.111.20"},
+			},
+			{
+				name:   "VIPs on different machine networks",
+				vips:   ips("192.168.111.5", "10.0.0.4"),
+				ifaces: []string{"eth0", "eth1"},
+				addrs:  []string{"192.168.111.20", "10.0.0.20"},
+			},
+			{
+				name:   "VIP on a bond slave",
+				vips:   ips("172.22.0.5"),
+				ifaces: []string{"bond0"},
+				addrs:  []string{"172.22.0.20"},
+			},
+			{
+				name:   "VIP without a node IP file",
+				vips:   ips("fd2e:6f44:5dd8::5"),
+				ifaces: []string{"eth2"},
+				addrs:  []string{"fd2e:6f44:5dd8::20"},
+			},
+			{
+				name:   "VIP with no matching interface",
+				vips:   ips("203.0.113.5"),
+				ifaces: []string{"eth0"},
+				addrs:  []string{"192.168.111.20"},
+			},
+			{
+				name:   "unset VIPs",
+				vips:   []net.IP{nil, net.ParseIP("10.0.0.4")},
+				ifaces: []string{"eth1"},
+				addrs:  []string{"10.0.0.20"},
+			},
+		} {
+			vipIfaces, err := GetVRRPInterfaces(DefaultOptions(), c.vips)
+			Expect(err).ShouldNot(HaveOccurred(), c.name)
+			Expect(vipIfaces).To(HaveLen(len(c.ifaces)), c.name)
+			for i, vipIface := range vipIfaces {
+				Expect(vipIface.Interface.Name).To(Equal(c.ifaces[i]), c.name)
+				Expect(vipIface.NonVirtualIP.IP.String()).To(Equal(c.addrs[i]), c.name)
+			}
+		}
+	})
+
+	It("falls back to the default interface without a node IP", func() {
+		Expect(os.Remove(nodeIPFiles[false])).To(Succeed())
+		vipIfaces, err := GetVRRPInterfaces(DefaultOptions(), ips("203.0.113.5"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(vipIfaces[0].Interface.Name).To(Equal("eth0"))
+
+		defaultAddrs = nil
+		_, err = GetVRRPInterfaces(DefaultOptions(), ips("203.0.113.5"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is the first VIP for GetVRRPConfig", func() {
+		iface, addr, err := GetVRRPConfig(DefaultOptions(), net.ParseIP("10.0.0.4"), net.ParseIP("192.168.111.4"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface.Name).To(Equal("eth1"))
+		Expect(addr.IP.String()).To(Equal("10.0.0.20"))
+
+		iface, _, err = GetVRRPConfig(DefaultOptions(), nil, net.ParseIP("172.22.0.4"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface.Name).To(Equal("bond0"))
+
+		_, _, err = GetVRRPConfig(DefaultOptions(), nil, nil)
+		Expect(err).To(Equal(errNoVIP))
+	})
+})
@@ -2,7 +2,6 @@ package config
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +9,17 @@ import (
 	"net"
 	"net/url"
 	"os"
-	"sort"
 	"strings"
 
 	"github.com/ghodss/yaml"
+	configv1 "github.com/openshift/api/config/v1"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+	"github.com/openshift/baremetal-runtimecfg/pkg/tracing"
 	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 	"github.com/openshift/installer/pkg/types"
 )
@@ -34,6 +34,9 @@ type NodeAddress struct {
 	Address string
 	Name    string
 	Ipv6    bool
+	// PTRName is the reverse lookup name of Address, e.g.
+	// 10.1.168.192.in-addr.arpa
+	PTRName string
 }
 
 type Cluster struct {
@@ -50,11 +53,30 @@ type Cluster struct {
 	VIPNetmask             int
 	MasterAmount           int64
 	NodeAddresses          []NodeAddress
-	APILBIPs               []string
-	APIIntLBIPs            []string
-	IngressLBIPs           []string
-	CloudLBRecordType      string
-	CloudLBEmptyType       string
+	// IngressNodeAddresses is the subset of NodeAddresses that may serve
+	// ingress traffic.
+	IngressNodeAddresses []NodeAddress
+	APILBIPs             []string
+	APIIntLBIPs          []string
+	IngressLBIPs         []string
+	CloudLBRecordType    string
+	CloudLBEmptyType     string
+	// APIInternalIPs and APIExternalIPs are the targets api.<domain>
+	// resolves to for in-cluster and external clients respectively.
+	// APIServedIPs is the set selected for the node-local DNS server.
+	APIInternalIPs []string
+	APIExternalIPs []string
+	APIServedIPs   []string
+	// ReverseZones are the reverse zones of the machine networks with the
+	// PTR records of the nodes and VIPs, for the node-local DNS server
+	ReverseZones []ReverseZone
+	// NoReadyRouters is set when the *.apps wildcard is gated on the routers
+	// and none of them is ready, for the templates to leave the record out
+	// rather than black-hole the traffic at the ingress VIP
+	NoReadyRouters bool
+	// ControlPlaneTopology is status.controlPlaneTopology of the cluster
+	// Infrastructure, e.g. HighlyAvailableArbiter, empty until it is read
+	ControlPlaneTopology string
 }
 
 type Backend struct {
@@ -64,9 +86,12 @@ type Backend struct {
 }
 
 type ApiLBConfig struct {
-	ApiPort      uint16
-	LbPort       uint16
-	StatPort     uint16
+	ApiPort  uint16
+	LbPort   uint16
+	StatPort uint16
+	// Frontends are the ports HAProxy listens on for the API, the LbPort of
+	// ApiPort first and then the ones of Options.ExtraAPIPorts
+	Frontends    []APIPort
 	Backends     []Backend
 	FrontendAddr string
 }
@@ -80,17 +105,88 @@ type Node struct {
 	LBConfig      ApiLBConfig
 	NonVirtualIP  string
 	ShortHostname string
+	// VRRPInterface is the interface of the VIPs of the node as a whole, the
+	// one of the API VIP when it and the ingress VIP are in different
+	// machine networks
 	VRRPInterface string
+	// APIVRRPInterface and IngressVRRPInterface are the interfaces of the
+	// API and ingress VIPs, each in the machine network of its VIP
+	APIVRRPInterface     string
+	IngressVRRPInterface string
+	// VRRPBond describes VRRPInterface when it is a bond or a team, e.g.
+	// for the templates to adjust the advert interval to its failover
+	// time. It is nil for any other interface.
+	VRRPBond *Bond
+	// VRRPVLANID is the 802.1Q VLAN ID of VRRPInterface, 0 when it is
+	// untagged
+	VRRPVLANID    int
 	DNSUpstreams  []string
+	DNSForwarders []DNSForwarder
+	// DHCPStaticLeases are the provisioning network host reservations
+	// derived from BareMetalHost objects.
+	DHCPStaticLeases []StaticLease
+	// LeaseTrackFiles maps the names of the DHCP leased VIPs to keepalived
+	// track files that hold 1 while the lease does not match the VIP.
+	LeaseTrackFiles map[string]string
+	// FirewallTrackFile is the keepalived track file that holds 1 while the
+	// API redirect rules of the VIP family of the node are in place.
+	FirewallTrackFile string
+	// MaintenanceTrackFile is the keepalived track file that holds 1 while
+	// the node is in maintenance, empty when maintenance is disabled.
+	MaintenanceTrackFile string
+	// BFD holds the BFD sessions of the node with its routing peers, nil
+	// when BFD is not used.
+	BFD *BFDConfig
+	// Overrides are the per-node settings of the RuntimeNetConfig of the
+	// node, already applied to the other fields.
+	Overrides     NodeOverrides
 	IngressConfig IngressConfig
 	EnableUnicast bool
-	Configs       *[]Node
+	// UserManagedLB is set when the API and ingress go through load
+	// balancers of the user. keepalived and haproxy do not run then, and the
+	// VIPs of Cluster are the LB IPs the DNS records point at.
+	UserManagedLB bool
+	// Priority is the keepalived priority of the node, computed from
+	// Options.Priorities unless the overrides of the node set one
+	Priority int
+	// Arbiter is set on the arbiter of a HighlyAvailableArbiter control
+	// plane, which must not hold a VIP
+	Arbiter bool
+	// Site is the site of the node when the cluster is split in Sites, its
+	// VIPs, VRRP IDs, peers and backends are then those of the site
+	Site string
+	// IngressShards are the VIPs of the IngressControllers that have their
+	// own, only set when the monitor gathers them
+	IngressShards []IngressShard
+	// VRRP are the VRRP options of the keepalived-vrrp ConfigMap, only set
+	// by the keepalived monitor
+	VRRP VRRPSettings
+	// HealthChecks are the checks of the keepalived-health-checks ConfigMap,
+	// only set by the keepalived monitor
+	HealthChecks []HealthCheck
+	// Secrets are the values of the secret files, e.g. the VRRP
+	// authentication password. Only set on the copy of the node rendered,
+	// so they are never shared or logged with the node.
+	Secrets render.Secrets `json:"-"`
+	// Unresolved lists the fields left empty in Offline mode because they
+	// need the API, e.g. UnresolvedSite
+	Unresolved []string
+	Configs    *[]Node
+}
+
+// RenderSecrets returns the Secrets of the node to the rendering
+func (n Node) RenderSecrets() render.Secrets {
+	return n.Secrets
 }
 
 type ClusterLBConfig struct {
 	ApiLBIPs     []net.IP
 	ApiIntLBIPs  []net.IP
 	IngressLBIPs []net.IP
+	// UserManaged is set when the API and ingress go through load balancers
+	// of the user instead of the keepalived VIPs and haproxy. The VIPs given
+	// to GetConfig are then the LB IPs that are not given here.
+	UserManaged bool
 }
 
 func getDNSUpstreams(resolvConfPath string) (upstreams []string, err error) {
@@ -124,20 +220,41 @@ func getDNSUpstreams(resolvConfPath string) (upstreams []string, err error) {
 	return upstreams, nil
 }
 
+// GetKubeconfigClusterNameAndDomain returns the cluster name and domain from
+// the api(-int).<name>.<domain> server of the kubeconfig. In cluster, the
+// server is the service IP, so the internal API URL of the cluster
+// Infrastructure is used instead.
 func GetKubeconfigClusterNameAndDomain(kubeconfigPath string) (name, domain string, err error) {
-	kubeCfg, err := clientcmd.LoadFromFile(kubeconfigPath)
-	if err != nil {
-		return "", "", err
+	var server string
+	if utils.IsInCluster(kubeconfigPath) {
+		data, err := getInfrastructure(kubeconfigPath)
+		if err != nil {
+			return "", "", err
+		}
+		infra := configv1.Infrastructure{}
+		if err := json.Unmarshal(data, &infra); err != nil {
+			return "", "", err
+		}
+		server = infra.Status.APIServerInternalURL
+	} else {
+		kubeCfg, err := clientcmd.LoadFromFile(kubeconfigPath)
+		if err != nil {
+			return "", "", err
+		}
+		ctxt := kubeCfg.Contexts[kubeCfg.CurrentContext]
+		cluster := kubeCfg.Clusters[ctxt.Cluster]
+		server = cluster.Server
 	}
-	ctxt := kubeCfg.Contexts[kubeCfg.CurrentContext]
-	cluster := kubeCfg.Clusters[ctxt.Cluster]
-	serverUrl, err := url.Parse(cluster.Server)
+	serverUrl, err := url.Parse(server)
 	if err != nil {
 		return "", "", err
 	}
 
 	apiHostname := serverUrl.Hostname()
 	apiHostnameSlices := strings.SplitN(apiHostname, ".", 3)
+	if len(apiHostnameSlices) < 3 {
+		return "", "", fmt.Errorf("API server %s is not named api.<cluster>.<domain>", apiHostname)
+	}
 
 	return apiHostnameSlices[1], apiHostnameSlices[2], nil
 }
@@ -189,6 +306,75 @@ func getClusterConfigMapInstallConfig(configPath string) (installConfig types.In
 	return ic, err
 }
 
+// GetNetworkType returns the cluster network type from the install-config
+// in the cluster-config ConfigMap at clusterConfigPath
+func GetNetworkType(clusterConfigPath string) (string, error) {
+	ic, err := getClusterConfigMapInstallConfig(clusterConfigPath)
+	if err != nil {
+		return "", err
+	}
+	if ic.Networking == nil {
+		return "", nil
+	}
+	if ic.Networking.NetworkType != "" {
+		return ic.Networking.NetworkType, nil
+	}
+	return ic.Networking.DeprecatedType, nil
+}
+
+// ovnNetworkConfig holds the OVN-Kubernetes gateway settings of a
+// networks.operator.openshift.io manifest
+type ovnNetworkConfig struct {
+	Spec struct {
+		DefaultNetwork struct {
+			OVNKubernetesConfig *struct {
+				GatewayConfig *struct {
+					IPv4 struct {
+						InternalMasqueradeSubnet string `json:"internalMasqueradeSubnet"`
+					} `json:"ipv4"`
+					IPv6 struct {
+						InternalMasqueradeSubnet string `json:"internalMasqueradeSubnet"`
+					} `json:"ipv6"`
+				} `json:"gatewayConfig"`
+			} `json:"ovnKubernetesConfig"`
+		} `json:"defaultNetwork"`
+	} `json:"spec"`
+}
+
+// GetOVNInternalSubnets returns the OVN masquerade subnets of both families
+// set in the networks.operator.openshift.io manifest at networkConfigPath,
+// e.g. the cluster-network-03-config.yml installer manifest. A family not
+// set in the manifest keeps its subnet of utils.DefaultOVNInternalSubnets.
+func GetOVNInternalSubnets(networkConfigPath string) ([]string, error) {
+	yamlFile, err := ioutil.ReadFile(networkConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	nc := ovnNetworkConfig{}
+	if err := yaml.Unmarshal(yamlFile, &nc); err != nil {
+		return nil, err
+	}
+
+	ipv4, ipv6 := "", ""
+	if ovn := nc.Spec.DefaultNetwork.OVNKubernetesConfig; ovn != nil && ovn.GatewayConfig != nil {
+		ipv4 = ovn.GatewayConfig.IPv4.InternalMasqueradeSubnet
+		ipv6 = ovn.GatewayConfig.IPv6.InternalMasqueradeSubnet
+	}
+	subnets := []string{}
+	for _, cidr := range utils.DefaultOVNInternalSubnets() {
+		isIPv6 := strings.Contains(cidr, ":")
+		switch {
+		case isIPv6 && ipv6 != "":
+			subnets = append(subnets, ipv6)
+		case !isIPv6 && ipv4 != "":
+			subnets = append(subnets, ipv4)
+		default:
+			subnets = append(subnets, cidr)
+		}
+	}
+	return subnets, nil
+}
+
 // PopulateVRIDs fills in the Virtual Router information for the provided Node configuration
 func (c *Cluster) PopulateVRIDs() error {
 	// Add one to the fletcher8 result because 0 is an invalid vrid in
@@ -206,15 +392,69 @@ func (c *Cluster) PopulateVRIDs() error {
 	return nil
 }
 
-func GetVRRPConfig(apiVip, ingressVip net.IP) (vipIface net.Interface, nonVipAddr *net.IPNet, err error) {
-	vips := make([]net.IP, 0)
-	if apiVip != nil && (utils.IsIPv4(apiVip) || utils.IsIPv6(apiVip)) {
-		vips = append(vips, apiVip)
+// errNoVIP is returned by GetVRRPConfig without any IPv4 or IPv6 VIP
+var errNoVIP = errors.New("at least one correct IPv4/IPv6 VIP needs to be fed to this function")
+
+// GetVRRPConfig returns the interface of apiVip, or of ingressVip when
+// apiVip is not set, and the address of the node on it. It is the first
+// entry of GetVRRPInterfaces.
+func GetVRRPConfig(opts Options, apiVip, ingressVip net.IP) (vipIface net.Interface, nonVipAddr *net.IPNet, err error) {
+	vipIfaces, err := GetVRRPInterfaces(opts, []net.IP{apiVip, ingressVip})
+	if err != nil {
+		return vipIface, nonVipAddr, err
+	}
+	if len(vipIfaces) == 0 {
+		return vipIface, nonVipAddr, errNoVIP
+	}
+	return vipIfaces[0].Interface, vipIfaces[0].NonVirtualIP, nil
+}
+
+// VRRPInterface is the interface keepalived advertises a VIP on and the
+// address of the node on it
+type VRRPInterface struct {
+	VIP          net.IP
+	Interface    net.Interface
+	NonVirtualIP *net.IPNet
+}
+
+// GetVRRPInterfaces returns the interface of each of the valid vips, in the
+// same order, skipping the unset ones. Each VIP gets the interface of its own
+// network, so that VIPs of different machine networks are advertised on their
+// own interfaces.
+func GetVRRPInterfaces(opts Options, vips []net.IP) ([]VRRPInterface, error) {
+	valid := make([]net.IP, 0, len(vips))
+	for _, vip := range vips {
+		if vip != nil && (utils.IsIPv4(vip) || utils.IsIPv6(vip)) {
+			valid = append(valid, vip)
+		}
 	}
-	if ingressVip != nil && (utils.IsIPv4(ingressVip) || utils.IsIPv6(ingressVip)) {
-		vips = append(vips, ingressVip)
+	interfaces := make([]VRRPInterface, 0, len(valid))
+	for _, vip := range valid {
+		iface, addr, err := getVIPInterface(vip, valid, opts.ExcludedDevices)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find the interface of VIP %s: %w", vip, err)
+		}
+		interfaces = append(interfaces, VRRPInterface{VIP: vip, Interface: bondMaster(iface), NonVirtualIP: addr})
 	}
-	return getInterfaceAndNonVIPAddr(vips)
+	return interfaces, nil
+}
+
+// VIPInterface returns the VRRP interface of vip, looked up in the node and
+// its nested configs. It is VRRPInterface for a VIP the node does not have.
+func (n *Node) VIPInterface(vip string) string {
+	nodes := []Node{*n}
+	if n.Configs != nil {
+		nodes = append(nodes, *n.Configs...)
+	}
+	for _, c := range nodes {
+		switch {
+		case vip == c.Cluster.APIVIP && c.APIVRRPInterface != "":
+			return c.APIVRRPInterface
+		case vip == c.Cluster.IngressVIP && c.IngressVRRPInterface != "":
+			return c.IngressVRRPInterface
+		}
+	}
+	return n.VRRPInterface
 }
 
 // GetNodes will return a list of all nodes in the cluster
@@ -225,21 +465,11 @@ func GetVRRPConfig(apiVip, ingressVip net.IP) (vipIface net.Interface, nonVipAdd
 // Returns:
 //   - v1.NodeList or error
 func GetNodes(kubeconfigPath string) (*v1.NodeList, error) {
-	config, err := utils.GetClientConfig("", kubeconfigPath)
-	if err != nil {
-		return nil, err
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	nodes, err := SharedNodeCache().List("", kubeconfigPath, "")
 	if err != nil {
 		return nil, err
 	}
-	return nodes, nil
+	return &v1.NodeList{Items: nodes}, nil
 }
 
 // IsUpgradeStillRunning check if the upgrade is still running by looking at
@@ -279,80 +509,12 @@ func IsUpgradeStillRunning(kubeconfigPath string) (bool, error) {
 	return false, nil
 }
 
-func GetIngressConfig(kubeconfigPath string, vips []string) (IngressConfig, error) {
-	var machineNetwork string
-	var ingressConfig IngressConfig
-
-	config, err := utils.GetClientConfig("", kubeconfigPath)
-	if err != nil {
-		return ingressConfig, err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return ingressConfig, err
-	}
-
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return ingressConfig, err
-	}
-
-	if len(vips) == 0 {
-		// This is not necessarily an error path because in handleBootstrapStopKeepalived we do
-		// call this function without providing any VIPs. Because of this, we only want to mark
-		// this scenario and avoid trying to calculate the machine networks.
-		log.Infof("Requested GetIngressConfig for empty VIP list.")
-	} else {
-		// As it is not possible to get cluster's Machine Network directly, we are using a workaround
-		// by detecting which of the local interfaces belongs to the same subnet as requested VIP.
-		// This interface can be used to detect what was the original machine network as it contains
-		// the subnet mask that we need.
-		//
-		// In case there is no subnet containing a VIP on any of the available NICs we are counterintuitively
-		// selecting just a Node IP with the matching IP stack. This is a weird case in e.g. vSphere
-		// where VIPs do not belong to the L2 of the node, yet they work properly.
-		machineNetwork, err = utils.GetLocalCIDRByIP(vips[0])
-
-		if err == nil {
-			debug := utils.GetNodeIPDebugStatus(clientset)
-			for _, node := range nodes.Items {
-				addr, err := getNodeIpForRequestedIpStack(node, vips, machineNetwork, debug)
-				if err != nil {
-					log.WithFields(logrus.Fields{
-						"err": err,
-					}).Warnf("For node %s could not retrieve node's IP. Ignoring", node.ObjectMeta.Name)
-				} else {
-					ingressConfig.Peers = append(ingressConfig.Peers, addr)
-				}
-			}
-		} else {
-			log.WithFields(logrus.Fields{
-				"err": err,
-			}).Errorf("Could not retrieve subnet for IP %s. Falling back to an IP of the matching IP stack", vips[0])
-
-			for _, node := range nodes.Items {
-				addr := ""
-				for _, address := range node.Status.Addresses {
-					if address.Type == v1.NodeInternalIP && utils.IsIPv6(net.ParseIP(address.Address)) == utils.IsIPv6(net.ParseIP(vips[0])) {
-						addr = address.Address
-						break
-					}
-				}
-				if addr != "" {
-					ingressConfig.Peers = append(ingressConfig.Peers, addr)
-				} else {
-					log.WithFields(logrus.Fields{
-						"err": err,
-					}).Warnf("Could not retrieve node's IP for %s. Ignoring", node.ObjectMeta.Name)
-				}
-			}
-		}
-	}
-
-	return ingressConfig, nil
+func GetIngressConfig(opts Options, kubeconfigPath string, vips []string) (IngressConfig, error) {
+	defer tracing.Start("GetIngressConfig").End()
+	return SharedNodeCache().IngressConfig(opts, kubeconfigPath, vips)
 }
 
-func getNodeIpForRequestedIpStack(node v1.Node, filterIps []string, machineNetwork string, debug bool) (string, error) {
+func getNodeIpForRequestedIpStack(opts Options, node v1.Node, filterIps []string, machineNetwork string, debug bool) (string, error) {
 	if debug {
 		SetDebugLogLevel()
 		utils.SetDebugLogLevel()
@@ -430,7 +592,7 @@ func getNodeIpForRequestedIpStack(node v1.Node, filterIps []string, machineNetwo
 		// We are checking if NonVirtualIP is present in the list of OVN annotations. If yes, we
 		// use it as a hint and simply pick this IP address.
 
-		_, nonVipAddr, err := GetVRRPConfig(net.ParseIP(filterIps[0]), nil)
+		_, nonVipAddr, err := GetVRRPConfig(opts, net.ParseIP(filterIps[0]), nil)
 		if err != nil {
 			return "", err
 		}
@@ -486,18 +648,34 @@ func getNodeIpForRequestedIpStack(node v1.Node, filterIps []string, machineNetwo
 // lbPort: The port on which haproxy listens.
 // statPort: The port on which the haproxy stats endpoint listens.
 // clusterLBConfig: A struct containing IPs for API, API-Int and Ingress LBs
-func GetConfig(kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig ClusterLBConfig) (node Node, err error) {
+// opts: The settings of the command, e.g. whether it runs offline
+func GetConfig(opts Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig ClusterLBConfig) (node Node, err error) {
+	span := tracing.Start("GetConfig")
+	defer span.End()
+	if clusterLBConfig.UserManaged {
+		return getNodeConfigWithUserManagedLB(span, opts, kubeconfigPath, clusterConfigPath, resolvConfPath, clusterLBConfig.withVIPs(apiVips, ingressVips))
+	}
 	if onPremPlatform, _ := isOnPremPlatform(clusterConfigPath); !onPremPlatform {
 		// Cloud Platforms with cloud LBs but no Cloud DNS
-		return getNodeConfigWithCloudLBIPs(kubeconfigPath, clusterConfigPath, resolvConfPath, clusterLBConfig)
+		return getNodeConfigWithCloudLBIPs(span, opts, kubeconfigPath, clusterConfigPath, resolvConfPath, clusterLBConfig)
 	}
 	// On-prem platforms
+	site := ""
+	if opts.Sites.Enabled() && !opts.Offline {
+		site, apiVips, ingressVips, err = siteVIPs(opts.Sites, kubeconfigPath, apiVips, ingressVips)
+		if err != nil {
+			return Node{}, err
+		}
+	}
 	vipCount := 0
 	if len(apiVips) > len(ingressVips) {
 		vipCount = len(apiVips)
 	} else {
 		vipCount = len(ingressVips)
 	}
+	if vipCount == 0 {
+		return Node{}, fmt.Errorf("No API or Ingress VIP given for an on-prem platform")
+	}
 	nodes := []Node{}
 	var apiVip, ingressVip net.IP
 	for i := 0; i < vipCount; i++ {
@@ -511,18 +689,47 @@ func GetConfig(kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips
 		} else {
 			ingressVip = nil
 		}
-		newNode, err := getNodeConfig(kubeconfigPath, clusterConfigPath, resolvConfPath, apiVip, ingressVip, apiPort, lbPort, statPort)
+		newNode, err := getNodeConfig(span, opts, kubeconfigPath, clusterConfigPath, resolvConfPath, apiVip, ingressVip, apiPort, lbPort, statPort)
 		if err != nil {
 			return Node{}, err
 		}
+		newNode.setSite(site)
+		if opts.Sites.Enabled() && opts.Offline {
+			newNode.markUnresolved(UnresolvedSite)
+		}
 		nodes = append(nodes, newNode)
 	}
 	nodes[0].Configs = &nodes
 	return nodes[0], nil
 }
 
-func getNodeConfig(kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVip net.IP, ingressVip net.IP, apiPort, lbPort, statPort uint16) (node Node, err error) {
-	clusterName, clusterDomain, err := GetClusterNameAndDomain(kubeconfigPath, clusterConfigPath)
+// setVIPs sets the VIPs and the DNS record types they are served with
+func (c *Cluster) setVIPs(apiVip, ingressVip net.IP) {
+	c.APIVIPRecordType = "A"
+	c.APIVIPEmptyType = "AAAA"
+	if apiVip != nil {
+		c.APIVIP = apiVip.String()
+		if apiVip.To4() == nil {
+			c.APIVIPRecordType = "AAAA"
+			c.APIVIPEmptyType = "A"
+		}
+	}
+	c.IngressVIPRecordType = "A"
+	c.IngressVIPEmptyType = "AAAA"
+	if ingressVip != nil {
+		c.IngressVIP = ingressVip.String()
+		if ingressVip.To4() == nil {
+			c.IngressVIPRecordType = "AAAA"
+			c.IngressVIPEmptyType = "A"
+		}
+	}
+}
+
+// getNodeConfig times its phases under span
+func getNodeConfig(span *tracing.Span, opts Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVip net.IP, ingressVip net.IP, apiPort, lbPort, statPort uint16) (node Node, err error) {
+	phase := span.Phase("clusterName")
+	clusterName, clusterDomain, err := GetClusterNameAndDomain(opts, kubeconfigPath, clusterConfigPath)
+	phase.End()
 	if err != nil {
 		return node, err
 	}
@@ -547,41 +754,32 @@ func getNodeConfig(kubeconfigPath, clusterConfigPath, resolvConfPath string, api
 		return node, err
 	}
 
-	node.Cluster.APIVIPRecordType = "A"
-	node.Cluster.APIVIPEmptyType = "AAAA"
-	if apiVip != nil {
-		node.Cluster.APIVIP = apiVip.String()
-		if apiVip.To4() == nil {
-			node.Cluster.APIVIPRecordType = "AAAA"
-			node.Cluster.APIVIPEmptyType = "A"
-		}
-	}
-	node.Cluster.IngressVIPRecordType = "A"
-	node.Cluster.IngressVIPEmptyType = "AAAA"
-	if ingressVip != nil {
-		node.Cluster.IngressVIP = ingressVip.String()
-		if ingressVip.To4() == nil {
-			node.Cluster.IngressVIPRecordType = "AAAA"
-			node.Cluster.IngressVIPEmptyType = "A"
-		}
-	}
+	node.Cluster.setVIPs(apiVip, ingressVip)
 	// Rest of the Node config will not be available on Cloud platforms.
 	if onPremPlatform, err := isOnPremPlatform(clusterConfigPath); !onPremPlatform {
 		return node, err
 	}
 
-	vipIface, nonVipAddr, err := GetVRRPConfig(apiVip, ingressVip)
+	phase = span.Phase("vrrpInterface")
+	vipIfaces, err := GetVRRPInterfaces(opts, []net.IP{apiVip, ingressVip})
+	phase.End()
+	if err == nil && len(vipIfaces) == 0 {
+		err = errNoVIP
+	}
 	if err != nil {
 		return node, err
 	}
-	node.NonVirtualIP = nonVipAddr.IP.String()
+	vipIface := vipIfaces[0].Interface
+	node.NonVirtualIP = vipIfaces[0].NonVirtualIP.IP.String()
 
 	node.EnableUnicast = false
 	if os.Getenv("ENABLE_UNICAST") == "yes" {
 		node.EnableUnicast = true
 	}
 
+	phase = span.Phase("dnsUpstreams")
 	resolvConfUpstreams, err := getDNSUpstreams(resolvConfPath)
+	phase.End()
 	if err != nil {
 		return node, err
 	}
@@ -604,103 +802,50 @@ func getNodeConfig(kubeconfigPath, clusterConfigPath, resolvConfPath string, api
 		node.Cluster.VIPNetmask = 32
 	}
 	node.VRRPInterface = vipIface.Name
+	node.VRRPBond = GetBond(vipIface.Name)
+	node.VRRPVLANID = vlanID(vipIface.Name)
+	// Without an ingress VIP both are the interface of the API VIP
+	node.APIVRRPInterface = vipIface.Name
+	node.IngressVRRPInterface = vipIfaces[len(vipIfaces)-1].Interface.Name
 
 	// We can't populate this with GetLBConfig because in many cases the
 	// backends won't be available yet.
 	node.LBConfig = ApiLBConfig{
-		ApiPort:  apiPort,
-		LbPort:   lbPort,
-		StatPort: statPort,
+		ApiPort:   apiPort,
+		LbPort:    lbPort,
+		StatPort:  statPort,
+		Frontends: APIPorts(apiPort, lbPort, opts.ExtraAPIPorts),
 	}
 
+	phase = span.Phase("topology")
+	populateTopology(opts, kubeconfigPath, &node)
+	phase.End()
+	populatePriority(opts, kubeconfigPath, &node)
+
 	return node, err
 }
 
 // getSortedBackends builds config to communicate with kube-api based on kubeconfigPath parameter value, if kubeconfigPath is not empty it will build the
 // config based on that content else config will point to localhost.
-func getSortedBackends(kubeconfigPath string, readFromLocalAPI bool, vips []net.IP) (backends []Backend, err error) {
+func getSortedBackends(opts Options, kubeconfigPath string, readFromLocalAPI bool, vips []net.IP) (backends []Backend, err error) {
 	kubeApiServerUrl := ""
 	if readFromLocalAPI {
 		kubeApiServerUrl = localhostKubeApiServerUrl
 	}
-	config, err := utils.GetClientConfig(kubeApiServerUrl, kubeconfigPath)
-	if err != nil {
-		return []Backend{}, err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"err": err,
-		}).Info("Failed to get client")
-		return []Backend{}, err
-	}
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "node-role.kubernetes.io/master=",
-	})
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"err": err,
-		}).Info("Failed to get master Nodes list")
-		return []Backend{}, err
+	if opts.BackendSource == BackendSourceEndpointSlices {
+		return SharedNodeCache().EndpointSliceBackends(opts, kubeApiServerUrl, kubeconfigPath, vips)
 	}
-	if len(vips) == 0 {
-		return []Backend{}, fmt.Errorf("Trying to build config using empty VIPs")
-	}
-
-	// As it is not possible to get cluster's Machine Network directly, we are using a workaround
-	// by detecting which of the local interfaces belongs to the same subnet as requested VIP.
-	// This interface can be used to detect what was the original machine network as it contains
-	// the subnet mask that we need.
-	// In case there is no subnet containing a VIP on any of the available NICs we are counterintuitively
-	// selecting just a Node IP with the matching IP stack. This is a weird case in e.g. vSphere
-	// where VIPs do not belong to the L2 of the node, yet they work properly.
-	machineNetwork, err := utils.GetLocalCIDRByIP(vips[0].String())
-	if err == nil {
-		debug := utils.GetNodeIPDebugStatus(clientset)
-		for _, node := range nodes.Items {
-			masterIp, err := getNodeIpForRequestedIpStack(node, utils.ConvertIpsToStrings(vips), machineNetwork, debug)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"err": err,
-				}).Warnf("Could not retrieve node's IP for %s. Ignoring", node.ObjectMeta.Name)
-			} else {
-				backends = append(backends, Backend{Host: node.ObjectMeta.Name, Address: masterIp})
-			}
-		}
-	} else {
-		log.WithFields(logrus.Fields{
-			"err": err,
-		}).Errorf("Could not retrieve subnet for IP %s. Falling back to an IP of the matching IP stack", vips[0].String())
-
-		for _, node := range nodes.Items {
-			masterIp := ""
-			for _, address := range node.Status.Addresses {
-				if address.Type == v1.NodeInternalIP && utils.IsIPv6(net.ParseIP(address.Address)) == utils.IsIPv6(vips[0]) {
-					masterIp = address.Address
-					break
-				}
-			}
-			if masterIp != "" {
-				backends = append(backends, Backend{Host: node.ObjectMeta.Name, Address: masterIp})
-			} else {
-				log.WithFields(logrus.Fields{
-					"err": err,
-				}).Warnf("Could not retrieve node's IP for %s. Ignoring", node.ObjectMeta.Name)
-			}
-		}
-	}
-
-	sort.Slice(backends, func(i, j int) bool {
-		return backends[i].Address < backends[j].Address
-	})
-	return backends, nil
+	return SharedNodeCache().Backends(opts, kubeApiServerUrl, kubeconfigPath, vips)
 }
 
-func GetLBConfig(kubeconfigPath string, apiPort, lbPort, statPort uint16, vips []net.IP) (ApiLBConfig, error) {
+func GetLBConfig(opts Options, kubeconfigPath string, apiPort, lbPort, statPort uint16, vips []net.IP) (ApiLBConfig, error) {
+	span := tracing.Start("GetLBConfig")
+	defer span.End()
 	config := ApiLBConfig{
-		ApiPort:  apiPort,
-		LbPort:   lbPort,
-		StatPort: statPort,
+		ApiPort:   apiPort,
+		LbPort:    lbPort,
+		StatPort:  statPort,
+		Frontends: APIPorts(apiPort, lbPort, opts.ExtraAPIPorts),
 	}
 
 	if len(vips) == 0 {
@@ -712,11 +857,15 @@ func GetLBConfig(kubeconfigPath string, apiPort, lbPort, statPort uint16, vips [
 		config.FrontendAddr = "::"
 	}
 	// Try reading master nodes details first from api-vip:kube-apiserver and failover to localhost:kube-apiserver
-	backends, err := getSortedBackends(kubeconfigPath, false, vips)
+	phase := span.Phase("backends")
+	backends, err := getSortedBackends(opts, kubeconfigPath, false, vips)
+	phase.End()
 	if err != nil {
 		log.Infof("An error occurred while trying to read master nodes details from api-vip:kube-apiserver: %v", err)
 		log.Infof("Trying to read master nodes details from localhost:kube-apiserver")
-		backends, err = getSortedBackends(kubeconfigPath, true, vips)
+		phase = span.Phase("localBackends")
+		backends, err = getSortedBackends(opts, kubeconfigPath, true, vips)
+		phase.End()
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"kubeconfigPath": kubeconfigPath,
@@ -735,10 +884,16 @@ func GetLBConfig(kubeconfigPath string, apiPort, lbPort, statPort uint16, vips [
 	return config, nil
 }
 
-func GetClusterNameAndDomain(kubeconfigPath, clusterConfigPath string) (clusterName string, clusterDomain string, err error) {
+// GetClusterNameAndDomain reads the cluster name and domain from the cluster
+// config, and from the kubeconfig when it has none. In Offline mode the
+// cluster Infrastructure is not read for the in-cluster kubeconfig.
+func GetClusterNameAndDomain(opts Options, kubeconfigPath, clusterConfigPath string) (clusterName string, clusterDomain string, err error) {
 	// Try cluster-config.yml first
 	clusterName, clusterDomain, err = getClusterConfigClusterNameAndDomain(clusterConfigPath)
 	if err != nil {
+		if opts.Offline && utils.IsInCluster(kubeconfigPath) {
+			return "", "", fmt.Errorf("No kubeconfig to read the cluster name from: %w", errOffline)
+		}
 		// We are using kubeconfig as a fallback for this
 		clusterName, clusterDomain, err = GetKubeconfigClusterNameAndDomain(kubeconfigPath)
 	}
@@ -746,26 +901,45 @@ func GetClusterNameAndDomain(kubeconfigPath, clusterConfigPath string) (clusterN
 	return
 }
 
-func PopulateNodeAddresses(kubeconfigPath string, node *Node) {
-	// Get node list
-	config, err := utils.GetClientConfig("", kubeconfigPath)
-	if err != nil {
-		log.Errorf("Failed to build client config: %s", err)
-		return
-	}
+// IngressNodeFilter restricts which nodes are used for ingress related
+// records. The zero value accepts every node.
+type IngressNodeFilter struct {
+	// ReadyOnly skips nodes whose Ready condition is not True.
+	ReadyOnly bool
+	// Selector, if not nil, must match the node labels (e.g. the ingress
+	// controller node placement).
+	Selector labels.Selector
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Errorf("Failed to create client: %s", err)
-		return
+func (f IngressNodeFilter) matches(n v1.Node) bool {
+	if f.ReadyOnly && !isNodeReady(n) {
+		return false
 	}
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		log.Errorf("Failed to get node list: %s", err)
-		return
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(n.Labels)) {
+		return false
+	}
+	return true
+}
+
+func isNodeReady(n v1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
 	}
+	return false
+}
+
+// getNodeAddresses returns the InternalIP addresses of the given nodes,
+// keyed by the node short name.
+func getNodeAddresses(nodes []v1.Node, filter func(v1.Node) bool) []NodeAddress {
+	var result []NodeAddress
 	var nodeAddresses []net.IP
-	for _, n := range nodes.Items {
+	for _, n := range nodes {
+		if filter != nil && !filter(n) {
+			log.Debugf("Skipping node %s for node addresses", n.Name)
+			continue
+		}
 		name := ""
 		nodeAddresses = nil
 		for _, a := range n.Status.Addresses {
@@ -777,7 +951,7 @@ func PopulateNodeAddresses(kubeconfigPath string, node *Node) {
 			}
 		}
 		if name == "" || (nodeAddresses == nil) {
-			log.Warningf("Could not handle node: %v", node)
+			log.Warningf("Could not handle node: %v", n.Name)
 			continue
 		}
 		// TODO(bnemec): The ipv6 flag isn't currently used in the templates,
@@ -789,12 +963,29 @@ func PopulateNodeAddresses(kubeconfigPath string, node *Node) {
 			if check != nil {
 				ipv6 = false
 			}
-			node.Cluster.NodeAddresses = append(node.Cluster.NodeAddresses, NodeAddress{Address: addr.String(), Name: name, Ipv6: ipv6})
+			result = append(result, NodeAddress{Address: addr.String(), Name: name, Ipv6: ipv6, PTRName: utils.ReverseAddr(addr)})
 		}
 	}
+	return result
+}
+
+func PopulateNodeAddresses(kubeconfigPath string, node *Node) {
+	PopulateNodeAddressesWithFilter(kubeconfigPath, node, IngressNodeFilter{})
 }
 
-func getNodeConfigWithCloudLBIPs(kubeconfigPath, clusterConfigPath, resolvConfPath string, clusterLBConfig ClusterLBConfig) (node Node, err error) {
+// PopulateNodeAddressesWithFilter fills in NodeAddresses with every node of the cluster
+// and IngressNodeAddresses with the nodes accepted by ingressFilter.
+func PopulateNodeAddressesWithFilter(kubeconfigPath string, node *Node, ingressFilter IngressNodeFilter) {
+	nodes, err := SharedNodeCache().List("", kubeconfigPath, "")
+	if err != nil {
+		log.Errorf("Failed to get node list: %s", err)
+		return
+	}
+	node.Cluster.NodeAddresses = append(node.Cluster.NodeAddresses, getNodeAddresses(nodes, nil)...)
+	node.Cluster.IngressNodeAddresses = append(node.Cluster.IngressNodeAddresses, getNodeAddresses(nodes, ingressFilter.matches)...)
+}
+
+func getNodeConfigWithCloudLBIPs(span *tracing.Span, opts Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, clusterLBConfig ClusterLBConfig) (node Node, err error) {
 	var apiLBIP, apiIntLBIP, ingressIP net.IP
 	nodes := []Node{}
 
@@ -831,7 +1022,7 @@ func getNodeConfigWithCloudLBIPs(kubeconfigPath, clusterConfigPath, resolvConfPa
 		} else {
 			ingressIP = nil
 		}
-		newNode, err := getNodeConfig(kubeconfigPath, clusterConfigPath, resolvConfPath, nil, nil, 0, 0, 0)
+		newNode, err := getNodeConfig(span, opts, kubeconfigPath, clusterConfigPath, resolvConfPath, nil, nil, 0, 0, 0)
 		if err != nil {
 			return Node{}, err
 		}
@@ -845,6 +1036,77 @@ func getNodeConfigWithCloudLBIPs(kubeconfigPath, clusterConfigPath, resolvConfPa
 	return nodes[0], nil
 }
 
+// withVIPs returns the LB IPs of c, with the VIPs in place of the API and
+// ingress LB IPs that are not given
+func (c ClusterLBConfig) withVIPs(apiVips, ingressVips []net.IP) ClusterLBConfig {
+	if len(c.ApiIntLBIPs) == 0 {
+		c.ApiIntLBIPs = apiVips
+	}
+	if len(c.ApiLBIPs) == 0 {
+		c.ApiLBIPs = apiVips
+	}
+	if len(c.IngressLBIPs) == 0 {
+		c.IngressLBIPs = ingressVips
+	}
+	return c
+}
+
+// ipAt returns the IP at index i of ips, nil when ips is shorter
+func ipAt(ips []net.IP, i int) net.IP {
+	if i < len(ips) {
+		return ips[i]
+	}
+	return nil
+}
+
+// getNodeConfigWithUserManagedLB returns the config of a cluster that uses
+// load balancers of the user. Only what the DNS of the nodes needs is set:
+// the api and api-int records point at the API LB IPs and the apps record
+// at the ingress LB IPs, through the VIPs of each nested config, and there
+// are no VRRP or haproxy settings.
+func getNodeConfigWithUserManagedLB(span *tracing.Span, opts Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, clusterLBConfig ClusterLBConfig) (node Node, err error) {
+	ipCount := max(len(clusterLBConfig.ApiIntLBIPs), len(clusterLBConfig.IngressLBIPs))
+	if ipCount == 0 {
+		return Node{}, fmt.Errorf("No API or Ingress load balancer IP given for a user managed load balancer")
+	}
+
+	phase := span.Phase("clusterName")
+	clusterName, clusterDomain, err := GetClusterNameAndDomain(opts, kubeconfigPath, clusterConfigPath)
+	phase.End()
+	if err != nil {
+		return Node{}, err
+	}
+	shortHostname, err := utils.ShortHostname()
+	if err != nil {
+		return Node{}, err
+	}
+
+	nodes := []Node{}
+	for i := 0; i < ipCount; i++ {
+		newNode := Node{UserManagedLB: true, ShortHostname: shortHostname}
+		newNode.Cluster.Name = clusterName
+		newNode.Cluster.Domain = clusterDomain
+		apiIntLBIP := ipAt(clusterLBConfig.ApiIntLBIPs, i)
+		ingressLBIP := ipAt(clusterLBConfig.IngressLBIPs, i)
+		newNode.Cluster.setVIPs(apiIntLBIP, ingressLBIP)
+		newNode, err = updateNodewithCloudInfo(ipAt(clusterLBConfig.ApiLBIPs, i), apiIntLBIP, ingressLBIP, resolvConfPath, newNode)
+		if err != nil {
+			return Node{}, err
+		}
+		nodes = append(nodes, newNode)
+	}
+	// The first config lists every LB IP, as PopulateCloudLBIPAddresses does
+	nodes[0].Cluster.APILBIPs = nil
+	nodes[0].Cluster.APIIntLBIPs = nil
+	nodes[0].Cluster.IngressLBIPs = nil
+	nodes[0], err = PopulateCloudLBIPAddresses(clusterLBConfig, nodes[0])
+	if err != nil {
+		return Node{}, err
+	}
+	nodes[0].Configs = &nodes
+	return nodes[0], nil
+}
+
 func updateNodewithCloudInfo(apiLBIP, apiIntLBIP, ingressIP net.IP, resolvConfPath string, node Node) (updatedNode Node, err error) {
 	var validLBIP net.IP
 	if apiIntLBIP != nil {
@@ -3,12 +3,16 @@ package config
 import (
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
 )
 
 var (
@@ -87,12 +91,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 	Context("for dual-stack node", func() {
 		Context("with address only in status", func() {
 			It("matches an IPv4 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack1, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack1, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 				Expect(res).To(Equal("192.168.1.99"))
 				Expect(err).To(BeNil())
 			})
 			It("matches an IPv6 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack1, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack1, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 				Expect(res).To(Equal("fd00::5"))
 				Expect(err).To(BeNil())
 			})
@@ -100,12 +104,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 		Context("with address only in OVN HostAddresses annotation", func() {
 			It("matches an IPv4 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack3, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack3, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 				Expect(res).To(Equal("192.168.1.99"))
 				Expect(err).To(BeNil())
 			})
 			It("matches an IPv6 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack3, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack3, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 				Expect(res).To(Equal("fd00::5"))
 				Expect(err).To(BeNil())
 			})
@@ -113,12 +117,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 		Context("with address only in OVN HostCidrs annotation", func() {
 			It("matches an IPv4 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack5, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack5, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 				Expect(res).To(Equal("192.168.1.99"))
 				Expect(err).To(BeNil())
 			})
 			It("matches an IPv6 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack5, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack5, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 				Expect(res).To(Equal("fd00::5"))
 				Expect(err).To(BeNil())
 			})
@@ -126,12 +130,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 		Context("with address in status and OVN HostAddresses annotation", func() {
 			It("matches an IPv4 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack2, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack2, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 				Expect(res).To(Equal("192.168.1.99"))
 				Expect(err).To(BeNil())
 			})
 			It("matches an IPv6 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack2, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack2, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 				Expect(res).To(Equal("fd00::5"))
 				Expect(err).To(BeNil())
 			})
@@ -139,12 +143,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 		Context("with address in status and OVN HostCidrs annotation", func() {
 			It("matches an IPv4 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack4, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack4, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 				Expect(res).To(Equal("192.168.1.99"))
 				Expect(err).To(BeNil())
 			})
 			It("matches an IPv6 VIP", func() {
-				res, err := getNodeIpForRequestedIpStack(testNodeDualStack4, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+				res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeDualStack4, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 				Expect(res).To(Equal("fd00::5"))
 				Expect(err).To(BeNil())
 			})
@@ -153,12 +157,12 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 	Context("for single-stack v4 node", func() {
 		It("matches an IPv4 VIP", func() {
-			res, err := getNodeIpForRequestedIpStack(testNodeSingleStackV4, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+			res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeSingleStackV4, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 			Expect(res).To(Equal("192.168.1.99"))
 			Expect(err).To(BeNil())
 		})
 		It("empty for IPv6 VIP", func() {
-			res, err := getNodeIpForRequestedIpStack(testNodeSingleStackV4, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+			res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeSingleStackV4, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 			Expect(res).To(Equal(""))
 			Expect(err).To(BeNil())
 		})
@@ -166,25 +170,25 @@ var _ = Describe("getNodePeersForIpStack", func() {
 
 	Context("for single-stack v6 node", func() {
 		It("empty for IPv4 VIP", func() {
-			res, err := getNodeIpForRequestedIpStack(testNodeSingleStackV6, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+			res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeSingleStackV6, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 			Expect(res).To(Equal(""))
 			Expect(err).To(BeNil())
 		})
 		It("matches an IPv6 VIP", func() {
-			res, err := getNodeIpForRequestedIpStack(testNodeSingleStackV6, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
+			res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeSingleStackV6, []string{testApiVipV6, testIngressVipV6}, testMachineNetworkV6, debug)
 			Expect(res).To(Equal("fd00::5"))
 			Expect(err).To(BeNil())
 		})
 	})
 
 	It("empty for empty node", func() {
-		res, err := getNodeIpForRequestedIpStack(v1.Node{}, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
+		res, err := getNodeIpForRequestedIpStack(DefaultOptions(), v1.Node{}, []string{testApiVipV4, testIngressVipV4}, testMachineNetworkV4, debug)
 		Expect(res).To(Equal(""))
 		Expect(err).To(BeNil())
 	})
 
 	It("empty for node with IPs and empty VIP requested", func() {
-		res, err := getNodeIpForRequestedIpStack(testNodeSingleStackV4, []string{}, testMachineNetworkV4, debug)
+		res, err := getNodeIpForRequestedIpStack(DefaultOptions(), testNodeSingleStackV4, []string{}, testMachineNetworkV4, debug)
 		Expect(res).To(Equal(""))
 		Expect(err.Error()).To(Equal("for node testNode requested NodeIP detection with empty filterIP list. Cannot detect IP stack"))
 	})
@@ -338,6 +342,156 @@ func deleteTempResolvConf() {
 	os.Remove("/tmp/resolvConf")
 }
 
+var _ = Describe("getNodeAddresses", func() {
+	newNode := func(name, ip string, ready bool, nodeLabels map[string]string) v1.Node {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeHostName, Address: name + ".example.com"},
+					{Type: v1.NodeInternalIP, Address: ip},
+				},
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+			},
+		}
+	}
+	nodes := []v1.Node{
+		newNode("master-0", "192.168.1.10", true, map[string]string{"node-role.kubernetes.io/master": ""}),
+		newNode("worker-0", "192.168.1.20", true, map[string]string{"node-role.kubernetes.io/worker": ""}),
+		newNode("worker-1", "fd00::21", false, map[string]string{"node-role.kubernetes.io/worker": ""}),
+	}
+
+	It("returns every node without a filter", func() {
+		Expect(getNodeAddresses(nodes, nil)).To(Equal([]NodeAddress{
+			{Address: "192.168.1.10", Name: "master-0", PTRName: "10.1.168.192.in-addr.arpa"},
+			{Address: "192.168.1.20", Name: "worker-0", PTRName: "20.1.168.192.in-addr.arpa"},
+			{Address: "fd00::21", Name: "worker-1", Ipv6: true, PTRName: "1.2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa"},
+		}))
+	})
+
+	It("skips NotReady nodes", func() {
+		filter := IngressNodeFilter{ReadyOnly: true}
+		Expect(getNodeAddresses(nodes, filter.matches)).To(HaveLen(2))
+	})
+
+	It("applies the ingress selector", func() {
+		selector, err := labels.Parse("node-role.kubernetes.io/worker")
+		Expect(err).ShouldNot(HaveOccurred())
+		filter := IngressNodeFilter{ReadyOnly: true, Selector: selector}
+		Expect(getNodeAddresses(nodes, filter.matches)).To(Equal([]NodeAddress{
+			{Address: "192.168.1.20", Name: "worker-0", PTRName: "20.1.168.192.in-addr.arpa"},
+		}))
+	})
+
+	It("renders the wildcard ingress records of the accepted nodes", func() {
+		dir, err := os.MkdirTemp("", "corefile")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		corefile := func(filter IngressNodeFilter) string {
+			node := Node{Cluster: Cluster{Domain: "ostest.test.metalkube.org"}, DNSUpstreams: []string{"192.168.111.1"}}
+			node.Cluster.IngressNodeAddresses = getNodeAddresses(nodes, filter.matches)
+			renderPath := filepath.Join(dir, "Corefile")
+			Expect(render.RenderFile(render.FileSpec{RenderPath: renderPath, TemplatePath: "../../test/data/Corefile.tmpl", Validate: render.ValidateCorefile}, node)).To(Succeed())
+			content, err := os.ReadFile(renderPath)
+			Expect(err).ShouldNot(HaveOccurred())
+			return string(content)
+		}
+
+		content := corefile(IngressNodeFilter{})
+		Expect(content).To(ContainSubstring("template IN A ostest.test.metalkube.org {\n        match .*.apps.ostest.test.metalkube.org\n        answer \"{{ .Name }} 60 in {{ .Type }} 192.168.1.10\"\n        answer \"{{ .Name }} 60 in {{ .Type }} 192.168.1.20\"\n        fallthrough\n"))
+		Expect(content).To(ContainSubstring("answer \"{{ .Name }} 60 in {{ .Type }} fd00::21\""))
+
+		By("dropping the NotReady node", func() {
+			content := corefile(IngressNodeFilter{ReadyOnly: true})
+			Expect(content).To(ContainSubstring("192.168.1.20"))
+			Expect(content).NotTo(ContainSubstring("fd00::21"))
+		})
+	})
+})
+
+var _ = Describe("GetConfig with a user managed LB", func() {
+	It("points the VIPs at the LB IPs", func() {
+		lbConfig := ClusterLBConfig{
+			ApiIntLBIPs:  []net.IP{testApiIntLBIPv4},
+			IngressLBIPs: []net.IP{testIngressOneIPv4, testIngressTwoIPv4},
+			UserManaged:  true}
+		node, err := GetConfig(DefaultOptions(), testKubeconfigPath, "../../test/data/cluster_config.yaml", testResolvConfPath, nil, nil, 0, 0, 0, lbConfig)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(node.UserManagedLB).To(BeTrue())
+		Expect(node.Cluster.APIVIP).To(Equal(expectedApiIntLBIPv4))
+		Expect(node.Cluster.IngressVIP).To(Equal(expectedIngressOneIPv4))
+		Expect(node.Cluster.APIIntLBIPs).To(Equal([]string{expectedApiIntLBIPv4}))
+		Expect(node.Cluster.IngressLBIPs).To(Equal([]string{expectedIngressOneIPv4, expectedIngressTwoIPv4}))
+		Expect(node.Cluster.APIVirtualRouterID).To(BeZero())
+		Expect(node.LBConfig.Backends).To(BeEmpty())
+		Expect(node.DNSUpstreams).To(Equal([]string{"169.254.169.254"}))
+		Expect(*node.Configs).To(HaveLen(2))
+		Expect((*node.Configs)[1].Cluster.APIVIP).To(BeEmpty())
+		Expect((*node.Configs)[1].Cluster.IngressVIP).To(Equal(expectedIngressTwoIPv4))
+	})
+
+	It("uses the VIPs when no LB IP is given", func() {
+		node, err := GetConfig(DefaultOptions(), testKubeconfigPath, "../../test/data/cluster_config.yaml", testResolvConfPath, []net.IP{net.ParseIP(testApiVipV6)}, []net.IP{net.ParseIP(testIngressVipV6)}, 0, 0, 0, ClusterLBConfig{UserManaged: true})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(node.Cluster.APIVIP).To(Equal(testApiVipV6))
+		Expect(node.Cluster.APIVIPRecordType).To(Equal("AAAA"))
+		Expect(node.Cluster.APILBIPs).To(Equal([]string{testApiVipV6}))
+		Expect(node.Cluster.IngressVIP).To(Equal(testIngressVipV6))
+	})
+
+	It("needs an LB IP", func() {
+		_, err := GetConfig(DefaultOptions(), testKubeconfigPath, "../../test/data/cluster_config.yaml", testResolvConfPath, nil, nil, 0, 0, 0, ClusterLBConfig{UserManaged: true})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("VIPInterface", func() {
+	It("returns the interface of each VIP", func() {
+		node := Node{
+			Cluster:              Cluster{APIVIP: "192.168.111.5", IngressVIP: "192.168.222.4"},
+			VRRPInterface:        "ens3",
+			APIVRRPInterface:     "ens3",
+			IngressVRRPInterface: "ens4",
+		}
+		nested := Node{
+			Cluster:              Cluster{APIVIP: "fd00::5", IngressVIP: "fd00::4"},
+			VRRPInterface:        "ens5",
+			APIVRRPInterface:     "ens5",
+			IngressVRRPInterface: "ens5",
+		}
+		node.Configs = &[]Node{node, nested}
+		Expect(node.VIPInterface("192.168.111.5")).To(Equal("ens3"))
+		Expect(node.VIPInterface("192.168.222.4")).To(Equal("ens4"))
+		Expect(node.VIPInterface("fd00::4")).To(Equal("ens5"))
+		Expect(node.VIPInterface("192.168.111.9")).To(Equal("ens3"))
+	})
+})
+
+var _ = Describe("GetNetworkType", func() {
+	It("reads the network type of the install-config", func() {
+		networkType, err := GetNetworkType("../../test/data/cluster_config.yaml")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(networkType).To(Equal("OpenShiftSDN"))
+	})
+})
+
+var _ = Describe("GetOVNInternalSubnets", func() {
+	It("reads the masquerade subnets of the network config", func() {
+		subnets, err := GetOVNInternalSubnets("../../test/data/cluster_network_config.yaml")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(subnets).To(Equal([]string{"169.254.0.0/17", "fd69::/125"}))
+	})
+
+	It("fails on a missing network config", func() {
+		_, err := GetOVNInternalSubnets("../../test/data/missing.yaml")
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
 func Test(t *testing.T) {
 	createTempResolvConf()
 	RegisterFailHandler(Fail)
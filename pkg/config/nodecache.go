@@ -0,0 +1,264 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// NodeCacheOptions configure the node cache shared by the monitors of a
+// process
+type NodeCacheOptions struct {
+	// LabelSelector and FieldSelector restrict every node list of the
+	// process, e.g. to the masters
+	LabelSelector string
+	FieldSelector string
+	// Resync is how long a list is served from the cache before the nodes
+	// are listed again. The nodes are listed on every call when zero.
+	Resync time.Duration
+	// Transform is applied to every listed node before it is cached
+	Transform func(node *v1.Node)
+}
+
+// DefaultNodeCacheOptions returns the options serving every node list of a
+// monitor iteration from a single API call
+func DefaultNodeCacheOptions() NodeCacheOptions {
+	return NodeCacheOptions{Resync: 5 * time.Second, Transform: StripNode}
+}
+
+// StripNode drops the fields of node that are never used, which are also its
+// largest ones
+func StripNode(node *v1.Node) {
+	node.ManagedFields = nil
+	node.Status.Images = nil
+}
+
+type nodeListKey struct {
+	apiServerURL   string
+	kubeconfigPath string
+	labelSelector  string
+}
+
+type nodeList struct {
+	items  []v1.Node
+	listed time.Time
+}
+
+// NodeCache lists the nodes, serving repeated lists from memory until they
+// are older than the resync period
+type NodeCache struct {
+	lock  sync.Mutex
+	opts  NodeCacheOptions
+	lists map[nodeListKey]nodeList
+
+	// swapped out by the tests
+	list func(apiServerURL, kubeconfigPath string, opts metav1.ListOptions) ([]v1.Node, error)
+	now  func() time.Time
+}
+
+// NewNodeCache returns a node cache configured with opts
+func NewNodeCache(opts NodeCacheOptions) *NodeCache {
+	return &NodeCache{
+		opts:  opts,
+		lists: map[nodeListKey]nodeList{},
+		list:  listNodes,
+		now:   time.Now,
+	}
+}
+
+func listNodes(apiServerURL, kubeconfigPath string, opts metav1.ListOptions) ([]v1.Node, error) {
+	clientset, err := utils.SharedKubeClient(apiServerURL, kubeconfigPath).Clientset()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+var (
+	sharedNodeCacheLock sync.Mutex
+	sharedNodeCache     = NewNodeCache(DefaultNodeCacheOptions())
+)
+
+// SharedNodeCache returns the node cache of the process
+func SharedNodeCache() *NodeCache {
+	sharedNodeCacheLock.Lock()
+	defer sharedNodeCacheLock.Unlock()
+	return sharedNodeCache
+}
+
+// SetNodeCacheOptions replaces the node cache of the process with one
+// configured with opts. It is meant to be called once at startup.
+func SetNodeCacheOptions(opts NodeCacheOptions) {
+	sharedNodeCacheLock.Lock()
+	defer sharedNodeCacheLock.Unlock()
+	sharedNodeCache = NewNodeCache(opts)
+}
+
+// List returns the nodes matching labelSelector, and the label and field
+// selectors of the cache, through the API server at apiServerURL (the one of
+// the kubeconfig when empty). The returned nodes are shared and must not be
+// modified.
+func (c *NodeCache) List(apiServerURL, kubeconfigPath, labelSelector string) ([]v1.Node, error) {
+	selectors := []string{}
+	for _, s := range []string{c.opts.LabelSelector, labelSelector} {
+		if s != "" {
+			selectors = append(selectors, s)
+		}
+	}
+	key := nodeListKey{apiServerURL, kubeconfigPath, strings.Join(selectors, ",")}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached, ok := c.lists[key]; ok && c.now().Sub(cached.listed) < c.opts.Resync {
+		return cached.items, nil
+	}
+	items, err := c.list(apiServerURL, kubeconfigPath, metav1.ListOptions{
+		LabelSelector: key.labelSelector,
+		FieldSelector: c.opts.FieldSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.Transform != nil {
+		for i := range items {
+			c.opts.Transform(&items[i])
+		}
+	}
+	c.lists[key] = nodeList{items: items, listed: c.now()}
+	return items, nil
+}
+
+// nodeIPDebug returns whether the node IP selection logs at debug level
+func nodeIPDebug(apiServerURL, kubeconfigPath string) (bool, error) {
+	config, err := utils.GetClientConfig(apiServerURL, kubeconfigPath)
+	if err != nil {
+		return false, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return false, err
+	}
+	return utils.GetNodeIPDebugStatus(clientset), nil
+}
+
+// nodePeerAddresses returns the address of every node that matches the IP
+// stack of vips, skipping the nodes without one.
+//
+// As it is not possible to get cluster's Machine Network directly, we are
+// using a workaround by detecting which of the local interfaces belongs to
+// the same subnet as the first VIP. This interface can be used to detect
+// what was the original machine network as it contains the subnet mask that
+// we need.
+//
+// In case there is no subnet containing a VIP on any of the available NICs
+// we are counterintuitively selecting just a Node IP with the matching IP
+// stack. This is a weird case in e.g. vSphere where VIPs do not belong to the
+// L2 of the node, yet they work properly.
+func nodePeerAddresses(opts Options, nodes []v1.Node, vips []string, debug bool) []Backend {
+	peers := []Backend{}
+	machineNetwork, err := utils.GetLocalCIDRByIP(vips[0])
+	if err == nil {
+		for _, node := range nodes {
+			addr, err := getNodeIpForRequestedIpStack(opts, node, vips, machineNetwork, debug)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"err": err,
+				}).Warnf("For node %s could not retrieve node's IP. Ignoring", node.ObjectMeta.Name)
+				continue
+			}
+			peers = append(peers, Backend{Host: node.ObjectMeta.Name, Address: addr})
+		}
+		return peers
+	}
+
+	log.WithFields(logrus.Fields{
+		"err": err,
+	}).Errorf("Could not retrieve subnet for IP %s. Falling back to an IP of the matching IP stack", vips[0])
+	for _, node := range nodes {
+		addr := ""
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeInternalIP && utils.IsIPv6(net.ParseIP(address.Address)) == utils.IsIPv6(net.ParseIP(vips[0])) {
+				addr = address.Address
+				break
+			}
+		}
+		if addr == "" {
+			log.Warnf("Could not retrieve node's IP for %s. Ignoring", node.ObjectMeta.Name)
+			continue
+		}
+		peers = append(peers, Backend{Host: node.ObjectMeta.Name, Address: addr})
+	}
+	return peers
+}
+
+// IngressConfig returns the keepalived unicast peers of the ingress VIPs,
+// one address per node of the site of the node but the arbiter
+func (c *NodeCache) IngressConfig(opts Options, kubeconfigPath string, vips []string) (IngressConfig, error) {
+	var ingressConfig IngressConfig
+	nodes, err := c.List("", kubeconfigPath, "")
+	if err != nil {
+		return ingressConfig, err
+	}
+	if nodes, _, err = c.splitSites(opts.Sites, "", kubeconfigPath, nodes); err != nil {
+		return ingressConfig, err
+	}
+	nodes = withoutArbiters(nodes)
+	if len(vips) == 0 {
+		// This is not necessarily an error path because in handleBootstrapStopKeepalived we do
+		// call this function without providing any VIPs. Because of this, we only want to mark
+		// this scenario and avoid trying to calculate the machine networks.
+		log.Infof("Requested GetIngressConfig for empty VIP list.")
+		return ingressConfig, nil
+	}
+	debug, err := nodeIPDebug("", kubeconfigPath)
+	if err != nil {
+		return ingressConfig, err
+	}
+	for _, peer := range nodePeerAddresses(opts, nodes, vips, debug) {
+		ingressConfig.Peers = append(ingressConfig.Peers, peer.Address)
+	}
+	return ingressConfig, nil
+}
+
+// Backends returns the API backends, one per master of the site of the node
+// sorted by address, listing the nodes through the API server at
+// apiServerURL
+func (c *NodeCache) Backends(opts Options, apiServerURL, kubeconfigPath string, vips []net.IP) ([]Backend, error) {
+	nodes, err := c.List(apiServerURL, kubeconfigPath, labelNodeRolePrefix+"master=")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Info("Failed to get master Nodes list")
+		return []Backend{}, err
+	}
+	if len(vips) == 0 {
+		return []Backend{}, fmt.Errorf("Trying to build config using empty VIPs")
+	}
+	if nodes, _, err = c.splitSites(opts.Sites, apiServerURL, kubeconfigPath, nodes); err != nil {
+		return []Backend{}, err
+	}
+	debug, err := nodeIPDebug(apiServerURL, kubeconfigPath)
+	if err != nil {
+		return []Backend{}, err
+	}
+	backends := nodePeerAddresses(opts, withoutArbiters(nodes), utils.ConvertIpsToStrings(vips), debug)
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Address < backends[j].Address
+	})
+	return backends, nil
+}
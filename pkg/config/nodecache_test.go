@@ -0,0 +1,56 @@
+package config
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("NodeCache", func() {
+	var c *NodeCache
+	var lists []metav1.ListOptions
+	var now time.Time
+
+	BeforeEach(func() {
+		lists = nil
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		c = NewNodeCache(NodeCacheOptions{LabelSelector: "node-role.kubernetes.io/master=", Resync: 5 * time.Second, Transform: StripNode})
+		c.now = func() time.Time { return now }
+		c.list = func(apiServerURL, kubeconfigPath string, opts metav1.ListOptions) ([]v1.Node, error) {
+			lists = append(lists, opts)
+			return []v1.Node{{
+				ObjectMeta: metav1.ObjectMeta{Name: "master-0", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}},
+				Status:     v1.NodeStatus{Images: []v1.ContainerImage{{Names: []string{"quay.io/openshift/origin-keepalived"}}}},
+			}}, nil
+		}
+	})
+
+	It("shares_lists_until_the_resync", func() {
+		nodes, err := c.List("", "kubeconfig", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodes[0].ManagedFields).To(BeNil())
+		Expect(nodes[0].Status.Images).To(BeNil())
+
+		now = now.Add(4 * time.Second)
+		_, err = c.List("", "kubeconfig", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(HaveLen(1))
+
+		now = now.Add(time.Second)
+		_, err = c.List("", "kubeconfig", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(HaveLen(2))
+	})
+
+	It("combines_the_selectors", func() {
+		_, err := c.List("https://localhost:6443", "kubeconfig", "kubernetes.io/os=linux")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.List("", "kubeconfig", "kubernetes.io/os=linux")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(HaveLen(2))
+		Expect(lists[0].LabelSelector).To(Equal("node-role.kubernetes.io/master=,kubernetes.io/os=linux"))
+	})
+})
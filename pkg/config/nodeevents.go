@@ -0,0 +1,127 @@
+package config
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+)
+
+// NodeChange is a set of changes of the node list, used both as the changes
+// of a NodeEvent and as the filter of a subscription
+type NodeChange uint
+
+const (
+	// NodePeersChanged is set when a node joined or left the cluster
+	NodePeersChanged NodeChange = 1 << iota
+	// NodeMastersChanged is set when the set of masters changed
+	NodeMastersChanged
+	// NodeAddressesChanged is set when the addresses of a node changed
+	NodeAddressesChanged
+
+	AllNodeChanges = NodePeersChanged | NodeMastersChanged | NodeAddressesChanged
+)
+
+// NodeEvent notifies a subscriber of the changes of the node list. Events
+// that were not received yet are coalesced, so Nodes is always the latest
+// list and Changes covers every change since the previous event.
+type NodeEvent struct {
+	Changes NodeChange
+	Nodes   []v1.Node
+}
+
+// defaultNodeSubscriptionPoll is used when the cache has no resync period
+const defaultNodeSubscriptionPoll = 5 * time.Second
+
+func nodeNames(nodes []v1.Node, mastersOnly bool) []string {
+	names := []string{}
+	for _, node := range nodes {
+		if _, master := node.Labels[labelNodeRolePrefix+"master"]; mastersOnly && !master {
+			continue
+		}
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func nodeAddresses(nodes []v1.Node) map[string][]v1.NodeAddress {
+	addresses := map[string][]v1.NodeAddress{}
+	for _, node := range nodes {
+		addresses[node.Name] = node.Status.Addresses
+	}
+	return addresses
+}
+
+// diffNodes returns the changes between two lists of the same nodes
+func diffNodes(old, new []v1.Node) NodeChange {
+	var changes NodeChange
+	if !cmp.Equal(nodeNames(old, false), nodeNames(new, false)) {
+		changes |= NodePeersChanged
+	}
+	if !cmp.Equal(nodeNames(old, true), nodeNames(new, true)) {
+		changes |= NodeMastersChanged
+	}
+	if !cmp.Equal(nodeAddresses(old), nodeAddresses(new)) {
+		changes |= NodeAddressesChanged
+	}
+	return changes
+}
+
+// notify sends event on events without blocking. An event the subscriber
+// did not receive yet is replaced by one covering both.
+func notify(events chan NodeEvent, event NodeEvent) {
+	for {
+		select {
+		case events <- event:
+			return
+		default:
+		}
+		select {
+		case pending := <-events:
+			event.Changes |= pending.Changes
+		default:
+		}
+	}
+}
+
+// Subscribe lists the nodes once per resync period and sends an event every
+// time the list changes in a way matching filter. The first list is the
+// baseline and is not notified. The returned function stops the
+// subscription.
+func (c *NodeCache) Subscribe(kubeconfigPath string, filter NodeChange) (<-chan NodeEvent, func()) {
+	events := make(chan NodeEvent, 1)
+	stop := make(chan struct{})
+	poll := c.opts.Resync
+	if poll <= 0 {
+		poll = defaultNodeSubscriptionPoll
+	}
+
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		var last []v1.Node
+		listed := false
+		for {
+			nodes, err := c.List("", kubeconfigPath, "")
+			if err != nil {
+				log.WithError(err).Warn("Failed to list nodes for the subscribers")
+			} else {
+				if listed {
+					if changes := diffNodes(last, nodes) & filter; changes != 0 {
+						log.WithField("changes", changes).Debug("Node change detected")
+						notify(events, NodeEvent{Changes: changes, Nodes: nodes})
+					}
+				}
+				last, listed = nodes, true
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return events, func() { close(stop) }
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("NodeEvents", func() {
+	node := func(name, address string, master bool) v1.Node {
+		n := v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}}},
+		}
+		if master {
+			n.Labels[labelNodeRolePrefix+"master"] = ""
+		}
+		return n
+	}
+
+	It("diffs_node_lists", func() {
+		nodes := []v1.Node{node("master-0", "192.168.111.20", true), node("worker-0", "192.168.111.30", false)}
+		Expect(diffNodes(nodes, nodes)).To(BeZero())
+		Expect(diffNodes(nodes, nodes[:1])).To(Equal(NodePeersChanged | NodeAddressesChanged))
+		Expect(diffNodes(nodes[:1], []v1.Node{node("master-0", "192.168.111.21", true)})).To(Equal(NodeAddressesChanged))
+		Expect(diffNodes(nodes, []v1.Node{nodes[0], node("worker-0", "192.168.111.30", true)})).To(Equal(NodeMastersChanged))
+	})
+
+	It("coalesces_pending_events", func() {
+		events := make(chan NodeEvent, 1)
+		first := []v1.Node{node("master-0", "192.168.111.20", true)}
+		second := []v1.Node{node("master-0", "192.168.111.21", true)}
+		notify(events, NodeEvent{Changes: NodePeersChanged, Nodes: first})
+		notify(events, NodeEvent{Changes: NodeAddressesChanged, Nodes: second})
+
+		event := <-events
+		Expect(event.Changes).To(Equal(NodePeersChanged | NodeAddressesChanged))
+		Expect(event.Nodes).To(Equal(second))
+		Expect(events).To(BeEmpty())
+	})
+})
@@ -0,0 +1,31 @@
+package config
+
+import "fmt"
+
+// The fields of Node that are left unresolved in Offline mode
+const (
+	UnresolvedSite                 = "Site"
+	UnresolvedControlPlaneTopology = "ControlPlaneTopology"
+	UnresolvedPriority             = "Priority"
+)
+
+var errOffline = fmt.Errorf("The API is not used in offline mode")
+
+// markUnresolved records that field of node could not be resolved offline
+func (n *Node) markUnresolved(field string) {
+	if !n.IsUnresolved(field) {
+		n.Unresolved = append(n.Unresolved, field)
+	}
+}
+
+// IsUnresolved returns whether field of the node was left unresolved in
+// Offline mode, for the templates to render a bootstrap-safe config, e.g.
+// {{ if .IsUnresolved "Priority" }}
+func (n Node) IsUnresolved(field string) bool {
+	for _, f := range n.Unresolved {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
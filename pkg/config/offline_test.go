@@ -0,0 +1,54 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Offline", func() {
+	origGetInfrastructure := getInfrastructure
+	var reads int
+	var opts Options
+
+	BeforeEach(func() {
+		reads = 0
+		opts = DefaultOptions()
+		opts.Offline = true
+		getInfrastructure = func(kubeconfigPath string) ([]byte, error) {
+			reads++
+			return []byte(`{"status": {"controlPlaneTopology": "HighlyAvailable"}}`), nil
+		}
+	})
+
+	AfterEach(func() {
+		getInfrastructure = origGetInfrastructure
+	})
+
+	It("leaves the topology unresolved without reading it", func() {
+		node := Node{}
+		populateTopology(opts, "kubeconfig", &node)
+		Expect(reads).To(Equal(0))
+		Expect(node.Cluster.ControlPlaneTopology).To(BeEmpty())
+		Expect(node.IsUnresolved(UnresolvedControlPlaneTopology)).To(BeTrue())
+	})
+
+	It("computes the priority without the role bonus", func() {
+		opts.Priorities.RoleBonus = map[string]int{"master": 100}
+		node := Node{ShortHostname: "master-0"}
+		populatePriority(opts, "kubeconfig", &node)
+		Expect(node.Priority).To(Equal(opts.Priorities.Priority("master-0", nil)))
+		Expect(node.Unresolved).To(Equal([]string{UnresolvedPriority}))
+	})
+
+	It("needs no role to compute the priority without role bonuses", func() {
+		node := Node{ShortHostname: "master-0"}
+		populatePriority(opts, "kubeconfig", &node)
+		Expect(node.Unresolved).To(BeEmpty())
+	})
+
+	It("does not read the cluster name from the API", func() {
+		_, _, err := GetClusterNameAndDomain(opts, "", "")
+		Expect(err).To(MatchError(ContainSubstring("offline")))
+		Expect(reads).To(Equal(0))
+	})
+})
@@ -0,0 +1,33 @@
+package config
+
+// Options are the settings of the commands that the node config depends on.
+// The commands start from DefaultOptions and set them from their flags.
+type Options struct {
+	// Offline makes GetConfig use local sources only, the cluster config,
+	// resolv.conf, the kubeconfig file and the VIPs, for the first boot of a
+	// node when there are no credentials yet or the API is down. The fields
+	// that need the API are left empty and listed in Node.Unresolved.
+	Offline bool
+	// Priorities are how the keepalived priorities of the nodes are
+	// computed
+	Priorities PriorityOptions
+	// Sites group the nodes of a stretched cluster, disabled when their
+	// label is empty
+	Sites Sites
+	// BackendSource selects how GetLBConfig discovers the API backends
+	BackendSource string
+	// ExtraAPIPorts are the ports the API is exposed on besides the API
+	// port, e.g. 443 for proxies passing it through
+	ExtraAPIPorts []APIPort
+	// ExcludedDevices are the glob patterns of the devices whose addresses
+	// are ignored on top of utils.DefaultExcludedDevices
+	ExcludedDevices []string
+}
+
+// DefaultOptions returns the options of a command without flags
+func DefaultOptions() Options {
+	return Options{
+		Priorities:    PriorityOptions{Base: 40, Spread: 10},
+		BackendSource: BackendSourceNodes,
+	}
+}
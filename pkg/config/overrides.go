@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// OverridesConfigMapPrefix is followed by the node name in the name of
+	// the ConfigMap holding the overrides of the node
+	OverridesConfigMapPrefix = "runtimecfg-overrides-"
+	// OverridesConfigMapKey is the ConfigMap key holding the overrides
+	OverridesConfigMapKey = "overrides.yaml"
+)
+
+// NodeOverrides are the per-node settings that take precedence over the
+// discovered configuration. They are set in the RuntimeNetConfig of the node
+// and reach the monitors through the ConfigMap of the node.
+type NodeOverrides struct {
+	// Interface pins the VRRP interface
+	Interface string `json:"interface,omitempty"`
+	// VRRPPriority is used as the Priority of the node instead of the
+	// computed one when set
+	VRRPPriority int `json:"vrrpPriority,omitempty"`
+	// ExcludedCIDRs hold addresses that are never used as peers or backends
+	ExcludedCIDRs []string `json:"excludedCIDRs,omitempty"`
+	// Unicast selects the keepalived mode instead of monitor-user.conf
+	Unicast *bool `json:"unicast,omitempty"`
+}
+
+// OverridesConfigMapName returns the name of the ConfigMap holding the
+// overrides of nodeName
+func OverridesConfigMapName(nodeName string) string {
+	return OverridesConfigMapPrefix + nodeName
+}
+
+// Validate checks the overrides before they are applied
+func (o NodeOverrides) Validate() error {
+	if o.VRRPPriority < 0 || o.VRRPPriority > 254 {
+		return fmt.Errorf("VRRP priority %d is out of the 1-254 range", o.VRRPPriority)
+	}
+	for _, cidr := range o.ExcludedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid excluded CIDR %s: %w", cidr, err)
+		}
+	}
+	return nil
+}
+
+// ParseNodeOverrides parses and validates the overrides stored in a
+// ConfigMap
+func ParseNodeOverrides(data string) (NodeOverrides, error) {
+	o := NodeOverrides{}
+	if err := yaml.Unmarshal([]byte(data), &o); err != nil {
+		return o, err
+	}
+	return o, o.Validate()
+}
+
+// GetNodeOverrides reads the overrides of nodeName from its ConfigMap in the
+// pod namespace. A missing ConfigMap is not an error and results in no
+// overrides.
+func GetNodeOverrides(kubeconfigPath, nodeName string) (NodeOverrides, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return NodeOverrides{}, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return NodeOverrides{}, err
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(os.Getenv("POD_NAMESPACE")).Get(context.TODO(), OverridesConfigMapName(nodeName), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return NodeOverrides{}, nil
+		}
+		return NodeOverrides{}, err
+	}
+	return ParseNodeOverrides(cm.Data[OverridesConfigMapKey])
+}
+
+func (o NodeOverrides) excluded(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range o.ExcludedCIDRs {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o NodeOverrides) applyOne(node *Node) {
+	node.Overrides = o
+	if o.Interface != "" {
+		node.VRRPInterface = o.Interface
+		node.VRRPBond = GetBond(o.Interface)
+		node.VRRPVLANID = vlanID(o.Interface)
+		node.APIVRRPInterface = o.Interface
+		node.IngressVRRPInterface = o.Interface
+	}
+	if o.VRRPPriority != 0 {
+		node.Priority = o.VRRPPriority
+	}
+	if len(o.ExcludedCIDRs) == 0 {
+		return
+	}
+	addresses := []NodeAddress{}
+	for _, a := range node.Cluster.NodeAddresses {
+		if !o.excluded(a.Address) {
+			addresses = append(addresses, a)
+		}
+	}
+	node.Cluster.NodeAddresses = addresses
+	backends := []Backend{}
+	for _, b := range node.LBConfig.Backends {
+		if !o.excluded(b.Address) {
+			backends = append(backends, b)
+		}
+	}
+	node.LBConfig.Backends = backends
+	peers := []string{}
+	for _, p := range node.IngressConfig.Peers {
+		if !o.excluded(p) {
+			peers = append(peers, p)
+		}
+	}
+	node.IngressConfig.Peers = peers
+}
+
+// Apply sets the overrides on node and on its nested configs
+func (o NodeOverrides) Apply(node *Node) {
+	o.applyOne(node)
+	if node.Configs == nil {
+		return
+	}
+	for i := range *node.Configs {
+		o.applyOne(&(*node.Configs)[i])
+	}
+}
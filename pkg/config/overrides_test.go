@@ -0,0 +1,47 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NodeOverrides", func() {
+	It("parses_and_validates", func() {
+		o, err := ParseNodeOverrides("interface: ens4\nvrrpPriority: 60\nexcludedCIDRs: [192.168.111.0/28]\nunicast: false\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.Interface).To(Equal("ens4"))
+		Expect(o.VRRPPriority).To(Equal(60))
+		Expect(o.Unicast).NotTo(BeNil())
+		Expect(*o.Unicast).To(BeFalse())
+
+		_, err = ParseNodeOverrides("vrrpPriority: 255\n")
+		Expect(err).To(MatchError(ContainSubstring("out of the 1-254 range")))
+		_, err = ParseNodeOverrides("excludedCIDRs: [192.168.111.1]\n")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("applies_to_the_nested_configs", func() {
+		node := Node{
+			VRRPInterface: "ens3",
+			Cluster: Cluster{NodeAddresses: []NodeAddress{
+				{Address: "192.168.111.20", Name: "master-0"},
+				{Address: "192.168.111.3", Name: "master-1"},
+			}},
+			LBConfig:      ApiLBConfig{Backends: []Backend{{Host: "master-1", Address: "192.168.111.3"}, {Host: "master-0", Address: "192.168.111.20"}}},
+			IngressConfig: IngressConfig{Peers: []string{"192.168.111.3", "192.168.111.21"}},
+		}
+		node.Configs = &[]Node{node}
+
+		o := NodeOverrides{Interface: "ens4", ExcludedCIDRs: []string{"192.168.111.0/30"}}
+		o.Apply(&node)
+		for _, n := range []Node{node, (*node.Configs)[0]} {
+			Expect(n.VRRPInterface).To(Equal("ens4"))
+			Expect(n.APIVRRPInterface).To(Equal("ens4"))
+			Expect(n.IngressVRRPInterface).To(Equal("ens4"))
+			Expect(n.Overrides).To(Equal(o))
+			Expect(n.Cluster.NodeAddresses).To(Equal([]NodeAddress{{Address: "192.168.111.20", Name: "master-0"}}))
+			Expect(n.LBConfig.Backends).To(Equal([]Backend{{Host: "master-0", Address: "192.168.111.20"}}))
+			Expect(n.IngressConfig.Peers).To(Equal([]string{"192.168.111.21"}))
+		}
+	})
+})
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// PriorityOptions are how the keepalived priority of a node is computed
+type PriorityOptions struct {
+	// Base is the priority of a node without role bonus before its offset
+	Base int
+	// Spread is the number of offsets the hash of the hostname of a node is
+	// spread over, so that the nodes of a role have distinct priorities
+	// without depending on which other nodes exist
+	Spread int
+	// RoleBonus is added to the priority of the nodes that have the
+	// node-role.kubernetes.io/<role> label, the highest one when a node has
+	// several roles
+	RoleBonus map[string]int
+}
+
+// Validate checks that every priority the options can compute is a valid
+// VRRP priority of a backup router, 1-254
+func (o PriorityOptions) Validate() error {
+	if o.Spread < 1 {
+		return fmt.Errorf("The VRRP priority spread must be at least 1")
+	}
+	lowest, highest := o.Base, o.Base
+	for _, bonus := range o.RoleBonus {
+		lowest = min(lowest, o.Base+bonus)
+		highest = max(highest, o.Base+bonus)
+	}
+	if lowest < 1 || highest+o.Spread-1 > 254 {
+		return fmt.Errorf("VRRP priorities %d-%d are out of the 1-254 range", lowest, highest+o.Spread-1)
+	}
+	return nil
+}
+
+// ParsePriorityRoleBonuses parses role=bonus values
+func ParsePriorityRoleBonuses(values []string) (map[string]int, error) {
+	bonuses := map[string]int{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid VRRP priority role bonus %s, expected role=bonus", value)
+		}
+		bonus, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid VRRP priority bonus %s of role %s", parts[1], parts[0])
+		}
+		bonuses[parts[0]] = bonus
+	}
+	return bonuses, nil
+}
+
+// priorityOffset spreads the hostnames over 0 to spread-1. Nodes with the
+// same offset and role tie, keepalived then elects the one with the highest
+// primary IP, which is deterministic too.
+func priorityOffset(hostname string, spread int) int {
+	h := fnv.New32a()
+	h.Write([]byte(hostname))
+	return int(h.Sum32() % uint32(spread))
+}
+
+// Priority returns the keepalived priority of the node with hostname and
+// labels. It only depends on them, so that it does not change when other
+// nodes join or leave and trigger preemptions.
+func (o PriorityOptions) Priority(hostname string, labels map[string]string) int {
+	bonus, found := 0, false
+	for role, roleBonus := range o.RoleBonus {
+		if _, ok := labels[labelNodeRolePrefix+role]; ok && (!found || roleBonus > bonus) {
+			bonus, found = roleBonus, true
+		}
+	}
+	return o.Base + bonus + priorityOffset(hostname, max(o.Spread, 1))
+}
+
+// populatePriority sets the keepalived priority of node. The role bonuses
+// are left out when the node cannot be read yet, e.g. before the API
+// answers, or in Offline mode.
+func populatePriority(opts Options, kubeconfigPath string, node *Node) {
+	labels := map[string]string{}
+	if len(opts.Priorities.RoleBonus) > 0 && opts.Offline {
+		node.markUnresolved(UnresolvedPriority)
+	} else if len(opts.Priorities.RoleBonus) > 0 {
+		nodes, err := SharedNodeCache().List("", kubeconfigPath, "")
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"kubeconfigPath": kubeconfigPath,
+			}).WithError(err).Debug("Failed to list the nodes for the role of the node")
+		}
+		if n := findNode(nodes, node.ShortHostname); n != nil {
+			labels = n.Labels
+		}
+	}
+	node.Priority = opts.Priorities.Priority(node.ShortHostname, labels)
+}
+
+// findNode returns the node whose short name is shortHostname
+func findNode(nodes []v1.Node, shortHostname string) *v1.Node {
+	for i := range nodes {
+		if strings.SplitN(nodes[i].Name, ".", 2)[0] == shortHostname {
+			return &nodes[i]
+		}
+	}
+	return nil
+}
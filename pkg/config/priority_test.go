@@ -0,0 +1,48 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PriorityOptions", func() {
+	opts := PriorityOptions{Base: 40, Spread: 10, RoleBonus: map[string]int{"master": 100, "worker": 0}}
+	master := map[string]string{labelNodeRolePrefix + "master": "", labelNodeRolePrefix + "worker": ""}
+
+	It("computes stable priorities from the hostname and roles", func() {
+		priority := opts.Priority("master-0", master)
+		Expect(priority).To(BeNumerically(">=", 140))
+		Expect(priority).To(BeNumerically("<", 150))
+		Expect(opts.Priority("master-0", master)).To(Equal(priority))
+		Expect(opts.Priority("master-0", nil)).To(Equal(priority - 100))
+	})
+
+	It("spreads the hostnames over the offsets", func() {
+		priorities := map[int]bool{}
+		for _, hostname := range []string{"master-0", "master-1", "master-2", "worker-0", "worker-1"} {
+			priorities[opts.Priority(hostname, nil)] = true
+		}
+		Expect(len(priorities)).To(BeNumerically(">", 1))
+	})
+
+	It("rejects options computing invalid priorities", func() {
+		Expect(opts.Validate()).To(Succeed())
+		Expect(PriorityOptions{Base: 200, Spread: 10, RoleBonus: map[string]int{"master": 50}}.Validate()).NotTo(Succeed())
+		Expect(PriorityOptions{Base: 0, Spread: 10}.Validate()).NotTo(Succeed())
+		Expect(PriorityOptions{Base: 40}.Validate()).NotTo(Succeed())
+	})
+
+	It("parses the role bonuses", func() {
+		bonuses, err := ParsePriorityRoleBonuses([]string{"master=100", "infra=-10"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(bonuses).To(Equal(map[string]int{"master": 100, "infra": -10}))
+		_, err = ParsePriorityRoleBonuses([]string{"master"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("prefers the priority of the overrides", func() {
+		node := Node{Priority: 45}
+		NodeOverrides{VRRPPriority: 60}.Apply(&node)
+		Expect(node.Priority).To(Equal(60))
+	})
+})
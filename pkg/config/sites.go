@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// Sites split a stretched cluster into groups of nodes, e.g. one per
+// datacenter, each with its own VIPs, keepalived peers and API backends
+type Sites struct {
+	// Label is the node label whose value names the site of a node. Sites
+	// are disabled when empty.
+	Label string
+	// APIVIPs and IngressVIPs are the VIPs of each site. A site without
+	// VIPs uses the ones given to GetConfig.
+	APIVIPs     map[string][]net.IP
+	IngressVIPs map[string][]net.IP
+}
+
+// Enabled returns whether the nodes are grouped in sites
+func (s Sites) Enabled() bool {
+	return s.Label != ""
+}
+
+// siteOf returns the site of node, empty when it has no site label
+func (s Sites) siteOf(node v1.Node) string {
+	return node.Labels[s.Label]
+}
+
+// localSite returns the site of the node the process runs on, found by its
+// short hostname among the nodes
+func (c *NodeCache) localSite(sites Sites, apiServerURL, kubeconfigPath string) (string, error) {
+	hostname, err := utils.ShortHostname()
+	if err != nil {
+		return "", err
+	}
+	nodes, err := c.List(apiServerURL, kubeconfigPath, "")
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if strings.SplitN(node.Name, ".", 2)[0] == hostname {
+			site := sites.siteOf(node)
+			if site == "" {
+				return "", fmt.Errorf("Node %s has no %s label", node.Name, sites.Label)
+			}
+			return site, nil
+		}
+	}
+	return "", fmt.Errorf("No node named %s to read the site from", hostname)
+}
+
+// splitSites returns the nodes of the site of the local node and the others.
+// Every node is local when the sites are disabled.
+func (c *NodeCache) splitSites(sites Sites, apiServerURL, kubeconfigPath string, nodes []v1.Node) (local, others []v1.Node, err error) {
+	if !sites.Enabled() {
+		return nodes, nil, nil
+	}
+	site, err := c.localSite(sites, apiServerURL, kubeconfigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range nodes {
+		if sites.siteOf(node) == site {
+			local = append(local, node)
+		} else {
+			others = append(others, node)
+		}
+	}
+	return local, others, nil
+}
+
+// siteVIPs returns the site of the local node and its VIPs, which are
+// apiVips and ingressVips unless the site has its own
+func siteVIPs(sites Sites, kubeconfigPath string, apiVips, ingressVips []net.IP) (string, []net.IP, []net.IP, error) {
+	site, err := SharedNodeCache().localSite(sites, "", kubeconfigPath)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("Failed to find the site of the node: %w", err)
+	}
+	if vips := sites.APIVIPs[site]; len(vips) > 0 {
+		apiVips = vips
+	}
+	if vips := sites.IngressVIPs[site]; len(vips) > 0 {
+		ingressVips = vips
+	}
+	return site, apiVips, ingressVips, nil
+}
+
+// setSite sets the site of node and derives its VRRP IDs from the site too,
+// so that the keepalived groups of sites sharing a link do not collide
+func (n *Node) setSite(site string) {
+	n.Site = site
+	if site == "" || n.Cluster.Name == "" {
+		return
+	}
+	c := Cluster{Name: n.Cluster.Name + "-" + site}
+	c.PopulateVRIDs()
+	n.Cluster.APIVirtualRouterID = c.APIVirtualRouterID
+	n.Cluster.IngressVirtualRouterID = c.IngressVirtualRouterID
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("Sites", func() {
+	var cache *NodeCache
+	var sites Sites
+	var local, remote v1.Node
+
+	BeforeEach(func() {
+		hostname, err := utils.ShortHostname()
+		Expect(err).ShouldNot(HaveOccurred())
+		local = v1.Node{ObjectMeta: metav1.ObjectMeta{Name: hostname, Labels: map[string]string{"example.com/site": "east"}}}
+		remote = v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master-2", Labels: map[string]string{"example.com/site": "west"}}}
+		cache = NewNodeCache(NodeCacheOptions{})
+		cache.list = func(apiServerURL, kubeconfigPath string, opts metav1.ListOptions) ([]v1.Node, error) {
+			return []v1.Node{local, remote}, nil
+		}
+		sites = Sites{Label: "example.com/site"}
+	})
+
+	It("splits the nodes by the site of the local node", func() {
+		sameSite, others, err := cache.splitSites(sites, "", "kubeconfig", []v1.Node{local, remote})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sameSite).To(Equal([]v1.Node{local}))
+		Expect(others).To(Equal([]v1.Node{remote}))
+	})
+
+	It("keeps every node without sites", func() {
+		sameSite, others, err := cache.splitSites(Sites{}, "", "kubeconfig", []v1.Node{local, remote})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sameSite).To(HaveLen(2))
+		Expect(others).To(BeEmpty())
+	})
+
+	It("fails when the local node has no site", func() {
+		delete(local.Labels, "example.com/site")
+		_, _, err := cache.splitSites(sites, "", "kubeconfig", []v1.Node{local, remote})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("derives the VRRP IDs from the site", func() {
+		east := Node{Cluster: Cluster{Name: "ostest"}}
+		east.Cluster.PopulateVRIDs()
+		west := east
+		east.setSite("east")
+		west.setSite("west")
+		Expect(east.Site).To(Equal("east"))
+		Expect(east.Cluster.APIVirtualRouterID).NotTo(Equal(west.Cluster.APIVirtualRouterID))
+		Expect(east.Cluster.APIVirtualRouterID).NotTo(Equal(east.Cluster.IngressVirtualRouterID))
+	})
+})
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// labelNodeRoleArbiter marks the arbiter of a two node control plane,
+	// which runs etcd but neither serves the API nor holds a VIP
+	labelNodeRoleArbiter = labelNodeRolePrefix + "arbiter"
+	// TopologyHighlyAvailableArbiter is the control plane topology of two
+	// masters and an arbiter, which the vendored API predates
+	TopologyHighlyAvailableArbiter configv1.TopologyMode = "HighlyAvailableArbiter"
+)
+
+// topologyRetry is how long a failure to read the control plane topology
+// is remembered, so that GetConfig does not wait for an API that is down on
+// every call
+const topologyRetry = time.Minute
+
+// The control plane topology never changes, so it is read once per
+// kubeconfig
+var (
+	topologies      = map[string]configv1.TopologyMode{}
+	topologyFailure = map[string]time.Time{}
+	topologiesLock  sync.Mutex
+)
+
+// swapped out by the tests
+var getInfrastructure = func(kubeconfigPath string) ([]byte, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = 5 * time.Second
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/config.openshift.io/v1/infrastructures/cluster").
+		DoRaw(context.TODO())
+}
+
+// GetControlPlaneTopology returns status.controlPlaneTopology of the cluster
+// Infrastructure
+func GetControlPlaneTopology(kubeconfigPath string) (configv1.TopologyMode, error) {
+	topologiesLock.Lock()
+	defer topologiesLock.Unlock()
+	if topology, ok := topologies[kubeconfigPath]; ok {
+		return topology, nil
+	}
+	if failed, ok := topologyFailure[kubeconfigPath]; ok && time.Since(failed) < topologyRetry {
+		return "", fmt.Errorf("Reading the control plane topology failed less than %s ago", topologyRetry)
+	}
+	data, err := getInfrastructure(kubeconfigPath)
+	if err != nil {
+		topologyFailure[kubeconfigPath] = time.Now()
+		return "", err
+	}
+	infra := configv1.Infrastructure{}
+	if err := json.Unmarshal(data, &infra); err != nil {
+		return "", err
+	}
+	topology := infra.Status.ControlPlaneTopology
+	if topology != "" {
+		topologies[kubeconfigPath] = topology
+	}
+	return topology, nil
+}
+
+func isArbiter(node v1.Node) bool {
+	_, ok := node.Labels[labelNodeRoleArbiter]
+	return ok
+}
+
+// withoutArbiters returns the nodes that are not arbiters
+func withoutArbiters(nodes []v1.Node) []v1.Node {
+	kept := make([]v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !isArbiter(node) {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+// populateTopology sets the control plane topology of node and whether node
+// is the arbiter. The topology is left empty when it cannot be read yet,
+// e.g. before the API answers, or in Offline mode.
+func populateTopology(opts Options, kubeconfigPath string, node *Node) {
+	if opts.Offline {
+		node.markUnresolved(UnresolvedControlPlaneTopology)
+		return
+	}
+	topology, err := GetControlPlaneTopology(kubeconfigPath)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"kubeconfigPath": kubeconfigPath,
+		}).WithError(err).Debug("Failed to read the control plane topology")
+		return
+	}
+	node.Cluster.ControlPlaneTopology = string(topology)
+	if topology != TopologyHighlyAvailableArbiter {
+		return
+	}
+	arbiters, err := SharedNodeCache().List("", kubeconfigPath, labelNodeRoleArbiter+"=")
+	if err != nil {
+		log.WithError(err).Warn("Failed to list the arbiter nodes")
+		return
+	}
+	for _, arbiter := range arbiters {
+		if strings.SplitN(arbiter.Name, ".", 2)[0] == node.ShortHostname {
+			node.Arbiter = true
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ControlPlaneTopology", func() {
+	var reads int
+	var infrastructure string
+	var readErr error
+	var origCache *NodeCache
+	origGetInfrastructure := getInfrastructure
+
+	arbiter := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "arbiter-0.example.com", Labels: map[string]string{labelNodeRoleArbiter: ""}},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.111.22"}}},
+	}
+	master := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "master-0", Labels: map[string]string{labelNodeRolePrefix + "master": ""}},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.111.20"}}},
+	}
+
+	BeforeEach(func() {
+		reads = 0
+		readErr = nil
+		infrastructure = `{"status": {"controlPlaneTopology": "HighlyAvailableArbiter"}}`
+		getInfrastructure = func(kubeconfigPath string) ([]byte, error) {
+			reads++
+			return []byte(infrastructure), readErr
+		}
+		origCache = SharedNodeCache()
+		cache := NewNodeCache(NodeCacheOptions{})
+		cache.list = func(apiServerURL, kubeconfigPath string, opts metav1.ListOptions) ([]v1.Node, error) {
+			Expect(opts.LabelSelector).To(Equal(labelNodeRoleArbiter + "="))
+			return []v1.Node{arbiter}, nil
+		}
+		sharedNodeCacheLock.Lock()
+		sharedNodeCache = cache
+		sharedNodeCacheLock.Unlock()
+	})
+
+	AfterEach(func() {
+		getInfrastructure = origGetInfrastructure
+		topologies = map[string]configv1.TopologyMode{}
+		topologyFailure = map[string]time.Time{}
+		sharedNodeCacheLock.Lock()
+		sharedNodeCache = origCache
+		sharedNodeCacheLock.Unlock()
+	})
+
+	It("reads the topology once", func() {
+		for i := 0; i < 2; i++ {
+			topology, err := GetControlPlaneTopology("kubeconfig")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(topology).To(Equal(TopologyHighlyAvailableArbiter))
+		}
+		Expect(reads).To(Equal(1))
+	})
+
+	It("does not read again right after a failure", func() {
+		readErr = errors.New("connection refused")
+		_, err := GetControlPlaneTopology("kubeconfig")
+		Expect(err).To(HaveOccurred())
+		_, err = GetControlPlaneTopology("kubeconfig")
+		Expect(err).To(HaveOccurred())
+		Expect(reads).To(Equal(1))
+	})
+
+	It("marks the arbiter node", func() {
+		node := Node{ShortHostname: "arbiter-0"}
+		populateTopology(DefaultOptions(), "kubeconfig", &node)
+		Expect(node.Cluster.ControlPlaneTopology).To(Equal("HighlyAvailableArbiter"))
+		Expect(node.Arbiter).To(BeTrue())
+
+		node = Node{ShortHostname: "master-0"}
+		populateTopology(DefaultOptions(), "kubeconfig", &node)
+		Expect(node.Arbiter).To(BeFalse())
+	})
+
+	It("leaves the arbiter out of the peers and backends", func() {
+		Expect(withoutArbiters([]v1.Node{master, arbiter})).To(Equal([]v1.Node{master}))
+		Expect(withoutNodeAddresses([]string{"192.168.111.20", "192.168.111.22"}, []v1.Node{arbiter})).To(Equal([]string{"192.168.111.20"}))
+	})
+})
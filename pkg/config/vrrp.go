@@ -0,0 +1,142 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	vrrpConfigMap = "keepalived-vrrp"
+	// vrrpAuthPassLength is the length keepalived truncates auth_pass to
+	vrrpAuthPassLength = 8
+)
+
+// VRRPSettings are the VRRP options of the keepalived instances that the
+// keepalived-vrrp ConfigMap sets. A zero value keeps the default of the
+// template.
+type VRRPSettings struct {
+	// Version is 2 or 3, VRRPv3 has no authentication
+	Version int
+	// AuthType is PASS or AH, no authentication when empty
+	AuthType string
+	AuthPass string
+	// AdvertInt is the advertisement interval in seconds, fractional with
+	// VRRPv3 only
+	AdvertInt string
+	// NoPreempt keeps a VIP on its holder when a higher priority node comes
+	// back
+	NoPreempt bool
+	// PreemptDelay is how many seconds a higher priority node waits after
+	// its start before it takes a VIP over
+	PreemptDelay int
+}
+
+// parseVRRPSettings validates the data of the keepalived-vrrp ConfigMap. It
+// also returns warnings about values keepalived would change.
+func parseVRRPSettings(data map[string]string) (VRRPSettings, []string, error) {
+	settings := VRRPSettings{}
+	warnings := []string{}
+	for key, value := range data {
+		value = strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "version":
+			if settings.Version, err = strconv.Atoi(value); err != nil || settings.Version < 2 || settings.Version > 3 {
+				return settings, nil, fmt.Errorf("Invalid VRRP version %s, must be 2 or 3", value)
+			}
+		case "auth_type":
+			settings.AuthType = strings.ToUpper(value)
+			if settings.AuthType != "PASS" && settings.AuthType != "AH" {
+				return settings, nil, fmt.Errorf("Invalid VRRP auth_type %s, must be PASS or AH", value)
+			}
+		case "auth_pass":
+			settings.AuthPass = value
+		case "advert_int":
+			advert, err := strconv.ParseFloat(value, 64)
+			if err != nil || advert <= 0 || advert > 255 {
+				return settings, nil, fmt.Errorf("Invalid VRRP advert_int %s, must be a number of seconds up to 255", value)
+			}
+			settings.AdvertInt = value
+		case "nopreempt":
+			if settings.NoPreempt, err = strconv.ParseBool(value); err != nil {
+				return settings, nil, fmt.Errorf("Invalid VRRP nopreempt %s, must be true or false", value)
+			}
+		case "preempt_delay":
+			if settings.PreemptDelay, err = strconv.Atoi(value); err != nil || settings.PreemptDelay < 0 || settings.PreemptDelay > 1000 {
+				return settings, nil, fmt.Errorf("Invalid VRRP preempt_delay %s, must be 0 to 1000 seconds", value)
+			}
+		default:
+			warnings = append(warnings, fmt.Sprintf("Unknown VRRP setting %s is ignored", key))
+		}
+	}
+
+	if settings.AuthPass != "" && settings.AuthType == "" {
+		settings.AuthType = "PASS"
+	}
+	if settings.AuthType != "" {
+		if settings.Version == 3 {
+			return settings, nil, fmt.Errorf("VRRPv3 has no authentication, remove auth_type and auth_pass")
+		}
+		if settings.AuthPass == "" {
+			return settings, nil, fmt.Errorf("VRRP auth_type %s needs an auth_pass", settings.AuthType)
+		}
+	}
+	if len(settings.AuthPass) > vrrpAuthPassLength {
+		warnings = append(warnings, fmt.Sprintf("VRRP auth_pass is longer than %d characters, keepalived only uses the first %d", vrrpAuthPassLength, vrrpAuthPassLength))
+		settings.AuthPass = settings.AuthPass[:vrrpAuthPassLength]
+	}
+	if settings.AdvertInt != "" && settings.Version != 3 {
+		if _, err := strconv.Atoi(settings.AdvertInt); err != nil {
+			return settings, nil, fmt.Errorf("VRRP advert_int %s must be a whole number of seconds before VRRPv3", settings.AdvertInt)
+		}
+	}
+	if settings.NoPreempt && settings.PreemptDelay > 0 {
+		warnings = append(warnings, "VRRP preempt_delay has no effect with nopreempt")
+	}
+	return settings, warnings, nil
+}
+
+// GetVRRPSettings reads the VRRP settings from the keepalived-vrrp ConfigMap
+// in the pod namespace. A missing ConfigMap is not an error and results in
+// the defaults of the template.
+func GetVRRPSettings(kubeconfigPath string) (VRRPSettings, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return VRRPSettings{}, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return VRRPSettings{}, err
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(os.Getenv("POD_NAMESPACE")).Get(context.TODO(), vrrpConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return VRRPSettings{}, nil
+		}
+		return VRRPSettings{}, err
+	}
+	settings, warnings, err := parseVRRPSettings(cm.Data)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"configmap": vrrpConfigMap,
+		}).WithError(err).Error("Invalid VRRP settings")
+		return VRRPSettings{}, err
+	}
+	for _, warning := range warnings {
+		log.WithFields(logrus.Fields{
+			"configmap": vrrpConfigMap,
+		}).Warn(warning)
+	}
+	return settings, nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VRRPSettings", func() {
+	It("parses the settings", func() {
+		settings, warnings, err := parseVRRPSettings(map[string]string{
+			"version":       "2",
+			"auth_type":     "pass",
+			"auth_pass":     "secret",
+			"advert_int":    "2",
+			"preempt_delay": "30",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+		Expect(settings).To(Equal(VRRPSettings{Version: 2, AuthType: "PASS", AuthPass: "secret", AdvertInt: "2", PreemptDelay: 30}))
+	})
+
+	It("truncates long passwords like keepalived does", func() {
+		settings, warnings, err := parseVRRPSettings(map[string]string{"auth_pass": "ostest_api_vip"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(warnings).To(HaveLen(1))
+		Expect(settings.AuthType).To(Equal("PASS"))
+		Expect(settings.AuthPass).To(Equal("ostest_a"))
+	})
+
+	It("allows fractional advertisement intervals with VRRPv3 only", func() {
+		_, _, err := parseVRRPSettings(map[string]string{"version": "3", "advert_int": "0.5", "nopreempt": "true"})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, err = parseVRRPSettings(map[string]string{"advert_int": "0.5"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects invalid settings", func() {
+		for _, data := range []map[string]string{
+			{"version": "4"},
+			{"version": "3", "auth_pass": "secret"},
+			{"auth_type": "MD5", "auth_pass": "secret"},
+			{"auth_type": "AH"},
+			{"advert_int": "0"},
+			{"nopreempt": "maybe"},
+			{"preempt_delay": "-1"},
+		} {
+			_, _, err := parseVRRPSettings(data)
+			Expect(err).To(HaveOccurred(), "%v", data)
+		}
+	})
+})
@@ -0,0 +1,388 @@
+// Package controller holds the BGPNodeConfigReconciler, which renders the
+// FRR configuration of every node from the BGP settings of the cluster
+// Infrastructure and has copy pods write it onto the nodes, for clusters
+// that advertise their VIPs with BGP instead of VRRP.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// NodeLabel holds the node of the ConfigMaps and copy pods the
+	// reconciler manages
+	NodeLabel = "bgp.onprem.openshift.io/node"
+	// GenerationAnnotation is the generation of the frr.conf of a
+	// ConfigMap, and the one a copy pod writes
+	GenerationAnnotation = "bgp.onprem.openshift.io/generation"
+	// AppliedGenerationAnnotation is the status of a ConfigMap, the last
+	// generation a copy pod wrote on the node
+	AppliedGenerationAnnotation = "bgp.onprem.openshift.io/applied-generation"
+	// ConfigKey is the key of frr.conf in the ConfigMaps
+	ConfigKey = "frr.conf"
+
+	copyContainerConfigDir = "/etc/frr-config"
+	copyContainerHostDir   = "/host"
+)
+
+var log = logging.Logger("controller")
+
+// BGPSpec is the BGP configuration of the cluster. The vendored openshift/api
+// has no field for it, it is read as JSON from
+// spec.platformSpec.baremetal.bgp of the cluster Infrastructure.
+type BGPSpec struct {
+	ASN     uint32   `json:"asn"`
+	PeerASN uint32   `json:"peerASN"`
+	Peers   []string `json:"peers"`
+	// Communities are set on the advertised VIP routes
+	Communities []string         `json:"communities,omitempty"`
+	BFD         config.BFDConfig `json:"bfd,omitempty"`
+}
+
+// Validate checks the ASNs, peers and BFD timers of s
+func (s BGPSpec) Validate() error {
+	if s.ASN == 0 || s.PeerASN == 0 {
+		return fmt.Errorf("Both the ASN and the peer ASN are required")
+	}
+	if len(s.Peers) == 0 {
+		return fmt.Errorf("At least one BGP peer is required")
+	}
+	for _, p := range s.Peers {
+		if net.ParseIP(p) == nil {
+			return fmt.Errorf("Invalid BGP peer %q", p)
+		}
+	}
+	return s.BFD.Validate()
+}
+
+// infrastructure is the part of the cluster Infrastructure the reconciler
+// reads
+type infrastructure struct {
+	Spec struct {
+		PlatformSpec struct {
+			BareMetal struct {
+				BGP *BGPSpec `json:"bgp,omitempty"`
+			} `json:"baremetal"`
+		} `json:"platformSpec"`
+	} `json:"spec"`
+	Status configv1.InfrastructureStatus `json:"status"`
+}
+
+// vips returns the API and ingress VIPs of the bare metal platform
+func (i infrastructure) vips() []net.IP {
+	vips := []net.IP{}
+	if i.Status.PlatformStatus == nil || i.Status.PlatformStatus.BareMetal == nil {
+		return vips
+	}
+	status := i.Status.PlatformStatus.BareMetal
+	for _, v := range append(append([]string{}, status.APIServerInternalIPs...), status.IngressIPs...) {
+		if ip := net.ParseIP(v); ip != nil {
+			vips = append(vips, ip)
+		}
+	}
+	return vips
+}
+
+// BGPNodeConfigReconciler renders the frr.conf of every node into a
+// ConfigMap of Namespace, and runs a pod on the node that copies each new
+// generation of it into HostDir. The generation the last successful copy
+// pod wrote is the status of the ConfigMap.
+type BGPNodeConfigReconciler struct {
+	KubeconfigPath string
+	Namespace      string
+	// TemplatePath is the frr.conf template rendered for every node
+	TemplatePath string
+	// CopyImage is the image of the copy pods, it needs a shell
+	CopyImage string
+	// HostDir is the directory of frr.conf on the nodes
+	HostDir string
+}
+
+// ConfigMapName returns the name of the ConfigMap holding the frr.conf of
+// node
+func ConfigMapName(node string) string {
+	return "frr-" + node
+}
+
+// copyPodName returns the name of the pod writing generation of the
+// frr.conf of node
+func copyPodName(node, generation string) string {
+	return fmt.Sprintf("frr-copy-%s-%s", node, generation)
+}
+
+// routerID returns the first IPv4 InternalIP of node, FRR only takes IPv4
+// router IDs
+func routerID(node v1.Node) string {
+	for _, a := range node.Status.Addresses {
+		if a.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(a.Address); ip != nil && ip.To4() != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// desiredConfigs renders the frr.conf of every node, by node name. The nodes
+// without an IPv4 InternalIP are skipped, FRR needs a router ID.
+func (r *BGPNodeConfigReconciler) desiredConfigs(spec BGPSpec, vips []net.IP, nodes []v1.Node) (map[string]string, error) {
+	prefixes := []string{}
+	for _, vip := range vips {
+		prefixes = append(prefixes, monitor.VIPPrefix(vip))
+	}
+	bgp := monitor.BGPConfig{
+		ASN:         spec.ASN,
+		PeerASN:     spec.PeerASN,
+		Peers:       spec.Peers,
+		Communities: spec.Communities,
+		BFD:         spec.BFD,
+	}
+	desired := map[string]string{}
+	for _, node := range nodes {
+		frr := monitor.NewFRRConfig(bgp, prefixes)
+		frr.RouterID = routerID(node)
+		if frr.RouterID == "" {
+			log.WithFields(logrus.Fields{"node": node.Name}).Warn("Skipping node without an IPv4 InternalIP")
+			continue
+		}
+		content, err := render.RenderContent(render.FileSpec{RenderPath: ConfigMapName(node.Name) + "/" + ConfigKey, TemplatePath: r.TemplatePath}, frr)
+		if err != nil {
+			return nil, err
+		}
+		desired[node.Name] = string(content)
+	}
+	return desired, nil
+}
+
+// copyPod returns the pod writing generation of the frr.conf of node into
+// HostDir, through a temporary file so FRR never reads a partial one
+func (r *BGPNodeConfigReconciler) copyPod(node, generation string) *v1.Pod {
+	privileged := true
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        copyPodName(node, generation),
+			Namespace:   r.Namespace,
+			Labels:      map[string]string{NodeLabel: node},
+			Annotations: map[string]string{GenerationAnnotation: generation},
+		},
+		Spec: v1.PodSpec{
+			NodeName:      node,
+			RestartPolicy: v1.RestartPolicyNever,
+			Tolerations:   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{{
+				Name:  "copy",
+				Image: r.CopyImage,
+				Command: []string{"/bin/sh", "-c", fmt.Sprintf("cp %[1]s/%[3]s %[2]s/.%[3]s.tmp && mv %[2]s/.%[3]s.tmp %[2]s/%[3]s",
+					copyContainerConfigDir, copyContainerHostDir, ConfigKey)},
+				SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+				VolumeMounts: []v1.VolumeMount{
+					{Name: "config", MountPath: copyContainerConfigDir, ReadOnly: true},
+					{Name: "host", MountPath: copyContainerHostDir},
+				},
+			}},
+			Volumes: []v1.Volume{
+				{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: ConfigMapName(node)},
+				}}},
+				{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: r.HostDir}}},
+			},
+		},
+	}
+}
+
+// copyActions compares the copy pods of a node with the generation of its
+// ConfigMap. It returns whether a copy pod has to be created, the pods to
+// delete and the generation the last successful copy wrote, empty when
+// none did.
+func copyActions(generation string, pods []v1.Pod) (create bool, deletes []string, applied string) {
+	create = true
+	for _, pod := range pods {
+		if pod.Annotations[GenerationAnnotation] != generation {
+			// Superseded, whether it ran or not
+			deletes = append(deletes, pod.Name)
+			continue
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded:
+			create = false
+			applied = generation
+		case v1.PodFailed:
+			// Created again on the next reconcile
+			log.WithFields(logrus.Fields{
+				"pod":        pod.Name,
+				"generation": generation,
+			}).Warn("Failed to copy FRR configuration to the node")
+			deletes = append(deletes, pod.Name)
+			create = false
+		default:
+			create = false
+		}
+	}
+	sort.Strings(deletes)
+	return create, deletes, applied
+}
+
+// Reconcile renders the frr.conf of every node from the BGP settings of the
+// cluster Infrastructure, updates their ConfigMaps, runs the copy pods of the
+// new generations and records the generation each node applied. Everything
+// it manages is deleted once the Infrastructure has no BGP settings.
+func (r *BGPNodeConfigReconciler) Reconcile(ctx context.Context) error {
+	clientConfig, err := utils.GetClientConfig("", r.KubeconfigPath)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	raw, err := clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/config.openshift.io/v1/infrastructures/cluster").
+		DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the cluster Infrastructure: %w", err)
+	}
+	infra := infrastructure{}
+	if err := json.Unmarshal(raw, &infra); err != nil {
+		return err
+	}
+
+	desired := map[string]string{}
+	if spec := infra.Spec.PlatformSpec.BareMetal.BGP; spec != nil {
+		if err := spec.Validate(); err != nil {
+			return fmt.Errorf("Invalid BGP configuration: %w", err)
+		}
+		nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		if desired, err = r.desiredConfigs(*spec, infra.vips(), nodes.Items); err != nil {
+			return err
+		}
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(r.Namespace)
+	pods := clientset.CoreV1().Pods(r.Namespace)
+	existing, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: NodeLabel})
+	if err != nil {
+		return err
+	}
+	current := map[string]v1.ConfigMap{}
+	for _, cm := range existing.Items {
+		node := cm.Labels[NodeLabel]
+		if _, ok := desired[node]; ok {
+			current[node] = cm
+			continue
+		}
+		if err := configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := pods.DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: NodeLabel + "=" + node}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{"node": node}).Info("Deleted FRR configuration")
+	}
+
+	nodes := []string{}
+	for node := range desired {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		cm, ok := current[node]
+		switch {
+		case !ok:
+			cm = v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        ConfigMapName(node),
+					Namespace:   r.Namespace,
+					Labels:      map[string]string{NodeLabel: node},
+					Annotations: map[string]string{GenerationAnnotation: "1"},
+				},
+				Data: map[string]string{ConfigKey: desired[node]},
+			}
+			created, err := configMaps.Create(ctx, &cm, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			cm = *created
+			log.WithFields(logrus.Fields{"node": node}).Info("Created FRR configuration")
+		case cm.Data[ConfigKey] != desired[node]:
+			generation, _ := strconv.ParseInt(cm.Annotations[GenerationAnnotation], 10, 64)
+			if cm.Annotations == nil {
+				cm.Annotations = map[string]string{}
+			}
+			cm.Annotations[GenerationAnnotation] = strconv.FormatInt(generation+1, 10)
+			cm.Data = map[string]string{ConfigKey: desired[node]}
+			updated, err := configMaps.Update(ctx, &cm, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			cm = *updated
+			log.WithFields(logrus.Fields{
+				"node":       node,
+				"generation": cm.Annotations[GenerationAnnotation],
+			}).Info("Updated FRR configuration")
+		}
+		if err := r.reconcileCopy(ctx, clientset, cm, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileCopy runs the copy pod of the generation of cm on node, cleans
+// up the other ones and records the generation the node applied on cm
+func (r *BGPNodeConfigReconciler) reconcileCopy(ctx context.Context, clientset *kubernetes.Clientset, cm v1.ConfigMap, node string) error {
+	pods := clientset.CoreV1().Pods(r.Namespace)
+	generation := cm.Annotations[GenerationAnnotation]
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: NodeLabel + "=" + node})
+	if err != nil {
+		return err
+	}
+	create, deletes, applied := copyActions(generation, list.Items)
+	for _, name := range deletes {
+		if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	if create {
+		if _, err := pods.Create(ctx, r.copyPod(node, generation), metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			"node":       node,
+			"generation": generation,
+		}).Info("Copying FRR configuration to the node")
+	}
+	if applied == "" || cm.Annotations[AppliedGenerationAnnotation] == applied {
+		return nil
+	}
+	cm.Annotations[AppliedGenerationAnnotation] = applied
+	if _, err := clientset.CoreV1().ConfigMaps(r.Namespace).Update(ctx, &cm, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		"node":       node,
+		"generation": applied,
+	}).Info("FRR configuration applied on the node")
+	return nil
+}
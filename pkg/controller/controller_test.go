@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const infra = `{
+  "apiVersion": "config.openshift.io/v1",
+  "kind": "Infrastructure",
+  "metadata": {"name": "cluster"},
+  "spec": {"platformSpec": {"type": "BareMetal", "baremetal": {"bgp": {
+    "asn": 64512, "peerASN": 64513, "peers": ["192.168.111.1"],
+    "bfd": {"enabled": true, "receiveInterval": 300, "transmitInterval": 300, "detectMultiplier": 3}
+  }}}},
+  "status": {"platformStatus": {"type": "BareMetal", "baremetal": {
+    "apiServerInternalIPs": ["192.168.111.5", "fd00::5"],
+    "ingressIPs": ["192.168.111.4"]
+  }}}
+}`
+
+var _ = Describe("bgp_node_config", func() {
+	newNode := func(name string, ips ...string) v1.Node {
+		node := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		for _, ip := range ips {
+			node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip})
+		}
+		return node
+	}
+	copyPod := func(name, generation string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{GenerationAnnotation: generation}},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+
+	It("reads_the_bgp_settings_and_vips_of_the_infrastructure", func() {
+		i := infrastructure{}
+		Expect(json.Unmarshal([]byte(infra), &i)).To(Succeed())
+		Expect(i.Spec.PlatformSpec.BareMetal.BGP).NotTo(BeNil())
+		spec := *i.Spec.PlatformSpec.BareMetal.BGP
+		Expect(spec.Validate()).To(Succeed())
+		Expect(spec.BFD.Enabled).To(BeTrue())
+		Expect(spec.BFD.DetectMultiplier).To(Equal(uint8(3)))
+		Expect(i.vips()).To(Equal([]net.IP{net.ParseIP("192.168.111.5"), net.ParseIP("fd00::5"), net.ParseIP("192.168.111.4")}))
+
+		spec.Peers = []string{"router"}
+		Expect(spec.Validate()).NotTo(Succeed())
+	})
+
+	It("renders_the_frr_config_of_every_node", func() {
+		r := &BGPNodeConfigReconciler{TemplatePath: "../../test/data/frr.conf.tmpl"}
+		spec := BGPSpec{ASN: 64512, PeerASN: 64513, Peers: []string{"192.168.111.1"}}
+		vips := []net.IP{net.ParseIP("192.168.111.5"), net.ParseIP("fd00::5")}
+		desired, err := r.desiredConfigs(spec, vips, []v1.Node{
+			newNode("master-0", "fd00::20", "192.168.111.20"),
+			newNode("master-1", "192.168.111.21"),
+			newNode("ipv6-only", "fd00::22"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desired).To(HaveLen(2))
+		Expect(desired["master-0"]).To(ContainSubstring("router bgp 64512\n bgp router-id 192.168.111.20\n"))
+		Expect(desired["master-0"]).To(ContainSubstring(" neighbor 192.168.111.1 remote-as 64513\n"))
+		Expect(desired["master-0"]).To(ContainSubstring("  network 192.168.111.5/32\n"))
+		Expect(desired["master-0"]).To(ContainSubstring("  network fd00::5/128\n"))
+		Expect(desired["master-1"]).To(ContainSubstring(" bgp router-id 192.168.111.21\n"))
+	})
+
+	It("creates_the_copy_pod_of_a_new_generation", func() {
+		create, deletes, applied := copyActions("2", []v1.Pod{copyPod("frr-copy-master-0-1", "1", v1.PodSucceeded)})
+		Expect(create).To(BeTrue())
+		Expect(deletes).To(Equal([]string{"frr-copy-master-0-1"}))
+		Expect(applied).To(BeEmpty())
+
+		By("waiting_for_the_running_one", func() {
+			create, deletes, applied := copyActions("2", []v1.Pod{copyPod("frr-copy-master-0-2", "2", v1.PodRunning)})
+			Expect(create).To(BeFalse())
+			Expect(deletes).To(BeEmpty())
+			Expect(applied).To(BeEmpty())
+		})
+	})
+
+	It("records_the_generation_of_a_successful_copy", func() {
+		create, deletes, applied := copyActions("2", []v1.Pod{copyPod("frr-copy-master-0-2", "2", v1.PodSucceeded)})
+		Expect(create).To(BeFalse())
+		Expect(deletes).To(BeEmpty())
+		Expect(applied).To(Equal("2"))
+
+		By("retrying_a_failed_copy", func() {
+			create, deletes, _ := copyActions("2", []v1.Pod{copyPod("frr-copy-master-0-2", "2", v1.PodFailed)})
+			Expect(create).To(BeFalse())
+			Expect(deletes).To(Equal([]string{"frr-copy-master-0-2"}))
+		})
+	})
+
+	It("copies_the_config_map_into_the_host_dir", func() {
+		r := &BGPNodeConfigReconciler{Namespace: "openshift-kni-infra", CopyImage: "runtimecfg", HostDir: "/etc/frr"}
+		pod := r.copyPod("master-0", "3")
+		Expect(pod.Name).To(Equal("frr-copy-master-0-3"))
+		Expect(pod.Labels).To(HaveKeyWithValue(NodeLabel, "master-0"))
+		Expect(pod.Annotations).To(HaveKeyWithValue(GenerationAnnotation, "3"))
+		Expect(pod.Spec.NodeName).To(Equal("master-0"))
+		Expect(pod.Spec.Containers[0].Command[2]).To(Equal("cp /etc/frr-config/frr.conf /host/.frr.conf.tmp && mv /host/.frr.conf.tmp /host/frr.conf"))
+		Expect(pod.Spec.Volumes[0].ConfigMap.Name).To(Equal(ConfigMapName("master-0")))
+		Expect(pod.Spec.Volumes[1].HostPath.Path).To(Equal("/etc/frr"))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controller tests")
+}
@@ -0,0 +1,108 @@
+// Package events records the failover related actions of the monitors as
+// Kubernetes Events attached to the Node they run on.
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	ReasonKeepalivedReloaded         = "KeepalivedReloaded"
+	ReasonKeepalivedReloadUnverified = "KeepalivedReloadUnverified"
+	ReasonHAProxyReloaded            = "HAProxyReloaded"
+	ReasonKeepalivedModeSwitch       = "KeepalivedModeSwitched"
+	ReasonFirewallRuleRepaired       = "FirewallRuleRepaired"
+	ReasonBootstrapVIPsReleased      = "BootstrapVIPsReleased"
+)
+
+var log = logging.Logger("events")
+
+// Recorder creates the Events of a component of a node. A nil Recorder
+// ignores the events, which is how the events are disabled.
+type Recorder struct {
+	Namespace string
+	NodeName  string
+	Component string
+
+	// swapped out by the tests
+	create func(event *v1.Event) error
+	now    func() time.Time
+}
+
+// NewRecorder returns the recorder of component on nodeName, creating the
+// Events in namespace. It returns nil when nodeName is empty.
+func NewRecorder(kubeconfigPath, namespace, nodeName, component string) *Recorder {
+	if nodeName == "" {
+		return nil
+	}
+	return &Recorder{
+		Namespace: namespace,
+		NodeName:  nodeName,
+		Component: component,
+		create: func(event *v1.Event) error {
+			clientset, err := utils.SharedKubeClient("", kubeconfigPath).Clientset()
+			if err != nil {
+				return err
+			}
+			_, err = clientset.CoreV1().Events(namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+			return err
+		},
+		now: time.Now,
+	}
+}
+
+// Normal records an action taken as expected
+func (r *Recorder) Normal(reason, messageFmt string, args ...interface{}) {
+	r.record(v1.EventTypeNormal, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// Warning records an action taken to recover from a problem
+func (r *Recorder) Warning(reason, messageFmt string, args ...interface{}) {
+	r.record(v1.EventTypeWarning, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *Recorder) event(eventType, reason, message string) *v1.Event {
+	now := metav1.NewTime(r.now())
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// The same scheme as client-go, unique per node and time
+			Name:      fmt.Sprintf("%s.%x", r.NodeName, now.UnixNano()),
+			Namespace: r.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       r.NodeName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: r.Component, Host: r.NodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+}
+
+// record creates the event. Failures are logged, the action itself already
+// happened.
+func (r *Recorder) record(eventType, reason, message string) {
+	if r == nil {
+		return
+	}
+	if err := r.create(r.event(eventType, reason, message)); err != nil {
+		log.WithFields(logrus.Fields{
+			"node":   r.NodeName,
+			"reason": reason,
+		}).WithError(err).Warn("Failed to record event")
+	}
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Recorder", func() {
+	It("attaches_the_events_to_the_node", func() {
+		created := []*v1.Event{}
+		r := NewRecorder("kubeconfig", "openshift-kni-infra", "master-0", "keepalived-monitor")
+		r.create = func(event *v1.Event) error {
+			created = append(created, event)
+			return nil
+		}
+		r.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+		r.Normal(ReasonKeepalivedModeSwitch, "Switched keepalived to %s mode", "unicast")
+		Expect(created).To(HaveLen(1))
+		event := created[0]
+		Expect(event.Namespace).To(Equal("openshift-kni-infra"))
+		Expect(event.InvolvedObject.Kind).To(Equal("Node"))
+		Expect(event.InvolvedObject.Name).To(Equal("master-0"))
+		Expect(event.Type).To(Equal(v1.EventTypeNormal))
+		Expect(event.Message).To(Equal("Switched keepalived to unicast mode"))
+		Expect(event.Source.Component).To(Equal("keepalived-monitor"))
+
+		r.create = func(event *v1.Event) error { return errors.New("forbidden") }
+		r.Warning(ReasonFirewallRuleRepaired, "Restored")
+	})
+
+	It("ignores_the_events_when_disabled", func() {
+		var r *Recorder
+		Expect(NewRecorder("kubeconfig", "openshift-kni-infra", "", "haproxy-monitor")).To(BeNil())
+		r.Normal(ReasonHAProxyReloaded, "Reloaded")
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Events tests")
+}
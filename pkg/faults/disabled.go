@@ -0,0 +1,6 @@
+//go:build !faultinjection
+
+package faults
+
+// Enabled is set by the faultinjection build tag
+const Enabled = false
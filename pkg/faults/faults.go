@@ -0,0 +1,154 @@
+// Package faults injects failures in the monitors so that QE can exercise
+// their failure handling in CI and in lab clusters. The injection points are
+// in every build, but the flags enabling them only exist in binaries built
+// with the faultinjection build tag, where Enabled is true.
+package faults
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
+
+var log = logging.Logger("faults")
+
+// Options configure the injected faults, none by default
+type Options struct {
+	// APIFailureRate is the fraction of the API requests failing
+	APIFailureRate float64
+	// RenderDelay is added before rendering each file
+	RenderDelay time.Duration
+	// NetlinkErrorRate is the fraction of the address and route listings
+	// failing
+	NetlinkErrorRate float64
+	// Seed of the random faults, the current time when zero. The same seed
+	// injects the same sequence of faults.
+	Seed int64
+}
+
+var (
+	lock    sync.Mutex
+	options Options
+	random  *rand.Rand
+)
+
+// Validate checks the rates and the delay
+func (o Options) Validate() error {
+	if o.APIFailureRate < 0 || o.APIFailureRate > 1 {
+		return fmt.Errorf("Invalid API failure rate %v, must be between 0 and 1", o.APIFailureRate)
+	}
+	if o.NetlinkErrorRate < 0 || o.NetlinkErrorRate > 1 {
+		return fmt.Errorf("Invalid netlink error rate %v, must be between 0 and 1", o.NetlinkErrorRate)
+	}
+	if o.RenderDelay < 0 {
+		return fmt.Errorf("Invalid render delay %v", o.RenderDelay)
+	}
+	return nil
+}
+
+// Configure sets the faults injected from now on. It is a no-op unless
+// Enabled.
+func Configure(opts Options) error {
+	if !Enabled {
+		return nil
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	options = opts
+	random = rand.New(rand.NewSource(seed))
+	if opts != (Options{Seed: opts.Seed}) {
+		log.WithFields(logrus.Fields{
+			"apiFailureRate":   opts.APIFailureRate,
+			"renderDelay":      opts.RenderDelay,
+			"netlinkErrorRate": opts.NetlinkErrorRate,
+			"seed":             seed,
+		}).Warn("Injecting faults")
+	}
+	return nil
+}
+
+// Active tells whether any fault is injected
+func Active() bool {
+	if !Enabled {
+		return false
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return options.APIFailureRate > 0 || options.RenderDelay > 0 || options.NetlinkErrorRate > 0
+}
+
+// hit draws whether a fault of rate is injected
+func hit(rate func(Options) float64) bool {
+	if !Enabled {
+		return false
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	r := rate(options)
+	return r > 0 && random.Float64() < r
+}
+
+// faultyTransport fails a share of the API requests before they are sent
+type faultyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *faultyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if hit(func(o Options) float64 { return o.APIFailureRate }) {
+		log.WithFields(logrus.Fields{
+			"method": req.Method,
+			"url":    req.URL.String(),
+		}).Debug("Injecting API failure")
+		return nil, fmt.Errorf("injected failure of %s %s", req.Method, req.URL.Path)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// WrapTransport fails a share of the requests through rt, it has the
+// signature of the rest.Config wrappers
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if !Enabled {
+		return rt
+	}
+	return &faultyTransport{next: rt}
+}
+
+// DelayRender sleeps for the render delay before a file is rendered
+func DelayRender() {
+	if !Enabled {
+		return
+	}
+	lock.Lock()
+	delay := options.RenderDelay
+	lock.Unlock()
+	if delay > 0 {
+		log.WithFields(logrus.Fields{
+			"delay": delay,
+		}).Debug("Injecting render delay")
+		time.Sleep(delay)
+	}
+}
+
+// NetlinkError returns an error for a share of the netlink operations op
+func NetlinkError(op string) error {
+	if hit(func(o Options) float64 { return o.NetlinkErrorRate }) {
+		log.WithFields(logrus.Fields{
+			"op": op,
+		}).Debug("Injecting netlink error")
+		return fmt.Errorf("injected netlink error in %s", op)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package faults
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+// netlinkErrors returns which of n netlink operations failed
+func netlinkErrors(n int) []bool {
+	failed := []bool{}
+	for i := 0; i < n; i++ {
+		failed = append(failed, NetlinkError("address list") != nil)
+	}
+	return failed
+}
+
+var _ = Describe("faults", func() {
+	AfterEach(func() {
+		Expect(Configure(Options{})).To(Succeed())
+	})
+
+	It("validates_the_options", func() {
+		Expect(Options{APIFailureRate: 0.5, NetlinkErrorRate: 1, RenderDelay: time.Second}.Validate()).To(Succeed())
+		Expect(Options{APIFailureRate: 1.5}.Validate()).NotTo(Succeed())
+		Expect(Options{NetlinkErrorRate: -0.1}.Validate()).NotTo(Succeed())
+		Expect(Options{RenderDelay: -time.Second}.Validate()).NotTo(Succeed())
+	})
+
+	It("injects_nothing_by_default", func() {
+		Expect(Active()).To(BeFalse())
+		Expect(netlinkErrors(10)).NotTo(ContainElement(true))
+		resp, err := WrapTransport(okTransport{}).RoundTrip(&http.Request{Method: http.MethodGet})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("replays_the_faults_of_a_seed", func() {
+		if !Enabled {
+			Skip("Must run with the faultinjection build tag")
+		}
+		Expect(Configure(Options{NetlinkErrorRate: 0.5, Seed: 42})).To(Succeed())
+		Expect(Active()).To(BeTrue())
+		first := netlinkErrors(20)
+		Expect(first).To(ContainElement(true))
+		Expect(first).To(ContainElement(false))
+		Expect(Configure(Options{NetlinkErrorRate: 0.5, Seed: 42})).To(Succeed())
+		Expect(netlinkErrors(20)).To(Equal(first))
+	})
+
+	It("fails_the_api_requests", func() {
+		if !Enabled {
+			Skip("Must run with the faultinjection build tag")
+		}
+		Expect(Configure(Options{APIFailureRate: 1, Seed: 1})).To(Succeed())
+		req, err := http.NewRequest(http.MethodGet, "https://api.example.com/api/v1/nodes", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = WrapTransport(okTransport{}).RoundTrip(req)
+		Expect(err).To(MatchError(ContainSubstring("injected failure of GET /api/v1/nodes")))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Faults tests")
+}
@@ -0,0 +1,282 @@
+// Package health publishes the health of the on-prem networking components
+// of a node as node annotations, and aggregates the annotations of every node
+// into the conditions of a ClusterOperator.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// AnnotationPrefix is followed by the component name in the node
+	// annotation holding the Status of the component
+	AnnotationPrefix = "health.onprem.openshift.io/"
+
+	ComponentKeepalived = "keepalived"
+	ComponentHAProxy    = "haproxy"
+	ComponentCoredns    = "coredns"
+	ComponentBGP        = "bgp"
+
+	clusterOperatorsPath = "/apis/config.openshift.io/v1/clusteroperators"
+)
+
+var log = logging.Logger("health")
+
+// Components are the components aggregated into the ClusterOperator
+var Components = []string{ComponentKeepalived, ComponentHAProxy, ComponentCoredns, ComponentBGP}
+
+// Status is the health of a component on a node
+type Status struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+	// Updated is refreshed on every publication, so that the status of a
+	// node whose monitor stopped becomes stale
+	Updated time.Time `json:"updated"`
+	// VIPs are the VIPs the node holds, published by keepalived
+	VIPs []string `json:"vips,omitempty"`
+}
+
+// Publisher publishes the status of a component of a node. The annotation is
+// only patched when the status changes or once per Heartbeat, to keep the
+// load on the API server low.
+type Publisher struct {
+	Component string
+	NodeName  string
+	Heartbeat time.Duration
+
+	last      *Status
+	published time.Time
+
+	// swapped out by the tests
+	patch func(annotation, value string) error
+	now   func() time.Time
+}
+
+// NewPublisher returns the publisher of component on nodeName, or nil when
+// nodeName is empty. A nil Publisher ignores the reports.
+func NewPublisher(kubeconfigPath, nodeName, component string, heartbeat time.Duration) *Publisher {
+	if nodeName == "" {
+		return nil
+	}
+	return &Publisher{
+		Component: component,
+		NodeName:  nodeName,
+		Heartbeat: heartbeat,
+		patch: func(annotation, value string) error {
+			return utils.PatchNodeAnnotations(kubeconfigPath, nodeName, map[string]string{annotation: value})
+		},
+		now: time.Now,
+	}
+}
+
+// Report publishes the status of the component if it changed or the last
+// publication is older than the heartbeat. Failures are logged and retried
+// on the next report.
+func (p *Publisher) Report(healthy bool, message string) {
+	p.ReportVIPs(healthy, message, nil)
+}
+
+// ReportVIPs is Report with the VIPs the node holds
+func (p *Publisher) ReportVIPs(healthy bool, message string, vips []string) {
+	if p == nil {
+		return
+	}
+	now := p.now()
+	if p.last != nil && p.last.Healthy == healthy && p.last.Message == message && reflect.DeepEqual(p.last.VIPs, vips) && now.Sub(p.published) < p.Heartbeat {
+		return
+	}
+	status := Status{Healthy: healthy, Message: message, Updated: now.UTC().Truncate(time.Second), VIPs: vips}
+	value, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	if err := p.patch(AnnotationPrefix+p.Component, string(value)); err != nil {
+		log.WithFields(logrus.Fields{
+			"node":      p.NodeName,
+			"component": p.Component,
+		}).WithError(err).Warn("Failed to publish component health")
+		return
+	}
+	p.last, p.published = &status, now
+}
+
+// nodeStatus returns the status of component published on node, and whether
+// there is one
+func nodeStatus(node v1.Node, component string) (Status, bool) {
+	var status Status
+	value, ok := node.Annotations[AnnotationPrefix+component]
+	if !ok {
+		return status, false
+	}
+	if err := json.Unmarshal([]byte(value), &status); err != nil {
+		return Status{Message: fmt.Sprintf("invalid status annotation: %v", err)}, true
+	}
+	return status, true
+}
+
+// Conditions aggregates the statuses published on nodes. The operator is
+// Degraded while a component is unhealthy or stale on any node, and not
+// Available when a component is unhealthy on every node that runs it.
+func Conditions(nodes []v1.Node, now time.Time, staleAfter time.Duration) []configv1.ClusterOperatorStatusCondition {
+	problems := []string{}
+	unavailable := []string{}
+	for _, component := range Components {
+		reporting, healthy := 0, 0
+		for _, node := range nodes {
+			status, ok := nodeStatus(node, component)
+			if !ok {
+				continue
+			}
+			reporting++
+			switch {
+			case now.Sub(status.Updated) > staleAfter:
+				problems = append(problems, fmt.Sprintf("%s on %s has not reported since %s", component, node.Name, status.Updated.Format(time.RFC3339)))
+			case !status.Healthy:
+				problems = append(problems, fmt.Sprintf("%s on %s is unhealthy: %s", component, node.Name, status.Message))
+			default:
+				healthy++
+			}
+		}
+		if reporting > 0 && healthy == 0 {
+			unavailable = append(unavailable, component)
+		}
+	}
+	sort.Strings(problems)
+
+	transition := metav1.NewTime(now)
+	available := configv1.ClusterOperatorStatusCondition{
+		Type:               configv1.OperatorAvailable,
+		Status:             configv1.ConditionTrue,
+		Reason:             "AsExpected",
+		LastTransitionTime: transition,
+	}
+	if len(unavailable) > 0 {
+		available.Status = configv1.ConditionFalse
+		available.Reason = "NoHealthyNode"
+		available.Message = "No node has a healthy " + strings.Join(unavailable, ", ")
+	}
+	degraded := configv1.ClusterOperatorStatusCondition{
+		Type:               configv1.OperatorDegraded,
+		Status:             configv1.ConditionFalse,
+		Reason:             "AsExpected",
+		LastTransitionTime: transition,
+	}
+	if len(problems) > 0 {
+		degraded.Status = configv1.ConditionTrue
+		degraded.Reason = "ComponentsUnhealthy"
+		degraded.Message = strings.Join(problems, "\n")
+	}
+	return []configv1.ClusterOperatorStatusCondition{available, degraded, {
+		Type:               configv1.OperatorProgressing,
+		Status:             configv1.ConditionFalse,
+		Reason:             "AsExpected",
+		LastTransitionTime: transition,
+	}}
+}
+
+// mergeConditions sets the conditions in existing, keeping the transition
+// time of the conditions whose status did not change
+func mergeConditions(existing, conditions []configv1.ClusterOperatorStatusCondition) []configv1.ClusterOperatorStatusCondition {
+	merged := []configv1.ClusterOperatorStatusCondition{}
+	for _, c := range conditions {
+		for _, old := range existing {
+			if old.Type == c.Type && old.Status == c.Status {
+				c.LastTransitionTime = old.LastTransitionTime
+			}
+		}
+		merged = append(merged, c)
+	}
+	for _, old := range existing {
+		found := false
+		for _, c := range conditions {
+			found = found || old.Type == c.Type
+		}
+		if !found {
+			merged = append(merged, old)
+		}
+	}
+	return merged
+}
+
+// UpdateClusterOperator lists the nodes and sets the aggregated conditions
+// on the ClusterOperator called name, creating it when missing. There is no
+// clientset for the OpenShift config API in the tree, so the
+// ClusterOperator is read and written through the REST client of the core
+// API.
+func UpdateClusterOperator(kubeconfigPath, name string, staleAfter time.Duration) error {
+	clientConfig, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	conditions := Conditions(nodes.Items, now, staleAfter)
+	recordNodeMetrics(nodes.Items, now, staleAfter)
+
+	rest := clientset.CoreV1().RESTClient()
+	co := configv1.ClusterOperator{}
+	raw, err := rest.Get().AbsPath(clusterOperatorsPath, name).DoRaw(context.TODO())
+	if apierrors.IsNotFound(err) {
+		co = configv1.ClusterOperator{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "config.openshift.io/v1", Kind: "ClusterOperator"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}
+		body, err := json.Marshal(co)
+		if err != nil {
+			return err
+		}
+		if raw, err = rest.Post().AbsPath(clusterOperatorsPath).Body(body).DoRaw(context.TODO()); err != nil {
+			return fmt.Errorf("failed to create ClusterOperator %s: %w", name, err)
+		}
+		log.WithFields(logrus.Fields{"name": name}).Info("Created ClusterOperator")
+	} else if err != nil {
+		return fmt.Errorf("failed to get ClusterOperator %s: %w", name, err)
+	}
+	if err := json.Unmarshal(raw, &co); err != nil {
+		return err
+	}
+
+	co.Status.Conditions = mergeConditions(co.Status.Conditions, conditions)
+	if len(co.Status.RelatedObjects) == 0 {
+		co.Status.RelatedObjects = []configv1.ObjectReference{{Resource: "nodes"}}
+	}
+	body, err := json.Marshal(co)
+	if err != nil {
+		return err
+	}
+	if _, err := rest.Put().AbsPath(clusterOperatorsPath, name, "status").Body(body).DoRaw(context.TODO()); err != nil {
+		return fmt.Errorf("failed to update ClusterOperator %s status: %w", name, err)
+	}
+	for _, c := range conditions {
+		log.WithFields(logrus.Fields{
+			"type":    c.Type,
+			"status":  c.Status,
+			"reason":  c.Reason,
+			"message": c.Message,
+		}).Debug("ClusterOperator condition")
+	}
+	return nil
+}
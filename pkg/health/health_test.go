@@ -0,0 +1,138 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeWith(name string, statuses map[string]Status) v1.Node {
+	node := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+	for component, status := range statuses {
+		value, _ := json.Marshal(status)
+		node.Annotations[AnnotationPrefix+component] = string(value)
+	}
+	return node
+}
+
+func condition(conditions []configv1.ClusterOperatorStatusCondition, t configv1.ClusterStatusConditionType) configv1.ClusterOperatorStatusCondition {
+	for _, c := range conditions {
+		if c.Type == t {
+			return c
+		}
+	}
+	return configv1.ClusterOperatorStatusCondition{}
+}
+
+var _ = Describe("Publisher", func() {
+	var r *Publisher
+	var patches []string
+	var now time.Time
+
+	BeforeEach(func() {
+		patches = nil
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		r = &Publisher{
+			Component: ComponentCoredns,
+			NodeName:  "master-0",
+			Heartbeat: time.Minute,
+			patch: func(annotation, value string) error {
+				patches = append(patches, annotation+"="+value)
+				return nil
+			},
+			now: func() time.Time { return now },
+		}
+	})
+
+	It("publishes_changes_and_heartbeats", func() {
+		r.Report(true, "")
+		Expect(patches).To(Equal([]string{AnnotationPrefix + `coredns={"healthy":true,"updated":"2024-01-01T00:00:00Z"}`}))
+
+		now = now.Add(30 * time.Second)
+		r.Report(true, "")
+		Expect(patches).To(HaveLen(1))
+
+		r.Report(false, "broken")
+		Expect(patches).To(HaveLen(2))
+
+		now = now.Add(time.Minute)
+		r.Report(false, "broken")
+		Expect(patches).To(HaveLen(3))
+	})
+
+	It("publishes_vip_changes", func() {
+		r.ReportVIPs(true, "", []string{"192.168.111.5"})
+		r.ReportVIPs(true, "", []string{"192.168.111.5"})
+		r.ReportVIPs(true, "", []string{})
+		Expect(patches).To(HaveLen(2))
+		Expect(patches[0]).To(ContainSubstring(`"vips":["192.168.111.5"]`))
+	})
+
+	It("retries_failed_publications", func() {
+		r.patch = func(annotation, value string) error { return fmt.Errorf("forbidden") }
+		r.Report(true, "")
+		Expect(r.last).To(BeNil())
+	})
+
+	It("ignores_reports_without_node", func() {
+		Expect(NewPublisher("", "", ComponentHAProxy, time.Minute)).To(BeNil())
+		var nilReporter *Publisher
+		nilReporter.Report(true, "")
+	})
+})
+
+var _ = Describe("Conditions", func() {
+	now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+	fresh := now.Add(-time.Minute)
+
+	It("is_available_when_all_healthy", func() {
+		nodes := []v1.Node{
+			nodeWith("master-0", map[string]Status{ComponentKeepalived: {Healthy: true, Updated: fresh}, ComponentCoredns: {Healthy: true, Updated: fresh}}),
+			nodeWith("worker-0", nil),
+		}
+		conditions := Conditions(nodes, now, 5*time.Minute)
+		Expect(condition(conditions, configv1.OperatorAvailable).Status).To(Equal(configv1.ConditionTrue))
+		Expect(condition(conditions, configv1.OperatorDegraded).Status).To(Equal(configv1.ConditionFalse))
+		Expect(condition(conditions, configv1.OperatorProgressing).Status).To(Equal(configv1.ConditionFalse))
+	})
+
+	It("is_degraded_by_unhealthy_or_stale_nodes", func() {
+		nodes := []v1.Node{
+			nodeWith("master-0", map[string]Status{ComponentHAProxy: {Healthy: false, Message: "API is not reachable through HAProxy", Updated: fresh}}),
+			nodeWith("master-1", map[string]Status{ComponentHAProxy: {Healthy: true, Updated: fresh}, ComponentCoredns: {Healthy: true, Updated: now.Add(-time.Hour)}}),
+		}
+		conditions := Conditions(nodes, now, 5*time.Minute)
+		degraded := condition(conditions, configv1.OperatorDegraded)
+		Expect(degraded.Status).To(Equal(configv1.ConditionTrue))
+		Expect(degraded.Message).To(Equal("coredns on master-1 has not reported since 2023-12-31T23:10:00Z\nhaproxy on master-0 is unhealthy: API is not reachable through HAProxy"))
+
+		available := condition(conditions, configv1.OperatorAvailable)
+		Expect(available.Status).To(Equal(configv1.ConditionFalse))
+		Expect(available.Message).To(Equal("No node has a healthy coredns"))
+	})
+
+	It("keeps_the_transition_time_of_unchanged_conditions", func() {
+		before := metav1.NewTime(now.Add(-time.Hour))
+		existing := []configv1.ClusterOperatorStatusCondition{
+			{Type: configv1.OperatorAvailable, Status: configv1.ConditionTrue, LastTransitionTime: before},
+			{Type: configv1.OperatorDegraded, Status: configv1.ConditionTrue, LastTransitionTime: before},
+			{Type: configv1.OperatorUpgradeable, Status: configv1.ConditionTrue, LastTransitionTime: before},
+		}
+		merged := mergeConditions(existing, Conditions(nil, now, time.Minute))
+		Expect(condition(merged, configv1.OperatorAvailable).LastTransitionTime).To(Equal(before))
+		Expect(condition(merged, configv1.OperatorDegraded).LastTransitionTime).To(Equal(metav1.NewTime(now)))
+		Expect(condition(merged, configv1.OperatorUpgradeable).Status).To(Equal(configv1.ConditionTrue))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Health tests")
+}
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	componentNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_component_nodes",
+		Help: "Number of nodes by on-prem networking component and state (healthy, unhealthy or stale)",
+	}, []string{"component", "state"})
+	bgpGenerationLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_bgp_generation_lag_seconds",
+		Help: "Time since the node last confirmed that its FRR configuration advertises the VIPs of its healthy services, up to the health heartbeat while it is up to date",
+	}, []string{"node"})
+	vipOwner = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_vip_owner",
+		Help: "1 for the node holding the VIP, as published by its keepalived monitor",
+	}, []string{"vip", "node"})
+)
+
+func init() {
+	prometheus.MustRegister(componentNodes, bgpGenerationLag, vipOwner)
+}
+
+// recordNodeMetrics sets the per-node rollups from the statuses published on
+// nodes. The gauges are reset first so that deleted nodes and moved VIPs
+// disappear.
+func recordNodeMetrics(nodes []v1.Node, now time.Time, staleAfter time.Duration) {
+	componentNodes.Reset()
+	bgpGenerationLag.Reset()
+	vipOwner.Reset()
+	for _, component := range Components {
+		for _, state := range []string{"healthy", "unhealthy", "stale"} {
+			componentNodes.WithLabelValues(component, state).Set(0)
+		}
+		for _, node := range nodes {
+			status, ok := nodeStatus(node, component)
+			if !ok {
+				continue
+			}
+			state := "healthy"
+			switch {
+			case now.Sub(status.Updated) > staleAfter:
+				state = "stale"
+			case !status.Healthy:
+				state = "unhealthy"
+			}
+			componentNodes.WithLabelValues(component, state).Inc()
+			switch component {
+			case ComponentBGP:
+				if !status.Healthy {
+					continue
+				}
+				bgpGenerationLag.WithLabelValues(node.Name).Set(now.Sub(status.Updated).Seconds())
+			case ComponentKeepalived:
+				for _, vip := range status.VIPs {
+					vipOwner.WithLabelValues(vip, node.Name).Set(1)
+				}
+			}
+		}
+	}
+}
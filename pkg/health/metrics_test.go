@@ -0,0 +1,54 @@
+package health
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+)
+
+func gaugeValue(m prometheus.Gauge) float64 {
+	out := &dto.Metric{}
+	Expect(m.Write(out)).ShouldNot(HaveOccurred())
+	return out.Gauge.GetValue()
+}
+
+var _ = Describe("recordNodeMetrics", func() {
+	It("rolls_up_the_node_statuses", func() {
+		now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+		nodes := []v1.Node{
+			nodeWith("master-0", map[string]Status{
+				ComponentKeepalived: {Healthy: true, Updated: now.Add(-time.Minute), VIPs: []string{"192.168.111.5"}},
+				ComponentBGP:        {Healthy: true, Updated: now.Add(-30 * time.Second)},
+			}),
+			nodeWith("master-1", map[string]Status{
+				ComponentKeepalived: {Healthy: false, Updated: now.Add(-time.Minute)},
+				ComponentBGP:        {Healthy: true, Updated: now.Add(-time.Hour)},
+			}),
+		}
+		recordNodeMetrics(nodes, now, 5*time.Minute)
+
+		Expect(gaugeValue(componentNodes.WithLabelValues(ComponentKeepalived, "healthy"))).To(Equal(1.0))
+		Expect(gaugeValue(componentNodes.WithLabelValues(ComponentKeepalived, "unhealthy"))).To(Equal(1.0))
+		Expect(gaugeValue(componentNodes.WithLabelValues(ComponentBGP, "stale"))).To(Equal(1.0))
+		Expect(gaugeValue(componentNodes.WithLabelValues(ComponentCoredns, "healthy"))).To(BeZero())
+		Expect(gaugeValue(bgpGenerationLag.WithLabelValues("master-0"))).To(Equal(30.0))
+		Expect(gaugeValue(bgpGenerationLag.WithLabelValues("master-1"))).To(Equal(3600.0))
+		Expect(gaugeValue(vipOwner.WithLabelValues("192.168.111.5", "master-0"))).To(Equal(1.0))
+
+		By("forgetting_moved_vips", func() {
+			recordNodeMetrics(nodes[1:], now, 5*time.Minute)
+			Expect(collectedCount(vipOwner)).To(BeZero())
+		})
+	})
+})
+
+func collectedCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
@@ -0,0 +1,167 @@
+// Package healthz serves the liveness and readiness of a monitor process.
+//
+// Every long running loop of the process registers with a timeout and beats
+// once per iteration. The process is live while every loop beat within its
+// timeout, so a loop stuck on a hung call fails the liveness probe, and
+// ready once it is live and reported ready, e.g. after its first render.
+package healthz
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
+
+var log = logging.Logger("healthz")
+
+type loop struct {
+	last    time.Time
+	timeout time.Duration
+}
+
+// Checker tracks the loops and the readiness of a process
+type Checker struct {
+	lock  sync.Mutex
+	loops map[string]*loop
+	ready bool
+	// signals are the states the process publishes besides its liveness
+	signals map[string]interface{}
+
+	// swapped out by the tests
+	now func() time.Time
+}
+
+// LoopStatus is the liveness of a loop
+type LoopStatus struct {
+	LastBeat time.Time `json:"lastBeat"`
+	Timeout  string    `json:"timeout"`
+	Alive    bool      `json:"alive"`
+}
+
+// Report is the body of the endpoints
+type Report struct {
+	Alive bool                  `json:"alive"`
+	Ready bool                  `json:"ready"`
+	Loops map[string]LoopStatus `json:"loops"`
+	// Signals are the states set with SetSignal, e.g. since when the API is
+	// reachable. They do not change the liveness nor the readiness.
+	Signals map[string]interface{} `json:"signals,omitempty"`
+}
+
+// NewChecker returns a checker without loops, live but not ready
+func NewChecker() *Checker {
+	return &Checker{loops: map[string]*loop{}, signals: map[string]interface{}{}, now: time.Now}
+}
+
+// Register adds the loop called name, which must beat at least once per
+// timeout from now on
+func (c *Checker) Register(name string, timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.loops[name] = &loop{last: c.now(), timeout: timeout}
+}
+
+// Beat records an iteration of the loop called name
+func (c *Checker) Beat(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if l, ok := c.loops[name]; ok {
+		l.last = c.now()
+	}
+}
+
+// SetReady sets whether the process is ready
+func (c *Checker) SetReady(ready bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ready = ready
+}
+
+// SetSignal publishes value, which must marshal to JSON, as the signal called
+// name in the reports
+func (c *Checker) SetSignal(name string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.signals[name] = value
+}
+
+// Report returns the liveness of every loop and of the process
+func (c *Checker) Report() Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	r := Report{Alive: true, Loops: map[string]LoopStatus{}}
+	for name, l := range c.loops {
+		alive := now.Sub(l.last) <= l.timeout
+		r.Loops[name] = LoopStatus{LastBeat: l.last, Timeout: l.timeout.String(), Alive: alive}
+		r.Alive = r.Alive && alive
+	}
+	r.Ready = r.Alive && c.ready
+	if len(c.signals) > 0 {
+		r.Signals = map[string]interface{}{}
+		for name, value := range c.signals {
+			r.Signals[name] = value
+		}
+	}
+	return r
+}
+
+// stuckLoops returns the names of the loops that missed their timeout
+func (r Report) stuckLoops() []string {
+	stuck := []string{}
+	for name, l := range r.Loops {
+		if !l.Alive {
+			stuck = append(stuck, name)
+		}
+	}
+	sort.Strings(stuck)
+	return stuck
+}
+
+func (c *Checker) handler(ok func(Report) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r := c.Report()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(r) {
+			if stuck := r.stuckLoops(); len(stuck) > 0 {
+				log.WithFields(logrus.Fields{
+					"loops": stuck,
+				}).Warn("Loops missed their liveness timeout")
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(r)
+	}
+}
+
+// Handler returns the mux serving /healthz, the liveness, and /readyz, the
+// readiness
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", c.handler(func(r Report) bool { return r.Alive }))
+	mux.Handle("/readyz", c.handler(func(r Report) bool { return r.Ready }))
+	return mux
+}
+
+// Serve starts the endpoints of c on addr. An empty addr disables them.
+func Serve(addr string, c *Checker) {
+	if addr == "" {
+		return
+	}
+	go func() {
+		log.WithFields(logrus.Fields{
+			"address": addr,
+		}).Info("Serving probe endpoints")
+		if err := http.ListenAndServe(addr, c.Handler()); err != nil {
+			log.WithFields(logrus.Fields{
+				"address": addr,
+			}).WithError(err).Error("Probe endpoints stopped")
+		}
+	}()
+}
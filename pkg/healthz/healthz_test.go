@@ -0,0 +1,66 @@
+package healthz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checker", func() {
+	var c *Checker
+	var clock time.Time
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		clock = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		c = NewChecker()
+		c.now = func() time.Time { return clock }
+		c.Register("main", time.Minute)
+		c.Register("mode", 10*time.Minute)
+	})
+
+	It("is_ready_once_reported", func() {
+		Expect(get("/healthz")).To(Equal(http.StatusOK))
+		Expect(get("/readyz")).To(Equal(http.StatusServiceUnavailable))
+		c.SetReady(true)
+		Expect(get("/readyz")).To(Equal(http.StatusOK))
+	})
+
+	It("fails_when_a_loop_is_stuck", func() {
+		c.SetReady(true)
+		clock = clock.Add(50 * time.Second)
+		c.Beat("main")
+		clock = clock.Add(50 * time.Second)
+		Expect(get("/healthz")).To(Equal(http.StatusOK))
+
+		clock = clock.Add(20 * time.Second)
+		r := c.Report()
+		Expect(r.Alive).To(BeFalse())
+		Expect(r.Ready).To(BeFalse())
+		Expect(r.stuckLoops()).To(Equal([]string{"main"}))
+		Expect(get("/healthz")).To(Equal(http.StatusServiceUnavailable))
+		Expect(get("/readyz")).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("publishes_the_signals", func() {
+		Expect(c.Report().Signals).To(BeNil())
+		c.SetSignal("apiReachability", map[string]bool{"reachable": true})
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		Expect(rec.Body.String()).To(ContainSubstring(`"signals":{"apiReachability":{"reachable":true}}`))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Healthz tests")
+}
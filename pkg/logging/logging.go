@@ -0,0 +1,131 @@
+// Package logging hands out the named loggers of the packages, so that the
+// level of every one of them can be changed at runtime and hooks can be
+// registered in a single place.
+//
+// Names are hierarchical, with dots as separators: the level or hook set on
+// "monitor" also applies to "monitor.cmd", unless a level is set on
+// "monitor.cmd" itself. The empty name is the root of every logger.
+package logging
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLevel is the level of the loggers without a level set on them or
+// on one of their parents
+const DefaultLevel = logrus.InfoLevel
+
+type hookEntry struct {
+	name string
+	hook logrus.Hook
+}
+
+var (
+	lock    sync.Mutex
+	loggers = map[string]*logrus.Logger{}
+	levels  = map[string]logrus.Level{}
+	hooks   = []hookEntry{}
+)
+
+// isParent returns whether parent is name or one of its parents
+func isParent(parent, name string) bool {
+	return parent == "" || parent == name || strings.HasPrefix(name, parent+".")
+}
+
+// levelOf returns the level set on name or its closest parent, the caller
+// holds the lock
+func levelOf(name string) logrus.Level {
+	for {
+		if level, ok := levels[name]; ok {
+			return level
+		}
+		if name == "" {
+			return DefaultLevel
+		}
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[:i]
+		} else {
+			name = ""
+		}
+	}
+}
+
+// Logger returns the logger called name, creating it on the first call
+func Logger(name string) *logrus.Logger {
+	lock.Lock()
+	defer lock.Unlock()
+	if logger, ok := loggers[name]; ok {
+		return logger
+	}
+	logger := logrus.New()
+	logger.SetLevel(levelOf(name))
+	for _, h := range hooks {
+		if isParent(h.name, name) {
+			logger.AddHook(h.hook)
+		}
+	}
+	loggers[name] = logger
+	return logger
+}
+
+// Names returns the names of the loggers created so far, sorted
+func Names() []string {
+	lock.Lock()
+	defer lock.Unlock()
+	names := []string{}
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Level returns the level set on name or on its closest parent, which is
+// the level of the logger unless it was changed on the logger directly
+func Level(name string) logrus.Level {
+	lock.Lock()
+	defer lock.Unlock()
+	return levelOf(name)
+}
+
+// SetLevel sets the level of name and of its children without a level of
+// their own
+func SetLevel(name string, level logrus.Level) {
+	lock.Lock()
+	defer lock.Unlock()
+	levels[name] = level
+	for n, logger := range loggers {
+		if isParent(name, n) {
+			logger.SetLevel(levelOf(n))
+		}
+	}
+}
+
+// ResetLevel removes the level set on name, which then follows its parent
+func ResetLevel(name string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(levels, name)
+	for n, logger := range loggers {
+		if isParent(name, n) {
+			logger.SetLevel(levelOf(n))
+		}
+	}
+}
+
+// AddHook adds hook to the loggers called name and to its children,
+// including the ones created later
+func AddHook(name string, hook logrus.Hook) {
+	lock.Lock()
+	defer lock.Unlock()
+	hooks = append(hooks, hookEntry{name: name, hook: hook})
+	for n, logger := range loggers {
+		if isParent(name, n) {
+			logger.AddHook(hook)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("Logging", func() {
+	BeforeEach(func() {
+		loggers = map[string]*logrus.Logger{}
+		levels = map[string]logrus.Level{}
+		hooks = []hookEntry{}
+	})
+
+	It("shares_the_named_loggers", func() {
+		Expect(Logger("monitor")).To(BeIdenticalTo(Logger("monitor")))
+		Expect(Logger("monitor")).NotTo(BeIdenticalTo(Logger("render")))
+		Expect(Names()).To(Equal([]string{"monitor", "render"}))
+	})
+
+	It("inherits_the_levels_of_the_parents", func() {
+		monitor, cmd, monitoring := Logger("monitor"), Logger("monitor.cmd"), Logger("monitoring")
+		SetLevel("monitor", logrus.DebugLevel)
+		Expect(cmd.GetLevel()).To(Equal(logrus.DebugLevel))
+		Expect(monitoring.GetLevel()).To(Equal(DefaultLevel))
+
+		SetLevel("monitor.cmd", logrus.WarnLevel)
+		SetLevel("", logrus.TraceLevel)
+		Expect(monitor.GetLevel()).To(Equal(logrus.DebugLevel))
+		Expect(cmd.GetLevel()).To(Equal(logrus.WarnLevel))
+		Expect(monitoring.GetLevel()).To(Equal(logrus.TraceLevel))
+		Expect(Logger("render").GetLevel()).To(Equal(logrus.TraceLevel))
+
+		ResetLevel("monitor.cmd")
+		Expect(cmd.GetLevel()).To(Equal(logrus.DebugLevel))
+	})
+
+	It("adds_the_hooks_to_the_children", func() {
+		hook := new(test.Hook)
+		AddHook("monitor", hook)
+		Logger("monitor.cmd").Info("child")
+		Logger("render").Info("other")
+		Logger("monitoring").Info("sibling")
+		Expect(hook.AllEntries()).To(HaveLen(1))
+		Expect(hook.LastEntry().Message).To(Equal("child"))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logging tests")
+}
@@ -0,0 +1,190 @@
+// Package loggingconfig applies the log levels set in the logging ConfigMap
+// of the monitors namespace to the package loggers of a running process, so
+// that debugging a component does not require restarting it.
+package loggingconfig
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// ConfigMapName is the ConfigMap holding the log levels
+	ConfigMapName = "logging"
+	// LevelKeyPrefix is followed by the component name in the keys holding
+	// a level, e.g. level-keepalived-monitor
+	LevelKeyPrefix = "level-"
+	// NodeIPDebugKey is the deprecated key that enables the debug level of
+	// the node IP detection, i.e. of the utils and config components
+	NodeIPDebugKey = "enable-nodeip-debug"
+
+	ComponentUtils  = "utils"
+	ComponentConfig = "config"
+)
+
+var log = logging.Logger("loggingconfig")
+
+// nodeIPComponents are the components set to debug by NodeIPDebugKey
+var nodeIPComponents = []string{ComponentUtils, ComponentConfig}
+
+// ParseLevel parses the value of a level key. Only the levels that make
+// sense for a monitor are accepted.
+func ParseLevel(value string) (logrus.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "trace":
+		return logrus.TraceLevel, nil
+	case "debug":
+		return logrus.DebugLevel, nil
+	case "info":
+		return logrus.InfoLevel, nil
+	case "warn", "warning":
+		return logrus.WarnLevel, nil
+	}
+	return logging.DefaultLevel, fmt.Errorf("Unknown log level %q, expected trace, debug, info or warn", value)
+}
+
+// Levels returns the level of the components that have a valid level key
+// in the data of the logging ConfigMap. Invalid values are reported in the
+// returned errors.
+func Levels(data map[string]string, components []string) (map[string]logrus.Level, []error) {
+	levels := map[string]logrus.Level{}
+	errs := []error{}
+	for _, component := range components {
+		if value, ok := data[LevelKeyPrefix+component]; ok {
+			level, err := ParseLevel(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", LevelKeyPrefix, component, err))
+				continue
+			}
+			levels[component] = level
+		}
+	}
+	if data[NodeIPDebugKey] == "true" {
+		for _, component := range nodeIPComponents {
+			if _, ok := data[LevelKeyPrefix+component]; !ok {
+				levels[component] = logrus.DebugLevel
+			}
+		}
+	}
+	return levels, errs
+}
+
+// Watcher polls the logging ConfigMap and applies the levels to the loggers
+// of the components
+type Watcher struct {
+	// Components maps the component of each level key to the name of the
+	// logger it sets
+	Components map[string]string
+	Interval   time.Duration
+
+	applied map[string]logrus.Level
+	warned  bool
+
+	// swapped out by the tests
+	get      func() (map[string]string, error)
+	setLevel func(name string, level logrus.Level)
+	reset    func(name string)
+}
+
+// NewWatcher returns a watcher of the logging ConfigMap in the pod
+// namespace
+func NewWatcher(kubeconfigPath string, interval time.Duration, components map[string]string) *Watcher {
+	return &Watcher{
+		Components: components,
+		Interval:   interval,
+		applied:    map[string]logrus.Level{},
+		get: func() (map[string]string, error) {
+			return getLoggingConfigMap(kubeconfigPath, os.Getenv("POD_NAMESPACE"))
+		},
+		setLevel: logging.SetLevel,
+		reset:    logging.ResetLevel,
+	}
+}
+
+func getLoggingConfigMap(kubeconfigPath, namespace string) (map[string]string, error) {
+	config, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// Sync reads the ConfigMap once and applies the levels that changed. The
+// levels are left alone when the ConfigMap cannot be read.
+func (w *Watcher) Sync() {
+	data, err := w.get()
+	if err != nil {
+		log.WithError(err).Warn("Failed to get the logging configuration")
+		return
+	}
+	if _, ok := data[NodeIPDebugKey]; ok && !w.warned {
+		log.Warnf("%s is deprecated, use %s%s and %s%s instead", NodeIPDebugKey, LevelKeyPrefix, ComponentUtils, LevelKeyPrefix, ComponentConfig)
+		w.warned = true
+	}
+	components := []string{}
+	for component := range w.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	levels, errs := Levels(data, components)
+	for _, err := range errs {
+		log.WithError(err).Warn("Ignoring invalid log level")
+	}
+	for _, component := range components {
+		name := w.Components[component]
+		level, set := levels[component]
+		applied, wasSet := w.applied[component]
+		switch {
+		case set && (!wasSet || applied != level):
+			w.setLevel(name, level)
+			w.applied[component] = level
+		case !set && wasSet:
+			// The logger follows its parent again
+			w.reset(name)
+			delete(w.applied, component)
+			level = logging.Level(name)
+		default:
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"component": component,
+			"level":     level,
+		}).Info("Applied log level")
+	}
+}
+
+// Run syncs the levels once per interval until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		w.Sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
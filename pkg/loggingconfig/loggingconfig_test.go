@@ -0,0 +1,59 @@
+package loggingconfig
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Levels", func() {
+	components := []string{"keepalived-monitor", ComponentUtils, ComponentConfig}
+
+	It("parses_the_component_keys", func() {
+		levels, errs := Levels(map[string]string{
+			"level-keepalived-monitor": "trace",
+			"level-utils":              "Warn",
+			"level-config":             "verbose",
+		}, components)
+		Expect(levels).To(Equal(map[string]logrus.Level{
+			"keepalived-monitor": logrus.TraceLevel,
+			ComponentUtils:       logrus.WarnLevel,
+		}))
+		Expect(errs).To(HaveLen(1))
+	})
+
+	It("keeps_enable_nodeip_debug_as_an_alias", func() {
+		levels, errs := Levels(map[string]string{NodeIPDebugKey: "true", "level-config": "trace"}, components)
+		Expect(errs).To(BeEmpty())
+		Expect(levels[ComponentUtils]).To(Equal(logrus.DebugLevel))
+		Expect(levels[ComponentConfig]).To(Equal(logrus.TraceLevel))
+		Expect(levels).NotTo(HaveKey("keepalived-monitor"))
+	})
+})
+
+var _ = Describe("Watcher", func() {
+	It("applies_the_changed_levels", func() {
+		applied := []string{}
+		data := map[string]string{"level-coredns-monitor": "debug"}
+		w := NewWatcher("kubeconfig", 0, map[string]string{"coredns-monitor": "monitor"})
+		w.get = func() (map[string]string, error) { return data, nil }
+		w.setLevel = func(name string, level logrus.Level) { applied = append(applied, name+"="+level.String()) }
+		w.reset = func(name string) { applied = append(applied, name+" reset") }
+
+		w.Sync()
+		w.Sync()
+		Expect(applied).To(Equal([]string{"monitor=debug"}))
+
+		delete(data, "level-coredns-monitor")
+		w.Sync()
+		w.Sync()
+		Expect(applied).To(Equal([]string{"monitor=debug", "monitor reset"}))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logging config tests")
+}
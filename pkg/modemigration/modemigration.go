@@ -0,0 +1,225 @@
+// Package modemigration coordinates the switch of keepalived between the
+// unicast and multicast modes. The coordinator publishes the desired mode
+// with a new epoch in a ConfigMap, waits until the keepalived monitor of
+// every node acknowledges it, and only then tells them to switch, instead of
+// having each node guess a common switch time.
+package modemigration
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// ConfigMapName is the ConfigMap in the namespace of the monitors that
+	// holds the State of the migration
+	ConfigMapName = "keepalived-mode"
+
+	// PhasePrepare asks the monitors to acknowledge the epoch
+	PhasePrepare = "Prepare"
+	// PhaseSwitch asks the monitors that acknowledged the epoch to switch
+	PhaseSwitch = "Switch"
+	// PhaseDone is set once every node switched
+	PhaseDone = "Done"
+	// PhaseAborted is set when a node did not acknowledge the epoch in time,
+	// no node switches
+	PhaseAborted = "Aborted"
+
+	// AckAnnotation holds the last epoch the monitor of the node
+	// acknowledged, AppliedAnnotation the last one it switched to
+	AckAnnotation     = "keepalived.onprem.openshift.io/mode-epoch-acked"
+	AppliedAnnotation = "keepalived.onprem.openshift.io/mode-epoch-applied"
+
+	ModeUnicast   = "unicast"
+	ModeMulticast = "multicast"
+)
+
+var log = logging.Logger("modemigration")
+
+// State is the migration published by the coordinator
+type State struct {
+	Mode  string
+	Epoch int64
+	Phase string
+}
+
+func (s State) data() map[string]string {
+	return map[string]string{
+		"mode":  s.Mode,
+		"epoch": strconv.FormatInt(s.Epoch, 10),
+		"phase": s.Phase,
+	}
+}
+
+func parseState(data map[string]string) (State, error) {
+	s := State{Mode: data["mode"], Phase: data["phase"]}
+	if s.Mode != ModeUnicast && s.Mode != ModeMulticast {
+		return s, fmt.Errorf("Unknown keepalived mode %q", s.Mode)
+	}
+	epoch, err := strconv.ParseInt(data["epoch"], 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("Invalid epoch %q: %w", data["epoch"], err)
+	}
+	s.Epoch = epoch
+	switch s.Phase {
+	case PhasePrepare, PhaseSwitch, PhaseDone, PhaseAborted:
+		return s, nil
+	}
+	return s, fmt.Errorf("Unknown migration phase %q", s.Phase)
+}
+
+func newClientset(kubeconfigPath string) (*kubernetes.Clientset, error) {
+	clientConfig, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(clientConfig)
+}
+
+// GetState returns the migration published in namespace, nil when there is
+// none and the monitors fall back to the mode files.
+func GetState(kubeconfigPath, namespace string) (*State, error) {
+	clientset, err := newClientset(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s, err := parseState(cm.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func setState(clientset *kubernetes.Clientset, namespace string, s State) error {
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: namespace}, Data: s.data()}
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	cm.Data = s.data()
+	_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	return err
+}
+
+// Acknowledge records on the node that its monitor saw epoch
+func Acknowledge(kubeconfigPath, nodeName string, epoch int64) error {
+	return utils.PatchNodeAnnotations(kubeconfigPath, nodeName, map[string]string{AckAnnotation: strconv.FormatInt(epoch, 10)})
+}
+
+// Applied records on the node that its monitor switched to the mode of
+// epoch
+func Applied(kubeconfigPath, nodeName string, epoch int64) error {
+	return utils.PatchNodeAnnotations(kubeconfigPath, nodeName, map[string]string{AppliedAnnotation: strconv.FormatInt(epoch, 10)})
+}
+
+// pendingNodes returns the names of the nodes whose annotation is not epoch
+func pendingNodes(nodes []v1.Node, annotation string, epoch int64) []string {
+	pending := []string{}
+	want := strconv.FormatInt(epoch, 10)
+	for _, node := range nodes {
+		if node.Annotations[annotation] != want {
+			pending = append(pending, node.Name)
+		}
+	}
+	return pending
+}
+
+// waitFor polls the nodes matching selector until all of them have epoch in
+// annotation or timeout expires
+func waitFor(clientset *kubernetes.Clientset, selector labels.Selector, annotation string, epoch int64, timeout, poll time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			log.WithError(err).Warn("Failed to list nodes")
+		} else {
+			pending := pendingNodes(nodes.Items, annotation, epoch)
+			if len(pending) == 0 {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("nodes %v did not report epoch %d in %s", pending, epoch, annotation)
+			}
+			log.WithFields(logrus.Fields{
+				"pending":    pending,
+				"annotation": annotation,
+			}).Debug("Waiting for nodes")
+		}
+		time.Sleep(poll)
+	}
+}
+
+// Migrate switches the keepalived monitors of the nodes matching selector to
+// mode. No node switches unless every one of them acknowledges the new epoch
+// within timeout.
+func Migrate(kubeconfigPath, namespace, mode string, selector labels.Selector, timeout, poll time.Duration) error {
+	if mode != ModeUnicast && mode != ModeMulticast {
+		return fmt.Errorf("Unknown keepalived mode %q", mode)
+	}
+	upgradeRunning, err := config.IsUpgradeStillRunning(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	if upgradeRunning {
+		return fmt.Errorf("A cluster upgrade is running, retry once it completes")
+	}
+	clientset, err := newClientset(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	s := State{Mode: mode, Epoch: time.Now().Unix(), Phase: PhasePrepare}
+	fields := logrus.Fields{"mode": s.Mode, "epoch": s.Epoch}
+	if err := setState(clientset, namespace, s); err != nil {
+		return err
+	}
+	log.WithFields(fields).Info("Waiting for the nodes to acknowledge the keepalived mode")
+	if err := waitFor(clientset, selector, AckAnnotation, s.Epoch, timeout, poll); err != nil {
+		s.Phase = PhaseAborted
+		if setErr := setState(clientset, namespace, s); setErr != nil {
+			log.WithFields(fields).WithError(setErr).Error("Failed to abort the keepalived mode migration")
+		}
+		return err
+	}
+
+	s.Phase = PhaseSwitch
+	if err := setState(clientset, namespace, s); err != nil {
+		return err
+	}
+	log.WithFields(fields).Info("Switching the keepalived mode")
+	// The nodes that acknowledged the epoch switch even if the wait times
+	// out, so the phase is left at Switch for the late ones
+	if err := waitFor(clientset, selector, AppliedAnnotation, s.Epoch, timeout, poll); err != nil {
+		return err
+	}
+	s.Phase = PhaseDone
+	if err := setState(clientset, namespace, s); err != nil {
+		return err
+	}
+	log.WithFields(fields).Info("Keepalived mode migration done")
+	return nil
+}
@@ -0,0 +1,45 @@
+package modemigration
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("State", func() {
+	It("round_trips_through_the_configmap", func() {
+		s := State{Mode: ModeUnicast, Epoch: 1700000000, Phase: PhaseSwitch}
+		parsed, err := parseState(s.data())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(s))
+	})
+
+	It("rejects_invalid_states", func() {
+		_, err := parseState(map[string]string{"mode": "broadcast", "epoch": "1", "phase": PhasePrepare})
+		Expect(err).To(HaveOccurred())
+		_, err = parseState(map[string]string{"mode": ModeUnicast, "epoch": "soon", "phase": PhasePrepare})
+		Expect(err).To(HaveOccurred())
+		_, err = parseState(map[string]string{"mode": ModeUnicast, "epoch": "1", "phase": "Later"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("pendingNodes", func() {
+	It("lists_the_nodes_without_the_epoch", func() {
+		nodes := []v1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "master-0", Annotations: map[string]string{AckAnnotation: "42"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "master-1", Annotations: map[string]string{AckAnnotation: "41"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "master-2"}},
+		}
+		Expect(pendingNodes(nodes, AckAnnotation, 42)).To(Equal([]string{"master-1", "master-2"}))
+		Expect(pendingNodes(nodes[:1], AckAnnotation, 42)).To(BeEmpty())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mode migration tests")
+}
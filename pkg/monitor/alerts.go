@@ -0,0 +1,15 @@
+package monitor
+
+import (
+	"github.com/openshift/baremetal-runtimecfg/pkg/alerts"
+)
+
+func newAlertNotifier(opts Options, component string) *alerts.Notifier {
+	notifier, err := alerts.NewNotifier(opts.Alerts, opts.NodeName, component)
+	if err != nil {
+		// The commands validate the options
+		log.WithError(err).Error("Failed to configure the alerts, they are disabled")
+		return nil
+	}
+	return notifier
+}
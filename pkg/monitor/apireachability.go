@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	apiReachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_api_reachable",
+		Help: "1 while the API is reachable through the local HAProxy",
+	})
+	apiReachabilityChanged = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_api_reachability_changed_timestamp_seconds",
+		Help: "Time the API became reachable or unreachable through the local HAProxy",
+	})
+	apiLastReachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_api_last_reachable_timestamp_seconds",
+		Help: "Last time the API was reachable through the local HAProxy, 0 if never",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(apiReachable, apiReachabilityChanged, apiLastReachable)
+}
+
+// apiReachabilityStatus is the reachability of the API through the local
+// HAProxy, published in the probe reports
+type apiReachabilityStatus struct {
+	Reachable bool `json:"reachable"`
+	// Since is when the API became reachable or unreachable, the start of
+	// the monitor before the first transition
+	Since time.Time `json:"since"`
+	// LastReachable is zero until the API was first reachable
+	LastReachable time.Time `json:"lastReachable"`
+	Transitions   int       `json:"transitions"`
+}
+
+// apiReachability tracks since when the API is reachable or not through the
+// local HAProxy
+type apiReachability struct {
+	status apiReachabilityStatus
+	// tracked is the value of the track file, so it is only written on
+	// changes
+	tracked string
+	// trackFile holds 1 while the API has been unreachable for threshold,
+	// disabled when empty
+	trackFile string
+	threshold time.Duration
+
+	// swapped out by the tests
+	now func() time.Time
+}
+
+func newAPIReachability(trackFile string, threshold time.Duration) *apiReachability {
+	a := &apiReachability{trackFile: trackFile, threshold: threshold, now: time.Now}
+	a.status.Since = a.now()
+	apiReachabilityChanged.Set(float64(a.status.Since.Unix()))
+	return a
+}
+
+// lasted returns how long the API has been reachable or unreachable
+func (a *apiReachability) lasted() time.Duration {
+	return a.now().Sub(a.status.Since)
+}
+
+// unreachableFor returns how long the API has been unreachable, zero while it
+// is reachable
+func (a *apiReachability) unreachableFor() time.Duration {
+	if a.status.Reachable {
+		return 0
+	}
+	return a.lasted()
+}
+
+// update records the reachability of an iteration of the monitor and
+// publishes it
+func (a *apiReachability) update(reachable bool) {
+	now := a.now()
+	if reachable != a.status.Reachable {
+		a.status.Reachable = reachable
+		a.status.Since = now
+		a.status.Transitions++
+		apiReachabilityChanged.Set(float64(now.Unix()))
+	}
+	if reachable {
+		a.status.LastReachable = now
+		apiLastReachable.Set(float64(now.Unix()))
+		apiReachable.Set(1)
+	} else {
+		apiReachable.Set(0)
+	}
+	probes.SetSignal("apiReachability", a.status)
+	a.writeTrackFile()
+}
+
+// writeTrackFile writes 1 in the track file while the API has been
+// unreachable for the threshold, 0 otherwise
+func (a *apiReachability) writeTrackFile() {
+	if a.trackFile == "" {
+		return
+	}
+	value := "0\n"
+	if !a.status.Reachable && a.unreachableFor() >= a.threshold {
+		value = "1\n"
+	}
+	if value == a.tracked {
+		return
+	}
+	if err := ioutil.WriteFile(a.trackFile, []byte(value), 0644); err != nil {
+		log.WithFields(logrus.Fields{"path": a.trackFile}).WithError(err).Warn("Failed to write API reachability track file")
+		return
+	}
+	a.tracked = value
+}
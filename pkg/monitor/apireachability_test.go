@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("api_reachability", func() {
+	var dir string
+	var clock time.Time
+	var a *apiReachability
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "reachability")
+		Expect(err).ShouldNot(HaveOccurred())
+		clock = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+		a = newAPIReachability(filepath.Join(dir, "api-unreachable"), time.Minute)
+		a.now = func() time.Time { return clock }
+		a.status.Since = clock
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	tracked := func() string {
+		data, err := ioutil.ReadFile(a.trackFile)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(data)
+	}
+
+	It("records_the_transitions", func() {
+		a.update(false)
+		Expect(a.status.Transitions).Should(Equal(0))
+		Expect(a.status.LastReachable.IsZero()).Should(BeTrue())
+
+		clock = clock.Add(30 * time.Second)
+		a.update(true)
+		Expect(a.status).Should(Equal(apiReachabilityStatus{Reachable: true, Since: clock, LastReachable: clock, Transitions: 1}))
+		Expect(metricValue(apiReachable)).Should(Equal(1.0))
+		Expect(metricValue(apiReachabilityChanged)).Should(Equal(float64(clock.Unix())))
+
+		lastReachable := clock
+		clock = clock.Add(10 * time.Second)
+		a.update(false)
+		clock = clock.Add(5 * time.Second)
+		a.update(false)
+		Expect(a.unreachableFor()).Should(Equal(5 * time.Second))
+		Expect(a.status.LastReachable).Should(Equal(lastReachable))
+		Expect(a.status.Transitions).Should(Equal(2))
+		Expect(metricValue(apiReachable)).Should(Equal(0.0))
+		Expect(probes.Report().Signals).Should(HaveKeyWithValue("apiReachability", a.status))
+	})
+
+	It("tracks_a_chronically_unreachable_api", func() {
+		a.update(false)
+		Expect(tracked()).Should(Equal("0\n"))
+
+		clock = clock.Add(time.Minute)
+		a.update(false)
+		Expect(tracked()).Should(Equal("1\n"))
+
+		clock = clock.Add(time.Second)
+		a.update(true)
+		Expect(tracked()).Should(Equal("0\n"))
+		Expect(a.unreachableFor()).Should(BeZero())
+	})
+})
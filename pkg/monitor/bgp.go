@@ -0,0 +1,303 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	VIPAdvertisementVRRP = "vrrp"
+	VIPAdvertisementBGP  = "bgp"
+	VIPAdvertisementBoth = "vrrp+bgp"
+	// VIPAdvertisementLease is experimental, for networks where VRRP is
+	// blocked: the node holding the coordination Lease of a VIP adds it
+	VIPAdvertisementLease = "lease"
+
+	// frrRouteMap sets the BGP communities of the advertised VIPs
+	frrRouteMap = "RUNTIMECFG-VIPS"
+	// ingressHealthURL is the readiness endpoint of the local router, the
+	// one the keepalived ingress check script uses
+	ingressHealthURL = "http://localhost:1936/healthz/ready"
+)
+
+// ValidateVIPAdvertisement returns an error for an unknown advertisement mode
+func ValidateVIPAdvertisement(mode string) error {
+	switch mode {
+	case VIPAdvertisementVRRP, VIPAdvertisementBGP, VIPAdvertisementBoth, VIPAdvertisementLease:
+		return nil
+	}
+	return fmt.Errorf("Unknown VIP advertisement mode %q, must be one of %s, %s, %s or %s", mode, VIPAdvertisementVRRP, VIPAdvertisementBGP, VIPAdvertisementBoth, VIPAdvertisementLease)
+}
+
+// BGPConfig is the BGP session the VIPs are advertised through
+type BGPConfig struct {
+	ASN     uint32
+	PeerASN uint32
+	Peers   []string
+	// Communities are set on the advertised VIP routes
+	Communities []string
+	// TemplatePath is rendered into ConfigPath, the configuration FRR loads
+	TemplatePath string
+	ConfigPath   string
+	// BFD sessions withdraw the routes of a failed node in under a second
+	BFD config.BFDConfig
+}
+
+// FRRConfig is the data of the FRR template, rendered by the keepalived
+// monitor and by the BGP node config reconciler
+type FRRConfig struct {
+	BGP          BGPConfig
+	RouteMap     string
+	IPv4Prefixes []string
+	IPv6Prefixes []string
+	// BFDPeers are the BGP peers with a BFD session
+	BFDPeers []string
+	// RouterID is the BGP router ID, the one FRR picks when empty
+	RouterID string
+}
+
+// NewFRRConfig returns the FRR template data advertising the given host
+// route prefixes through cfg
+func NewFRRConfig(cfg BGPConfig, prefixes []string) FRRConfig {
+	frr := FRRConfig{
+		BGP:          cfg,
+		RouteMap:     frrRouteMap,
+		IPv4Prefixes: []string{},
+		IPv6Prefixes: []string{},
+		BFDPeers:     cfg.BFD.PeersOf(cfg.Peers),
+	}
+	for _, prefix := range prefixes {
+		if strings.HasSuffix(prefix, "/32") {
+			frr.IPv4Prefixes = append(frr.IPv4Prefixes, prefix)
+		} else {
+			frr.IPv6Prefixes = append(frr.IPv6Prefixes, prefix)
+		}
+	}
+	sort.Strings(frr.IPv4Prefixes)
+	sort.Strings(frr.IPv6Prefixes)
+	return frr
+}
+
+// bgpAdvertiser advertises the host routes of the VIPs whose local service
+// is healthy, so that the upstream routers spread the traffic of every VIP
+// over the nodes that can serve it
+type bgpAdvertiser struct {
+	cfg         BGPConfig
+	apiVips     []net.IP
+	ingressVips []net.IP
+	// advertised holds the prefixes FRR currently advertises, nil until
+	// the configuration is first loaded
+	advertised map[string]bool
+	reporter   *health.Publisher
+
+	// swapped out by the tests
+	apiHealthy     func() bool
+	ingressHealthy func() bool
+	renderConfig   func(cfg FRRConfig) error
+	vtysh          func(args ...string) error
+}
+
+func newBGPAdvertiser(cfg BGPConfig, files render.FileOptions, apiVips, ingressVips []net.IP, apiPort uint16) *bgpAdvertiser {
+	return &bgpAdvertiser{
+		cfg:         cfg,
+		apiVips:     apiVips,
+		ingressVips: ingressVips,
+		apiHealthy: func() bool {
+			healthy, _ := utils.IsKubernetesHealthy(apiPort)
+			return healthy
+		},
+		ingressHealthy: isIngressHealthy,
+		renderConfig: func(cfg FRRConfig) error {
+			return render.RenderFile(files.Apply(render.FileSpec{RenderPath: cfg.BGP.ConfigPath, TemplatePath: cfg.BGP.TemplatePath}), cfg)
+		},
+		vtysh: runVtysh,
+	}
+}
+
+func isIngressHealthy() bool {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(ingressHealthURL)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func runVtysh(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("vtysh", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("vtysh: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// VIPPrefix returns the host route of vip
+func VIPPrefix(vip net.IP) string {
+	if vip.To4() != nil {
+		return vip.String() + "/32"
+	}
+	return vip.String() + "/128"
+}
+
+// desired returns the prefixes of the VIPs whose service is healthy
+func (a *bgpAdvertiser) desired() map[string]bool {
+	prefixes := map[string]bool{}
+	for _, vips := range []struct {
+		ips     []net.IP
+		healthy func() bool
+	}{{a.apiVips, a.apiHealthy}, {a.ingressVips, a.ingressHealthy}} {
+		if len(vips.ips) == 0 || !vips.healthy() {
+			continue
+		}
+		for _, vip := range vips.ips {
+			prefixes[VIPPrefix(vip)] = true
+		}
+	}
+	return prefixes
+}
+
+func (a *bgpAdvertiser) frrConfig() FRRConfig {
+	prefixes := []string{}
+	for prefix := range a.advertised {
+		prefixes = append(prefixes, prefix)
+	}
+	return NewFRRConfig(a.cfg, prefixes)
+}
+
+// setBFD exposes the BFD sessions of the node to the keepalived template,
+// for configurations that protect static routes to the VIPs with them
+func setBFD(node *config.Node, bfd config.BFDConfig) {
+	if !bfd.Enabled {
+		return
+	}
+	node.BFD = &bfd
+	if node.Configs == nil {
+		return
+	}
+	for i := range *node.Configs {
+		(*node.Configs)[i].BFD = &bfd
+	}
+}
+
+// networkCommand returns the vtysh arguments that advertise prefix, or
+// withdraw it with withdraw
+func (c BGPConfig) networkCommand(prefix string, withdraw bool) []string {
+	family := "ipv6"
+	if strings.HasSuffix(prefix, "/32") {
+		family = "ipv4"
+	}
+	network := "network " + prefix
+	if withdraw {
+		network = "no " + network
+	} else if len(c.Communities) > 0 {
+		network += " route-map " + frrRouteMap
+	}
+	return []string{
+		"-c", "configure terminal",
+		"-c", fmt.Sprintf("router bgp %d", c.ASN),
+		"-c", fmt.Sprintf("address-family %s unicast", family),
+		"-c", network,
+	}
+}
+
+// reconcile advertises the VIPs of the healthy services and withdraws the
+// others. The first call renders and loads the whole FRR configuration,
+// later ones only change the advertised networks and render the
+// configuration again for FRR restarts.
+func (a *bgpAdvertiser) reconcile() error {
+	desired := a.desired()
+	if a.advertised == nil {
+		a.advertised = desired
+		if err := a.renderConfig(a.frrConfig()); err != nil {
+			a.advertised = nil
+			return err
+		}
+		if err := a.vtysh("-f", a.cfg.ConfigPath); err != nil {
+			a.advertised = nil
+			return err
+		}
+		cfg := a.frrConfig()
+		log.WithFields(logrus.Fields{
+			"ipv4": cfg.IPv4Prefixes,
+			"ipv6": cfg.IPv6Prefixes,
+		}).Info("Loaded FRR configuration")
+		return nil
+	}
+
+	changed := false
+	for prefix := range desired {
+		if a.advertised[prefix] {
+			continue
+		}
+		if err := a.vtysh(a.cfg.networkCommand(prefix, false)...); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{"prefix": prefix}).Info("Advertising VIP")
+		a.advertised[prefix] = true
+		changed = true
+	}
+	for prefix := range a.advertised {
+		if desired[prefix] {
+			continue
+		}
+		if err := a.withdraw(prefix); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return a.renderConfig(a.frrConfig())
+}
+
+// update reconciles the advertisement and publishes whether FRR is up to
+// date
+func (a *bgpAdvertiser) update() {
+	if err := a.reconcile(); err != nil {
+		log.WithError(err).Error("Failed to update the BGP advertisement of the VIPs")
+		a.reporter.Report(false, err.Error())
+		return
+	}
+	a.reporter.Report(true, "")
+}
+
+func (a *bgpAdvertiser) withdraw(prefix string) error {
+	if err := a.vtysh(a.cfg.networkCommand(prefix, true)...); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{"prefix": prefix}).Info("Withdrawing VIP")
+	delete(a.advertised, prefix)
+	return nil
+}
+
+// withdrawAll stops advertising every VIP, so that the routers stop sending
+// their traffic to the node as soon as it stops monitoring them
+func (a *bgpAdvertiser) withdrawAll() {
+	for prefix := range a.advertised {
+		if err := a.withdraw(prefix); err != nil {
+			log.WithFields(logrus.Fields{"prefix": prefix}).WithError(err).Error("Failed to withdraw VIP")
+		}
+	}
+	if a.advertised != nil {
+		if err := a.renderConfig(a.frrConfig()); err != nil {
+			log.WithError(err).Error("Failed to render FRR configuration")
+		}
+	}
+}
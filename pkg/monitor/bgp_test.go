@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+var _ = Describe("bgp_advertiser", func() {
+	var a *bgpAdvertiser
+	var apiUp, ingressUp bool
+	var commands [][]string
+	var rendered []FRRConfig
+
+	BeforeEach(func() {
+		apiUp, ingressUp = true, false
+		commands, rendered = nil, nil
+		a = newBGPAdvertiser(BGPConfig{ASN: 64512, PeerASN: 64512, Peers: []string{"192.168.111.1"}, ConfigPath: "/etc/frr/frr.conf"}, render.FileOptions{}, []net.IP{net.ParseIP("192.168.111.5"), net.ParseIP("fd00::5")}, []net.IP{net.ParseIP("192.168.111.4")}, 6443)
+		a.apiHealthy = func() bool { return apiUp }
+		a.ingressHealthy = func() bool { return ingressUp }
+		a.renderConfig = func(cfg FRRConfig) error {
+			rendered = append(rendered, cfg)
+			return nil
+		}
+		a.vtysh = func(args ...string) error {
+			commands = append(commands, args)
+			return nil
+		}
+	})
+
+	It("loads_the_whole_configuration_first", func() {
+		Expect(a.reconcile()).To(Succeed())
+		Expect(commands).To(Equal([][]string{{"-f", "/etc/frr/frr.conf"}}))
+		Expect(rendered).To(HaveLen(1))
+		Expect(rendered[0].IPv4Prefixes).To(Equal([]string{"192.168.111.5/32"}))
+		Expect(rendered[0].IPv6Prefixes).To(Equal([]string{"fd00::5/128"}))
+	})
+
+	It("follows_the_service_health", func() {
+		Expect(a.reconcile()).To(Succeed())
+		commands = nil
+
+		apiUp, ingressUp = false, true
+		a.cfg.Communities = []string{"65000:100"}
+		Expect(a.reconcile()).To(Succeed())
+		Expect(commands).To(ConsistOf(
+			a.cfg.networkCommand("192.168.111.4/32", false),
+			a.cfg.networkCommand("192.168.111.5/32", true),
+			a.cfg.networkCommand("fd00::5/128", true),
+		))
+		Expect(a.cfg.networkCommand("192.168.111.4/32", false)).To(ContainElement("network 192.168.111.4/32 route-map " + frrRouteMap))
+		Expect(a.cfg.networkCommand("fd00::5/128", true)).To(ContainElement("address-family ipv6 unicast"))
+		Expect(a.advertised).To(Equal(map[string]bool{"192.168.111.4/32": true}))
+		Expect(rendered).To(HaveLen(2))
+
+		By("not_touching_frr_without_change", func() {
+			commands = nil
+			Expect(a.reconcile()).To(Succeed())
+			Expect(commands).To(BeEmpty())
+			Expect(rendered).To(HaveLen(2))
+		})
+	})
+
+	It("withdraws_everything_on_exit", func() {
+		ingressUp = true
+		Expect(a.reconcile()).To(Succeed())
+		a.withdrawAll()
+		Expect(a.advertised).To(BeEmpty())
+		Expect(rendered[len(rendered)-1].IPv4Prefixes).To(BeEmpty())
+	})
+
+	It("renders_the_sample_template", func() {
+		a.cfg.Communities = []string{"65000:100", "65000:200"}
+		a.advertised = map[string]bool{"192.168.111.5/32": true, "fd00::5/128": true}
+		dir, err := os.MkdirTemp("", "frr")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		cfgPath := filepath.Join(dir, "frr.conf")
+
+		Expect(render.RenderFile(render.FileSpec{RenderPath: cfgPath, TemplatePath: "../../test/data/frr.conf.tmpl"}, a.frrConfig())).To(Succeed())
+		content, err := os.ReadFile(cfgPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring(" set community 65000:100 65000:200\n"))
+		Expect(string(content)).To(ContainSubstring(" neighbor 192.168.111.1 remote-as 64512\n"))
+		// Nothing puts the VIPs on the node in bgp mode, the networks are
+		// advertised without a route of their own
+		Expect(string(content)).To(ContainSubstring("router bgp 64512\n no bgp ebgp-requires-policy\n no bgp network import-check\n"))
+		Expect(strings.Count(string(content), "route-map "+frrRouteMap)).To(Equal(3))
+		Expect(string(content)).NotTo(ContainSubstring("bfd"))
+	})
+
+	It("configures_bfd_peers", func() {
+		a.cfg.Peers = []string{"192.168.111.1", "192.168.111.2"}
+		a.cfg.BFD = config.BFDConfig{Enabled: true, Peers: []string{"192.168.111.2"}, ReceiveInterval: 100, TransmitInterval: 200, DetectMultiplier: 3}
+		dir, err := os.MkdirTemp("", "frr")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		cfgPath := filepath.Join(dir, "frr.conf")
+
+		Expect(render.RenderFile(render.FileSpec{RenderPath: cfgPath, TemplatePath: "../../test/data/frr.conf.tmpl"}, a.frrConfig())).To(Succeed())
+		content, err := os.ReadFile(cfgPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring("bfd\n peer 192.168.111.2\n  receive-interval 100\n  transmit-interval 200\n  detect-multiplier 3\n exit\n"))
+		Expect(string(content)).To(ContainSubstring(" neighbor 192.168.111.2 bfd\n"))
+		Expect(string(content)).NotTo(ContainSubstring("192.168.111.1 bfd"))
+
+		By("exposing_them_to_the_keepalived_template", func() {
+			node := config.Node{Configs: &[]config.Node{{}}}
+			setBFD(&node, a.cfg.BFD)
+			Expect(node.BFD).To(Equal(&a.cfg.BFD))
+			Expect((*node.Configs)[0].BFD).To(Equal(&a.cfg.BFD))
+		})
+	})
+})
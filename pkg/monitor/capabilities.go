@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	// DHCPClientAuto uses the internal client when it can open its packet
+	// socket and dhclient otherwise
+	DHCPClientAuto = "auto"
+	// DHCPClientInternal leases the VIPs with the client of the monitor,
+	// which needs CAP_NET_RAW for IPv4
+	DHCPClientInternal = "internal"
+	// DHCPClientDhclient runs dhclient, which must be in the image
+	DHCPClientDhclient = "dhclient"
+
+	// dhcpcd is detected but cannot lease the VIPs, its lease files are not
+	// in the dhclient format the lease watcher reads
+	dhcpClientDhcpcd = "dhcpcd"
+
+	// The variants of the iptables binary, as printed by iptables --version
+	iptablesNft    = "iptables-nft"
+	iptablesLegacy = "iptables-legacy"
+
+	capabilityDHCPClient = "dhcp_client"
+	capabilityFirewall   = "firewall"
+)
+
+var runtimeCapability = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "baremetal_runtimecfg_runtime_capability",
+	Help: "1 for the implementation of a capability the monitor uses, 0 for the ones found in the image but not used",
+}, []string{"capability", "implementation"})
+
+func init() {
+	prometheus.MustRegister(runtimeCapability)
+}
+
+// publishCapability sets the metrics of the implementations of capability
+// found in the image, used being the one the monitor uses
+func publishCapability(capability, used string, available []string) {
+	for _, implementation := range available {
+		value := 0.0
+		if implementation == used {
+			value = 1
+		}
+		runtimeCapability.WithLabelValues(capability, implementation).Set(value)
+	}
+	log.WithFields(logrus.Fields{
+		"capability":     capability,
+		"implementation": used,
+		"available":      available,
+	}).Info("Detected runtime capability")
+}
+
+// checkPacketSocket opens and closes the packet socket of the internal
+// DHCPv4 client
+func checkPacketSocket() error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(utils.Htons(unix.ETH_P_IP)))
+	if err != nil {
+		return err
+	}
+	return unix.Close(fd)
+}
+
+// detectDHCPClient returns the client of name that can lease the VIPs, and
+// the implementations found
+func detectDHCPClient(name string, lookPath func(string) (string, error), packetSocket func() error) (string, []string, error) {
+	available := []string{}
+	socketErr := packetSocket()
+	if socketErr == nil {
+		available = append(available, DHCPClientInternal)
+	}
+	for _, binary := range []string{DHCPClientDhclient, dhcpClientDhcpcd} {
+		if _, err := lookPath(binary); err == nil {
+			available = append(available, binary)
+		}
+	}
+	has := func(implementation string) bool {
+		for _, a := range available {
+			if a == implementation {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch name {
+	case DHCPClientInternal:
+		if socketErr != nil {
+			return "", available, fmt.Errorf("The internal DHCP client cannot open its packet socket, it needs CAP_NET_RAW: %w", socketErr)
+		}
+		return DHCPClientInternal, available, nil
+	case DHCPClientDhclient:
+		if !has(DHCPClientDhclient) {
+			return "", available, fmt.Errorf("dhclient is not in the image")
+		}
+		return DHCPClientDhclient, available, nil
+	case DHCPClientAuto:
+		if socketErr == nil {
+			return DHCPClientInternal, available, nil
+		}
+		if has(DHCPClientDhclient) {
+			return DHCPClientDhclient, available, nil
+		}
+		if has(dhcpClientDhcpcd) {
+			return "", available, fmt.Errorf("No DHCP client can lease the VIPs: the internal client cannot open its packet socket (%v) and dhcpcd is not supported", socketErr)
+		}
+		return "", available, fmt.Errorf("No DHCP client can lease the VIPs: the internal client cannot open its packet socket (%v) and dhclient is not in the image", socketErr)
+	}
+	return "", available, fmt.Errorf("Unknown DHCP client %q", name)
+}
+
+var (
+	// requestedDHCPClient is the client the monitor was configured with,
+	// set before anything is leased
+	requestedDHCPClient    = DHCPClientAuto
+	selectedDHCPClientOnce sync.Once
+	selectedDHCPClient     string
+	selectedDHCPClientErr  error
+)
+
+// setDHCPClient sets the client getDHCPClient detects, it must be called
+// before the first lease
+func setDHCPClient(name string) {
+	requestedDHCPClient = name
+}
+
+// getDHCPClient detects the DHCP client once and publishes it
+func getDHCPClient() (string, error) {
+	selectedDHCPClientOnce.Do(func() {
+		var available []string
+		selectedDHCPClient, available, selectedDHCPClientErr = detectDHCPClient(requestedDHCPClient, exec.LookPath, checkPacketSocket)
+		publishCapability(capabilityDHCPClient, selectedDHCPClient, available)
+	})
+	return selectedDHCPClient, selectedDHCPClientErr
+}
+
+// ValidateDHCPClient checks that name is a known DHCP client
+func ValidateDHCPClient(name string) error {
+	switch name {
+	case DHCPClientAuto, DHCPClientInternal, DHCPClientDhclient:
+		return nil
+	}
+	return fmt.Errorf("Unknown DHCP client %q", name)
+}
+
+// iptablesVariant returns whether the iptables binary drives the nftables
+// compat layer or the legacy xtables, from its version line, e.g.
+// "iptables v1.8.8 (nf_tables)". The versions before the nftables compat
+// layer print no variant. Empty when unknown.
+func iptablesVariant(version []byte) string {
+	switch {
+	case strings.Contains(string(version), "(nf_tables)"):
+		return iptablesNft
+	case strings.HasPrefix(string(version), "iptables v"):
+		return iptablesLegacy
+	}
+	return ""
+}
+
+// iptablesVersion runs iptables --version, swapped out by the tests
+var iptablesVersion = func() ([]byte, error) {
+	return exec.Command("iptables", "--version").Output()
+}
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("runtime_capabilities", func() {
+	lookPath := func(available ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, a := range available {
+				if a == name {
+					return "/usr/sbin/" + name, nil
+				}
+			}
+			return "", fmt.Errorf("%s not found", name)
+		}
+	}
+	socketOK := func() error { return nil }
+	socketDenied := func() error { return errors.New("operation not permitted") }
+
+	It("prefers_the_internal_dhcp_client", func() {
+		client, available, err := detectDHCPClient(DHCPClientAuto, lookPath("dhclient", "dhcpcd"), socketOK)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(client).Should(Equal(DHCPClientInternal))
+		Expect(available).Should(Equal([]string{DHCPClientInternal, DHCPClientDhclient, dhcpClientDhcpcd}))
+	})
+
+	It("falls_back_to_dhclient", func() {
+		client, _, err := detectDHCPClient(DHCPClientAuto, lookPath("dhclient"), socketDenied)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(client).Should(Equal(DHCPClientDhclient))
+
+		_, available, err := detectDHCPClient(DHCPClientAuto, lookPath("dhcpcd"), socketDenied)
+		Expect(err).Should(MatchError(ContainSubstring("dhcpcd is not supported")))
+		Expect(available).Should(Equal([]string{dhcpClientDhcpcd}))
+
+		_, _, err = detectDHCPClient(DHCPClientAuto, lookPath(), socketDenied)
+		Expect(err).Should(MatchError(ContainSubstring("dhclient is not in the image")))
+	})
+
+	It("checks_the_selected_dhcp_client", func() {
+		_, _, err := detectDHCPClient(DHCPClientInternal, lookPath("dhclient"), socketDenied)
+		Expect(err).Should(MatchError(ContainSubstring("CAP_NET_RAW")))
+		_, _, err = detectDHCPClient(DHCPClientDhclient, lookPath("dhcpcd"), socketOK)
+		Expect(err).Should(HaveOccurred())
+		client, _, err := detectDHCPClient(DHCPClientDhclient, lookPath("dhclient"), socketOK)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(client).Should(Equal(DHCPClientDhclient))
+
+		Expect(ValidateDHCPClient("dhcpcd")).ShouldNot(Succeed())
+		Expect(ValidateDHCPClient(DHCPClientAuto)).Should(Succeed())
+	})
+
+	It("runs_dhclient_without_configuring_the_lease", func() {
+		Expect(dhclientArgs("ens3-api", "52-54-00-aa-bb-cc-api", "/etc/keepalived/lease-ens3-api", false, false)).Should(Equal(
+			[]string{"-d", "-sf", "/bin/true", "-lf", "/etc/keepalived/lease-ens3-api", "-pf", "/etc/keepalived/lease-ens3-api.pid", "-H", "52-54-00-aa-bb-cc-api", "ens3-api"}))
+		Expect(dhclientArgs("ens3-api", "52-54-00-aa-bb-cc-api", "/etc/keepalived/lease6-ens3-api", true, true)).Should(Equal(
+			[]string{"-6", "-d", "-sf", "/bin/true", "-lf", "/etc/keepalived/lease6-ens3-api", "-pf", "/etc/keepalived/lease6-ens3-api.pid", "-r", "ens3-api"}))
+		Expect(isStaleDhclient([]byte("dhclient\x00"+strings.Join(dhclientArgs("ens3-api", "", "/etc/keepalived/lease-ens3-api", false, false), "\x00")), "/etc/keepalived")).Should(BeTrue())
+	})
+})
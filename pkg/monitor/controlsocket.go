@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	controlSocketAttempts     = 5
+	controlSocketWriteTimeout = 5 * time.Second
+	controlSocketBackoff      = 500 * time.Millisecond
+	controlSocketMaxBackoff   = 8 * time.Second
+)
+
+var controlSocketFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "baremetal_runtimecfg_control_socket_command_failures_total",
+	Help: "Number of commands that could not be written to a control socket after all the retries",
+}, []string{"socket", "command"})
+
+func init() {
+	prometheus.MustRegister(controlSocketFailures)
+}
+
+// controlSocket writes commands to the control socket of keepalived or of the
+// HAProxy master. The connection is opened again after a failed write, so a
+// restarted process does not need a restart of the monitor.
+type controlSocket struct {
+	// name labels the failure metric, e.g. keepalived or haproxy
+	name string
+	path string
+	conn net.Conn
+	// dial is swapped out by the tests
+	dial func(path string, timeout time.Duration) (net.Conn, error)
+}
+
+func newControlSocket(name, path string) *controlSocket {
+	return &controlSocket{
+		name: name,
+		path: path,
+		dial: func(path string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", path, timeout)
+		},
+	}
+}
+
+// connect opens the connection unless it is already open
+func (s *controlSocket) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	conn, err := s.dial(s.path, controlSocketWriteTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *controlSocket) write(command string) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(controlSocketWriteTimeout)); err != nil {
+		s.Close()
+		return err
+	}
+	if _, err := s.conn.Write([]byte(command + "\n")); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// Send writes command, reconnecting and backing off between the attempts. It
+// gives up after controlSocketAttempts attempts or when ctx is cancelled.
+func (s *controlSocket) Send(ctx context.Context, command string) error {
+	command = strings.TrimSpace(command)
+	backoff := controlSocketBackoff
+	var err error
+	for attempt := 1; attempt <= controlSocketAttempts; attempt++ {
+		if err = s.write(command); err == nil {
+			return nil
+		}
+		log.WithFields(logrus.Fields{
+			"socket":  s.path,
+			"command": command,
+			"attempt": attempt,
+		}).WithError(err).Warn("Failed to write command to control socket")
+		if attempt == controlSocketAttempts || !sleep(ctx, backoff) {
+			break
+		}
+		backoff = min(2*backoff, controlSocketMaxBackoff)
+	}
+	controlSocketFailures.WithLabelValues(s.name, command).Inc()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("Failed to write %s to %s: %w", command, s.path, err)
+}
+
+// Close closes the connection, the next Send opens it again
+func (s *controlSocket) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
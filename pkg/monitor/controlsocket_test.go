@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("control_socket", func() {
+	var dials int
+	var commands chan string
+
+	// pipe returns the client end of a connection whose commands are read
+	// into commands
+	pipe := func() net.Conn {
+		client, server := net.Pipe()
+		go func() {
+			scanner := bufio.NewScanner(server)
+			for scanner.Scan() {
+				commands <- scanner.Text()
+			}
+		}()
+		return client
+	}
+
+	BeforeEach(func() {
+		dials = 0
+		commands = make(chan string, 10)
+	})
+
+	It("reconnects_after_a_failed_write", func() {
+		sock := newControlSocket("test-reconnect", "/test.sock")
+		sock.dial = func(string, time.Duration) (net.Conn, error) {
+			dials++
+			conn := pipe()
+			if dials == 1 {
+				conn.Close()
+			}
+			return conn, nil
+		}
+		Expect(sock.Send(context.Background(), "reload\n")).To(Succeed())
+		Expect(<-commands).To(Equal("reload"))
+		Expect(dials).To(Equal(2))
+		Expect(metricValue(controlSocketFailures.WithLabelValues("test-reconnect", "reload"))).To(Equal(0.0))
+	})
+
+	It("gives_up_when_cancelled", func() {
+		sock := newControlSocket("test-cancel", "/test.sock")
+		sock.dial = func(string, time.Duration) (net.Conn, error) {
+			dials++
+			return nil, errors.New("connection refused")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(sock.Send(ctx, "stop")).To(MatchError(context.Canceled))
+		Expect(dials).To(Equal(1))
+		Expect(metricValue(controlSocketFailures.WithLabelValues("test-cancel", "stop"))).To(Equal(1.0))
+	})
+})
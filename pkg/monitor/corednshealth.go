@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// corednsStatus tracks the state of the coredns monitor for the health
+// endpoint.
+type corednsStatus struct {
+	sync.Mutex
+	cfgPath         string
+	lastRenderOK    bool
+	lastRenderError string
+	lastRenderTime  time.Time
+	upstreams       []string
+	nodeAddresses   int
+}
+
+type corednsHealthReport struct {
+	Healthy         bool      `json:"healthy"`
+	LastRenderOK    bool      `json:"lastRenderOK"`
+	LastRenderError string    `json:"lastRenderError,omitempty"`
+	LastRenderTime  time.Time `json:"lastRenderTime,omitempty"`
+	CorefileAge     string    `json:"corefileAge,omitempty"`
+	Upstreams       []string  `json:"upstreams"`
+	NodeAddresses   int       `json:"nodeAddresses"`
+}
+
+func (s *corednsStatus) renderDone(err error, upstreams []string, nodeAddresses int) {
+	s.Lock()
+	defer s.Unlock()
+	s.lastRenderOK = err == nil
+	s.lastRenderError = ""
+	if err != nil {
+		s.lastRenderError = err.Error()
+	}
+	s.lastRenderTime = time.Now()
+	s.upstreams = upstreams
+	s.nodeAddresses = nodeAddresses
+}
+
+func (s *corednsStatus) report() corednsHealthReport {
+	s.Lock()
+	defer s.Unlock()
+	r := corednsHealthReport{
+		LastRenderOK:    s.lastRenderOK,
+		LastRenderError: s.lastRenderError,
+		LastRenderTime:  s.lastRenderTime,
+		Upstreams:       s.upstreams,
+		NodeAddresses:   s.nodeAddresses,
+	}
+	if info, err := os.Stat(s.cfgPath); err == nil {
+		r.CorefileAge = time.Since(info.ModTime()).Round(time.Second).String()
+	}
+	r.Healthy = s.lastRenderOK && r.CorefileAge != ""
+	return r
+}
+
+// summary returns the health of the monitor and why it is unhealthy
+func (s *corednsStatus) summary() (bool, string) {
+	r := s.report()
+	switch {
+	case r.Healthy:
+		return true, ""
+	case r.LastRenderError != "":
+		return false, "Failed to render the Corefile: " + r.LastRenderError
+	case r.CorefileAge == "":
+		return false, "The Corefile has not been rendered"
+	}
+	return false, "The Corefile has not been rendered since the monitor started"
+}
+
+func (s *corednsStatus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r := s.report()
+	w.Header().Set("Content-Type", "application/json")
+	if !r.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(r)
+}
+
+// serveCorednsHealth starts the health endpoint on addr. An empty addr
+// disables it.
+func serveCorednsHealth(addr string, status *corednsStatus) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", status)
+	go func() {
+		log.WithFields(logrus.Fields{
+			"address": addr,
+		}).Info("Serving coredns monitor health endpoint")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.WithFields(logrus.Fields{
+				"address": addr,
+			}).WithError(err).Error("Health endpoint stopped")
+		}
+	}()
+}
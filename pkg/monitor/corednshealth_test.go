@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("corednsStatus", func() {
+	var cfgFile *os.File
+
+	BeforeEach(func() {
+		var err error
+		cfgFile, err = os.CreateTemp("", "Corefile")
+		Expect(err).ShouldNot(HaveOccurred())
+		cfgFile.Close()
+	})
+
+	AfterEach(func() {
+		os.Remove(cfgFile.Name())
+	})
+
+	get := func(s *corednsStatus) (int, corednsHealthReport) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		r := corednsHealthReport{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &r)).To(Succeed())
+		return rec.Code, r
+	}
+
+	It("is unhealthy before the first render", func() {
+		code, _ := get(&corednsStatus{cfgPath: cfgFile.Name()})
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("reports the last successful render", func() {
+		s := &corednsStatus{cfgPath: cfgFile.Name()}
+		s.renderDone(nil, []string{"10.0.0.1"}, 3)
+		code, r := get(s)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(r.Upstreams).To(Equal([]string{"10.0.0.1"}))
+		Expect(r.NodeAddresses).To(Equal(3))
+		Expect(r.CorefileAge).ToNot(BeEmpty())
+	})
+
+	It("reports render failures", func() {
+		s := &corednsStatus{cfgPath: cfgFile.Name()}
+		s.renderDone(errors.New("bad template"), nil, 0)
+		code, r := get(s)
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(r.LastRenderError).To(Equal("bad template"))
+	})
+})
@@ -1,100 +1,222 @@
 package monitor
 
 import (
-	"net"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"sort"
-	"syscall"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
 	"github.com/openshift/baremetal-runtimecfg/pkg/render"
 	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/fsnotify.v1"
 )
 
-const resolvConfFilepath string = "/var/run/NetworkManager/resolv.conf"
+const (
+	resolvConfFilepath string = "/var/run/NetworkManager/resolv.conf"
+	// resolvConfSettleTime is how long we wait after a resolv.conf event
+	// before rendering, so a burst of writes results in a single render.
+	resolvConfSettleTime = 500 * time.Millisecond
+)
 
-func CorednsWatch(kubeconfigPath, clusterConfigPath, templatePath, cfgPath string, apiVips, ingressVips []net.IP, interval time.Duration, apiLBIPs, apiIntLBIPs, ingressLBIPs []net.IP) error {
-	signals := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
+func nodeAddressesChanged(newConfig, prevConfig config.Node) bool {
+	return nodeNamesChanged(newConfig.Cluster.NodeAddresses, prevConfig.Cluster.NodeAddresses) ||
+		nodeNamesChanged(newConfig.Cluster.IngressNodeAddresses, prevConfig.Cluster.IngressNodeAddresses)
+}
 
-	signal.Notify(signals, syscall.SIGTERM)
-	signal.Notify(signals, syscall.SIGINT)
-	go func() {
-		<-signals
-		done <- true
-	}()
+func nodeNamesChanged(newAddresses, prevAddresses []config.NodeAddress) bool {
+	if len(newAddresses) != len(prevAddresses) {
+		return true
+	}
+	for i, addr := range newAddresses {
+		if addr.Name != prevAddresses[i].Name {
+			return true
+		}
+	}
+	return false
+}
+
+// isResolvConfEvent returns true if the event affects the watched resolv.conf.
+// NetworkManager replaces the file with a rename, so we watch the parent
+// directory and filter on the file name.
+func isResolvConfEvent(event fsnotify.Event, resolvConfPath string) bool {
+	if filepath.Clean(event.Name) != filepath.Clean(resolvConfPath) {
+		return false
+	}
+	return event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0
+}
 
-	prevMD5, err := utils.GetFileMd5(resolvConfFilepath)
+// CorednsWatch renders the Corefile of the node-local DNS from
+// opts.TemplatePath into opts.CfgPath on resolv.conf and node changes
+func CorednsWatch(opts Options) error {
+	kubeconfigPath, cfgPath, interval := opts.KubeconfigPath, opts.CfgPath, opts.Interval
+	ctx, cancel := notifyContext()
+	defer cancel()
+	refresh, stopRefresh := notifyRefresh()
+	defer stopRefresh()
+
+	if _, err := os.Stat(resolvConfFilepath); err != nil {
+		return err
+	}
+	watcher, err := utils.CreateFileWatcher(log, filepath.Dir(resolvConfFilepath))
 	if err != nil {
 		return err
 	}
+	defer watcher.Close()
+
+	// The Corefile and any additional files (e.g. hosts or zone files it
+	// references) are rendered as a single transaction.
+	files := []render.FileSpec{opts.Render.Apply(render.FileSpec{RenderPath: cfgPath, TemplatePath: opts.TemplatePath, Validate: render.ValidateCorefile})}
+	for _, f := range opts.ExtraFiles {
+		files = append(files, opts.Render.Apply(f))
+	}
 	prevConfig := config.Node{}
+	status := &corednsStatus{cfgPath: cfgPath}
+	serveCorednsHealth(opts.HealthAddress, status)
+	serveMetrics(opts.MetricsAddress)
+	reporter := newHealthReporter(opts, health.ComponentCoredns)
+	// Render as soon as we start; afterwards only on resolv.conf events or
+	// node changes.
+	resolvConfChanged := true
+	settle := time.NewTimer(0)
+	defer settle.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	nodeEvents, unsubscribe := config.SharedNodeCache().Subscribe(kubeconfigPath, config.NodePeersChanged|config.NodeAddressesChanged)
+	defer unsubscribe()
+	steady := newSteadyInterval("coredns", interval, opts.Steady)
+	// CoreDNS loads the Corefile on its own, so only its content is checked
+	drift := newDriftChecker(opts, "coredns", cfgPath, "")
+	serveProbes(opts.ProbeAddress, "coredns", opts.Steady.loopTimeout(interval))
 
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
 			return nil
-		default:
-			curMD5, err := utils.GetFileMd5(resolvConfFilepath)
-			if err != nil {
-				return err
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
 			}
-			clusterLBConfig := config.ClusterLBConfig{ApiLBIPs: apiLBIPs, ApiIntLBIPs: apiIntLBIPs, IngressLBIPs: ingressLBIPs}
-			newConfig, err := config.GetConfig(kubeconfigPath, clusterConfigPath, resolvConfFilepath, apiVips, ingressVips, 0, 0, 0, clusterLBConfig)
-			if err != nil {
-				return err
+			if isResolvConfEvent(event, resolvConfFilepath) {
+				log.WithFields(logrus.Fields{
+					"event": event.String(),
+				}).Debug("resolv.conf event received")
+				resolvConfChanged = true
+				dnsResolvConfChanges.WithLabelValues(dnsMonitorCoredns).Inc()
+				settle.Reset(resolvConfSettleTime)
+			}
+			continue
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
 			}
+			log.WithFields(logrus.Fields{
+				"path": resolvConfFilepath,
+			}).WithError(err).Error("resolv.conf watcher error")
+			continue
+		case <-refresh:
+			log.Info("SIGHUP received, forcing a Corefile render")
+			resolvConfChanged = true
+		case <-settle.C:
+		case <-ticker.C:
+		case <-nodeEvents:
+		}
+		probes.Beat("coredns")
+		reporter.Report(status.summary())
+		if drift.check() && opts.RerenderOnDrift {
+			log.Info("Rendering the drifted Corefile again")
+			resolvConfChanged = true
+		}
 
-			// Populate cloud LB IP addresses for platforms where the cloud LBs
-			// have already been configured
-			newConfig, err = config.PopulateCloudLBIPAddresses(clusterLBConfig, newConfig)
+		newConfig, err := getConfig(opts, kubeconfigPath, opts.ClusterConfigPath, resolvConfFilepath, opts.APIVIPs, opts.IngressVIPs, 0, 0, 0, opts.ClusterLBConfig)
+		if err != nil {
+			return err
+		}
+
+		// Populate cloud LB IP addresses for platforms where the cloud LBs
+		// have already been configured. GetConfig already lists the LB IPs
+		// of user managed load balancers.
+		if !newConfig.UserManagedLB {
+			newConfig, err = config.PopulateCloudLBIPAddresses(opts.ClusterLBConfig, newConfig)
 			if err != nil {
 				return err
 			}
+		}
 
-			config.PopulateNodeAddresses(kubeconfigPath, &newConfig)
-			// There should never be 0 nodes in a functioning cluster. This means
-			// we failed to populate the list, so we don't want to render.
-			if len(newConfig.Cluster.NodeAddresses) == 0 {
-				time.Sleep(interval)
-				continue
-			}
-			sort.SliceStable(newConfig.Cluster.NodeAddresses, func(i, j int) bool {
-				return newConfig.Cluster.NodeAddresses[i].Name < newConfig.Cluster.NodeAddresses[j].Name
-			})
-			addressesChanged := len(newConfig.Cluster.NodeAddresses) != len(prevConfig.Cluster.NodeAddresses)
-			if !addressesChanged {
-				for i, addr := range newConfig.Cluster.NodeAddresses {
-					if addr.Name != prevConfig.Cluster.NodeAddresses[i].Name {
-						addressesChanged = true
-						break
-					}
-				}
+		if err = config.PopulateAPIResolution(&newConfig, opts.DNSView); err != nil {
+			return err
+		}
+
+		forwarders, err := config.GetDNSForwarders(kubeconfigPath)
+		if err != nil {
+			// Keep serving the last known forwarders rather than dropping
+			// them because of a transient API error or a bad edit.
+			log.WithError(err).Warn("Failed to get DNS forwarders, keeping previous ones")
+			forwarders = prevConfig.DNSForwarders
+		}
+		newConfig.DNSForwarders = forwarders
+		populateIngressShards(opts, &newConfig, prevConfig.IngressShards)
+		populateRouterReadiness(opts, &newConfig, prevConfig.Cluster.NoReadyRouters)
+
+		config.PopulateNodeAddressesWithFilter(kubeconfigPath, &newConfig, opts.IngressFilter)
+		// There should never be 0 nodes in a functioning cluster. This means
+		// we failed to populate the list, so we don't want to render.
+		if len(newConfig.Cluster.NodeAddresses) == 0 {
+			continue
+		}
+		sort.SliceStable(newConfig.Cluster.NodeAddresses, func(i, j int) bool {
+			return newConfig.Cluster.NodeAddresses[i].Name < newConfig.Cluster.NodeAddresses[j].Name
+		})
+		sort.SliceStable(newConfig.Cluster.IngressNodeAddresses, func(i, j int) bool {
+			return newConfig.Cluster.IngressNodeAddresses[i].Name < newConfig.Cluster.IngressNodeAddresses[j].Name
+		})
+		config.PopulateReverseZones(&newConfig)
+		addressesChanged := nodeAddressesChanged(newConfig, prevConfig)
+		forwardersChanged := len(newConfig.DNSForwarders)+len(prevConfig.DNSForwarders) > 0 && !cmp.Equal(newConfig.DNSForwarders, prevConfig.DNSForwarders)
+		shardsChanged := len(newConfig.IngressShards)+len(prevConfig.IngressShards) > 0 && !cmp.Equal(newConfig.IngressShards, prevConfig.IngressShards)
+		routersChanged := newConfig.Cluster.NoReadyRouters != prevConfig.Cluster.NoReadyRouters
+		changed := resolvConfChanged || addressesChanged || forwardersChanged || shardsChanged || routersChanged
+		ticker.Reset(steady.next(changed))
+		if changed {
+			if addressesChanged {
+				log.WithFields(logrus.Fields{
+					"Node Addresses": newConfig.Cluster.NodeAddresses,
+				}).Info("Node change detected, rendering Corefile")
+			} else if forwardersChanged {
+				log.WithFields(logrus.Fields{
+					"DNS forwarders": newConfig.DNSForwarders,
+				}).Info("DNS forwarding change detected, rendering Corefile")
+			} else if shardsChanged {
+				log.WithFields(logrus.Fields{
+					"Ingress shards": newConfig.IngressShards,
+				}).Info("Ingress shard change detected, rendering Corefile")
+			} else if routersChanged {
+				log.WithFields(logrus.Fields{
+					"No ready routers": newConfig.Cluster.NoReadyRouters,
+				}).Info("Router readiness change detected, rendering Corefile")
+			} else {
+				log.WithFields(logrus.Fields{
+					"DNS upstreams": newConfig.DNSUpstreams,
+				}).Info("Resolv.conf change detected, rendering Corefile")
 			}
-			if curMD5 != prevMD5 || addressesChanged {
-				if addressesChanged {
-					log.WithFields(logrus.Fields{
-						"Node Addresses": newConfig.Cluster.NodeAddresses,
-					}).Info("Node change detected, rendering Corefile")
-				} else {
-					log.WithFields(logrus.Fields{
-						"DNS upstreams": newConfig.DNSUpstreams,
-					}).Info("Resolv.conf change detected, rendering Corefile")
-				}
-				err = render.RenderFile(cfgPath, templatePath, newConfig)
-				if err != nil {
-					log.WithFields(logrus.Fields{
-						"config": newConfig,
-					}).Error("Failed to render coredns Corefile")
-					return err
-				}
+			err = render.RenderFiles(files, newConfig)
+			status.renderDone(err, newConfig.DNSUpstreams, len(newConfig.Cluster.NodeAddresses))
+			recordDNSRender(dnsMonitorCoredns, err, len(newConfig.Cluster.NodeAddresses))
+			if err != nil {
+				// The live Corefile has not been touched, so keep serving
+				// it and retry on the next iteration.
+				log.WithFields(logrus.Fields{
+					"config": newConfig,
+				}).WithError(err).Error("Failed to render coredns Corefile")
+				continue
 			}
-			prevMD5 = curMD5
-			prevConfig = newConfig
-			time.Sleep(interval)
+			drift.rendered(true)
 		}
+		resolvConfChanged = false
+		prevConfig = newConfig
+		probes.SetReady(true)
 	}
 }
@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/fsnotify.v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("corednsmonitor", func() {
+	Context("isResolvConfEvent", func() {
+		It("accepts writes and renames of resolv.conf", func() {
+			Expect(isResolvConfEvent(fsnotify.Event{Name: resolvConfFilepath, Op: fsnotify.Write}, resolvConfFilepath)).To(BeTrue())
+			Expect(isResolvConfEvent(fsnotify.Event{Name: resolvConfFilepath, Op: fsnotify.Create}, resolvConfFilepath)).To(BeTrue())
+			Expect(isResolvConfEvent(fsnotify.Event{Name: resolvConfFilepath, Op: fsnotify.Rename}, resolvConfFilepath)).To(BeTrue())
+		})
+		It("ignores other files and chmod events", func() {
+			Expect(isResolvConfEvent(fsnotify.Event{Name: "/var/run/NetworkManager/no-stub-resolv.conf", Op: fsnotify.Write}, resolvConfFilepath)).To(BeFalse())
+			Expect(isResolvConfEvent(fsnotify.Event{Name: resolvConfFilepath, Op: fsnotify.Chmod}, resolvConfFilepath)).To(BeFalse())
+		})
+	})
+
+	Context("nodeAddressesChanged", func() {
+		node := func(names ...string) config.Node {
+			n := config.Node{}
+			for _, name := range names {
+				n.Cluster.NodeAddresses = append(n.Cluster.NodeAddresses, config.NodeAddress{Name: name})
+			}
+			return n
+		}
+		It("detects added and renamed nodes", func() {
+			Expect(nodeAddressesChanged(node("a", "b"), node("a"))).To(BeTrue())
+			Expect(nodeAddressesChanged(node("a", "c"), node("a", "b"))).To(BeTrue())
+		})
+		It("reports no change for identical lists", func() {
+			Expect(nodeAddressesChanged(node("a", "b"), node("a", "b"))).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"net"
+	"os/exec"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dhclientArgs returns the arguments of a dhclient keeping the lease of the
+// VIP interface in leaseFile, or releasing it. Its script is /bin/true, so
+// the leased address is left to keepalived, as with the internal client.
+func dhclientArgs(ifaceName, hostname, leaseFile string, ipv6, release bool) []string {
+	args := []string{}
+	if ipv6 {
+		args = append(args, "-6")
+	}
+	args = append(args, "-d", "-sf", "/bin/true", "-lf", leaseFile, "-pf", leaseFile+".pid")
+	if release {
+		args = append(args, "-r")
+	} else if hostname != "" && !ipv6 {
+		args = append(args, "-H", hostname)
+	}
+	return append(args, ifaceName)
+}
+
+// startDhclient runs dhclient for the VIP interface until the lease client
+// is stopped. dhclient writes its leases to leaseFile in the format the
+// lease watcher reads, and is restarted when it exits.
+func startDhclient(log logrus.FieldLogger, iface *net.Interface, hostname, leaseFile string, ipv6 bool) {
+	startLeaseClient(leaseFile, func(client *leaseClient) {
+		for {
+			cmd := exec.Command(DHCPClientDhclient, dhclientArgs(iface.Name, hostname, leaseFile, ipv6, false)...)
+			if err := cmd.Start(); err != nil {
+				log.WithFields(logrus.Fields{
+					"interface": iface.Name,
+				}).WithError(err).Error("Failed to start dhclient")
+				if !waitOrStop(client.stop, dhcpRetryInterval) {
+					return
+				}
+				continue
+			}
+			exited := make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+
+			select {
+			case err := <-exited:
+				log.WithFields(logrus.Fields{
+					"interface": iface.Name,
+				}).WithError(err).Warn("dhclient exited, restarting it")
+				if !waitOrStop(client.stop, dhcpRetryInterval) {
+					return
+				}
+			case <-client.stop:
+				cmd.Process.Signal(syscall.SIGTERM)
+				<-exited
+				if client.release {
+					release := exec.Command(DHCPClientDhclient, dhclientArgs(iface.Name, hostname, leaseFile, ipv6, true)...)
+					if err := release.Run(); err != nil {
+						log.WithFields(logrus.Fields{
+							"interface": iface.Name,
+						}).WithError(err).Warn("Failed to release the lease with dhclient")
+					}
+				}
+				return
+			}
+		}
+	})
+}
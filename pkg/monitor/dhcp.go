@@ -0,0 +1,314 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	dhcpBootRequest = 1
+	dhcpBootReply   = 2
+
+	dhcpFlagBroadcast = 0x8000
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+
+	dhcpOptSubnetMask    = 1
+	dhcpOptRouter        = 3
+	dhcpOptDNS           = 6
+	dhcpOptHostname      = 12
+	dhcpOptRequestedIP   = 50
+	dhcpOptLeaseTime     = 51
+	dhcpOptMessageType   = 53
+	dhcpOptServerID      = 54
+	dhcpOptParamRequest  = 55
+	dhcpOptRenewalTime   = 58
+	dhcpOptRebindingTime = 59
+	dhcpOptClientID      = 61
+	dhcpOptEnd           = 255
+	dhcpOptPad           = 0
+
+	// fixed BOOTP header plus the magic cookie
+	dhcpHeaderLen = 240
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+type dhcpMessage struct {
+	Op      byte
+	Xid     uint32
+	Flags   uint16
+	Ciaddr  net.IP
+	Yiaddr  net.IP
+	Chaddr  net.HardwareAddr
+	Options map[byte][]byte
+}
+
+func (m *dhcpMessage) messageType() byte {
+	if t := m.Options[dhcpOptMessageType]; len(t) == 1 {
+		return t[0]
+	}
+	return 0
+}
+
+func (m *dhcpMessage) ipOption(code byte) net.IP {
+	if v := m.Options[code]; len(v) == net.IPv4len {
+		return net.IP(v).To4()
+	}
+	return nil
+}
+
+func (m *dhcpMessage) durationOption(code byte) time.Duration {
+	if v := m.Options[code]; len(v) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return 0
+}
+
+// marshal encodes the message in BOOTP wire format. Options are written in
+// ascending code order so that the output is deterministic.
+func (m *dhcpMessage) marshal() []byte {
+	buf := make([]byte, dhcpHeaderLen)
+	buf[0] = m.Op
+	buf[1] = 1 // htype ethernet
+	buf[2] = byte(len(m.Chaddr))
+	binary.BigEndian.PutUint32(buf[4:8], m.Xid)
+	binary.BigEndian.PutUint16(buf[10:12], m.Flags)
+	if ip := m.Ciaddr.To4(); ip != nil {
+		copy(buf[12:16], ip)
+	}
+	if ip := m.Yiaddr.To4(); ip != nil {
+		copy(buf[16:20], ip)
+	}
+	copy(buf[28:44], m.Chaddr)
+	copy(buf[236:240], dhcpMagicCookie)
+
+	for code := 1; code < dhcpOptEnd; code++ {
+		value, ok := m.Options[byte(code)]
+		if !ok {
+			continue
+		}
+		buf = append(buf, byte(code), byte(len(value)))
+		buf = append(buf, value...)
+	}
+	buf = append(buf, dhcpOptEnd)
+
+	// Some relays drop BOOTP messages shorter than the original 300 bytes
+	for len(buf) < 300 {
+		buf = append(buf, dhcpOptPad)
+	}
+	return buf
+}
+
+func parseDHCPMessage(data []byte) (*dhcpMessage, error) {
+	if len(data) < dhcpHeaderLen {
+		return nil, fmt.Errorf("DHCP message too short: %d bytes", len(data))
+	}
+	if string(data[236:240]) != string(dhcpMagicCookie) {
+		return nil, fmt.Errorf("DHCP message has an invalid magic cookie")
+	}
+	hlen := int(data[2])
+	if hlen > 16 {
+		return nil, fmt.Errorf("DHCP message has an invalid hardware address length %d", hlen)
+	}
+
+	m := &dhcpMessage{
+		Op:      data[0],
+		Xid:     binary.BigEndian.Uint32(data[4:8]),
+		Flags:   binary.BigEndian.Uint16(data[10:12]),
+		Ciaddr:  net.IP(append([]byte{}, data[12:16]...)),
+		Yiaddr:  net.IP(append([]byte{}, data[16:20]...)),
+		Chaddr:  net.HardwareAddr(append([]byte{}, data[28:28+hlen]...)),
+		Options: map[byte][]byte{},
+	}
+
+	options := data[dhcpHeaderLen:]
+	for i := 0; i < len(options); {
+		code := options[i]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			i++
+			continue
+		}
+		if i+1 >= len(options) || i+2+int(options[i+1]) > len(options) {
+			return nil, fmt.Errorf("DHCP option %d is truncated", code)
+		}
+		length := int(options[i+1])
+		m.Options[code] = append(m.Options[code], options[i+2:i+2+length]...)
+		i += 2 + length
+	}
+	return m, nil
+}
+
+func newDHCPRequest(msgType byte, xid uint32, mac net.HardwareAddr, hostname string) *dhcpMessage {
+	clientID := append([]byte{1}, mac...)
+	return &dhcpMessage{
+		Op:     dhcpBootRequest,
+		Xid:    xid,
+		Flags:  dhcpFlagBroadcast,
+		Chaddr: mac,
+		Options: map[byte][]byte{
+			dhcpOptMessageType: {msgType},
+			dhcpOptClientID:    clientID,
+			dhcpOptHostname:    []byte(hostname),
+			dhcpOptParamRequest: {
+				dhcpOptSubnetMask, dhcpOptRouter, dhcpOptDNS, dhcpOptLeaseTime,
+				dhcpOptServerID, dhcpOptRenewalTime, dhcpOptRebindingTime,
+			},
+		},
+	}
+}
+
+// dhcpLease is an acknowledged DHCP lease
+type dhcpLease struct {
+	Interface    string
+	FixedAddress net.IP
+	SubnetMask   net.IP
+	Routers      []net.IP
+	ServerID     net.IP
+	LeaseTime    time.Duration
+	Renew        time.Time
+	Rebind       time.Time
+	Expire       time.Time
+}
+
+func newDHCPLease(iface string, ack *dhcpMessage, now time.Time) *dhcpLease {
+	lease := &dhcpLease{
+		Interface:    iface,
+		FixedAddress: ack.Yiaddr.To4(),
+		SubnetMask:   ack.ipOption(dhcpOptSubnetMask),
+		ServerID:     ack.ipOption(dhcpOptServerID),
+		LeaseTime:    ack.durationOption(dhcpOptLeaseTime),
+	}
+	// An ACK without a lease time, or a shorter one than the retries, would
+	// renew right away in a loop
+	if lease.LeaseTime < dhcpMinLeaseTime {
+		lease.LeaseTime = dhcpMinLeaseTime
+	}
+	routers := ack.Options[dhcpOptRouter]
+	for i := 0; i+net.IPv4len <= len(routers); i += net.IPv4len {
+		lease.Routers = append(lease.Routers, net.IP(routers[i:i+net.IPv4len]))
+	}
+
+	// RFC 2131 4.4.5 default T1 and T2 values
+	renew := ack.durationOption(dhcpOptRenewalTime)
+	if renew == 0 {
+		renew = lease.LeaseTime / 2
+	}
+	rebind := ack.durationOption(dhcpOptRebindingTime)
+	if rebind == 0 {
+		rebind = lease.LeaseTime * 7 / 8
+	}
+	renew = max(renew, dhcpRetryInterval)
+	rebind = max(rebind, renew)
+	lease.Renew = now.Add(renew)
+	lease.Rebind = now.Add(rebind)
+	lease.Expire = now.Add(lease.LeaseTime)
+	return lease
+}
+
+func formatLeaseTime(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%d %s", int(t.Weekday()), t.Format("2006/01/02 15:04:05"))
+}
+
+// String formats the lease the way dhclient writes it to its lease file, so
+// that the lease file stays readable by GetLastLeaseFromFile and by anything
+// else that used to consume the dhclient leases.
+func (l *dhcpLease) String() string {
+	var b strings.Builder
+	b.WriteString("lease {\n")
+	fmt.Fprintf(&b, "  interface \"%s\";\n", l.Interface)
+	fmt.Fprintf(&b, "  fixed-address %s;\n", l.FixedAddress)
+	if l.SubnetMask != nil {
+		fmt.Fprintf(&b, "  option subnet-mask %s;\n", l.SubnetMask)
+	}
+	if len(l.Routers) > 0 {
+		routers := []string{}
+		for _, r := range l.Routers {
+			routers = append(routers, r.String())
+		}
+		fmt.Fprintf(&b, "  option routers %s;\n", strings.Join(routers, ","))
+	}
+	fmt.Fprintf(&b, "  option dhcp-lease-time %d;\n", int(l.LeaseTime.Seconds()))
+	fmt.Fprintf(&b, "  option dhcp-message-type %d;\n", dhcpAck)
+	if l.ServerID != nil {
+		fmt.Fprintf(&b, "  option dhcp-server-identifier %s;\n", l.ServerID)
+	}
+	fmt.Fprintf(&b, "  renew %s;\n", formatLeaseTime(l.Renew))
+	fmt.Fprintf(&b, "  rebind %s;\n", formatLeaseTime(l.Rebind))
+	fmt.Fprintf(&b, "  expire %s;\n", formatLeaseTime(l.Expire))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// buildDHCPPacket wraps a DHCP payload in the IPv4 and UDP headers. The
+// packets are always sent to the link-layer broadcast address since the
+// interface has no address to resolve the server's MAC address with.
+func buildDHCPPacket(payload []byte, src, dst net.IP) []byte {
+	udpLen := 8 + len(payload)
+	packet := make([]byte, 20+udpLen)
+
+	ip := packet[:20]
+	ip[0] = 0x45 // version 4, 20 bytes header
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(packet)))
+	ip[8] = 64 // ttl
+	ip[9] = 17 // udp
+	copy(ip[12:16], src.To4())
+	copy(ip[16:20], dst.To4())
+	binary.BigEndian.PutUint16(ip[10:12], ipChecksum(ip))
+
+	udp := packet[20:]
+	binary.BigEndian.PutUint16(udp[0:2], dhcpClientPort)
+	binary.BigEndian.PutUint16(udp[2:4], dhcpServerPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	// A zero UDP checksum means no checksum for IPv4
+	copy(udp[8:], payload)
+	return packet
+}
+
+// extractDHCPPayload returns the UDP payload of an IPv4 packet addressed to
+// the DHCP client port, or nil for any other packet.
+func extractDHCPPayload(packet []byte) []byte {
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != 17 {
+		return nil
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if ihl < 20 || len(packet) < ihl+8 {
+		return nil
+	}
+	udp := packet[ihl:]
+	if binary.BigEndian.Uint16(udp[2:4]) != dhcpClientPort {
+		return nil
+	}
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < 8 || udpLen > len(udp) {
+		return nil
+	}
+	return udp[8:udpLen]
+}
@@ -0,0 +1,326 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	dhcp6ClientPort = 546
+	dhcp6ServerPort = 547
+
+	dhcp6Solicit   = 1
+	dhcp6Advertise = 2
+	dhcp6Request   = 3
+	dhcp6Renew     = 5
+	dhcp6Rebind    = 6
+	dhcp6Reply     = 7
+	dhcp6Release   = 8
+
+	dhcp6OptClientID    = 1
+	dhcp6OptServerID    = 2
+	dhcp6OptIANA        = 3
+	dhcp6OptIAAddr      = 5
+	dhcp6OptORO         = 6
+	dhcp6OptElapsedTime = 8
+	dhcp6OptStatusCode  = 13
+	dhcp6OptDNSServers  = 23
+	dhcp6OptClientFQDN  = 39
+
+	dhcp6StatusSuccess = 0
+
+	// DUID based on link-layer address, RFC 8415 11.4
+	dhcp6DUIDTypeLL = 3
+)
+
+// All_DHCP_Relay_Agents_and_Servers
+var dhcp6ServersAddr = net.ParseIP("ff02::1:2")
+
+type dhcp6Option struct {
+	Code uint16
+	Data []byte
+}
+
+type dhcp6Message struct {
+	Type    byte
+	Xid     uint32
+	Options []dhcp6Option
+}
+
+func (m *dhcp6Message) option(code uint16) []byte {
+	return findDHCP6Option(m.Options, code)
+}
+
+func findDHCP6Option(options []dhcp6Option, code uint16) []byte {
+	for _, o := range options {
+		if o.Code == code {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+func marshalDHCP6Options(options []dhcp6Option) []byte {
+	buf := []byte{}
+	for _, o := range options {
+		buf = binary.BigEndian.AppendUint16(buf, o.Code)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(o.Data)))
+		buf = append(buf, o.Data...)
+	}
+	return buf
+}
+
+func parseDHCP6Options(data []byte) ([]dhcp6Option, error) {
+	options := []dhcp6Option{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("DHCPv6 option header is truncated")
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return nil, fmt.Errorf("DHCPv6 option %d is truncated", code)
+		}
+		options = append(options, dhcp6Option{Code: code, Data: append([]byte{}, data[4:4+length]...)})
+		data = data[4+length:]
+	}
+	return options, nil
+}
+
+func (m *dhcp6Message) marshal() []byte {
+	buf := []byte{m.Type, byte(m.Xid >> 16), byte(m.Xid >> 8), byte(m.Xid)}
+	return append(buf, marshalDHCP6Options(m.Options)...)
+}
+
+func parseDHCP6Message(data []byte) (*dhcp6Message, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("DHCPv6 message too short: %d bytes", len(data))
+	}
+	options, err := parseDHCP6Options(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &dhcp6Message{
+		Type:    data[0],
+		Xid:     uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]),
+		Options: options,
+	}, nil
+}
+
+// dhcp6DUID returns the DUID-LL of the interface. Every VIP macvlan has its
+// own MAC address, so it is also identified as a distinct DHCPv6 client.
+func dhcp6DUID(mac net.HardwareAddr) []byte {
+	duid := []byte{0, dhcp6DUIDTypeLL, 0, 1}
+	return append(duid, mac...)
+}
+
+// dhcp6IAID derives the IAID from the last four bytes of the MAC address, as
+// dhclient does.
+func dhcp6IAID(mac net.HardwareAddr) uint32 {
+	if len(mac) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(mac[len(mac)-4:])
+}
+
+// dhcp6FQDN encodes the hostname as a partial domain name for the Client
+// FQDN option, leaving the DNS updates to the server.
+func dhcp6FQDN(hostname string) []byte {
+	buf := []byte{0}
+	for _, label := range strings.Split(strings.TrimSuffix(hostname, "."), ".") {
+		if label == "" || len(label) > 63 {
+			continue
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return buf
+}
+
+type dhcp6IAAddr struct {
+	Address   net.IP
+	Preferred time.Duration
+	Valid     time.Duration
+}
+
+type dhcp6IANA struct {
+	IAID    uint32
+	T1      time.Duration
+	T2      time.Duration
+	Addrs   []dhcp6IAAddr
+	Status  uint16
+	Message string
+}
+
+func marshalDHCP6IANA(iaid uint32, addr net.IP) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, iaid)
+	// T1 and T2 are left for the server to decide
+	buf = append(buf, make([]byte, 8)...)
+	if addr != nil {
+		iaaddr := append([]byte{}, addr.To16()...)
+		iaaddr = append(iaaddr, make([]byte, 8)...)
+		buf = append(buf, marshalDHCP6Options([]dhcp6Option{{dhcp6OptIAAddr, iaaddr}})...)
+	}
+	return buf
+}
+
+func parseDHCP6Status(data []byte) (uint16, string) {
+	if len(data) < 2 {
+		return dhcp6StatusSuccess, ""
+	}
+	return binary.BigEndian.Uint16(data[0:2]), string(data[2:])
+}
+
+func parseDHCP6IANA(data []byte) (*dhcp6IANA, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("DHCPv6 IA_NA option is truncated")
+	}
+	ia := &dhcp6IANA{
+		IAID: binary.BigEndian.Uint32(data[0:4]),
+		T1:   time.Duration(binary.BigEndian.Uint32(data[4:8])) * time.Second,
+		T2:   time.Duration(binary.BigEndian.Uint32(data[8:12])) * time.Second,
+	}
+	options, err := parseDHCP6Options(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range options {
+		switch o.Code {
+		case dhcp6OptIAAddr:
+			if len(o.Data) < 24 {
+				return nil, fmt.Errorf("DHCPv6 IAADDR option is truncated")
+			}
+			addrOptions, err := parseDHCP6Options(o.Data[24:])
+			if err != nil {
+				return nil, err
+			}
+			if status, _ := parseDHCP6Status(findDHCP6Option(addrOptions, dhcp6OptStatusCode)); status != dhcp6StatusSuccess {
+				continue
+			}
+			ia.Addrs = append(ia.Addrs, dhcp6IAAddr{
+				Address:   net.IP(o.Data[0:16]),
+				Preferred: time.Duration(binary.BigEndian.Uint32(o.Data[16:20])) * time.Second,
+				Valid:     time.Duration(binary.BigEndian.Uint32(o.Data[20:24])) * time.Second,
+			})
+		case dhcp6OptStatusCode:
+			ia.Status, ia.Message = parseDHCP6Status(o.Data)
+		}
+	}
+	return ia, nil
+}
+
+func newDHCP6Message(msgType byte, xid uint32, mac net.HardwareAddr, hostname string) *dhcp6Message {
+	return &dhcp6Message{
+		Type: msgType,
+		Xid:  xid & 0xffffff,
+		Options: []dhcp6Option{
+			{dhcp6OptClientID, dhcp6DUID(mac)},
+			{dhcp6OptORO, []byte{0, dhcp6OptDNSServers}},
+			{dhcp6OptElapsedTime, []byte{0, 0}},
+			{dhcp6OptClientFQDN, dhcp6FQDN(hostname)},
+		},
+	}
+}
+
+// dhcp6Lease is an IA_NA address leased from a DHCPv6 server
+type dhcp6Lease struct {
+	Interface string
+	IAID      uint32
+	Address   net.IP
+	ClientID  []byte
+	ServerID  []byte
+	Starts    time.Time
+	T1        time.Duration
+	T2        time.Duration
+	Preferred time.Duration
+	Valid     time.Duration
+}
+
+// newDHCP6Lease returns the lease held in the IA_NA of a server reply
+func newDHCP6Lease(iface string, iaid uint32, reply *dhcp6Message, now time.Time) (*dhcp6Lease, error) {
+	if status, msg := parseDHCP6Status(reply.option(dhcp6OptStatusCode)); status != dhcp6StatusSuccess {
+		return nil, fmt.Errorf("DHCPv6 server returned status %d: %s", status, msg)
+	}
+	data := reply.option(dhcp6OptIANA)
+	if data == nil {
+		return nil, fmt.Errorf("DHCPv6 reply has no IA_NA")
+	}
+	ia, err := parseDHCP6IANA(data)
+	if err != nil {
+		return nil, err
+	}
+	if ia.IAID != iaid {
+		return nil, fmt.Errorf("DHCPv6 reply has IAID %08x instead of %08x", ia.IAID, iaid)
+	}
+	if ia.Status != dhcp6StatusSuccess {
+		return nil, fmt.Errorf("DHCPv6 server returned IA_NA status %d: %s", ia.Status, ia.Message)
+	}
+	if len(ia.Addrs) == 0 {
+		return nil, fmt.Errorf("DHCPv6 reply has no address in IA_NA")
+	}
+
+	lease := &dhcp6Lease{
+		Interface: iface,
+		IAID:      iaid,
+		Address:   ia.Addrs[0].Address,
+		ClientID:  reply.option(dhcp6OptClientID),
+		ServerID:  reply.option(dhcp6OptServerID),
+		Starts:    now,
+		T1:        ia.T1,
+		T2:        ia.T2,
+		Preferred: ia.Addrs[0].Preferred,
+		Valid:     ia.Addrs[0].Valid,
+	}
+	// RFC 8415 21.4 lets the client choose T1 and T2 when the server does not
+	if lease.T1 == 0 {
+		lease.T1 = lease.Preferred / 2
+	}
+	if lease.T2 == 0 {
+		lease.T2 = lease.Preferred * 4 / 5
+	}
+	return lease, nil
+}
+
+func (l *dhcp6Lease) Renew() time.Time {
+	return l.Starts.Add(l.T1)
+}
+
+func (l *dhcp6Lease) Rebind() time.Time {
+	return l.Starts.Add(l.T2)
+}
+
+func (l *dhcp6Lease) Expire() time.Time {
+	return l.Starts.Add(l.Valid)
+}
+
+func formatDHCP6Octets(data []byte) string {
+	octets := []string{}
+	for _, b := range data {
+		octets = append(octets, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(octets, ":")
+}
+
+// String formats the lease like a dhclient -6 lease6 entry
+func (l *dhcp6Lease) String() string {
+	var b strings.Builder
+	b.WriteString("lease6 {\n")
+	fmt.Fprintf(&b, "  interface \"%s\";\n", l.Interface)
+	fmt.Fprintf(&b, "  ia-na %s {\n", formatDHCP6Octets(binary.BigEndian.AppendUint32(nil, l.IAID)))
+	fmt.Fprintf(&b, "    starts %d;\n", l.Starts.Unix())
+	fmt.Fprintf(&b, "    renew %d;\n", int(l.T1.Seconds()))
+	fmt.Fprintf(&b, "    rebind %d;\n", int(l.T2.Seconds()))
+	fmt.Fprintf(&b, "    iaaddr %s {\n", l.Address)
+	fmt.Fprintf(&b, "      starts %d;\n", l.Starts.Unix())
+	fmt.Fprintf(&b, "      preferred-life %d;\n", int(l.Preferred.Seconds()))
+	fmt.Fprintf(&b, "      max-life %d;\n", int(l.Valid.Seconds()))
+	b.WriteString("    }\n")
+	b.WriteString("  }\n")
+	fmt.Fprintf(&b, "  option dhcp6.client-id %s;\n", formatDHCP6Octets(l.ClientID))
+	fmt.Fprintf(&b, "  option dhcp6.server-id %s;\n", formatDHCP6Octets(l.ServerID))
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// dhcp6Client keeps a DHCPv6 IA_NA lease for a VIP macvlan. As with the
+// DHCPv4 client the address is never configured on the interface, the
+// exchanges use the link-local address of the macvlan.
+type dhcp6Client struct {
+	log       logrus.FieldLogger
+	iface     *net.Interface
+	hostname  string
+	leaseFile string
+	iaid      uint32
+	client    *leaseClient
+}
+
+func startDHCP6Client(log logrus.FieldLogger, iface *net.Interface, hostname, leaseFile string) {
+	startLeaseClient(leaseFile, func(client *leaseClient) {
+		c := &dhcp6Client{
+			log:       log,
+			iface:     iface,
+			hostname:  hostname,
+			leaseFile: leaseFile,
+			iaid:      dhcp6IAID(iface.HardwareAddr),
+			client:    client,
+		}
+		if lease := c.run(); lease != nil && client.release {
+			c.release(lease)
+		}
+	})
+}
+
+// run keeps the lease up to date until the client is stopped or the
+// interface is removed, and returns the lease held at that point.
+func (c *dhcp6Client) run() *dhcp6Lease {
+	var lease *dhcp6Lease
+	var requestedIP net.IP
+	if _, ip, err := GetLastLeaseFromFile(c.log, c.leaseFile); err == nil {
+		requestedIP = net.ParseIP(ip)
+	}
+
+	for {
+		if _, err := net.InterfaceByName(c.iface.Name); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"interface": c.iface.Name,
+			}).WithError(err).Info("Interface is gone, stopping the DHCPv6 client")
+			return nil
+		}
+
+		var newLease *dhcp6Lease
+		var err error
+		switch {
+		case lease == nil || time.Now().After(lease.Expire()):
+			newLease, err = c.acquire(requestedIP)
+		case time.Now().After(lease.Rebind()):
+			newLease, err = c.extend(lease, dhcp6Rebind)
+		default:
+			newLease, err = c.extend(lease, dhcp6Renew)
+		}
+
+		if err != nil {
+			c.log.WithFields(logrus.Fields{
+				"interface": c.iface.Name,
+			}).WithError(err).Warn("Failed to get a DHCPv6 lease")
+			if !waitOrStop(c.client.stop, dhcpRetryInterval) {
+				return lease
+			}
+			continue
+		}
+
+		lease = newLease
+		requestedIP = lease.Address
+		if err := appendLease(c.leaseFile, lease); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"filename": c.leaseFile,
+			}).WithError(err).Error("Failed to write lease file")
+		}
+		leaseAcquired(c.iface.Name, lease.Address.String(), lease.Expire())
+
+		if !waitOrStop(c.client.stop, time.Until(lease.Renew())) {
+			return lease
+		}
+	}
+}
+
+func (c *dhcp6Client) acquire(requestedIP net.IP) (*dhcp6Lease, error) {
+	solicit := newDHCP6Message(dhcp6Solicit, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	solicit.Options = append(solicit.Options, dhcp6Option{dhcp6OptIANA, marshalDHCP6IANA(c.iaid, requestedIP)})
+	advertise, err := c.exchange(c.client.stop, solicit, dhcp6Advertise)
+	if err != nil {
+		return nil, err
+	}
+	offered, err := newDHCP6Lease(c.iface.Name, c.iaid, advertise, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	request := newDHCP6Message(dhcp6Request, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	request.Options = append(request.Options,
+		dhcp6Option{dhcp6OptServerID, offered.ServerID},
+		dhcp6Option{dhcp6OptIANA, marshalDHCP6IANA(c.iaid, offered.Address)})
+	return c.leaseFromReply(request)
+}
+
+// extend renews the lease with the server that granted it or, once T2 has
+// passed, rebinds it with any server.
+func (c *dhcp6Client) extend(lease *dhcp6Lease, msgType byte) (*dhcp6Lease, error) {
+	msg := newDHCP6Message(msgType, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	if msgType == dhcp6Renew {
+		msg.Options = append(msg.Options, dhcp6Option{dhcp6OptServerID, lease.ServerID})
+	}
+	msg.Options = append(msg.Options, dhcp6Option{dhcp6OptIANA, marshalDHCP6IANA(c.iaid, lease.Address)})
+	return c.leaseFromReply(msg)
+}
+
+// release gives the address back to the server that leased it
+func (c *dhcp6Client) release(lease *dhcp6Lease) {
+	msg := newDHCP6Message(dhcp6Release, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	msg.Options = append(msg.Options,
+		dhcp6Option{dhcp6OptServerID, lease.ServerID},
+		dhcp6Option{dhcp6OptIANA, marshalDHCP6IANA(c.iaid, lease.Address)})
+
+	if _, err := c.exchange(nil, msg, dhcp6Reply); err != nil {
+		c.log.WithFields(logrus.Fields{
+			"interface": c.iface.Name,
+			"ip":        lease.Address,
+		}).WithError(err).Warn("Failed to release DHCPv6 lease")
+		return
+	}
+	c.log.WithFields(logrus.Fields{
+		"interface": c.iface.Name,
+		"ip":        lease.Address,
+	}).Info("Released DHCPv6 lease")
+}
+
+func (c *dhcp6Client) leaseFromReply(msg *dhcp6Message) (*dhcp6Lease, error) {
+	reply, err := c.exchange(c.client.stop, msg, dhcp6Reply)
+	if err != nil {
+		return nil, err
+	}
+	return newDHCP6Lease(c.iface.Name, c.iaid, reply, time.Now())
+}
+
+// listen opens the DHCPv6 client port on the interface only, so that every
+// VIP macvlan can run its own client.
+func (c *dhcp6Client) listen() (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, raw syscall.RawConn) error {
+			var sockErr error
+			err := raw.Control(func(fd uintptr) {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+				sockErr = unix.BindToDevice(int(fd), c.iface.Name)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp6", fmt.Sprintf("[::]:%d", dhcp6ClientPort))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// exchange multicasts msg to the DHCPv6 servers on the link and waits for a
+// reply of the given type, resending it a few times if no reply arrives. It
+// gives up early when stop is closed.
+func (c *dhcp6Client) exchange(stop <-chan struct{}, msg *dhcp6Message, replyType byte) (*dhcp6Message, error) {
+	conn, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: dhcp6ServersAddr, Port: dhcp6ServerPort, Zone: c.iface.Name}
+	clientID := msg.option(dhcp6OptClientID)
+	packet := msg.marshal()
+	buf := make([]byte, 1500)
+
+	for attempt := 0; attempt < dhcpSendAttempts; attempt++ {
+		if _, err := conn.WriteToUDP(packet, dst); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(dhcpReplyTimeout)
+		for time.Now().Before(deadline) {
+			select {
+			case <-stop:
+				return nil, fmt.Errorf("DHCPv6 client stopped")
+			default:
+			}
+
+			if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				return nil, err
+			}
+			n, _, err := conn.ReadFromUDP(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			reply, err := parseDHCP6Message(buf[:n])
+			if err != nil || reply.Type != replyType || reply.Xid != msg.Xid ||
+				!bytes.Equal(reply.option(dhcp6OptClientID), clientID) {
+				continue
+			}
+			return reply, nil
+		}
+	}
+	return nil, fmt.Errorf("No DHCPv6 reply on %s after %d attempts", c.iface.Name, dhcpSendAttempts)
+}
@@ -0,0 +1,282 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dhcp", func() {
+	mac, _ := net.ParseMAC("00:1a:4a:92:c8:d7")
+
+	It("message_round_trip", func() {
+		msg := newDHCPRequest(dhcpRequest, 0x1234abcd, mac, "00-1a-4a-92-c8-d7-api")
+		msg.Options[dhcpOptRequestedIP] = net.ParseIP("172.99.0.55").To4()
+
+		parsed, err := parseDHCPMessage(msg.marshal())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(parsed.Op).Should(Equal(byte(dhcpBootRequest)))
+		Expect(parsed.Xid).Should(Equal(uint32(0x1234abcd)))
+		Expect(parsed.Flags).Should(Equal(uint16(dhcpFlagBroadcast)))
+		Expect(parsed.Chaddr).Should(Equal(mac))
+		Expect(parsed.messageType()).Should(Equal(byte(dhcpRequest)))
+		Expect(parsed.ipOption(dhcpOptRequestedIP).String()).Should(Equal("172.99.0.55"))
+		Expect(string(parsed.Options[dhcpOptHostname])).Should(Equal("00-1a-4a-92-c8-d7-api"))
+	})
+
+	It("invalid_messages", func() {
+		_, err := parseDHCPMessage([]byte{1, 2, 3})
+		Expect(err).Should(HaveOccurred())
+
+		data := newDHCPRequest(dhcpDiscover, 1, mac, "host").marshal()
+		data[236] = 0
+		_, err = parseDHCPMessage(data)
+		Expect(err).Should(HaveOccurred())
+
+		data = newDHCPRequest(dhcpDiscover, 1, mac, "host").marshal()
+		data = append(data[:dhcpHeaderLen], dhcpOptHostname, 10, 'a')
+		_, err = parseDHCPMessage(data)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("packet_round_trip", func() {
+		payload := newDHCPRequest(dhcpDiscover, 1, mac, "host").marshal()
+		packet := buildDHCPPacket(payload, net.IPv4zero, net.IPv4bcast)
+		Expect(ipChecksum(packet[:20])).Should(Equal(uint16(0)))
+
+		// Replies are addressed to the client port
+		packet[22], packet[23] = packet[20], packet[21]
+		packet[20], packet[21] = 0, dhcpServerPort
+		Expect(extractDHCPPayload(packet)).Should(Equal(payload))
+
+		packet[23] = 53
+		Expect(extractDHCPPayload(packet)).Should(BeNil())
+	})
+
+	It("lease_file_format", func() {
+		ack := &dhcpMessage{
+			Op:     dhcpBootReply,
+			Yiaddr: net.ParseIP("172.99.0.55"),
+			Options: map[byte][]byte{
+				dhcpOptMessageType: {dhcpAck},
+				dhcpOptSubnetMask:  net.ParseIP("255.255.255.0").To4(),
+				dhcpOptServerID:    net.ParseIP("172.99.0.2").To4(),
+				dhcpOptLeaseTime:   {0, 0, 0xa8, 0xc0},
+			},
+		}
+		now := time.Date(2020, 8, 17, 15, 11, 32, 0, time.UTC)
+		lease := newDHCPLease("api", ack, now)
+		Expect(lease.Renew).Should(Equal(now.Add(6 * time.Hour)))
+		Expect(lease.Rebind).Should(Equal(now.Add(37800 * time.Second)))
+		Expect(lease.Expire).Should(Equal(now.Add(12 * time.Hour)))
+		Expect(lease.String()).Should(ContainSubstring("renew 1 2020/08/17 21:11:32;"))
+
+		file, err := ioutil.TempFile("", "lease")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(lease.String())
+		Expect(err).ShouldNot(HaveOccurred())
+		file.Close()
+
+		iface, ip, err := GetLastLeaseFromFile(log, file.Name())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface).Should(Equal("api"))
+		Expect(ip).Should(Equal("172.99.0.55"))
+	})
+
+	It("lease_without_lease_time", func() {
+		ack := &dhcpMessage{
+			Op:     dhcpBootReply,
+			Yiaddr: net.ParseIP("172.99.0.55"),
+			Options: map[byte][]byte{
+				dhcpOptMessageType: {dhcpAck},
+				dhcpOptServerID:    net.ParseIP("172.99.0.2").To4(),
+			},
+		}
+		now := time.Date(2020, 8, 17, 15, 11, 32, 0, time.UTC)
+		lease := newDHCPLease("api", ack, now)
+		Expect(lease.LeaseTime).Should(Equal(dhcpMinLeaseTime))
+		Expect(lease.Renew).Should(Equal(now.Add(dhcpRetryInterval)))
+		Expect(lease.Rebind).Should(Equal(now.Add(dhcpMinLeaseTime * 7 / 8)))
+		Expect(lease.Expire).Should(Equal(now.Add(dhcpMinLeaseTime)))
+
+		ack.Options[dhcpOptLeaseTime] = []byte{0, 0, 0x0e, 0x10}
+		ack.Options[dhcpOptRenewalTime] = []byte{0, 0, 0, 1}
+		Expect(newDHCPLease("api", ack, now).Renew).Should(Equal(now.Add(dhcpRetryInterval)))
+	})
+})
+
+var _ = Describe("dhcp6", func() {
+	mac, _ := net.ParseMAC("00:1a:4a:92:c8:d7")
+	iaid := dhcp6IAID(mac)
+
+	newReply := func(ianaOptions []dhcp6Option) *dhcp6Message {
+		iana := []byte{0x4a, 0x92, 0xc8, 0xd7, 0, 0, 0x0e, 0x10, 0, 0, 0x16, 0x80}
+		iana = append(iana, marshalDHCP6Options(ianaOptions)...)
+		return &dhcp6Message{
+			Type: dhcp6Reply,
+			Xid:  0xabcdef,
+			Options: []dhcp6Option{
+				{dhcp6OptClientID, dhcp6DUID(mac)},
+				{dhcp6OptServerID, []byte{0, 1, 2, 3}},
+				{dhcp6OptIANA, iana},
+			},
+		}
+	}
+	iaaddr := func(ip string) dhcp6Option {
+		data := append([]byte{}, net.ParseIP(ip).To16()...)
+		data = append(data, 0, 0, 0x1c, 0x20, 0, 0, 0x2a, 0x30)
+		return dhcp6Option{dhcp6OptIAAddr, data}
+	}
+
+	It("message_round_trip", func() {
+		msg := newDHCP6Message(dhcp6Solicit, 0x12abcdef, mac, "00-1a-4a-92-c8-d7-api")
+		msg.Options = append(msg.Options, dhcp6Option{dhcp6OptIANA, marshalDHCP6IANA(iaid, net.ParseIP("fd00::55"))})
+
+		parsed, err := parseDHCP6Message(msg.marshal())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(parsed.Type).Should(Equal(byte(dhcp6Solicit)))
+		Expect(parsed.Xid).Should(Equal(uint32(0xabcdef)))
+		Expect(parsed.option(dhcp6OptClientID)).Should(Equal([]byte{0, 3, 0, 1, 0x00, 0x1a, 0x4a, 0x92, 0xc8, 0xd7}))
+
+		ia, err := parseDHCP6IANA(parsed.option(dhcp6OptIANA))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ia.IAID).Should(Equal(uint32(0x4a92c8d7)))
+		Expect(ia.Addrs).Should(HaveLen(1))
+		Expect(ia.Addrs[0].Address.String()).Should(Equal("fd00::55"))
+	})
+
+	It("truncated_options", func() {
+		_, err := parseDHCP6Message([]byte{dhcp6Reply, 0, 0, 1, 0, dhcp6OptServerID, 0, 8, 1})
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("lease_from_reply", func() {
+		now := time.Unix(1597700000, 0)
+		lease, err := newDHCP6Lease("api", iaid, newReply([]dhcp6Option{iaaddr("fd00::55")}), now)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(lease.Address.String()).Should(Equal("fd00::55"))
+		Expect(lease.Renew()).Should(Equal(now.Add(time.Hour)))
+		Expect(lease.Rebind()).Should(Equal(now.Add(96 * time.Minute)))
+		Expect(lease.Expire()).Should(Equal(now.Add(3 * time.Hour)))
+
+		file, err := ioutil.TempFile("", "lease6")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(lease.String())
+		Expect(err).ShouldNot(HaveOccurred())
+		file.Close()
+
+		iface, ip, err := GetLastLeaseFromFile(log, file.Name())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface).Should(Equal("api"))
+		Expect(ip).Should(Equal("fd00::55"))
+	})
+
+	It("no_address_available", func() {
+		status := dhcp6Option{dhcp6OptStatusCode, append([]byte{0, 2}, "NoAddrsAvail"...)}
+		_, err := newDHCP6Lease("api", iaid, newReply([]dhcp6Option{status}), time.Now())
+		Expect(err).Should(MatchError(ContainSubstring("NoAddrsAvail")))
+	})
+
+	It("wrong_iaid", func() {
+		_, err := newDHCP6Lease("api", iaid+1, newReply([]dhcp6Option{iaaddr("fd00::55")}), time.Now())
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("vip_family", func() {
+	It("family_from_address", func() {
+		Expect(vip{IpAddress: "fd00::55"}.isIPv6()).Should(BeTrue())
+		Expect(vip{IpAddress: "172.99.0.55", Family: vipFamilyIPv6}.isIPv6()).Should(BeFalse())
+		Expect(vip{Family: vipFamilyIPv6}.isIPv6()).Should(BeTrue())
+		Expect(vip{}.isIPv6()).Should(BeFalse())
+	})
+
+	It("dual_stack_monitor_file", func() {
+		vips, err := parseMonitorFile([]byte(`
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", ip-address: 172.99.0.55}
+- {name: api6, mac-address: "00:1a:4a:00:00:02", ip-address: "fd00::55"}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03", ip-address: 172.99.0.56}
+- {name: ingress6, mac-address: "00:1a:4a:00:00:04", family: ipv6}
+`))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(vips.APIVips[1].isIPv6()).Should(BeTrue())
+		Expect(vips.IngressVips[1].isIPv6()).Should(BeTrue())
+	})
+
+	It("invalid_family", func() {
+		_, err := parseMonitorFile([]byte(`
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", family: ipv5}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03"}
+`))
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("monitor_file_v2", func() {
+	It("v2_settings", func() {
+		vips, err := parseMonitorFile([]byte(`
+version: 2
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", ip-address: 172.99.0.55, interface: eth1, vlan: 100, hostname: api-vip}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03", ip-address: 172.99.0.56}
+`))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(vips.APIVips[0].Interface).Should(Equal("eth1"))
+		Expect(vips.APIVips[0].VLAN).Should(Equal(100))
+
+		mac, _ := net.ParseMAC("00:1a:4a:00:00:03")
+		Expect(vips.APIVips[0].hostname(mac)).Should(Equal("api-vip"))
+		Expect(vips.IngressVips[0].hostname(mac)).Should(Equal("00-1a-4a-00-00-03-ingress"))
+	})
+
+	It("v2_settings_need_version", func() {
+		_, err := parseMonitorFile([]byte(`
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", ip-address: 172.99.0.55, vlan: 100}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03", ip-address: 172.99.0.56}
+`))
+		Expect(err).Should(MatchError(ContainSubstring("require version: 2")))
+	})
+
+	It("vlan_interface_names", func() {
+		Expect(vlanInterfaceName("eth1", 100)).Should(Equal("eth1.100"))
+		long := vlanInterfaceName("enp0s31f6np0", 4094)
+		Expect(len(long)).Should(BeNumerically("<=", 15))
+		Expect(long).ShouldNot(Equal(vlanInterfaceName("enp0s31f6np1", 4094)))
+		Expect(long).Should(Equal(vlanInterfaceName("enp0s31f6np0", 4094)))
+	})
+
+	It("invalid_vlan", func() {
+		_, err := parseMonitorFile([]byte(`
+version: 2
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", ip-address: 172.99.0.55, vlan: 4095}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03", ip-address: 172.99.0.56}
+`))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("unsupported_version", func() {
+		_, err := parseMonitorFile([]byte(`
+version: 3
+api-vips:
+- {name: api, mac-address: "00:1a:4a:00:00:01", ip-address: 172.99.0.55}
+ingress-vips:
+- {name: ingress, mac-address: "00:1a:4a:00:00:03", ip-address: 172.99.0.56}
+`))
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,248 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	dhcpReplyTimeout  = 4 * time.Second
+	dhcpSendAttempts  = 3
+	dhcpRetryInterval = 10 * time.Second
+	// dhcpMinLeaseTime is the lease time of the leases acknowledged
+	// without one or with a shorter one
+	dhcpMinLeaseTime = 2 * dhcpRetryInterval
+)
+
+// dhcpClient keeps a DHCP lease for a VIP macvlan without configuring the
+// leased address on it, which is keepalived's job. Every acknowledged lease is
+// appended to the lease file in the dhclient format.
+type dhcpClient struct {
+	log       logrus.FieldLogger
+	iface     *net.Interface
+	hostname  string
+	leaseFile string
+	client    *leaseClient
+}
+
+func startDHCPClient(log logrus.FieldLogger, iface *net.Interface, hostname, leaseFile string) {
+	startLeaseClient(leaseFile, func(client *leaseClient) {
+		c := &dhcpClient{
+			log:       log,
+			iface:     iface,
+			hostname:  hostname,
+			leaseFile: leaseFile,
+			client:    client,
+		}
+		if lease := c.run(); lease != nil && client.release {
+			c.release(lease)
+		}
+	})
+}
+
+// run keeps the lease up to date until the client is stopped or the
+// interface is removed, and returns the lease held at that point.
+func (c *dhcpClient) run() *dhcpLease {
+	var lease *dhcpLease
+	var requestedIP net.IP
+	if _, ip, err := GetLastLeaseFromFile(c.log, c.leaseFile); err == nil {
+		requestedIP = net.ParseIP(ip).To4()
+	}
+
+	for {
+		if _, err := net.InterfaceByName(c.iface.Name); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"interface": c.iface.Name,
+			}).WithError(err).Info("Interface is gone, stopping the DHCP client")
+			return nil
+		}
+
+		var newLease *dhcpLease
+		var err error
+		if lease == nil || time.Now().After(lease.Expire) {
+			newLease, err = c.acquire(requestedIP)
+		} else {
+			newLease, err = c.renew(lease)
+		}
+
+		if err != nil {
+			c.log.WithFields(logrus.Fields{
+				"interface": c.iface.Name,
+			}).WithError(err).Warn("Failed to get a DHCP lease")
+			if !waitOrStop(c.client.stop, dhcpRetryInterval) {
+				return lease
+			}
+			continue
+		}
+
+		lease = newLease
+		requestedIP = lease.FixedAddress
+		if err := appendLease(c.leaseFile, lease); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"filename": c.leaseFile,
+			}).WithError(err).Error("Failed to write lease file")
+		}
+		leaseAcquired(c.iface.Name, lease.FixedAddress.String(), lease.Expire)
+
+		if !waitOrStop(c.client.stop, time.Until(lease.Renew)) {
+			return lease
+		}
+	}
+}
+
+func (c *dhcpClient) acquire(requestedIP net.IP) (*dhcpLease, error) {
+	xid := rand.Uint32()
+	discover := newDHCPRequest(dhcpDiscover, xid, c.iface.HardwareAddr, c.hostname)
+	if requestedIP != nil {
+		discover.Options[dhcpOptRequestedIP] = requestedIP
+	}
+	offer, err := c.exchange(discover, dhcpOffer)
+	if err != nil {
+		return nil, err
+	}
+
+	request := newDHCPRequest(dhcpRequest, xid, c.iface.HardwareAddr, c.hostname)
+	request.Options[dhcpOptRequestedIP] = offer.Yiaddr.To4()
+	if serverID := offer.ipOption(dhcpOptServerID); serverID != nil {
+		request.Options[dhcpOptServerID] = serverID
+	}
+	return c.request(request)
+}
+
+// renew re-requests the leased address as a client in the INIT-REBOOT state
+// would. Since the address is never configured on the interface the
+// unicast replies of the RENEWING state could not be received.
+func (c *dhcpClient) renew(lease *dhcpLease) (*dhcpLease, error) {
+	request := newDHCPRequest(dhcpRequest, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	request.Options[dhcpOptRequestedIP] = lease.FixedAddress
+	return c.request(request)
+}
+
+func (c *dhcpClient) request(request *dhcpMessage) (*dhcpLease, error) {
+	ack, err := c.exchange(request, dhcpAck, dhcpNak)
+	if err != nil {
+		return nil, err
+	}
+	if ack.messageType() == dhcpNak {
+		return nil, fmt.Errorf("DHCP server %s declined the request for %s", ack.ipOption(dhcpOptServerID), net.IP(request.Options[dhcpOptRequestedIP]))
+	}
+	return newDHCPLease(c.iface.Name, ack, time.Now()), nil
+}
+
+// release gives the lease back to the server. DHCPRELEASE gets no reply, so
+// it is sent only once.
+func (c *dhcpClient) release(lease *dhcpLease) {
+	msg := newDHCPRequest(dhcpRelease, rand.Uint32(), c.iface.HardwareAddr, c.hostname)
+	msg.Flags = 0
+	msg.Ciaddr = lease.FixedAddress
+	delete(msg.Options, dhcpOptParamRequest)
+	server := net.IPv4bcast
+	if lease.ServerID != nil {
+		msg.Options[dhcpOptServerID] = lease.ServerID
+		server = lease.ServerID
+	}
+
+	err := c.withSocket(func(fd int, bcast *unix.SockaddrLinklayer) error {
+		return unix.Sendto(fd, buildDHCPPacket(msg.marshal(), lease.FixedAddress, server), 0, bcast)
+	})
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"interface": c.iface.Name,
+			"ip":        lease.FixedAddress,
+		}).WithError(err).Warn("Failed to release DHCP lease")
+		return
+	}
+	c.log.WithFields(logrus.Fields{
+		"interface": c.iface.Name,
+		"ip":        lease.FixedAddress,
+	}).Info("Released DHCP lease")
+}
+
+// withSocket runs f with a packet socket bound to the interface and the
+// link-layer broadcast address to send to.
+func (c *dhcpClient) withSocket(f func(fd int, bcast *unix.SockaddrLinklayer) error) error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(utils.Htons(unix.ETH_P_IP)))
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: utils.Htons(unix.ETH_P_IP),
+		Ifindex:  c.iface.Index,
+		Halen:    6,
+	}
+	if err := unix.Bind(fd, addr); err != nil {
+		return err
+	}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		return err
+	}
+
+	bcast := *addr
+	copy(bcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	return f(fd, &bcast)
+}
+
+// exchange broadcasts msg on the interface and waits for a reply of one of
+// the given types, resending it a few times if no reply arrives.
+func (c *dhcpClient) exchange(msg *dhcpMessage, replyTypes ...byte) (*dhcpMessage, error) {
+	var reply *dhcpMessage
+	err := c.withSocket(func(fd int, bcast *unix.SockaddrLinklayer) error {
+		var err error
+		reply, err = c.sendAndReceive(fd, bcast, msg, replyTypes)
+		return err
+	})
+	return reply, err
+}
+
+func (c *dhcpClient) sendAndReceive(fd int, bcast *unix.SockaddrLinklayer, msg *dhcpMessage, replyTypes []byte) (*dhcpMessage, error) {
+	packet := buildDHCPPacket(msg.marshal(), net.IPv4zero, net.IPv4bcast)
+	buf := make([]byte, 1500)
+
+	for attempt := 0; attempt < dhcpSendAttempts; attempt++ {
+		if err := unix.Sendto(fd, packet, 0, bcast); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(dhcpReplyTimeout)
+		for time.Now().Before(deadline) {
+			select {
+			case <-c.client.stop:
+				return nil, fmt.Errorf("DHCP client stopped")
+			default:
+			}
+
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			payload := extractDHCPPayload(buf[:n])
+			if payload == nil {
+				continue
+			}
+			reply, err := parseDHCPMessage(payload)
+			if err != nil || reply.Op != dhcpBootReply || reply.Xid != msg.Xid ||
+				reply.Chaddr.String() != c.iface.HardwareAddr.String() {
+				continue
+			}
+			for _, t := range replyTypes {
+				if reply.messageType() == t {
+					return reply, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("No DHCP reply on %s after %d attempts", c.iface.Name, dhcpSendAttempts)
+}
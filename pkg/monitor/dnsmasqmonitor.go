@@ -1,13 +1,16 @@
 package monitor
 
 import (
+	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"os/exec"
-	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
-	"time"
+
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
 	"github.com/openshift/baremetal-runtimecfg/pkg/render"
@@ -15,42 +18,82 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func DnsmasqWatch(kubeconfigPath, templatePath, cfgPath string, apiVips []net.IP, interval time.Duration) error {
-	signals := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
+// renderDnsmasqToTemp renders the dnsmasq host file to a temporary file and
+// returns its md5.
+func renderDnsmasqToTemp(templatePath string, files render.FileOptions, cfg config.Node) (string, error) {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	err = render.RenderFile(render.FileSpec{RenderPath: tmpFile.Name(), TemplatePath: templatePath, OverrideDir: files.OverrideDir}, cfg)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"config":  cfg,
+			"tmpFile": tmpFile.Name(),
+		}).Error("Failed to render dnsmasq host file")
+		return "", err
+	}
+	return utils.GetFileMd5(tmpFile.Name())
+}
+
+// DnsmasqWatch renders the dnsmasq host file from opts.TemplatePath into
+// opts.CfgPath and reloads dnsmasq when it changes
+func DnsmasqWatch(opts Options) error {
+	kubeconfigPath, templatePath, interval := opts.KubeconfigPath, opts.TemplatePath, opts.Interval
+	ctx, cancel := notifyContext()
+	defer cancel()
+	refresh, stopRefresh := notifyRefresh()
+	defer stopRefresh()
 	prevMD5 := ""
+	var prevLeases []config.StaticLease
+	var prevUpstreams []string
 
-	signal.Notify(signals, syscall.SIGTERM)
-	signal.Notify(signals, syscall.SIGINT)
-	go func() {
-		<-signals
-		done <- true
-	}()
+	serveMetrics(opts.MetricsAddress)
+	steady := newSteadyInterval("dnsmasq", interval, opts.Steady)
+	serveProbes(opts.ProbeAddress, "dnsmasq", opts.Steady.loopTimeout(interval))
 
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
 			return nil
 		default:
-			// We only care about the api vip and cluster domain here
-			config, err := config.GetConfig(kubeconfigPath, "", "/etc/resolv.conf", apiVips, apiVips, 0, 0, 0, config.ClusterLBConfig{})
+			probes.Beat("dnsmasq")
+			// We only care about the api vip, cluster domain and nodes here
+			newConfig, err := getConfig(opts, kubeconfigPath, "", "/etc/resolv.conf", opts.APIVIPs, opts.APIVIPs, 0, 0, 0, config.ClusterLBConfig{})
 			if err != nil {
 				return err
 			}
-			tmpFile, err := ioutil.TempFile("", "")
-			if err != nil {
-				return err
+			// dnsmasq forwards to the upstreams of resolv.conf itself, only
+			// count their changes
+			if prevUpstreams != nil && !cmp.Equal(prevUpstreams, newConfig.DNSUpstreams) {
+				dnsResolvConfChanges.WithLabelValues(dnsMonitorDnsmasq).Inc()
 			}
-			defer os.Remove(tmpFile.Name())
-			err = render.RenderFile(tmpFile.Name(), templatePath, config)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"config":  config,
-					"tmpFile": tmpFile.Name(),
-				}).Error("Failed to render dnsmasq host file")
-				return err
+			prevUpstreams = newConfig.DNSUpstreams
+
+			// Node records are best effort, a failure to list nodes should
+			// not remove the VIP records.
+			config.PopulateNodeAddresses(kubeconfigPath, &newConfig)
+			sort.SliceStable(newConfig.Cluster.NodeAddresses, func(i, j int) bool {
+				if newConfig.Cluster.NodeAddresses[i].Name == newConfig.Cluster.NodeAddresses[j].Name {
+					return newConfig.Cluster.NodeAddresses[i].Address < newConfig.Cluster.NodeAddresses[j].Address
+				}
+				return newConfig.Cluster.NodeAddresses[i].Name < newConfig.Cluster.NodeAddresses[j].Name
+			})
+
+			if opts.BMHNamespace != "" {
+				leases, err := config.GetStaticLeases(kubeconfigPath, opts.BMHNamespace)
+				if err != nil {
+					// Don't drop reservations because of a transient API error
+					log.WithError(err).Warn("Failed to get static leases, keeping previous ones")
+					leases = prevLeases
+				}
+				newConfig.DHCPStaticLeases = leases
+				prevLeases = leases
 			}
-			newMD5, err := utils.GetFileMd5(tmpFile.Name())
+
+			newMD5, err := renderDnsmasqToTemp(templatePath, opts.Render, newConfig)
 			if err != nil {
 				return err
 			}
@@ -58,29 +101,61 @@ func DnsmasqWatch(kubeconfigPath, templatePath, cfgPath string, apiVips []net.IP
 				"prevMD5": prevMD5,
 				"newMD5":  newMD5,
 			}).Info("Md5s")
-			if prevMD5 != newMD5 {
-				err = render.RenderFile(cfgPath, templatePath, config)
+			changed := prevMD5 != newMD5
+			if changed {
+				err = render.RenderFile(opts.Render.Apply(render.FileSpec{RenderPath: opts.CfgPath, TemplatePath: templatePath}), newConfig)
+				recordDNSRender(dnsMonitorDnsmasq, err, len(newConfig.Cluster.NodeAddresses))
 				if err != nil {
 					log.WithFields(logrus.Fields{
-						"config":  config,
-						"tmpFile": tmpFile.Name(),
+						"config": newConfig,
 					}).Error("Failed to render dnsmasq host file")
 					return err
 				}
 				prevMD5 = newMD5
-				err = ReloadDnsmasq()
+				err = ReloadDnsmasq(opts.DnsmasqPidFile)
 				if err != nil {
 					log.Error("Failed to reload dnsmasq configuration")
 					return err
 				}
 				log.Info("Reloaded dnsmasq")
 			}
-			time.Sleep(interval)
+			probes.SetReady(true)
+			if sleepOrRefresh(ctx, refresh, steady.next(changed)) {
+				// Render and reload even if the hosts did not change
+				prevMD5 = ""
+			}
 		}
 	}
 }
 
-func ReloadDnsmasq() error {
+// ReloadDnsmasq makes dnsmasq re-read its host files. If pidFile points to a
+// running dnsmasq it is sent SIGHUP, otherwise we fall back to clearing the
+// cache over DBus.
+func ReloadDnsmasq(pidFile string) error {
+	if pidFile != "" {
+		pid, err := readPidFile(pidFile)
+		if err == nil {
+			return syscall.Kill(pid, syscall.SIGHUP)
+		}
+		log.WithFields(logrus.Fields{
+			"pidFile": pidFile,
+		}).WithError(err).Warn("Could not read dnsmasq pid, falling back to DBus")
+	}
 	cmd := exec.Command("dbus-send", "--system", "--dest=uk.org.thekelleys.dnsmasq", "/uk/org/thekelleys/dnsmasq", "uk.org.thekelleys.ClearCache")
 	return cmd.Run()
 }
+
+func readPidFile(pidFile string) (int, error) {
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, err
+	}
+	if pid <= 0 {
+		return 0, fmt.Errorf("Invalid pid %d in %s", pid, pidFile)
+	}
+	return pid, nil
+}
@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readPidFile", func() {
+	var pidFile *os.File
+
+	BeforeEach(func() {
+		var err error
+		pidFile, err = os.CreateTemp("", "dnsmasq.pid")
+		Expect(err).ShouldNot(HaveOccurred())
+		pidFile.Close()
+	})
+
+	AfterEach(func() {
+		os.Remove(pidFile.Name())
+	})
+
+	It("reads a pid with a trailing newline", func() {
+		Expect(os.WriteFile(pidFile.Name(), []byte("1234\n"), 0644)).To(Succeed())
+		pid, err := readPidFile(pidFile.Name())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(pid).To(Equal(1234))
+	})
+
+	It("rejects invalid content", func() {
+		for _, content := range []string{"", "abc", "0", "-1"} {
+			Expect(os.WriteFile(pidFile.Name(), []byte(content), 0644)).To(Succeed())
+			_, err := readPidFile(pidFile.Name())
+			Expect(err).Should(HaveOccurred(), content)
+		}
+	})
+})
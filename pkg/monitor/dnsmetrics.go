@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	dnsMonitorCoredns = "coredns"
+	dnsMonitorDnsmasq = "dnsmasq"
+)
+
+var (
+	dnsRenders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_dns_renders_total",
+		Help: "Number of times the DNS monitor rendered its configuration",
+	}, []string{"monitor"})
+	dnsRenderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_dns_render_failures_total",
+		Help: "Number of times the DNS monitor failed to render its configuration",
+	}, []string{"monitor"})
+	dnsResolvConfChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_dns_resolv_conf_changes_total",
+		Help: "Number of resolv.conf changes seen by the DNS monitor",
+	}, []string{"monitor"})
+	dnsNodeAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_dns_node_addresses",
+		Help: "Number of node addresses in the last configuration of the DNS monitor",
+	}, []string{"monitor"})
+	dnsRenderAge = newRenderAgeCollector()
+)
+
+func init() {
+	prometheus.MustRegister(dnsRenders, dnsRenderFailures, dnsResolvConfChanges, dnsNodeAddresses, dnsRenderAge)
+}
+
+// renderAgeCollector exposes the time since the last successful render of
+// each DNS monitor, computed when scraped so it keeps growing while the
+// monitor is stuck
+type renderAgeCollector struct {
+	lock sync.Mutex
+	desc *prometheus.Desc
+	last map[string]time.Time
+
+	// swapped out by the tests
+	now func() time.Time
+}
+
+func newRenderAgeCollector() *renderAgeCollector {
+	return &renderAgeCollector{
+		desc: prometheus.NewDesc("baremetal_runtimecfg_dns_seconds_since_last_render",
+			"Seconds since the DNS monitor last rendered its configuration successfully", []string{"monitor"}, nil),
+		last: map[string]time.Time{},
+		now:  time.Now,
+	}
+}
+
+func (c *renderAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *renderAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for monitor, last := range c.last {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, c.now().Sub(last).Seconds(), monitor)
+	}
+}
+
+func (c *renderAgeCollector) rendered(monitor string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.last[monitor] = c.now()
+}
+
+// recordDNSRender counts a render of monitor with nodeAddresses node
+// addresses, failed when err is not nil
+func recordDNSRender(monitor string, err error, nodeAddresses int) {
+	dnsRenders.WithLabelValues(monitor).Inc()
+	if err != nil {
+		dnsRenderFailures.WithLabelValues(monitor).Inc()
+		return
+	}
+	dnsNodeAddresses.WithLabelValues(monitor).Set(float64(nodeAddresses))
+	dnsRenderAge.rendered(monitor)
+}
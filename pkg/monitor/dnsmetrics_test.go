@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("dns_metrics", func() {
+	It("records_renders", func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		dnsRenderAge.now = func() time.Time { return now }
+		defer func() { dnsRenderAge.now = time.Now }()
+		renders := metricValue(dnsRenders.WithLabelValues(dnsMonitorDnsmasq))
+		failures := metricValue(dnsRenderFailures.WithLabelValues(dnsMonitorDnsmasq))
+
+		recordDNSRender(dnsMonitorDnsmasq, nil, 3)
+		now = now.Add(90 * time.Second)
+		recordDNSRender(dnsMonitorDnsmasq, errors.New("template: bad"), 0)
+
+		Expect(metricValue(dnsRenders.WithLabelValues(dnsMonitorDnsmasq))).Should(Equal(renders + 2))
+		Expect(metricValue(dnsRenderFailures.WithLabelValues(dnsMonitorDnsmasq))).Should(Equal(failures + 1))
+		Expect(metricValue(dnsNodeAddresses.WithLabelValues(dnsMonitorDnsmasq))).Should(Equal(3.0))
+
+		ch := make(chan prometheus.Metric, 10)
+		dnsRenderAge.Collect(ch)
+		close(ch)
+		Expect(ch).Should(HaveLen(1))
+		Expect(metricValue(<-ch)).Should(Equal(90.0))
+	})
+})
@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// drainPollInterval is how often the sessions of the drained backends are
+// read, swapped out by the tests
+var drainPollInterval = time.Second
+
+// queryControlSocket writes command to the socket at path on a connection of
+// its own and returns the response, which the HAProxy master ends by closing
+// the connection. Swapped out by the tests.
+var queryControlSocket = func(ctx context.Context, path, command string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(controlSocketWriteTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+	response, err := ioutil.ReadAll(conn)
+	return string(response), err
+}
+
+// removedBackends returns the servers of applied that are not in cur
+func removedBackends(applied, cur []config.Backend) []string {
+	kept := map[string]bool{}
+	for _, b := range cur {
+		kept[b.Host] = true
+	}
+	removed := []string{}
+	for _, b := range applied {
+		if !kept[b.Host] {
+			removed = append(removed, b.Host)
+		}
+	}
+	return removed
+}
+
+// drainCommand returns the command of the master socket draining server in
+// the current HAProxy worker: it takes no new connection and keeps the
+// established ones
+func drainCommand(server string) string {
+	return fmt.Sprintf("@1 set server %s/%s state drain", haproxyAPIBackend, server)
+}
+
+// serverSessions returns the current sessions of servers of the API backend
+// in the CSV of show stat. The servers missing from it have none.
+func serverSessions(stat string, servers []string) (int, error) {
+	draining := map[string]bool{}
+	for _, s := range servers {
+		draining[s] = true
+	}
+	columns := map[string]int{}
+	sessions := 0
+	for _, line := range strings.Split(stat, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			for i, name := range strings.Split(strings.TrimPrefix(line, "# "), ",") {
+				columns[name] = i
+			}
+			continue
+		}
+		fields := strings.Split(line, ",")
+		pxname, okPx := columns["pxname"]
+		svname, okSv := columns["svname"]
+		scur, okCur := columns["scur"]
+		if !okPx || !okSv || !okCur {
+			continue
+		}
+		if len(fields) <= scur || len(fields) <= svname || fields[pxname] != haproxyAPIBackend || !draining[fields[svname]] {
+			continue
+		}
+		n, err := strconv.Atoi(fields[scur])
+		if err != nil {
+			return 0, fmt.Errorf("Invalid sessions %q of server %s", fields[scur], fields[svname])
+		}
+		sessions += n
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("No header in the HAProxy stats")
+	}
+	return sessions, nil
+}
+
+// backendDrainer drains the API backends about to be removed through the
+// runtime API of the current HAProxy worker. Each command goes on a
+// connection of its own to the master socket at path, which closes the
+// connection after answering.
+type backendDrainer struct {
+	path string
+	// timeout is how long the backends are drained at most
+	timeout time.Duration
+	// threshold is the number of sessions the drain ends at
+	threshold int
+}
+
+// sessions returns the current sessions of servers
+func (d *backendDrainer) sessions(ctx context.Context, servers []string) (int, error) {
+	stat, err := queryControlSocket(ctx, d.path, "@1 show stat")
+	if err != nil {
+		return 0, err
+	}
+	return serverSessions(stat, servers)
+}
+
+// drain sets servers to the drain state and waits for their sessions to fall
+// to d.threshold, for d.timeout at most. The reload goes on
+// whatever the outcome, draining only reduces the dropped requests.
+func (d *backendDrainer) drain(ctx context.Context, servers []string) {
+	for _, server := range servers {
+		response, err := queryControlSocket(ctx, d.path, drainCommand(server))
+		if err == nil && strings.TrimSpace(response) != "" {
+			err = fmt.Errorf("HAProxy answered %q", strings.TrimSpace(response))
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"server": server,
+			}).WithError(err).Error("Failed to drain the removed API backend")
+			return
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"servers": servers,
+		"timeout": d.timeout,
+	}).Info("Draining the removed API backends")
+
+	deadline := time.Now().Add(d.timeout)
+	for {
+		sessions, err := d.sessions(ctx, servers)
+		if err != nil {
+			log.WithError(err).Warn("Failed to read the sessions of the drained API backends")
+		} else if sessions <= d.threshold {
+			log.WithFields(logrus.Fields{
+				"servers":  servers,
+				"sessions": sessions,
+			}).Info("Drained the removed API backends")
+			return
+		}
+		if !time.Now().Before(deadline) {
+			log.WithFields(logrus.Fields{
+				"servers":  servers,
+				"sessions": sessions,
+			}).Warn("Timed out draining the removed API backends, reloading anyway")
+			return
+		}
+		if !sleep(ctx, drainPollInterval) {
+			return
+		}
+	}
+}
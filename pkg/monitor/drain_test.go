@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("drain", func() {
+	var commands chan string
+	var d *backendDrainer
+	prevPoll, prevQuery := drainPollInterval, queryControlSocket
+
+	stat := func(sessions string) string {
+		return "# pxname,svname,qcur,qmax,scur,smax\n" +
+			"main,FRONTEND,,,12,40\n" +
+			"masters,master-0,0,0,3,10\n" +
+			"masters,master-1,0,0," + sessions + ",10\n" +
+			"masters,BACKEND,0,0,9,20\n"
+	}
+
+	BeforeEach(func() {
+		commands = make(chan string, 10)
+		d = &backendDrainer{path: "/test.sock"}
+		drainPollInterval = 10 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		drainPollInterval, queryControlSocket = prevPoll, prevQuery
+	})
+
+	It("finds_the_removed_backends", func() {
+		applied := []config.Backend{{Host: "master-0"}, {Host: "master-1"}, {Host: "master-2"}}
+		Expect(removedBackends(applied, []config.Backend{{Host: "master-0"}, {Host: "master-2"}, {Host: "master-3"}})).To(Equal([]string{"master-1"}))
+		Expect(removedBackends(applied, applied)).To(BeEmpty())
+	})
+
+	It("counts_the_sessions_of_the_drained_servers", func() {
+		Expect(serverSessions(stat("6"), []string{"master-1"})).To(Equal(6))
+		Expect(serverSessions(stat("6"), []string{"master-0", "master-1", "master-9"})).To(Equal(9))
+		_, err := serverSessions(stat("many"), []string{"master-1"})
+		Expect(err).To(HaveOccurred())
+		_, err = serverSessions("Unknown command\n", []string{"master-1"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("waits_for_the_sessions_to_drain", func() {
+		d.timeout = time.Minute
+		d.threshold = 1
+		polls := 0
+		queryControlSocket = func(_ context.Context, path, command string) (string, error) {
+			Expect(path).To(Equal("/test.sock"))
+			if command != "@1 show stat" {
+				commands <- command
+				return "\n", nil
+			}
+			polls++
+			return stat([]string{"5", "2", "1"}[min(polls-1, 2)]), nil
+		}
+
+		d.drain(context.Background(), []string{"master-1"})
+		Expect(commands).To(Receive(Equal("@1 set server masters/master-1 state drain")))
+		Expect(polls).To(Equal(3))
+	})
+
+	It("stops_when_haproxy_rejects_the_drain", func() {
+		d.timeout = time.Minute
+		polls := 0
+		queryControlSocket = func(_ context.Context, _, command string) (string, error) {
+			if command == "@1 show stat" {
+				polls++
+			}
+			return "No such server.\n", nil
+		}
+
+		d.drain(context.Background(), []string{"master-1"})
+		Expect(polls).To(Equal(0))
+	})
+
+	It("gives_up_after_the_timeout", func() {
+		d.timeout = 50 * time.Millisecond
+		queryControlSocket = func(_ context.Context, _, command string) (string, error) {
+			if command != "@1 show stat" {
+				return "", nil
+			}
+			return stat("5"), nil
+		}
+
+		start := time.Now()
+		d.drain(context.Background(), []string{"master-1"})
+		Expect(time.Since(start)).To(BeNumerically(">=", d.timeout))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
@@ -0,0 +1,234 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+const (
+	// driftModified is a configuration file whose content differs from the
+	// one the monitor rendered, e.g. after a hand edit
+	driftModified = "modified"
+	// driftNotLoaded is a configuration file written after the process last
+	// loaded its configuration
+	driftNotLoaded = "not_loaded"
+)
+
+var driftReasons = []string{driftModified, driftNotLoaded}
+
+var (
+	configDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_config_drift",
+		Help: "1 while the configuration file of the component drifted from the one the monitor rendered and its process loaded",
+	}, []string{"component", "reason"})
+	configDriftDetections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_config_drift_detections_total",
+		Help: "Number of times the configuration file of the component was found drifted",
+	}, []string{"component", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(configDrift, configDriftDetections)
+}
+
+// userHZ is the unit of the process start time in /proc/<pid>/stat
+const userHZ = 100
+
+// swapped out by the tests
+var procDir = "/proc"
+
+// processStartTime returns when the process whose pid is in pidFile started
+func processStartTime(pidFile string) (time.Time, error) {
+	pidData, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidData)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid pid file %s: %w", pidFile, err)
+	}
+	stat, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The command name may hold spaces, the fields after it start with the
+	// state, the third field of the line, and the start time is the 22nd
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("Invalid stat of process %d", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid start time of process %d: %w", pid, err)
+	}
+	procStat, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(procStat), "\n") {
+		if btime := strings.TrimPrefix(line, "btime "); btime != line {
+			boot, err := strconv.ParseInt(strings.TrimSpace(btime), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("Invalid boot time: %w", err)
+			}
+			return time.Unix(boot, 0).Add(time.Duration(ticks) * time.Second / userHZ), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("No boot time in %s", filepath.Join(procDir, "stat"))
+}
+
+// driftChecker compares a configuration file with the content the monitor
+// last rendered into it and, with a pid file, its modification time with
+// the last time the process loaded a configuration. The methods of a nil
+// checker do nothing, so the monitors call them whether drift detection is
+// enabled or not.
+type driftChecker struct {
+	component string
+	path      string
+	// pidFile holds the pid of the process loading path, its start time is
+	// not checked when empty
+	pidFile string
+
+	// generation is the GenerationID of the content the monitor last
+	// rendered, empty until then
+	generation string
+	// loadedAt is when the monitor last reloaded the process, zero until
+	// then
+	loadedAt time.Time
+	drifted  map[string]bool
+
+	// swapped out by the tests
+	startTime func(pidFile string) (time.Time, error)
+	now       func() time.Time
+}
+
+// newDriftChecker returns the checker of path, nil without
+// opts.DetectConfigDrift
+func newDriftChecker(opts Options, component, path, pidFile string) *driftChecker {
+	if !opts.DetectConfigDrift {
+		return nil
+	}
+	return &driftChecker{
+		component: component,
+		path:      path,
+		pidFile:   pidFile,
+		drifted:   map[string]bool{},
+		startTime: processStartTime,
+		now:       time.Now,
+	}
+}
+
+// rendered records the content the monitor just rendered into the file, and
+// that the process loaded it when reloaded
+func (d *driftChecker) rendered(reloaded bool) {
+	if d == nil {
+		return
+	}
+	content, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path": d.path,
+		}).WithError(err).Debug("Failed to read the rendered configuration")
+		return
+	}
+	d.generation = render.GenerationID(content)
+	if reloaded {
+		d.loadedAt = d.now()
+	}
+}
+
+// check logs and publishes the drift of the file, and returns whether it
+// drifted and the monitor should render it again
+func (d *driftChecker) check() bool {
+	if d == nil {
+		return false
+	}
+	drifted := map[string]bool{}
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path": d.path,
+		}).WithError(err).Debug("Failed to stat the rendered configuration")
+		return false
+	}
+	if modified, err := d.modified(); err != nil {
+		log.WithFields(logrus.Fields{
+			"path": d.path,
+		}).WithError(err).Debug("Failed to read the rendered configuration")
+	} else {
+		drifted[driftModified] = modified
+	}
+	// Only a reload by the monitor tells when the process loaded the
+	// configuration after it started
+	if d.pidFile != "" && !d.loadedAt.IsZero() {
+		started, err := d.startTime(d.pidFile)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"pidFile": d.pidFile,
+			}).WithError(err).Debug("Failed to get the process start time")
+		} else {
+			loaded := d.loadedAt
+			if started.After(loaded) {
+				loaded = started
+			}
+			drifted[driftNotLoaded] = fi.ModTime().After(loaded)
+		}
+	}
+
+	found := false
+	for _, reason := range driftReasons {
+		if drifted[reason] && !d.drifted[reason] {
+			log.WithFields(logrus.Fields{
+				"component": d.component,
+				"path":      d.path,
+				"reason":    reason,
+			}).Warn("Configuration drift detected")
+			configDriftDetections.WithLabelValues(d.component, reason).Inc()
+		} else if !drifted[reason] && d.drifted[reason] {
+			log.WithFields(logrus.Fields{
+				"component": d.component,
+				"path":      d.path,
+				"reason":    reason,
+			}).Info("Configuration drift resolved")
+		}
+		value := 0.0
+		if drifted[reason] {
+			value = 1
+			found = true
+		}
+		configDrift.WithLabelValues(d.component, reason).Set(value)
+	}
+	d.drifted = drifted
+	return found
+}
+
+// modified returns whether the content of the file differs from the one the
+// monitor rendered. Before the monitor renders, a file stamped with its
+// generation is compared with its stamp.
+func (d *driftChecker) modified() (bool, error) {
+	content, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return false, err
+	}
+	if d.generation != "" {
+		return render.GenerationID(content) != d.generation, nil
+	}
+	stamped, err := render.ReadGenerationID(d.path)
+	if err != nil || stamped == "" {
+		return false, err
+	}
+	if i := strings.IndexByte(string(content), '\n'); i >= 0 {
+		content = content[i+1:]
+	}
+	return render.GenerationID(content) != stamped, nil
+}
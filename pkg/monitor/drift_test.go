@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("config_drift", func() {
+	var dir, cfgPath string
+	var d *driftChecker
+	var started time.Time
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "drift")
+		Expect(err).NotTo(HaveOccurred())
+		cfgPath = filepath.Join(dir, "haproxy.cfg")
+		Expect(ioutil.WriteFile(cfgPath, []byte("global\n"), 0644)).To(Succeed())
+
+		d = newDriftChecker(Options{DetectConfigDrift: true}, "haproxy", cfgPath, filepath.Join(dir, "haproxy.pid"))
+		started = time.Now().Add(-time.Hour)
+		d.startTime = func(string) (time.Time, error) { return started, nil }
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("is_disabled_by_default", func() {
+		d = newDriftChecker(DefaultOptions(), "haproxy", cfgPath, "")
+		Expect(d).To(BeNil())
+		d.rendered(true)
+		Expect(d.check()).To(BeFalse())
+	})
+
+	It("detects_a_hand_edit", func() {
+		d.rendered(true)
+		Expect(d.check()).To(BeFalse())
+		before := metricValue(configDriftDetections.WithLabelValues("haproxy", driftModified))
+
+		Expect(ioutil.WriteFile(cfgPath, []byte("global\n  maxconn 1\n"), 0644)).To(Succeed())
+		Expect(d.check()).To(BeTrue())
+		Expect(metricValue(configDrift.WithLabelValues("haproxy", driftModified))).To(Equal(1.0))
+		Expect(metricValue(configDriftDetections.WithLabelValues("haproxy", driftModified))).To(Equal(before + 1))
+
+		By("counting_the_drift_once", func() {
+			Expect(d.check()).To(BeTrue())
+			Expect(metricValue(configDriftDetections.WithLabelValues("haproxy", driftModified))).To(Equal(before + 1))
+		})
+
+		By("resolving_on_the_next_render", func() {
+			d.rendered(true)
+			Expect(d.check()).To(BeFalse())
+			Expect(metricValue(configDrift.WithLabelValues("haproxy", driftModified))).To(Equal(0.0))
+		})
+	})
+
+	It("compares_a_stamped_file_with_its_generation", func() {
+		stamped := "# runtimecfg-generation: 0123456789ab\nvrrp_instance\n"
+		Expect(ioutil.WriteFile(cfgPath, []byte(stamped), 0644)).To(Succeed())
+		Expect(d.check()).To(BeTrue())
+	})
+
+	It("detects_a_file_the_process_did_not_load", func() {
+		d.rendered(true)
+		Expect(d.check()).To(BeFalse())
+
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(cfgPath, later, later)).To(Succeed())
+		Expect(d.check()).To(BeTrue())
+		Expect(d.drifted).To(Equal(map[string]bool{driftModified: false, driftNotLoaded: true}))
+
+		By("resolving_once_the_process_restarts", func() {
+			started = later.Add(time.Second)
+			Expect(d.check()).To(BeFalse())
+		})
+	})
+
+	It("reads_the_process_start_time", func() {
+		procDir = dir
+		defer func() { procDir = "/proc" }()
+		Expect(os.MkdirAll(filepath.Join(dir, "42"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "haproxy.pid"), []byte("42\n"), 0644)).To(Succeed())
+		stat := "42 (haproxy master) S 1 42 42 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 250 0 0\n"
+		Expect(ioutil.WriteFile(filepath.Join(dir, "42", "stat"), []byte(stat), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "stat"), []byte("cpu 1 2 3\nbtime 1700000000\n"), 0644)).To(Succeed())
+
+		start, err := processStartTime(filepath.Join(dir, "haproxy.pid"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(start).To(Equal(time.Unix(1700000002, 500000000)))
+	})
+})
@@ -1,21 +1,27 @@
 package monitor
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"gopkg.in/yaml.v2"
-
 	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/baremetal-runtimecfg/pkg/alerts"
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/events"
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
+	"github.com/openshift/baremetal-runtimecfg/pkg/peers"
 	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -58,19 +64,19 @@ func getActualMode(cfgPath string) (error, bool) {
 	return nil, enableUnicast
 }
 
-func updateUnicastConfig(kubeconfigPath string, newConfig *config.Node) error {
+func updateUnicastConfig(opts config.Options, kubeconfigPath string, peerCIDRs []net.IPNet, newConfig *config.Node) error {
 	var err error
 
 	if !newConfig.EnableUnicast {
 		return err
 	}
-	newConfig.IngressConfig, err = config.GetIngressConfig(kubeconfigPath, []string{newConfig.Cluster.APIVIP, newConfig.Cluster.IngressVIP})
+	newConfig.IngressConfig, err = config.GetIngressConfig(opts, kubeconfigPath, []string{newConfig.Cluster.APIVIP, newConfig.Cluster.IngressVIP})
 	if err != nil {
 		log.Warnf("Could not retrieve ingress config: %v", err)
 		return err
 	}
 
-	newConfig.LBConfig, err = config.GetLBConfig(kubeconfigPath, dummyPortNum, dummyPortNum, dummyPortNum, []net.IP{net.ParseIP(newConfig.Cluster.APIVIP), net.ParseIP(newConfig.Cluster.IngressVIP)})
+	newConfig.LBConfig, err = config.GetLBConfig(opts, kubeconfigPath, dummyPortNum, dummyPortNum, dummyPortNum, []net.IP{net.ParseIP(newConfig.Cluster.APIVIP), net.ParseIP(newConfig.Cluster.IngressVIP)})
 	if err != nil {
 		log.Warnf("Could not retrieve LB config: %v", err)
 		return err
@@ -78,28 +84,29 @@ func updateUnicastConfig(kubeconfigPath string, newConfig *config.Node) error {
 
 	for i, c := range *newConfig.Configs {
 		// Must do this by index instead of using c because c is local to this loop
-		(*newConfig.Configs)[i].IngressConfig, err = config.GetIngressConfig(kubeconfigPath, []string{c.Cluster.APIVIP, c.Cluster.IngressVIP})
+		(*newConfig.Configs)[i].IngressConfig, err = config.GetIngressConfig(opts, kubeconfigPath, []string{c.Cluster.APIVIP, c.Cluster.IngressVIP})
 		if err != nil {
 			log.Warnf("Could not retrieve ingress config: %v", err)
 			return err
 		}
-		(*newConfig.Configs)[i].LBConfig, err = config.GetLBConfig(kubeconfigPath, dummyPortNum, dummyPortNum, dummyPortNum, []net.IP{net.ParseIP(c.Cluster.APIVIP), net.ParseIP(c.Cluster.IngressVIP)})
+		(*newConfig.Configs)[i].LBConfig, err = config.GetLBConfig(opts, kubeconfigPath, dummyPortNum, dummyPortNum, dummyPortNum, []net.IP{net.ParseIP(c.Cluster.APIVIP), net.ParseIP(c.Cluster.IngressVIP)})
 		if err != nil {
 			log.Warnf("Could not retrieve LB config: %v", err)
 			return err
 		}
 	}
+	filterUnicastPeers(newConfig, peerCIDRs)
 	return nil
 }
 
-func doesConfigChanged(curConfig, appliedConfig *config.Node) bool {
+func doesConfigChanged(curConfig, appliedConfig *config.Node, view peers.View, nodeName string) bool {
 	validConfig := true
 	cfgChanged := appliedConfig == nil || !cmp.Equal(*appliedConfig, *curConfig)
-	// In unicast mode etcd is used for sync purpose between bootstrap and the masters nodes,
-	// we want to apply new config to master nodes only after nodes appears in etcd, with this
-	// approach we should avoid asymetric configuration
+	// In unicast mode the masters only apply a new config once they have
+	// peers that announced themselves, which avoids asymmetric
+	// configurations while the cluster forms
 	if curConfig.EnableUnicast {
-		if os.Getenv("IS_BOOTSTRAP") == "no" && len(curConfig.LBConfig.Backends) < 2 {
+		if os.Getenv("IS_BOOTSTRAP") == "no" && !unicastPeersReady(curConfig, view, nodeName) {
 			validConfig = false
 		}
 	}
@@ -107,32 +114,49 @@ func doesConfigChanged(curConfig, appliedConfig *config.Node) bool {
 }
 
 type modeUpdateInfo struct {
-	Mode string
-	Time time.Time
+	// Version is the schema version of the mode files, see
+	// modeUpdateVersion
+	Version int       `yaml:"version,omitempty"`
+	Mode    string    `yaml:"mode"`
+	Time    time.Time `yaml:"time,omitempty"`
+	// Epoch is the coordinated migration the update belongs to, 0 for the
+	// mode files
+	Epoch int64 `json:"-" yaml:"-"`
+
+	// source and checksum are the mode file the update was read from and
+	// the checksum of its content, empty for the other updates
+	source   string
+	checksum string
 }
 
-func isModeUpdateNeeded(cfgPath string) (bool, modeUpdateInfo) {
+// isModeUpdateNeeded compares the keepalived mode of cfgPath with the
+// desired one: the unicast override of the node when set, else the mode of
+// userModeUpdateFilepath or modeUpdateFilepath.
+func isModeUpdateNeeded(cfgPath string, unicastOverride *bool) (bool, modeUpdateInfo) {
 	enableUnicast := false
 	updateRequired := false
 	desiredModeInfo := modeUpdateInfo{}
 	filePath := userModeUpdateFilepath
 
-	// userModeUpdateFilepath has higher priority than modeUpdateFilepath
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		filePath = modeUpdateFilepath
+	if unicastOverride != nil {
+		desiredModeInfo.Mode = "multicast"
+		if *unicastOverride {
+			desiredModeInfo.Mode = "unicast"
+		}
+	} else {
+		// userModeUpdateFilepath has higher priority than modeUpdateFilepath
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			return updateRequired, desiredModeInfo
+			filePath = modeUpdateFilepath
+			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+				return updateRequired, desiredModeInfo
+			}
 		}
-	}
 
-	yamlFile, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		log.Warnf("Could not ReadFile %s", filePath)
-		return updateRequired, desiredModeInfo
-	}
-	if err = yaml.Unmarshal(yamlFile, &desiredModeInfo); err != nil {
-		log.Warnf("Could not parse file content %s", yamlFile)
-		return updateRequired, desiredModeInfo
+		var err error
+		if desiredModeInfo, err = readModeUpdate(filePath); err != nil {
+			log.WithError(err).Warn("Ignoring the mode update file")
+			return updateRequired, modeUpdateInfo{}
+		}
 	}
 	if desiredModeInfo.Mode == "unicast" {
 		enableUnicast = true
@@ -140,11 +164,14 @@ func isModeUpdateNeeded(cfgPath string) (bool, modeUpdateInfo) {
 	err, curEnableUnicast := getActualMode(cfgPath)
 	if err == nil && curEnableUnicast != enableUnicast {
 		updateRequired = true
+		setModeUpdateStatus(desiredModeInfo, modeUpdateAccepted, "")
+	} else if err == nil {
+		setModeUpdateStatus(desiredModeInfo, modeUpdateApplied, "")
 	}
 	return updateRequired, desiredModeInfo
 }
 
-func handleBootstrapStopKeepalived(kubeconfigPath string, bootstrapStopKeepalived chan APIState) {
+func handleBootstrapStopKeepalived(ctx context.Context, opts config.Options, kubeconfigPath string, bootstrapStopKeepalived chan APIState) {
 	consecutiveErr := 0
 
 	/* It should take up to ~20 seconds for the local kube-apiserver to start running on the
@@ -154,16 +181,18 @@ func handleBootstrapStopKeepalived(kubeconfigPath string, bootstrapStopKeepalive
 	*/
 	log.Info("handleBootstrapStopKeepalived: verify first that local kube-apiserver is operational")
 	for start := time.Now(); time.Since(start) < time.Minute*30; {
-		if _, err := config.GetIngressConfig(kubeconfigPath, []string{}); err == nil {
+		if _, err := config.GetIngressConfig(opts, kubeconfigPath, []string{}); err == nil {
 			log.Info("handleBootstrapStopKeepalived: local kube-apiserver is operational")
 			break
 		}
 		log.Info("handleBootstrapStopKeepalived: local kube-apiserver still not operational")
-		time.Sleep(3 * time.Second)
+		if !sleep(ctx, 3*time.Second) {
+			return
+		}
 	}
 
 	for {
-		if _, err := config.GetIngressConfig(kubeconfigPath, []string{}); err != nil {
+		if _, err := config.GetIngressConfig(opts, kubeconfigPath, []string{}); err != nil {
 			// We have started to talk to Ironic through the API VIP as well,
 			// so if Ironic is still up then we need to keep the VIP, even if
 			// the apiserver has gone down.
@@ -175,7 +204,9 @@ func handleBootstrapStopKeepalived(kubeconfigPath string, bootstrapStopKeepalive
 			}
 		} else {
 			if consecutiveErr > bootstrapApiFailuresThreshold { // Means it was stopped
-				bootstrapStopKeepalived <- started
+				if !sendAPIState(ctx, bootstrapStopKeepalived, started) {
+					return
+				}
 			}
 			consecutiveErr = 0
 		}
@@ -184,27 +215,75 @@ func handleBootstrapStopKeepalived(kubeconfigPath string, bootstrapStopKeepalive
 				"consecutiveErr":                consecutiveErr,
 				"bootstrapApiFailuresThreshold": bootstrapApiFailuresThreshold,
 			}).Info("handleBootstrapStopKeepalived: Num of failures exceeds threshold")
-			bootstrapStopKeepalived <- stopped
+			if !sendAPIState(ctx, bootstrapStopKeepalived, stopped) {
+				return
+			}
 		}
-		time.Sleep(1 * time.Second)
+		if !sleep(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+// sendAPIState returns false when ctx is cancelled before the main loop
+// received the state
+func sendAPIState(ctx context.Context, ch chan APIState, state APIState) bool {
+	select {
+	case ch <- state:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
-func handleConfigModeUpdate(cfgPath string, kubeconfigPath string, updateModeCh chan modeUpdateInfo) {
+// sendModeUpdate returns false when ctx is cancelled before the main loop
+// received the update
+func sendModeUpdate(ctx context.Context, ch chan modeUpdateInfo, update modeUpdateInfo) bool {
+	select {
+	case ch <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func handleConfigModeUpdate(ctx context.Context, opts Options, updateModeCh chan modeUpdateInfo) {
+	cfgPath, kubeconfigPath := opts.CfgPath, opts.KubeconfigPath
+	overrides := &nodeOverrides{kubeconfigPath: kubeconfigPath, nodeName: opts.NodeName}
+	migration := &modeMigration{kubeconfigPath: kubeconfigPath, nodeName: opts.NodeName}
+	coordinated := time.NewTicker(modeMigrationPollInterval)
+	defer coordinated.Stop()
 
 	// create Ticker that will run every round modeUpdateIntervalInSec
 	nextTickTime := time.Now().Add((modeUpdateIntervalInSec / 2) * time.Second).Round(modeUpdateIntervalInSec * time.Second)
 	// The first tick happens on nextTickTime, then we reset to the regular interval
 	ticker := time.NewTicker(time.Until(nextTickTime))
 	defer ticker.Stop()
+	// Sending an update blocks while the main loop waits for the planned
+	// time of the previous one
+	probes.Register("keepalived-mode", loopTimeout(modeMigrationPollInterval)+(modeUpdateIntervalInSec/2)*time.Second)
 
 	for {
+		probes.Beat("keepalived-mode")
 
 		select {
+		case <-ctx.Done():
+			return
+
+		case <-coordinated.C:
+			if update := migration.step(); update != nil {
+				if !sendModeUpdate(ctx, updateModeCh, *update) {
+					return
+				}
+			}
+
 		case tickerTime := <-ticker.C:
 
 			ticker.Reset(modeUpdateIntervalInSec * time.Second)
-			updateRequired, desiredModeInfo := isModeUpdateNeeded(cfgPath)
+			if migration.inProgress {
+				continue
+			}
+			updateRequired, desiredModeInfo := isModeUpdateNeeded(cfgPath, overrides.get().Unicast)
 			if !updateRequired {
 				continue
 			}
@@ -231,13 +310,22 @@ func handleConfigModeUpdate(cfgPath string, kubeconfigPath string, updateModeCh
 
 			timeoutInSec := time.Duration((time.Until(desiredModeInfo.Time).Seconds() - (float64)(processingTimeInSec)))
 			// sleep until processingTimeInSec seconds before planned time
-			time.Sleep(timeoutInSec * time.Second)
-			updateModeCh <- desiredModeInfo
+			if !sleep(ctx, timeoutInSec*time.Second) || !sendModeUpdate(ctx, updateModeCh, desiredModeInfo) {
+				return
+			}
 		}
 	}
 }
 
-func handleLeasing(cfgPath string, apiVips, ingressVips []net.IP) error {
+func handleLeasing(opts config.Options, cfgPath string, apiVips, ingressVips []net.IP) error {
+	reapDhclients(log, cfgPath)
+	return reconcileLeasedVIPs(opts, cfgPath, apiVips, ingressVips)
+}
+
+// reconcileLeasedVIPs leases the VIPs of the monitor configuration that have
+// no lease client running, and releases the leases and deletes the macvlans
+// of the VIPs that are no longer in it
+func reconcileLeasedVIPs(opts config.Options, cfgPath string, apiVips, ingressVips []net.IP) error {
 	vips, err := getVipsToLease(cfgPath)
 
 	if err != nil {
@@ -245,8 +333,13 @@ func handleLeasing(cfgPath string, apiVips, ingressVips []net.IP) error {
 	}
 
 	if vips == nil {
+		cleanupStaleLeases(log, cfgPath, nil)
 		return nil
 	}
+	// The monitor configuration is the desired state of the leases, the VIPs
+	// removed from it are cleaned up even when it no longer matches the VIPs
+	// of the command line
+	cleanupStaleLeases(log, cfgPath, append(append([]vip{}, vips.APIVips...), vips.IngressVips...))
 
 	if len(apiVips) != len(vips.APIVips) {
 		return fmt.Errorf("Mismatched number of API VIPs. Expected: %d Actual: %d", len(apiVips), len(vips.APIVips))
@@ -267,22 +360,48 @@ func handleLeasing(cfgPath string, apiVips, ingressVips []net.IP) error {
 		}
 	}
 
-	for i := 0; i < len(apiVips); i++ {
-		vipIface, _, err := config.GetVRRPConfig(apiVips[i], ingressVips[i])
-		if err != nil {
-			return err
+	// Each VIP is leased on its own interface, the VIPs of a pair are not
+	// necessarily in the same machine network
+	vipIfaces, err := config.GetVRRPInterfaces(opts, append(append([]net.IP{}, apiVips...), ingressVips...))
+	if err != nil {
+		return err
+	}
+	toLease := append(append([]vip{}, vips.APIVips...), vips.IngressVips...)
+	byIface := map[string][]vip{}
+	ifaceNames := []string{}
+	for _, vipIface := range vipIfaces {
+		for _, v := range toLease {
+			if v.IpAddress != vipIface.VIP.String() {
+				continue
+			}
+			if isLeaseClientRunning(v.leaseFile(cfgPath)) {
+				break
+			}
+			if _, ok := byIface[vipIface.Interface.Name]; !ok {
+				ifaceNames = append(ifaceNames, vipIface.Interface.Name)
+			}
+			byIface[vipIface.Interface.Name] = append(byIface[vipIface.Interface.Name], v)
+			break
 		}
+	}
 
-		if err = LeaseVIPs(log, cfgPath, vipIface.Name, []vip{vips.APIVips[i], vips.IngressVips[i]}); err != nil {
+	// The VIPs of an interface failing to lease do not keep the ones of
+	// the other interfaces from being leased
+	errs := []error{}
+	for _, ifaceName := range ifaceNames {
+		if err = LeaseVIPs(log, cfgPath, ifaceName, byIface[ifaceName]); err != nil {
 			log.WithFields(logrus.Fields{
 				"cfgPath":        cfgPath,
-				"vipMasterIface": vipIface.Name,
-				"vips":           []vip{vips.APIVips[i], vips.IngressVips[i]},
+				"vipMasterIface": ifaceName,
+				"vips":           byIface[ifaceName],
 			}).WithError(err).Error("Failed to lease VIPS")
-			return err
+			errs = append(errs, err)
 		}
 	}
 
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
 	log.WithFields(logrus.Fields{
 		"cfgPath": cfgPath,
 	}).Info("Leased VIPS successfully")
@@ -290,75 +409,500 @@ func handleLeasing(cfgPath string, apiVips, ingressVips []net.IP) error {
 	return nil
 }
 
-func KeepalivedWatch(kubeconfigPath, clusterConfigPath, templatePath, cfgPath string, apiVips, ingressVips []net.IP, apiPort, lbPort uint16, interval time.Duration) error {
-	var appliedConfig, curConfig, prevConfig *config.Node
-	var configChangeCtr uint8 = 0
+// watchLeasedVIPs reconciles the leased VIPs whenever the monitor
+// configuration changes, until ctx is done
+func watchLeasedVIPs(ctx context.Context, opts config.Options, cfgPath string, apiVips, ingressVips []net.IP, interval time.Duration) {
+	monitorConfPath := filepath.Join(filepath.Dir(cfgPath), MonitorConfFileName)
+	// A missing configuration reads as empty, like one whose VIPs were all
+	// removed
+	prev, _ := ioutil.ReadFile(monitorConfPath)
+	for sleep(ctx, interval) {
+		current, _ := ioutil.ReadFile(monitorConfPath)
+		if bytes.Equal(current, prev) {
+			continue
+		}
+		prev = current
+		log.WithFields(logrus.Fields{
+			"file": monitorConfPath,
+		}).Info("Monitor conf file changed, reconciling the leased VIPs")
+		if err := reconcileLeasedVIPs(opts, cfgPath, apiVips, ingressVips); err != nil {
+			log.WithError(err).Error("Failed to reconcile the leased VIPs")
+		}
+	}
+}
+
+// firewallTrackFile returns the keepalived track file in trackFileDir
+// holding 1 while the firewall rules of all the API VIPs of family are in
+// place
+func firewallTrackFile(trackFileDir, family string) string {
+	return filepath.Join(trackFileDir, "firewall-rules-"+family)
+}
+
+// updateFirewallTrackFiles checks the firewall rules of every port of ports
+// of every API VIP with backend and writes the track file of each family in
+// trackFileDir. The legacy iptablesFilePath flag file still follows the
+// first VIP.
+func updateFirewallTrackFiles(backend firewallBackend, trackFileDir string, apiVips []net.IP, ports []config.APIPort) {
+	inPlace := map[string]bool{}
+	for i, apiVip := range apiVips {
+		family := vipFamily(apiVip.String())
+		ruleExists := true
+		var err error
+		for _, p := range ports {
+			exists, checkErr := checkHAProxyFirewallRules(backend, apiVip.String(), p.Port, p.LbPort)
+			if checkErr != nil {
+				log.WithFields(logrus.Fields{"vip": apiVip, "port": p.Port}).WithError(checkErr).Error("Failed to check for haproxy firewall rule")
+				err = checkErr
+			}
+			ruleExists = ruleExists && exists
+		}
+		if prev, ok := inPlace[family]; ok {
+			inPlace[family] = prev && ruleExists
+		} else {
+			inPlace[family] = ruleExists
+		}
+
+		if i > 0 || err != nil {
+			continue
+		}
+		if ruleExists {
+			// if openfile returns a nil error then the file either already existed or has been created
+			fd, err := os.OpenFile(iptablesFilePath, os.O_CREATE, 0666)
+			if err != nil {
+				log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Error("Failed to open or create file")
+			} else if err := fd.Close(); err != nil {
+				log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Warn("Error closing file")
+			}
+		} else if err := os.RemoveAll(iptablesFilePath); err != nil {
+			// if the path doesn't exist then RemoveAll returns nil
+			log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Error("Failed to remove file")
+		}
+	}
+
+	for family, ok := range inPlace {
+		value := "0\n"
+		if ok {
+			value = "1\n"
+		}
+		if err := ioutil.WriteFile(firewallTrackFile(trackFileDir, family), []byte(value), 0644); err != nil {
+			log.WithFields(logrus.Fields{"path": firewallTrackFile(trackFileDir, family)}).WithError(err).Warn("Failed to write firewall track file")
+		}
+	}
+}
+
+// setTrackFiles sets the keepalived track files in trackFileDir of node and
+// of its nested configs, the maintenance one only with a maintenanceFile
+func setTrackFiles(node *config.Node, trackFileDir, maintenanceFile string) {
+	node.LeaseTrackFiles = leaseTrackFiles()
+	if maintenanceFile != "" {
+		node.MaintenanceTrackFile = maintenanceTrackFile(trackFileDir)
+	}
+	if node.Cluster.APIVIP != "" {
+		node.FirewallTrackFile = firewallTrackFile(trackFileDir, vipFamily(node.Cluster.APIVIP))
+	}
+	if node.Configs == nil {
+		return
+	}
+	for i := range *node.Configs {
+		c := &(*node.Configs)[i]
+		c.LeaseTrackFiles = node.LeaseTrackFiles
+		c.MaintenanceTrackFile = node.MaintenanceTrackFile
+		if c.Cluster.APIVIP != "" {
+			c.FirewallTrackFile = firewallTrackFile(trackFileDir, vipFamily(c.Cluster.APIVIP))
+		}
+	}
+}
 
-	if err := handleLeasing(cfgPath, apiVips, ingressVips); err != nil {
+// renderKeepalived renders the keepalived configuration stamped with its
+// generation, which names it in the logs and events of the reload. The
+// secrets are only set on the rendered copy of node.
+func renderKeepalived(cfgPath, templatePath string, files render.FileOptions, node config.Node) error {
+	secrets, err := render.ReadSecrets(files.SecretFiles)
+	if err != nil {
 		return err
 	}
+	node.Secrets = secrets
+	return render.RenderFile(files.Apply(render.FileSpec{RenderPath: cfgPath, TemplatePath: templatePath, GenerationComment: "#"}), node)
+}
+
+// reportUnverifiedReload alerts that keepalived did not load the configuration
+// it was reloaded with
+func reportUnverifiedReload(recorder *events.Recorder, reporter *health.Publisher, err error) {
+	keepalivedReloadsUnverified.Inc()
+	log.WithError(err).Error("Keepalived did not apply the new configuration")
+	recorder.Warning(events.ReasonKeepalivedReloadUnverified, "Keepalived did not apply the new configuration: %v", err)
+	reporter.Report(false, err.Error())
+}
+
+// keepalivedReloader renders the keepalived configuration once a change was
+// seen for cfgKeepalivedChangeThreshold iterations, or at the planned time of
+// a mode switch, and reloads keepalived
+type keepalivedReloader struct {
+	templatePath string
+	cfgPath      string
+	files        render.FileOptions
+	sock         serviceController
+	recorder     *events.Recorder
+	reporter     *health.Publisher
+	notifier     *alerts.Notifier
+	// reportMigration reports that the node switched to the mode of a
+	// coordinated migration
+	reportMigration func(epoch int64) error
+	// drift is told about every reload, disabled when nil
+	drift *driftChecker
+	// nodeName is the node whose unicast peers must have announced
+	// themselves
+	nodeName string
+	// vipProbeTimeout is how long the new VIPs are probed for, disabled
+	// when zero
+	vipProbeTimeout time.Duration
+	// pidFile and dataFile are those of keepalived, the reloads are only
+	// verified with a pidFile
+	pidFile  string
+	dataFile string
+
+	// applied is the configuration keepalived runs, nil until the first
+	// reload
+	applied *config.Node
+	prev    *config.Node
+	// changes counts the iterations the current change was seen
+	changes uint8
+}
+
+// apply counts the iterations cur differs from the applied configuration,
+// renders and reloads it from the threshold on, or right away when forced.
+// It returns false when a failed reload has to be tried again, and the error
+// of a failed render.
+func (r *keepalivedReloader) apply(ctx context.Context, cur *config.Node, view peers.View, forced bool) (bool, error) {
+	defer func() { r.prev = cur }()
+	// A forced refresh compares with no applied config, so only the
+	// unicast peers can still hold it back
+	changedFrom := r.applied
+	if forced {
+		changedFrom = nil
+	}
+	if !doesConfigChanged(cur, changedFrom, view, r.nodeName) {
+		r.changes = 0
+		return true, nil
+	}
+	if r.prev == nil || cmp.Equal(*r.prev, *cur) {
+		r.changes++
+	} else {
+		r.changes = 1
+	}
+	log.WithFields(logrus.Fields{
+		"current config":        fmt.Sprintf("%+v", *cur),
+		"current nested config": fmt.Sprintf("%+v", *cur.Configs),
+		"configChangeCtr":       r.changes,
+	}).Info("Config change detected")
+	if r.changes < cfgKeepalivedChangeThreshold && !forced {
+		return true, nil
+	}
+
+	log.WithFields(logrus.Fields{
+		"curConfig": fmt.Sprintf("%+v", *cur),
+	}).Info("Apply config change")
+
+	// Never claim a VIP another host on the link answers for. A keepalived
+	// that already runs on the first apply may hold the VIPs with the
+	// cluster, they are not new to it.
+	if r.applied == nil && keepalivedRunning(r.pidFile) {
+		log.Debug("Keepalived already runs, skipping the duplicate VIP probe")
+	} else if err := checkNewVIPsNotInUse(cur, r.applied, r.vipProbeTimeout); err != nil {
+		log.WithError(err).Error("Refusing to apply Keepalived configuration")
+		r.reporter.Report(false, err.Error())
+		return false, nil
+	}
+
+	if err := renderKeepalived(r.cfgPath, r.templatePath, r.files, *cur); err != nil {
+		log.WithFields(logrus.Fields{
+			"config": fmt.Sprintf("%+v", *cur),
+		}).Error("Failed to render Keepalived configuration")
+		r.notifier.RenderFailed(err)
+		return false, err
+	}
+
+	if err := r.sock.Send(ctx, "reload"); err != nil {
+		log.WithFields(logrus.Fields{
+			"socket": keepalivedControlSock,
+		}).WithError(err).Error("Failed to write reload to Keepalived container control socket")
+		// The change counter stays above the threshold, so the next
+		// iteration reloads again
+		r.reporter.Report(false, "Failed to reload keepalived: "+err.Error())
+		return false, nil
+	}
+	if err := verifyKeepalivedReload(ctx, r.pidFile, r.dataFile, r.cfgPath, cur); err != nil {
+		if ctx.Err() == nil {
+			// The applied config is left as it was, so the next iteration
+			// renders and reloads again
+			reportUnverifiedReload(r.recorder, r.reporter, err)
+		}
+		return false, nil
+	}
+	r.recorder.Normal(events.ReasonKeepalivedReloaded, "Reloaded keepalived after a configuration change")
+	r.notifier.Reloaded()
+	r.changes = 0
+	r.applied = cur
+	r.drift.rendered(true)
+	return true, nil
+}
+
+// switchMode renders cur in the mode of update and reloads keepalived at the
+// planned time of the update. It returns whether keepalived switched, and
+// the error of a failed render.
+func (r *keepalivedReloader) switchMode(ctx context.Context, cur *config.Node, update modeUpdateInfo) (bool, error) {
+	if err := renderKeepalived(r.cfgPath, r.templatePath, r.files, *cur); err != nil {
+		log.WithFields(logrus.Fields{
+			"config": fmt.Sprintf("%+v", *cur),
+		}).Error("Failed to render Keepalived configuration")
+		r.notifier.RenderFailed(err)
+		return false, err
+	}
+
+	if !sleep(ctx, time.Until(update.Time)) {
+		return false, nil
+	}
+	log.WithFields(logrus.Fields{
+		"curTime": time.Now(),
+	}).Info("After sleep, before sending reload request ")
+
+	if err := r.sock.Send(ctx, "reload"); err != nil {
+		if ctx.Err() == nil {
+			// The configuration differs from the applied one, so the main
+			// loop reloads keepalived again
+			log.WithFields(logrus.Fields{
+				"socket": keepalivedControlSock,
+			}).WithError(err).Error("Failed to write reload to Keepalived container control socket")
+		}
+		return false, nil
+	}
+	if err := verifyKeepalivedReload(ctx, r.pidFile, r.dataFile, r.cfgPath, cur); err != nil {
+		if ctx.Err() == nil {
+			reportUnverifiedReload(r.recorder, r.reporter, err)
+		}
+		return false, nil
+	}
+	r.recorder.Normal(events.ReasonKeepalivedModeSwitch, "Switched keepalived to %s mode", update.Mode)
+	r.notifier.Reloaded()
+	setModeUpdateStatus(update, modeUpdateApplied, "")
+	if update.Epoch != 0 {
+		if err := r.reportMigration(update.Epoch); err != nil {
+			log.WithFields(logrus.Fields{
+				"epoch": update.Epoch,
+			}).WithError(err).Warn("Failed to report the keepalived mode switch")
+		}
+	}
+	r.changes = 0
+	r.applied = cur
+	r.drift.rendered(true)
+	return true, nil
+}
+
+// KeepalivedWatch renders the keepalived configuration from
+// opts.TemplatePath into opts.CfgPath and reloads keepalived when it
+// changes, or claims the VIPs on its own in the BGP and lease advertisement
+// modes
+func KeepalivedWatch(opts Options) error {
+	kubeconfigPath, cfgPath, interval := opts.KubeconfigPath, opts.CfgPath, opts.Interval
+	apiVips, ingressVips := opts.APIVIPs, opts.IngressVIPs
+	var curConfig *config.Node
+
+	serveMetrics(opts.MetricsAddress)
+
+	setLeaseStatusFile(opts.LeaseStatusFile)
+	setLeaseTrackFileDir(opts.TrackFileDir)
+	setDHCPClient(opts.DHCPClient)
+	if err := handleLeasing(opts.Config, cfgPath, apiVips, ingressVips); err != nil {
+		// The VIPs that were leased are kept and the failed ones are
+		// reported in the lease status file, they are tried again when the
+		// monitor restarts
+		if !errors.Is(err, errVIPsNotLeased) || leasedVIPCount() == 0 {
+			return err
+		}
+		log.WithError(err).Error("Continuing with the VIPs that were leased")
+	}
+
+	firewall := selectFirewallBackend(opts.FirewallBackend, opts.FirewallRuleMode)
+	ingressFirewall := newFirewallReconciler(firewall, ingressRedirects(ingressVips, opts.IngressRedirectPorts))
+	reporter := newHealthReporter(opts, health.ComponentKeepalived)
+	recorder := newEventRecorder(opts, eventComponentKeepalived)
+	notifier := newAlertNotifier(opts, eventComponentKeepalived)
+	exchange := newPeerExchange(opts)
+	ingressFirewall.events = recorder
+	overrides := &nodeOverrides{kubeconfigPath: kubeconfigPath, nodeName: opts.NodeName}
+	ingressFirewall.setDesired(true)
 
-	signals := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
 	updateModeCh := make(chan modeUpdateInfo, 1)
 	bootstrapStopKeepalived := make(chan APIState, 1)
 
-	signal.Notify(signals, syscall.SIGTERM)
-	signal.Notify(signals, syscall.SIGINT)
-	go func() {
-		<-signals
-		done <- true
-	}()
+	ctx, cancel := notifyContext()
+	defer cancel()
+	// forced is set by SIGHUP to render and reload without waiting for the
+	// change threshold
+	refresh, stopRefresh := notifyRefresh()
+	defer stopRefresh()
+	var forced bool
+	var workers workerGroup
+
+	var bgp *bgpAdvertiser
+	if opts.VIPAdvertisement == VIPAdvertisementBGP || opts.VIPAdvertisement == VIPAdvertisementBoth {
+		bgp = newBGPAdvertiser(opts.BGP, opts.Render, apiVips, ingressVips, opts.APIPort)
+		bgp.reporter = newHealthReporter(opts, health.ComponentBGP)
+	}
+	var router *vipRouter
+	if opts.VIPRoutes.Enabled() {
+		router = newVIPRouter(opts.VIPRoutes, append(append([]net.IP{}, apiVips...), ingressVips...))
+		workers.Go("vip-routes", func() {
+			router.run(ctx, interval)
+		})
+	}
+	workers.Go("vip-leasing", func() {
+		watchLeasedVIPs(ctx, opts.Config, cfgPath, apiVips, ingressVips, interval)
+	})
+	if opts.HooksDir != "" {
+		hooks := newVIPHooks(opts.Config, opts.HooksDir, apiVips, ingressVips)
+		workers.Go("vip-hooks", func() {
+			hooks.run(ctx, interval)
+		})
+	}
+	// shutdown stops the workers before withdrawing the VIPs so that none
+	// of them acts on keepalived during the teardown
+	shutdown := func() error {
+		log.Info("Shutting down the keepalived monitor")
+		cancel()
+		workers.Wait(opts.ShutdownTimeout)
+		if router != nil {
+			router.unrouteAll()
+		}
+		if bgp != nil {
+			bgp.withdrawAll()
+		}
+		ReleaseLeases()
+		ingressFirewall.setDesired(false)
+		ingressFirewall.reconcile()
+		return nil
+	}
+	// The loop sleeps until the planned time of a mode switch
+	serveProbes(opts.ProbeAddress, "keepalived", loopTimeout(interval)+(modeUpdateIntervalInSec/2)*time.Second)
+	if opts.VIPAdvertisement == VIPAdvertisementLease {
+		// keepalived does not run, the VIPs follow their Leases and the
+		// ingress rules are kept up to date
+		identity, err := utils.ShortHostname()
+		if err != nil {
+			return err
+		}
+		for _, holder := range newVIPHolders(opts.Config, kubeconfigPath, identity, apiVips, ingressVips, opts.APIPort, opts.VIPLeases) {
+			holder := holder
+			workers.Go("vip-lease-"+holder.name, func() {
+				holder.contend(ctx)
+			})
+		}
+		for {
+			probes.Beat("keepalived")
+			select {
+			case <-ctx.Done():
+				return shutdown()
+			default:
+				ingressFirewall.reconcile()
+				probes.SetReady(true)
+				sleep(ctx, interval)
+			}
+		}
+	}
+	if opts.VIPAdvertisement == VIPAdvertisementBGP {
+		// keepalived does not run, only the VIP advertisement and the
+		// ingress rules are kept up to date
+		for {
+			probes.Beat("keepalived")
+			select {
+			case <-ctx.Done():
+				return shutdown()
+			default:
+				ingressFirewall.reconcile()
+				bgp.update()
+				probes.SetReady(true)
+				sleep(ctx, interval)
+			}
+		}
+	}
 
-	go handleConfigModeUpdate(cfgPath, kubeconfigPath, updateModeCh)
+	workers.Go("keepalived-mode", func() {
+		handleConfigModeUpdate(ctx, opts, updateModeCh)
+	})
 
 	if os.Getenv("IS_BOOTSTRAP") == "yes" {
 		/* When OPENSHIFT_INSTALL_PRESERVE_BOOTSTRAP is set to true the bootstrap node won't be destroyed and
 		   Keepalived on the bootstrap continue to run, this behavior might cause problems when unicast keepalived being used,
 		   so, Keepalived on bootstrap should stop running when local kube-apiserver isn't operational anymore.
 		   handleBootstrapStopKeepalived function is responsible to stop Keepalived when the condition is met. */
-		go handleBootstrapStopKeepalived(kubeconfigPath, bootstrapStopKeepalived)
+		workers.Go("bootstrap-stop-keepalived", func() {
+			handleBootstrapStopKeepalived(ctx, opts.Config, kubeconfigPath, bootstrapStopKeepalived)
+		})
 	}
 
-	conn, err := net.Dial("unix", keepalivedControlSock)
+	sock, err := newServiceController("keepalived", keepalivedControlSock, syscall.SIGHUP, opts.KeepalivedControl)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer sock.Close()
+	reloader := &keepalivedReloader{
+		templatePath: opts.TemplatePath,
+		cfgPath:      cfgPath,
+		files:        opts.Render,
+		sock:         sock,
+		recorder:     recorder,
+		reporter:     reporter,
+		notifier:     notifier,
+		reportMigration: func(epoch int64) error {
+			return appliedModeMigration(kubeconfigPath, opts.NodeName, epoch)
+		},
+		drift:           newDriftChecker(opts, "keepalived", cfgPath, opts.keepalivedPidFile()),
+		nodeName:        opts.NodeName,
+		vipProbeTimeout: opts.VIPProbeTimeout,
+		pidFile:         opts.KeepalivedPidFile,
+		dataFile:        opts.KeepalivedDataFile,
+	}
 	for {
+		probes.Beat("keepalived")
 		select {
-		case <-done:
-			return nil
+		case <-ctx.Done():
+			return shutdown()
 
 		case APIStateChanged := <-bootstrapStopKeepalived:
-			//Verify that stop message sent successfully
-			for {
-				var cmdMsg []byte
-				if APIStateChanged == stopped {
-					cmdMsg = []byte("stop\n")
-				} else {
-					cmdMsg = []byte("reload\n")
-				}
-				_, err := conn.Write(cmdMsg)
-				if err == nil {
-					log.Infof("Command message successfully sent to Keepalived container control socket: %s", string(cmdMsg[:]))
-					break
+			cmdMsg := "reload"
+			if APIStateChanged == stopped {
+				cmdMsg = "stop"
+			}
+			if err := sock.Send(ctx, cmdMsg); err != nil {
+				if ctx.Err() != nil {
+					return shutdown()
 				}
 				log.WithFields(logrus.Fields{
 					"socket": keepalivedControlSock,
-				}).Error("Failed to write command to Keepalived container control socket")
-				time.Sleep(1 * time.Second)
+				}).WithError(err).Error("Failed to write command to Keepalived container control socket")
+				// Try again unless the API state changed meanwhile
+				select {
+				case bootstrapStopKeepalived <- APIStateChanged:
+				default:
+				}
+			} else {
+				log.Infof("Command message successfully sent to Keepalived container control socket: %s", cmdMsg)
+				if APIStateChanged == stopped {
+					recorder.Normal(events.ReasonBootstrapVIPsReleased, "Stopped keepalived on the bootstrap node, the API is served by the control plane")
+				}
 			}
 			// Make sure we don't send multiple messages in close succession if the
 			// bootstrapStopKeepalived queue has more than one item in it.
-			time.Sleep(5 * time.Second)
+			sleep(ctx, 5*time.Second)
 
 		case desiredModeInfo := <-updateModeCh:
 
-			newConfig, err := config.GetConfig(kubeconfigPath, clusterConfigPath, "/etc/resolv.conf", apiVips, ingressVips, 0, 0, 0, config.ClusterLBConfig{})
+			newConfig, err := getConfig(opts, kubeconfigPath, opts.ClusterConfigPath, "/etc/resolv.conf", apiVips, ingressVips, 0, 0, 0, config.ClusterLBConfig{})
 			if err != nil {
 				return err
 			}
+			setTrackFiles(&newConfig, opts.TrackFileDir, opts.MaintenanceFile)
+			setBFD(&newConfig, opts.BGP.BFD)
 			log.WithFields(logrus.Fields{
 				"newConfig.EnableUnicast": newConfig.EnableUnicast,
 				"desiredModeInfo.Mode":    desiredModeInfo.Mode,
@@ -372,68 +916,48 @@ func KeepalivedWatch(kubeconfigPath, clusterConfigPath, templatePath, cfgPath st
 			}
 			// We have to get a valid unicast config before the migration
 			for {
-				err = updateUnicastConfig(kubeconfigPath, &newConfig)
+				err = updateUnicastConfig(opts.Config, kubeconfigPath, opts.UnicastPeerCIDRs, &newConfig)
 				if err == nil {
 					break
 				}
-				time.Sleep(interval)
+				if !sleep(ctx, interval) {
+					return shutdown()
+				}
 			}
+			overrides.get().Apply(&newConfig)
 
 			log.WithFields(logrus.Fields{
 				"curConfig": fmt.Sprintf("%+v", newConfig),
 			}).Info("Mode Update config change")
 
-			err = render.RenderFile(cfgPath, templatePath, newConfig)
+			switched, err := reloader.switchMode(ctx, &newConfig, desiredModeInfo)
 			if err != nil {
-				log.WithFields(logrus.Fields{
-					"config": fmt.Sprintf("%+v", newConfig),
-				}).Error("Failed to render Keepalived configuration")
 				return err
 			}
-
-			time.Sleep(time.Until(desiredModeInfo.Time))
-			log.WithFields(logrus.Fields{
-				"curTime": time.Now(),
-			}).Info("After sleep, before sending reload request ")
-
-			_, err = conn.Write([]byte("reload\n"))
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"socket": keepalivedControlSock,
-				}).Error("Failed to write reload to Keepalived container control socket")
-				return err
+			if ctx.Err() != nil {
+				return shutdown()
+			}
+			if switched {
+				curConfig = &newConfig
 			}
-
-			curConfig = &newConfig
-			configChangeCtr = 0
-			appliedConfig = curConfig
 
 		default:
-			// Signal to keepalived whether the haproxy firewall rule is in place
-			// The rules are all managed as a single entity, so we should only need
-			// to check the first VIP.
+			// Signal to keepalived whether the haproxy firewall rules are in place
 			// NOTE(bnemec): We are now doing this first so it doesn't get skipped
 			// if there is a problem updating the peer list below, which can result
 			// in the VIP remaining on a node without API connectivity.
-			ruleExists, err := checkHAProxyFirewallRules(apiVips[0].String(), apiPort, lbPort)
-			if err != nil {
-				log.Error("Failed to check for haproxy firewall rule")
-			} else if ruleExists {
-				// if openfile returns a nil error then the file either already existed or has been created
-				fd, err := os.OpenFile(iptablesFilePath, os.O_CREATE, 0666)
-				if err != nil {
-					log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Error("Failed to open or create file")
-				} else if err := fd.Close(); err != nil {
-					log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Warn("Error closing file")
-				}
-			} else if err := os.RemoveAll(iptablesFilePath); err != nil {
-				// if the path doesn't exist then RemoveAll returns nil
-				log.WithFields(logrus.Fields{"path": iptablesFilePath}).WithError(err).Error("Failed to remove file")
+			updateFirewallTrackFiles(firewall, opts.TrackFileDir, apiVips, config.APIPorts(opts.APIPort, opts.LbPort, opts.Config.ExtraAPIPorts))
+			updateMaintenanceTrackFile(opts.TrackFileDir, opts.MaintenanceFile)
+			ingressFirewall.reconcile()
+			if bgp != nil {
+				bgp.update()
 			}
-			newConfig, err := config.GetConfig(kubeconfigPath, clusterConfigPath, "/etc/resolv.conf", apiVips, ingressVips, 0, 0, 0, config.ClusterLBConfig{})
+			newConfig, err := getConfig(opts, kubeconfigPath, opts.ClusterConfigPath, "/etc/resolv.conf", apiVips, ingressVips, 0, 0, 0, config.ClusterLBConfig{})
 			if err != nil {
 				return err
 			}
+			setTrackFiles(&newConfig, opts.TrackFileDir, opts.MaintenanceFile)
+			setBFD(&newConfig, opts.BGP.BFD)
 
 			//In upgrade flow, we should first continue with the same mode (unicast or multicast) as currently configured in keepalived.conf file
 			err, curEnableUnicast := getActualMode(cfgPath)
@@ -451,56 +975,61 @@ func KeepalivedWatch(kubeconfigPath, clusterConfigPath, templatePath, cfgPath st
 			for i, _ := range *newConfig.Configs {
 				(*newConfig.Configs)[i].EnableUnicast = newConfig.EnableUnicast
 			}
-			err = updateUnicastConfig(kubeconfigPath, &newConfig)
+			err = updateUnicastConfig(opts.Config, kubeconfigPath, opts.UnicastPeerCIDRs, &newConfig)
 			if err != nil {
 				// We don't want to render a new config with an incomplete
 				// unicast peer list
-				time.Sleep(interval)
+				reporter.Report(false, "Failed to get the unicast peers: "+err.Error())
+				forced = sleepOrRefresh(ctx, refresh, interval) || forced
 				continue
 			}
-			curConfig = &newConfig
-			if doesConfigChanged(curConfig, appliedConfig) {
-				if prevConfig == nil || cmp.Equal(*prevConfig, *curConfig) {
-					configChangeCtr++
-				} else {
-					configChangeCtr = 1
-				}
-				log.WithFields(logrus.Fields{
-					"current config":        fmt.Sprintf("%+v", *curConfig),
-					"current nested config": fmt.Sprintf("%+v", *curConfig.Configs),
-					"configChangeCtr":       configChangeCtr,
-				}).Info("Config change detected")
-
-				if configChangeCtr >= cfgKeepalivedChangeThreshold {
-
-					log.WithFields(logrus.Fields{
-						"curConfig": fmt.Sprintf("%+v", *curConfig),
-					}).Info("Apply config change")
-
-					err = render.RenderFile(cfgPath, templatePath, newConfig)
-					if err != nil {
-						log.WithFields(logrus.Fields{
-							"config": fmt.Sprintf("%+v", newConfig),
-						}).Error("Failed to render Keepalived configuration")
-						return err
-					}
-
-					_, err = conn.Write([]byte("reload\n"))
-					if err != nil {
-						log.WithFields(logrus.Fields{
-							"socket": keepalivedControlSock,
-						}).Error("Failed to write reload to Keepalived container control socket")
-						return err
-					}
-					configChangeCtr = 0
-					appliedConfig = curConfig
-				}
+			if opts.VIPProbeTimeout > 0 {
+				// The peers that may hold a VIP in multicast mode too
+				config.PopulateNodeAddresses(kubeconfigPath, &newConfig)
+			}
+			overrides.get().Apply(&newConfig)
+			var prevShards []config.IngressShard
+			if curConfig != nil {
+				prevShards = curConfig.IngressShards
+			}
+			populateIngressShards(opts, &newConfig, prevShards)
+			if curConfig != nil {
+				newConfig.VRRP = curConfig.VRRP
+			}
+			if vrrp, err := config.GetVRRPSettings(kubeconfigPath); err != nil {
+				log.WithError(err).Warn("Failed to get the VRRP settings, keeping previous ones")
 			} else {
-				configChangeCtr = 0
+				newConfig.VRRP = vrrp
+			}
+			if curConfig != nil {
+				newConfig.HealthChecks = curConfig.HealthChecks
+			}
+			if checks, err := config.GetHealthChecks(kubeconfigPath); err != nil {
+				log.WithError(err).Warn("Failed to get the health checks, keeping previous ones")
+			} else {
+				newConfig.HealthChecks = checks
+			}
+			curConfig = &newConfig
+			view := exchange.update(curConfig)
+			if reloader.drift.check() && opts.RerenderOnDrift {
+				log.Info("Rendering the drifted keepalived configuration again")
+				forced = true
+			}
+			ok, err := reloader.apply(ctx, curConfig, view, forced)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return shutdown()
+			}
+			if !ok {
+				forced = sleepOrRefresh(ctx, refresh, interval) || forced
+				continue
 			}
-			prevConfig = &newConfig
+			reporter.ReportVIPs(true, "", heldVIPs(apiVips, ingressVips))
+			probes.SetReady(true)
 
-			time.Sleep(interval)
+			forced = sleepOrRefresh(ctx, refresh, interval)
 		}
 	}
 }
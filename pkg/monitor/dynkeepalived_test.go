@@ -0,0 +1,148 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor/monitortest"
+	"github.com/openshift/baremetal-runtimecfg/pkg/peers"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("keepalived_reloader", func() {
+	var dir string
+	var sock *monitortest.ControlSocket
+	var r *keepalivedReloader
+	var reported []int64
+
+	// node returns the config of a node with the API VIP vip
+	node := func(vip string) *config.Node {
+		return &config.Node{
+			Cluster: config.Cluster{APIVIP: vip, IngressVIP: "192.168.111.4"},
+			Configs: &[]config.Node{},
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "keepalived")
+		Expect(err).ShouldNot(HaveOccurred())
+		sock, err = monitortest.NewControlSocket(dir, "keepalived.sock")
+		Expect(err).ShouldNot(HaveOccurred())
+		// The control socket of the keepalived container
+		ctl, err := newServiceController("keepalived", sock.Path, syscall.SIGHUP, ServiceControlOptions{Mechanism: ServiceControlSocket})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		templatePath := filepath.Join(dir, "keepalived.conf.tmpl")
+		Expect(os.WriteFile(templatePath, []byte("virtual_ipaddress {{ .Cluster.APIVIP }}{{ if .EnableUnicast }}\nunicast_peer{{ end }}\n"), 0644)).To(Succeed())
+		reported = []int64{}
+		r = &keepalivedReloader{
+			templatePath: templatePath,
+			cfgPath:      filepath.Join(dir, "keepalived.conf"),
+			sock:         ctl,
+			reportMigration: func(epoch int64) error {
+				reported = append(reported, epoch)
+				return nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		r.sock.Close()
+		sock.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("reloads_once_the_change_is_seen_for_the_threshold", func() {
+		for i := uint8(1); i < cfgKeepalivedChangeThreshold; i++ {
+			Expect(r.apply(context.Background(), node("192.168.111.5"), peers.View{}, false)).To(BeTrue())
+		}
+		Consistently(sock.Commands, 100*time.Millisecond).Should(BeEmpty())
+
+		Expect(r.apply(context.Background(), node("192.168.111.5"), peers.View{}, false)).To(BeTrue())
+		Eventually(sock.Commands).Should(Equal([]string{"reload"}))
+		Expect(r.applied.Cluster.APIVIP).To(Equal("192.168.111.5"))
+		data, err := os.ReadFile(r.cfgPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("virtual_ipaddress 192.168.111.5\n"))
+
+		// Nothing changed
+		Expect(r.apply(context.Background(), node("192.168.111.5"), peers.View{}, false)).To(BeTrue())
+		Expect(r.changes).To(BeZero())
+	})
+
+	It("reloads_right_away_when_forced", func() {
+		Expect(r.apply(context.Background(), node("192.168.111.5"), peers.View{}, true)).To(BeTrue())
+		Expect(r.apply(context.Background(), node("192.168.111.5"), peers.View{}, true)).To(BeTrue())
+		Eventually(sock.Commands).Should(Equal([]string{"reload", "reload"}))
+	})
+
+	It("skips_the_vip_probe_on_the_first_apply_while_keepalived_runs", func() {
+		foreignMAC, _ := net.ParseMAC("52:54:00:00:00:99")
+		probeAddress = func(iface *net.Interface, ip net.IP, timeout time.Duration) ([]net.HardwareAddr, error) {
+			return []net.HardwareAddr{foreignMAC}, nil
+		}
+		running, wasRunning := true, keepalivedRunning
+		keepalivedRunning = func(string) bool { return running }
+		defer func() {
+			probeAddress = utils.ProbeAddress
+			keepalivedRunning = wasRunning
+		}()
+		r.vipProbeTimeout = time.Millisecond
+		cur := node("192.168.111.5")
+		cur.VRRPInterface = "lo"
+
+		Expect(r.apply(context.Background(), cur, peers.View{}, true)).To(BeTrue())
+		Eventually(sock.Commands).Should(Equal([]string{"reload"}))
+
+		By("probing_once_keepalived_is_not_running", func() {
+			r.applied, running = nil, false
+			Expect(r.apply(context.Background(), cur, peers.View{}, true)).To(BeFalse())
+			Consistently(sock.Commands, 100*time.Millisecond).Should(Equal([]string{"reload"}))
+		})
+	})
+
+	It("switches_the_mode_at_the_planned_time", func() {
+		r.applied = node("192.168.111.5")
+		update := modeUpdateInfo{Mode: "unicast", Time: time.Now().Add(500 * time.Millisecond), Epoch: 3}
+		cur := node("192.168.111.5")
+		cur.EnableUnicast = true
+
+		switched := make(chan bool)
+		go func() {
+			defer GinkgoRecover()
+			ok, err := r.switchMode(context.Background(), cur, update)
+			Expect(err).ShouldNot(HaveOccurred())
+			switched <- ok
+		}()
+		// The configuration is rendered ahead of the planned time
+		Eventually(func() (bool, error) {
+			_, unicast := getActualMode(r.cfgPath)
+			return unicast, nil
+		}).Should(BeTrue())
+		Consistently(sock.Commands, 300*time.Millisecond).Should(BeEmpty())
+		Eventually(switched).Should(Receive(BeTrue()))
+		Expect(time.Now()).To(BeTemporally(">=", update.Time))
+		Eventually(sock.Commands).Should(Equal([]string{"reload"}))
+		Expect(r.applied).To(Equal(cur))
+		Expect(reported).To(Equal([]int64{3}))
+	})
+
+	It("stops_waiting_for_the_planned_time_on_shutdown", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		ok, err := r.switchMode(ctx, node("192.168.111.5"), modeUpdateInfo{Mode: "unicast", Time: time.Now().Add(time.Hour)})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(r.applied).To(BeNil())
+		Expect(reported).To(BeEmpty())
+	})
+})
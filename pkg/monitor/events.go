@@ -0,0 +1,21 @@
+package monitor
+
+import (
+	"os"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/events"
+)
+
+const (
+	eventComponentKeepalived = "keepalived-monitor"
+	eventComponentHAProxy    = "haproxy-monitor"
+)
+
+// newEventRecorder returns the recorder of the Events of component, nil
+// without opts.EmitEvents
+func newEventRecorder(opts Options, component string) *events.Recorder {
+	if !opts.EmitEvents {
+		return nil
+	}
+	return events.NewRecorder(opts.KubeconfigPath, os.Getenv("POD_NAMESPACE"), opts.NodeName, component)
+}
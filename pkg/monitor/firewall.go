@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FirewallBackendAuto     = "auto"
+	FirewallBackendIptables = "iptables"
+	FirewallBackendNftables = "nftables"
+)
+
+// portRedirect sends the traffic of a VIP port to a local port
+type portRedirect struct {
+	vip        string
+	port       uint16
+	targetPort uint16
+	set        ruleSet
+}
+
+// ingressRedirects returns the redirects of ports to the same port of the
+// local node for every ingress VIP
+func ingressRedirects(ingressVips []net.IP, ports []uint16) []portRedirect {
+	redirects := []portRedirect{}
+	for _, vip := range ingressVips {
+		for _, port := range ports {
+			redirects = append(redirects, portRedirect{vip.String(), port, port, ingressLBRuleSet})
+		}
+	}
+	return redirects
+}
+
+// apiRedirect sends the API traffic of apiVip to the HAProxy port
+func apiRedirect(apiVip string, apiPort, lbPort uint16) portRedirect {
+	return portRedirect{apiVip, apiPort, lbPort, apiLBRuleSet}
+}
+
+// firewallBackend manages the rules of the port redirects
+type firewallBackend interface {
+	ensure(r portRedirect) error
+	check(r portRedirect) (bool, error)
+	clean(r portRedirect) error
+	// flush removes every rule and chain of the set, whatever the VIP
+	flush(set ruleSet) error
+}
+
+// newFirewallBackend returns the backend of name, sending the traffic with
+// the rule mode mode, and the implementation of the firewall it drives
+func newFirewallBackend(name, mode string, lookPath func(string) (string, error)) (firewallBackend, string, error) {
+	switch name {
+	case FirewallBackendIptables:
+		return iptablesBackend{mode: mode}, detectIptablesVariant(), nil
+	case FirewallBackendNftables:
+		return nftBackend{mode: mode}, FirewallBackendNftables, nil
+	case FirewallBackendAuto:
+		_, nftErr := lookPath("nft")
+		if _, err := lookPath("iptables"); err == nil {
+			variant := detectIptablesVariant()
+			// The legacy xtables are gone from recent kernels, where their
+			// rules would silently never match
+			if variant != iptablesLegacy || nftErr != nil {
+				return iptablesBackend{mode: mode}, variant, nil
+			}
+			log.Warn("The iptables binary drives the legacy xtables, using nftables")
+		}
+		if nftErr == nil {
+			return nftBackend{mode: mode}, FirewallBackendNftables, nil
+		}
+		return nil, "", fmt.Errorf("Neither iptables nor nft is available")
+	}
+	return nil, "", fmt.Errorf("Unknown firewall backend %q", name)
+}
+
+// detectIptablesVariant returns the variant of the iptables binary, plain
+// iptables when unknown
+func detectIptablesVariant() string {
+	version, err := iptablesVersion()
+	if err != nil {
+		log.WithError(err).Warn("Failed to get the iptables version")
+		return FirewallBackendIptables
+	}
+	if variant := iptablesVariant(version); variant != "" {
+		return variant
+	}
+	return FirewallBackendIptables
+}
+
+// unavailableFirewall is the backend of a monitor whose firewall backend
+// could not be selected, every rule operation fails with err
+type unavailableFirewall struct {
+	err error
+}
+
+func (u unavailableFirewall) ensure(portRedirect) error        { return u.err }
+func (u unavailableFirewall) check(portRedirect) (bool, error) { return false, u.err }
+func (u unavailableFirewall) clean(portRedirect) error         { return u.err }
+func (u unavailableFirewall) flush(ruleSet) error              { return u.err }
+
+// selectFirewallBackend returns the backend of name with the rule mode mode
+// and publishes it
+func selectFirewallBackend(name, mode string) firewallBackend {
+	backend, implementation, err := newFirewallBackend(name, mode, exec.LookPath)
+	if err != nil {
+		log.WithError(err).Error("Failed to select the firewall backend")
+		return unavailableFirewall{err}
+	}
+	log.WithFields(logrus.Fields{
+		"backend": fmt.Sprintf("%T", backend),
+		"mode":    mode,
+	}).Info("Selected firewall backend")
+	publishCapability(capabilityFirewall, implementation, []string{implementation})
+	return backend
+}
+
+func checkHAProxyFirewallRules(backend firewallBackend, apiVip string, apiPort, lbPort uint16) (bool, error) {
+	return backend.check(apiRedirect(apiVip, apiPort, lbPort))
+}
@@ -0,0 +1,143 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("firewall", func() {
+	lookPath := func(available ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, a := range available {
+				if a == name {
+					return "/usr/sbin/" + name, nil
+				}
+			}
+			return "", fmt.Errorf("%s not found", name)
+		}
+	}
+
+	version := "iptables v1.8.8 (nf_tables)"
+	origIptablesVersion := iptablesVersion
+
+	BeforeEach(func() {
+		iptablesVersion = func() ([]byte, error) { return []byte(version + "\n"), nil }
+	})
+
+	AfterEach(func() {
+		iptablesVersion = origIptablesVersion
+		version = "iptables v1.8.8 (nf_tables)"
+	})
+
+	backend := func(name string, available ...string) firewallBackend {
+		b, _, err := newFirewallBackend(name, RuleModeRedirect, lookPath(available...))
+		Expect(err).ShouldNot(HaveOccurred())
+		return b
+	}
+
+	It("backend_selection", func() {
+		Expect(backend(FirewallBackendAuto, "iptables", "nft")).Should(Equal(iptablesBackend{mode: RuleModeRedirect}))
+		Expect(backend(FirewallBackendAuto, "nft")).Should(Equal(nftBackend{mode: RuleModeRedirect}))
+		Expect(backend(FirewallBackendNftables, "iptables")).Should(Equal(nftBackend{mode: RuleModeRedirect}))
+		_, _, err := newFirewallBackend(FirewallBackendAuto, RuleModeRedirect, lookPath())
+		Expect(err).Should(HaveOccurred())
+		_, _, err = newFirewallBackend("ebtables", RuleModeRedirect, lookPath())
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("avoids_the_legacy_xtables", func() {
+		_, implementation, err := newFirewallBackend(FirewallBackendAuto, RuleModeRedirect, lookPath("iptables", "nft"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(implementation).Should(Equal(iptablesNft))
+
+		version = "iptables v1.8.4 (legacy)"
+		Expect(backend(FirewallBackendAuto, "iptables", "nft")).Should(Equal(nftBackend{mode: RuleModeRedirect}))
+		Expect(backend(FirewallBackendAuto, "iptables")).Should(Equal(iptablesBackend{mode: RuleModeRedirect}))
+		_, implementation, err = newFirewallBackend(FirewallBackendIptables, RuleModeRedirect, lookPath("iptables", "nft"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(implementation).Should(Equal(iptablesLegacy))
+
+		Expect(iptablesVariant([]byte("iptables v1.4.21"))).Should(Equal(iptablesLegacy))
+		Expect(iptablesVariant([]byte("command not found"))).Should(BeEmpty())
+	})
+
+	It("rule_modes", func() {
+		Expect(DefaultRuleMode("Cilium")).Should(Equal(RuleModeDNATSNAT))
+		Expect(DefaultRuleMode("OVNKubernetes")).Should(Equal(RuleModeRedirect))
+		Expect(DefaultRuleMode("")).Should(Equal(RuleModeRedirect))
+		Expect(ValidateRuleMode(RuleModeDNATMark)).Should(Succeed())
+		Expect(ValidateRuleMode("masquerade")).ShouldNot(Succeed())
+	})
+
+	It("iptables_rules", func() {
+		rules := getRedirectRules(RuleModeRedirect, apiRedirect("192.168.111.5", 6443, 9445))
+		Expect(rules).Should(Equal([]iptablesRule{
+			{"nat", "PREROUTING", []string{"--dst", "192.168.111.5", "-p", "tcp", "--dport", "6443", "-j", "REDIRECT", "--to-ports", "9445", "-m", "comment", "--comment", "OCP_API_LB_REDIRECT"}},
+			{"nat", "OUTPUT", []string{"--dst", "192.168.111.5", "-p", "tcp", "--dport", "6443", "-j", "REDIRECT", "--to-ports", "9445", "-m", "comment", "--comment", "OCP_API_LB_REDIRECT", "-o", "lo"}},
+		}))
+
+		rules = getRedirectRules(RuleModeDNATSNAT, apiRedirect("fd00::5", 6443, 9445))
+		Expect(rules).Should(HaveLen(3))
+		Expect(rules[0].spec).Should(ContainElement("[fd00::5]:9445"))
+		Expect(rules[2].chain).Should(Equal("INPUT"))
+		Expect(rules[2].spec).Should(ContainElement("SNAT"))
+
+		rules = getRedirectRules(RuleModeDNATMark, apiRedirect("192.168.111.5", 6443, 9445))
+		Expect(rules[0].table).Should(Equal("mangle"))
+		Expect(rules[1].spec).Should(ContainElement("DNAT"))
+	})
+
+	It("dedicated_chains", func() {
+		Expect(apiLBRuleSet.chain("PREROUTING")).Should(Equal("OCP-API-LB-PREROUTING"))
+		Expect(len(ingressLBRuleSet.chain("POSTROUTING"))).Should(BeNumerically("<=", 28))
+		Expect(apiLBRuleSet.jumpSpec("OUTPUT")).Should(Equal([]string{"-m", "comment", "--comment", "OCP_API_LB_REDIRECT", "-j", "OCP-API-LB-OUTPUT"}))
+	})
+
+	It("legacy_rules", func() {
+		Expect(legacyRuleSpec(`-A PREROUTING -d 192.168.111.5/32 -p tcp -m tcp --dport 6443 -m comment --comment OCP_API_LB_REDIRECT -j REDIRECT --to-ports 9445`)).Should(Equal(
+			[]string{"-d", "192.168.111.5/32", "-p", "tcp", "-m", "tcp", "--dport", "6443", "-m", "comment", "--comment", "OCP_API_LB_REDIRECT", "-j", "REDIRECT", "--to-ports", "9445"}))
+		Expect(legacyRuleSpec(`-A OUTPUT -m comment --comment "OCP_API_LB_REDIRECT" -j OCP-API-LB-OUTPUT`)).Should(BeNil())
+		Expect(legacyRuleSpec(`-A PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES`)).Should(BeNil())
+		Expect(legacyRuleSpec(`-P PREROUTING ACCEPT`)).Should(BeNil())
+	})
+
+	It("ingress_redirects", func() {
+		redirects := ingressRedirects([]net.IP{net.ParseIP("192.168.111.4"), net.ParseIP("fd00::4")}, []uint16{80, 1936})
+		Expect(redirects).Should(HaveLen(4))
+		Expect(redirects[1]).Should(Equal(portRedirect{"192.168.111.4", 1936, 1936, ingressLBRuleSet}))
+
+		rules := getRedirectRules(RuleModeRedirect, redirects[0])
+		Expect(rules[0].spec).Should(Equal([]string{"--dst", "192.168.111.4", "-p", "tcp", "--dport", "80", "-j", "REDIRECT", "--to-ports", "80", "-m", "comment", "--comment", "OCP_INGRESS_LB_REDIRECT"}))
+		Expect(getNftRules(RuleModeRedirect, redirects[3])[0].comment).Should(Equal("OCP_INGRESS_LB_REDIRECT redirect prerouting fd00::4:1936:1936"))
+	})
+
+	It("nft_rules", func() {
+		rules := getNftRules(RuleModeRedirect, apiRedirect("fd00::5", 6443, 9445))
+		Expect(rules).Should(Equal([]nftRule{
+			{"prerouting", "ip6 daddr fd00::5 tcp dport 6443 redirect to :9445", "OCP_API_LB_REDIRECT redirect prerouting fd00::5:6443:9445"},
+			{"output", `oifname "lo" ip6 daddr fd00::5 tcp dport 6443 redirect to :9445`, "OCP_API_LB_REDIRECT redirect output fd00::5:6443:9445"},
+		}))
+		for _, mode := range ruleModes {
+			for _, rule := range getNftRules(mode, apiRedirect("192.168.111.5", 6443, 9445)) {
+				Expect(nftChainHooks).Should(HaveKey(rule.chain))
+			}
+		}
+	})
+
+	It("nft_rule_handles", func() {
+		listing := `table ip ocp_api_lb {
+	chain output { # handle 2
+		type nat hook output priority -100; policy accept;
+		oifname "lo" ip daddr 192.168.111.6 tcp dport 6443 redirect to :9445 comment "OCP_API_LB_REDIRECT redirect output 192.168.111.6:6443:9445" # handle 7
+		oifname "lo" ip daddr 192.168.111.5 tcp dport 6443 redirect to :9445 comment "OCP_API_LB_REDIRECT redirect output 192.168.111.5:6443:9445" # handle 5
+	}
+}
+`
+		rules := getNftRules(RuleModeRedirect, apiRedirect("192.168.111.5", 6443, 9445))
+		Expect(findNftRuleHandles(listing, rules[1].comment)).Should(Equal([]string{"5"}))
+		Expect(findNftRuleHandles(listing, rules[0].comment)).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/events"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var (
+	firewallRulesPresent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_firewall_rules_present",
+		Help: "1 while the redirect rules of the VIP port are in place",
+	}, []string{"vip", "family", "port"})
+	firewallRuleRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_firewall_rule_repairs_total",
+		Help: "Number of times the redirect rules of the VIP port were found missing and restored",
+	}, []string{"vip", "family", "port"})
+)
+
+func init() {
+	prometheus.MustRegister(firewallRulesPresent, firewallRuleRepairs)
+}
+
+// firewallReconciler keeps the rules of port redirects in the desired state.
+// Rules deleted by something else after they were ensured are restored and
+// counted as repairs.
+type firewallReconciler struct {
+	redirects []portRedirect
+	desired   bool
+	// applied holds the redirects whose rules were ensured since they were
+	// last desired, a missing rule for them is drift
+	applied map[portRedirect]bool
+	repairs map[portRedirect]int
+	// events records the repairs, disabled when nil
+	events *events.Recorder
+
+	// swapped out by the tests
+	check  func(r portRedirect) (bool, error)
+	ensure func(r portRedirect) error
+	clean  func(r portRedirect) error
+}
+
+func newFirewallReconciler(backend firewallBackend, redirects []portRedirect) *firewallReconciler {
+	return &firewallReconciler{
+		redirects: redirects,
+		applied:   map[portRedirect]bool{},
+		repairs:   map[portRedirect]int{},
+		check:     backend.check,
+		ensure:    backend.ensure,
+		clean:     backend.clean,
+	}
+}
+
+// apiRedirects returns the redirects of the API ports of the API VIPs to
+// their HAProxy ports
+func apiRedirects(apiVips []string, ports []config.APIPort) []portRedirect {
+	redirects := []portRedirect{}
+	for _, apiVip := range apiVips {
+		for _, p := range ports {
+			redirects = append(redirects, apiRedirect(apiVip, p.Port, p.LbPort))
+		}
+	}
+	return redirects
+}
+
+func vipFamily(ip string) string {
+	if utils.IsIPv6(net.ParseIP(ip)) {
+		return vipFamilyIPv6
+	}
+	return vipFamilyIPv4
+}
+
+// setDesired sets whether the rules should be in place
+func (r *firewallReconciler) setDesired(present bool) {
+	if present != r.desired {
+		r.applied = map[portRedirect]bool{}
+	}
+	r.desired = present
+}
+
+// reconcile brings the rules of every redirect to the desired state
+func (r *firewallReconciler) reconcile() {
+	for _, redirect := range r.redirects {
+		if r.desired {
+			r.reconcileRedirect(redirect)
+			continue
+		}
+		if err := r.clean(redirect); err != nil {
+			log.WithFields(logrus.Fields{
+				"vip":  redirect.vip,
+				"port": redirect.port,
+			}).WithError(err).Warn("Failed to clean firewall rules")
+		}
+		redirect.presentMetric().Set(0)
+	}
+}
+
+func (r *firewallReconciler) reconcileRedirect(redirect portRedirect) {
+	present, err := r.check(redirect)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"vip":  redirect.vip,
+			"port": redirect.port,
+		}).WithError(err).Warn("Failed to check firewall rules")
+	}
+	if present {
+		r.applied[redirect] = true
+		redirect.presentMetric().Set(1)
+		return
+	}
+
+	if err := r.ensure(redirect); err != nil {
+		log.WithFields(logrus.Fields{
+			"vip":  redirect.vip,
+			"port": redirect.port,
+			"err":  err,
+		}).Error("Failed to ensure firewall rules to direct traffic to the LB")
+		redirect.presentMetric().Set(0)
+		return
+	}
+	redirect.presentMetric().Set(1)
+	if r.applied[redirect] {
+		r.repairs[redirect]++
+		firewallRuleRepairs.WithLabelValues(redirect.metricLabels()...).Inc()
+		log.WithFields(logrus.Fields{
+			"vip":     redirect.vip,
+			"port":    redirect.port,
+			"repairs": r.repairs[redirect],
+		}).Warn("Restored missing firewall rules")
+		r.events.Warning(events.ReasonFirewallRuleRepaired, "Restored the missing firewall rules of %s port %d", redirect.vip, redirect.port)
+	}
+	r.applied[redirect] = true
+}
+
+func (r portRedirect) metricLabels() []string {
+	return []string{r.vip, vipFamily(r.vip), strconv.Itoa(int(r.port))}
+}
+
+func (r portRedirect) presentMetric() prometheus.Gauge {
+	return firewallRulesPresent.WithLabelValues(r.metricLabels()...)
+}
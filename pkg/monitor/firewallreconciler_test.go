@@ -0,0 +1,75 @@
+package monitor
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("firewall_reconciler", func() {
+	var rules map[portRedirect]bool
+	var r *firewallReconciler
+	v4 := apiRedirect("192.168.111.5", 6443, 9445)
+	v6 := apiRedirect("fd00::5", 6443, 9445)
+
+	BeforeEach(func() {
+		rules = map[portRedirect]bool{}
+		r = newFirewallReconciler(iptablesBackend{}, apiRedirects([]string{"192.168.111.5", "fd00::5"}, config.APIPorts(6443, 9445, nil)))
+		r.check = func(redirect portRedirect) (bool, error) {
+			return rules[redirect], nil
+		}
+		r.ensure = func(redirect portRedirect) error {
+			rules[redirect] = true
+			return nil
+		}
+		r.clean = func(redirect portRedirect) error {
+			delete(rules, redirect)
+			return nil
+		}
+	})
+
+	It("ensures_desired_rules", func() {
+		r.setDesired(true)
+		r.reconcile()
+		Expect(rules).Should(Equal(map[portRedirect]bool{v4: true, v6: true}))
+		Expect(r.repairs).Should(BeEmpty())
+		Expect(metricValue(firewallRulesPresent.WithLabelValues("fd00::5", vipFamilyIPv6, "6443"))).Should(Equal(1.0))
+	})
+
+	It("repairs_drift", func() {
+		r.setDesired(true)
+		r.reconcile()
+		before := metricValue(firewallRuleRepairs.WithLabelValues("fd00::5", vipFamilyIPv6, "6443"))
+
+		delete(rules, v6)
+		r.reconcile()
+		Expect(rules[v6]).Should(BeTrue())
+		Expect(r.repairs).Should(Equal(map[portRedirect]int{v6: 1}))
+		Expect(metricValue(firewallRuleRepairs.WithLabelValues("fd00::5", vipFamilyIPv6, "6443"))).Should(Equal(before + 1))
+	})
+
+	It("cleans_undesired_rules", func() {
+		r.setDesired(true)
+		r.reconcile()
+		r.setDesired(false)
+		r.reconcile()
+		Expect(rules).Should(BeEmpty())
+
+		By("not_counting_a_new_ensure_as_repair", func() {
+			r.setDesired(true)
+			r.reconcile()
+			Expect(r.repairs).Should(BeEmpty())
+		})
+	})
+
+	It("redirects_the_extra_api_ports", func() {
+		ports := config.APIPorts(6443, 9445, []config.APIPort{{Port: 443, LbPort: 9443}})
+		Expect(apiRedirects([]string{"192.168.111.5", "fd00::5"}, ports)).Should(Equal([]portRedirect{
+			v4,
+			apiRedirect("192.168.111.5", 443, 9443),
+			v6,
+			apiRedirect("fd00::5", 443, 9443),
+		}))
+	})
+})
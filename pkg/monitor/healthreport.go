@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"net"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
+)
+
+func newHealthReporter(opts Options, component string) *health.Publisher {
+	return health.NewPublisher(opts.KubeconfigPath, opts.NodeName, component, opts.HealthHeartbeat)
+}
+
+// localAddrs is swapped out by the tests
+var localAddrs = net.InterfaceAddrs
+
+// heldVIPs returns the VIPs of vipLists assigned to an interface of the node
+func heldVIPs(vipLists ...[]net.IP) []string {
+	addrs, err := localAddrs()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list the local addresses")
+		return nil
+	}
+	held := []string{}
+	for _, vips := range vipLists {
+		for _, vip := range vips {
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(vip) {
+					held = append(held, vip.String())
+					break
+				}
+			}
+		}
+	}
+	return held
+}
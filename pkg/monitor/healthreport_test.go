@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("held_vips", func() {
+	AfterEach(func() {
+		localAddrs = net.InterfaceAddrs
+	})
+
+	It("lists_the_local_vips", func() {
+		localAddrs = func() ([]net.Addr, error) {
+			return []net.Addr{
+				&net.IPNet{IP: net.ParseIP("192.168.111.20"), Mask: net.CIDRMask(24, 32)},
+				&net.IPNet{IP: net.ParseIP("192.168.111.5"), Mask: net.CIDRMask(32, 32)},
+			}, nil
+		}
+		Expect(heldVIPs([]net.IP{net.ParseIP("192.168.111.5")}, []net.IP{net.ParseIP("192.168.111.4")})).To(Equal([]string{"192.168.111.5"}))
+	})
+})
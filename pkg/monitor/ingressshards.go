@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// populateIngressShards sets the ingress shards of node, keeping the ones of
+// prev when the IngressControllers cannot be read. Nothing is done without
+// opts.GatherIngressShards.
+func populateIngressShards(opts Options, node *config.Node, prev []config.IngressShard) {
+	if !opts.GatherIngressShards {
+		return
+	}
+	if err := config.PopulateIngressShards(opts.Config, opts.KubeconfigPath, node); err != nil {
+		log.WithError(err).Warn("Failed to get the ingress shards, keeping previous ones")
+		node.IngressShards = prev
+	}
+}
+
+// populateRouterReadiness sets whether node has no ready router, keeping the
+// state of prev when the router endpoints cannot be read. Nothing is done
+// without opts.GateAppsWildcard.
+func populateRouterReadiness(opts Options, node *config.Node, prev bool) {
+	if !opts.GateAppsWildcard {
+		return
+	}
+	ready, err := config.RoutersReady(opts.KubeconfigPath)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get the router endpoints, keeping the previous *.apps record")
+		node.Cluster.NoReadyRouters = prev
+		return
+	}
+	node.Cluster.NoReadyRouters = !ready
+}
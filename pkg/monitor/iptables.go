@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
@@ -10,19 +11,120 @@ import (
 )
 
 const (
-	table       = "nat"
-	isLoopback  = true
-	notLoopback = false
+	// RuleModeAuto picks the rule mode from the network type of the cluster
+	RuleModeAuto = "auto"
+	// RuleModeRedirect redirects the API port of the VIP to the HAProxy port
+	RuleModeRedirect = "redirect"
+	// RuleModeDNATSNAT DNATs the API port of the VIP to the HAProxy port and
+	// SNATs the connections to the VIP, so that the replies never leave the
+	// node through the CNI. Needed by CNIs that handle pod traffic to host
+	// addresses themselves, such as Cilium.
+	RuleModeDNATSNAT = "dnat-snat"
+	// RuleModeDNATMark marks the API connections to the VIP before DNATing
+	// only the marked ones, for CNIs that rewrite the destination before the
+	// nat table is reached
+	RuleModeDNATMark = "dnat-mark"
+
+	apiLBRedirectComment = "OCP_API_LB_REDIRECT"
+	// apiLBMark is not used by kube-proxy (0x4000, 0x8000) nor OVN-Kubernetes
+	apiLBMark = "0x2000"
 )
 
-func getHAProxyRuleSpec(apiVip string, apiPort, lbPort uint16, loopback bool) (ruleSpec []string, err error) {
-	apiPortStr := strconv.Itoa(int(apiPort))
-	lbPortStr := strconv.Itoa(int(lbPort))
-	ruleSpec = []string{"--dst", apiVip, "-p", "tcp", "--dport", apiPortStr, "-j", "REDIRECT", "--to-ports", lbPortStr, "-m", "comment", "--comment", "OCP_API_LB_REDIRECT"}
-	if loopback {
-		ruleSpec = append(ruleSpec, "-o", "lo")
+var ruleModes = []string{RuleModeRedirect, RuleModeDNATSNAT, RuleModeDNATMark}
+
+// DefaultRuleMode returns the rule mode that works with the given cluster
+// network type
+func DefaultRuleMode(networkType string) string {
+	switch strings.ToLower(networkType) {
+	case "cilium":
+		return RuleModeDNATSNAT
+	}
+	return RuleModeRedirect
+}
+
+// ValidateRuleMode checks that mode is a known rule mode
+func ValidateRuleMode(mode string) error {
+	for _, m := range append(ruleModes, RuleModeAuto) {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown firewall rule mode %q", mode)
+}
+
+// iptablesBackend manages the rules with the iptables binaries, which use
+// either the legacy xtables or the nftables compat layer of the host
+type iptablesBackend struct {
+	// mode is how the traffic is sent to the target port
+	mode string
+}
+
+// RuleManager is the part of go-iptables the iptables backend uses, so that
+// the rule logic runs against a fake in the tests
+type RuleManager interface {
+	ListChains(table string) ([]string, error)
+	NewChain(table, chain string) error
+	ClearChain(table, chain string) error
+	DeleteChain(table, chain string) error
+	List(table, chain string) ([]string, error)
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	Insert(table, chain string, pos int, rulespec ...string) error
+	Append(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+}
+
+// newRuleManager returns the RuleManager of proto, running the iptables
+// binaries. Swapped out by the tests.
+var newRuleManager = func(proto iptables.Protocol) (RuleManager, error) {
+	return iptables.NewWithProtocol(proto)
+}
+
+type iptablesRule struct {
+	table string
+	chain string
+	spec  []string
+}
+
+func hostPort(ip string, port uint16) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// getRedirectRules returns the rules of mode sending the traffic of the VIP
+// port to the target port, both for remote clients and for the node itself
+func getRedirectRules(mode string, r portRedirect) []iptablesRule {
+	targetPortStr := strconv.Itoa(int(r.targetPort))
+	comment := []string{"-m", "comment", "--comment", r.set.comment}
+	portMatch := []string{"--dst", r.vip, "-p", "tcp", "--dport", strconv.Itoa(int(r.port))}
+	join := func(parts ...[]string) []string {
+		spec := []string{}
+		for _, p := range parts {
+			spec = append(spec, p...)
+		}
+		return spec
+	}
+	loopback := []string{"-o", "lo"}
+
+	switch mode {
+	case RuleModeDNATSNAT:
+		dnat := []string{"-j", "DNAT", "--to-destination", hostPort(r.vip, r.targetPort)}
+		return []iptablesRule{
+			{"nat", "PREROUTING", join(portMatch, dnat, comment)},
+			{"nat", "OUTPUT", join(portMatch, dnat, comment, loopback)},
+			{"nat", "INPUT", join([]string{"--dst", r.vip, "-p", "tcp", "--dport", targetPortStr}, []string{"-j", "SNAT", "--to-source", r.vip}, comment)},
+		}
+	case RuleModeDNATMark:
+		dnat := []string{"-m", "mark", "--mark", apiLBMark + "/" + apiLBMark, "-j", "DNAT", "--to-destination", hostPort(r.vip, r.targetPort)}
+		return []iptablesRule{
+			{"mangle", "PREROUTING", join(portMatch, []string{"-j", "MARK", "--set-xmark", apiLBMark + "/" + apiLBMark}, comment)},
+			{"nat", "PREROUTING", join(portMatch, dnat, comment)},
+			{"nat", "OUTPUT", join(portMatch, []string{"-j", "DNAT", "--to-destination", hostPort(r.vip, r.targetPort)}, comment, loopback)},
+		}
+	}
+	redirect := []string{"-j", "REDIRECT", "--to-ports", targetPortStr}
+	return []iptablesRule{
+		{"nat", "PREROUTING", join(portMatch, redirect, comment)},
+		{"nat", "OUTPUT", join(portMatch, redirect, comment, loopback)},
 	}
-	return ruleSpec, err
 }
 
 func getProtocolbyIp(ipStr string) iptables.Protocol {
@@ -33,98 +135,263 @@ func getProtocolbyIp(ipStr string) iptables.Protocol {
 	return iptables.ProtocolIPv6
 }
 
-func cleanHAProxyFirewallRules(apiVip string, apiPort, lbPort uint16) error {
-	ipt, err := iptables.NewWithProtocol(getProtocolbyIp(apiVip))
+// ruleSet groups the rules managed together. Each set lives in its own
+// chains, jumped to from the top of the built-in ones, so that other agents
+// inserting rules cannot reorder them and a set can be flushed as a whole.
+type ruleSet struct {
+	comment     string
+	chainPrefix string
+	nftTable    string
+}
+
+var (
+	apiLBRuleSet     = ruleSet{apiLBRedirectComment, "OCP-API-LB-", "ocp_api_lb"}
+	ingressLBRuleSet = ruleSet{"OCP_INGRESS_LB_REDIRECT", "OCP-INGRESS-LB-", "ocp_ingress_lb"}
+)
+
+// chain returns the chain of the set holding the rules of builtin
+func (set ruleSet) chain(builtin string) string {
+	return set.chainPrefix + builtin
+}
+
+func (set ruleSet) jumpSpec(builtin string) []string {
+	return []string{"-m", "comment", "--comment", set.comment, "-j", set.chain(builtin)}
+}
+
+// ensureChain creates the chain of builtin and the jump to it. It returns
+// true if the jump was missing.
+func (set ruleSet) ensureChain(ipt RuleManager, table, builtin string) (bool, error) {
+	chains, err := ipt.ListChains(table)
 	if err != nil {
-		return err
+		return false, err
+	}
+	found := false
+	for _, c := range chains {
+		found = found || c == set.chain(builtin)
+	}
+	if !found {
+		if err := ipt.NewChain(table, set.chain(builtin)); err != nil {
+			return false, err
+		}
 	}
 
-	ruleSpec, err := getHAProxyRuleSpec(apiVip, apiPort, lbPort, notLoopback)
+	if exists, _ := ipt.Exists(table, builtin, set.jumpSpec(builtin)...); exists {
+		return false, nil
+	}
+	log.WithFields(logrus.Fields{
+		"chain": set.chain(builtin),
+	}).Infof("Inserting jump from %s %s", table, builtin)
+	return true, ipt.Insert(table, builtin, 1, set.jumpSpec(builtin)...)
+}
+
+// deleteIptablesRules removes rules from their chains or, for legacy, from
+// the built-in chains where previous versions inserted them
+func deleteIptablesRules(ipt RuleManager, set ruleSet, rules []iptablesRule, legacy bool) error {
+	for _, rule := range rules {
+		chain := set.chain(rule.chain)
+		if legacy {
+			chain = rule.chain
+		}
+		if exists, _ := ipt.Exists(rule.table, chain, rule.spec...); exists {
+			log.WithFields(logrus.Fields{
+				"spec": strings.Join(rule.spec, " "),
+			}).Infof("Removing existing %s %s rule", rule.table, chain)
+			if err := ipt.Delete(rule.table, chain, rule.spec...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clean removes the rules of every mode, so that nothing is left behind
+// after the mode was changed
+func (iptablesBackend) clean(r portRedirect) error {
+	ipt, err := newRuleManager(getProtocolbyIp(r.vip))
 	if err != nil {
 		return err
 	}
 
-	chain := "PREROUTING"
-	if exists, _ := ipt.Exists(table, chain, ruleSpec...); exists {
-		log.WithFields(logrus.Fields{
-			"spec": strings.Join(ruleSpec, " "),
-		}).Info("Removing existing nat PREROUTING rule")
-		err = ipt.Delete(table, chain, ruleSpec...)
-		if err != nil {
+	for _, mode := range ruleModes {
+		rules := getRedirectRules(mode, r)
+		if err := deleteIptablesRules(ipt, r.set, rules, false); err != nil {
+			return err
+		}
+		if err := deleteIptablesRules(ipt, r.set, rules, true); err != nil {
 			return err
 		}
 	}
-	ruleSpec, err = getHAProxyRuleSpec(apiVip, apiPort, lbPort, isLoopback)
+	return nil
+}
+
+// ensure adds the missing rules of the current mode, after removing the
+// rules left by a previous mode
+func (b iptablesBackend) ensure(r portRedirect) error {
+	ipt, err := newRuleManager(getProtocolbyIp(r.vip))
 	if err != nil {
 		return err
 	}
-	chain = "OUTPUT"
-	if exists, _ := ipt.Exists(table, chain, ruleSpec...); exists {
+
+	migrate := false
+	for _, rule := range getRedirectRules(b.mode, r) {
+		added, err := r.set.ensureChain(ipt, rule.table, rule.chain)
+		if err != nil {
+			return err
+		}
+		migrate = migrate || added
+	}
+
+	for _, mode := range ruleModes {
+		rules := getRedirectRules(mode, r)
+		// Rules in the built-in chains predate the dedicated chains and
+		// can only be there when the jumps were not
+		if migrate {
+			if err := deleteIptablesRules(ipt, r.set, rules, true); err != nil {
+				return err
+			}
+		}
+		if mode == b.mode {
+			continue
+		}
+		if err := deleteIptablesRules(ipt, r.set, rules, false); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range getRedirectRules(b.mode, r) {
+		chain := r.set.chain(rule.chain)
+		if exists, _ := ipt.Exists(rule.table, chain, rule.spec...); exists {
+			continue
+		}
 		log.WithFields(logrus.Fields{
-			"spec": strings.Join(ruleSpec, " "),
-		}).Info("Removing existing nat OUTPUT rule")
-		return ipt.Delete(table, chain, ruleSpec...)
+			"spec": strings.Join(rule.spec, " "),
+		}).Infof("Appending %s %s rule", rule.table, chain)
+		if err := ipt.Append(rule.table, chain, rule.spec...); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func ensureHAProxyFirewallRules(apiVip string, apiPort, lbPort uint16) error {
-	ipt, err := iptables.NewWithProtocol(getProtocolbyIp(apiVip))
+func (b iptablesBackend) check(r portRedirect) (bool, error) {
+	ipt, err := newRuleManager(getProtocolbyIp(r.vip))
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	ruleSpec, err := getHAProxyRuleSpec(apiVip, apiPort, lbPort, notLoopback)
-	if err != nil {
-		return err
+	for _, rule := range getRedirectRules(b.mode, r) {
+		for chain, spec := range map[string][]string{
+			rule.chain:              r.set.jumpSpec(rule.chain),
+			r.set.chain(rule.chain): rule.spec,
+		} {
+			exists, err := ipt.Exists(rule.table, chain, spec...)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				return false, nil
+			}
+		}
 	}
-	chain := "PREROUTING"
-	if exists, _ := ipt.Exists(table, chain, ruleSpec...); exists {
-		return nil
+	return true, nil
+}
+
+// flush removes the chains of set, the jumps to them and any rule left in
+// the built-in chains by previous versions, for both IP families
+func (iptablesBackend) flush(set ruleSet) error {
+	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		ipt, err := newRuleManager(proto)
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"nat", "mangle"} {
+			chains, err := ipt.ListChains(table)
+			if err != nil {
+				return err
+			}
+			for _, chain := range chains {
+				if strings.HasPrefix(chain, set.chainPrefix) {
+					if err := set.flushChain(ipt, table, chain); err != nil {
+						return err
+					}
+					continue
+				}
+				// Only the API rules predate the dedicated chains
+				if set != apiLBRuleSet {
+					continue
+				}
+				if err := deleteLegacyRules(ipt, table, chain); err != nil {
+					return err
+				}
+			}
+		}
 	}
-	log.WithFields(logrus.Fields{
-		"spec": strings.Join(ruleSpec, " "),
-	}).Info("Inserting nat PREROUTING rule")
-	err = ipt.Insert(table, chain, 1, ruleSpec...)
-	if err != nil {
-		return err
+	return nil
+}
+
+func (set ruleSet) flushChain(ipt RuleManager, table, chain string) error {
+	builtin := strings.TrimPrefix(chain, set.chainPrefix)
+	for {
+		exists, _ := ipt.Exists(table, builtin, set.jumpSpec(builtin)...)
+		if !exists {
+			break
+		}
+		if err := ipt.Delete(table, builtin, set.jumpSpec(builtin)...); err != nil {
+			return err
+		}
 	}
-	ruleSpec, err = getHAProxyRuleSpec(apiVip, apiPort, lbPort, isLoopback)
-	if err != nil {
+	if err := ipt.ClearChain(table, chain); err != nil {
 		return err
 	}
-	chain = "OUTPUT"
-	if exists, _ := ipt.Exists(table, chain, ruleSpec...); exists {
-		return nil
-	}
 	log.WithFields(logrus.Fields{
-		"spec": strings.Join(ruleSpec, " "),
-	}).Info("Inserting nat OUTPUT rule")
-	return ipt.Insert(table, chain, 1, ruleSpec...)
+		"table": table,
+		"chain": chain,
+	}).Info("Deleting chain")
+	return ipt.DeleteChain(table, chain)
 }
 
-func checkHAProxyFirewallRules(apiVip string, apiPort, lbPort uint16) (bool, error) {
-	ipt, err := iptables.NewWithProtocol(getProtocolbyIp(apiVip))
+// deleteLegacyRules removes the rules with the redirect comment from chain
+func deleteLegacyRules(ipt RuleManager, table, chain string) error {
+	rules, err := ipt.List(table, chain)
 	if err != nil {
-		return false, err
-	}
-
-	ruleSpec, err := getHAProxyRuleSpec(apiVip, apiPort, lbPort, notLoopback)
-	if err != nil {
-		return false, err
+		return err
 	}
-	preroutingExists, err := ipt.Exists(table, "PREROUTING", ruleSpec...)
-	if err != nil {
-		return false, err
+	for _, rule := range rules {
+		spec := legacyRuleSpec(rule)
+		if spec == nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"spec": strings.Join(spec, " "),
+		}).Infof("Removing existing %s %s rule", table, chain)
+		if err := ipt.Delete(table, chain, spec...); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	ruleSpec, err = getHAProxyRuleSpec(apiVip, apiPort, lbPort, isLoopback)
-	if err != nil {
-		return false, err
+// legacyRuleSpec returns the spec of a rule listed by iptables -S if it is a
+// redirect rule inserted directly in a built-in chain, nil otherwise
+func legacyRuleSpec(rule string) []string {
+	fields := strings.Fields(rule)
+	if len(fields) < 3 || fields[0] != "-A" {
+		return nil
 	}
-	outputExists, err := ipt.Exists(table, "OUTPUT", ruleSpec...)
-	if err != nil {
-		return false, err
+	spec := []string{}
+	commented := false
+	for i, f := range fields[2:] {
+		f = strings.Trim(f, "\"")
+		if f == apiLBRedirectComment && i > 0 && fields[i+1] == "--comment" {
+			commented = true
+		}
+		if strings.HasPrefix(f, apiLBRuleSet.chainPrefix) {
+			return nil
+		}
+		spec = append(spec, f)
+	}
+	if !commented {
+		return nil
 	}
-	return (preroutingExists && outputExists), nil
+	return spec
 }
@@ -0,0 +1,257 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRuleManager keeps the rules of its chains in memory, in the order
+// iptables would list them
+type fakeRuleManager struct {
+	chains map[string]map[string][]string
+}
+
+func newFakeRuleManager() *fakeRuleManager {
+	return &fakeRuleManager{chains: map[string]map[string][]string{
+		"nat":    {"PREROUTING": nil, "INPUT": nil, "OUTPUT": nil, "POSTROUTING": nil},
+		"mangle": {"PREROUTING": nil, "INPUT": nil, "FORWARD": nil, "OUTPUT": nil, "POSTROUTING": nil},
+	}}
+}
+
+func (f *fakeRuleManager) chain(table, chain string) ([]string, error) {
+	rules, ok := f.chains[table][chain]
+	if !ok {
+		return nil, fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+	return rules, nil
+}
+
+func (f *fakeRuleManager) ListChains(table string) ([]string, error) {
+	chains := []string{}
+	for chain := range f.chains[table] {
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
+func (f *fakeRuleManager) NewChain(table, chain string) error {
+	if _, ok := f.chains[table][chain]; ok {
+		return fmt.Errorf("Chain %s already exists", chain)
+	}
+	f.chains[table][chain] = nil
+	return nil
+}
+
+func (f *fakeRuleManager) ClearChain(table, chain string) error {
+	f.chains[table][chain] = nil
+	return nil
+}
+
+func (f *fakeRuleManager) DeleteChain(table, chain string) error {
+	rules, err := f.chain(table, chain)
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		return fmt.Errorf("Chain %s is not empty", chain)
+	}
+	delete(f.chains[table], chain)
+	return nil
+}
+
+func (f *fakeRuleManager) List(table, chain string) ([]string, error) {
+	rules, err := f.chain(table, chain)
+	if err != nil {
+		return nil, err
+	}
+	listed := []string{"-P " + chain + " ACCEPT"}
+	for _, rule := range rules {
+		listed = append(listed, "-A "+chain+" "+rule)
+	}
+	return listed, nil
+}
+
+// Exists is false for a missing chain, iptables -C exits with 1 for both
+func (f *fakeRuleManager) Exists(table, chain string, rulespec ...string) (bool, error) {
+	for _, rule := range f.chains[table][chain] {
+		if rule == strings.Join(rulespec, " ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRuleManager) Insert(table, chain string, pos int, rulespec ...string) error {
+	rules, err := f.chain(table, chain)
+	if err != nil {
+		return err
+	}
+	rules = append(rules[:pos-1:pos-1], append([]string{strings.Join(rulespec, " ")}, rules[pos-1:]...)...)
+	f.chains[table][chain] = rules
+	return nil
+}
+
+func (f *fakeRuleManager) Append(table, chain string, rulespec ...string) error {
+	rules, err := f.chain(table, chain)
+	if err != nil {
+		return err
+	}
+	f.chains[table][chain] = append(rules, strings.Join(rulespec, " "))
+	return nil
+}
+
+func (f *fakeRuleManager) Delete(table, chain string, rulespec ...string) error {
+	rules, err := f.chain(table, chain)
+	if err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		if rule == strings.Join(rulespec, " ") {
+			f.chains[table][chain] = append(rules[:i:i], rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("No rule %q in chain %s", strings.Join(rulespec, " "), chain)
+}
+
+var _ = Describe("iptables", func() {
+	var managers map[iptables.Protocol]*fakeRuleManager
+	origNewRuleManager := newRuleManager
+
+	BeforeEach(func() {
+		managers = map[iptables.Protocol]*fakeRuleManager{
+			iptables.ProtocolIPv4: newFakeRuleManager(),
+			iptables.ProtocolIPv6: newFakeRuleManager(),
+		}
+		newRuleManager = func(proto iptables.Protocol) (RuleManager, error) {
+			return managers[proto], nil
+		}
+	})
+
+	AfterEach(func() {
+		newRuleManager = origNewRuleManager
+	})
+
+	It("rule_specs", func() {
+		comment := []string{"-m", "comment", "--comment", "OCP_API_LB_REDIRECT"}
+		with := func(parts ...[]string) []string {
+			spec := []string{}
+			for _, p := range parts {
+				spec = append(spec, p...)
+			}
+			return spec
+		}
+		for _, c := range []struct {
+			mode   string
+			vip    string
+			target string
+		}{
+			{RuleModeRedirect, "192.168.111.5", "192.168.111.5:9445"},
+			{RuleModeRedirect, "fd00::5", "[fd00::5]:9445"},
+			{RuleModeDNATSNAT, "192.168.111.5", "192.168.111.5:9445"},
+			{RuleModeDNATSNAT, "fd00::5", "[fd00::5]:9445"},
+			{RuleModeDNATMark, "192.168.111.5", "192.168.111.5:9445"},
+			{RuleModeDNATMark, "fd00::5", "[fd00::5]:9445"},
+		} {
+			match := []string{"--dst", c.vip, "-p", "tcp", "--dport", "6443"}
+			want := map[string][]iptablesRule{
+				RuleModeRedirect: {
+					{"nat", "PREROUTING", with(match, []string{"-j", "REDIRECT", "--to-ports", "9445"}, comment)},
+					{"nat", "OUTPUT", with(match, []string{"-j", "REDIRECT", "--to-ports", "9445"}, comment, []string{"-o", "lo"})},
+				},
+				RuleModeDNATSNAT: {
+					{"nat", "PREROUTING", with(match, []string{"-j", "DNAT", "--to-destination", c.target}, comment)},
+					{"nat", "OUTPUT", with(match, []string{"-j", "DNAT", "--to-destination", c.target}, comment, []string{"-o", "lo"})},
+					{"nat", "INPUT", with([]string{"--dst", c.vip, "-p", "tcp", "--dport", "9445", "-j", "SNAT", "--to-source", c.vip}, comment)},
+				},
+				RuleModeDNATMark: {
+					{"mangle", "PREROUTING", with(match, []string{"-j", "MARK", "--set-xmark", "0x2000/0x2000"}, comment)},
+					{"nat", "PREROUTING", with(match, []string{"-m", "mark", "--mark", "0x2000/0x2000", "-j", "DNAT", "--to-destination", c.target}, comment)},
+					{"nat", "OUTPUT", with(match, []string{"-j", "DNAT", "--to-destination", c.target}, comment, []string{"-o", "lo"})},
+				},
+			}[c.mode]
+			Expect(getRedirectRules(c.mode, apiRedirect(c.vip, 6443, 9445))).Should(Equal(want), "%s %s", c.mode, c.vip)
+		}
+		Expect(getProtocolbyIp("192.168.111.5")).Should(Equal(iptables.ProtocolIPv4))
+		Expect(getProtocolbyIp("fd00::5")).Should(Equal(iptables.ProtocolIPv6))
+	})
+
+	It("ensures_checks_and_cleans_the_rules", func() {
+		for _, vip := range []string{"192.168.111.5", "fd00::5"} {
+			r := apiRedirect(vip, 6443, 9445)
+			ipt := managers[getProtocolbyIp(vip)]
+			for _, mode := range ruleModes {
+				b := iptablesBackend{mode: mode}
+				ok, err := b.check(r)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).Should(BeFalse(), "%s %s", mode, vip)
+
+				Expect(b.ensure(r)).Should(Succeed())
+				ok, err = b.check(r)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).Should(BeTrue(), "%s %s", mode, vip)
+
+				// Only the rules of the current mode are left
+				count := 0
+				for _, table := range []string{"nat", "mangle"} {
+					for chain, rules := range ipt.chains[table] {
+						if strings.HasPrefix(chain, apiLBRuleSet.chainPrefix) {
+							count += len(rules)
+						}
+					}
+				}
+				Expect(count).Should(Equal(len(getRedirectRules(mode, r))), "%s %s", mode, vip)
+			}
+
+			// The rules of the last mode are the ones left
+			b := iptablesBackend{mode: ruleModes[len(ruleModes)-1]}
+			Expect(b.clean(r)).Should(Succeed())
+			ok, err := b.check(r)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).Should(BeFalse())
+		}
+		Expect(managers[iptables.ProtocolIPv4].chains["nat"]["OCP-API-LB-PREROUTING"]).Should(BeEmpty())
+	})
+
+	It("migrates_the_rules_of_the_builtin_chains", func() {
+		r := apiRedirect("192.168.111.5", 6443, 9445)
+		ipt := managers[iptables.ProtocolIPv4]
+		for _, rule := range getRedirectRules(RuleModeRedirect, r) {
+			Expect(ipt.Append(rule.table, rule.chain, rule.spec...)).Should(Succeed())
+		}
+		Expect(ipt.Append("nat", "PREROUTING", "-j", "KUBE-SERVICES")).Should(Succeed())
+
+		Expect(iptablesBackend{mode: RuleModeRedirect}.ensure(r)).Should(Succeed())
+		Expect(ipt.chains["nat"]["PREROUTING"]).Should(Equal([]string{
+			strings.Join(apiLBRuleSet.jumpSpec("PREROUTING"), " "),
+			"-j KUBE-SERVICES",
+		}))
+		Expect(ipt.chains["nat"]["OCP-API-LB-OUTPUT"]).Should(HaveLen(1))
+	})
+
+	It("flushes_the_rule_sets", func() {
+		b := iptablesBackend{mode: RuleModeDNATMark}
+		Expect(b.ensure(apiRedirect("192.168.111.5", 6443, 9445))).Should(Succeed())
+		Expect(b.ensure(apiRedirect("fd00::5", 6443, 9445))).Should(Succeed())
+		Expect(b.ensure(portRedirect{"192.168.111.4", 80, 80, ingressLBRuleSet})).Should(Succeed())
+		legacy := getRedirectRules(RuleModeRedirect, apiRedirect("192.168.111.6", 6443, 9445))[0]
+		Expect(managers[iptables.ProtocolIPv4].Append(legacy.table, legacy.chain, legacy.spec...)).Should(Succeed())
+
+		Expect(b.flush(apiLBRuleSet)).Should(Succeed())
+		for _, ipt := range managers {
+			for _, table := range []string{"nat", "mangle"} {
+				for chain, rules := range ipt.chains[table] {
+					Expect(chain).ShouldNot(HavePrefix(apiLBRuleSet.chainPrefix))
+					for _, rule := range rules {
+						Expect(rule).ShouldNot(ContainSubstring(apiLBRedirectComment))
+					}
+				}
+			}
+		}
+		Expect(managers[iptables.ProtocolIPv4].chains["nat"]).Should(HaveKey("OCP-INGRESS-LB-PREROUTING"))
+	})
+})
@@ -1,14 +1,17 @@
 package monitor
 
 import (
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 	"github.com/sirupsen/logrus"
@@ -19,13 +22,74 @@ import (
 
 const MonitorConfFileName = "unsupported-monitor.conf"
 const leaseFile = "lease-%s"
+const lease6File = "lease6-%s"
+
+const (
+	vipFamilyIPv4 = "ipv4"
+	vipFamilyIPv6 = "ipv6"
+)
+
+// monitorConfVersion is the latest unsupported-monitor.conf schema version.
+// Version 2 adds the per-VIP interface, vlan and hostname settings.
+const monitorConfVersion = 2
 
 type vip struct {
 	Name       string `yaml:"name"`
 	MacAddress string `yaml:"mac-address"`
 	IpAddress  string `yaml:"ip-address"`
+	// Family selects DHCPv4 or DHCPv6 leasing when IpAddress is empty,
+	// otherwise the family of IpAddress is used
+	Family string `yaml:"family,omitempty"`
+	// Interface is the parent of the macvlan, the interface of the API VIP
+	// when empty. Requires version 2.
+	Interface string `yaml:"interface,omitempty"`
+	// VLAN creates the macvlan on a VLAN subinterface of the parent.
+	// Requires version 2.
+	VLAN int `yaml:"vlan,omitempty"`
+	// Hostname sent in the DHCP request instead of the one derived from the
+	// MAC address. Requires version 2.
+	Hostname string `yaml:"hostname,omitempty"`
+}
+
+func (v vip) hostname(mac net.HardwareAddr) string {
+	if v.Hostname != "" {
+		return v.Hostname
+	}
+	return formatHostname(mac.String(), v.Name)
+}
+
+// validate checks the fields of the vip against the schema version
+func (v vip) validate(version int) error {
+	if v.Family != "" && v.Family != vipFamilyIPv4 && v.Family != vipFamilyIPv6 {
+		return fmt.Errorf("Invalid family %s for vip %s", v.Family, v.Name)
+	}
+	if version < 2 && (v.Interface != "" || v.VLAN != 0 || v.Hostname != "") {
+		return fmt.Errorf("The interface, vlan and hostname settings of vip %s require version: 2", v.Name)
+	}
+	if v.VLAN < 0 || v.VLAN > 4094 {
+		return fmt.Errorf("Invalid vlan %d for vip %s", v.VLAN, v.Name)
+	}
+	return nil
 }
+
+func (v vip) isIPv6() bool {
+	if ip := net.ParseIP(v.IpAddress); ip != nil {
+		return ip.To4() == nil
+	}
+	return v.Family == vipFamilyIPv6
+}
+
+// leaseFile returns the lease file of the VIP next to cfgPath
+func (v vip) leaseFile(cfgPath string) string {
+	if v.isIPv6() {
+		return GetLease6File(cfgPath, v.Name)
+	}
+	return GetLeaseFile(cfgPath, v.Name)
+}
+
 type yamlVips struct {
+	// Schema version, 1 when not set
+	Version int `yaml:"version,omitempty"`
 	// Deprecated, use APIVips instead
 	APIVip  *vip  `yaml:"api-vip"`
 	APIVips []vip `yaml:"api-vips"`
@@ -96,37 +160,123 @@ func parseMonitorFile(buffer []byte) (*yamlVips, error) {
 		vips.IngressVips = []vip{*vips.IngressVip}
 	}
 
+	if vips.Version > monitorConfVersion {
+		err := fmt.Errorf("Unsupported monitor file version %d", vips.Version)
+		log.Error(err)
+		return nil, err
+	}
+
+	for _, v := range append(vips.APIVips, vips.IngressVips...) {
+		if err := v.validate(vips.Version); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
 	log.Info(fmt.Sprintf("Valid monitor file format. APIVip: %+v. APIVips: %+v. IngressVip: %+v. IngressVips: %+v.", vips.APIVip, vips.APIVips, vips.IngressVip, vips.IngressVips))
 
 	return &vips, nil
 }
 
-func LeaseVIPs(log logrus.FieldLogger, cfgPath string, vipMasterIface string, vips []vip) error {
-	for _, vip := range vips {
-		mac, err := net.ParseMAC(vip.MacAddress)
+var (
+	// leaseVIPAttempts is how many times LeaseVIPs tries to lease each VIP
+	// and leaseVIPRetryInterval the time between the attempts, both swapped
+	// out by the tests
+	leaseVIPAttempts      = 3
+	leaseVIPRetryInterval = 2 * time.Second
+	// leaseVIPOnce leases a single VIP, swapped out by the tests
+	leaseVIPOnce = leaseVIPOf
+
+	// vlanLock serializes the creation of the VLAN subinterfaces the VIPs
+	// share
+	vlanLock sync.Mutex
+)
 
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"vip": vip,
-			}).WithError(err).Error("Failed to parse mac")
-			return err
-		}
+// errVIPsNotLeased is wrapped by the errors of LeaseVIPs and handleLeasing
+// when only some VIPs failed, the others being leased
+var errVIPsNotLeased = errors.New("Failed to lease some VIPs")
 
-		if err := LeaseVIP(log, cfgPath, vipMasterIface, vip.Name, mac, vip.IpAddress); err != nil {
-			log.WithFields(logrus.Fields{
-				"masterDevice": vipMasterIface,
-				"name":         vip.Name,
-				"mac":          mac,
-				"ip":           vip.IpAddress,
-			}).WithError(err).Error("Failed to lease a vip")
-			return err
-		}
+// LeaseVIPs leases the vips concurrently, retrying each of them on its own,
+// so a VIP whose DHCP scope is broken does not keep the others from being
+// leased. The error joins the ones of the VIPs that could not be leased,
+// which are reported in the lease status file.
+func LeaseVIPs(log logrus.FieldLogger, cfgPath string, vipMasterIface string, vips []vip) error {
+	errs := make([]error, len(vips))
+	var wg sync.WaitGroup
+	for i := range vips {
+		wg.Add(1)
+		go func(i int, v vip) {
+			defer wg.Done()
+			for attempt := 1; ; attempt++ {
+				masterDevice, err := leaseVIPOnce(log, cfgPath, vipMasterIface, v)
+				if err == nil {
+					return
+				}
+				log.WithFields(logrus.Fields{
+					"masterDevice": masterDevice,
+					"name":         v.Name,
+					"mac":          v.MacAddress,
+					"ip":           v.IpAddress,
+					"attempt":      attempt,
+				}).WithError(err).Error("Failed to lease a vip")
+				var parseErr *net.ParseError
+				if attempt >= leaseVIPAttempts || errors.As(err, &parseErr) {
+					leaseFailed(v.Name, err, attempt)
+					errs[i] = fmt.Errorf("VIP %s: %w", v.Name, err)
+					return
+				}
+				time.Sleep(leaseVIPRetryInterval)
+			}
+		}(i, vips[i])
 	}
+	wg.Wait()
 
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", errVIPsNotLeased, err)
+	}
 	return nil
 }
 
+// leaseVIPOf leases v, on vipMasterIface unless v has its own interface,
+// and returns the interface the macvlan of v is created on
+func leaseVIPOf(log logrus.FieldLogger, cfgPath, vipMasterIface string, v vip) (string, error) {
+	mac, err := net.ParseMAC(v.MacAddress)
+	if err != nil {
+		return "", err
+	}
+
+	masterDevice := vipMasterIface
+	if v.Interface != "" {
+		masterDevice = v.Interface
+	}
+	if v.VLAN != 0 {
+		vlanLock.Lock()
+		masterDevice, err = VLANInterface(log, masterDevice, v.VLAN)
+		vlanLock.Unlock()
+		if err != nil {
+			return "", err
+		}
+	}
+	return masterDevice, leaseVIP(log, cfgPath, masterDevice, v.Name, mac, v.IpAddress, v.hostname(mac), v.isIPv6())
+}
+
+// LeaseVIP leases an IPv4 address with DHCP for the macvlan name
 func LeaseVIP(log logrus.FieldLogger, cfgPath, masterDevice, name string, mac net.HardwareAddr, ip string) error {
+	return leaseVIP(log, cfgPath, masterDevice, name, mac, ip, formatHostname(mac.String(), name), false)
+}
+
+// LeaseVIP6 leases an IPv6 address with DHCPv6 IA_NA for the macvlan name
+func LeaseVIP6(log logrus.FieldLogger, cfgPath, masterDevice, name string, mac net.HardwareAddr, ip string) error {
+	return leaseVIP(log, cfgPath, masterDevice, name, mac, ip, formatHostname(mac.String(), name), true)
+}
+
+func leaseVIP(log logrus.FieldLogger, cfgPath, masterDevice, name string, mac net.HardwareAddr, ip, hostname string, ipv6 bool) error {
+	client, err := getDHCPClient()
+	if err != nil {
+		log.WithError(err).Error("No DHCP client to lease the VIP")
+		return err
+	}
+
 	iface, err := LeaseInterface(log, masterDevice, name, mac)
 
 	if err != nil {
@@ -138,6 +288,9 @@ func LeaseVIP(log logrus.FieldLogger, cfgPath, masterDevice, name string, mac ne
 	}
 
 	leaseFile := GetLeaseFile(cfgPath, name)
+	if ipv6 {
+		leaseFile = GetLease6File(cfgPath, name)
+	}
 
 	if f, err := os.OpenFile(leaseFile, os.O_RDWR|os.O_CREATE, 0666); err != nil {
 		log.WithFields(logrus.Fields{
@@ -157,14 +310,22 @@ func LeaseVIP(log logrus.FieldLogger, cfgPath, masterDevice, name string, mac ne
 		return err
 	}
 
-	// -sf avoiding dhclient from setting the received IP to the interface
-	// --no-pid in order to allow running multiple `dhclient` simultaneously
-	cmd := exec.Command("dhclient", "-v", iface.Name, "-H", formatHostname(mac.String(), name),
-		"-sf", "/bin/true", "-lf", leaseFile, "-d", "--no-pid")
-	cmd.Stderr = os.Stderr
+	family := vipFamilyIPv4
+	if ipv6 {
+		family = vipFamilyIPv6
+	}
+	expectLease(iface.Name, mac.String(), family, ip)
 
 	RunInfiniteWatcher(log, watcher, leaseFile, iface.Name, ip)
-	return cmd.Start()
+	switch {
+	case client == DHCPClientDhclient:
+		startDhclient(log, iface, hostname, leaseFile, ipv6)
+	case ipv6:
+		startDHCP6Client(log, iface, hostname, leaseFile)
+	default:
+		startDHCPClient(log, iface, hostname, leaseFile)
+	}
+	return nil
 }
 
 func formatHostname(mac string, suffix string) string {
@@ -193,7 +354,8 @@ func GetLastLeaseFromFile(log logrus.FieldLogger, fileName string) (string, stri
 		return "", "", err
 	}
 
-	patternIp := regexp.MustCompile(`.+fixed-address\s+(.+);`)
+	// fixed-address for DHCPv4 leases and iaaddr for DHCPv6 ones
+	patternIp := regexp.MustCompile(`\s+(?:fixed-address\s+(.+);|iaaddr\s+(\S+)\s*\{)`)
 	matchesIp := patternIp.FindAllStringSubmatch(string(data), -1)
 
 	if len(matchesIp) == 0 {
@@ -215,7 +377,75 @@ func GetLastLeaseFromFile(log logrus.FieldLogger, fileName string) (string, stri
 		return "", "", err
 	}
 
-	return matchesIface[len(matchesIface)-1][1], matchesIp[len(matchesIp)-1][1], nil
+	lastIp := matchesIp[len(matchesIp)-1]
+	if lastIp[1] == "" {
+		return matchesIface[len(matchesIface)-1][1], lastIp[2], nil
+	}
+	return matchesIface[len(matchesIface)-1][1], lastIp[1], nil
+}
+
+// vlanInterfaceName returns the name of the VLAN subinterface vlanID of
+// parent. Past IFNAMSIZ the parent is replaced by a hash of its name, so the
+// subinterfaces of the same VLAN on different parents do not collide.
+func vlanInterfaceName(parent string, vlanID int) string {
+	name := fmt.Sprintf("%s.%d", parent, vlanID)
+	if len(name) > 15 {
+		h := fnv.New32a()
+		h.Write([]byte(parent))
+		name = fmt.Sprintf("v%08x.%d", h.Sum32(), vlanID)
+	}
+	return name
+}
+
+// VLANInterface returns the name of the VLAN subinterface vlanID of parent,
+// creating it if needed.
+func VLANInterface(log logrus.FieldLogger, parent string, vlanID int) (string, error) {
+	name := vlanInterfaceName(parent, vlanID)
+
+	master, err := netlink.LinkByName(parent)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"masterDev": parent,
+		}).WithError(err).Error("Failed to read master device")
+		return "", err
+	}
+
+	// Check if already exist
+	if link, err := netlink.LinkByName(name); err == nil {
+		if vlan, ok := link.(*netlink.Vlan); !ok || vlan.VlanId != vlanID || vlan.ParentIndex != master.Attrs().Index {
+			err := fmt.Errorf("Interface %s exists and is not VLAN %d of %s", name, vlanID, parent)
+			log.WithFields(logrus.Fields{
+				"name": name,
+			}).Error(err)
+			return "", err
+		}
+		return name, nil
+	}
+
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        name,
+			ParentIndex: master.Attrs().Index,
+		},
+		VlanId: vlanID,
+	}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		log.WithFields(logrus.Fields{
+			"masterDev": parent,
+			"name":      name,
+			"vlan":      vlanID,
+		}).WithError(err).Error("Failed to create a vlan")
+		return "", err
+	}
+
+	if err := netlink.LinkSetUp(vlan); err != nil {
+		log.WithFields(logrus.Fields{
+			"interface": name,
+		}).WithError(err).Error("Failed to bring interface up")
+		return "", err
+	}
+
+	return name, nil
 }
 
 func LeaseInterface(log logrus.FieldLogger, masterDevice string, name string, mac net.HardwareAddr) (*net.Interface, error) {
@@ -253,6 +483,13 @@ func LeaseInterface(log logrus.FieldLogger, masterDevice string, name string, ma
 		}).WithError(err).Error("Failed to create a macvlan")
 		return nil, err
 	}
+	// The alias marks the macvlans of the VIPs, so the ones of removed VIPs
+	// are found even without their lease file
+	if err := netlink.LinkSetAlias(mv, vipMacvlanAlias); err != nil {
+		log.WithFields(logrus.Fields{
+			"name": name,
+		}).WithError(err).Warn("Failed to set the alias of the macvlan")
+	}
 
 	// Read created link
 	macvlanInterfaceLink, err := netlink.LinkByName(name)
@@ -328,6 +565,7 @@ func CheckLastLease(log logrus.FieldLogger, fileName, expectedIface, expectedIp
 			"ip":            ip,
 			"expectedIp":    expectedIp,
 		}).Error(err)
+		leaseMismatch(expectedIface)
 		return err
 	} else {
 		log.WithFields(logrus.Fields{
@@ -335,6 +573,7 @@ func CheckLastLease(log logrus.FieldLogger, fileName, expectedIface, expectedIp
 			"iface":    iface,
 			"ip":       ip,
 		}).Info("A new lease has been written to the lease file with the right data")
+		leaseMatched(expectedIface)
 		return nil
 	}
 }
@@ -342,3 +581,7 @@ func CheckLastLease(log logrus.FieldLogger, fileName, expectedIface, expectedIp
 func GetLeaseFile(cfgPath, name string) string {
 	return filepath.Join(filepath.Dir(cfgPath), fmt.Sprintf(leaseFile, name))
 }
+
+func GetLease6File(cfgPath, name string) string {
+	return filepath.Join(filepath.Dir(cfgPath), fmt.Sprintf(lease6File, name))
+}
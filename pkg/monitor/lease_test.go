@@ -38,11 +38,14 @@ var _ = Describe("lease_vip", func() {
 		err       error
 		cfgPath   string
 	)
+	prevAttempts, prevRetryInterval := leaseVIPAttempts, leaseVIPRetryInterval
 
 	BeforeEach(func() {
 		if !hasCap(capability.CAP_NET_ADMIN, capability.CAP_NET_RAW) {
 			Skip("Must run with capabilities: CAP_NET_ADMIN, CAP_NET_RAW")
 		}
+		// Fail at once without a DHCP server
+		leaseVIPAttempts, leaseVIPRetryInterval = 1, 0
 
 		log = logrus.New()
 		hook = test.NewLocal(log)
@@ -126,8 +129,8 @@ var _ = Describe("lease_vip", func() {
 				verifyWatcherRecordLog(hook, testName, ip, true)
 			})
 
-			By("dhclient_still_running", func() {
-				Expect(isDhclientAlive()).Should(BeTrue())
+			By("dhcp_client_still_running", func() {
+				Expect(isLeaseClientRunning(GetLeaseFile(cfgPath, testName))).Should(BeTrue())
 			})
 
 			By("watcher_still_running", func() {
@@ -239,8 +242,8 @@ var _ = Describe("lease_vip", func() {
 	Describe("LeaseVIPs", func() {
 		It("happy_flow", func() {
 			vips := []vip{
-				{"api", generateMac().String(), ""},
-				{"ingress", generateMac().String(), ""},
+				{Name: "api", MacAddress: generateMac().String(), IpAddress: ""},
+				{Name: "ingress", MacAddress: generateMac().String(), IpAddress: ""},
 			}
 			Expect(LeaseVIPs(log, cfgPath, realIface.Name, vips)).ShouldNot(HaveOccurred())
 			time.Sleep(LeaseTime)
@@ -254,6 +257,7 @@ var _ = Describe("lease_vip", func() {
 	})
 
 	AfterEach(func() {
+		leaseVIPAttempts, leaseVIPRetryInterval = prevAttempts, prevRetryInterval
 		cleanEnv(cfgPath)
 
 		// Cleanup
@@ -297,8 +301,8 @@ var _ = Describe("getVipsToLease", func() {
 
 	It("invalid_array_content", func() {
 		data := []vip{
-			{"api", generateMac().String(), generateIP()},
-			{"ingress", generateMac().String(), generateIP()},
+			{Name: "api", MacAddress: generateMac().String(), IpAddress: generateIP()},
+			{Name: "ingress", MacAddress: generateMac().String(), IpAddress: generateIP()},
 		}
 
 		buffer, err := yaml.Marshal(&data)
@@ -314,7 +318,7 @@ var _ = Describe("getVipsToLease", func() {
 	It("invalid_yaml_content", func() {
 		data := yamlVips{
 			APIVip:     nil,
-			IngressVip: &vip{"ingress", generateMac().String(), generateIP()},
+			IngressVip: &vip{Name: "ingress", MacAddress: generateMac().String(), IpAddress: generateIP()},
 		}
 
 		buffer, err := yaml.Marshal(&data)
@@ -328,8 +332,8 @@ var _ = Describe("getVipsToLease", func() {
 	})
 
 	It("valid_yaml_content", func() {
-		api := vip{"api", generateMac().String(), generateIP()}
-		ingress := vip{"ingress", generateMac().String(), generateIP()}
+		api := vip{Name: "api", MacAddress: generateMac().String(), IpAddress: generateIP()}
+		ingress := vip{Name: "ingress", MacAddress: generateMac().String(), IpAddress: generateIP()}
 		data := yamlVips{
 			APIVips:     []vip{api},
 			IngressVips: []vip{ingress},
@@ -544,11 +548,7 @@ func deleteInterface(name string) {
 }
 
 func cleanEnv(cfgPath string) {
-	_ = getOutput(`pkill dhclient || true`)
-}
-
-func isDhclientAlive() bool {
-	return getOutput(`ps -ef | grep dhclient | egrep -v "(grep|defunct)"`) != "exit status 1"
+	stopLeaseClients(false)
 }
 
 func generateUUID() string {
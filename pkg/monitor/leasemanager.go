@@ -0,0 +1,344 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// leaseClient tracks a running DHCP or DHCPv6 client
+type leaseClient struct {
+	stop chan struct{}
+	done chan struct{}
+	// release is set before stop is closed when the lease must be given back
+	// to the server instead of just left to expire
+	release bool
+}
+
+var (
+	leaseClientsLock sync.Mutex
+	leaseClients     = map[string]*leaseClient{}
+)
+
+// startLeaseClient runs a lease client for leaseFile in its own goroutine,
+// stopping any client that is already writing to the same lease file. The
+// replaced client keeps its lease since the new one requests the same address.
+func startLeaseClient(leaseFile string, run func(client *leaseClient)) {
+	client := &leaseClient{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	leaseClientsLock.Lock()
+	prev := leaseClients[leaseFile]
+	leaseClients[leaseFile] = client
+	leaseClientsLock.Unlock()
+
+	if prev != nil {
+		close(prev.stop)
+		<-prev.done
+	}
+
+	go func() {
+		defer func() {
+			leaseClientsLock.Lock()
+			if leaseClients[leaseFile] == client {
+				delete(leaseClients, leaseFile)
+			}
+			leaseClientsLock.Unlock()
+			close(client.done)
+		}()
+		run(client)
+	}()
+}
+
+// stopLeaseClient stops the client of leaseFile, if any, and waits for it
+func stopLeaseClient(leaseFile string, release bool) {
+	leaseClientsLock.Lock()
+	client := leaseClients[leaseFile]
+	delete(leaseClients, leaseFile)
+	leaseClientsLock.Unlock()
+
+	if client != nil {
+		client.release = release
+		close(client.stop)
+		<-client.done
+	}
+}
+
+func stopLeaseClients(release bool) {
+	leaseClientsLock.Lock()
+	clients := leaseClients
+	leaseClients = map[string]*leaseClient{}
+	leaseClientsLock.Unlock()
+
+	for _, client := range clients {
+		client.release = release
+		close(client.stop)
+	}
+	for _, client := range clients {
+		<-client.done
+	}
+}
+
+// ReleaseLeases stops all the lease clients and gives their leases back to
+// the DHCP servers. It is meant to be called on shutdown.
+func ReleaseLeases() {
+	stopLeaseClients(true)
+}
+
+func isLeaseClientRunning(leaseFile string) bool {
+	leaseClientsLock.Lock()
+	defer leaseClientsLock.Unlock()
+	_, ok := leaseClients[leaseFile]
+	return ok
+}
+
+// waitOrStop returns false if stop was closed before d elapsed
+func waitOrStop(stop <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func appendLease(leaseFile string, lease interface{ String() string }) error {
+	f, err := os.OpenFile(leaseFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(lease.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// leaseFileVipName returns the VIP name of a lease file, or an empty string
+// if the file is not a lease file
+func leaseFileVipName(fileName string) string {
+	for _, pattern := range []string{lease6File, leaseFile} {
+		prefix := strings.TrimSuffix(pattern, "%s")
+		// VIP names are interface names, which never contain dots
+		if name := strings.TrimPrefix(fileName, prefix); name != fileName && !strings.Contains(name, ".") {
+			return name
+		}
+	}
+	return ""
+}
+
+// cleanupStaleLeases releases the leases of VIPs that are no longer in the
+// monitor configuration, deletes their macvlan interfaces and lease files.
+// The lease files left by previous runs are how removed VIPs are found.
+func cleanupStaleLeases(log logrus.FieldLogger, cfgPath string, vips []vip) {
+	current := map[string]bool{}
+	for _, v := range vips {
+		current[v.Name] = true
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(cfgPath))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"dir": filepath.Dir(cfgPath),
+		}).WithError(err).Warn("Failed to list lease files")
+		return
+	}
+
+	for _, f := range files {
+		name := leaseFileVipName(f.Name())
+		if name == "" || current[name] || !f.Mode().IsRegular() {
+			continue
+		}
+		leaseFile := filepath.Join(filepath.Dir(cfgPath), f.Name())
+		if isLeaseClientRunning(leaseFile) {
+			stopLeaseClient(leaseFile, true)
+		} else {
+			// Left by a previous run of the monitor
+			releaseStaleLease(log, name, leaseFile)
+		}
+		forgetLeaseStatus(name)
+
+		if link, err := netlink.LinkByName(name); err == nil {
+			if _, ok := link.(*netlink.Macvlan); ok {
+				if err := netlink.LinkDel(link); err != nil {
+					log.WithFields(logrus.Fields{
+						"interface": name,
+					}).WithError(err).Warn("Failed to delete macvlan of removed VIP")
+				}
+			}
+		}
+
+		if err := os.Remove(leaseFile); err != nil {
+			log.WithFields(logrus.Fields{
+				"filename": leaseFile,
+			}).WithError(err).Warn("Failed to remove lease file of removed VIP")
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"name":     name,
+			"filename": leaseFile,
+		}).Info("Cleaned up lease of removed VIP")
+	}
+	cleanupStaleMacvlans(log, cfgPath, current)
+}
+
+// vipMacvlanAlias is the alias of the macvlans created for the VIPs
+const vipMacvlanAlias = "baremetal-runtimecfg-vip"
+
+var (
+	// listLinks and deleteLink are swapped out by the tests
+	listLinks  = netlink.LinkList
+	deleteLink = netlink.LinkDel
+)
+
+// cleanupStaleMacvlans deletes the macvlans created for VIPs that are not in
+// current, like the ones of a removed VIP whose lease file is gone, after
+// stopping their lease clients
+func cleanupStaleMacvlans(log logrus.FieldLogger, cfgPath string, current map[string]bool) {
+	links, err := listLinks()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list the macvlans of the VIPs")
+		return
+	}
+	for _, link := range links {
+		if _, ok := link.(*netlink.Macvlan); !ok || link.Attrs().Alias != vipMacvlanAlias || current[link.Attrs().Name] {
+			continue
+		}
+		stopLeaseClient(GetLeaseFile(cfgPath, link.Attrs().Name), true)
+		stopLeaseClient(GetLease6File(cfgPath, link.Attrs().Name), true)
+		forgetLeaseStatus(link.Attrs().Name)
+		if err := deleteLink(link); err != nil {
+			log.WithFields(logrus.Fields{
+				"interface": link.Attrs().Name,
+			}).WithError(err).Warn("Failed to delete macvlan of removed VIP")
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"interface": link.Attrs().Name,
+		}).Info("Deleted macvlan of removed VIP")
+	}
+}
+
+var (
+	leaseServerIDPattern = regexp.MustCompile(`\s+option dhcp-server-identifier\s+(\S+);`)
+	leaseExpirePattern   = regexp.MustCompile(`\s+expire\s+\d\s+([^;]+);`)
+)
+
+// lastDHCPLease returns the address, server and expiry of the last DHCPv4
+// lease of a lease file in the dhclient format. The server and expiry are
+// nil and zero when the lease has none.
+func lastDHCPLease(log logrus.FieldLogger, leaseFile string) (*dhcpLease, error) {
+	_, ip, err := GetLastLeaseFromFile(log, leaseFile)
+	if err != nil {
+		return nil, err
+	}
+	lease := &dhcpLease{FixedAddress: net.ParseIP(ip).To4()}
+	if lease.FixedAddress == nil {
+		return nil, fmt.Errorf("Invalid leased address %s", ip)
+	}
+	data, err := ioutil.ReadFile(leaseFile)
+	if err != nil {
+		return nil, err
+	}
+	if m := leaseServerIDPattern.FindAllStringSubmatch(string(data), -1); len(m) > 0 {
+		lease.ServerID = net.ParseIP(m[len(m)-1][1]).To4()
+	}
+	if m := leaseExpirePattern.FindAllStringSubmatch(string(data), -1); len(m) > 0 {
+		if expire, err := time.Parse("2006/01/02 15:04:05", m[len(m)-1][1]); err == nil {
+			lease.Expire = expire
+		}
+	}
+	return lease, nil
+}
+
+// releaseStaleLease gives back the lease of leaseFile before the macvlan of
+// the removed VIP name is deleted, when no client of this run holds it. The
+// DHCPv6 leases are left to expire.
+func releaseStaleLease(log logrus.FieldLogger, name, leaseFile string) {
+	if strings.HasPrefix(filepath.Base(leaseFile), strings.TrimSuffix(lease6File, "%s")) {
+		return
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return
+	}
+	lease, err := lastDHCPLease(log, leaseFile)
+	if err != nil || (!lease.Expire.IsZero() && time.Now().After(lease.Expire)) {
+		return
+	}
+	if client, err := getDHCPClient(); err == nil && client == DHCPClientDhclient {
+		if out, err := exec.Command(DHCPClientDhclient, dhclientArgs(name, "", leaseFile, false, true)...).CombinedOutput(); err != nil {
+			log.WithFields(logrus.Fields{
+				"interface": name,
+				"output":    string(out),
+			}).WithError(err).Warn("Failed to release the lease with dhclient")
+		}
+		return
+	}
+	c := &dhcpClient{log: log, iface: iface, leaseFile: leaseFile}
+	c.release(lease)
+}
+
+// reapDhclients terminates dhclient processes that previous versions or runs
+// of the monitor started for the lease files next to cfgPath. They outlive
+// container restarts.
+func reapDhclients(log logrus.FieldLogger, cfgPath string) {
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	leaseDir := filepath.Dir(cfgPath)
+
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", p.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if !isStaleDhclient(cmdline, leaseDir) {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			log.WithFields(logrus.Fields{
+				"pid": pid,
+			}).WithError(err).Warn("Failed to terminate stale dhclient")
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"pid": pid,
+		}).Info("Terminated stale dhclient")
+	}
+}
+
+// isStaleDhclient checks whether a NUL separated command line is a dhclient
+// writing to a lease file in leaseDir
+func isStaleDhclient(cmdline []byte, leaseDir string) bool {
+	args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	if len(args) == 0 || filepath.Base(args[0]) != "dhclient" {
+		return false
+	}
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-lf" {
+			return filepath.Dir(args[i+1]) == leaseDir && leaseFileVipName(filepath.Base(args[i+1])) != ""
+		}
+	}
+	return false
+}
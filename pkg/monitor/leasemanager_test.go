@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("lease_manager", func() {
+	It("lease_file_vip_name", func() {
+		Expect(leaseFileVipName("lease-api")).Should(Equal("api"))
+		Expect(leaseFileVipName("lease6-api6")).Should(Equal("api6"))
+		Expect(leaseFileVipName("lease-status.json")).Should(BeEmpty())
+		Expect(leaseFileVipName("keepalived.conf")).Should(BeEmpty())
+	})
+
+	It("stale_dhclient", func() {
+		cmdline := []byte("dhclient\x00-v\x00api\x00-lf\x00/etc/keepalived/lease-api\x00-d\x00--no-pid\x00")
+		Expect(isStaleDhclient(cmdline, "/etc/keepalived")).Should(BeTrue())
+		Expect(isStaleDhclient(cmdline, "/etc/other")).Should(BeFalse())
+		Expect(isStaleDhclient([]byte("dhclient\x00eth0\x00"), "/etc/keepalived")).Should(BeFalse())
+		Expect(isStaleDhclient([]byte("bash\x00-lf\x00/etc/keepalived/lease-api\x00"), "/etc/keepalived")).Should(BeFalse())
+	})
+
+	It("client_lifecycle", func() {
+		released := make(chan bool, 2)
+		run := func(client *leaseClient) {
+			<-client.stop
+			released <- client.release
+		}
+
+		startLeaseClient("/tmp/lease-a", run)
+		Expect(isLeaseClientRunning("/tmp/lease-a")).Should(BeTrue())
+
+		By("replacing_keeps_the_lease", func() {
+			startLeaseClient("/tmp/lease-a", run)
+			Expect(<-released).Should(BeFalse())
+			Expect(isLeaseClientRunning("/tmp/lease-a")).Should(BeTrue())
+		})
+
+		By("stopping_releases", func() {
+			stopLeaseClient("/tmp/lease-a", true)
+			Expect(<-released).Should(BeTrue())
+			Expect(isLeaseClientRunning("/tmp/lease-a")).Should(BeFalse())
+		})
+	})
+
+	It("cleanup_removed_vips", func() {
+		dir, err := ioutil.TempDir("", "leases")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		cfgPath := filepath.Join(dir, "keepalived.conf")
+
+		for _, f := range []string{"keepalived.conf", "lease-api", "lease6-api6", "lease-oldvip", "lease6-oldvip6"} {
+			Expect(ioutil.WriteFile(filepath.Join(dir, f), []byte{}, 0644)).ShouldNot(HaveOccurred())
+		}
+
+		cleanupStaleLeases(log, cfgPath, []vip{{Name: "api"}, {Name: "api6"}})
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		names := []string{}
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		Expect(names).Should(ConsistOf("keepalived.conf", "lease-api", "lease6-api6"))
+	})
+
+	It("deletes_the_macvlans_of_removed_vips", func() {
+		defer func(list func() ([]netlink.Link, error), del func(netlink.Link) error) {
+			listLinks, deleteLink = list, del
+		}(listLinks, deleteLink)
+		listLinks = func() ([]netlink.Link, error) {
+			return []netlink.Link{
+				&netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{Name: "api", Alias: vipMacvlanAlias}},
+				&netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{Name: "oldvip", Alias: vipMacvlanAlias}},
+				&netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{Name: "other"}},
+				&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "oldvip6", Alias: vipMacvlanAlias}},
+			}, nil
+		}
+		deleted := []string{}
+		deleteLink = func(link netlink.Link) error {
+			deleted = append(deleted, link.Attrs().Name)
+			return nil
+		}
+
+		cleanupStaleMacvlans(log, "/tmp/keepalived.conf", map[string]bool{"api": true})
+		Expect(deleted).Should(Equal([]string{"oldvip"}))
+	})
+
+	It("reads_the_lease_to_release", func() {
+		dir, err := ioutil.TempDir("", "leases")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		leaseFile := filepath.Join(dir, "lease-oldvip")
+
+		expire := time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)
+		lease := &dhcpLease{
+			Interface:    "oldvip",
+			FixedAddress: net.ParseIP("192.168.111.7").To4(),
+			ServerID:     net.ParseIP("192.168.111.1").To4(),
+			LeaseTime:    time.Hour,
+			Expire:       expire,
+		}
+		Expect(ioutil.WriteFile(leaseFile, []byte(lease.String()), 0644)).ShouldNot(HaveOccurred())
+
+		read, err := lastDHCPLease(log, leaseFile)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(read.FixedAddress.String()).Should(Equal("192.168.111.7"))
+		Expect(read.ServerID.String()).Should(Equal("192.168.111.1"))
+		Expect(read.Expire).Should(Equal(expire))
+	})
+})
@@ -0,0 +1,306 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// vipLeaseStatus is the state of a single leased VIP
+type vipLeaseStatus struct {
+	Name          string    `json:"name"`
+	Interface     string    `json:"interface"`
+	MAC           string    `json:"mac"`
+	Family        string    `json:"family"`
+	ExpectedIP    string    `json:"expectedIP,omitempty"`
+	CurrentIP     string    `json:"currentIP,omitempty"`
+	LeaseExpiry   time.Time `json:"leaseExpiry,omitempty"`
+	RenewCount    int       `json:"renewCount"`
+	Mismatch      bool      `json:"mismatch"`
+	MismatchCount int       `json:"mismatchCount"`
+	LastMismatch  time.Time `json:"lastMismatch,omitempty"`
+	// Error is why the VIP could not be leased after Attempts tries, empty
+	// once a lease client runs for it
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+var (
+	leaseStatusLock sync.Mutex
+	leaseStatuses   = map[string]*vipLeaseStatus{}
+	// leaseStatusFile is where the state of the leased VIPs is written, so
+	// that it can be checked without scraping the logs. Disabled when empty.
+	leaseStatusFile string
+	// leaseTrackFileDir is where the lease-mismatch-<vip> track files are
+	// written
+	leaseTrackFileDir = DefaultOptions().TrackFileDir
+
+	vipLeaseInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_vip_lease_info",
+		Help: "Leased VIP with its interface, MAC and current address",
+	}, []string{"vip", "interface", "mac", "family", "ip"})
+	vipLeaseExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_vip_lease_expiry_timestamp_seconds",
+		Help: "Time at which the current VIP lease expires",
+	}, []string{"vip"})
+	vipLeaseRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_vip_lease_renewals_total",
+		Help: "Number of times the VIP lease was renewed",
+	}, []string{"vip"})
+	vipLeaseMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "baremetal_runtimecfg_vip_lease_mismatches_total",
+		Help: "Number of leases written with a different interface or address than expected",
+	}, []string{"vip"})
+	vipLeaseMismatch = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "baremetal_runtimecfg_vip_lease_mismatch",
+		Help: "1 while the last lease of the VIP does not match the expected address",
+	}, []string{"vip"})
+)
+
+func init() {
+	prometheus.MustRegister(vipLeaseInfo, vipLeaseExpiry, vipLeaseRenewals, vipLeaseMismatches, vipLeaseMismatch)
+}
+
+// leaseTrackFile returns the track file of the VIP name. It must be called
+// with leaseStatusLock held.
+func leaseTrackFile(name string) string {
+	return filepath.Join(leaseTrackFileDir, "lease-mismatch-"+name)
+}
+
+// writeLeaseTrackFile sets the keepalived track file of the VIP. keepalived
+// multiplies the value by the track_file weight, so a negative weight lowers
+// the priority of the node while its lease does not match the VIP.
+func writeLeaseTrackFile(name string, mismatch bool) {
+	value := "0\n"
+	if mismatch {
+		value = "1\n"
+	}
+	leaseStatusLock.Lock()
+	path := leaseTrackFile(name)
+	leaseStatusLock.Unlock()
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		log.WithFields(logrus.Fields{
+			"filename": path,
+		}).WithError(err).Warn("Failed to write lease track file")
+	}
+}
+
+// leaseTrackFiles returns the track files of the leased VIPs by VIP name
+func leaseTrackFiles() map[string]string {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+	files := map[string]string{}
+	for name := range leaseStatuses {
+		files[name] = leaseTrackFile(name)
+	}
+	return files
+}
+
+// updateLeaseStatus applies update to the status of the VIP name, creating
+// it if needed, and rewrites the status file.
+func updateLeaseStatus(name string, update func(s *vipLeaseStatus)) {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+
+	s, ok := leaseStatuses[name]
+	if !ok {
+		s = &vipLeaseStatus{Name: name, Interface: name}
+		leaseStatuses[name] = s
+	}
+	prev := *s
+	update(s)
+
+	if prev.CurrentIP != s.CurrentIP || prev.MAC != s.MAC {
+		vipLeaseInfo.DeletePartialMatch(prometheus.Labels{"vip": name})
+	}
+	if s.Mismatch {
+		vipLeaseMismatch.WithLabelValues(name).Set(1)
+	} else {
+		vipLeaseMismatch.WithLabelValues(name).Set(0)
+	}
+	if s.CurrentIP != "" {
+		vipLeaseInfo.WithLabelValues(name, s.Interface, s.MAC, s.Family, s.CurrentIP).Set(1)
+		vipLeaseExpiry.WithLabelValues(name).Set(float64(s.LeaseExpiry.Unix()))
+	}
+
+	writeLeaseStatusLocked()
+}
+
+// expectLease records the VIP a lease client is started for
+func expectLease(name, mac, family, expectedIP string) {
+	updateLeaseStatus(name, func(s *vipLeaseStatus) {
+		s.MAC = mac
+		s.Family = family
+		s.ExpectedIP = expectedIP
+		s.Mismatch = false
+		s.Error = ""
+		s.Attempts = 0
+	})
+	writeLeaseTrackFile(name, false)
+}
+
+// leasedVIPCount returns how many VIPs have a lease client running
+func leasedVIPCount() int {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+	count := 0
+	for _, s := range leaseStatuses {
+		if s.Error == "" {
+			count++
+		}
+	}
+	return count
+}
+
+// leaseFailed records a VIP that could not be leased after attempts tries.
+// Its track file is left at 0: only a lease of another address lowers the
+// priority of the node.
+func leaseFailed(name string, err error, attempts int) {
+	updateLeaseStatus(name, func(s *vipLeaseStatus) {
+		s.Error = err.Error()
+		s.Attempts = attempts
+	})
+	writeLeaseTrackFile(name, false)
+}
+
+// leaseAcquired records a lease written by a lease client. Any lease after
+// the first one is counted as a renewal.
+func leaseAcquired(name, ip string, expiry time.Time) {
+	updateLeaseStatus(name, func(s *vipLeaseStatus) {
+		if s.CurrentIP != "" {
+			s.RenewCount++
+			vipLeaseRenewals.WithLabelValues(name).Inc()
+		}
+		s.CurrentIP = ip
+		s.LeaseExpiry = expiry
+	})
+}
+
+// leaseMismatch records a lease that does not match the expected VIP
+func leaseMismatch(name string) {
+	updateLeaseStatus(name, func(s *vipLeaseStatus) {
+		s.Mismatch = true
+		s.MismatchCount++
+		s.LastMismatch = time.Now()
+		vipLeaseMismatches.WithLabelValues(name).Inc()
+	})
+	writeLeaseTrackFile(name, true)
+}
+
+// leaseMatched records a lease that matches the expected VIP, clearing a
+// previous mismatch
+func leaseMatched(name string) {
+	updateLeaseStatus(name, func(s *vipLeaseStatus) {
+		s.Mismatch = false
+	})
+	writeLeaseTrackFile(name, false)
+}
+
+// forgetLeaseStatus drops a VIP that is no longer leased
+func forgetLeaseStatus(name string) {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+
+	delete(leaseStatuses, name)
+	vipLeaseInfo.DeletePartialMatch(prometheus.Labels{"vip": name})
+	vipLeaseExpiry.DeleteLabelValues(name)
+	vipLeaseRenewals.DeleteLabelValues(name)
+	vipLeaseMismatches.DeleteLabelValues(name)
+	vipLeaseMismatch.DeleteLabelValues(name)
+	os.Remove(leaseTrackFile(name))
+
+	writeLeaseStatusLocked()
+}
+
+func leaseStatusReport() []vipLeaseStatus {
+	report := []vipLeaseStatus{}
+	for _, s := range leaseStatuses {
+		report = append(report, *s)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+// setLeaseStatusFile sets the file the state of the leased VIPs is written to
+func setLeaseStatusFile(path string) {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+	leaseStatusFile = path
+}
+
+// setLeaseTrackFileDir sets the directory the track files of the leased VIPs
+// are written to
+func setLeaseTrackFileDir(dir string) {
+	leaseStatusLock.Lock()
+	defer leaseStatusLock.Unlock()
+	leaseTrackFileDir = dir
+}
+
+// writeLeaseStatusLocked replaces the status file with the current state.
+// It must be called with leaseStatusLock held.
+func writeLeaseStatusLocked() {
+	if leaseStatusFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(leaseStatusReport(), "", "  ")
+	if err != nil {
+		return
+	}
+
+	dir := filepath.Dir(leaseStatusFile)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(leaseStatusFile)+".*")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"filename": leaseStatusFile,
+		}).WithError(err).Warn("Failed to write lease status file")
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), leaseStatusFile)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"filename": leaseStatusFile,
+		}).WithError(err).Warn("Failed to write lease status file")
+	}
+}
+
+// serveMetrics starts the prometheus metrics endpoint on addr. An empty addr
+// disables it.
+func serveMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.WithFields(logrus.Fields{
+			"address": addr,
+		}).Info("Serving metrics endpoint")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.WithFields(logrus.Fields{
+				"address": addr,
+			}).WithError(err).Error("Metrics endpoint stopped")
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+func metricValue(m prometheus.Metric) float64 {
+	out := &dto.Metric{}
+	Expect(m.Write(out)).ShouldNot(HaveOccurred())
+	if out.Counter != nil {
+		return out.Counter.GetValue()
+	}
+	return out.Gauge.GetValue()
+}
+
+func metricCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+var _ = Describe("lease_status", func() {
+	var (
+		dir        string
+		prevStatus string
+		prevTrack  string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "lease-status")
+		Expect(err).ShouldNot(HaveOccurred())
+		prevStatus = leaseStatusFile
+		setLeaseStatusFile(filepath.Join(dir, "lease-status.json"))
+		prevTrack = leaseTrackFileDir
+		setLeaseTrackFileDir(dir)
+
+		// Other specs lease VIPs too
+		leaseStatusLock.Lock()
+		leaseStatuses = map[string]*vipLeaseStatus{}
+		leaseStatusLock.Unlock()
+	})
+
+	AfterEach(func() {
+		forgetLeaseStatus("st-api")
+		setLeaseStatusFile(prevStatus)
+		setLeaseTrackFileDir(prevTrack)
+		os.RemoveAll(dir)
+	})
+
+	readStatus := func() []vipLeaseStatus {
+		data, err := ioutil.ReadFile(leaseStatusFile)
+		Expect(err).ShouldNot(HaveOccurred())
+		statuses := []vipLeaseStatus{}
+		Expect(json.Unmarshal(data, &statuses)).ShouldNot(HaveOccurred())
+		return statuses
+	}
+
+	It("lease_lifecycle", func() {
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		expectLease("st-api", "00:1a:4a:92:c8:d7", vipFamilyIPv4, "172.99.0.55")
+		leaseAcquired("st-api", "172.99.0.55", expiry)
+		leaseAcquired("st-api", "172.99.0.55", expiry)
+		leaseMismatch("st-api")
+
+		statuses := readStatus()
+		Expect(statuses).Should(HaveLen(1))
+		Expect(statuses[0].Name).Should(Equal("st-api"))
+		Expect(statuses[0].MAC).Should(Equal("00:1a:4a:92:c8:d7"))
+		Expect(statuses[0].CurrentIP).Should(Equal("172.99.0.55"))
+		Expect(statuses[0].LeaseExpiry.Equal(expiry)).Should(BeTrue())
+		Expect(statuses[0].RenewCount).Should(Equal(1))
+		Expect(statuses[0].MismatchCount).Should(Equal(1))
+
+		Expect(metricValue(vipLeaseRenewals.WithLabelValues("st-api"))).Should(Equal(1.0))
+		Expect(metricValue(vipLeaseMismatches.WithLabelValues("st-api"))).Should(Equal(1.0))
+		Expect(metricValue(vipLeaseExpiry.WithLabelValues("st-api"))).Should(Equal(float64(expiry.Unix())))
+
+		forgetLeaseStatus("st-api")
+		Expect(readStatus()).Should(BeEmpty())
+	})
+
+	It("isolates_the_vips_failing_to_lease", func() {
+		defer func(once func(logrus.FieldLogger, string, string, vip) (string, error), interval time.Duration) {
+			leaseVIPOnce, leaseVIPRetryInterval = once, interval
+		}(leaseVIPOnce, leaseVIPRetryInterval)
+		defer forgetLeaseStatus("st-ingress")
+		leaseVIPRetryInterval = time.Millisecond
+
+		var lock sync.Mutex
+		attempts := map[string]int{}
+		leaseVIPOnce = func(_ logrus.FieldLogger, _, iface string, v vip) (string, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts[v.Name]++
+			switch {
+			case v.Name == "st-ingress":
+				return iface, errors.New("no DHCPOFFER")
+			case attempts[v.Name] < 2:
+				return iface, errors.New("timed out")
+			}
+			expectLease(v.Name, v.MacAddress, vipFamilyIPv4, v.IpAddress)
+			return iface, nil
+		}
+
+		err := LeaseVIPs(logrus.New(), dir, "eth0", []vip{
+			{Name: "st-api", MacAddress: "00:1a:4a:92:c8:d7"},
+			{Name: "st-ingress", MacAddress: "00:1a:4a:92:c8:d8"},
+		})
+		Expect(err).Should(MatchError(errVIPsNotLeased))
+		Expect(err).Should(MatchError(ContainSubstring("VIP st-ingress: no DHCPOFFER")))
+		Expect(attempts).Should(Equal(map[string]int{"st-api": 2, "st-ingress": leaseVIPAttempts}))
+		Expect(leasedVIPCount()).Should(Equal(1))
+
+		statuses := readStatus()
+		Expect(statuses).Should(HaveLen(2))
+		Expect(statuses[0].Error).Should(BeEmpty())
+		Expect(statuses[1].Name).Should(Equal("st-ingress"))
+		Expect(statuses[1].Error).Should(Equal("no DHCPOFFER"))
+		Expect(statuses[1].Attempts).Should(Equal(leaseVIPAttempts))
+	})
+
+	It("address_change_replaces_info", func() {
+		expectLease("st-api", "00:1a:4a:92:c8:d7", vipFamilyIPv4, "")
+		leaseAcquired("st-api", "172.99.0.55", time.Now())
+		leaseAcquired("st-api", "172.99.0.56", time.Now())
+
+		Expect(metricCount(vipLeaseInfo)).Should(Equal(1))
+		Expect(metricValue(vipLeaseInfo.WithLabelValues("st-api", "st-api", "00:1a:4a:92:c8:d7", vipFamilyIPv4, "172.99.0.56"))).Should(Equal(1.0))
+	})
+
+	It("mismatch_track_file", func() {
+		readTrack := func() string {
+			data, err := ioutil.ReadFile(filepath.Join(dir, "lease-mismatch-st-api"))
+			Expect(err).ShouldNot(HaveOccurred())
+			return string(data)
+		}
+
+		expectLease("st-api", "00:1a:4a:92:c8:d7", vipFamilyIPv4, "172.99.0.55")
+		Expect(readTrack()).Should(Equal("0\n"))
+		Expect(leaseTrackFiles()).Should(HaveKeyWithValue("st-api", filepath.Join(dir, "lease-mismatch-st-api")))
+
+		leaseMismatch("st-api")
+		Expect(readTrack()).Should(Equal("1\n"))
+		Expect(readStatus()[0].Mismatch).Should(BeTrue())
+		Expect(metricValue(vipLeaseMismatch.WithLabelValues("st-api"))).Should(Equal(1.0))
+
+		leaseMatched("st-api")
+		Expect(readTrack()).Should(Equal("0\n"))
+		Expect(readStatus()[0].Mismatch).Should(BeFalse())
+		Expect(metricValue(vipLeaseMismatch.WithLabelValues("st-api"))).Should(Equal(0.0))
+
+		forgetLeaseStatus("st-api")
+		_, err := os.Stat(filepath.Join(dir, "lease-mismatch-st-api"))
+		Expect(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	It("firewall_track_files", func() {
+		expectLease("st-api", "00:1a:4a:92:c8:d7", vipFamilyIPv4, "172.99.0.55")
+		node := config.Node{
+			Cluster: config.Cluster{APIVIP: "192.168.111.5"},
+			Configs: &[]config.Node{
+				{Cluster: config.Cluster{APIVIP: "192.168.111.5"}},
+				{Cluster: config.Cluster{APIVIP: "fd00::5"}},
+			},
+		}
+		setTrackFiles(&node, dir, "")
+		Expect(node.FirewallTrackFile).Should(Equal(filepath.Join(dir, "firewall-rules-ipv4")))
+		Expect((*node.Configs)[1].FirewallTrackFile).Should(Equal(filepath.Join(dir, "firewall-rules-ipv6")))
+		Expect((*node.Configs)[1].LeaseTrackFiles).Should(HaveKey("st-api"))
+	})
+})
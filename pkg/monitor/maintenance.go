@@ -0,0 +1,109 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// haproxyAPIBackend is the backend of the API servers in the HAProxy
+// configuration
+const haproxyAPIBackend = "masters"
+
+// inMaintenance tells whether the maintenance file exists
+func inMaintenance(file string) bool {
+	if file == "" {
+		return false
+	}
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// maintenanceTrackFile returns the keepalived track file in trackFileDir
+// holding 1 while the node is in maintenance
+func maintenanceTrackFile(trackFileDir string) string {
+	return filepath.Join(trackFileDir, "maintenance")
+}
+
+// updateMaintenanceTrackFile writes the maintenance state of file in its
+// track file in trackFileDir
+func updateMaintenanceTrackFile(trackFileDir, file string) {
+	if file == "" {
+		return
+	}
+	value := "0\n"
+	if inMaintenance(file) {
+		value = "1\n"
+	}
+	if err := ioutil.WriteFile(maintenanceTrackFile(trackFileDir), []byte(value), 0644); err != nil {
+		log.WithFields(logrus.Fields{"path": maintenanceTrackFile(trackFileDir)}).WithError(err).Warn("Failed to write maintenance track file")
+	}
+}
+
+// localBackend returns the HAProxy server of the node with shortHostname
+// among backends, empty when the node is not one of them
+func localBackend(backends []config.Backend, shortHostname string) string {
+	for _, b := range backends {
+		if strings.SplitN(b.Host, ".", 2)[0] == shortHostname {
+			return b.Host
+		}
+	}
+	return ""
+}
+
+// maintenanceCommand returns the command of the master socket setting the
+// state of server in the current HAProxy worker
+func maintenanceCommand(server string, maintenance bool) string {
+	state := "ready"
+	if maintenance {
+		state = "maint"
+	}
+	return fmt.Sprintf("@1 set server %s/%s state %s", haproxyAPIBackend, server, state)
+}
+
+// haproxyMaintenance keeps the API backend of the node in the maintenance
+// state of its maintenance file through the HAProxy runtime API
+type haproxyMaintenance struct {
+	file          string
+	shortHostname string
+	// applied is the state of the backend in the running HAProxy worker,
+	// a worker starts with every backend ready
+	applied bool
+}
+
+// reconcile sets the state of the backend of the node when it differs from
+// the wanted one. reloaded tells that HAProxy started a new worker since the
+// previous call.
+func (m *haproxyMaintenance) reconcile(ctx context.Context, sock *controlSocket, backends []config.Backend, reloaded bool) {
+	if reloaded {
+		m.applied = false
+	}
+	wanted := inMaintenance(m.file)
+	if wanted == m.applied {
+		return
+	}
+	server := localBackend(backends, m.shortHostname)
+	if server == "" {
+		return
+	}
+	if err := sock.Send(ctx, maintenanceCommand(server, wanted)); err != nil {
+		log.WithFields(logrus.Fields{
+			"server":      server,
+			"maintenance": wanted,
+		}).WithError(err).Error("Failed to set the maintenance state of the API backend")
+		return
+	}
+	m.applied = wanted
+	log.WithFields(logrus.Fields{
+		"server":      server,
+		"maintenance": wanted,
+		"file":        m.file,
+	}).Info("Set the maintenance state of the API backend")
+}
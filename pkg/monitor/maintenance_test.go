@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("maintenance", func() {
+	var dir, maintenanceFile string
+	var commands chan string
+	var sock *controlSocket
+	backends := []config.Backend{
+		{Host: "master-0.ostest.test.metalkube.org", Address: "192.168.111.20"},
+		{Host: "master-1.ostest.test.metalkube.org", Address: "192.168.111.21"},
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "maintenance")
+		Expect(err).ShouldNot(HaveOccurred())
+		maintenanceFile = filepath.Join(dir, "haproxy-maintenance")
+		commands = make(chan string, 10)
+		sock = newControlSocket("test-maintenance", "/test.sock")
+		sock.dial = func(string, time.Duration) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				scanner := bufio.NewScanner(server)
+				for scanner.Scan() {
+					commands <- scanner.Text()
+				}
+			}()
+			return client, nil
+		}
+	})
+
+	AfterEach(func() {
+		sock.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("finds_the_backend_of_the_node", func() {
+		Expect(localBackend(backends, "master-1")).To(Equal("master-1.ostest.test.metalkube.org"))
+		Expect(localBackend(backends, "worker-0")).To(BeEmpty())
+	})
+
+	It("sets_the_backend_state_on_changes_and_reloads", func() {
+		m := &haproxyMaintenance{file: maintenanceFile, shortHostname: "master-0"}
+		m.reconcile(context.Background(), sock, backends, false)
+		Consistently(commands, 50*time.Millisecond).ShouldNot(Receive())
+
+		Expect(ioutil.WriteFile(maintenanceFile, nil, 0644)).To(Succeed())
+		m.reconcile(context.Background(), sock, backends, false)
+		Eventually(commands).Should(Receive(Equal("@1 set server masters/master-0.ostest.test.metalkube.org state maint")))
+		m.reconcile(context.Background(), sock, backends, false)
+		Consistently(commands, 50*time.Millisecond).ShouldNot(Receive())
+
+		// The new worker of a reload starts with the backend ready
+		m.reconcile(context.Background(), sock, backends, true)
+		Eventually(commands).Should(Receive(Equal("@1 set server masters/master-0.ostest.test.metalkube.org state maint")))
+
+		Expect(os.Remove(maintenanceFile)).To(Succeed())
+		m.reconcile(context.Background(), sock, backends, false)
+		Eventually(commands).Should(Receive(Equal("@1 set server masters/master-0.ostest.test.metalkube.org state ready")))
+	})
+
+	It("writes_the_track_file", func() {
+		updateMaintenanceTrackFile(dir, maintenanceFile)
+		Expect(ioutil.ReadFile(maintenanceTrackFile(dir))).To(Equal([]byte("0\n")))
+		Expect(ioutil.WriteFile(maintenanceFile, nil, 0644)).To(Succeed())
+		updateMaintenanceTrackFile(dir, maintenanceFile)
+		Expect(ioutil.ReadFile(maintenanceTrackFile(dir))).To(Equal([]byte("1\n")))
+	})
+})
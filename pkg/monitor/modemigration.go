@@ -0,0 +1,75 @@
+package monitor
+
+import (
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/modemigration"
+)
+
+// modeMigrationPollInterval is how often the coordinated keepalived mode
+// migration is checked
+const modeMigrationPollInterval = 10 * time.Second
+
+// swapped out by the tests
+var (
+	getModeMigration     = modemigration.GetState
+	ackModeMigration     = modemigration.Acknowledge
+	appliedModeMigration = modemigration.Applied
+)
+
+// modeMigration follows the keepalived mode migration published by the
+// coordinator: it acknowledges every new epoch and asks for the switch once
+// the coordinator saw the acknowledgment of every node.
+type modeMigration struct {
+	kubeconfigPath string
+	// nodeName is the node of the monitor, the migration is left to the
+	// coordinator when empty
+	nodeName string
+	acked    int64
+	applied  int64
+	// inProgress is set while a coordinated migration runs, the mode files
+	// are ignored meanwhile
+	inProgress bool
+}
+
+// step returns the mode update to apply, if any
+func (m *modeMigration) step() *modeUpdateInfo {
+	if m.nodeName == "" {
+		return nil
+	}
+	state, err := getModeMigration(m.kubeconfigPath, os.Getenv("POD_NAMESPACE"))
+	if err != nil {
+		log.WithError(err).Warn("Failed to get the keepalived mode migration")
+		return nil
+	}
+	m.inProgress = state != nil && (state.Phase == modemigration.PhasePrepare || state.Phase == modemigration.PhaseSwitch)
+	if state == nil {
+		return nil
+	}
+	fields := logrus.Fields{"mode": state.Mode, "epoch": state.Epoch}
+	switch state.Phase {
+	case modemigration.PhasePrepare:
+		if m.acked == state.Epoch {
+			return nil
+		}
+		if err := ackModeMigration(m.kubeconfigPath, m.nodeName, state.Epoch); err != nil {
+			log.WithFields(fields).WithError(err).Warn("Failed to acknowledge the keepalived mode migration")
+			return nil
+		}
+		m.acked = state.Epoch
+		log.WithFields(fields).Info("Acknowledged the keepalived mode migration")
+	case modemigration.PhaseSwitch:
+		// A monitor that did not acknowledge the epoch, e.g. because it
+		// restarted since, leaves the node to the coordinator to report
+		if m.acked != state.Epoch || m.applied == state.Epoch {
+			return nil
+		}
+		m.applied = state.Epoch
+		log.WithFields(fields).Info("Switching the keepalived mode")
+		return &modeUpdateInfo{Mode: state.Mode, Time: time.Now(), Epoch: state.Epoch}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/modemigration"
+)
+
+var _ = Describe("mode_migration", func() {
+	var state *modemigration.State
+	var acks []int64
+	var m *modeMigration
+
+	BeforeEach(func() {
+		state, acks = nil, nil
+		m = &modeMigration{nodeName: "master-0"}
+		getModeMigration = func(kubeconfigPath, namespace string) (*modemigration.State, error) {
+			return state, nil
+		}
+		ackModeMigration = func(kubeconfigPath, nodeName string, epoch int64) error {
+			acks = append(acks, epoch)
+			return nil
+		}
+	})
+
+	AfterEach(func() {
+		getModeMigration = modemigration.GetState
+		ackModeMigration = modemigration.Acknowledge
+	})
+
+	It("acknowledges_then_switches_once", func() {
+		Expect(m.step()).To(BeNil())
+		Expect(m.inProgress).To(BeFalse())
+
+		state = &modemigration.State{Mode: modemigration.ModeUnicast, Epoch: 42, Phase: modemigration.PhasePrepare}
+		Expect(m.step()).To(BeNil())
+		Expect(m.step()).To(BeNil())
+		Expect(acks).To(Equal([]int64{42}))
+		Expect(m.inProgress).To(BeTrue())
+
+		state.Phase = modemigration.PhaseSwitch
+		update := m.step()
+		Expect(update).NotTo(BeNil())
+		Expect(update.Mode).To(Equal(modemigration.ModeUnicast))
+		Expect(update.Epoch).To(Equal(int64(42)))
+		Expect(m.step()).To(BeNil())
+
+		state.Phase = modemigration.PhaseDone
+		Expect(m.step()).To(BeNil())
+		Expect(m.inProgress).To(BeFalse())
+	})
+
+	It("does_not_switch_without_acknowledging", func() {
+		state = &modemigration.State{Mode: modemigration.ModeMulticast, Epoch: 43, Phase: modemigration.PhaseSwitch}
+		Expect(m.step()).To(BeNil())
+		Expect(acks).To(BeEmpty())
+	})
+})
@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// modeUpdateVersion is the latest schema version of monitor.conf and
+// monitor-user.conf, 1 when not set in a file
+const modeUpdateVersion = 1
+
+// modeUpdateStatusSuffix names the status file written next to a mode
+// update file
+const modeUpdateStatusSuffix = ".status"
+
+// The states of a mode update file
+const (
+	// modeUpdateRejected is a file that failed to parse or validate
+	modeUpdateRejected = "Rejected"
+	// modeUpdateAccepted is a valid file whose mode is not applied yet
+	modeUpdateAccepted = "Accepted"
+	// modeUpdateApplied is a valid file whose mode keepalived runs with
+	modeUpdateApplied = "Applied"
+)
+
+// modeUpdateStatus reports what the node did with a mode update file, for
+// whoever drives the migration to confirm that each node acted on it
+type modeUpdateStatus struct {
+	Version int `yaml:"version"`
+	// Checksum is the sha256 of the content of the file the status is
+	// about, so that a status is never mistaken for the one of a newer file
+	Checksum string `yaml:"checksum"`
+	Mode     string `yaml:"mode,omitempty"`
+	State    string `yaml:"state"`
+	Reason   string `yaml:"reason,omitempty"`
+	// AppliedTime is when keepalived was reloaded in Mode
+	AppliedTime        *time.Time `yaml:"appliedTime,omitempty"`
+	LastTransitionTime time.Time  `yaml:"lastTransitionTime"`
+}
+
+func modeUpdateStatusPath(filePath string) string {
+	return filePath + modeUpdateStatusSuffix
+}
+
+func modeUpdateChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseModeUpdate parses and validates the content of a mode update file.
+// Unknown fields are rejected, so that a typo is not silently ignored.
+func parseModeUpdate(data []byte) (modeUpdateInfo, error) {
+	info := modeUpdateInfo{}
+	if err := yaml.UnmarshalStrict(data, &info); err != nil {
+		return info, err
+	}
+	if info.Version == 0 {
+		info.Version = 1
+	}
+	if info.Version < 0 || info.Version > modeUpdateVersion {
+		return info, fmt.Errorf("Unsupported version %d, the latest is %d", info.Version, modeUpdateVersion)
+	}
+	if info.Mode != "unicast" && info.Mode != "multicast" {
+		return info, fmt.Errorf("Invalid mode %q, must be unicast or multicast", info.Mode)
+	}
+	return info, nil
+}
+
+// readModeUpdate reads the mode update file at filePath. Rejected files are
+// reported in their status file.
+func readModeUpdate(filePath string) (modeUpdateInfo, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return modeUpdateInfo{}, err
+	}
+	info, err := parseModeUpdate(data)
+	info.source = filePath
+	info.checksum = modeUpdateChecksum(data)
+	if err != nil {
+		setModeUpdateStatus(info, modeUpdateRejected, err.Error())
+		return info, fmt.Errorf("Invalid mode update file %s: %w", filePath, err)
+	}
+	return info, nil
+}
+
+// setModeUpdateStatus writes the state of the mode update info in the
+// status file of its source. The file is left alone when it already has the
+// state for the same content, so that the transition time is kept.
+func setModeUpdateStatus(info modeUpdateInfo, state, reason string) {
+	if info.source == "" {
+		return
+	}
+	statusPath := modeUpdateStatusPath(info.source)
+	status := modeUpdateStatus{}
+	if data, err := ioutil.ReadFile(statusPath); err == nil {
+		if yaml.Unmarshal(data, &status) == nil && status.Checksum == info.checksum && status.State == state {
+			return
+		}
+	}
+	now := time.Now()
+	status = modeUpdateStatus{
+		Version:            modeUpdateVersion,
+		Checksum:           info.checksum,
+		Mode:               info.Mode,
+		State:              state,
+		Reason:             reason,
+		LastTransitionTime: now,
+	}
+	if state == modeUpdateApplied {
+		status.AppliedTime = &now
+	}
+	if err := writeModeUpdateStatus(statusPath, status); err != nil {
+		log.WithFields(logrus.Fields{
+			"filename": statusPath,
+		}).WithError(err).Warn("Failed to write the mode update status")
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"filename": info.source,
+		"state":    state,
+		"reason":   reason,
+	}).Info("Mode update status changed")
+}
+
+// writeModeUpdateStatus replaces the status file at statusPath
+func writeModeUpdateStatus(statusPath string, status modeUpdateStatus) error {
+	data, err := yaml.Marshal(status)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(statusPath), "."+filepath.Base(statusPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), statusPath)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("mode_update", func() {
+	var dir, filePath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "keepalived")
+		Expect(err).ShouldNot(HaveOccurred())
+		filePath = filepath.Join(dir, "monitor-user.conf")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	readStatus := func() modeUpdateStatus {
+		data, err := os.ReadFile(modeUpdateStatusPath(filePath))
+		Expect(err).ShouldNot(HaveOccurred())
+		status := modeUpdateStatus{}
+		Expect(yaml.Unmarshal(data, &status)).To(Succeed())
+		return status
+	}
+
+	It("parses_the_versioned_schema", func() {
+		info, err := parseModeUpdate([]byte("mode: unicast\n"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Version).To(Equal(1))
+		Expect(info.Mode).To(Equal("unicast"))
+
+		info, err = parseModeUpdate([]byte("version: 1\nmode: multicast\ntime: 2024-05-02T10:05:00Z\n"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode).To(Equal("multicast"))
+		Expect(info.Time.IsZero()).To(BeFalse())
+	})
+
+	It("rejects_invalid_files", func() {
+		for _, data := range []string{
+			"mode: broadcast\n",
+			"version: 2\nmode: unicast\n",
+			"mode: unicast\nmod: multicast\n",
+			"{",
+		} {
+			_, err := parseModeUpdate([]byte(data))
+			Expect(err).To(HaveOccurred(), data)
+		}
+	})
+
+	It("reports_rejected_files", func() {
+		Expect(os.WriteFile(filePath, []byte("mode: broadcast\n"), 0644)).To(Succeed())
+		_, err := readModeUpdate(filePath)
+		Expect(err).To(HaveOccurred())
+		status := readStatus()
+		Expect(status.State).To(Equal(modeUpdateRejected))
+		Expect(status.Reason).To(ContainSubstring("broadcast"))
+		Expect(status.Checksum).To(Equal(modeUpdateChecksum([]byte("mode: broadcast\n"))))
+	})
+
+	It("reports_accepted_and_applied_files", func() {
+		Expect(os.WriteFile(filePath, []byte("mode: unicast\n"), 0644)).To(Succeed())
+		info, err := readModeUpdate(filePath)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		setModeUpdateStatus(info, modeUpdateAccepted, "")
+		accepted := readStatus()
+		Expect(accepted.State).To(Equal(modeUpdateAccepted))
+		Expect(accepted.Mode).To(Equal("unicast"))
+		Expect(accepted.AppliedTime).To(BeNil())
+
+		// The transition time is kept while the state does not change
+		setModeUpdateStatus(info, modeUpdateAccepted, "")
+		Expect(readStatus().LastTransitionTime).To(Equal(accepted.LastTransitionTime))
+
+		setModeUpdateStatus(info, modeUpdateApplied, "")
+		applied := readStatus()
+		Expect(applied.State).To(Equal(modeUpdateApplied))
+		Expect(applied.AppliedTime).NotTo(BeNil())
+	})
+
+	It("writes_no_status_without_a_mode_file", func() {
+		setModeUpdateStatus(modeUpdateInfo{Mode: "unicast"}, modeUpdateApplied, "")
+		entries, err := os.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
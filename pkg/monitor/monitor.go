@@ -1,16 +1,18 @@
 package monitor
 
 import (
-	"net"
-	"os"
-	"os/signal"
+	"context"
 	"syscall"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sirupsen/logrus"
 
+	"github.com/openshift/baremetal-runtimecfg/pkg/alerts"
 	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/events"
+	"github.com/openshift/baremetal-runtimecfg/pkg/health"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
 	"github.com/openshift/baremetal-runtimecfg/pkg/render"
 	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
 )
@@ -20,96 +22,216 @@ const cfgChangeThreshold uint8 = 3
 const k8sHealthThresholdOn uint8 = 3
 const k8sHealthThresholdOff uint8 = 11
 
-var log = logrus.New()
+var log = logging.Logger("monitor")
 
 type RuntimeConfig struct {
 	LBConfig *config.ApiLBConfig
+	// Secrets are the values of the secret files, e.g. the password of the
+	// stats page
+	Secrets render.Secrets
 }
 
-func Monitor(kubeconfigPath, clusterName, clusterDomain, templatePath, cfgPath string, apiVips []string, apiPort, lbPort, statPort uint16, interval time.Duration) error {
-	var appliedConfig, curConfig, prevConfig *config.ApiLBConfig
+// RenderSecrets returns the Secrets of the configuration to the rendering
+func (c RuntimeConfig) RenderSecrets() render.Secrets {
+	return c.Secrets
+}
+
+// haproxyReloader renders the HAProxy configuration once a change was seen
+// for cfgChangeThreshold iterations and reloads HAProxy
+type haproxyReloader struct {
+	templatePath string
+	cfgPath      string
+	files        render.FileOptions
+	sock         serviceController
+	recorder     *events.Recorder
+	notifier     *alerts.Notifier
+	// drift is told about every render, disabled when nil
+	drift *driftChecker
+	// drainer drains the removed backends before the reload, disabled when
+	// nil
+	drainer *backendDrainer
+
+	// applied is the configuration HAProxy runs, nil until the first reload
+	applied *config.ApiLBConfig
+	prev    *config.ApiLBConfig
+	// changes counts the iterations the current change was seen
+	changes uint8
+	// pending is set while a rendered configuration failed to reload
+	pending bool
+}
+
+// apply counts the iterations cur differs from the applied configuration,
+// renders and reloads it from the threshold on, or right away when forced.
+// It returns whether HAProxy started a new worker, which forgets the
+// maintenance state of the previous one, and the error of a failed render.
+func (r *haproxyReloader) apply(ctx context.Context, cur *config.ApiLBConfig, forced bool) (bool, error) {
+	defer func() { r.prev = cur }()
+	if !forced && r.applied != nil && cmp.Equal(*r.applied, *cur) {
+		r.changes = 0
+		return false, nil
+	}
+	if r.prev == nil || cmp.Equal(*r.prev, *cur) {
+		r.changes++
+	} else {
+		r.changes = 1
+	}
+	log.WithFields(logrus.Fields{
+		"curConfig":       *cur,
+		"configChangeCtr": r.changes,
+	}).Info("Config change detected")
+	if r.changes < cfgChangeThreshold && !forced {
+		return false, nil
+	}
+	log.WithFields(logrus.Fields{
+		"curConfig": *cur,
+	}).Info("Apply config change")
+	prevMD5, errPrevMD5 := utils.GetFileMd5(r.cfgPath)
+	secrets, err := render.ReadSecrets(r.files.SecretFiles)
+	if err == nil {
+		err = render.RenderFile(r.files.Apply(render.FileSpec{RenderPath: r.cfgPath, TemplatePath: r.templatePath}), RuntimeConfig{LBConfig: cur, Secrets: secrets})
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"config": *cur,
+		}).Error("Failed to render HAProxy configuration")
+		r.notifier.RenderFailed(err)
+		return false, err
+	}
+	reloaded := false
+	newMD5, err := utils.GetFileMd5(r.cfgPath)
+	if (newMD5 == prevMD5) && (errPrevMD5 == nil) && (err == nil) && !r.pending && !forced {
+		log.WithFields(logrus.Fields{
+			"curConfig": *cur,
+		}).Info("Rendered cfg file equal to previous one, no need to reload")
+	} else {
+		if r.drainer != nil && r.applied != nil {
+			if removed := removedBackends(r.applied.Backends, cur.Backends); len(removed) > 0 {
+				r.drainer.drain(ctx, removed)
+			}
+		}
+		r.pending = r.sock.Send(ctx, "reload") != nil
+		if !r.pending {
+			reloaded = true
+			r.recorder.Normal(events.ReasonHAProxyReloaded, "Reloaded HAProxy with %d API backends", len(cur.Backends))
+		}
+	}
+	// After a failed reload the change counter stays above the threshold,
+	// so the next iteration reloads again
+	if r.pending {
+		log.WithFields(logrus.Fields{
+			"socket": haproxyMasterSock,
+		}).Error("Failed to write reload to HAProxy master socket")
+		return false, nil
+	}
+	r.changes = 0
+	r.applied = cur
+	r.drift.rendered(reloaded)
+	return reloaded, nil
+}
+
+// Monitor renders the HAProxy configuration of the API from
+// opts.TemplatePath into opts.CfgPath, reloads HAProxy when it changes and
+// redirects the API traffic of the VIPs to HAProxy while it serves the API
+func Monitor(opts Options) error {
+	kubeconfigPath, cfgPath, interval := opts.KubeconfigPath, opts.CfgPath, opts.Interval
+	apiPort, lbPort := opts.APIPort, opts.LbPort
 	var K8sHealthSts bool = false
 	var oldK8sHealthSts bool
 	var k8sHealthChangeCtr uint8 = 0
-	var configChangeCtr uint8 = 0
+	apiVips := []string{}
+	for _, vip := range opts.APIVIPs {
+		apiVips = append(apiVips, vip.String())
+	}
+	backend := selectFirewallBackend(opts.FirewallBackend, opts.FirewallRuleMode)
+	firewall := newFirewallReconciler(backend, apiRedirects(apiVips, config.APIPorts(apiPort, lbPort, opts.Config.ExtraAPIPorts)))
+	reporter := newHealthReporter(opts, health.ComponentHAProxy)
+	recorder := newEventRecorder(opts, eventComponentHAProxy)
+	firewall.events = recorder
+	notifier := newAlertNotifier(opts, eventComponentHAProxy)
+	reachability := newAPIReachability(opts.APIReachabilityTrackFile, opts.APIUnreachableThreshold)
 
-	signals := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
+	serveMetrics(opts.MetricsAddress)
 
-	signal.Notify(signals, syscall.SIGTERM)
-	signal.Notify(signals, syscall.SIGINT)
-	go func() {
-		<-signals
-		done <- true
-	}()
+	ctx, cancel := notifyContext()
+	defer cancel()
+	// forced is set by SIGHUP to render and reload without waiting for the
+	// change threshold
+	refresh, stopRefresh := notifyRefresh()
+	defer stopRefresh()
+	var forced bool
 
-	conn, err := net.Dial("unix", haproxyMasterSock)
+	// The HAProxy master reloads its workers on SIGUSR2
+	sock, err := newServiceController("haproxy", haproxyMasterSock, syscall.SIGUSR2, opts.HAProxyControl)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer sock.Close()
+	reloader := &haproxyReloader{
+		templatePath: opts.TemplatePath,
+		cfgPath:      cfgPath,
+		files:        opts.Render,
+		sock:         sock,
+		recorder:     recorder,
+		notifier:     notifier,
+		drift:        newDriftChecker(opts, "haproxy", cfgPath, opts.HAProxyControl.PidFile),
+	}
 
+	maintenance := &haproxyMaintenance{file: opts.MaintenanceFile}
+	if maintenance.shortHostname, err = utils.ShortHostname(); err != nil {
+		log.WithError(err).Warn("Failed to get the hostname, the maintenance file is ignored")
+	}
+	// Only the master socket can set the state of a backend
+	masterSock, _ := sock.(*controlSocket)
+	if masterSock == nil && opts.MaintenanceFile != "" {
+		log.WithFields(logrus.Fields{
+			"control": opts.HAProxyControl.Mechanism,
+		}).Warn("The API backend is not put in maintenance without the socket service control")
+	}
+	if opts.DrainTimeout > 0 {
+		if masterSock != nil {
+			reloader.drainer = &backendDrainer{path: masterSock.path, timeout: opts.DrainTimeout, threshold: opts.DrainSessionThreshold}
+		} else {
+			log.WithFields(logrus.Fields{
+				"control": opts.HAProxyControl.Mechanism,
+			}).Warn("The removed API backends are not drained without the socket service control")
+		}
+	}
+
+	serveProbes(opts.ProbeAddress, "haproxy", loopTimeout(interval))
 	log.Info("API is not reachable through HAProxy")
 	for {
 		select {
-		case <-done:
-			for _, apiVip := range apiVips {
-				cleanHAProxyFirewallRules(apiVip, apiPort, lbPort)
+		case <-ctx.Done():
+			log.Info("Shutting down the HAProxy monitor")
+			// Also removes the rules of the VIPs that are no longer
+			// configured
+			if err := backend.flush(apiLBRuleSet); err != nil {
+				log.WithError(err).Error("Failed to flush HAProxy firewall rules")
 			}
 			return nil
 		default:
-			config, err := config.GetLBConfig(kubeconfigPath, apiPort, lbPort, statPort, []net.IP{net.ParseIP(apiVips[0])})
+			probes.Beat("haproxy")
+			// Ready once HAProxy runs with the backends
+			probes.SetReady(reloader.applied != nil)
+			config, err := config.GetLBConfig(opts.Config, kubeconfigPath, apiPort, lbPort, opts.StatPort, opts.APIVIPs[:1])
 			if err != nil {
 				log.WithFields(logrus.Fields{
 					"kubeconfigPath": kubeconfigPath,
 				}).Info("GetLBConfig failed, sleep half of interval and retry")
-				time.Sleep(interval / 2)
+				forced = sleepOrRefresh(ctx, refresh, interval/2) || forced
 				continue
 			}
-			curConfig = &config
-			if appliedConfig == nil || !cmp.Equal(*appliedConfig, *curConfig) {
-				if prevConfig == nil || cmp.Equal(*prevConfig, *curConfig) {
-					configChangeCtr++
-				} else {
-					configChangeCtr = 1
-				}
-				log.WithFields(logrus.Fields{
-					"curConfig":       *curConfig,
-					"configChangeCtr": configChangeCtr,
-				}).Info("Config change detected")
-				if configChangeCtr >= cfgChangeThreshold {
-					log.WithFields(logrus.Fields{
-						"curConfig": *curConfig,
-					}).Info("Apply config change")
-					prevMD5, errPrevMD5 := utils.GetFileMd5(cfgPath)
-					err = render.RenderFile(cfgPath, templatePath, RuntimeConfig{LBConfig: curConfig})
-					if err != nil {
-						log.WithFields(logrus.Fields{
-							"config": *curConfig,
-						}).Error("Failed to render HAProxy configuration")
-						return err
-					}
-					newMD5, err := utils.GetFileMd5(cfgPath)
-					if (newMD5 == prevMD5) && (errPrevMD5 == nil) && (err == nil) {
-						log.WithFields(logrus.Fields{
-							"curConfig": *curConfig,
-						}).Info("Rendered cfg file equal to previous one, no need to reload")
-					} else {
-						_, err = conn.Write([]byte("reload\n"))
-						if err != nil {
-							log.WithFields(logrus.Fields{
-								"socket": haproxyMasterSock,
-							}).Error("Failed to write reload to HAProxy master socket")
-							return err
-						}
-					}
-					configChangeCtr = 0
-					appliedConfig = curConfig
-				}
-			} else {
-				configChangeCtr = 0
+			if reloader.drift.check() && opts.RerenderOnDrift {
+				log.Info("Rendering the drifted HAProxy configuration again")
+				forced = true
+			}
+			reloaded, err := reloader.apply(ctx, &config, forced)
+			if err != nil {
+				return err
+			}
+			if reloader.applied != nil && masterSock != nil {
+				maintenance.reconcile(ctx, masterSock, reloader.applied.Backends, reloaded)
 			}
-			prevConfig = &config
 
 			curK8sHealthSts, err := utils.IsKubernetesHealthy(lbPort)
 			if err != nil {
@@ -117,25 +239,28 @@ func Monitor(kubeconfigPath, clusterName, clusterDomain, templatePath, cfgPath s
 			}
 			oldK8sHealthSts = K8sHealthSts
 			K8sHealthSts, k8sHealthChangeCtr = utils.AlarmStabilization(K8sHealthSts, curK8sHealthSts, k8sHealthChangeCtr, k8sHealthThresholdOn, k8sHealthThresholdOff)
-			if K8sHealthSts {
-				if oldK8sHealthSts != K8sHealthSts {
-					log.Info("API is reachable through HAProxy")
-				}
-				for _, apiVip := range apiVips {
-					err := ensureHAProxyFirewallRules(apiVip, apiPort, lbPort)
-					if err != nil {
-						log.WithFields(logrus.Fields{"err": err}).Error("Failed to ensure HAProxy firewall rules to direct traffic to the LB")
-					}
+			if oldK8sHealthSts != K8sHealthSts {
+				after := log.WithFields(logrus.Fields{
+					"after": reachability.lasted().Round(time.Second).String(),
+				})
+				if K8sHealthSts {
+					after.Info("API is reachable through HAProxy")
+				} else {
+					after.Info("API is not reachable through HAProxy")
 				}
+			}
+			reachability.update(K8sHealthSts)
+			// Reconciling on every iteration restores the rules if anything
+			// else deletes them
+			firewall.setDesired(K8sHealthSts)
+			firewall.reconcile()
+			notifier.Health(K8sHealthSts, "API is not reachable through HAProxy")
+			if K8sHealthSts {
+				reporter.Report(true, "")
 			} else {
-				if oldK8sHealthSts != K8sHealthSts {
-					log.Info("API is not reachable through HAProxy")
-				}
-				for _, apiVip := range apiVips {
-					cleanHAProxyFirewallRules(apiVip, apiPort, lbPort)
-				}
+				reporter.Report(false, "API is not reachable through HAProxy")
 			}
-			time.Sleep(interval)
+			forced = sleepOrRefresh(ctx, refresh, interval)
 		}
 	}
 }
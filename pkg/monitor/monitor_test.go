@@ -0,0 +1,159 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor/monitortest"
+)
+
+var _ = Describe("haproxy_reloader", func() {
+	var dir string
+	var api *monitortest.APIServer
+	var ctl *monitortest.Controller
+	var r *haproxyReloader
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "haproxy")
+		Expect(err).ShouldNot(HaveOccurred())
+		api, err = monitortest.NewAPIServer(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		api.SetNodes(
+			monitortest.Node("master-0", "master", "192.168.111.20"),
+			monitortest.Node("master-1", "master", "192.168.111.21"),
+			monitortest.Node("master-2", "master", "192.168.111.22"),
+			monitortest.Node("worker-0", "worker", "192.168.111.23"),
+		)
+		// Every iteration lists the nodes again
+		config.SetNodeCacheOptions(config.NodeCacheOptions{})
+
+		templatePath := filepath.Join(dir, "haproxy.cfg.tmpl")
+		Expect(os.WriteFile(templatePath, []byte("{{ range .LBConfig.Backends }}server {{ .Host }} {{ .Address }}:{{ .Port }}\n{{ end }}"), 0644)).To(Succeed())
+		ctl = &monitortest.Controller{}
+		r = &haproxyReloader{
+			templatePath: templatePath,
+			cfgPath:      filepath.Join(dir, "haproxy.cfg"),
+			sock:         ctl,
+		}
+	})
+
+	AfterEach(func() {
+		api.Close()
+		config.SetNodeCacheOptions(config.DefaultNodeCacheOptions())
+		os.RemoveAll(dir)
+	})
+
+	// iterate runs an iteration of the monitor loop
+	iterate := func(forced bool) bool {
+		cfg, err := config.GetLBConfig(config.DefaultOptions(), api.Kubeconfig, 6443, 9445, 29445, []net.IP{net.ParseIP("192.168.111.5")})
+		Expect(err).ShouldNot(HaveOccurred())
+		reloaded, err := r.apply(context.Background(), &cfg, forced)
+		Expect(err).ShouldNot(HaveOccurred())
+		return reloaded
+	}
+
+	rendered := func() string {
+		data, err := os.ReadFile(r.cfgPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(data)
+	}
+
+	It("reloads_once_the_change_is_seen_for_the_threshold", func() {
+		for i := uint8(1); i < cfgChangeThreshold; i++ {
+			Expect(iterate(false)).To(BeFalse())
+		}
+		Expect(ctl.Commands()).To(BeEmpty())
+		Expect(r.applied).To(BeNil())
+
+		Expect(iterate(false)).To(BeTrue())
+		Expect(ctl.Commands()).To(Equal([]string{"reload"}))
+		Expect(rendered()).To(Equal("server master-0 192.168.111.20:6443\nserver master-1 192.168.111.21:6443\nserver master-2 192.168.111.22:6443\n"))
+
+		Expect(iterate(false)).To(BeFalse())
+		Expect(ctl.Commands()).To(HaveLen(1))
+
+		api.SetNodes(
+			monitortest.Node("master-0", "master", "192.168.111.20"),
+			monitortest.Node("master-1", "master", "192.168.111.21"),
+		)
+		for i := uint8(1); i < cfgChangeThreshold; i++ {
+			Expect(iterate(false)).To(BeFalse())
+		}
+		Expect(iterate(false)).To(BeTrue())
+		Expect(ctl.Commands()).To(Equal([]string{"reload", "reload"}))
+		Expect(rendered()).NotTo(ContainSubstring("master-2"))
+	})
+
+	It("drains_the_removed_backends_before_reloading", func() {
+		prevQuery := queryControlSocket
+		defer func() { queryControlSocket = prevQuery }()
+		drained := make(chan string, 10)
+		r.drainer = &backendDrainer{path: "/test.sock", timeout: time.Minute}
+		reloadsWhileDraining := -1
+		queryControlSocket = func(_ context.Context, _, command string) (string, error) {
+			if command != "@1 show stat" {
+				drained <- command
+				return "", nil
+			}
+			reloadsWhileDraining = len(ctl.Commands())
+			return "# pxname,svname,qcur,qmax,scur\nmasters,master-2,0,0,0\n", nil
+		}
+
+		Expect(iterate(true)).To(BeTrue())
+		Expect(reloadsWhileDraining).To(Equal(-1))
+
+		api.SetNodes(
+			monitortest.Node("master-0", "master", "192.168.111.20"),
+			monitortest.Node("master-1", "master", "192.168.111.21"),
+		)
+		Expect(iterate(true)).To(BeTrue())
+		Expect(drained).To(Receive(Equal("@1 set server masters/master-2 state drain")))
+		Expect(reloadsWhileDraining).To(Equal(1))
+		Expect(ctl.Commands()).To(Equal([]string{"reload", "reload"}))
+	})
+
+	It("counts_again_when_the_change_changes", func() {
+		Expect(iterate(false)).To(BeFalse())
+		Expect(iterate(false)).To(BeFalse())
+		api.SetNodes(monitortest.Node("master-0", "master", "192.168.111.20"))
+		Expect(iterate(false)).To(BeFalse())
+		Expect(r.changes).To(Equal(uint8(1)))
+		Expect(ctl.Commands()).To(BeEmpty())
+	})
+
+	It("reloads_again_after_a_failed_reload", func() {
+		ctl.FailNext(1)
+		for i := uint8(0); i < cfgChangeThreshold; i++ {
+			Expect(iterate(false)).To(BeFalse())
+		}
+		Expect(r.applied).To(BeNil())
+		Expect(r.pending).To(BeTrue())
+
+		// The rendered file did not change, the pending reload is sent
+		Expect(iterate(false)).To(BeTrue())
+		Expect(ctl.Commands()).To(Equal([]string{"reload"}))
+		Expect(r.applied).NotTo(BeNil())
+	})
+
+	It("reloads_right_away_when_forced", func() {
+		Expect(iterate(true)).To(BeTrue())
+		Expect(iterate(true)).To(BeTrue())
+		Expect(ctl.Commands()).To(Equal([]string{"reload", "reload"}))
+	})
+
+	It("keeps_the_backends_while_the_api_is_down", func() {
+		Expect(iterate(true)).To(BeTrue())
+		api.SetFailing(true)
+		_, err := config.GetLBConfig(config.DefaultOptions(), api.Kubeconfig, 6443, 9445, 29445, []net.IP{net.ParseIP("192.168.111.5")})
+		Expect(err).To(HaveOccurred())
+		Expect(r.applied.Backends).To(HaveLen(3))
+	})
+})
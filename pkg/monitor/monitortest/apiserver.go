@@ -0,0 +1,154 @@
+package monitortest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// APIServer serves the nodes and the objects set on it like the API server
+// of a cluster, to the clients built from its Kubeconfig
+type APIServer struct {
+	// Kubeconfig is the path of a kubeconfig of the server
+	Kubeconfig string
+
+	server  *httptest.Server
+	lock    sync.Mutex
+	nodes   []v1.Node
+	objects map[string]interface{}
+	failing bool
+}
+
+// NewAPIServer starts an API server and writes its kubeconfig in dir
+func NewAPIServer(dir string) (*APIServer, error) {
+	s := &APIServer{
+		Kubeconfig: filepath.Join(dir, "kubeconfig"),
+		objects:    map[string]interface{}{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user: {}
+`, s.server.URL)
+	if err := os.WriteFile(s.Kubeconfig, []byte(kubeconfig), 0600); err != nil {
+		s.server.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stops the server
+func (s *APIServer) Close() {
+	s.server.Close()
+}
+
+// SetNodes replaces the nodes listed by the server
+func (s *APIServer) SetNodes(nodes ...v1.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodes = nodes
+}
+
+// Set serves obj on GET path, e.g.
+// /api/v1/namespaces/openshift-kni-infra/configmaps/keepalived-settings
+func (s *APIServer) Set(path string, obj interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[path] = obj
+}
+
+// SetFailing makes every request fail while failing is set, like an API
+// server that is down
+func (s *APIServer) SetFailing(failing bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failing = failing
+}
+
+func (s *APIServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failing {
+		writeStatus(w, http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, "the server is currently unable to handle the request")
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, req.Method+" is not supported")
+		return
+	}
+	if req.URL.Path == "/api/v1/nodes" {
+		selector, err := labels.Parse(req.URL.Query().Get("labelSelector"))
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+			return
+		}
+		list := v1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}}
+		for _, node := range s.nodes {
+			if selector.Matches(labels.Set(node.Labels)) {
+				list.Items = append(list.Items, node)
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	if obj, ok := s.objects[req.URL.Path]; ok {
+		writeJSON(w, http.StatusOK, obj)
+		return
+	}
+	writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, req.URL.Path+" not found")
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+// Node returns a Ready node of role, e.g. master or worker, with its
+// internal addresses
+func Node(name, role string, addresses ...string) v1.Node {
+	node := v1.Node{
+		TypeMeta: metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"node-role.kubernetes.io/" + role: ""},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+	for _, address := range addresses {
+		node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: address})
+	}
+	return node
+}
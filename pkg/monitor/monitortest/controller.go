@@ -0,0 +1,50 @@
+// Package monitortest fakes the environment of the monitor loops: the
+// keepalived and HAProxy control sockets, the API server and the netlink
+// address and route listings, so that the control loops of the monitor
+// package are covered by Go tests.
+package monitortest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Controller records the commands sent to keepalived or HAProxy. It has the
+// methods of the service controllers of the monitors, so it replaces them in
+// the tests of the monitor package.
+type Controller struct {
+	lock     sync.Mutex
+	commands []string
+	failures int
+}
+
+// Send records command, or fails while failures are left
+func (c *Controller) Send(ctx context.Context, command string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("injected control failure")
+	}
+	c.commands = append(c.commands, strings.TrimSpace(command))
+	return nil
+}
+
+// Close does nothing
+func (c *Controller) Close() {}
+
+// FailNext makes the next n commands fail
+func (c *Controller) FailNext(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failures = n
+}
+
+// Commands returns the commands sent so far
+func (c *Controller) Commands() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.commands...)
+}
@@ -0,0 +1,78 @@
+package monitortest
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"sync"
+)
+
+// ControlSocket listens on a unix socket like the control socket of the
+// keepalived container or the HAProxy master socket, and records the lines
+// written to it
+type ControlSocket struct {
+	// Path is where the socket listens
+	Path string
+
+	listener net.Listener
+	lock     sync.Mutex
+	commands []string
+	received chan struct{}
+}
+
+// NewControlSocket listens on name in dir
+func NewControlSocket(dir, name string) (*ControlSocket, error) {
+	path := filepath.Join(dir, name)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ControlSocket{
+		Path:     path,
+		listener: listener,
+		received: make(chan struct{}, 100),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *ControlSocket) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.read(conn)
+	}
+}
+
+func (s *ControlSocket) read(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.lock.Lock()
+		s.commands = append(s.commands, scanner.Text())
+		s.lock.Unlock()
+		select {
+		case s.received <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Received is notified of each command
+func (s *ControlSocket) Received() <-chan struct{} {
+	return s.received
+}
+
+// Commands returns the commands received so far
+func (s *ControlSocket) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Close stops listening
+func (s *ControlSocket) Close() {
+	s.listener.Close()
+}
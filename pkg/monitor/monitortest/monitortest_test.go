@@ -0,0 +1,105 @@
+package monitortest
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var _ = Describe("api_server", func() {
+	var dir string
+	var api *APIServer
+	var clientset *kubernetes.Clientset
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "monitortest")
+		Expect(err).ShouldNot(HaveOccurred())
+		api, err = NewAPIServer(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		config, err := clientcmd.BuildConfigFromFlags("", api.Kubeconfig)
+		Expect(err).ShouldNot(HaveOccurred())
+		clientset, err = kubernetes.NewForConfig(config)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		api.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("lists_the_nodes_matching_the_selector", func() {
+		api.SetNodes(Node("master-0", "master", "192.168.111.20"), Node("worker-0", "worker", "192.168.111.23"))
+		nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "node-role.kubernetes.io/master="})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(nodes.Items).To(HaveLen(1))
+		Expect(nodes.Items[0].Name).To(Equal("master-0"))
+		Expect(nodes.Items[0].Status.Addresses[0].Address).To(Equal("192.168.111.20"))
+	})
+
+	It("serves_the_objects_set_on_it", func() {
+		_, err := clientset.CoreV1().ConfigMaps("openshift-kni-infra").Get(context.TODO(), "keepalived-settings", metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+
+		api.Set("/api/v1/namespaces/openshift-kni-infra/configmaps/keepalived-settings", map[string]interface{}{
+			"kind":       "ConfigMap",
+			"apiVersion": "v1",
+			"metadata":   map[string]string{"name": "keepalived-settings"},
+			"data":       map[string]string{"advert-int": "2"},
+		})
+		cm, err := clientset.CoreV1().ConfigMaps("openshift-kni-infra").Get(context.TODO(), "keepalived-settings", metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cm.Data).To(HaveKeyWithValue("advert-int", "2"))
+
+		api.SetFailing(true)
+		_, err = clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("interfaces", func() {
+	It("lists_the_addresses_and_routes", func() {
+		ifaces := NewInterfaces().
+			AddAddress("eth0", "192.168.111.20/24").
+			AddAddress("eth0", "192.168.111.5/32").
+			AddAddress("eth1", "fd00::20/64").
+			AddRoute("eth0", "", "192.168.111.1")
+
+		addrs, err := ifaces.AddressMap(func(addr netlink.Addr) bool { return addr.IP.To4() != nil })
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addrs).To(HaveLen(1))
+		Expect(addrs[ifaces.Link("eth0")]).To(HaveLen(2))
+
+		routes, err := ifaces.RouteMap(nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(routes[ifaces.Link("eth0").Attrs().Index][0].Gw.String()).To(Equal("192.168.111.1"))
+
+		all, err := ifaces.InterfaceAddrs()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(all).To(HaveLen(3))
+		Expect(all[1].(*net.IPNet).IP.String()).To(Equal("192.168.111.5"))
+	})
+})
+
+var _ = Describe("controller", func() {
+	It("records_the_commands", func() {
+		c := &Controller{}
+		c.FailNext(1)
+		Expect(c.Send(context.Background(), "reload\n")).NotTo(Succeed())
+		Expect(c.Send(context.Background(), "reload\n")).To(Succeed())
+		Expect(c.Commands()).To(Equal([]string{"reload"}))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Monitor test harness tests")
+}
@@ -0,0 +1,112 @@
+package monitortest
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// Interfaces are the links of a node with their addresses and routes, like
+// the interface maps of the address tests of the utils package
+type Interfaces struct {
+	links  []netlink.Link
+	addrs  map[netlink.Link][]netlink.Addr
+	routes map[int][]netlink.Route
+}
+
+// NewInterfaces returns a node with no links
+func NewInterfaces() *Interfaces {
+	return &Interfaces{
+		addrs:  map[netlink.Link][]netlink.Addr{},
+		routes: map[int][]netlink.Route{},
+	}
+}
+
+// Link returns the link named name, added with the next index when new
+func (i *Interfaces) Link(name string) netlink.Link {
+	for _, link := range i.links {
+		if link.Attrs().Name == name {
+			return link
+		}
+	}
+	link := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: len(i.links), Name: name}}
+	i.links = append(i.links, link)
+	return link
+}
+
+// AddAddress assigns the address cidr, e.g. 192.168.111.20/24, to the link
+// name
+func (i *Interfaces) AddAddress(name, cidr string) *Interfaces {
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		panic(fmt.Sprintf("bad address %q", cidr))
+	}
+	// Not deprecated
+	addr.PreferedLft = 999
+	link := i.Link(name)
+	i.addrs[link] = append(i.addrs[link], *addr)
+	return i
+}
+
+// AddRoute adds a route to destination, the default route when empty,
+// through gateway on the link name
+func (i *Interfaces) AddRoute(name, destination, gateway string) *Interfaces {
+	var dst *net.IPNet
+	if destination != "" {
+		var err error
+		if _, dst, err = net.ParseCIDR(destination); err != nil {
+			panic(fmt.Sprintf("bad route destination %q", destination))
+		}
+	}
+	index := i.Link(name).Attrs().Index
+	i.routes[index] = append(i.routes[index], netlink.Route{
+		LinkIndex: index,
+		Dst:       dst,
+		Protocol:  unix.RTPROT_KERNEL,
+		Gw:        net.ParseIP(gateway),
+	})
+	return i
+}
+
+// AddressMap lists the addresses matching filter by link, like the netlink
+// listing of the utils package
+func (i *Interfaces) AddressMap(filter utils.AddressFilter) (map[netlink.Link][]netlink.Addr, error) {
+	addrMap := map[netlink.Link][]netlink.Addr{}
+	for link, addrs := range i.addrs {
+		for _, addr := range addrs {
+			if filter == nil || filter(addr) {
+				addrMap[link] = append(addrMap[link], addr)
+			}
+		}
+	}
+	return addrMap, nil
+}
+
+// RouteMap lists the routes matching filter by link index, like the netlink
+// listing of the utils package
+func (i *Interfaces) RouteMap(filter utils.RouteFilter) (map[int][]netlink.Route, error) {
+	routeMap := map[int][]netlink.Route{}
+	for index, routes := range i.routes {
+		for _, route := range routes {
+			if filter == nil || filter(route) {
+				routeMap[index] = append(routeMap[index], route)
+			}
+		}
+	}
+	return routeMap, nil
+}
+
+// InterfaceAddrs lists the addresses of every link like net.InterfaceAddrs
+func (i *Interfaces) InterfaceAddrs() ([]net.Addr, error) {
+	addrs := []net.Addr{}
+	for _, link := range i.links {
+		for _, addr := range i.addrs[link] {
+			addrs = append(addrs, addr.IPNet)
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,211 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var nftHandleRegexp = regexp.MustCompile(`comment "([^"]*)" # handle (\d+)$`)
+
+// nftChainHooks are the base chains of the table, hooked where the iptables
+// chains of the same name are
+var nftChainHooks = map[string]string{
+	"prerouting":        "type nat hook prerouting priority -100",
+	"output":            "type nat hook output priority -100",
+	"input":             "type nat hook input priority 100",
+	"mangle_prerouting": "type filter hook prerouting priority -150",
+}
+
+// nftBackend manages the rules in a dedicated nftables table, for hosts that
+// have no iptables compat layer
+type nftBackend struct {
+	// mode is how the traffic is sent to the target port
+	mode string
+}
+
+type nftRule struct {
+	chain string
+	expr  string
+	// comment identifies the rule, since nft does not list expressions the
+	// way they were written
+	comment string
+}
+
+func nftFamily(apiVip string) string {
+	if net.ParseIP(apiVip).To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// getNftRules returns the nftables equivalent of getRedirectRules
+func getNftRules(mode string, r portRedirect) []nftRule {
+	portMatch := fmt.Sprintf("%s daddr %s tcp dport %d", nftFamily(r.vip), r.vip, r.port)
+	dnat := "dnat to " + hostPort(r.vip, r.targetPort)
+	rule := func(chain, expr string) nftRule {
+		return nftRule{chain, expr, fmt.Sprintf("%s %s %s %s:%d:%d", r.set.comment, mode, chain, r.vip, r.port, r.targetPort)}
+	}
+
+	switch mode {
+	case RuleModeDNATSNAT:
+		return []nftRule{
+			rule("prerouting", portMatch+" "+dnat),
+			rule("output", "oifname \"lo\" "+portMatch+" "+dnat),
+			rule("input", fmt.Sprintf("%s daddr %s tcp dport %d snat to %s", nftFamily(r.vip), r.vip, r.targetPort, r.vip)),
+		}
+	case RuleModeDNATMark:
+		return []nftRule{
+			rule("mangle_prerouting", portMatch+" meta mark set meta mark or "+apiLBMark),
+			rule("prerouting", portMatch+" meta mark and "+apiLBMark+" == "+apiLBMark+" "+dnat),
+			rule("output", "oifname \"lo\" "+portMatch+" "+dnat),
+		}
+	}
+	return []nftRule{
+		rule("prerouting", fmt.Sprintf("%s redirect to :%d", portMatch, r.targetPort)),
+		rule("output", fmt.Sprintf("oifname \"lo\" %s redirect to :%d", portMatch, r.targetPort)),
+	}
+}
+
+func runNft(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("nft", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("nft %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// findNftRuleHandles returns the handles of the rules with comment in the
+// output of nft -a list chain
+func findNftRuleHandles(listing, comment string) []string {
+	handles := []string{}
+	for _, line := range strings.Split(listing, "\n") {
+		m := nftHandleRegexp.FindStringSubmatch(line)
+		if m != nil && m[1] == comment {
+			handles = append(handles, m[2])
+		}
+	}
+	return handles
+}
+
+// ruleHandles lists the handles of rule. A missing table or chain means no
+// rule rather than an error.
+func (nftBackend) ruleHandles(family, table string, rule nftRule) ([]string, error) {
+	listing, err := runNft("-a", "list", "chain", family, table, rule.chain)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return findNftRuleHandles(listing, rule.comment), nil
+}
+
+func (n nftBackend) deleteRules(family, table string, rules []nftRule) error {
+	for _, rule := range rules {
+		handles, err := n.ruleHandles(family, table, rule)
+		if err != nil {
+			return err
+		}
+		for _, handle := range handles {
+			log.WithFields(logrus.Fields{
+				"spec": rule.expr,
+			}).Infof("Removing existing nft %s rule", rule.chain)
+			if _, err := runNft("delete", "rule", family, table, rule.chain, "handle", handle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensure inserts the missing rules of the current mode, after removing the
+// rules left by a previous mode
+func (n nftBackend) ensure(r portRedirect) error {
+	family, table := nftFamily(r.vip), r.set.nftTable
+	for _, mode := range ruleModes {
+		if mode == n.mode {
+			continue
+		}
+		if err := n.deleteRules(family, table, getNftRules(mode, r)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := runNft("add", "table", family, table); err != nil {
+		return err
+	}
+	for _, rule := range getNftRules(n.mode, r) {
+		hook := fmt.Sprintf("{ %s ; }", nftChainHooks[rule.chain])
+		if _, err := runNft("add", "chain", family, table, rule.chain, hook); err != nil {
+			return err
+		}
+		handles, err := n.ruleHandles(family, table, rule)
+		if err != nil {
+			return err
+		}
+		if len(handles) > 0 {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"spec": rule.expr,
+		}).Infof("Inserting nft %s rule", rule.chain)
+		// nft parses its arguments joined together, so the quoted comment
+		// keeps its spaces
+		if _, err := runNft("insert", "rule", family, table, rule.chain, rule.expr, "comment", fmt.Sprintf("%q", rule.comment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n nftBackend) check(r portRedirect) (bool, error) {
+	family, table := nftFamily(r.vip), r.set.nftTable
+	for _, rule := range getNftRules(n.mode, r) {
+		handles, err := n.ruleHandles(family, table, rule)
+		if err != nil {
+			return false, err
+		}
+		if len(handles) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// clean removes the rules of every mode
+func (n nftBackend) clean(r portRedirect) error {
+	family, table := nftFamily(r.vip), r.set.nftTable
+	for _, mode := range ruleModes {
+		if err := n.deleteRules(family, table, getNftRules(mode, r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush deletes the table of set for both IP families
+func (nftBackend) flush(set ruleSet) error {
+	for _, family := range []string{"ip", "ip6"} {
+		if _, err := runNft("list", "table", family, set.nftTable); err != nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"table": family + " " + set.nftTable,
+		}).Info("Deleting nft table")
+		if _, err := runNft("delete", "table", family, set.nftTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
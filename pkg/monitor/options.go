@@ -0,0 +1,204 @@
+package monitor
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/alerts"
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+// Options configure a monitor. The commands start from DefaultOptions and
+// set them from their flags, each monitor only reads the ones it uses.
+type Options struct {
+	KubeconfigPath string
+	// ClusterConfigPath is the cluster-config ConfigMap, optional
+	ClusterConfigPath string
+	// TemplatePath is rendered into CfgPath
+	TemplatePath string
+	CfgPath      string
+	APIVIPs      []net.IP
+	IngressVIPs  []net.IP
+	// APIPort is where the OpenShift API listens
+	APIPort uint16
+	// LbPort is where HAProxy listens for the API
+	LbPort uint16
+	// StatPort is where HAProxy serves its stats
+	StatPort uint16
+	// Interval is the time between the checks of the monitor loop
+	Interval time.Duration
+	// MetricsAddress is where /metrics is served, disabled when empty
+	MetricsAddress string
+	// ProbeAddress is where the liveness and readiness of the monitor loops
+	// are served, disabled when empty
+	ProbeAddress string
+
+	// NodeName is the node the monitor runs on. Its annotations receive the
+	// health of the monitored component, for the on-prem networking
+	// ClusterOperator, and its overrides are applied by the keepalived
+	// monitor. Both are disabled when empty.
+	NodeName string
+	// HealthHeartbeat is how often an unchanged health is published again
+	HealthHeartbeat time.Duration
+	// EmitEvents enables the Events recording the failover related actions
+	// of the monitors. The service account of the monitors needs to be
+	// allowed to create Events in their namespace.
+	EmitEvents bool
+	// Alerts configure the webhook the monitors post their alerts to,
+	// disabled when its URL is empty
+	Alerts alerts.Options
+	// DetectConfigDrift compares the rendered configuration files with what
+	// the monitor rendered and what the processes loaded on every iteration
+	DetectConfigDrift bool
+	// RerenderOnDrift forces a render and reload of a drifted configuration
+	RerenderOnDrift bool
+	// ShutdownTimeout bounds how long a monitor waits for its goroutines
+	// after SIGTERM before it cleans up anyway
+	ShutdownTimeout time.Duration
+	// Render are the template overrides, kept generations and secrets of
+	// every rendered file
+	Render render.FileOptions
+	// Config are the settings of the computation of the node config
+	Config       config.Options
+	SharedConfig SharedConfigOptions
+	Steady       SteadyOptions
+	// GatherIngressShards makes the monitors read the IngressControllers
+	// that have their own ingress VIPs into the config
+	GatherIngressShards bool
+	// MaintenanceFile puts the node in maintenance while it exists: the
+	// HAProxy monitor marks the API backend of the node down and the
+	// keepalived monitor drops its priority so that the VIPs move to another
+	// node. Disabled when empty.
+	MaintenanceFile string
+	// FirewallBackend selects how the redirect rules are managed. With
+	// FirewallBackendAuto iptables is used when its binary is available and
+	// does not drive the legacy xtables, and nftables otherwise.
+	FirewallBackend string
+	// FirewallRuleMode is how the API traffic of the VIPs is sent to HAProxy
+	FirewallRuleMode string
+
+	// KeepalivedControl is how the keepalived monitor drives keepalived
+	KeepalivedControl ServiceControlOptions
+	// KeepalivedPidFile is the pid file of keepalived. The reloads are only
+	// verified when it is set and the monitor shares the PID namespace of
+	// keepalived, since the verification signals keepalived.
+	KeepalivedPidFile string
+	// KeepalivedDataFile is where keepalived writes its data dump on
+	// SIGUSR1, as seen by the monitor
+	KeepalivedDataFile string
+	// LeaseStatusFile is where the state of the leased VIPs is written, so
+	// that it can be checked without scraping the logs. Disabled when empty.
+	LeaseStatusFile string
+	// TrackFileDir holds the keepalived track files written by the monitor:
+	// lease-mismatch-<vip>, firewall-rules-<family> and maintenance
+	TrackFileDir string
+	// DHCPClient selects the client leasing the VIPs
+	DHCPClient string
+	// VIPProbeTimeout is how long the keepalived monitor waits for hosts
+	// answering for a VIP before it renders a configuration that introduces
+	// it. Zero disables the duplicate address detection.
+	VIPProbeTimeout time.Duration
+	// HooksDir holds the master.d, backup.d and fault.d directories whose
+	// executables are run when a VIP enters the state. Disabled when empty.
+	HooksDir string
+	// IngressRedirectPorts are the ingress VIP ports redirected to the same
+	// port of the local node, so that the node and its pods reach the local
+	// router through the VIP wherever the VIP is. Empty disables the ingress
+	// rules.
+	IngressRedirectPorts []uint16
+	// UnicastPeerCIDRs restrict the keepalived unicast peers to the
+	// addresses they contain, typically the machine networks, so that a
+	// Node object with a forged address cannot become a VRRP peer. Every
+	// address is allowed when empty.
+	UnicastPeerCIDRs []net.IPNet
+	// VIPAdvertisement selects how the node claims the VIPs: with VRRP
+	// through keepalived, by advertising them through BGP with FRR while
+	// their local service is healthy, both, or by holding a coordination
+	// Lease per VIP
+	VIPAdvertisement string
+	// BGP is the session the VIPs are advertised through when
+	// VIPAdvertisement includes BGP
+	BGP       BGPConfig
+	VIPLeases VIPLeaseOptions
+	VIPRoutes VIPRouteOptions
+
+	// HAProxyControl is how the HAProxy monitor drives HAProxy
+	HAProxyControl ServiceControlOptions
+	// DrainTimeout is how long the API backends removed from the HAProxy
+	// configuration are drained before the reload that removes them.
+	// Disabled when zero.
+	DrainTimeout time.Duration
+	// DrainSessionThreshold is the number of sessions of the drained
+	// backends HAProxy reloads at, without waiting for DrainTimeout
+	DrainSessionThreshold int
+	// APIReachabilityTrackFile is the keepalived track file holding 1 while
+	// the API has not been reachable through HAProxy for
+	// APIUnreachableThreshold, so that a node whose local LB keeps failing
+	// gives up the API VIP. Disabled when empty.
+	APIReachabilityTrackFile string
+	// APIUnreachableThreshold is how long the API must be unreachable
+	// through HAProxy before the track file is set
+	APIUnreachableThreshold time.Duration
+
+	// ClusterLBConfig are the cloud load balancers the DNS records of the
+	// coredns monitor point at, or the load balancers of the user
+	ClusterLBConfig config.ClusterLBConfig
+	// DNSView is which api record targets the node-local DNS serves:
+	// internal (VIPs) or external (cloud LBs)
+	DNSView       string
+	IngressFilter config.IngressNodeFilter
+	// HealthAddress is where the coredns monitor serves /healthz, disabled
+	// when empty
+	HealthAddress string
+	// ExtraFiles are rendered together with the Corefile, with Render
+	ExtraFiles []render.FileSpec
+	// GateAppsWildcard makes the DNS monitors leave the *.apps wildcard out
+	// while no router of the default IngressController is ready
+	GateAppsWildcard bool
+
+	// DnsmasqPidFile is the pid file of the dnsmasq process to send SIGHUP
+	// to, the DBus cache clear is used when it is empty or unreadable
+	DnsmasqPidFile string
+	// BMHNamespace is the namespace of the BareMetalHosts whose DHCP static
+	// leases dnsmasq serves. Disabled when empty.
+	BMHNamespace string
+}
+
+// DefaultOptions returns the options of a monitor before the flags are
+// applied
+func DefaultOptions() Options {
+	return Options{
+		HealthHeartbeat:         time.Minute,
+		Alerts:                  alerts.DefaultOptions,
+		ShutdownTimeout:         10 * time.Second,
+		Render:                  render.FileOptions{OverrideDir: render.DefaultTemplateOverrideDir},
+		Config:                  config.DefaultOptions(),
+		SharedConfig:            SharedConfigOptions{MaxAge: 30 * time.Second},
+		Steady:                  SteadyOptions{MaxInterval: 5 * time.Minute},
+		MaintenanceFile:         "/run/runtimecfg/haproxy-maintenance",
+		FirewallBackend:         FirewallBackendAuto,
+		FirewallRuleMode:        RuleModeRedirect,
+		KeepalivedControl:       ServiceControlOptions{Mechanism: ServiceControlSocket, Unit: "keepalived.service"},
+		KeepalivedDataFile:      "/tmp/keepalived.data",
+		LeaseStatusFile:         "/var/run/keepalived/lease-status.json",
+		TrackFileDir:            "/var/run/keepalived",
+		DHCPClient:              DHCPClientAuto,
+		HooksDir:                "/etc/runtimecfg/hooks",
+		VIPAdvertisement:        VIPAdvertisementVRRP,
+		VIPLeases:               VIPLeaseOptions{Duration: 15 * time.Second, RenewDeadline: 10 * time.Second, RetryPeriod: 2 * time.Second},
+		VIPRoutes:               VIPRouteOptions{Table: unix.RT_TABLE_MAIN},
+		HAProxyControl:          ServiceControlOptions{Mechanism: ServiceControlSocket, Unit: "haproxy.service"},
+		APIUnreachableThreshold: 2 * time.Minute,
+	}
+}
+
+// keepalivedPidFile returns the pid file of keepalived, empty when unknown
+func (o Options) keepalivedPidFile() string {
+	if o.KeepalivedControl.PidFile != "" {
+		return o.KeepalivedControl.PidFile
+	}
+	return o.KeepalivedPidFile
+}
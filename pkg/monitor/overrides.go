@@ -0,0 +1,43 @@
+package monitor
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// getNodeOverrides is swapped out by the tests
+var getNodeOverrides = config.GetNodeOverrides
+
+// nodeOverrides reads the overrides of the node nodeName, none when it is
+// empty
+type nodeOverrides struct {
+	kubeconfigPath string
+	nodeName       string
+	last           config.NodeOverrides
+}
+
+// get returns the current overrides of the node. The previous ones are kept
+// when they can't be read, so that an API error or a bad edit doesn't flip
+// the settings of the node.
+func (n *nodeOverrides) get() config.NodeOverrides {
+	if n.nodeName == "" {
+		return config.NodeOverrides{}
+	}
+	o, err := getNodeOverrides(n.kubeconfigPath, n.nodeName)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"node": n.nodeName,
+		}).WithError(err).Warn("Failed to get node overrides, keeping previous ones")
+		return n.last
+	}
+	if !cmp.Equal(o, n.last) {
+		log.WithFields(logrus.Fields{
+			"node":      n.nodeName,
+			"overrides": o,
+		}).Info("Node overrides changed")
+	}
+	n.last = o
+	return o
+}
@@ -0,0 +1,50 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("node_overrides", func() {
+	AfterEach(func() {
+		getNodeOverrides = config.GetNodeOverrides
+	})
+
+	It("keeps_the_previous_overrides_on_error", func() {
+		var err error
+		getNodeOverrides = func(kubeconfigPath, nodeName string) (config.NodeOverrides, error) {
+			return config.NodeOverrides{VRRPPriority: 60}, err
+		}
+		n := &nodeOverrides{nodeName: "master-0"}
+		Expect(n.get().VRRPPriority).To(Equal(60))
+		err = fmt.Errorf("connection refused")
+		Expect(n.get().VRRPPriority).To(Equal(60))
+
+		n.nodeName = ""
+		Expect(n.get()).To(Equal(config.NodeOverrides{}))
+	})
+
+	It("prefers_the_unicast_override_to_the_mode_files", func() {
+		dir, err := os.MkdirTemp("", "keepalived")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		cfgPath := filepath.Join(dir, "keepalived.conf")
+		Expect(os.WriteFile(cfgPath, []byte("vrrp_instance api {\n}\n"), 0644)).To(Succeed())
+
+		unicast := true
+		required, info := isModeUpdateNeeded(cfgPath, &unicast)
+		Expect(required).To(BeTrue())
+		Expect(info.Mode).To(Equal("unicast"))
+
+		unicast = false
+		required, info = isModeUpdateNeeded(cfgPath, &unicast)
+		Expect(required).To(BeFalse())
+		Expect(info.Mode).To(Equal("multicast"))
+	})
+})
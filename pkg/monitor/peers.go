@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/peers"
+)
+
+// peerExchange announces the node to its keepalived peers and reads their
+// announcements
+type peerExchange struct {
+	kubeconfigPath string
+	announcer      *peers.Announcer
+
+	// swapped out by the tests
+	listNodes func() (peers.View, error)
+}
+
+func newPeerExchange(opts Options) *peerExchange {
+	p := &peerExchange{
+		kubeconfigPath: opts.KubeconfigPath,
+		announcer:      peers.NewAnnouncer(opts.KubeconfigPath, opts.NodeName, opts.HealthHeartbeat),
+	}
+	p.listNodes = func() (peers.View, error) {
+		nodes, err := config.SharedNodeCache().List("", opts.KubeconfigPath, "")
+		if err != nil {
+			return peers.View{}, err
+		}
+		// Announcements are refreshed once per heartbeat
+		return peers.NewView(nodes, time.Now(), 3*opts.HealthHeartbeat), nil
+	}
+	return p
+}
+
+// nodeAddresses returns the non-VIP addresses of node and of its nested
+// configs
+func nodeAddresses(node *config.Node) []string {
+	addresses := []string{}
+	seen := map[string]bool{}
+	add := func(address string) {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	add(node.NonVirtualIP)
+	if node.Configs != nil {
+		for _, c := range *node.Configs {
+			add(c.NonVirtualIP)
+		}
+	}
+	return addresses
+}
+
+// update announces node and returns the view of the peers. A view that can
+// not be read is not Announced, which falls back to the node count.
+func (p *peerExchange) update(node *config.Node) peers.View {
+	p.announcer.Announce(node.ShortHostname, nodeAddresses(node), true)
+	view, err := p.listNodes()
+	if err != nil {
+		log.WithError(err).Warn("Failed to read the peer announcements")
+	}
+	return view
+}
+
+// unicastPeersReady returns whether the unicast configuration of node can be
+// applied on the node nodeName. Once the monitors announce themselves, at least one backend must
+// be a peer whose monitor announced it runs keepalived. Until then, which is
+// the case while upgrading from monitors that do not announce, the backends
+// only need to count more than this node.
+func unicastPeersReady(node *config.Node, view peers.View, nodeName string) bool {
+	if view.Announced && nodeName != "" {
+		addresses := []string{}
+		for _, backend := range node.LBConfig.Backends {
+			addresses = append(addresses, backend.Address)
+		}
+		return view.ReadyFor(nodeName, addresses)
+	}
+	return len(node.LBConfig.Backends) >= 2
+}
@@ -0,0 +1,22 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/healthz"
+)
+
+var probes = healthz.NewChecker()
+
+// loopTimeout is how long an iteration of a loop running every interval may
+// take before the monitor is no longer live
+func loopTimeout(interval time.Duration) time.Duration {
+	return max(5*interval, time.Minute)
+}
+
+// serveProbes registers the loop called name and starts the probe
+// endpoints on addr
+func serveProbes(addr, name string, timeout time.Duration) {
+	probes.Register(name, timeout)
+	healthz.Serve(addr, probes)
+}
@@ -0,0 +1,36 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// notifyRefresh returns a channel receiving SIGHUP, which operators send to
+// force a monitor to recompute and render its configuration right away, and
+// a function to stop the delivery
+func notifyRefresh() (<-chan os.Signal, func()) {
+	refresh := make(chan os.Signal, 1)
+	signal.Notify(refresh, syscall.SIGHUP)
+	return refresh, func() {
+		signal.Stop(refresh)
+	}
+}
+
+// sleepOrRefresh waits for d and returns true when a refresh is requested
+// first. It returns false right away when ctx is cancelled.
+func sleepOrRefresh(ctx context.Context, refresh <-chan os.Signal, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-refresh:
+		log.Info("SIGHUP received, forcing a configuration refresh")
+		return true
+	case <-timer.C:
+		return false
+	}
+}
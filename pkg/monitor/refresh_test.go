@@ -0,0 +1,30 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("refresh", func() {
+	It("wakes_up_on_sighup", func() {
+		refresh, stop := notifyRefresh()
+		defer stop()
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+		start := time.Now()
+		Expect(sleepOrRefresh(context.Background(), refresh, time.Hour)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+	})
+
+	It("times_out_and_stops_without_refresh", func() {
+		refresh := make(chan os.Signal)
+		Expect(sleepOrRefresh(context.Background(), refresh, time.Millisecond)).To(BeFalse())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(sleepOrRefresh(ctx, refresh, time.Hour)).To(BeFalse())
+	})
+})
@@ -0,0 +1,178 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+var keepalivedReloadsUnverified = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "baremetal_runtimecfg_keepalived_reloads_unverified_total",
+	Help: "Number of keepalived reloads after which keepalived did not run the rendered configuration",
+})
+
+func init() {
+	prometheus.MustRegister(keepalivedReloadsUnverified)
+}
+
+// swapped out by the tests
+var (
+	signalKeepalived = func(pid int) error {
+		return syscall.Kill(pid, syscall.SIGUSR1)
+	}
+	// reloadVerifyTimeout bounds how long keepalived may take to load a new
+	// configuration after a reload
+	reloadVerifyTimeout = 10 * time.Second
+	reloadVerifyPoll    = time.Second
+	// keepalivedDumpTimeout bounds how long keepalived may take to write its
+	// data dump after SIGUSR1
+	keepalivedDumpTimeout = 5 * time.Second
+	dumpPoll              = 100 * time.Millisecond
+)
+
+// reloadExpectedAddrs returns the VIPs and the unicast peers of node and of
+// its nested configs, which keepalived lists in its data dump once it runs
+// the configuration rendered from node
+func reloadExpectedAddrs(node *config.Node) []net.IP {
+	nodes := []config.Node{*node}
+	if node.Configs != nil {
+		nodes = append(nodes, *node.Configs...)
+	}
+	addrs := []string{}
+	for _, n := range nodes {
+		addrs = append(addrs, n.Cluster.APIVIP, n.Cluster.IngressVIP)
+		if !n.EnableUnicast {
+			continue
+		}
+		for _, backend := range n.LBConfig.Backends {
+			if backend.Address != n.NonVirtualIP {
+				addrs = append(addrs, backend.Address)
+			}
+		}
+		for _, peer := range n.IngressConfig.Peers {
+			if peer != n.NonVirtualIP {
+				addrs = append(addrs, peer)
+			}
+		}
+	}
+	expected := []net.IP{}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil || containsIP(expected, ip) {
+			continue
+		}
+		expected = append(expected, ip)
+	}
+	return expected
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpAddrs returns the IP addresses found in a keepalived data dump. The
+// layout of the dump differs between keepalived versions, so every word is
+// tried rather than parsing its sections.
+func dumpAddrs(dump []byte) []net.IP {
+	addrs := []net.IP{}
+	words := strings.FieldsFunc(string(dump), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '/' || r == ',' || r == '=' || r == '(' || r == ')' || r == '[' || r == ']'
+	})
+	for _, word := range words {
+		if ip := net.ParseIP(word); ip != nil {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// requestKeepalivedDump signals the keepalived of pidFile to write its data
+// dump into dataFile and returns the dump once it has been written again
+func requestKeepalivedDump(ctx context.Context, pidFile, dataFile string) ([]byte, error) {
+	pidData, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidData)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid keepalived pid file %s: %w", pidFile, err)
+	}
+	var before time.Time
+	if fi, err := os.Stat(dataFile); err == nil {
+		before = fi.ModTime()
+	}
+	if err := signalKeepalived(pid); err != nil {
+		return nil, fmt.Errorf("Failed to signal keepalived: %w", err)
+	}
+	deadline := time.Now().Add(keepalivedDumpTimeout)
+	for {
+		if fi, err := os.Stat(dataFile); err == nil && fi.ModTime().After(before) {
+			return ioutil.ReadFile(dataFile)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("keepalived did not write %s", dataFile)
+		}
+		if !sleep(ctx, dumpPoll) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// verifyKeepalivedReload waits for keepalived to run the configuration
+// rendered from node into cfgPath, according to its data dump, and returns
+// an error naming the generation of cfgPath when it does not within
+// reloadVerifyTimeout. The data dump is requested from the keepalived of
+// pidFile and read from dataFile, nothing is verified without pidFile.
+func verifyKeepalivedReload(ctx context.Context, pidFile, dataFile, cfgPath string, node *config.Node) error {
+	if pidFile == "" {
+		return nil
+	}
+	generation, err := render.ReadGenerationID(cfgPath)
+	if err != nil {
+		return err
+	}
+	expected := reloadExpectedAddrs(node)
+	deadline := time.Now().Add(reloadVerifyTimeout)
+	for {
+		dump, err := requestKeepalivedDump(ctx, pidFile, dataFile)
+		if err == nil {
+			missing := []string{}
+			dumped := dumpAddrs(dump)
+			for _, ip := range expected {
+				if !containsIP(dumped, ip) {
+					missing = append(missing, ip.String())
+				}
+			}
+			if len(missing) == 0 {
+				log.WithFields(logrus.Fields{
+					"generation": generation,
+				}).Info("Keepalived runs the rendered configuration")
+				return nil
+			}
+			err = fmt.Errorf("keepalived does not run configuration generation %s, its data dump lacks %s", generation, strings.Join(missing, ", "))
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !time.Now().Add(reloadVerifyPoll).Before(deadline) || !sleep(ctx, reloadVerifyPoll) {
+			return err
+		}
+	}
+}
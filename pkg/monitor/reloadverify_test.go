@@ -0,0 +1,110 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("keepalived_reload_verification", func() {
+	var dir, cfgPath, pidFile, dataFile string
+	var dump string
+	var signals int
+	origSignal := signalKeepalived
+	origTimeout := reloadVerifyTimeout
+	origPoll := reloadVerifyPoll
+	origDumpTimeout := keepalivedDumpTimeout
+
+	node := &config.Node{
+		Cluster:       config.Cluster{APIVIP: "192.168.111.5", IngressVIP: "192.168.111.4"},
+		NonVirtualIP:  "192.168.111.20",
+		EnableUnicast: true,
+		LBConfig:      config.ApiLBConfig{Backends: []config.Backend{{Address: "192.168.111.20"}, {Address: "192.168.111.21"}}},
+		IngressConfig: config.IngressConfig{Peers: []string{"192.168.111.20", "192.168.111.21"}},
+		Configs:       &[]config.Node{{Cluster: config.Cluster{APIVIP: "fd2e:6f44:5dd8::5", IngressVIP: "fd2e:6f44:5dd8::4"}}},
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "reloadverify")
+		Expect(err).ShouldNot(HaveOccurred())
+		cfgPath = filepath.Join(dir, "keepalived.conf")
+		Expect(os.WriteFile(cfgPath, []byte("# runtimecfg-generation: 0123456789ab\n"), 0644)).To(Succeed())
+		pidFile = filepath.Join(dir, "keepalived.pid")
+		Expect(os.WriteFile(pidFile, []byte("42\n"), 0644)).To(Succeed())
+		dataFile = filepath.Join(dir, "keepalived.data")
+		signals = 0
+		signalKeepalived = func(pid int) error {
+			Expect(pid).To(Equal(42))
+			signals++
+			// a later modification time than the previous dump
+			mtime := time.Now().Add(time.Duration(signals) * time.Second)
+			if err := os.WriteFile(dataFile, []byte(dump), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(dataFile, mtime, mtime)
+		}
+		reloadVerifyTimeout = 50 * time.Millisecond
+		reloadVerifyPoll = 10 * time.Millisecond
+		keepalivedDumpTimeout = 50 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		signalKeepalived = origSignal
+		reloadVerifyTimeout = origTimeout
+		reloadVerifyPoll = origPoll
+		keepalivedDumpTimeout = origDumpTimeout
+		os.RemoveAll(dir)
+	})
+
+	It("expects_the_vips_and_the_unicast_peers", func() {
+		expected := []string{}
+		for _, ip := range reloadExpectedAddrs(node) {
+			expected = append(expected, ip.String())
+		}
+		Expect(expected).To(ConsistOf("192.168.111.5", "192.168.111.4", "192.168.111.21", "fd2e:6f44:5dd8::5", "fd2e:6f44:5dd8::4"))
+	})
+
+	It("passes_once_keepalived_dumps_the_new_configuration", func() {
+		dump = ` VRRP Instance = ostest_API
+   Unicast Peer = 192.168.111.21
+   Virtual IP (1):
+     192.168.111.5/32 dev ens3 scope global
+ VRRP Instance = ostest_INGRESS
+   Unicast Peer = 192.168.111.21
+   Virtual IP (1):
+     192.168.111.4/32 dev ens3 scope global
+ VRRP Instance = ostest_API_1
+   Virtual IP (1):
+     fd2e:6f44:5dd8::5/128 dev ens3 scope global
+ VRRP Instance = ostest_INGRESS_1
+   Virtual IP (1):
+     fd2e:6f44:5dd8::4/128 dev ens3 scope global
+`
+		Expect(verifyKeepalivedReload(context.Background(), pidFile, dataFile, cfgPath, node)).To(Succeed())
+		Expect(signals).To(Equal(1))
+	})
+
+	It("fails_with_the_generation_when_keepalived_runs_an_older_configuration", func() {
+		dump = ` VRRP Instance = ostest_API
+   Virtual IP (1):
+     192.168.111.5/32 dev ens3 scope global
+`
+		err := verifyKeepalivedReload(context.Background(), pidFile, dataFile, cfgPath, node)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("0123456789ab"))
+		Expect(err.Error()).To(ContainSubstring("192.168.111.21"))
+		Expect(signals).To(BeNumerically(">", 1))
+	})
+
+	It("verifies_nothing_without_a_pid_file", func() {
+		Expect(verifyKeepalivedReload(context.Background(), "", dataFile, cfgPath, node)).To(Succeed())
+		Expect(signals).To(Equal(0))
+	})
+})
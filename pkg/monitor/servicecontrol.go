@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How the monitors drive keepalived and HAProxy
+const (
+	// ServiceControlSocket writes the commands to the control sockets of
+	// the keepalived and HAProxy containers
+	ServiceControlSocket = "socket"
+	// ServiceControlSystemd runs systemctl for the host systemd unit of the
+	// service, which reaches systemd over D-Bus
+	ServiceControlSystemd = "systemd"
+	// ServiceControlPidFile signals the process whose pid is in a pid file
+	ServiceControlPidFile = "pidfile"
+)
+
+// systemctlTimeout is how long a systemctl command can run
+const systemctlTimeout = 30 * time.Second
+
+// ServiceControlOptions are how a monitor reloads and stops its service
+type ServiceControlOptions struct {
+	// Mechanism is ServiceControlSocket, ServiceControlSystemd or
+	// ServiceControlPidFile
+	Mechanism string
+	// Unit is the systemd unit of the service, with ServiceControlSystemd
+	Unit string
+	// PidFile holds the pid of the service, that of the HAProxy master,
+	// with ServiceControlPidFile
+	PidFile string
+}
+
+// Validate checks that the mechanism has what it needs
+func (o ServiceControlOptions) Validate() error {
+	switch o.Mechanism {
+	case ServiceControlSocket:
+	case ServiceControlSystemd:
+		if o.Unit == "" {
+			return fmt.Errorf("The systemd service control requires a unit")
+		}
+	case ServiceControlPidFile:
+		if o.PidFile == "" {
+			return fmt.Errorf("The pidfile service control requires a pid file")
+		}
+	default:
+		return fmt.Errorf("Invalid service control %s, must be %s, %s or %s", o.Mechanism, ServiceControlSocket, ServiceControlSystemd, ServiceControlPidFile)
+	}
+	return nil
+}
+
+// ReloadService reloads service, keepalived or haproxy, through opts as its
+// monitor does
+func ReloadService(ctx context.Context, service string, opts ServiceControlOptions) error {
+	var sock serviceController
+	var err error
+	switch service {
+	case "keepalived":
+		sock, err = newServiceController("keepalived", keepalivedControlSock, syscall.SIGHUP, opts)
+	case "haproxy":
+		sock, err = newServiceController("haproxy", haproxyMasterSock, syscall.SIGUSR2, opts)
+	default:
+		return fmt.Errorf("Unknown service %s, must be keepalived or haproxy", service)
+	}
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	return sock.Send(ctx, "reload")
+}
+
+// serviceController reloads or stops keepalived or HAProxy
+type serviceController interface {
+	// Send runs command, reload or stop. The socket control also passes
+	// any other command to the service.
+	Send(ctx context.Context, command string) error
+	Close()
+}
+
+// newServiceController returns the controller of the service name, whose
+// container listens on socketPath and which reloads on reloadSignal
+func newServiceController(name, socketPath string, reloadSignal syscall.Signal, opts ServiceControlOptions) (serviceController, error) {
+	switch opts.Mechanism {
+	case ServiceControlSystemd:
+		return &systemdController{
+			unit: opts.Unit,
+			run: func(ctx context.Context, args ...string) ([]byte, error) {
+				return exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+			},
+		}, nil
+	case ServiceControlPidFile:
+		return &pidFileController{
+			path:         opts.PidFile,
+			reloadSignal: reloadSignal,
+			kill:         syscall.Kill,
+		}, nil
+	}
+	sock := newControlSocket(name, socketPath)
+	if err := sock.connect(); err != nil {
+		return nil, err
+	}
+	return sock, nil
+}
+
+// systemdController drives a host systemd unit
+type systemdController struct {
+	unit string
+	// swapped out by the tests
+	run func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+func (c *systemdController) Send(ctx context.Context, command string) error {
+	command = strings.TrimSpace(command)
+	if command != "reload" && command != "stop" {
+		return fmt.Errorf("Command %s is not supported with the systemd service control", command)
+	}
+	ctx, cancel := context.WithTimeout(ctx, systemctlTimeout)
+	defer cancel()
+	if out, err := c.run(ctx, command, c.unit); err != nil {
+		return fmt.Errorf("systemctl %s %s: %w: %s", command, c.unit, err, strings.TrimSpace(string(out)))
+	}
+	log.WithFields(logrus.Fields{
+		"unit":    c.unit,
+		"command": command,
+	}).Debug("Ran systemctl")
+	return nil
+}
+
+func (c *systemdController) Close() {}
+
+// pidFileController signals the process of a pid file. The file is read on
+// every command, so that a restarted service is found.
+type pidFileController struct {
+	path         string
+	reloadSignal syscall.Signal
+	// swapped out by the tests
+	kill func(pid int, sig syscall.Signal) error
+}
+
+func (c *pidFileController) Send(ctx context.Context, command string) error {
+	var sig syscall.Signal
+	switch strings.TrimSpace(command) {
+	case "reload":
+		sig = c.reloadSignal
+	case "stop":
+		sig = syscall.SIGTERM
+	default:
+		return fmt.Errorf("Command %s is not supported with the pidfile service control", command)
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid < 1 {
+		return fmt.Errorf("Invalid pid file %s", c.path)
+	}
+	if err := c.kill(pid, sig); err != nil {
+		return fmt.Errorf("Failed to send %v to %d from %s: %w", sig, pid, c.path, err)
+	}
+	return nil
+}
+
+func (c *pidFileController) Close() {}
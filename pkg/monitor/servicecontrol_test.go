@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("service_control", func() {
+	It("validates_the_mechanism", func() {
+		Expect(ServiceControlOptions{Mechanism: ServiceControlSocket}.Validate()).To(Succeed())
+		Expect(ServiceControlOptions{Mechanism: ServiceControlSystemd, Unit: "keepalived.service"}.Validate()).To(Succeed())
+		Expect(ServiceControlOptions{Mechanism: ServiceControlSystemd}.Validate()).NotTo(Succeed())
+		Expect(ServiceControlOptions{Mechanism: ServiceControlPidFile}.Validate()).NotTo(Succeed())
+		Expect(ServiceControlOptions{Mechanism: "dbus"}.Validate()).NotTo(Succeed())
+	})
+
+	It("runs_systemctl_for_the_unit", func() {
+		ran := [][]string{}
+		c := &systemdController{unit: "haproxy.service"}
+		c.run = func(ctx context.Context, args ...string) ([]byte, error) {
+			ran = append(ran, args)
+			return nil, nil
+		}
+		Expect(c.Send(context.Background(), "reload\n")).To(Succeed())
+		Expect(c.Send(context.Background(), "stop")).To(Succeed())
+		Expect(c.Send(context.Background(), "@1 set server masters/master-0 state maint")).NotTo(Succeed())
+		Expect(ran).To(Equal([][]string{{"reload", "haproxy.service"}, {"stop", "haproxy.service"}}))
+
+		c.run = func(ctx context.Context, args ...string) ([]byte, error) {
+			return []byte("Unit haproxy.service not found.\n"), errors.New("exit status 5")
+		}
+		Expect(c.Send(context.Background(), "reload")).To(MatchError(ContainSubstring("Unit haproxy.service not found.")))
+	})
+
+	It("signals_the_process_of_the_pid_file", func() {
+		dir, err := ioutil.TempDir("", "servicecontrol")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		type signaled struct {
+			pid int
+			sig syscall.Signal
+		}
+		sent := []signaled{}
+		c := &pidFileController{
+			path:         filepath.Join(dir, "keepalived.pid"),
+			reloadSignal: syscall.SIGHUP,
+			kill: func(pid int, sig syscall.Signal) error {
+				sent = append(sent, signaled{pid, sig})
+				return nil
+			},
+		}
+		Expect(c.Send(context.Background(), "reload")).NotTo(Succeed())
+
+		Expect(ioutil.WriteFile(c.path, []byte("1234\n"), 0644)).To(Succeed())
+		Expect(c.Send(context.Background(), "reload")).To(Succeed())
+		// A restarted service writes its new pid
+		Expect(ioutil.WriteFile(c.path, []byte("5678\n"), 0644)).To(Succeed())
+		Expect(c.Send(context.Background(), "stop")).To(Succeed())
+		Expect(sent).To(Equal([]signaled{{1234, syscall.SIGHUP}, {5678, syscall.SIGTERM}}))
+
+		Expect(ioutil.WriteFile(c.path, []byte("keepalived\n"), 0644)).To(Succeed())
+		Expect(c.Send(context.Background(), "reload")).To(MatchError(ContainSubstring("Invalid pid file")))
+	})
+})
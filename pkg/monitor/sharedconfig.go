@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+// SharedConfigOptions configure how the monitors share the node config
+type SharedConfigOptions struct {
+	// File is the node-local file, e.g. on a hostPath shared by the monitor
+	// pods, through which the monitors share the node config so that they
+	// render from the same computation. Disabled when empty.
+	File string
+	// Publish makes the monitor the one that computes the node config and
+	// publishes it in File. The other monitors read it.
+	Publish bool
+	// MaxAge is how old a shared config can be before the monitors stop
+	// trusting it and compute the node config themselves, e.g. while the
+	// publishing monitor is down
+	MaxAge time.Duration
+}
+
+// sharedConfig is the content of SharedConfigOptions.File
+type sharedConfig struct {
+	// Generation identifies the node config, it only changes with it
+	Generation string `json:"generation"`
+	// Key are the GetConfig arguments the node config was computed with,
+	// a monitor passing other arguments computes its own
+	Key       string      `json:"key"`
+	Published time.Time   `json:"published"`
+	Node      config.Node `json:"node"`
+}
+
+// computeConfig is swapped out by the tests
+var computeConfig = config.GetConfig
+
+// sharedConfigGeneration is the generation of the shared config last used
+// by the monitor, to log when it changes
+var (
+	sharedConfigGeneration     string
+	sharedConfigGenerationLock sync.Mutex
+)
+
+func sharedConfigKey(cfgOpts config.Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig config.ClusterLBConfig) string {
+	return fmt.Sprintf("%v|%s|%s|%s|%v|%v|%d|%d|%d|%v", cfgOpts, kubeconfigPath, clusterConfigPath, resolvConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+}
+
+// getConfig is config.GetConfig with opts.Config through the shared config of
+// opts. The publishing monitor computes the node config and publishes it,
+// the others use the published one when it is fresh and was computed with
+// the same arguments.
+func getConfig(opts Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig config.ClusterLBConfig) (config.Node, error) {
+	shared := opts.SharedConfig
+	key := sharedConfigKey(opts.Config, kubeconfigPath, clusterConfigPath, resolvConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+	if shared.File != "" && !shared.Publish {
+		published, err := readSharedConfig(shared.File)
+		switch {
+		case err != nil:
+			log.WithFields(logrus.Fields{
+				"filename": shared.File,
+			}).WithError(err).Debug("Failed to read the shared config, computing it")
+		case published.Key != key:
+			log.WithFields(logrus.Fields{
+				"filename": shared.File,
+			}).Debug("The shared config was computed for other arguments, computing it")
+		case time.Since(published.Published) > shared.MaxAge:
+			log.WithFields(logrus.Fields{
+				"filename":  shared.File,
+				"published": published.Published,
+			}).Warn("The shared config is stale, computing it")
+		default:
+			useSharedConfigGeneration(published.Generation)
+			return published.Node, nil
+		}
+	}
+
+	node, err := computeConfig(opts.Config, kubeconfigPath, clusterConfigPath, resolvConfPath, apiVips, ingressVips, apiPort, lbPort, statPort, clusterLBConfig)
+	if err != nil || shared.File == "" || !shared.Publish {
+		return node, err
+	}
+	generation, err := publishSharedConfig(shared.File, key, node)
+	if err != nil {
+		// The monitor itself has what it needs, the others compute their
+		// own once the shared config is stale
+		log.WithFields(logrus.Fields{
+			"filename": shared.File,
+		}).WithError(err).Warn("Failed to publish the shared config")
+		return node, nil
+	}
+	useSharedConfigGeneration(generation)
+	return node, nil
+}
+
+func useSharedConfigGeneration(generation string) {
+	sharedConfigGenerationLock.Lock()
+	defer sharedConfigGenerationLock.Unlock()
+	if generation != sharedConfigGeneration {
+		log.WithFields(logrus.Fields{
+			"generation": generation,
+			"previous":   sharedConfigGeneration,
+		}).Info("Node config generation changed")
+		sharedConfigGeneration = generation
+	}
+}
+
+// readSharedConfig reads the shared config at path and restores the nested
+// configs of its node as config.GetConfig returns them
+func readSharedConfig(path string) (sharedConfig, error) {
+	shared := sharedConfig{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return shared, err
+	}
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return shared, err
+	}
+	if shared.Node.Configs != nil && len(*shared.Node.Configs) > 0 {
+		nodes := *shared.Node.Configs
+		nodes[0].Configs = &nodes
+		shared.Node = nodes[0]
+	}
+	return shared, nil
+}
+
+// publishSharedConfig replaces the shared config at path with node and
+// returns its generation
+func publishSharedConfig(path, key string, node config.Node) (string, error) {
+	// The nested configs reference the node, they are only kept one level
+	// deep as in the display of the config
+	display := node.ForDisplay(false)
+	content, err := json.Marshal(display)
+	if err != nil {
+		return "", err
+	}
+	shared := sharedConfig{
+		Generation: render.GenerationID(content),
+		Key:        key,
+		Published:  time.Now(),
+		Node:       display,
+	}
+	data, err := json.Marshal(shared)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return shared.Generation, err
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("shared_config", func() {
+	var dir string
+	var computed int
+	var shared SharedConfigOptions
+	apiVips := []net.IP{net.ParseIP("192.168.111.5")}
+	ingressVips := []net.IP{net.ParseIP("192.168.111.4")}
+
+	newNode := func(apiVip string) config.Node {
+		nodes := []config.Node{
+			{Cluster: config.Cluster{Name: "ostest", APIVIP: apiVip}, ShortHostname: "master-0", DNSUpstreams: []string{"192.168.111.1"}},
+			{Cluster: config.Cluster{Name: "ostest", APIVIP: "fd2e:6f44:5dd8::5"}, ShortHostname: "master-0", DNSUpstreams: []string{}},
+		}
+		nodes[0].Configs = &nodes
+		return nodes[0]
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "shared")
+		Expect(err).ShouldNot(HaveOccurred())
+		shared = SharedConfigOptions{File: filepath.Join(dir, "node-config.json"), MaxAge: 30 * time.Second}
+		computed = 0
+		computeConfig = func(cfgOpts config.Options, kubeconfigPath, clusterConfigPath, resolvConfPath string, apiVips, ingressVips []net.IP, apiPort, lbPort, statPort uint16, clusterLBConfig config.ClusterLBConfig) (config.Node, error) {
+			computed++
+			return newNode(apiVips[0].String()), nil
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		computeConfig = config.GetConfig
+	})
+
+	get := func(apiVips []net.IP) config.Node {
+		node, err := getConfig(Options{SharedConfig: shared}, "kubeconfig", "", "/etc/resolv.conf", apiVips, ingressVips, 0, 0, 0, config.ClusterLBConfig{})
+		Expect(err).ShouldNot(HaveOccurred())
+		return node
+	}
+
+	It("serves_the_published_config_to_the_other_monitors", func() {
+		shared.Publish = true
+		published := get(apiVips)
+		Expect(computed).To(Equal(1))
+
+		shared.Publish = false
+		node := get(apiVips)
+		Expect(computed).To(Equal(1))
+		Expect(cmp.Equal(node, published)).To(BeTrue(), cmp.Diff(node, published))
+		Expect((*node.Configs)[0].Configs).To(Equal(node.Configs))
+	})
+
+	It("keeps_the_generation_while_the_config_is_unchanged", func() {
+		gen1, err := publishSharedConfig(shared.File, "key", newNode("192.168.111.5"))
+		Expect(err).ShouldNot(HaveOccurred())
+		gen2, err := publishSharedConfig(shared.File, "key", newNode("192.168.111.5"))
+		Expect(err).ShouldNot(HaveOccurred())
+		gen3, err := publishSharedConfig(shared.File, "key", newNode("192.168.111.6"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(gen2).To(Equal(gen1))
+		Expect(gen3).NotTo(Equal(gen1))
+	})
+
+	It("computes_the_config_for_other_arguments", func() {
+		shared.Publish = true
+		get(apiVips)
+		shared.Publish = false
+		node := get([]net.IP{net.ParseIP("192.168.111.6")})
+		Expect(computed).To(Equal(2))
+		Expect(node.Cluster.APIVIP).To(Equal("192.168.111.6"))
+	})
+
+	It("computes_the_config_when_the_shared_one_is_stale", func() {
+		shared.Publish = true
+		get(apiVips)
+		shared.Publish = false
+		shared.MaxAge = 0
+		get(apiVips)
+		Expect(computed).To(Equal(2))
+	})
+
+	It("computes_the_config_without_a_shared_one", func() {
+		get(apiVips)
+		Expect(computed).To(Equal(1))
+		_, err := os.Stat(shared.File)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
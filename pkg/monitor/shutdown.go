@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notifyContext returns a context cancelled by SIGTERM or SIGINT
+func notifyContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+}
+
+// sleep waits for d and returns false when ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// workerGroup runs the goroutines of a monitor loop so that the loop can
+// wait for them to return after its context is cancelled
+type workerGroup struct {
+	wg sync.WaitGroup
+}
+
+// Go runs f in a goroutine of the group
+func (g *workerGroup) Go(name string, f func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+		log.WithFields(logrus.Fields{
+			"worker": name,
+		}).Debug("Monitor worker stopped")
+	}()
+}
+
+// Wait waits for the goroutines of the group for at most timeout and
+// returns false if some are still running
+func (g *workerGroup) Wait(timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		log.WithFields(logrus.Fields{
+			"timeout": timeout,
+		}).Warn("Monitor workers did not stop in time, cleaning up anyway")
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("shutdown", func() {
+	It("sleep_returns_early_on_cancel", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		Expect(sleep(ctx, time.Millisecond)).Should(BeTrue())
+		cancel()
+		start := time.Now()
+		Expect(sleep(ctx, time.Hour)).Should(BeFalse())
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("waits_for_the_workers", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var workers workerGroup
+		workers.Go("test", func() {
+			<-ctx.Done()
+		})
+		Expect(workers.Wait(10 * time.Millisecond)).Should(BeFalse())
+		cancel()
+		Expect(workers.Wait(time.Second)).Should(BeTrue())
+	})
+
+	It("does_not_block_a_send_after_cancel", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := make(chan APIState, 1)
+		Expect(sendAPIState(ctx, ch, stopped)).Should(BeTrue())
+		cancel()
+		Expect(sendAPIState(ctx, ch, started)).Should(BeFalse())
+		Expect(sendModeUpdate(ctx, make(chan modeUpdateInfo), modeUpdateInfo{})).Should(BeFalse())
+	})
+
+	It("stops_the_bootstrap_worker", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var workers workerGroup
+		workers.Go("bootstrap-stop-keepalived", func() {
+			handleBootstrapStopKeepalived(ctx, config.DefaultOptions(), "/nonexistent/kubeconfig", make(chan APIState))
+		})
+		Expect(workers.Wait(5 * time.Second)).Should(BeTrue())
+	})
+})
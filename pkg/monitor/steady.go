@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SteadyOptions extend the check interval of the coredns and dnsmasq
+// monitors while nothing changes. The keepalived and haproxy monitors keep
+// their interval, their iterations also check the health of the API.
+type SteadyOptions struct {
+	// Iterations is how many iterations in a row must find nothing to
+	// change before the check interval is extended. Disabled when zero.
+	Iterations int
+	// MaxInterval is the longest check interval of a steady monitor
+	MaxInterval time.Duration
+}
+
+// loopTimeout is loopTimeout for a loop whose interval can be extended
+func (o SteadyOptions) loopTimeout(interval time.Duration) time.Duration {
+	if o.Iterations > 0 {
+		interval = max(interval, o.MaxInterval)
+	}
+	return loopTimeout(interval)
+}
+
+// steadyInterval doubles the check interval of a monitor loop every
+// opts.Iterations unchanged iterations, up to opts.MaxInterval, and goes
+// back to the base interval on any change
+type steadyInterval struct {
+	name      string
+	base      time.Duration
+	opts      SteadyOptions
+	current   time.Duration
+	unchanged int
+}
+
+func newSteadyInterval(name string, base time.Duration, opts SteadyOptions) *steadyInterval {
+	return &steadyInterval{name: name, base: base, opts: opts, current: base}
+}
+
+// next returns the interval to wait after an iteration that found a change
+// to apply, or was forced by a signal, when changed is set
+func (s *steadyInterval) next(changed bool) time.Duration {
+	if changed || s.opts.Iterations <= 0 {
+		if s.current != s.base {
+			log.WithFields(logrus.Fields{
+				"monitor":  s.name,
+				"interval": s.base,
+			}).Info("Change detected, back to the regular check interval")
+		}
+		s.unchanged = 0
+		s.current = s.base
+		return s.current
+	}
+	s.unchanged++
+	if s.unchanged%s.opts.Iterations == 0 && s.current < s.opts.MaxInterval {
+		s.current = min(2*s.current, s.opts.MaxInterval)
+		log.WithFields(logrus.Fields{
+			"monitor":    s.name,
+			"interval":   s.current,
+			"iterations": s.unchanged,
+		}).Info("Nothing changed for a while, extending the check interval")
+	}
+	return s.current
+}
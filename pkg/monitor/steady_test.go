@@ -0,0 +1,41 @@
+package monitor
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("steady_interval", func() {
+	It("keeps_the_interval_when_disabled", func() {
+		s := newSteadyInterval("test", 10*time.Second, DefaultOptions().Steady)
+		for i := 0; i < 100; i++ {
+			Expect(s.next(false)).To(Equal(10 * time.Second))
+		}
+	})
+
+	It("extends_the_interval_while_nothing_changes", func() {
+		s := newSteadyInterval("test", 10*time.Second, SteadyOptions{Iterations: 3, MaxInterval: time.Minute})
+		intervals := []time.Duration{}
+		for i := 0; i < 12; i++ {
+			intervals = append(intervals, s.next(false))
+		}
+		Expect(intervals).To(Equal([]time.Duration{
+			10 * time.Second, 10 * time.Second, 20 * time.Second,
+			20 * time.Second, 20 * time.Second, 40 * time.Second,
+			40 * time.Second, 40 * time.Second, time.Minute,
+			time.Minute, time.Minute, time.Minute,
+		}))
+
+		Expect(s.next(true)).To(Equal(10 * time.Second))
+		Expect(s.next(false)).To(Equal(10 * time.Second))
+	})
+
+	It("lets_the_liveness_probe_wait_for_the_longest_interval", func() {
+		opts := DefaultOptions().Steady
+		Expect(opts.loopTimeout(10 * time.Second)).To(Equal(loopTimeout(10 * time.Second)))
+		opts.Iterations = 3
+		Expect(opts.loopTimeout(10 * time.Second)).To(Equal(loopTimeout(5 * time.Minute)))
+	})
+})
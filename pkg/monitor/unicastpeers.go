@@ -0,0 +1,56 @@
+package monitor
+
+import (
+	"net"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// unicastPeerAllowed returns whether address is in one of cidrs, every
+// address is when there is none
+func unicastPeerAllowed(address string, cidrs []net.IPNet) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(address)
+	for _, cidr := range cidrs {
+		if ip != nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"address": address,
+	}).Warn("Ignoring unicast peer outside of the allowed CIDRs")
+	return false
+}
+
+func filterUnicastPeersOne(node *config.Node, cidrs []net.IPNet) {
+	peers := []string{}
+	for _, peer := range node.IngressConfig.Peers {
+		if unicastPeerAllowed(peer, cidrs) {
+			peers = append(peers, peer)
+		}
+	}
+	node.IngressConfig.Peers = peers
+	backends := []config.Backend{}
+	for _, backend := range node.LBConfig.Backends {
+		if unicastPeerAllowed(backend.Address, cidrs) {
+			backends = append(backends, backend)
+		}
+	}
+	node.LBConfig.Backends = backends
+}
+
+// filterUnicastPeers drops the unicast peers of node and of its nested
+// configs that are outside of cidrs
+func filterUnicastPeers(node *config.Node, cidrs []net.IPNet) {
+	filterUnicastPeersOne(node, cidrs)
+	if node.Configs == nil {
+		return
+	}
+	for i := range *node.Configs {
+		filterUnicastPeersOne(&(*node.Configs)[i], cidrs)
+	}
+}
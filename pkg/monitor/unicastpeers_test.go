@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/peers"
+)
+
+var _ = Describe("unicast_peers", func() {
+	It("drops_peers_outside_of_the_allowed_cidrs", func() {
+		_, machineNetwork, _ := net.ParseCIDR("192.168.111.0/24")
+		nested := []config.Node{{IngressConfig: config.IngressConfig{Peers: []string{"192.168.111.21", "10.0.0.5"}}}}
+		node := config.Node{
+			IngressConfig: config.IngressConfig{Peers: []string{"192.168.111.20", "10.0.0.5"}},
+			LBConfig:      config.ApiLBConfig{Backends: []config.Backend{{Host: "master-0", Address: "192.168.111.20"}, {Host: "rogue", Address: "10.0.0.5"}}},
+			Configs:       &nested,
+		}
+		filterUnicastPeers(&node, []net.IPNet{*machineNetwork})
+		Expect(node.IngressConfig.Peers).Should(Equal([]string{"192.168.111.20"}))
+		Expect(node.LBConfig.Backends).Should(Equal([]config.Backend{{Host: "master-0", Address: "192.168.111.20"}}))
+		Expect((*node.Configs)[0].IngressConfig.Peers).Should(Equal([]string{"192.168.111.21"}))
+	})
+
+	It("allows_every_peer_by_default", func() {
+		node := config.Node{IngressConfig: config.IngressConfig{Peers: []string{"10.0.0.5"}}}
+		filterUnicastPeers(&node, nil)
+		Expect(node.IngressConfig.Peers).Should(Equal([]string{"10.0.0.5"}))
+	})
+})
+
+var _ = Describe("unicast_peers_ready", func() {
+	backends := config.ApiLBConfig{Backends: []config.Backend{{Address: "192.168.111.20"}, {Address: "192.168.111.21"}}}
+
+	It("falls_back_to_the_backend_count", func() {
+		node := config.Node{LBConfig: backends}
+		Expect(unicastPeersReady(&node, peers.View{}, "")).Should(BeTrue())
+		node.LBConfig.Backends = node.LBConfig.Backends[:1]
+		Expect(unicastPeersReady(&node, peers.View{}, "")).Should(BeFalse())
+	})
+
+	It("waits_for_an_announced_peer", func() {
+		node := config.Node{LBConfig: backends}
+		view := peers.View{Announced: true, Peers: map[string]peers.Announcement{
+			"master-0": {Addresses: []string{"192.168.111.20"}, VIPCandidate: true},
+		}}
+		Expect(unicastPeersReady(&node, view, "master-0")).Should(BeFalse())
+		view.Peers["master-1"] = peers.Announcement{Addresses: []string{"192.168.111.21"}, VIPCandidate: true}
+		Expect(unicastPeersReady(&node, view, "master-0")).Should(BeTrue())
+	})
+})
@@ -0,0 +1,186 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// probeAddress is swapped out by the tests
+var probeAddress = utils.ProbeAddress
+
+// keepalivedRunning reports whether the process of pidFile runs, false when
+// the pid file is unknown. Swapped out by the tests.
+var keepalivedRunning = func(pidFile string) bool {
+	if pidFile == "" {
+		return false
+	}
+	_, err := processStartTime(pidFile)
+	return err == nil
+}
+
+// nodeVIPs returns the VIPs of node and of its nested configs
+func nodeVIPs(node *config.Node) []string {
+	vips := []string{}
+	for _, n := range append([]config.Node{*node}, nestedConfigs(node)...) {
+		for _, v := range []string{n.Cluster.APIVIP, n.Cluster.IngressVIP} {
+			if v != "" {
+				vips = append(vips, v)
+			}
+		}
+	}
+	return vips
+}
+
+// newVIPs returns the VIPs of cur that applied does not have
+func newVIPs(cur, applied *config.Node) []string {
+	known := map[string]bool{}
+	if applied != nil {
+		for _, v := range nodeVIPs(applied) {
+			known[v] = true
+		}
+	}
+	added := []string{}
+	for _, v := range nodeVIPs(cur) {
+		if !known[v] {
+			added = append(added, v)
+			known[v] = true
+		}
+	}
+	return added
+}
+
+// clusterPeerAddresses returns the addresses of the other nodes of the
+// cluster, which may legitimately hold a VIP already. The node addresses are
+// known in every mode, the backends and ingress peers only in unicast mode.
+func clusterPeerAddresses(node *config.Node) []string {
+	seen := map[string]bool{}
+	peers := []string{}
+	add := func(addr string) {
+		if addr != "" && addr != node.NonVirtualIP && !seen[addr] {
+			seen[addr] = true
+			peers = append(peers, addr)
+		}
+	}
+	for _, n := range append([]config.Node{*node}, nestedConfigs(node)...) {
+		for _, a := range n.Cluster.NodeAddresses {
+			add(a.Address)
+		}
+		for _, b := range n.LBConfig.Backends {
+			add(b.Address)
+		}
+		for _, p := range n.IngressConfig.Peers {
+			add(p)
+		}
+	}
+	return peers
+}
+
+func nestedConfigs(node *config.Node) []config.Node {
+	if node.Configs == nil {
+		return nil
+	}
+	return *node.Configs
+}
+
+// knownHardwareAddrs returns the MAC addresses of the local interfaces and of
+// the cluster peers that answer on iface within timeout.
+func knownHardwareAddrs(iface *net.Interface, peers []string, timeout time.Duration) map[string]bool {
+	known := map[string]bool{}
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, i := range ifaces {
+			if len(i.HardwareAddr) > 0 {
+				known[i.HardwareAddr.String()] = true
+			}
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		ip := net.ParseIP(p)
+		if ip == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			macs, err := probeAddress(iface, ip, timeout)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, mac := range macs {
+				known[mac.String()] = true
+			}
+		}(ip)
+	}
+	wg.Wait()
+	return known
+}
+
+// checkNewVIPsNotInUse probes the VIPs that cur introduces compared to
+// applied and returns an error if any of them is answered for by a host
+// outside of the cluster within timeout. Nothing is probed when timeout is
+// zero.
+func checkNewVIPsNotInUse(cur, applied *config.Node, timeout time.Duration) error {
+	if timeout == 0 {
+		return nil
+	}
+	vips := newVIPs(cur, applied)
+	if len(vips) == 0 {
+		return nil
+	}
+
+	// Each VIP is probed on its own interface, the one keepalived will
+	// advertise it on
+	byIface := map[string][]string{}
+	ifaceNames := []string{}
+	for _, v := range vips {
+		name := cur.VIPInterface(v)
+		if _, ok := byIface[name]; !ok {
+			ifaceNames = append(ifaceNames, name)
+		}
+		byIface[name] = append(byIface[name], v)
+	}
+
+	peers := clusterPeerAddresses(cur)
+	conflicts := []string{}
+	for _, name := range ifaceNames {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("Failed to find VRRP interface %s: %w", name, err)
+		}
+		known := knownHardwareAddrs(iface, peers, timeout)
+		for _, v := range byIface[name] {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				continue
+			}
+			macs, err := probeAddress(iface, ip, timeout)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"vip":       v,
+					"interface": iface.Name,
+				}).WithError(err).Warn("Failed to probe VIP for duplicate addresses")
+				continue
+			}
+			for _, mac := range macs {
+				if !known[mac.String()] {
+					conflicts = append(conflicts, fmt.Sprintf("%s is in use by %s", v, mac))
+				}
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Duplicate VIP addresses detected: %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
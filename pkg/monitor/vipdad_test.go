@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+var _ = Describe("vip_dad", func() {
+	peerMAC, _ := net.ParseMAC("52:54:00:00:00:02")
+	foreignMAC, _ := net.ParseMAC("52:54:00:00:00:99")
+	owners := map[string][]net.HardwareAddr{}
+
+	node := func(apiVIP, ingressVIP string) *config.Node {
+		return &config.Node{
+			Cluster:       config.Cluster{APIVIP: apiVIP, IngressVIP: ingressVIP},
+			VRRPInterface: "lo",
+			NonVirtualIP:  "192.168.111.20",
+			LBConfig: config.ApiLBConfig{Backends: []config.Backend{
+				{Host: "master-0", Address: "192.168.111.20"},
+				{Host: "master-1", Address: "192.168.111.21"},
+			}},
+			Configs: &[]config.Node{},
+		}
+	}
+
+	BeforeEach(func() {
+		probeAddress = func(iface *net.Interface, ip net.IP, timeout time.Duration) ([]net.HardwareAddr, error) {
+			return owners[ip.String()], nil
+		}
+		owners = map[string][]net.HardwareAddr{"192.168.111.21": {peerMAC}}
+	})
+
+	AfterEach(func() {
+		probeAddress = utils.ProbeAddress
+	})
+
+	It("new_vips", func() {
+		applied := node("192.168.111.5", "192.168.111.4")
+		cur := node("192.168.111.5", "192.168.111.6")
+		*cur.Configs = []config.Node{*node("fd00::5", "fd00::4")}
+		Expect(newVIPs(cur, applied)).Should(Equal([]string{"192.168.111.6", "fd00::5", "fd00::4"}))
+		Expect(newVIPs(cur, nil)).Should(HaveLen(4))
+	})
+
+	It("allows_vips_held_by_peers", func() {
+		owners["192.168.111.5"] = []net.HardwareAddr{peerMAC}
+		Expect(checkNewVIPsNotInUse(node("192.168.111.5", "192.168.111.4"), nil, time.Millisecond)).Should(Succeed())
+	})
+
+	It("allows_vips_held_by_nodes_in_multicast_mode", func() {
+		// Without unicast there are no backends, only the node addresses
+		cur := node("192.168.111.5", "192.168.111.4")
+		cur.LBConfig = config.ApiLBConfig{}
+		owners["192.168.111.5"] = []net.HardwareAddr{peerMAC}
+		Expect(checkNewVIPsNotInUse(cur, nil, time.Millisecond)).ShouldNot(Succeed())
+
+		cur.Cluster.NodeAddresses = []config.NodeAddress{
+			{Address: "192.168.111.20", Name: "master-0"},
+			{Address: "192.168.111.21", Name: "master-1"},
+		}
+		Expect(clusterPeerAddresses(cur)).Should(Equal([]string{"192.168.111.21"}))
+		Expect(checkNewVIPsNotInUse(cur, nil, time.Millisecond)).Should(Succeed())
+	})
+
+	It("rejects_vips_held_by_foreign_hosts", func() {
+		owners["192.168.111.4"] = []net.HardwareAddr{foreignMAC}
+		err := checkNewVIPsNotInUse(node("192.168.111.5", "192.168.111.4"), nil, time.Millisecond)
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).Should(ContainSubstring("192.168.111.4 is in use by " + foreignMAC.String()))
+
+		By("only_probing_new_vips", func() {
+			applied := node("192.168.111.5", "192.168.111.4")
+			Expect(checkNewVIPsNotInUse(node("192.168.111.5", "192.168.111.4"), applied, time.Millisecond)).Should(Succeed())
+		})
+	})
+
+	It("disabled_by_default", func() {
+		owners["192.168.111.4"] = []net.HardwareAddr{foreignMAC}
+		Expect(checkNewVIPsNotInUse(node("192.168.111.5", "192.168.111.4"), nil, 0)).Should(Succeed())
+	})
+})
@@ -0,0 +1,203 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+// The states of a VIP on the node, as those of keepalived
+const (
+	// vipStateMaster is a VIP on an interface of the node
+	vipStateMaster = "master"
+	// vipStateBackup is a VIP another node holds
+	vipStateBackup = "backup"
+	// vipStateFault is a VIP whose interface is missing or down
+	vipStateFault = "fault"
+)
+
+// vipHookTimeout is how long a hook can run
+const vipHookTimeout = 30 * time.Second
+
+// vipState is the state of a VIP on the node
+type vipState struct {
+	State     string
+	Interface string
+}
+
+// vipHookVIP is a VIP the hooks are run for
+type vipHookVIP struct {
+	IP net.IP
+	// Kind is api or ingress
+	Kind string
+}
+
+// currentVIPStates returns the state of each of vips
+func currentVIPStates(cfgOpts config.Options, vips []net.IP) (map[string]vipState, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	// linkOf is the name of the link of each address of the node
+	linkOf := map[string]string{}
+	for _, link := range links {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			linkOf[addr.IP.String()] = link.Attrs().Name
+		}
+	}
+	states := map[string]vipState{}
+	for _, vip := range vips {
+		state := vipState{State: vipStateFault}
+		if name, ok := linkOf[vip.String()]; ok {
+			state = vipState{State: vipStateMaster, Interface: name}
+		} else if vipIfaces, err := config.GetVRRPInterfaces(cfgOpts, []net.IP{vip}); err == nil && len(vipIfaces) == 1 {
+			state.Interface = vipIfaces[0].Interface.Name
+			if vipIfaces[0].Interface.Flags&net.FlagUp != 0 {
+				state.State = vipStateBackup
+			}
+		}
+		states[vip.String()] = state
+	}
+	return states, nil
+}
+
+// vipHookEnv returns the environment of the hooks of a transition of vip
+func vipHookEnv(vip vipHookVIP, state vipState, previous string) []string {
+	return append(os.Environ(),
+		"RUNTIMECFG_VIP="+vip.IP.String(),
+		"RUNTIMECFG_VIP_KIND="+vip.Kind,
+		"RUNTIMECFG_VIP_INTERFACE="+state.Interface,
+		"RUNTIMECFG_VIP_STATE="+state.State,
+		"RUNTIMECFG_VIP_PREVIOUS_STATE="+previous,
+	)
+}
+
+// stateHooks returns the executables of the directory of state in hooksDir,
+// sorted by name as ioutil.ReadDir returns them
+func stateHooks(hooksDir, state string) ([]string, error) {
+	dir := filepath.Join(hooksDir, state+".d")
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hooks := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Mode().Perm()&0111 == 0 || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		hooks = append(hooks, filepath.Join(dir, entry.Name()))
+	}
+	return hooks, nil
+}
+
+func runVIPHook(path string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), vipHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// vipHooks runs the hooks of the state a VIP enters
+type vipHooks struct {
+	// dir holds the master.d, backup.d and fault.d directories of the hooks
+	dir  string
+	vips []vipHookVIP
+	// states are the states of the VIPs the hooks were run for
+	states map[string]vipState
+
+	// swapped out by the tests
+	vipStates func(vips []net.IP) (map[string]vipState, error)
+	runHook   func(path string, env []string) error
+}
+
+func newVIPHooks(cfgOpts config.Options, dir string, apiVips, ingressVips []net.IP) *vipHooks {
+	vips := []vipHookVIP{}
+	for _, vip := range apiVips {
+		vips = append(vips, vipHookVIP{IP: vip, Kind: "api"})
+	}
+	for _, vip := range ingressVips {
+		vips = append(vips, vipHookVIP{IP: vip, Kind: "ingress"})
+	}
+	return &vipHooks{
+		dir:    dir,
+		vips:   vips,
+		states: map[string]vipState{},
+		vipStates: func(vips []net.IP) (map[string]vipState, error) {
+			return currentVIPStates(cfgOpts, vips)
+		},
+		runHook: runVIPHook,
+	}
+}
+
+// reconcile runs the hooks of the VIPs whose state changed. The first state
+// of a VIP is a transition from an empty previous state, so that the hooks
+// learn the state the node starts in.
+func (h *vipHooks) reconcile() {
+	ips := []net.IP{}
+	for _, vip := range h.vips {
+		ips = append(ips, vip.IP)
+	}
+	states, err := h.vipStates(ips)
+	if err != nil {
+		log.WithError(err).Error("Failed to get the state of the VIPs")
+		return
+	}
+	for _, vip := range h.vips {
+		state, previous := states[vip.IP.String()], h.states[vip.IP.String()]
+		if state.State == previous.State {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"vip":       vip.IP,
+			"interface": state.Interface,
+			"state":     state.State,
+			"previous":  previous.State,
+		}).Info("VIP state changed")
+		// A failed hook is not run again, the state it is about already
+		// happened
+		h.states[vip.IP.String()] = state
+		hooks, err := stateHooks(h.dir, state.State)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"state": state.State,
+			}).WithError(err).Error("Failed to list the VIP hooks")
+			continue
+		}
+		env := vipHookEnv(vip, state, previous.State)
+		for _, hook := range hooks {
+			if err := h.runHook(hook, env); err != nil {
+				log.WithFields(logrus.Fields{
+					"hook": hook,
+					"vip":  vip.IP,
+				}).WithError(err).Error("VIP hook failed")
+			}
+		}
+	}
+}
+
+// run reconciles on every address change of the node until ctx is cancelled
+func (h *vipHooks) run(ctx context.Context, interval time.Duration) {
+	watchAddresses(ctx, interval, h.reconcile)
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+var _ = Describe("vip_hooks", func() {
+	var dir, out string
+	var states map[string]vipState
+	var hooks *vipHooks
+	apiVip := net.ParseIP("192.168.111.5")
+
+	writeHook := func(state, name, script string, mode os.FileMode) {
+		Expect(os.MkdirAll(filepath.Join(dir, state+".d"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, state+".d", name), []byte(script), mode)).To(Succeed())
+	}
+	runs := func() []string {
+		data, err := ioutil.ReadFile(out)
+		if os.IsNotExist(err) {
+			return []string{}
+		}
+		Expect(err).ShouldNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "hooks")
+		Expect(err).ShouldNot(HaveOccurred())
+		out = filepath.Join(dir, "runs")
+		states = map[string]vipState{apiVip.String(): {State: vipStateBackup, Interface: "ens3"}}
+		hooks = newVIPHooks(config.DefaultOptions(), dir, []net.IP{apiVip}, nil)
+		hooks.vipStates = func([]net.IP) (map[string]vipState, error) {
+			return states, nil
+		}
+		record := "#!/bin/sh\necho \"$0 $RUNTIMECFG_VIP $RUNTIMECFG_VIP_KIND $RUNTIMECFG_VIP_INTERFACE $RUNTIMECFG_VIP_PREVIOUS_STATE>$RUNTIMECFG_VIP_STATE\" >> " + out + "\n"
+		writeHook(vipStateMaster, "10-record", record, 0755)
+		writeHook(vipStateMaster, "20-record", record, 0755)
+		writeHook(vipStateMaster, "30-disabled", record, 0644)
+		writeHook(vipStateBackup, "10-record", record, 0755)
+		writeHook(vipStateFault, "10-fail", "#!/bin/sh\nexit 1\n", 0755)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("runs_the_executables_of_the_state_entered", func() {
+		hooks.reconcile()
+		Expect(runs()).To(Equal([]string{dir + "/backup.d/10-record 192.168.111.5 api ens3 >backup"}))
+
+		hooks.reconcile()
+		Expect(runs()).To(HaveLen(1))
+
+		states[apiVip.String()] = vipState{State: vipStateMaster, Interface: "ens3"}
+		hooks.reconcile()
+		Expect(runs()[1:]).To(Equal([]string{
+			dir + "/master.d/10-record 192.168.111.5 api ens3 backup>master",
+			dir + "/master.d/20-record 192.168.111.5 api ens3 backup>master",
+		}))
+
+		// A failed hook does not hold the state back
+		states[apiVip.String()] = vipState{State: vipStateFault}
+		hooks.reconcile()
+		Expect(hooks.states[apiVip.String()].State).To(Equal(vipStateFault))
+		Expect(runs()).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,281 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// VIPLeaseOptions are the durations of the coordination Leases of the VIPs
+// in VIPAdvertisementLease mode, as those of a leader election
+type VIPLeaseOptions struct {
+	// Duration is how long the other nodes wait after the last renewal
+	// before they take a VIP over
+	Duration time.Duration
+	// RenewDeadline is how long the holder retries to renew before it
+	// drops the VIP
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between the attempts to acquire or renew
+	RetryPeriod time.Duration
+}
+
+// Validate checks the durations the way the leader election does
+func (o VIPLeaseOptions) Validate() error {
+	if o.RetryPeriod <= 0 {
+		return fmt.Errorf("The VIP lease retry period must be positive")
+	}
+	if o.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(o.RetryPeriod)) {
+		return fmt.Errorf("The VIP lease renew deadline must be longer than %v times the retry period", leaderelection.JitterFactor)
+	}
+	if o.Duration <= o.RenewDeadline {
+		return fmt.Errorf("The VIP lease duration must be longer than the renew deadline")
+	}
+	return nil
+}
+
+// vipLeaseName returns the name of the Lease of vip in the pod namespace
+func vipLeaseName(vip net.IP) string {
+	return "vip-" + strings.NewReplacer(".", "-", ":", "-").Replace(vip.String())
+}
+
+// vipMask returns the host mask of vip
+func vipMask(vip net.IP) net.IPMask {
+	if vip.To4() != nil {
+		return net.CIDRMask(32, 32)
+	}
+	return net.CIDRMask(128, 128)
+}
+
+// addVIPAddress adds vip to the interface of its network and announces it
+func addVIPAddress(cfgOpts config.Options, vip net.IP) error {
+	vipIfaces, err := config.GetVRRPInterfaces(cfgOpts, []net.IP{vip})
+	if err != nil {
+		return err
+	}
+	if len(vipIfaces) != 1 {
+		return fmt.Errorf("No interface found for VIP %s", vip)
+	}
+	iface := vipIfaces[0].Interface
+	link, err := netlink.LinkByName(iface.Name)
+	if err != nil {
+		return err
+	}
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: vip, Mask: vipMask(vip)}}
+	if err := netlink.AddrAdd(link, addr); err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+	if err := utils.AnnounceAddress(&iface, vip); err != nil {
+		// The hosts on the link learn the new owner on their next
+		// resolution, it only takes longer
+		log.WithFields(logrus.Fields{
+			"vip":       vip,
+			"interface": iface.Name,
+		}).WithError(err).Warn("Failed to announce VIP")
+	}
+	return nil
+}
+
+// delVIPAddress removes vip from the interfaces it is on
+func delVIPAddress(vip net.IP) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if !addr.IP.Equal(vip) {
+				continue
+			}
+			if err := netlink.AddrDel(link, &addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// vipHolder contends for the Lease of a VIP while the service of the VIP is
+// healthy on the node, and has the VIP on the node while it holds the Lease
+type vipHolder struct {
+	vip  net.IP
+	name string
+	// healthy tells whether the service of the VIP runs on the node
+	healthy func() bool
+	// retryPeriod is how often the health of the service is checked
+	retryPeriod time.Duration
+
+	lock sync.Mutex
+	held bool
+
+	// swapped out by the tests
+	elect      func(ctx context.Context, callbacks leaderelection.LeaderCallbacks) error
+	addAddress func(vip net.IP) error
+	delAddress func(vip net.IP) error
+}
+
+func newVIPHolder(cfgOpts config.Options, kubeconfigPath, identity string, vip net.IP, healthy func() bool, opts VIPLeaseOptions) *vipHolder {
+	name := vipLeaseName(vip)
+	return &vipHolder{
+		vip:         vip,
+		name:        name,
+		healthy:     healthy,
+		retryPeriod: opts.RetryPeriod,
+		elect: func(ctx context.Context, callbacks leaderelection.LeaderCallbacks) error {
+			clientset, err := utils.SharedKubeClient("", kubeconfigPath).Clientset()
+			if err != nil {
+				return err
+			}
+			elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+				Lock: &resourcelock.LeaseLock{
+					LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: os.Getenv("POD_NAMESPACE")},
+					Client:     clientset.CoordinationV1(),
+					LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+				},
+				LeaseDuration:   opts.Duration,
+				RenewDeadline:   opts.RenewDeadline,
+				RetryPeriod:     opts.RetryPeriod,
+				Callbacks:       callbacks,
+				ReleaseOnCancel: true,
+				Name:            name,
+			})
+			if err != nil {
+				return err
+			}
+			elector.Run(ctx)
+			return nil
+		},
+		addAddress: func(vip net.IP) error {
+			return addVIPAddress(cfgOpts, vip)
+		},
+		delAddress: delVIPAddress,
+	}
+}
+
+// hold adds the VIP once the Lease is acquired. ctx is cancelled before
+// release is called, so a late hold does not add the VIP back.
+func (h *vipHolder) hold(ctx context.Context) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if err := h.addAddress(h.vip); err != nil {
+		// The lease is kept, the next holder would not be able to add
+		// it either
+		log.WithFields(logrus.Fields{
+			"vip":   h.vip,
+			"lease": h.name,
+		}).WithError(err).Error("Failed to add the VIP of the held lease")
+		return
+	}
+	h.held = true
+	log.WithFields(logrus.Fields{
+		"vip":   h.vip,
+		"lease": h.name,
+	}).Info("Acquired VIP lease, added the VIP")
+}
+
+// release removes the VIP once the Lease is lost or given up
+func (h *vipHolder) release() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.held {
+		return
+	}
+	if err := h.delAddress(h.vip); err != nil {
+		log.WithFields(logrus.Fields{
+			"vip":   h.vip,
+			"lease": h.name,
+		}).WithError(err).Error("Failed to remove the VIP of the lost lease")
+		return
+	}
+	h.held = false
+	log.WithFields(logrus.Fields{
+		"vip":   h.vip,
+		"lease": h.name,
+	}).Info("Lost VIP lease, removed the VIP")
+}
+
+// contend runs the elections of the Lease until ctx is cancelled. The node
+// only contends while the service of the VIP is healthy, and gives the
+// Lease up as soon as it is not.
+func (h *vipHolder) contend(ctx context.Context) {
+	// A VIP left behind by a previous run is not backed by a Lease
+	if err := h.delAddress(h.vip); err != nil {
+		log.WithFields(logrus.Fields{
+			"vip": h.vip,
+		}).WithError(err).Warn("Failed to remove a VIP left behind")
+	}
+	retryPeriod := h.retryPeriod
+	for ctx.Err() == nil {
+		if !h.healthy() {
+			sleep(ctx, retryPeriod)
+			continue
+		}
+		electCtx, cancel := context.WithCancel(ctx)
+		watched := make(chan struct{})
+		go func() {
+			defer close(watched)
+			for sleep(electCtx, retryPeriod) {
+				if !h.healthy() {
+					log.WithFields(logrus.Fields{
+						"vip":   h.vip,
+						"lease": h.name,
+					}).Info("Service of the VIP is unhealthy, giving the VIP lease up")
+					cancel()
+					return
+				}
+			}
+		}()
+		err := h.elect(electCtx, leaderelection.LeaderCallbacks{
+			OnStartedLeading: h.hold,
+			OnStoppedLeading: h.release,
+		})
+		cancel()
+		<-watched
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"vip":   h.vip,
+				"lease": h.name,
+			}).WithError(err).Error("Failed to run the VIP lease election")
+			sleep(ctx, retryPeriod)
+		}
+	}
+}
+
+// newVIPHolders returns the holders of the API VIPs, contending while the
+// API is reachable on the node, and of the ingress VIPs, contending while
+// the router is ready, with the Leases of opts
+func newVIPHolders(cfgOpts config.Options, kubeconfigPath, identity string, apiVips, ingressVips []net.IP, apiPort uint16, opts VIPLeaseOptions) []*vipHolder {
+	apiHealthy := func() bool {
+		healthy, _ := utils.IsKubernetesHealthy(apiPort)
+		return healthy
+	}
+	holders := []*vipHolder{}
+	for _, vip := range apiVips {
+		holders = append(holders, newVIPHolder(cfgOpts, kubeconfigPath, identity, vip, apiHealthy, opts))
+	}
+	for _, vip := range ingressVips {
+		holders = append(holders, newVIPHolder(cfgOpts, kubeconfigPath, identity, vip, isIngressHealthy, opts))
+	}
+	return holders
+}
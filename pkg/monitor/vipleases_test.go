@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+var _ = Describe("vip_leases", func() {
+	It("names_the_lease_of_each_vip", func() {
+		Expect(vipLeaseName(net.ParseIP("192.168.111.5"))).To(Equal("vip-192-168-111-5"))
+		Expect(vipLeaseName(net.ParseIP("fd2e:6f44:5dd8::5"))).To(Equal("vip-fd2e-6f44-5dd8--5"))
+	})
+
+	It("validates_the_durations", func() {
+		Expect(DefaultOptions().VIPLeases.Validate()).To(Succeed())
+		Expect(VIPLeaseOptions{Duration: 10 * time.Second, RenewDeadline: 10 * time.Second, RetryPeriod: time.Second}.Validate()).NotTo(Succeed())
+		Expect(VIPLeaseOptions{Duration: 15 * time.Second, RenewDeadline: 2 * time.Second, RetryPeriod: 2 * time.Second}.Validate()).NotTo(Succeed())
+		Expect(VIPLeaseOptions{Duration: 15 * time.Second, RenewDeadline: 10 * time.Second}.Validate()).NotTo(Succeed())
+	})
+
+	It("has_the_vip_while_holding_the_lease_of_a_healthy_service", func() {
+		var lock sync.Mutex
+		healthy, present := true, false
+		elections := 0
+		holder := &vipHolder{vip: net.ParseIP("192.168.111.5"), name: "vip-192-168-111-5", retryPeriod: 10 * time.Millisecond}
+		holder.healthy = func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return healthy
+		}
+		holder.addAddress = func(net.IP) error {
+			lock.Lock()
+			defer lock.Unlock()
+			present = true
+			return nil
+		}
+		holder.delAddress = func(net.IP) error {
+			lock.Lock()
+			defer lock.Unlock()
+			present = false
+			return nil
+		}
+		// Every election is won at once and held until cancelled, as
+		// leaderelection runs the callbacks
+		holder.elect = func(ctx context.Context, callbacks leaderelection.LeaderCallbacks) error {
+			lock.Lock()
+			elections++
+			lock.Unlock()
+			defer callbacks.OnStoppedLeading()
+			leadCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go callbacks.OnStartedLeading(leadCtx)
+			<-ctx.Done()
+			return nil
+		}
+		isPresent := func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return present
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			holder.contend(ctx)
+			close(done)
+		}()
+		Eventually(isPresent).Should(BeTrue())
+
+		lock.Lock()
+		healthy = false
+		lock.Unlock()
+		Eventually(isPresent).Should(BeFalse())
+		Consistently(isPresent, 50*time.Millisecond).Should(BeFalse())
+
+		lock.Lock()
+		healthy = true
+		lock.Unlock()
+		Eventually(isPresent).Should(BeTrue())
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+		Expect(isPresent()).To(BeFalse())
+		lock.Lock()
+		defer lock.Unlock()
+		Expect(elections).To(Equal(2))
+	})
+})
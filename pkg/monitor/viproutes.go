@@ -0,0 +1,234 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// vipRouteProtocol marks the routes of the VIPs, so that they are told
+	// apart from the routes of the node
+	vipRouteProtocol = 200
+	// vipRouteHookTimeout is how long the route hook can run
+	vipRouteHookTimeout = 30 * time.Second
+)
+
+// VIPRouteOptions are the routes installed while the node holds a VIP, for
+// L3 fabrics that learn the VIPs from the routes of their holder
+type VIPRouteOptions struct {
+	// Gateways are the next hops of the host routes of the VIPs, those of
+	// the family of a VIP are used
+	Gateways []net.IP
+	// Table is the routing table of the host routes
+	Table int
+	// Hook is an executable run with add or del and the VIP when the node
+	// gains or loses a VIP, e.g. to write a NetworkManager dispatcher file
+	Hook string
+}
+
+// Enabled tells whether anything is done when the node gains a VIP
+func (o VIPRouteOptions) Enabled() bool {
+	return len(o.Gateways) > 0 || o.Hook != ""
+}
+
+// gatewaysOf returns the gateways of the family of vip
+func (o VIPRouteOptions) gatewaysOf(vip net.IP) []net.IP {
+	gateways := []net.IP{}
+	for _, gw := range o.Gateways {
+		if (gw.To4() != nil) == (vip.To4() != nil) {
+			gateways = append(gateways, gw)
+		}
+	}
+	return gateways
+}
+
+// vipRoute returns the host route of vip through the gateways of its family,
+// nil when there is none
+func (o VIPRouteOptions) vipRoute(vip net.IP) *netlink.Route {
+	gateways := o.gatewaysOf(vip)
+	if len(gateways) == 0 {
+		return nil
+	}
+	route := &netlink.Route{
+		Dst:      &net.IPNet{IP: vip, Mask: vipMask(vip)},
+		Table:    o.Table,
+		Protocol: vipRouteProtocol,
+	}
+	if len(gateways) == 1 {
+		route.Gw = gateways[0]
+		return route
+	}
+	for _, gw := range gateways {
+		route.MultiPath = append(route.MultiPath, &netlink.NexthopInfo{Gw: gw})
+	}
+	return route
+}
+
+// localVIPs returns which of vips are on an interface of the node
+func localVIPs(vips []net.IP) (map[string]bool, error) {
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	local := map[string]bool{}
+	for _, vip := range vips {
+		for _, addr := range addrs {
+			if addr.IP.Equal(vip) {
+				local[vip.String()] = true
+			}
+		}
+	}
+	return local, nil
+}
+
+func runVIPRouteHook(hook, action string, vip net.IP) error {
+	ctx, cancel := context.WithTimeout(context.Background(), vipRouteHookTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, hook, action, vip.String()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s %s: %w: %s", hook, action, vip, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// vipRouter installs the routes of the VIPs the node holds and removes
+// those of the VIPs it lost
+type vipRouter struct {
+	opts VIPRouteOptions
+	vips []net.IP
+	// routed holds the VIPs whose routes are installed
+	routed map[string]bool
+
+	// swapped out by the tests
+	localVIPs func(vips []net.IP) (map[string]bool, error)
+	addRoute  func(route *netlink.Route) error
+	delRoute  func(route *netlink.Route) error
+	runHook   func(action string, vip net.IP) error
+}
+
+func newVIPRouter(opts VIPRouteOptions, vips []net.IP) *vipRouter {
+	return &vipRouter{
+		opts:      opts,
+		vips:      vips,
+		routed:    map[string]bool{},
+		localVIPs: localVIPs,
+		addRoute:  netlink.RouteReplace,
+		delRoute:  netlink.RouteDel,
+		runHook: func(action string, vip net.IP) error {
+			return runVIPRouteHook(opts.Hook, action, vip)
+		},
+	}
+}
+
+func (r *vipRouter) route(vip net.IP) error {
+	if route := r.opts.vipRoute(vip); route != nil {
+		if err := r.addRoute(route); err != nil {
+			return err
+		}
+	}
+	if r.opts.Hook != "" {
+		if err := r.runHook("add", vip); err != nil {
+			return err
+		}
+	}
+	r.routed[vip.String()] = true
+	log.WithFields(logrus.Fields{
+		"vip":      vip,
+		"gateways": r.opts.gatewaysOf(vip),
+	}).Info("Node holds the VIP, installed its routes")
+	return nil
+}
+
+func (r *vipRouter) unroute(vip net.IP) error {
+	if route := r.opts.vipRoute(vip); route != nil {
+		if err := r.delRoute(route); err != nil && !errors.Is(err, unix.ESRCH) {
+			return err
+		}
+	}
+	if r.opts.Hook != "" {
+		if err := r.runHook("del", vip); err != nil {
+			return err
+		}
+	}
+	delete(r.routed, vip.String())
+	log.WithFields(logrus.Fields{
+		"vip": vip,
+	}).Info("Node lost the VIP, removed its routes")
+	return nil
+}
+
+// reconcile routes the VIPs on the node and unroutes the others. A failed
+// VIP is retried on the next call.
+func (r *vipRouter) reconcile() {
+	local, err := r.localVIPs(r.vips)
+	if err != nil {
+		log.WithError(err).Error("Failed to list the VIPs of the node")
+		return
+	}
+	for _, vip := range r.vips {
+		var err error
+		switch {
+		case local[vip.String()] && !r.routed[vip.String()]:
+			err = r.route(vip)
+		case !local[vip.String()] && r.routed[vip.String()]:
+			err = r.unroute(vip)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"vip": vip,
+			}).WithError(err).Error("Failed to update the routes of the VIP")
+		}
+	}
+}
+
+// watchAddresses calls reconcile on every address change of the node, and
+// every interval in case a change was missed, until ctx is cancelled
+func watchAddresses(ctx context.Context, interval time.Duration, reconcile func()) {
+	updates := make(chan netlink.AddrUpdate, 16)
+	if err := netlink.AddrSubscribe(updates, ctx.Done()); err != nil {
+		log.WithError(err).Warn("Failed to subscribe to the address changes, only checking the VIPs every interval")
+		updates = nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reconcile()
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-updates:
+			if !ok {
+				updates = nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// run reconciles on every address change of the node until ctx is cancelled
+func (r *vipRouter) run(ctx context.Context, interval time.Duration) {
+	watchAddresses(ctx, interval, r.reconcile)
+}
+
+// unrouteAll removes the routes of every VIP, the node stops claiming them
+func (r *vipRouter) unrouteAll() {
+	for _, vip := range r.vips {
+		if !r.routed[vip.String()] {
+			continue
+		}
+		if err := r.unroute(vip); err != nil {
+			log.WithFields(logrus.Fields{
+				"vip": vip,
+			}).WithError(err).Error("Failed to remove the routes of the VIP")
+		}
+	}
+}
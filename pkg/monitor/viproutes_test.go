@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("vip_routes", func() {
+	apiVip, ingressVip := net.ParseIP("192.168.111.5"), net.ParseIP("fd2e:6f44:5dd8::4")
+	var local map[string]bool
+	var routes map[string]*netlink.Route
+	var hooks []string
+	var router *vipRouter
+
+	BeforeEach(func() {
+		opts := VIPRouteOptions{
+			Gateways: []net.IP{net.ParseIP("192.168.111.1"), net.ParseIP("192.168.111.2"), net.ParseIP("fd2e:6f44:5dd8::1")},
+			Table:    254,
+			Hook:     "/usr/local/bin/vip-hook",
+		}
+		local = map[string]bool{}
+		routes = map[string]*netlink.Route{}
+		hooks = []string{}
+		router = newVIPRouter(opts, []net.IP{apiVip, ingressVip})
+		router.localVIPs = func([]net.IP) (map[string]bool, error) {
+			return local, nil
+		}
+		router.addRoute = func(route *netlink.Route) error {
+			routes[route.Dst.String()] = route
+			return nil
+		}
+		router.delRoute = func(route *netlink.Route) error {
+			delete(routes, route.Dst.String())
+			return nil
+		}
+		router.runHook = func(action string, vip net.IP) error {
+			hooks = append(hooks, action+" "+vip.String())
+			return nil
+		}
+	})
+
+	It("builds_the_host_route_through_the_gateways_of_the_family", func() {
+		route := router.opts.vipRoute(apiVip)
+		Expect(route.Dst.String()).To(Equal("192.168.111.5/32"))
+		Expect(route.MultiPath).To(HaveLen(2))
+		route = router.opts.vipRoute(ingressVip)
+		Expect(route.Dst.String()).To(Equal("fd2e:6f44:5dd8::4/128"))
+		Expect(route.Gw.String()).To(Equal("fd2e:6f44:5dd8::1"))
+
+		router.opts.Gateways = router.opts.Gateways[:2]
+		Expect(router.opts.vipRoute(ingressVip)).To(BeNil())
+	})
+
+	It("follows_the_vips_of_the_node", func() {
+		router.reconcile()
+		Expect(routes).To(BeEmpty())
+		Expect(hooks).To(BeEmpty())
+
+		local[apiVip.String()] = true
+		router.reconcile()
+		router.reconcile()
+		Expect(routes).To(HaveKey("192.168.111.5/32"))
+		Expect(hooks).To(Equal([]string{"add 192.168.111.5"}))
+
+		local = map[string]bool{ingressVip.String(): true}
+		router.reconcile()
+		Expect(routes).To(HaveLen(1))
+		Expect(routes).To(HaveKey("fd2e:6f44:5dd8::4/128"))
+		Expect(hooks).To(Equal([]string{"add 192.168.111.5", "del 192.168.111.5", "add fd2e:6f44:5dd8::4"}))
+
+		router.unrouteAll()
+		Expect(routes).To(BeEmpty())
+		Expect(hooks[len(hooks)-1]).To(Equal("del fd2e:6f44:5dd8::4"))
+	})
+})
@@ -0,0 +1,150 @@
+package monitorcmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+	"github.com/openshift/baremetal-runtimecfg/pkg/render"
+)
+
+// NewCorednsCommand returns the coredns monitor command named name.
+func NewCorednsCommand(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name + " path_to_kubeconfig path_to_keepalived_cfg_template path_to_config",
+		Short: "Monitors runtime external interface for Coredns Corefile changes",
+		RunE:  runCoredns,
+	}
+	cmd.PersistentFlags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
+	cmd.Flags().Duration("check-interval", time.Second*30, "Time between coredns watch checks")
+	addAPIVipFlags(cmd.Flags())
+	addIngressVipFlags(cmd.Flags())
+	addCloudLBFlags(cmd.Flags())
+	cmd.Flags().String("api-dns-view", "internal", "Which api record targets the node-local DNS serves: internal (VIPs) or external (cloud LBs)")
+	cmd.Flags().Bool("ingress-ready-nodes-only", false, "Only include Ready nodes in the ingress node addresses")
+	cmd.Flags().String("ingress-node-selector", "", "Label selector restricting the ingress node addresses, e.g. the IngressController node placement")
+	cmd.Flags().String("health-address", "", "Address (e.g. :29500) where /healthz is served. Disabled when empty")
+	cmd.Flags().String("metrics-address", "", "Address (e.g. :29501) where the render /metrics are served. Disabled when empty")
+	cmd.Flags().StringArray("additional-template", nil, "Extra path_to_template=path_to_output rendered together with the Corefile. Can be repeated")
+	addNodeFlags(cmd.Flags())
+	addProbeFlags(cmd.Flags())
+	addFaultFlags(cmd.Flags())
+	addDriftFlags(cmd.Flags())
+	addShutdownFlags(cmd.Flags())
+	addTemplateFlags(cmd.Flags())
+	addSiteFlags(cmd.Flags())
+	addSharedConfigFlags(cmd.Flags())
+	addSteadyFlags(cmd.Flags())
+	cmd.Flags().Bool("ingress-shards", false, "Read the IngressControllers annotated with "+config.IngressShardVIPsAnnotation+" into the ingress shards of the config, each with its own VIPs and peers")
+	cmd.Flags().Bool("gate-apps-wildcard", false, "Set .Cluster.NoReadyRouters while no router of the default IngressController is ready, for the template to leave the *.apps record out")
+	return cmd
+}
+
+func runCoredns(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Help()
+		return nil
+	}
+	opts := monitor.DefaultOptions()
+	opts.KubeconfigPath, opts.TemplatePath, opts.CfgPath = args[0], args[1], args[2]
+	opts.APIVIPs, opts.IngressVIPs = getAPIVips(cmd), getIngressVips(cmd)
+	opts.ClusterLBConfig = config.ClusterLBConfig{
+		ApiLBIPs:     getIPSlice(cmd, "cloud-ext-lb-ips"),
+		ApiIntLBIPs:  getIPSlice(cmd, "cloud-int-lb-ips"),
+		IngressLBIPs: getIPSlice(cmd, "cloud-ingress-lb-ips"),
+	}
+	var err error
+	if opts.Interval, err = cmd.Flags().GetDuration("check-interval"); err != nil {
+		return err
+	}
+	if opts.ClusterConfigPath, err = cmd.Flags().GetString("cluster-config"); err != nil {
+		return err
+	}
+
+	if opts.DNSView, err = cmd.Flags().GetString("api-dns-view"); err != nil {
+		return err
+	}
+	if err := config.ValidateDNSView(opts.DNSView); err != nil {
+		return err
+	}
+
+	opts.IngressFilter.ReadyOnly, err = cmd.Flags().GetBool("ingress-ready-nodes-only")
+	if err != nil {
+		return err
+	}
+	ingressSelector, err := cmd.Flags().GetString("ingress-node-selector")
+	if err != nil {
+		return err
+	}
+	if ingressSelector != "" {
+		opts.IngressFilter.Selector, err = labels.Parse(ingressSelector)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.HealthAddress, err = cmd.Flags().GetString("health-address"); err != nil {
+		return err
+	}
+	if opts.MetricsAddress, err = cmd.Flags().GetString("metrics-address"); err != nil {
+		return err
+	}
+	if opts.ClusterLBConfig.UserManaged, err = cmd.Flags().GetBool("user-managed-lb"); err != nil {
+		return err
+	}
+	if err := setNodeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setProbeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setFaultOptions(cmd); err != nil {
+		return err
+	}
+	if err := setDriftOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setShutdownOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setTemplateOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSiteOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSharedConfigOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSteadyOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if opts.GatherIngressShards, err = cmd.Flags().GetBool("ingress-shards"); err != nil {
+		return err
+	}
+	if opts.GateAppsWildcard, err = cmd.Flags().GetBool("gate-apps-wildcard"); err != nil {
+		return err
+	}
+	if err := watchLogLevels(cmd, args[0], "coredns-monitor"); err != nil {
+		return err
+	}
+
+	additionalTemplates, err := cmd.Flags().GetStringArray("additional-template")
+	if err != nil {
+		return err
+	}
+	for _, t := range additionalTemplates {
+		paths := strings.SplitN(t, "=", 2)
+		if len(paths) != 2 || paths[0] == "" || paths[1] == "" {
+			return fmt.Errorf("Invalid additional template %s, expected path_to_template=path_to_output", t)
+		}
+		opts.ExtraFiles = append(opts.ExtraFiles, render.FileSpec{TemplatePath: paths[0], RenderPath: paths[1]})
+	}
+
+	return monitor.CorednsWatch(opts)
+}
@@ -0,0 +1,80 @@
+package monitorcmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+)
+
+// NewDnsmasqCommand returns the dnsmasq monitor command named name.
+func NewDnsmasqCommand(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name + " path_to_kubeconfig path_to_host_file_cfg_template path_to_config",
+		Short: "Monitors dnsmasq host configmap",
+		RunE:  runDnsmasq,
+	}
+	cmd.Flags().Duration("check-interval", time.Second*30, "Time between coredns watch checks")
+	addAPIVipFlags(cmd.Flags())
+	cmd.Flags().String("dnsmasq-pidfile", "/run/dnsmasq.pid", "Pid file of the dnsmasq process to send SIGHUP to. If empty or unreadable, the DBus cache clear is used")
+	cmd.Flags().String("metrics-address", "", "Address (e.g. :29502) where the render /metrics are served. Disabled when empty")
+	addProbeFlags(cmd.Flags())
+	addFaultFlags(cmd.Flags())
+	addShutdownFlags(cmd.Flags())
+	addTemplateFlags(cmd.Flags())
+	addSiteFlags(cmd.Flags())
+	addSharedConfigFlags(cmd.Flags())
+	addSteadyFlags(cmd.Flags())
+	cmd.Flags().String("bmh-namespace", "", "Namespace of the BareMetalHosts used to render DHCP static leases. Disabled when empty")
+	return cmd
+}
+
+func runDnsmasq(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Help()
+		return nil
+	}
+	opts := monitor.DefaultOptions()
+	opts.KubeconfigPath, opts.TemplatePath, opts.CfgPath = args[0], args[1], args[2]
+	opts.APIVIPs = getAPIVips(cmd)
+	var err error
+	if opts.Interval, err = cmd.Flags().GetDuration("check-interval"); err != nil {
+		return err
+	}
+
+	if opts.DnsmasqPidFile, err = cmd.Flags().GetString("dnsmasq-pidfile"); err != nil {
+		return err
+	}
+
+	if opts.BMHNamespace, err = cmd.Flags().GetString("bmh-namespace"); err != nil {
+		return err
+	}
+
+	if opts.MetricsAddress, err = cmd.Flags().GetString("metrics-address"); err != nil {
+		return err
+	}
+	if err := setProbeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setFaultOptions(cmd); err != nil {
+		return err
+	}
+	if err := setShutdownOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setTemplateOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSiteOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSharedConfigOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSteadyOptions(cmd, &opts); err != nil {
+		return err
+	}
+
+	return monitor.DnsmasqWatch(opts)
+}
@@ -0,0 +1,622 @@
+// Package monitorcmd holds the cobra commands of the monitor binaries so they
+// can be shipped both as individual binaries and as netmonitor subcommands.
+package monitorcmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/alerts"
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/faults"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+	"github.com/openshift/baremetal-runtimecfg/pkg/tracing"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+func addAPIVipFlags(flags *pflag.FlagSet) {
+	flags.IP("api-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift API")
+	flags.IPSlice("api-vips", nil, "Virtual IP Addresses to reach the OpenShift API")
+}
+
+func addIngressVipFlags(flags *pflag.FlagSet) {
+	flags.IP("ingress-vip", nil, "DEPRECATED: Virtual IP Address to reach the OpenShift Ingress Routers")
+	flags.IPSlice("ingress-vips", nil, "Virtual IP Addresses to reach the OpenShift Ingress Routers")
+}
+
+func addCloudLBFlags(flags *pflag.FlagSet) {
+	flags.IPSlice("cloud-ext-lb-ips", nil, "IP Addresses of Cloud External Load Balancers for OpenShift API")
+	flags.IPSlice("cloud-int-lb-ips", nil, "IP Addresses of Cloud Internal Load Balancers for OpenShift Internal API")
+	flags.IPSlice("cloud-ingress-lb-ips", nil, "IP Addresses of Cloud Ingress Load Balancers")
+	flags.Bool("user-managed-lb", false, "The API and ingress go through load balancers of the user, the --cloud-*-lb-ips or else the VIPs, which the DNS records point at")
+}
+
+// getVips returns the VIPs passed with the --<kind>-vips flag or, if only
+// the deprecated --<kind>-vip flag was used, coerces it into the list format
+// that the rest of the code now expects.
+func getVips(cmd *cobra.Command, kind string) []net.IP {
+	vip, err := cmd.Flags().GetIP(kind + "-vip")
+	if err != nil {
+		vip = nil
+	}
+	vips, err := cmd.Flags().GetIPSlice(kind + "-vips")
+	if err != nil {
+		vips = []net.IP{}
+	}
+	if len(vips) < 1 && vip != nil {
+		vips = []net.IP{vip}
+	}
+	return vips
+}
+
+func getAPIVips(cmd *cobra.Command) []net.IP {
+	return getVips(cmd, "api")
+}
+
+func getIngressVips(cmd *cobra.Command) []net.IP {
+	return getVips(cmd, "ingress")
+}
+
+// getIPSlice returns an empty list instead of an error for optional IP list
+// flags.
+func getIPSlice(cmd *cobra.Command, name string) []net.IP {
+	ips, err := cmd.Flags().GetIPSlice(name)
+	if err != nil {
+		return []net.IP{}
+	}
+	return ips
+}
+
+func addFirewallFlags(flags *pflag.FlagSet) {
+	flags.String("firewall-backend", monitor.FirewallBackendAuto, "How the API redirect rules are managed: auto, iptables or nftables. auto uses iptables when its binary is available")
+	flags.String("firewall-rule-mode", monitor.RuleModeAuto, "How the API traffic of the VIPs is sent to HAProxy: auto, redirect, dnat-snat or dnat-mark. auto picks the mode from the network type in the cluster config")
+}
+
+// setFirewallOptions sets the firewall backend and rule mode of opts from the
+// flags, reading the network type from opts.ClusterConfigPath for auto
+func setFirewallOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	opts.FirewallBackend, err = cmd.Flags().GetString("firewall-backend")
+	if err != nil {
+		return err
+	}
+	mode, err := cmd.Flags().GetString("firewall-rule-mode")
+	if err != nil {
+		return err
+	}
+	if err := monitor.ValidateRuleMode(mode); err != nil {
+		return err
+	}
+	if mode == monitor.RuleModeAuto {
+		networkType := ""
+		if opts.ClusterConfigPath != "" {
+			networkType, err = config.GetNetworkType(opts.ClusterConfigPath)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"cluster-config": opts.ClusterConfigPath,
+				}).WithError(err).Warn("Failed to read the network type, using the default firewall rule mode")
+			}
+		}
+		mode = monitor.DefaultRuleMode(networkType)
+		log.WithFields(logrus.Fields{
+			"networkType": networkType,
+			"mode":        mode,
+		}).Info("Selected firewall rule mode")
+	}
+	opts.FirewallRuleMode = mode
+	return nil
+}
+
+func addAPIPortFlags(flags *pflag.FlagSet) {
+	flags.StringArray("extra-api-port", nil, "Port the API is also reached on through the API VIPs, as port:lbport where lbport is the port HAProxy listens on for it, e.g. 443:9443. Can be repeated")
+}
+
+// setAPIPortOptions sets the API ports exposed besides the one of
+// opts.APIPort and opts.LbPort from the flags
+func setAPIPortOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	values, err := cmd.Flags().GetStringArray("extra-api-port")
+	if err != nil {
+		return err
+	}
+	ports, err := config.ParseAPIPorts(values)
+	if err != nil {
+		return err
+	}
+	opts.Config.ExtraAPIPorts = ports
+	return config.ValidateAPIPorts(config.APIPorts(opts.APIPort, opts.LbPort, ports))
+}
+
+func addProbeFlags(flags *pflag.FlagSet) {
+	flags.String("probe-address", "", "Address (e.g. :29448) where the /healthz liveness and /readyz readiness of the monitor loops are served. Disabled when empty")
+}
+
+func setProbeOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	opts.ProbeAddress, err = cmd.Flags().GetString("probe-address")
+	return err
+}
+
+func addDriftFlags(flags *pflag.FlagSet) {
+	flags.Bool("detect-config-drift", false, "Compare the rendered configuration with the content the monitor rendered and, given the pid file of the service, with the configuration the service loaded, reporting drift in the log and the baremetal_runtimecfg_config_drift metric")
+	flags.Bool("rerender-on-drift", false, "Render and reload a drifted configuration again, with --detect-config-drift")
+}
+
+func setDriftOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.DetectConfigDrift, err = cmd.Flags().GetBool("detect-config-drift"); err != nil {
+		return err
+	}
+	opts.RerenderOnDrift, err = cmd.Flags().GetBool("rerender-on-drift")
+	return err
+}
+
+func addShutdownFlags(flags *pflag.FlagSet) {
+	flags.Duration("shutdown-timeout", monitor.DefaultOptions().ShutdownTimeout, "How long the monitor waits for its background loops on SIGTERM before it removes its firewall rules and releases its VIPs anyway")
+}
+
+func setShutdownOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	opts.ShutdownTimeout, err = cmd.Flags().GetDuration("shutdown-timeout")
+	return err
+}
+
+func addTemplateFlags(flags *pflag.FlagSet) {
+	defaults := monitor.DefaultOptions().Render
+	flags.String("template-override-dir", defaults.OverrideDir, "Directory of templates used instead of the ones of the same name given to the monitor, as long as they render a valid file. Disabled when empty")
+	flags.Int("keep-generations", defaults.KeepGenerations, "How many previous versions of each rendered file are kept next to it as <file>.<n> for a rollback")
+	flags.StringToString("secret-file", nil, "Secret values used by the templates as .Secrets.<name>, given as name=path of the file holding the value, e.g. of a mounted Secret. The rendered files containing one are only readable by their owner. Can be repeated")
+}
+
+func setTemplateOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.Render.OverrideDir, err = cmd.Flags().GetString("template-override-dir"); err != nil {
+		return err
+	}
+	if opts.Render.KeepGenerations, err = cmd.Flags().GetInt("keep-generations"); err != nil {
+		return err
+	}
+	opts.Render.SecretFiles, err = cmd.Flags().GetStringToString("secret-file")
+	return err
+}
+
+func addSiteFlags(flags *pflag.FlagSet) {
+	flags.String("site-label", "", "Node label whose value names the site of a node in a stretched cluster. The VIPs, keepalived peers and API backends are then those of the site of the node. Disabled when empty")
+	flags.StringArray("site-api-vips", nil, "API VIPs of a site as site=vip[,vip], used instead of --api-vips on the nodes of the site. Can be repeated")
+	flags.StringArray("site-ingress-vips", nil, "Ingress VIPs of a site as site=vip[,vip], used instead of --ingress-vips on the nodes of the site. Can be repeated")
+}
+
+// parseSiteVIPs parses the site=vip[,vip] values of a site VIPs flag
+func parseSiteVIPs(values []string) (map[string][]net.IP, error) {
+	sites := map[string][]net.IP{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid site VIPs %s, expected site=vip[,vip]", value)
+		}
+		for _, vip := range strings.Split(parts[1], ",") {
+			ip := net.ParseIP(vip)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid VIP %s of site %s", vip, parts[0])
+			}
+			sites[parts[0]] = append(sites[parts[0]], ip)
+		}
+	}
+	return sites, nil
+}
+
+func setSiteOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	sites := config.Sites{}
+	var err error
+	if sites.Label, err = cmd.Flags().GetString("site-label"); err != nil {
+		return err
+	}
+	for flag, vips := range map[string]*map[string][]net.IP{"site-api-vips": &sites.APIVIPs, "site-ingress-vips": &sites.IngressVIPs} {
+		values, err := cmd.Flags().GetStringArray(flag)
+		if err != nil {
+			return err
+		}
+		if *vips, err = parseSiteVIPs(values); err != nil {
+			return err
+		}
+	}
+	if !sites.Enabled() && len(sites.APIVIPs)+len(sites.IngressVIPs) > 0 {
+		return fmt.Errorf("--site-api-vips and --site-ingress-vips need --site-label")
+	}
+	opts.Config.Sites = sites
+	return nil
+}
+
+func addNodeFlags(flags *pflag.FlagSet) {
+	flags.String("node-name", os.Getenv("NODE_NAME"), "Name of the node the monitor runs on, used to publish its health and to apply the keepalived overrides of the node. Both are disabled when empty")
+	flags.Duration("health-heartbeat", monitor.DefaultOptions().HealthHeartbeat, "How often an unchanged health is published again")
+	flags.Bool("emit-events", false, "Record the reloads, mode switches and firewall repairs as Events of the node in the pod namespace. Requires --node-name and the create permission on events")
+	flags.String("node-label-selector", "", "Label selector restricting every node list of the monitor, e.g. node-role.kubernetes.io/master=")
+	flags.String("node-field-selector", "", "Field selector restricting every node list of the monitor")
+	flags.Duration("node-resync", config.DefaultNodeCacheOptions().Resync, "How long a node list is shared before the nodes are listed again. Listed on every use when zero")
+	flags.Duration("slow-operation-threshold", tracing.SlowThreshold, "Duration above which a configuration computation or rendering is logged with the duration of its phases at warning level")
+	flags.StringSlice("exclude-devices", nil, "Glob patterns of the devices whose addresses are ignored, on top of the OVN and pod interfaces. A driver: prefix matches the device driver, e.g. driver:iavf")
+	flags.Duration("log-level-interval", 30*time.Second, "How often the log levels are read from the logging ConfigMap. Disabled when zero")
+}
+
+// setNodeOptions sets the node overrides, the health publication and the
+// events of opts and configures the shared node cache from the flags
+func setNodeOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.NodeName, err = cmd.Flags().GetString("node-name"); err != nil {
+		return err
+	}
+	if opts.HealthHeartbeat, err = cmd.Flags().GetDuration("health-heartbeat"); err != nil {
+		return err
+	}
+	if opts.EmitEvents, err = cmd.Flags().GetBool("emit-events"); err != nil {
+		return err
+	}
+	if tracing.SlowThreshold, err = cmd.Flags().GetDuration("slow-operation-threshold"); err != nil {
+		return err
+	}
+	if opts.Config.ExcludedDevices, err = cmd.Flags().GetStringSlice("exclude-devices"); err != nil {
+		return err
+	}
+	if err := utils.ValidateExcludedDevices(opts.Config.ExcludedDevices); err != nil {
+		return err
+	}
+
+	cache := config.DefaultNodeCacheOptions()
+	if cache.LabelSelector, err = cmd.Flags().GetString("node-label-selector"); err != nil {
+		return err
+	}
+	if _, err := labels.Parse(cache.LabelSelector); err != nil {
+		return fmt.Errorf("Invalid node label selector: %w", err)
+	}
+	if cache.FieldSelector, err = cmd.Flags().GetString("node-field-selector"); err != nil {
+		return err
+	}
+	if cache.Resync, err = cmd.Flags().GetDuration("node-resync"); err != nil {
+		return err
+	}
+	config.SetNodeCacheOptions(cache)
+	return nil
+}
+
+func addBGPFlags(flags *pflag.FlagSet) {
+	defaults := monitor.DefaultOptions()
+	flags.String("vip-advertisement", monitor.VIPAdvertisementVRRP, "How the node claims the VIPs: vrrp with keepalived, bgp with FRR while their service is healthy, vrrp+bgp, or lease (experimental) by holding a coordination Lease per VIP")
+	flags.Duration("vip-lease-duration", defaults.VIPLeases.Duration, "How long the other nodes wait after the last renewal of a VIP Lease before taking the VIP over, with --vip-advertisement lease")
+	flags.Duration("vip-lease-renew-deadline", defaults.VIPLeases.RenewDeadline, "How long the holder of a VIP Lease retries to renew it before dropping the VIP, with --vip-advertisement lease")
+	flags.Duration("vip-lease-retry-period", defaults.VIPLeases.RetryPeriod, "Interval between the attempts to acquire or renew a VIP Lease, with --vip-advertisement lease")
+	flags.Uint32("bgp-asn", 0, "Autonomous system number of the node when advertising the VIPs with BGP")
+	flags.Uint32("bgp-peer-asn", 0, "Autonomous system number of the BGP peers, the one of the node when 0")
+	flags.IPSlice("bgp-peers", nil, "Addresses of the BGP peers the VIPs are advertised to")
+	flags.StringSlice("bgp-communities", nil, "BGP communities (e.g. 65000:100) set on the advertised VIP routes")
+	flags.String("frr-template", "", "Path of the FRR configuration template used when advertising the VIPs with BGP")
+	flags.String("frr-config", "/etc/frr/frr.conf", "Path where the FRR configuration is rendered")
+	flags.Bool("bfd", false, "Detect the failure of the node with BFD sessions to its BGP peers, so the VIPs are withdrawn in under a second")
+	flags.IPSlice("bfd-peers", nil, "BGP peers with a BFD session, every peer when empty")
+	flags.Uint32("bfd-receive-interval", 300, "Minimum interval in milliseconds between the BFD packets received from a peer")
+	flags.Uint32("bfd-transmit-interval", 300, "Minimum interval in milliseconds between the BFD packets sent to a peer")
+	flags.Uint8("bfd-detect-multiplier", 3, "Number of missed BFD packets after which a peer is down")
+}
+
+// setBGPOptions sets the VIP advertisement of opts from the flags
+func setBGPOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	opts.VIPAdvertisement, err = cmd.Flags().GetString("vip-advertisement")
+	if err != nil {
+		return err
+	}
+	if err := monitor.ValidateVIPAdvertisement(opts.VIPAdvertisement); err != nil {
+		return err
+	}
+	if opts.VIPAdvertisement == monitor.VIPAdvertisementVRRP {
+		return nil
+	}
+	if opts.VIPAdvertisement == monitor.VIPAdvertisementLease {
+		leases := monitor.VIPLeaseOptions{}
+		if leases.Duration, err = cmd.Flags().GetDuration("vip-lease-duration"); err != nil {
+			return err
+		}
+		if leases.RenewDeadline, err = cmd.Flags().GetDuration("vip-lease-renew-deadline"); err != nil {
+			return err
+		}
+		if leases.RetryPeriod, err = cmd.Flags().GetDuration("vip-lease-retry-period"); err != nil {
+			return err
+		}
+		if err := leases.Validate(); err != nil {
+			return err
+		}
+		opts.VIPLeases = leases
+		return nil
+	}
+
+	bgp := monitor.BGPConfig{}
+	if bgp.ASN, err = cmd.Flags().GetUint32("bgp-asn"); err != nil {
+		return err
+	}
+	if bgp.PeerASN, err = cmd.Flags().GetUint32("bgp-peer-asn"); err != nil {
+		return err
+	}
+	if bgp.PeerASN == 0 {
+		bgp.PeerASN = bgp.ASN
+	}
+	peers := getIPSlice(cmd, "bgp-peers")
+	for _, peer := range peers {
+		bgp.Peers = append(bgp.Peers, peer.String())
+	}
+	if bgp.Communities, err = cmd.Flags().GetStringSlice("bgp-communities"); err != nil {
+		return err
+	}
+	if bgp.TemplatePath, err = cmd.Flags().GetString("frr-template"); err != nil {
+		return err
+	}
+	if bgp.ConfigPath, err = cmd.Flags().GetString("frr-config"); err != nil {
+		return err
+	}
+	if err := setBFDOptions(cmd, &bgp.BFD); err != nil {
+		return err
+	}
+	switch {
+	case bgp.ASN == 0:
+		return fmt.Errorf("--bgp-asn is required with --vip-advertisement %s", opts.VIPAdvertisement)
+	case len(bgp.Peers) == 0:
+		return fmt.Errorf("--bgp-peers is required with --vip-advertisement %s", opts.VIPAdvertisement)
+	case bgp.TemplatePath == "":
+		return fmt.Errorf("--frr-template is required with --vip-advertisement %s", opts.VIPAdvertisement)
+	}
+	opts.BGP = bgp
+	return nil
+}
+
+func setBFDOptions(cmd *cobra.Command, bfd *config.BFDConfig) error {
+	var err error
+	if bfd.Enabled, err = cmd.Flags().GetBool("bfd"); err != nil {
+		return err
+	}
+	for _, peer := range getIPSlice(cmd, "bfd-peers") {
+		bfd.Peers = append(bfd.Peers, peer.String())
+	}
+	if bfd.ReceiveInterval, err = cmd.Flags().GetUint32("bfd-receive-interval"); err != nil {
+		return err
+	}
+	if bfd.TransmitInterval, err = cmd.Flags().GetUint32("bfd-transmit-interval"); err != nil {
+		return err
+	}
+	if bfd.DetectMultiplier, err = cmd.Flags().GetUint8("bfd-detect-multiplier"); err != nil {
+		return err
+	}
+	return bfd.Validate()
+}
+
+func addPriorityFlags(flags *pflag.FlagSet) {
+	flags.Int("vrrp-priority-base", monitor.DefaultOptions().Config.Priorities.Base, "keepalived priority of the nodes before their role bonus and hostname offset")
+	flags.Int("vrrp-priority-spread", monitor.DefaultOptions().Config.Priorities.Spread, "Number of offsets the hash of the hostname of a node spreads the priorities over, so that nodes rarely tie")
+	flags.StringArray("vrrp-priority-role-bonus", nil, "Priority bonus of the nodes with a node-role.kubernetes.io/<role> label as role=bonus, the highest one applies. Can be repeated")
+}
+
+func setPriorityOptions(cmd *cobra.Command, monitorOpts *monitor.Options) error {
+	opts := config.PriorityOptions{}
+	var err error
+	if opts.Base, err = cmd.Flags().GetInt("vrrp-priority-base"); err != nil {
+		return err
+	}
+	if opts.Spread, err = cmd.Flags().GetInt("vrrp-priority-spread"); err != nil {
+		return err
+	}
+	bonuses, err := cmd.Flags().GetStringArray("vrrp-priority-role-bonus")
+	if err != nil {
+		return err
+	}
+	if opts.RoleBonus, err = config.ParsePriorityRoleBonuses(bonuses); err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	monitorOpts.Config.Priorities = opts
+	return nil
+}
+
+func addSharedConfigFlags(flags *pflag.FlagSet) {
+	flags.String("shared-config-file", "", "Node-local file through which the monitors share the node config, so that they render from the same computation. Disabled when empty")
+	flags.Bool("publish-shared-config", false, "Compute the node config and publish it in --shared-config-file for the other monitors. Exactly one monitor of a node publishes it")
+	flags.Duration("shared-config-max-age", monitor.DefaultOptions().SharedConfig.MaxAge, "How old the shared config can be before the monitor computes the node config itself")
+}
+
+func setSharedConfigOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.SharedConfig.File, err = cmd.Flags().GetString("shared-config-file"); err != nil {
+		return err
+	}
+	if opts.SharedConfig.Publish, err = cmd.Flags().GetBool("publish-shared-config"); err != nil {
+		return err
+	}
+	if opts.SharedConfig.Publish && opts.SharedConfig.File == "" {
+		return fmt.Errorf("--publish-shared-config needs --shared-config-file")
+	}
+	opts.SharedConfig.MaxAge, err = cmd.Flags().GetDuration("shared-config-max-age")
+	return err
+}
+
+func addDrainFlags(flags *pflag.FlagSet) {
+	defaults := monitor.DefaultOptions()
+	flags.Duration("drain-timeout", defaults.DrainTimeout, "How long the API backends removed from the HAProxy configuration are drained through the runtime API before the reload. Needs --haproxy-control socket. Disabled when zero")
+	flags.Int("drain-session-threshold", defaults.DrainSessionThreshold, "Number of sessions of the drained API backends HAProxy is reloaded at without waiting for --drain-timeout")
+}
+
+func setDrainOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.DrainTimeout, err = cmd.Flags().GetDuration("drain-timeout"); err != nil {
+		return err
+	}
+	if opts.DrainSessionThreshold, err = cmd.Flags().GetInt("drain-session-threshold"); err != nil {
+		return err
+	}
+	if opts.DrainTimeout < 0 || opts.DrainSessionThreshold < 0 {
+		return fmt.Errorf("--drain-timeout and --drain-session-threshold cannot be negative")
+	}
+	return nil
+}
+
+func addAPIReachabilityFlags(flags *pflag.FlagSet) {
+	defaults := monitor.DefaultOptions()
+	flags.String("api-reachability-track-file", defaults.APIReachabilityTrackFile, "keepalived track file holding 1 while the API has not been reachable through HAProxy for --api-unreachable-threshold, to lower the priority of a node whose local LB keeps failing. Disabled when empty")
+	flags.Duration("api-unreachable-threshold", defaults.APIUnreachableThreshold, "How long the API must be unreachable through HAProxy before --api-reachability-track-file is set")
+}
+
+func setAPIReachabilityOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.APIReachabilityTrackFile, err = cmd.Flags().GetString("api-reachability-track-file"); err != nil {
+		return err
+	}
+	opts.APIUnreachableThreshold, err = cmd.Flags().GetDuration("api-unreachable-threshold")
+	return err
+}
+
+func addSteadyFlags(flags *pflag.FlagSet) {
+	defaults := monitor.DefaultOptions().Steady
+	flags.Int("steady-iterations", defaults.Iterations, "How many iterations in a row must find nothing to change before the check interval is doubled, up to --max-steady-interval. Any change or SIGHUP restores it. Disabled when zero")
+	flags.Duration("max-steady-interval", defaults.MaxInterval, "Longest check interval once nothing changes")
+}
+
+func setSteadyOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	if opts.Steady.Iterations, err = cmd.Flags().GetInt("steady-iterations"); err != nil {
+		return err
+	}
+	opts.Steady.MaxInterval, err = cmd.Flags().GetDuration("max-steady-interval")
+	return err
+}
+
+func addMaintenanceFlags(flags *pflag.FlagSet) {
+	flags.String("maintenance-file", monitor.DefaultOptions().MaintenanceFile, "Local file that puts the node in maintenance while it exists: its API backend is marked down in HAProxy and keepalived drops its priority. Disabled when empty")
+}
+
+func setMaintenanceOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	opts.MaintenanceFile, err = cmd.Flags().GetString("maintenance-file")
+	return err
+}
+
+func addVIPRouteFlags(flags *pflag.FlagSet) {
+	flags.IPSlice("vip-route-gateways", nil, "Gateways the host routes of the VIPs the node holds are installed through, for L3 fabrics that learn the VIPs from the routes of their holder")
+	flags.Int("vip-route-table", monitor.DefaultOptions().VIPRoutes.Table, "Routing table of the host routes of the VIPs")
+	flags.String("vip-route-hook", "", "Executable run with add or del and the VIP when the node gains or loses a VIP. Disabled when empty")
+}
+
+func setVIPRouteOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	routes := monitor.VIPRouteOptions{Gateways: getIPSlice(cmd, "vip-route-gateways")}
+	if routes.Table, err = cmd.Flags().GetInt("vip-route-table"); err != nil {
+		return err
+	}
+	if routes.Table < 1 {
+		return fmt.Errorf("Invalid VIP route table %d", routes.Table)
+	}
+	if routes.Hook, err = cmd.Flags().GetString("vip-route-hook"); err != nil {
+		return err
+	}
+	opts.VIPRoutes = routes
+	return nil
+}
+
+func addAlertFlags(flags *pflag.FlagSet) {
+	flags.String("alert-webhook-url", "", "URL the alerts on repeated reloads, persistent health failures and render failures are posted to, e.g. a Slack incoming webhook. Disabled when empty")
+	flags.String("alert-payload-template", alerts.DefaultPayloadTemplate, "Go template of the JSON body of an alert, executed with its .Condition, .Component, .Node, .Message and .Time. The json function quotes a string")
+	flags.Duration("alert-min-interval", alerts.DefaultOptions.MinInterval, "Shortest time between two alerts of the same condition")
+	flags.Int("alert-reload-threshold", alerts.DefaultOptions.ReloadThreshold, "Number of reloads within --alert-reload-window raising an alert")
+	flags.Duration("alert-reload-window", alerts.DefaultOptions.ReloadWindow, "Window the reloads are counted in")
+	flags.Duration("alert-unhealthy-for", alerts.DefaultOptions.UnhealthyFor, "How long a component is unhealthy before raising an alert")
+}
+
+func setAlertOptions(cmd *cobra.Command, opts *monitor.Options) error {
+	var err error
+	alertOpts := alerts.Options{}
+	if alertOpts.WebhookURL, err = cmd.Flags().GetString("alert-webhook-url"); err != nil {
+		return err
+	}
+	if alertOpts.PayloadTemplate, err = cmd.Flags().GetString("alert-payload-template"); err != nil {
+		return err
+	}
+	if alertOpts.MinInterval, err = cmd.Flags().GetDuration("alert-min-interval"); err != nil {
+		return err
+	}
+	if alertOpts.ReloadThreshold, err = cmd.Flags().GetInt("alert-reload-threshold"); err != nil {
+		return err
+	}
+	if alertOpts.ReloadWindow, err = cmd.Flags().GetDuration("alert-reload-window"); err != nil {
+		return err
+	}
+	if alertOpts.UnhealthyFor, err = cmd.Flags().GetDuration("alert-unhealthy-for"); err != nil {
+		return err
+	}
+	if err := alertOpts.Validate(); err != nil {
+		return err
+	}
+	opts.Alerts = alertOpts
+	return nil
+}
+
+// addServiceControlFlags adds the flags choosing how the monitor drives
+// service. The command adds the --<service>-pid-file flag.
+func addServiceControlFlags(flags *pflag.FlagSet, service string, defaults monitor.ServiceControlOptions) {
+	flags.String(service+"-control", defaults.Mechanism, "How "+service+" is reloaded: socket through the control socket of its container, systemd with systemctl for a host service, or pidfile by signaling the process of --"+service+"-pid-file")
+	flags.String(service+"-unit", defaults.Unit, "Systemd unit of "+service+", with --"+service+"-control systemd")
+}
+
+func getServiceControlOptions(cmd *cobra.Command, service string) (monitor.ServiceControlOptions, error) {
+	var err error
+	opts := monitor.ServiceControlOptions{}
+	if opts.Mechanism, err = cmd.Flags().GetString(service + "-control"); err != nil {
+		return opts, err
+	}
+	if opts.Unit, err = cmd.Flags().GetString(service + "-unit"); err != nil {
+		return opts, err
+	}
+	if opts.PidFile, err = cmd.Flags().GetString(service + "-pid-file"); err != nil {
+		return opts, err
+	}
+	return opts, opts.Validate()
+}
+
+// addFaultFlags adds the hidden fault injection flags to the binaries built
+// with the faultinjection tag
+func addFaultFlags(flags *pflag.FlagSet) {
+	if !faults.Enabled {
+		return
+	}
+	flags.Float64("inject-api-failure-rate", 0, "Fraction of the API requests failing")
+	flags.Duration("inject-render-delay", 0, "Delay added before rendering each file")
+	flags.Float64("inject-netlink-error", 0, "Fraction of the netlink address and route listings failing")
+	flags.Int64("inject-seed", 0, "Seed of the injected faults, to reproduce them. Random when zero")
+	for _, name := range []string{"inject-api-failure-rate", "inject-render-delay", "inject-netlink-error", "inject-seed"} {
+		flags.MarkHidden(name)
+	}
+}
+
+func setFaultOptions(cmd *cobra.Command) error {
+	if !faults.Enabled {
+		return nil
+	}
+	var err error
+	opts := faults.Options{}
+	if opts.APIFailureRate, err = cmd.Flags().GetFloat64("inject-api-failure-rate"); err != nil {
+		return err
+	}
+	if opts.RenderDelay, err = cmd.Flags().GetDuration("inject-render-delay"); err != nil {
+		return err
+	}
+	if opts.NetlinkErrorRate, err = cmd.Flags().GetFloat64("inject-netlink-error"); err != nil {
+		return err
+	}
+	if opts.Seed, err = cmd.Flags().GetInt64("inject-seed"); err != nil {
+		return err
+	}
+	return faults.Configure(opts)
+}
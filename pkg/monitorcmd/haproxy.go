@@ -0,0 +1,135 @@
+package monitorcmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+)
+
+// NewHAProxyCommand returns the haproxy monitor command named name.
+func NewHAProxyCommand(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name + " path_to_kubeconfig path_to_haproxy_cfg_template path_to_config",
+		Short: "Monitors master membership and updates HAProxy",
+		RunE:  runHAProxy,
+	}
+	cmd.Flags().Uint16("api-port", 6443, "Port where the OpenShift API listens")
+	cmd.Flags().Uint16("lb-port", 9445, "Port where the API HAProxy LB will listen")
+	cmd.Flags().Uint16("stat-port", 29445, "Port where the HAProxy stats API will listen")
+	cmd.Flags().Duration("check-interval", time.Second*6, "Time between monitor checks")
+	addAPIVipFlags(cmd.Flags())
+	cmd.Flags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve the network type")
+	cmd.Flags().String("metrics-address", "", "Address (e.g. :29447) where the firewall rule /metrics are served. Disabled when empty")
+	cmd.Flags().String("api-backend-source", monitor.DefaultOptions().Config.BackendSource, "Where the API backends are discovered: nodes (the master nodes) or endpointslices (the ready endpoints of the default/kubernetes service)")
+	addFirewallFlags(cmd.Flags())
+	addAPIPortFlags(cmd.Flags())
+	addNodeFlags(cmd.Flags())
+	addProbeFlags(cmd.Flags())
+	addFaultFlags(cmd.Flags())
+	addDriftFlags(cmd.Flags())
+	addShutdownFlags(cmd.Flags())
+	addTemplateFlags(cmd.Flags())
+	addSiteFlags(cmd.Flags())
+	addMaintenanceFlags(cmd.Flags())
+	addDrainFlags(cmd.Flags())
+	addAPIReachabilityFlags(cmd.Flags())
+	addAlertFlags(cmd.Flags())
+	addServiceControlFlags(cmd.Flags(), "haproxy", monitor.DefaultOptions().HAProxyControl)
+	cmd.Flags().String("haproxy-pid-file", "", "Path of the pid file of the HAProxy master, signaled with --haproxy-control pidfile")
+	return cmd
+}
+
+func runHAProxy(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Help()
+		return nil
+	}
+	if _, _, err := config.GetKubeconfigClusterNameAndDomain(args[0]); err != nil {
+		return err
+	}
+
+	opts := monitor.DefaultOptions()
+	opts.KubeconfigPath, opts.TemplatePath, opts.CfgPath = args[0], args[1], args[2]
+	opts.APIVIPs = getAPIVips(cmd)
+	var err error
+	if opts.APIPort, err = cmd.Flags().GetUint16("api-port"); err != nil {
+		return err
+	}
+	if opts.LbPort, err = cmd.Flags().GetUint16("lb-port"); err != nil {
+		return err
+	}
+	if opts.StatPort, err = cmd.Flags().GetUint16("stat-port"); err != nil {
+		return err
+	}
+
+	if err := setAPIPortOptions(cmd, &opts); err != nil {
+		return err
+	}
+
+	if opts.Interval, err = cmd.Flags().GetDuration("check-interval"); err != nil {
+		return err
+	}
+
+	if opts.ClusterConfigPath, err = cmd.Flags().GetString("cluster-config"); err != nil {
+		return err
+	}
+	if err := setFirewallOptions(cmd, &opts); err != nil {
+		return err
+	}
+
+	if err := setNodeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setProbeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setFaultOptions(cmd); err != nil {
+		return err
+	}
+	if err := setDriftOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setShutdownOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setTemplateOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSiteOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setMaintenanceOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setDrainOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setAPIReachabilityOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setAlertOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if opts.HAProxyControl, err = getServiceControlOptions(cmd, "haproxy"); err != nil {
+		return err
+	}
+	if err := watchLogLevels(cmd, args[0], "haproxy-monitor"); err != nil {
+		return err
+	}
+
+	if opts.Config.BackendSource, err = cmd.Flags().GetString("api-backend-source"); err != nil {
+		return err
+	}
+	if err := config.ValidateBackendSource(opts.Config.BackendSource); err != nil {
+		return err
+	}
+
+	if opts.MetricsAddress, err = cmd.Flags().GetString("metrics-address"); err != nil {
+		return err
+	}
+
+	return monitor.Monitor(opts)
+}
@@ -0,0 +1,182 @@
+package monitorcmd
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/monitor"
+)
+
+// NewKeepalivedCommand returns the keepalived monitor command named name.
+func NewKeepalivedCommand(name string) *cobra.Command {
+	defaults := monitor.DefaultOptions()
+	cmd := &cobra.Command{
+		Use:          name + " path_to_kubeconfig path_to_keepalived_cfg_template path_to_config",
+		Short:        "Monitors runtime external interface for keepalived and reloads if it changes",
+		SilenceUsage: true,
+		RunE:         runKeepalived,
+	}
+	cmd.PersistentFlags().StringP("cluster-config", "c", "", "Path to cluster-config ConfigMap to retrieve ControlPlane info")
+	cmd.Flags().Duration("check-interval", time.Second*10, "Time between keepalived watch checks")
+	addAPIVipFlags(cmd.Flags())
+	addIngressVipFlags(cmd.Flags())
+	cmd.PersistentFlags().IP("dns-vip", nil, "DEPRECATED: Virtual IP Address to reach an OpenShift node resolving DNS server")
+	cmd.Flags().Uint16("api-port", 6443, "Port where the OpenShift API listens")
+	cmd.Flags().Uint16("lb-port", 9445, "Port where the API HAProxy LB will listen")
+	cmd.Flags().String("metrics-address", "", "Address (e.g. :29446) where the VIP lease /metrics are served. Disabled when empty")
+	cmd.Flags().String("lease-status-file", defaults.LeaseStatusFile, "Path of the JSON file describing the leased VIPs. Disabled when empty")
+	cmd.Flags().String("dhcp-client", monitor.DHCPClientAuto, "Client leasing the VIPs with DHCP: auto, internal or dhclient. auto uses the internal client when it can open its packet socket, which needs CAP_NET_RAW, and dhclient otherwise")
+	cmd.Flags().Duration("vip-probe-timeout", 0, "How long to probe new VIPs with ARP/ND for hosts outside of the cluster already using them before claiming them. Disabled when zero")
+	cmd.Flags().String("keepalived-pid-file", defaults.KeepalivedPidFile, "Path of the keepalived pid file, used to check after each reload that keepalived runs the rendered configuration and to signal keepalived with --keepalived-control pidfile. Requires sharing the PID namespace of keepalived, disabled when empty")
+	addServiceControlFlags(cmd.Flags(), "keepalived", defaults.KeepalivedControl)
+	cmd.Flags().String("hooks-dir", defaults.HooksDir, "Directory whose master.d, backup.d and fault.d executables are run when a VIP enters the state, with the VIP in the RUNTIMECFG_VIP* environment variables. Disabled when empty")
+	cmd.Flags().String("keepalived-data-file", defaults.KeepalivedDataFile, "Path where the monitor reads the data dump keepalived writes on SIGUSR1")
+	addFirewallFlags(cmd.Flags())
+	addAPIPortFlags(cmd.Flags())
+	cmd.Flags().UintSlice("ingress-redirect-ports", nil, "Ingress VIP ports (e.g. 80,443,1936) redirected to the local node, for a node-local ingress LB or pod hairpin traffic. Disabled when empty")
+	cmd.Flags().IPNetSlice("unicast-peer-cidrs", nil, "CIDRs (e.g. the machine networks) the keepalived unicast peers must belong to. Every address is allowed when empty")
+	addBGPFlags(cmd.Flags())
+	addNodeFlags(cmd.Flags())
+	addProbeFlags(cmd.Flags())
+	addFaultFlags(cmd.Flags())
+	addDriftFlags(cmd.Flags())
+	addShutdownFlags(cmd.Flags())
+	addTemplateFlags(cmd.Flags())
+	addSiteFlags(cmd.Flags())
+	addSharedConfigFlags(cmd.Flags())
+	addPriorityFlags(cmd.Flags())
+	addMaintenanceFlags(cmd.Flags())
+	addVIPRouteFlags(cmd.Flags())
+	addAlertFlags(cmd.Flags())
+	cmd.Flags().Bool("ingress-shards", false, "Read the IngressControllers annotated with "+config.IngressShardVIPsAnnotation+" into the ingress shards of the config, each with its own VIPs and peers")
+	return cmd
+}
+
+func runKeepalived(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Help()
+		return nil
+	}
+	opts := monitor.DefaultOptions()
+	opts.KubeconfigPath, opts.TemplatePath, opts.CfgPath = args[0], args[1], args[2]
+	opts.APIVIPs, opts.IngressVIPs = getAPIVips(cmd), getIngressVips(cmd)
+	var err error
+	if opts.APIPort, err = cmd.Flags().GetUint16("api-port"); err != nil {
+		return err
+	}
+	if opts.LbPort, err = cmd.Flags().GetUint16("lb-port"); err != nil {
+		return err
+	}
+	if err := setAPIPortOptions(cmd, &opts); err != nil {
+		return err
+	}
+
+	if opts.Interval, err = cmd.Flags().GetDuration("check-interval"); err != nil {
+		return err
+	}
+	if opts.ClusterConfigPath, err = cmd.Flags().GetString("cluster-config"); err != nil {
+		return err
+	}
+
+	if opts.MetricsAddress, err = cmd.Flags().GetString("metrics-address"); err != nil {
+		return err
+	}
+	if opts.LeaseStatusFile, err = cmd.Flags().GetString("lease-status-file"); err != nil {
+		return err
+	}
+
+	if opts.VIPProbeTimeout, err = cmd.Flags().GetDuration("vip-probe-timeout"); err != nil {
+		return err
+	}
+
+	if opts.KeepalivedPidFile, err = cmd.Flags().GetString("keepalived-pid-file"); err != nil {
+		return err
+	}
+	if opts.KeepalivedDataFile, err = cmd.Flags().GetString("keepalived-data-file"); err != nil {
+		return err
+	}
+	if opts.HooksDir, err = cmd.Flags().GetString("hooks-dir"); err != nil {
+		return err
+	}
+	if opts.KeepalivedControl, err = getServiceControlOptions(cmd, "keepalived"); err != nil {
+		return err
+	}
+
+	if err := setFirewallOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if opts.DHCPClient, err = cmd.Flags().GetString("dhcp-client"); err != nil {
+		return err
+	}
+	if err := monitor.ValidateDHCPClient(opts.DHCPClient); err != nil {
+		return err
+	}
+
+	ingressPorts, err := cmd.Flags().GetUintSlice("ingress-redirect-ports")
+	if err != nil {
+		return err
+	}
+	for _, port := range ingressPorts {
+		if port == 0 || port > math.MaxUint16 {
+			return fmt.Errorf("Invalid ingress redirect port %d", port)
+		}
+		opts.IngressRedirectPorts = append(opts.IngressRedirectPorts, uint16(port))
+	}
+
+	if opts.UnicastPeerCIDRs, err = cmd.Flags().GetIPNetSlice("unicast-peer-cidrs"); err != nil {
+		return err
+	}
+
+	if err := setBGPOptions(cmd, &opts); err != nil {
+		return err
+	}
+
+	if err := setNodeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setProbeOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setFaultOptions(cmd); err != nil {
+		return err
+	}
+	if err := setDriftOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setShutdownOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setTemplateOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSiteOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setMaintenanceOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setVIPRouteOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setAlertOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setSharedConfigOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if err := setPriorityOptions(cmd, &opts); err != nil {
+		return err
+	}
+	if opts.GatherIngressShards, err = cmd.Flags().GetBool("ingress-shards"); err != nil {
+		return err
+	}
+	if err := watchLogLevels(cmd, args[0], "keepalived-monitor"); err != nil {
+		return err
+	}
+
+	return monitor.KeepalivedWatch(opts)
+}
@@ -0,0 +1,33 @@
+package monitorcmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/loggingconfig"
+)
+
+var log = logging.Logger("monitor.cmd")
+
+// monitorLogger is the logger of the monitor package, set with the key of
+// the component of the command rather than level-monitor
+const monitorLogger = "monitor"
+
+// watchLogLevels applies the levels of the logging ConfigMap until the
+// process exits: level-<component> sets the monitor logger, and the
+// level-<name> keys set the other loggers of the logging package
+func watchLogLevels(cmd *cobra.Command, kubeconfigPath, component string) error {
+	interval, err := cmd.Flags().GetDuration("log-level-interval")
+	if err != nil || interval <= 0 {
+		return err
+	}
+	components := map[string]string{component: monitorLogger}
+	for _, name := range logging.Names() {
+		if name != monitorLogger {
+			components[name] = name
+		}
+	}
+	w := loggingconfig.NewWatcher(kubeconfigPath, interval, components)
+	go w.Run(make(chan struct{}))
+	return nil
+}
@@ -0,0 +1,36 @@
+package monitorcmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/cmddoc"
+)
+
+// NewNetmonitorCommand returns the command named name that runs every
+// monitor as a subcommand.
+func NewNetmonitorCommand(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: "Runs the on-prem networking monitors (haproxy, keepalived, coredns, dnsmasq)",
+	}
+	cmd.AddCommand(
+		NewHAProxyCommand("haproxy"),
+		NewKeepalivedCommand("keepalived"),
+		NewCorednsCommand("coredns"),
+		NewDnsmasqCommand("dnsmasq"),
+		cmddoc.NewCompletionCommand(),
+	)
+	return cmd
+}
+
+// Binaries returns the root commands of the monitor binaries, named after
+// their binary.
+func Binaries() []*cobra.Command {
+	return []*cobra.Command{
+		NewHAProxyCommand("monitor"),
+		NewKeepalivedCommand("dynkeepalived"),
+		NewCorednsCommand("corednsmonitor"),
+		NewDnsmasqCommand("dnsmasqmonitor"),
+		NewNetmonitorCommand("netmonitor"),
+	}
+}
@@ -0,0 +1,159 @@
+// Package overrides turns the RuntimeNetConfig objects, which hold the
+// per-node overrides of the on-prem networking configuration, into the
+// per-node ConfigMaps read by the monitors.
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	Group    = "runtimecfg.openshift.io"
+	Version  = "v1alpha1"
+	Resource = "runtimenetconfigs"
+
+	// ManagedByLabel marks the ConfigMaps written by Sync, the ones it
+	// deletes once their RuntimeNetConfig is gone
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "runtimecfg"
+)
+
+var log = logging.Logger("overrides")
+
+// RuntimeNetConfig holds the overrides of a node. There is no generated
+// clientset for it, it is read as JSON through the REST client of the core
+// API.
+type RuntimeNetConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RuntimeNetConfigSpec `json:"spec"`
+}
+
+// RuntimeNetConfigSpec is the node the overrides apply to and the overrides
+type RuntimeNetConfigSpec struct {
+	NodeName             string `json:"nodeName"`
+	config.NodeOverrides `json:",inline"`
+}
+
+// RuntimeNetConfigList is a list of RuntimeNetConfig
+type RuntimeNetConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []RuntimeNetConfig `json:"items"`
+}
+
+// desiredConfigMaps returns the ConfigMaps of the nodes that have
+// overrides, by name. Invalid RuntimeNetConfigs are skipped, and when a
+// node has several the first one by name wins.
+func desiredConfigMaps(namespace string, list []RuntimeNetConfig) map[string]v1.ConfigMap {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	desired := map[string]v1.ConfigMap{}
+	owners := map[string]string{}
+	for _, rnc := range list {
+		fields := logrus.Fields{"runtimeNetConfig": rnc.Name, "node": rnc.Spec.NodeName}
+		if rnc.Spec.NodeName == "" {
+			log.WithFields(fields).Warn("Skipping RuntimeNetConfig without nodeName")
+			continue
+		}
+		if err := rnc.Spec.NodeOverrides.Validate(); err != nil {
+			log.WithFields(fields).WithError(err).Warn("Skipping invalid RuntimeNetConfig")
+			continue
+		}
+		if owner, ok := owners[rnc.Spec.NodeName]; ok {
+			log.WithFields(fields).WithField("used", owner).Warn("Skipping RuntimeNetConfig of a node that already has one")
+			continue
+		}
+		data, err := yaml.Marshal(rnc.Spec.NodeOverrides)
+		if err != nil {
+			log.WithFields(fields).WithError(err).Warn("Skipping RuntimeNetConfig")
+			continue
+		}
+		owners[rnc.Spec.NodeName] = rnc.Name
+		name := config.OverridesConfigMapName(rnc.Spec.NodeName)
+		desired[name] = v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{ManagedByLabel: managedBy},
+			},
+			Data: map[string]string{config.OverridesConfigMapKey: string(data)},
+		}
+	}
+	return desired
+}
+
+// Sync writes a ConfigMap with the overrides of every node that has a
+// RuntimeNetConfig in namespace, and deletes the ConfigMaps of the nodes
+// that no longer have one.
+func Sync(kubeconfigPath, namespace string) error {
+	clientConfig, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	raw, err := clientset.CoreV1().RESTClient().Get().
+		AbsPath("/apis", Group, Version, "namespaces", namespace, Resource).
+		DoRaw(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to list RuntimeNetConfigs: %w", err)
+	}
+	list := RuntimeNetConfigList{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+	desired := desiredConfigMaps(namespace, list.Items)
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	existing, err := configMaps.List(context.TODO(), metav1.ListOptions{LabelSelector: ManagedByLabel + "=" + managedBy})
+	if err != nil {
+		return err
+	}
+	for _, cm := range existing.Items {
+		want, ok := desired[cm.Name]
+		if !ok {
+			if err := configMaps.Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			log.WithFields(logrus.Fields{"configmap": cm.Name}).Info("Deleted node overrides")
+			continue
+		}
+		delete(desired, cm.Name)
+		if cmp.Equal(cm.Data, want.Data) {
+			continue
+		}
+		cm.Data = want.Data
+		if _, err := configMaps.Update(context.TODO(), &cm, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{"configmap": cm.Name}).Info("Updated node overrides")
+	}
+	for _, cm := range desired {
+		if _, err := configMaps.Create(context.TODO(), &cm, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{"configmap": cm.Name}).Info("Created node overrides")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package overrides
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+const list = `{
+  "apiVersion": "runtimecfg.openshift.io/v1alpha1",
+  "kind": "RuntimeNetConfigList",
+  "items": [
+    {"metadata": {"name": "master-0-b"}, "spec": {"nodeName": "master-0", "vrrpPriority": 70}},
+    {"metadata": {"name": "master-0-a"}, "spec": {"nodeName": "master-0", "interface": "ens4", "unicast": true}},
+    {"metadata": {"name": "master-1"}, "spec": {"nodeName": "master-1", "excludedCIDRs": ["not-a-cidr"]}},
+    {"metadata": {"name": "orphan"}, "spec": {"vrrpPriority": 10}}
+  ]
+}`
+
+var _ = Describe("desiredConfigMaps", func() {
+	It("keeps_one_valid_config_per_node", func() {
+		l := RuntimeNetConfigList{}
+		Expect(json.Unmarshal([]byte(list), &l)).To(Succeed())
+		Expect(l.Items[1].Spec.Interface).To(Equal("ens4"))
+
+		desired := desiredConfigMaps("openshift-kni-infra", l.Items)
+		Expect(desired).To(HaveLen(1))
+		cm := desired[config.OverridesConfigMapName("master-0")]
+		Expect(cm.Namespace).To(Equal("openshift-kni-infra"))
+		Expect(cm.Labels).To(HaveKeyWithValue(ManagedByLabel, managedBy))
+
+		o, err := config.ParseNodeOverrides(cm.Data[config.OverridesConfigMapKey])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.Interface).To(Equal("ens4"))
+		Expect(o.VRRPPriority).To(BeZero())
+		Expect(*o.Unicast).To(BeTrue())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Overrides tests")
+}
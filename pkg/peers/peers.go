@@ -0,0 +1,156 @@
+// Package peers lets the keepalived monitors agree on their unicast peers.
+// Each monitor announces its node addresses and whether it runs keepalived
+// in an annotation of its Node, and every monitor builds the same View of
+// the peers from the annotations of the nodes.
+package peers
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// Annotation holds the Announcement of the node
+const Annotation = "keepalived.onprem.openshift.io/peer"
+
+var log = logging.Logger("peers")
+
+// Announcement is what a monitor publishes about its node
+type Announcement struct {
+	Hostname string `json:"hostname"`
+	// Addresses are the non-VIP addresses of the node, one per VIP family
+	Addresses []string `json:"addresses"`
+	// VIPCandidate is set when keepalived runs on the node and may claim
+	// the VIPs
+	VIPCandidate bool `json:"vipCandidate"`
+	// Updated is refreshed on every publication, so that the announcement
+	// of a node whose monitor stopped becomes stale
+	Updated time.Time `json:"updated"`
+}
+
+// Announcer publishes the announcement of a node. The annotation is only
+// patched when the announcement changes or once per Heartbeat.
+type Announcer struct {
+	NodeName  string
+	Heartbeat time.Duration
+
+	last      *Announcement
+	published time.Time
+
+	// swapped out by the tests
+	patch func(value string) error
+	now   func() time.Time
+}
+
+// NewAnnouncer returns the announcer of nodeName, or nil when nodeName is
+// empty. A nil Announcer ignores the announcements.
+func NewAnnouncer(kubeconfigPath, nodeName string, heartbeat time.Duration) *Announcer {
+	if nodeName == "" {
+		return nil
+	}
+	return &Announcer{
+		NodeName:  nodeName,
+		Heartbeat: heartbeat,
+		patch: func(value string) error {
+			return utils.PatchNodeAnnotations(kubeconfigPath, nodeName, map[string]string{Annotation: value})
+		},
+		now: time.Now,
+	}
+}
+
+// Announce publishes the addresses and candidacy of the node. Failures are
+// logged and retried on the next call.
+func (a *Announcer) Announce(hostname string, addresses []string, candidate bool) {
+	if a == nil {
+		return
+	}
+	now := a.now()
+	sorted := append([]string{}, addresses...)
+	sort.Strings(sorted)
+	if a.last != nil && a.last.Hostname == hostname && a.last.VIPCandidate == candidate && reflect.DeepEqual(a.last.Addresses, sorted) && now.Sub(a.published) < a.Heartbeat {
+		return
+	}
+	announcement := Announcement{Hostname: hostname, Addresses: sorted, VIPCandidate: candidate, Updated: now.UTC().Truncate(time.Second)}
+	value, err := json.Marshal(announcement)
+	if err != nil {
+		return
+	}
+	if err := a.patch(string(value)); err != nil {
+		log.WithFields(logrus.Fields{
+			"node": a.NodeName,
+		}).WithError(err).Warn("Failed to announce the node to its peers")
+		return
+	}
+	a.last, a.published = &announcement, now
+}
+
+// View is the peers announced by the nodes
+type View struct {
+	// Peers are the fresh announcements of the VIP candidates, by node name
+	Peers map[string]Announcement
+	// Announced is set when any node announced itself, fresh or not. Until
+	// then the monitors predate the announcements and the view is unusable.
+	Announced bool
+}
+
+// NewView builds the view from the annotations of nodes. Announcements older
+// than staleAfter are left out.
+func NewView(nodes []v1.Node, now time.Time, staleAfter time.Duration) View {
+	view := View{Peers: map[string]Announcement{}}
+	for _, node := range nodes {
+		value, ok := node.Annotations[Annotation]
+		if !ok {
+			continue
+		}
+		view.Announced = true
+		var a Announcement
+		if err := json.Unmarshal([]byte(value), &a); err != nil {
+			log.WithFields(logrus.Fields{
+				"node": node.Name,
+			}).WithError(err).Warn("Ignoring invalid peer announcement")
+			continue
+		}
+		if !a.VIPCandidate || now.Sub(a.Updated) > staleAfter {
+			continue
+		}
+		view.Peers[node.Name] = a
+	}
+	return view
+}
+
+// hasAddress returns whether a peer other than self announced address
+func (v View) hasAddress(self, address string) bool {
+	for name, a := range v.Peers {
+		if name == self {
+			continue
+		}
+		for _, announced := range a.Addresses {
+			if announced == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadyFor returns whether the node self can apply a unicast configuration
+// with the peers at addresses: the node announced itself, and at least one
+// of the addresses belongs to another announced VIP candidate.
+func (v View) ReadyFor(self string, addresses []string) bool {
+	if _, ok := v.Peers[self]; !ok {
+		return false
+	}
+	for _, address := range addresses {
+		if v.hasAddress(self, address) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package peers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Announcer", func() {
+	It("announces_changes_and_heartbeats", func() {
+		patches := []string{}
+		clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		a := NewAnnouncer("kubeconfig", "master-0", time.Minute)
+		a.patch = func(value string) error {
+			patches = append(patches, value)
+			return nil
+		}
+		a.now = func() time.Time { return clock }
+
+		a.Announce("master-0", []string{"fd00::20", "192.168.111.20"}, true)
+		a.Announce("master-0", []string{"192.168.111.20", "fd00::20"}, true)
+		Expect(patches).To(HaveLen(1))
+		var announced Announcement
+		Expect(json.Unmarshal([]byte(patches[0]), &announced)).To(Succeed())
+		Expect(announced.Addresses).To(Equal([]string{"192.168.111.20", "fd00::20"}))
+
+		a.Announce("master-0", []string{"192.168.111.20", "fd00::20"}, false)
+		clock = clock.Add(time.Minute)
+		a.Announce("master-0", []string{"192.168.111.20", "fd00::20"}, false)
+		Expect(patches).To(HaveLen(3))
+	})
+})
+
+var _ = Describe("View", func() {
+	now := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+	node := func(name string, a *Announcement) v1.Node {
+		n := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if a != nil {
+			value, _ := json.Marshal(a)
+			n.Annotations[Annotation] = string(value)
+		}
+		return n
+	}
+	fresh := func(address string) *Announcement {
+		return &Announcement{Addresses: []string{address}, VIPCandidate: true, Updated: now.Add(-time.Minute)}
+	}
+
+	It("keeps_the_fresh_candidates", func() {
+		stale := fresh("192.168.111.22")
+		stale.Updated = now.Add(-time.Hour)
+		worker := fresh("192.168.111.30")
+		worker.VIPCandidate = false
+		view := NewView([]v1.Node{
+			node("master-0", fresh("192.168.111.20")),
+			node("master-1", fresh("192.168.111.21")),
+			node("master-2", stale),
+			node("worker-0", worker),
+			node("worker-1", nil),
+		}, now, 3*time.Minute)
+		Expect(view.Announced).To(BeTrue())
+		Expect(view.Peers).To(HaveLen(2))
+		Expect(view.ReadyFor("master-0", []string{"192.168.111.20", "192.168.111.21"})).To(BeTrue())
+		Expect(view.ReadyFor("master-0", []string{"192.168.111.20", "192.168.111.22"})).To(BeFalse())
+		Expect(view.ReadyFor("master-2", []string{"192.168.111.20", "192.168.111.21"})).To(BeFalse())
+	})
+
+	It("is_not_announced_without_annotations", func() {
+		Expect(NewView([]v1.Node{node("master-0", nil)}, now, time.Minute).Announced).To(BeFalse())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Peers tests")
+}
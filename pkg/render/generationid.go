@@ -0,0 +1,47 @@
+package render
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// generationMarker introduces the generation ID in the first line of a
+// rendered file
+const generationMarker = "runtimecfg-generation: "
+
+// GenerationID identifies rendered content, it is the same for the same
+// content on every node and across restarts of the monitors
+func GenerationID(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:6])
+}
+
+// stampGeneration prefixes content with a comment line holding its
+// generation ID
+func stampGeneration(comment string, content []byte) []byte {
+	line := comment + " " + generationMarker + GenerationID(content) + "\n"
+	return append([]byte(line), content...)
+}
+
+// ReadGenerationID returns the generation ID stamped in the first line of
+// path, empty when the file was rendered without one
+func ReadGenerationID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return "", scanner.Err()
+	}
+	line := scanner.Text()
+	i := strings.Index(line, generationMarker)
+	if i < 0 {
+		return "", nil
+	}
+	return strings.TrimSpace(line[i+len(generationMarker):]), nil
+}
@@ -0,0 +1,174 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func generationPath(renderPath string, n int) string {
+	return fmt.Sprintf("%s.%d", renderPath, n)
+}
+
+// shiftGenerations shifts the kept generations of renderPath, up to
+// <file>.<keep>, and links the current file as the latest one, unless
+// newPath renders the same content. The current file stays in place until
+// newPath is renamed over it, and a failure only costs the rollback.
+func shiftGenerations(renderPath, newPath string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	// An empty file, like a placeholder created before the first
+	// rendering, has nothing to roll back to
+	current, err := os.ReadFile(renderPath)
+	if err != nil || len(current) == 0 {
+		return
+	}
+	if rendered, err := os.ReadFile(newPath); err == nil && bytes.Equal(current, rendered) {
+		return
+	}
+
+	os.Remove(generationPath(renderPath, keep))
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(generationPath(renderPath, n), generationPath(renderPath, n+1)); err != nil && !os.IsNotExist(err) {
+			log.WithFields(logrus.Fields{
+				"path": generationPath(renderPath, n),
+			}).WithError(err).Warn("Failed to shift a previous generation")
+		}
+	}
+	latest := generationPath(renderPath, 1)
+	if err := os.Link(renderPath, latest); err != nil {
+		// Hard links are not available on every filesystem
+		fi, statErr := os.Stat(renderPath)
+		if statErr == nil {
+			err = os.WriteFile(latest, current, fi.Mode())
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"path": latest,
+			}).WithError(err).Warn("Failed to keep the previous generation")
+		}
+	}
+}
+
+// Generation is a previous version of a rendered file kept for a rollback
+type Generation struct {
+	// N is 1 for the latest previous version
+	N    int
+	Path string
+	// Time is when the version was rendered
+	Time time.Time
+	// ID is the generation ID stamped in the file, or else that of its
+	// content
+	ID string
+}
+
+// ListGenerations returns the previous versions of renderPath kept next to
+// it, the latest first
+func ListGenerations(renderPath string) ([]Generation, error) {
+	generations := []Generation{}
+	for n := 1; ; n++ {
+		path := generationPath(renderPath, n)
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return generations, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, err := ReadGenerationID(path)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			id = GenerationID(content)
+		}
+		generations = append(generations, Generation{N: n, Path: path, Time: fi.ModTime(), ID: id})
+	}
+}
+
+// Rollback replaces renderPath with its generation n, after validating it
+// as the file is validated when rendered. The replaced file is kept as the
+// latest generation, so a rollback can be undone with another one, and the
+// oldest generation is dropped. The mode and ownership of renderPath are
+// kept.
+func Rollback(renderPath string, n int) error {
+	generations, err := ListGenerations(renderPath)
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > len(generations) {
+		return fmt.Errorf("%s has no generation %d, %d are kept", renderPath, n, len(generations))
+	}
+	content, err := os.ReadFile(generations[n-1].Path)
+	if err != nil {
+		return err
+	}
+	if validate := ValidatorFor(renderPath); validate != nil {
+		if err := validate(content); err != nil {
+			return fmt.Errorf("Generation %d of %s is not valid: %w", n, renderPath, err)
+		}
+	}
+	fi, err := os.Stat(renderPath)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(renderPath), "."+filepath.Base(renderPath)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Chmod(fi.Mode())
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok && err == nil && (int(stat.Uid) != os.Getuid() || int(stat.Gid) != os.Getgid()) {
+		err = tmpFile.Chown(int(stat.Uid), int(stat.Gid))
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	shiftGenerations(renderPath, tmpFile.Name(), len(generations))
+	if err := os.Rename(tmpFile.Name(), renderPath); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	syncDir(filepath.Dir(renderPath))
+	log.WithFields(logrus.Fields{
+		"path":       renderPath,
+		"generation": n,
+		"id":         generations[n-1].ID,
+	}).Info("Rolled back rendered file")
+	return nil
+}
+
+// syncDir flushes the renames in dir to disk
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		log.WithFields(logrus.Fields{
+			"path": dir,
+		}).WithError(err).Debug("Failed to sync directory")
+	}
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTemplateOverrideDir is where the monitors look for user supplied
+// templates by default
+const DefaultTemplateOverrideDir = "/etc/kubernetes/runtimecfg-templates.d"
+
+// selectTemplate returns the override of f.TemplatePath in f.OverrideDir
+// when there is one that renders cfg into a valid f.RenderPath, and
+// f.TemplatePath otherwise. The validator defaults to the one of the file
+// type of f.RenderPath.
+func selectTemplate(f FileSpec, cfg interface{}) string {
+	templatePath := f.TemplatePath
+	if f.OverrideDir == "" {
+		return templatePath
+	}
+	overridePath := filepath.Join(f.OverrideDir, filepath.Base(templatePath))
+	if filepath.Clean(overridePath) == filepath.Clean(templatePath) {
+		return templatePath
+	}
+	if fi, err := os.Stat(overridePath); err != nil || !fi.Mode().IsRegular() {
+		return templatePath
+	}
+
+	fields := logrus.Fields{
+		"template": templatePath,
+		"override": overridePath,
+	}
+	tmpl, err := parseTemplate(overridePath, f.Strict)
+	if err != nil {
+		log.WithFields(fields).WithError(err).Warn("Failed to parse the template override, using the default template")
+		return templatePath
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, cfg); err != nil {
+		log.WithFields(fields).WithError(err).Warn("Failed to render the template override, using the default template")
+		return templatePath
+	}
+	if f.Strict && bytes.Contains(buf.Bytes(), []byte(noValue)) {
+		log.WithFields(fields).Warn("The template override printed a nil value, using the default template")
+		return templatePath
+	}
+	validate := f.Validate
+	if validate == nil {
+		validate = ValidatorFor(f.RenderPath)
+	}
+	if validate != nil {
+		if err := validate(buf.Bytes()); err != nil {
+			log.WithFields(fields).WithError(err).Warn("The template override rendered an invalid file, using the default template")
+			return templatePath
+		}
+	}
+	log.WithFields(fields).Debug("Using the template override")
+	return overridePath
+}
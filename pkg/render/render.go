@@ -2,119 +2,504 @@ package render
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/user"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/faults"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+	"github.com/openshift/baremetal-runtimecfg/pkg/tracing"
 )
 
 const ext = ".tmpl"
 
 var extLen = len(ext)
 
-var log = logrus.New()
+var log = logging.Logger("render")
+
+// noValue is what text/template prints for a nil value, which in strict mode
+// means the data lacks something the template uses
+const noValue = "<no value>"
+
+// parseTemplate parses templatePath. In strict mode executing the template
+// fails on missing map keys instead of printing them as empty values.
+func parseTemplate(templatePath string, strict bool) (*template.Template, error) {
+	tmpl := template.New(filepath.Base(templatePath))
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	return tmpl.ParseFiles(templatePath)
+}
+
+// Validator checks rendered content before it is put in place.
+type Validator func(content []byte) error
+
+// RenderFile renders f.TemplatePath into f.RenderPath through a temporary
+// file that is renamed into place, so that readers never see a partial file.
+// When f.Validate is set the rendered content is checked before it is
+// written, and the existing file is left untouched if validation fails.
+func RenderFile(f FileSpec, cfg interface{}) error {
+	defer tracing.Start("RenderFile").End()
+	return renderFiles([]FileSpec{f}, cfg)
+}
+
+// RenderContent renders and validates f in memory, for content that is not
+// written to a local file, e.g. the data of a ConfigMap. f.RenderPath only
+// names it in the logs.
+func RenderContent(f FileSpec, cfg interface{}) ([]byte, error) {
+	content, _, err := renderContent(f, cfg)
+	return content, err
+}
+
+// FileSpec describes one file rendered by RenderFile or RenderFiles.
+type FileSpec struct {
+	RenderPath   string
+	TemplatePath string
+	// Validate checks the rendered content before it is written, optional
+	Validate Validator
+	// Strict fails the rendering when the template uses data that is missing
+	Strict bool
+	// Mode of the rendered file, the mode of the template when 0
+	Mode os.FileMode
+	// Owner of the rendered file, the user running the rendering when nil
+	Owner *Owner
+	// GenerationComment starts a first line stamping the file with the
+	// GenerationID of the rest of its content, e.g. "#". No line is added
+	// when empty.
+	GenerationComment string
+	// OverrideDir holds templates that take precedence over TemplatePath: a
+	// template with the same file name is used instead, as long as it
+	// renders and the result validates. Disabled when empty.
+	OverrideDir string
+	// KeepGenerations is how many previous versions of the file are kept
+	// next to it for a rollback, as <file>.1 for the latest up to
+	// <file>.<KeepGenerations>, when it is rendered through a temporary
+	// file. Disabled when 0.
+	KeepGenerations int
+}
+
+// FileOptions are the settings the monitors render all their files with
+type FileOptions struct {
+	OverrideDir     string
+	KeepGenerations int
+	// SecretFiles maps the names of the Secrets given to the templates to
+	// the files their values are read from
+	SecretFiles map[string]string
+}
+
+// Apply returns f rendered with the override directory and kept
+// generations of o
+func (o FileOptions) Apply(f FileSpec) FileSpec {
+	f.OverrideDir = o.OverrideDir
+	f.KeepGenerations = o.KeepGenerations
+	return f
+}
+
+// Owner is the numeric owner of a rendered file. An ID of -1 is left
+// unchanged, as with os.Chown.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// RenderFiles renders and validates every file into a temporary file next to
+// its destination before replacing any of them, so either all destinations
+// are updated or none is. The files are then renamed into place one after
+// the other, which keeps the window where they disagree to a few renames.
+func RenderFiles(files []FileSpec, cfg interface{}) error {
+	defer tracing.Start("RenderFiles").End()
+	return renderFiles(files, cfg)
+}
+
+func renderFiles(files []FileSpec, cfg interface{}) error {
+	tmpPaths := make([]string, 0, len(files))
+	defer func() {
+		// Only leftovers from a failed transaction still exist here
+		for _, tmpPath := range tmpPaths {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	for _, f := range files {
+		tmpPath, err := renderToTemp(f, cfg)
+		if err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+	for i, f := range files {
+		shiftGenerations(f.RenderPath, tmpPaths[i], f.KeepGenerations)
+		if err := os.Rename(tmpPaths[i], f.RenderPath); err != nil {
+			log.WithFields(logrus.Fields{
+				"path": f.RenderPath,
+			}).WithError(err).Error("Failed to move rendered file into place")
+			return err
+		}
+	}
+	for _, f := range files {
+		syncDir(filepath.Dir(f.RenderPath))
+	}
+	return nil
+}
 
-func RenderFile(renderPath, templatePath string, cfg interface{}) error {
-	tmpl, err := template.ParseFiles(templatePath)
+// renderContent renders and validates a single file in memory and returns
+// its content and the mode it is written with.
+func renderContent(f FileSpec, cfg interface{}) ([]byte, os.FileMode, error) {
+	faults.DelayRender()
+	selectedPath := selectTemplate(f, cfg)
+	tmpl, err := parseTemplate(selectedPath, f.Strict)
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"path": templatePath,
+			"path": selectedPath,
 		}).Error("Failed to parse template")
-		return err
+		return nil, 0, err
+	}
+	mode := f.Mode
+	if mode == 0 {
+		templateStat, err := os.Stat(f.TemplatePath)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"path": f.TemplatePath,
+			}).Error("Failed to stat template")
+			return nil, 0, err
+		}
+		mode = templateStat.Mode()
 	}
 
-	renderFile, err := os.Create(renderPath)
-	if err != nil {
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, cfg); err != nil {
 		log.WithFields(logrus.Fields{
-			"path": renderPath,
-		}).Error("Failed to create file")
-		return err
+			"path": f.RenderPath,
+		}).Error("Failed to render template")
+		return nil, 0, err
+	}
+	if f.Strict && bytes.Contains(buf.Bytes(), []byte(noValue)) {
+		err = fmt.Errorf("template printed a nil value as %q", noValue)
+		log.WithFields(logrus.Fields{
+			"path": f.RenderPath,
+		}).WithError(err).Error("Failed to render template")
+		return nil, 0, err
+	}
+	if containsSecret(cfg, buf.Bytes()) {
+		mode = SecretFileMode
+	}
+	if f.GenerationComment != "" {
+		buf = bytes.NewBuffer(stampGeneration(f.GenerationComment, buf.Bytes()))
+	}
+	if f.Validate != nil {
+		if err = f.Validate(buf.Bytes()); err != nil {
+			log.WithFields(logrus.Fields{
+				"path": f.RenderPath,
+			}).WithError(err).Error("Rendered file failed validation, keeping the current one")
+			return nil, 0, err
+		}
+	}
+	return buf.Bytes(), mode, nil
+}
+
+// setOwner changes the owner of the open file when f asks for one
+func setOwner(file *os.File, f FileSpec) error {
+	if f.Owner == nil {
+		return nil
+	}
+	return file.Chown(f.Owner.UID, f.Owner.GID)
+}
+
+func logRendered(renderPath string, content []byte) {
+	log.WithFields(logrus.Fields{
+		"path": renderPath,
+	}).Info("Runtimecfg rendering template")
+	for _, line := range strings.Split(string(content), "\n") {
+		log.Info(line)
 	}
-	defer renderFile.Close()
+}
 
-	// Make sure we propagate any special permissions
-	templateStat, err := os.Stat(templatePath)
+// renderToTemp renders and validates a single file into a temporary file in
+// the destination directory and returns its path.
+func renderToTemp(f FileSpec, cfg interface{}) (string, error) {
+	content, mode, err := renderContent(f, cfg)
 	if err != nil {
-		log.WithFields(logrus.Fields{
-			"path": templatePath,
-		}).Error("Failed to stat template")
-		return err
+		return "", err
 	}
-	err = os.Chmod(renderPath, templateStat.Mode())
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(f.RenderPath), "."+filepath.Base(f.RenderPath)+".")
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"path": renderPath,
-		}).Error("Failed to set permissions on file")
+			"path": f.RenderPath,
+		}).Error("Failed to create temporary file")
+		return "", err
+	}
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Chmod(mode)
+	}
+	if err == nil {
+		err = setOwner(tmpFile, f)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	logRendered(f.RenderPath, redactSecrets(cfg, content))
+	return tmpFile.Name(), nil
+}
+
+// writeFile renders and validates a single file and writes it in place. A
+// failed write can leave a partial file behind.
+func writeFile(f FileSpec, cfg interface{}) error {
+	content, mode, err := renderContent(f, cfg)
+	if err != nil {
 		return err
 	}
 
-	buf := &bytes.Buffer{}
-	err = tmpl.Execute(buf, cfg)
+	file, err := os.OpenFile(f.RenderPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"path": renderPath,
-		}).Error("Failed to render template")
+			"path": f.RenderPath,
+		}).Error("Failed to create file")
 		return err
 	}
-	// The string we get back is a single line with \n's. For readability,
-	// split it and write it line-by-line.
-	lines := strings.Split(buf.String(), "\n")
-	for _, line := range lines {
-		log.Info(line)
+	_, err = file.Write(content)
+	if err == nil {
+		// The mode given to OpenFile is masked and ignored for existing files
+		err = file.Chmod(mode)
+	}
+	if err == nil {
+		err = setOwner(file, f)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
 	}
 
-	log.WithFields(logrus.Fields{
-		"path": renderPath,
-	}).Info("Runtimecfg rendering template")
-	return tmpl.Execute(renderFile, cfg)
+	logRendered(f.RenderPath, redactSecrets(cfg, content))
+	return nil
+}
+
+// Options tune how Render writes the templates
+type Options struct {
+	// Strict fails on templates that use data that is missing and validates
+	// every file whose type ValidatorFor knows
+	Strict bool
+	// Include and Exclude are globs matched against the names of the
+	// rendered files. With Include only the matching templates are rendered,
+	// and the ones matching Exclude are skipped.
+	Include []string
+	Exclude []string
+	// Mode of the rendered files, the mode of their template when 0
+	Mode os.FileMode
+	// Owner of the rendered files, the user running the rendering when nil
+	Owner *Owner
+	// Atomic renders each file into a temporary file renamed over the
+	// destination, so that readers never see a partial file
+	Atomic bool
 }
 
+// Render renders the templates of paths into outDir with the default Options
 func Render(outDir string, paths []string, cfg interface{}) error {
-	tempPaths := paths
-	if len(paths) == 1 {
-		fi, err := os.Stat(paths[0])
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"path": paths[0],
-			}).Error("Failed to stat file")
-		}
-		if fi.Mode().IsDir() {
-			templateDir := paths[0]
-			files, err := ioutil.ReadDir(templateDir)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"path": templateDir,
-				}).Error("Failed to read template directory")
-				return err
-			}
-			tempPaths = make([]string, 0)
-			for _, entryFi := range files {
-				if entryFi.Mode().IsRegular() {
-					if path.Ext(entryFi.Name()) == ext {
-						tempPaths = append(tempPaths, path.Join(templateDir, entryFi.Name()))
-					}
-				}
-			}
-		}
+	return RenderWithOptions(outDir, paths, cfg, Options{})
+}
+
+// RenderWithOptions renders the templates of paths into outDir. If there is a
+// single path and it is a directory, the .tmpl files in it are rendered.
+// Every template is attempted and all the failures are returned, so that CI
+// reports every template that drifted from the data at once.
+func RenderWithOptions(outDir string, paths []string, cfg interface{}, opts Options) error {
+	templatePaths, err := listTemplates(paths)
+	if err != nil {
+		return err
 	}
-	for _, templatePath := range tempPaths {
+	for _, templatePath := range templatePaths {
 		if path.Ext(templatePath) != ext {
 			return fmt.Errorf("Template %s does not have the right extension. Must be '%s'", templatePath, ext)
 		}
+	}
 
+	var errs []error
+	for _, templatePath := range templatePaths {
 		baseName := path.Base(templatePath)
-		renderPath := path.Join(outDir, baseName[:len(baseName)-extLen])
-		err := RenderFile(renderPath, templatePath, cfg)
+		renderName := baseName[:len(baseName)-extLen]
+		selected, err := opts.selects(renderName)
+		if err != nil {
+			return err
+		}
+		if !selected {
+			log.WithFields(logrus.Fields{
+				"path": templatePath,
+			}).Info("Skipping filtered out template")
+			continue
+		}
+
+		f := FileSpec{
+			RenderPath:   path.Join(outDir, renderName),
+			TemplatePath: templatePath,
+			Strict:       opts.Strict,
+			Mode:         opts.Mode,
+			Owner:        opts.Owner,
+		}
+		if opts.Strict {
+			f.Validate = ValidatorFor(f.RenderPath)
+		}
+		if opts.Atomic {
+			err = RenderFiles([]FileSpec{f}, cfg)
+		} else {
+			err = writeFile(f, cfg)
+		}
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"path": templatePath,
 				"err":  err,
 			}).Error("Failed to render template")
+			errs = append(errs, fmt.Errorf("%s: %w", templatePath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listTemplates returns the templates of paths, the .tmpl files of the
+// directory when paths is a single directory
+func listTemplates(paths []string) ([]string, error) {
+	if len(paths) != 1 {
+		return paths, nil
+	}
+	fi, err := os.Stat(paths[0])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path": paths[0],
+		}).Error("Failed to stat file")
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return paths, nil
+	}
+
+	templateDir := paths[0]
+	entries, err := os.ReadDir(templateDir)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path": templateDir,
+		}).Error("Failed to read template directory")
+		return nil, err
+	}
+	templatePaths := make([]string, 0)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && path.Ext(entry.Name()) == ext {
+			templatePaths = append(templatePaths, path.Join(templateDir, entry.Name()))
+		}
+	}
+	return templatePaths, nil
+}
+
+// selects reports whether the file named name passes the Include and
+// Exclude globs
+func (o Options) selects(name string) (bool, error) {
+	included := len(o.Include) == 0
+	for _, pattern := range o.Include {
+		match, err := filepath.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("Invalid include pattern %q: %w", pattern, err)
+		}
+		included = included || match
+	}
+	if !included {
+		return false, nil
+	}
+	for _, pattern := range o.Exclude {
+		match, err := filepath.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("Invalid exclude pattern %q: %w", pattern, err)
+		}
+		if match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ParseOwner parses an owner given as user[:group], by name or numeric ID
+func ParseOwner(spec string) (*Owner, error) {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+	owner := &Owner{UID: -1, GID: -1}
+	if userName != "" {
+		uid, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return nil, err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return nil, err
+			}
+		}
+		owner.UID = uid
+	}
+	if hasGroup && groupName != "" {
+		gid, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return nil, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return nil, err
+			}
+		}
+		owner.GID = gid
+	}
+	if owner.UID == -1 && owner.GID == -1 {
+		return nil, fmt.Errorf("Invalid owner %q", spec)
+	}
+	return owner, nil
+}
+
+// Watch renders the templates of paths into outDir every time the config
+// returned by getConfig changes, until done is closed. getConfig is polled
+// every interval and also whenever trigger receives, so that callers can
+// react to events instead of waiting for the next poll. A failure to get the
+// config or to render keeps the previous files and is retried on the next
+// poll.
+func Watch(outDir string, paths []string, opts Options, getConfig func() (interface{}, error), interval time.Duration, trigger <-chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prevConfig interface{}
+	for {
+		cfg, err := getConfig()
+		if err != nil {
+			log.WithError(err).Error("Failed to get the config, keeping the rendered files")
+		} else if !reflect.DeepEqual(cfg, prevConfig) {
+			log.Info("Config changed, rendering the templates")
+			if err := RenderWithOptions(outDir, paths, cfg, opts); err != nil {
+				log.WithError(err).Error("Failed to render the templates, retrying on the next poll")
+			} else {
+				prevConfig = cfg
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		case <-trigger:
 		}
 	}
-	return nil
 }
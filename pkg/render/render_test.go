@@ -0,0 +1,592 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateCorefile", func() {
+	It("accepts a valid Corefile", func() {
+		Expect(ValidateCorefile([]byte(`. {
+    errors
+    forward . 10.0.0.1 10.0.0.2
+    hosts /etc/coredns/api-int.hosts example.com {
+        192.168.1.5 api-int.example.com
+        fallthrough
+    }
+}
+corp.example.com {
+    forward . tls://10.0.0.53 {
+        tls_servername dns.example.com
+    }
+}
+`))).To(Succeed())
+	})
+
+	It("rejects a forward without upstreams", func() {
+		Expect(ValidateCorefile([]byte(". {\n    forward .\n}\n"))).ToNot(Succeed())
+		Expect(ValidateCorefile([]byte(". {\n    forward\n}\n"))).ToNot(Succeed())
+		Expect(ValidateCorefile([]byte("forward ."))).ToNot(Succeed())
+	})
+
+	It("rejects unbalanced braces", func() {
+		Expect(ValidateCorefile([]byte(". {\n    errors\n"))).ToNot(Succeed())
+		Expect(ValidateCorefile([]byte(". {\n}\n}\n"))).ToNot(Succeed())
+	})
+
+	It("rejects an empty file", func() {
+		Expect(ValidateCorefile([]byte("\n"))).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("RenderFile", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("keeps the existing file when validation fails", func() {
+		tmplPath := filepath.Join(dir, "Corefile.tmpl")
+		outPath := filepath.Join(dir, "Corefile")
+		Expect(os.WriteFile(tmplPath, []byte(". {\n    forward .{{range .}} {{.}}{{end}}\n}\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(outPath, []byte("old"), 0644)).To(Succeed())
+
+		Expect(RenderFile(FileSpec{RenderPath: outPath, TemplatePath: tmplPath, Validate: ValidateCorefile}, []string{})).ToNot(Succeed())
+		content, err := os.ReadFile(outPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal("old"))
+
+		Expect(RenderFile(FileSpec{RenderPath: outPath, TemplatePath: tmplPath, Validate: ValidateCorefile}, []string{"10.0.0.1"})).To(Succeed())
+		content, err = os.ReadFile(outPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal(". {\n    forward . 10.0.0.1\n}\n"))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("RenderFiles", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("updates no file when one of them fails", func() {
+		goodTmpl := filepath.Join(dir, "good.tmpl")
+		badTmpl := filepath.Join(dir, "bad.tmpl")
+		goodOut := filepath.Join(dir, "good")
+		badOut := filepath.Join(dir, "bad")
+		Expect(os.WriteFile(goodTmpl, []byte("{{.}}"), 0644)).To(Succeed())
+		Expect(os.WriteFile(badTmpl, []byte("{{.Missing}}"), 0644)).To(Succeed())
+		Expect(os.WriteFile(goodOut, []byte("old"), 0644)).To(Succeed())
+
+		err := RenderFiles([]FileSpec{
+			{RenderPath: goodOut, TemplatePath: goodTmpl},
+			{RenderPath: badOut, TemplatePath: badTmpl},
+		}, "new")
+		Expect(err).Should(HaveOccurred())
+
+		content, err := os.ReadFile(goodOut)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal("old"))
+		_, err = os.Stat(badOut)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("updates every file on success", func() {
+		for _, name := range []string{"a", "b"} {
+			Expect(os.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(name+"={{.}}"), 0644)).To(Succeed())
+		}
+		Expect(RenderFiles([]FileSpec{
+			{RenderPath: filepath.Join(dir, "a"), TemplatePath: filepath.Join(dir, "a.tmpl")},
+			{RenderPath: filepath.Join(dir, "b"), TemplatePath: filepath.Join(dir, "b.tmpl")},
+		}, "x")).To(Succeed())
+		for _, name := range []string{"a", "b"} {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).To(Equal(name + "=x"))
+		}
+		entries, err := os.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).To(HaveLen(4))
+	})
+})
+
+type secretConfig struct {
+	User    string
+	Secrets Secrets
+}
+
+func (c secretConfig) RenderSecrets() Secrets {
+	return c.Secrets
+}
+
+var _ = Describe("Secrets", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reads the secret files", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0600)).To(Succeed())
+		files := map[string]string{"stats_password": filepath.Join(dir, "password")}
+		secrets, err := ReadSecrets(files)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(secrets).To(Equal(Secrets{"stats_password": "s3cret"}))
+		Expect(fmt.Sprintf("%v", secretConfig{Secrets: secrets})).NotTo(ContainSubstring("s3cret"))
+
+		files["vrrp_auth"] = filepath.Join(dir, "missing")
+		_, err = ReadSecrets(files)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("only lets the owner read the files holding a secret", func() {
+		tmpl := filepath.Join(dir, "haproxy.cfg.tmpl")
+		Expect(os.WriteFile(tmpl, []byte("stats auth {{.User}}:{{.Secrets.stats_password}}\n"), 0644)).To(Succeed())
+		out := filepath.Join(dir, "haproxy.cfg")
+
+		Expect(RenderFile(FileSpec{RenderPath: out, TemplatePath: tmpl}, secretConfig{User: "admin", Secrets: Secrets{"stats_password": "s3cret"}})).To(Succeed())
+		content, err := os.ReadFile(out)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal("stats auth admin:s3cret\n"))
+		fi, err := os.Stat(out)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(SecretFileMode))
+		Expect(string(redactSecrets(secretConfig{Secrets: Secrets{"stats_password": "s3cret"}}, content))).To(Equal("stats auth admin:<redacted>\n"))
+
+		By("keeping the template mode without a secret", func() {
+			Expect(RenderFile(FileSpec{RenderPath: out, TemplatePath: tmpl}, secretConfig{User: "admin", Secrets: Secrets{"stats_password": ""}})).To(Succeed())
+			fi, err := os.Stat(out)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0644)))
+		})
+	})
+})
+
+var _ = Describe("ValidateKeepalivedConf", func() {
+	valid := `vrrp_script chk_ocp {
+    script "curl -o /dev/null -kLs https://0:6443/readyz"
+    interval 1
+}
+
+vrrp_instance ostest_API {
+    state BACKUP
+    interface eth0
+    virtual_router_id 42
+    authentication {
+        auth_type PASS
+    }
+    virtual_ipaddress {
+        192.168.111.5/24 label vip
+    }
+}
+`
+
+	It("accepts a valid configuration", func() {
+		Expect(ValidateKeepalivedConf([]byte(valid))).To(Succeed())
+	})
+
+	It("rejects an instance without interface", func() {
+		Expect(ValidateKeepalivedConf([]byte(strings.Replace(valid, "interface eth0", "interface", 1)))).ToNot(Succeed())
+	})
+
+	It("rejects an invalid virtual_router_id", func() {
+		Expect(ValidateKeepalivedConf([]byte(strings.Replace(valid, "virtual_router_id 42", "virtual_router_id 0", 1)))).ToNot(Succeed())
+	})
+
+	It("rejects an empty virtual address", func() {
+		Expect(ValidateKeepalivedConf([]byte(strings.Replace(valid, "192.168.111.5/24", "/24", 1)))).ToNot(Succeed())
+	})
+
+	It("rejects unbalanced braces", func() {
+		Expect(ValidateKeepalivedConf([]byte(valid + "}\n"))).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("ValidateHAProxyConfig", func() {
+	It("accepts a valid configuration", func() {
+		Expect(ValidateHAProxyConfig([]byte(`frontend main
+  bind :::9445 v4v6
+  default_backend masters
+backend masters
+   server master-0 192.168.111.20:6443 weight 1
+`))).To(Succeed())
+	})
+
+	It("rejects a bind without port", func() {
+		Expect(ValidateHAProxyConfig([]byte("frontend main\n  bind 127.0.0.1:\n"))).ToNot(Succeed())
+	})
+
+	It("rejects a server without address", func() {
+		Expect(ValidateHAProxyConfig([]byte("frontend main\n  bind :9445\nbackend masters\n  server master-0 :6443\n"))).ToNot(Succeed())
+	})
+
+	It("rejects a configuration without frontend", func() {
+		Expect(ValidateHAProxyConfig([]byte("defaults\n  mode tcp\n"))).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("ValidateDnsmasqConf", func() {
+	It("accepts valid hosts", func() {
+		Expect(ValidateDnsmasqConf([]byte("# hosts\nbind-interfaces\ndhcp-host=52:54:00:aa:bb:cc,192.168.111.20,master-0\n"))).To(Succeed())
+	})
+
+	It("rejects options without value", func() {
+		Expect(ValidateDnsmasqConf([]byte("listen-address=\n"))).ToNot(Succeed())
+	})
+
+	It("rejects empty fields", func() {
+		Expect(ValidateDnsmasqConf([]byte("dhcp-host=52:54:00:aa:bb:cc,,master-0\n"))).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("RenderWithOptions", func() {
+	var dir, outDir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+		outDir = filepath.Join(dir, "out")
+		Expect(os.Mkdir(outDir, 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("fails on missing map keys", func() {
+		tmplPath := filepath.Join(dir, "a.tmpl")
+		Expect(os.WriteFile(tmplPath, []byte("a={{.missing}}"), 0644)).To(Succeed())
+		data := map[string]string{"present": "x"}
+
+		Expect(Render(outDir, []string{tmplPath}, data)).To(Succeed())
+		Expect(RenderWithOptions(outDir, []string{tmplPath}, data, Options{Strict: true})).ToNot(Succeed())
+	})
+
+	It("fails on nil values", func() {
+		tmplPath := filepath.Join(dir, "a.tmpl")
+		Expect(os.WriteFile(tmplPath, []byte("a={{.Value}}"), 0644)).To(Succeed())
+
+		Expect(RenderWithOptions(outDir, []string{tmplPath}, struct{ Value interface{} }{}, Options{Strict: true})).ToNot(Succeed())
+	})
+
+	It("validates the known file types and renders the others", func() {
+		badPath := filepath.Join(dir, "haproxy.cfg.tmpl")
+		goodPath := filepath.Join(dir, "other.tmpl")
+		Expect(os.WriteFile(badPath, []byte("frontend main\n  bind :{{.}}\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(goodPath, []byte("{{.}}"), 0644)).To(Succeed())
+
+		Expect(RenderWithOptions(outDir, []string{badPath, goodPath}, "", Options{Strict: true})).ToNot(Succeed())
+		_, err := os.Stat(filepath.Join(outDir, "haproxy.cfg"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, err = os.Stat(filepath.Join(outDir, "other"))
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("renders the filtered templates of a directory", func() {
+		tmplDir := filepath.Join(dir, "templates")
+		Expect(os.Mkdir(tmplDir, 0755)).To(Succeed())
+		for _, name := range []string{"a.conf.tmpl", "b.conf.tmpl", "c.cfg.tmpl", "d.txt"} {
+			Expect(os.WriteFile(filepath.Join(tmplDir, name), []byte("{{.}}"), 0644)).To(Succeed())
+		}
+
+		Expect(RenderWithOptions(outDir, []string{tmplDir}, "x", Options{
+			Include: []string{"*.conf", "*.cfg"},
+			Exclude: []string{"b.*"},
+		})).To(Succeed())
+		entries, err := os.ReadDir(outDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		Expect(names).To(ConsistOf("a.conf", "c.cfg"))
+	})
+
+	It("sets the mode of the rendered files", func() {
+		tmplPath := filepath.Join(dir, "a.tmpl")
+		Expect(os.WriteFile(tmplPath, []byte("{{.}}"), 0644)).To(Succeed())
+
+		for _, atomic := range []bool{false, true} {
+			Expect(RenderWithOptions(outDir, []string{tmplPath}, "x", Options{Mode: 0600, Atomic: atomic})).To(Succeed())
+			fi, err := os.Stat(filepath.Join(outDir, "a"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		}
+	})
+
+	It("returns the failures", func() {
+		Expect(Render(outDir, []string{filepath.Join(dir, "missing.tmpl")}, "x")).ToNot(Succeed())
+
+		tmplPath := filepath.Join(dir, "a.tmpl")
+		Expect(os.WriteFile(tmplPath, []byte("{{.Missing}}"), 0644)).To(Succeed())
+		Expect(Render(outDir, []string{tmplPath}, "x")).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Watch", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("renders again when the config changes", func() {
+		tmplPath := filepath.Join(dir, "a.tmpl")
+		outPath := filepath.Join(dir, "a")
+		Expect(os.WriteFile(tmplPath, []byte("{{.}}"), 0644)).To(Succeed())
+
+		configs := make(chan string, 1)
+		configs <- "first"
+		current := ""
+		getConfig := func() (interface{}, error) {
+			select {
+			case current = <-configs:
+			default:
+			}
+			return current, nil
+		}
+		trigger := make(chan struct{})
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			Watch(dir, []string{tmplPath}, Options{}, getConfig, time.Hour, trigger, done)
+			close(stopped)
+		}()
+
+		readOut := func() string {
+			content, _ := os.ReadFile(outPath)
+			return string(content)
+		}
+		Eventually(readOut).Should(Equal("first"))
+		configs <- "second"
+		trigger <- struct{}{}
+		Eventually(readOut).Should(Equal("second"))
+
+		close(done)
+		Eventually(stopped).Should(BeClosed())
+	})
+})
+
+var _ = Describe("ParseOwner", func() {
+	It("parses numeric IDs", func() {
+		Expect(ParseOwner("1000:2000")).To(Equal(&Owner{UID: 1000, GID: 2000}))
+		Expect(ParseOwner("1000")).To(Equal(&Owner{UID: 1000, GID: -1}))
+		Expect(ParseOwner(":2000")).To(Equal(&Owner{UID: -1, GID: 2000}))
+	})
+
+	It("looks up names", func() {
+		Expect(ParseOwner("root:root")).To(Equal(&Owner{UID: 0, GID: 0}))
+	})
+
+	It("rejects empty owners", func() {
+		_, err := ParseOwner(":")
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("OverrideDir", func() {
+	var dir, overrideDir, tmplPath, renderPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+		overrideDir = filepath.Join(dir, "overrides")
+		Expect(os.Mkdir(overrideDir, 0755)).To(Succeed())
+		tmplPath = filepath.Join(dir, "Corefile.tmpl")
+		renderPath = filepath.Join(dir, "Corefile")
+		Expect(os.WriteFile(tmplPath, []byte(". {\n    forward . {{.}}\n}\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("prefers a valid override", func() {
+		Expect(os.WriteFile(filepath.Join(overrideDir, "Corefile.tmpl"), []byte(". {\n    cache\n    forward . {{.}}\n}\n"), 0644)).To(Succeed())
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, OverrideDir: overrideDir, Validate: ValidateCorefile}, "10.0.0.1")).To(Succeed())
+		content, err := os.ReadFile(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring("cache"))
+
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, OverrideDir: overrideDir}, "10.0.0.2")).To(Succeed())
+		content, err = os.ReadFile(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal(". {\n    cache\n    forward . 10.0.0.2\n}\n"))
+	})
+
+	It("falls back to the default template when the override does not validate", func() {
+		Expect(os.WriteFile(filepath.Join(overrideDir, "Corefile.tmpl"), []byte(". {\n    forward .\n"), 0644)).To(Succeed())
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, OverrideDir: overrideDir}, "10.0.0.1")).To(Succeed())
+		content, err := os.ReadFile(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal(". {\n    forward . 10.0.0.1\n}\n"))
+	})
+
+	It("falls back to the default template when the override does not parse", func() {
+		Expect(os.WriteFile(filepath.Join(overrideDir, "Corefile.tmpl"), []byte("{{.Missing"), 0644)).To(Succeed())
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, OverrideDir: overrideDir, Validate: ValidateCorefile}, "10.0.0.1")).To(Succeed())
+		content, err := os.ReadFile(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal(". {\n    forward . 10.0.0.1\n}\n"))
+	})
+})
+
+var _ = Describe("KeepGenerations", func() {
+	var dir, tmplPath, renderPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+		tmplPath = filepath.Join(dir, "haproxy.cfg.tmpl")
+		renderPath = filepath.Join(dir, "haproxy.cfg")
+		Expect(os.WriteFile(tmplPath, []byte("server {{.}}\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	read := func(path string) string {
+		content, err := os.ReadFile(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(content)
+	}
+
+	It("keeps the previous versions of a changed file", func() {
+		for _, server := range []string{"a", "b", "b", "c", "d"} {
+			Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, KeepGenerations: 2}, server)).To(Succeed())
+		}
+		Expect(read(renderPath)).To(Equal("server d\n"))
+		Expect(read(renderPath + ".1")).To(Equal("server c\n"))
+		Expect(read(renderPath + ".2")).To(Equal("server b\n"))
+		_, err := os.Stat(renderPath + ".3")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("rolls back to a previous generation", func() {
+		// A file type without a validator
+		renderPath := filepath.Join(dir, "lb.cfg")
+		for _, server := range []string{"a", "b", "c"} {
+			Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, KeepGenerations: 2}, server)).To(Succeed())
+		}
+		Expect(os.Chmod(renderPath, 0640)).To(Succeed())
+		generations, err := ListGenerations(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(generations).To(HaveLen(2))
+		Expect(generations[0].N).To(Equal(1))
+		Expect(generations[0].ID).To(Equal(GenerationID([]byte("server b\n"))))
+		Expect(generations[1].Path).To(Equal(renderPath + ".2"))
+
+		Expect(Rollback(renderPath, 3)).NotTo(Succeed())
+		Expect(Rollback(renderPath, 2)).To(Succeed())
+		Expect(read(renderPath)).To(Equal("server a\n"))
+		Expect(read(renderPath + ".1")).To(Equal("server c\n"))
+		Expect(read(renderPath + ".2")).To(Equal("server b\n"))
+		fi, err := os.Stat(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0640)))
+
+		By("undoing the rollback", func() {
+			Expect(Rollback(renderPath, 1)).To(Succeed())
+			Expect(read(renderPath)).To(Equal("server c\n"))
+			Expect(read(renderPath + ".1")).To(Equal("server a\n"))
+		})
+	})
+
+	It("does not roll back to an invalid generation", func() {
+		Expect(os.WriteFile(renderPath, []byte("global\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(renderPath+".1", []byte("frontend main\n  bind :abc\n"), 0644)).To(Succeed())
+		Expect(Rollback(renderPath, 1)).To(MatchError(ContainSubstring("is not valid")))
+		Expect(read(renderPath)).To(Equal("global\n"))
+	})
+
+	It("leaves no temporary file behind", func() {
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath, KeepGenerations: 2}, "a")).To(Succeed())
+		entries, err := os.ReadDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		Expect(names).To(ConsistOf("haproxy.cfg", "haproxy.cfg.tmpl"))
+	})
+})
+
+var _ = Describe("GenerationComment", func() {
+	var dir, tmplPath, renderPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "render")
+		Expect(err).ShouldNot(HaveOccurred())
+		tmplPath = filepath.Join(dir, "keepalived.conf.tmpl")
+		renderPath = filepath.Join(dir, "keepalived.conf")
+		Expect(os.WriteFile(tmplPath, []byte("vrrp_instance {{.}} {\n}\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("stamps the generation of the content in the first line", func() {
+		Expect(RenderFiles([]FileSpec{{RenderPath: renderPath, TemplatePath: tmplPath, GenerationComment: "#"}}, "api")).To(Succeed())
+		content, err := os.ReadFile(renderPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		generation := GenerationID([]byte("vrrp_instance api {\n}\n"))
+		Expect(string(content)).To(Equal("# runtimecfg-generation: " + generation + "\nvrrp_instance api {\n}\n"))
+		Expect(ReadGenerationID(renderPath)).To(Equal(generation))
+
+		Expect(RenderFiles([]FileSpec{{RenderPath: renderPath, TemplatePath: tmplPath, GenerationComment: "#"}}, "ingress")).To(Succeed())
+		Expect(ReadGenerationID(renderPath)).NotTo(Equal(generation))
+	})
+
+	It("reads no generation from files rendered without one", func() {
+		Expect(RenderFile(FileSpec{RenderPath: renderPath, TemplatePath: tmplPath}, "api")).To(Succeed())
+		Expect(ReadGenerationID(renderPath)).To(BeEmpty())
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Render tests")
+}
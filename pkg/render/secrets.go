@@ -0,0 +1,88 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SecretFileMode is the mode of the rendered files holding a secret
+const SecretFileMode os.FileMode = 0600
+
+// redacted replaces the secrets in the logged content of the rendered files
+const redacted = "<redacted>"
+
+// Secrets are the values of the secret files by name, used by the templates as
+// e.g. {{ .Secrets.stats_password }}. Printing them only shows their names.
+type Secrets map[string]string
+
+func (s Secrets) String() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name+":"+redacted)
+	}
+	sort.Strings(names)
+	return "map[" + strings.Join(names, " ") + "]"
+}
+
+// ReadSecrets reads the values of files, which maps the names of Secrets to
+// the files their values are read from, e.g. the keys of a mounted Secret,
+// so they never show up in the command line. They are read on every render
+// so that a rotated Secret is rendered.
+func ReadSecrets(files map[string]string) (Secrets, error) {
+	secrets := Secrets{}
+	for name, path := range files {
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read secret %s: %w", name, err)
+		}
+		secrets[name] = strings.TrimRight(string(value), "\r\n")
+	}
+	return secrets, nil
+}
+
+// SecretHolder is a render context carrying Secrets. The files rendered from
+// it that contain one of them are written with SecretFileMode and logged
+// without them.
+type SecretHolder interface {
+	RenderSecrets() Secrets
+}
+
+// secretsOf returns the non empty values of the Secrets of cfg
+func secretsOf(cfg interface{}) []string {
+	holder, ok := cfg.(SecretHolder)
+	if !ok {
+		return nil
+	}
+	values := []string{}
+	for _, value := range holder.RenderSecrets() {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	// Longer values first, so a secret containing another one is fully
+	// redacted
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// containsSecret returns whether content holds one of the Secrets of cfg
+func containsSecret(cfg interface{}, content []byte) bool {
+	for _, value := range secretsOf(cfg) {
+		if bytes.Contains(content, []byte(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets returns content with the Secrets of cfg replaced
+func redactSecrets(cfg interface{}, content []byte) []byte {
+	for _, value := range secretsOf(cfg) {
+		content = bytes.ReplaceAll(content, []byte(value), []byte(redacted))
+	}
+	return content
+}
@@ -0,0 +1,266 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ValidatorFor returns the validator of the file type of renderPath, or nil
+// when there is none for it.
+func ValidatorFor(renderPath string) Validator {
+	base := filepath.Base(renderPath)
+	switch {
+	case base == "keepalived.conf":
+		return ValidateKeepalivedConf
+	case base == "haproxy.cfg":
+		return ValidateHAProxyConfig
+	case base == "Corefile":
+		return ValidateCorefile
+	case strings.HasPrefix(base, "dnsmasq"):
+		return ValidateDnsmasqConf
+	}
+	return nil
+}
+
+// ValidateCorefile performs a sanity check of a rendered CoreDNS Corefile: it
+// must contain at least one server block, braces must be balanced and every
+// forward directive needs at least one upstream. This does not replace the
+// CoreDNS parser but catches the failure modes we have seen from empty or
+// partially rendered data.
+func ValidateCorefile(content []byte) error {
+	depth := 0
+	serverBlocks := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if depth == 1 && fields[0] == "forward" {
+			// forward FROM TO... [{]
+			if len(fields) < 3 {
+				return fmt.Errorf("line %d: forward directive without upstreams", lineNum)
+			}
+			upstreams := 0
+			for _, f := range fields[2:] {
+				if f != "{" {
+					upstreams++
+				}
+			}
+			if upstreams == 0 {
+				return fmt.Errorf("line %d: forward directive without upstreams", lineNum)
+			}
+		}
+		for _, c := range line {
+			switch c {
+			case '{':
+				if depth == 0 {
+					serverBlocks++
+				}
+				depth++
+			case '}':
+				depth--
+				if depth < 0 {
+					return fmt.Errorf("line %d: unexpected closing brace", lineNum)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced braces, %d block(s) not closed", depth)
+	}
+	if serverBlocks == 0 {
+		return fmt.Errorf("no server blocks found")
+	}
+	return nil
+}
+
+// ValidateKeepalivedConf performs a sanity check of a rendered keepalived
+// configuration: braces must be balanced and every vrrp_instance needs an
+// interface, a virtual_router_id between 1 and 255 and addresses in its
+// virtual_ipaddress block.
+func ValidateKeepalivedConf(content []byte) error {
+	// blocks holds the names of the blocks enclosing the current line
+	blocks := []string{}
+	instance := ""
+	var hasInterface, hasRouterID bool
+	vips := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#!"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "}" {
+			if len(blocks) == 0 {
+				return fmt.Errorf("line %d: unexpected closing brace", lineNum)
+			}
+			closed := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			if closed != "vrrp_instance" {
+				continue
+			}
+			switch {
+			case !hasInterface:
+				return fmt.Errorf("vrrp_instance %s has no interface", instance)
+			case !hasRouterID:
+				return fmt.Errorf("vrrp_instance %s has no virtual_router_id", instance)
+			case vips == 0:
+				return fmt.Errorf("vrrp_instance %s has no virtual_ipaddress", instance)
+			}
+			continue
+		}
+		if fields[len(fields)-1] == "{" {
+			if fields[0] == "vrrp_instance" {
+				if len(fields) < 3 {
+					return fmt.Errorf("line %d: vrrp_instance without a name", lineNum)
+				}
+				instance = fields[1]
+				hasInterface, hasRouterID, vips = false, false, 0
+			}
+			blocks = append(blocks, fields[0])
+			continue
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		switch blocks[len(blocks)-1] {
+		case "vrrp_instance":
+			switch fields[0] {
+			case "interface":
+				if len(fields) < 2 {
+					return fmt.Errorf("line %d: interface without a name", lineNum)
+				}
+				hasInterface = true
+			case "virtual_router_id":
+				if len(fields) < 2 {
+					return fmt.Errorf("line %d: virtual_router_id without a value", lineNum)
+				}
+				id, err := strconv.Atoi(fields[1])
+				if err != nil || id < 1 || id > 255 {
+					return fmt.Errorf("line %d: invalid virtual_router_id %q", lineNum, fields[1])
+				}
+				hasRouterID = true
+			}
+		case "virtual_ipaddress":
+			addr := strings.SplitN(fields[0], "/", 2)
+			if net.ParseIP(addr[0]) == nil {
+				return fmt.Errorf("line %d: invalid virtual address %q", lineNum, fields[0])
+			}
+			if len(addr) == 2 {
+				if _, err := strconv.Atoi(addr[1]); err != nil {
+					return fmt.Errorf("line %d: invalid virtual address %q", lineNum, fields[0])
+				}
+			}
+			vips++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(blocks) != 0 {
+		return fmt.Errorf("unbalanced braces, %d block(s) not closed", len(blocks))
+	}
+	return nil
+}
+
+// ValidateHAProxyConfig performs a sanity check of a rendered HAProxy
+// configuration: it needs a frontend or listen section, every bind needs an
+// address with a port and every server an address with a port.
+func ValidateHAProxyConfig(content []byte) error {
+	proxies := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "frontend", "listen":
+			proxies++
+		case "bind":
+			if len(fields) < 2 || !hasHostPort(fields[1], true) {
+				return fmt.Errorf("line %d: bind without a port", lineNum)
+			}
+		case "server":
+			if len(fields) < 3 || !hasHostPort(fields[2], false) {
+				return fmt.Errorf("line %d: server without an address and port", lineNum)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if proxies == 0 {
+		return fmt.Errorf("no frontend or listen section found")
+	}
+	return nil
+}
+
+// hasHostPort reports whether addr is host:port with a numeric port. The
+// host can be empty, meaning every address, when emptyHost is set.
+func hasHostPort(addr string, emptyHost bool) bool {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return false
+	}
+	if _, err := strconv.ParseUint(addr[i+1:], 10, 16); err != nil {
+		return false
+	}
+	return emptyHost || i > 0
+}
+
+// ValidateDnsmasqConf performs a sanity check of rendered dnsmasq
+// configuration and host files: options given with an = need a value and no
+// field of a comma separated value, such as dhcp-host=MAC,IP,Hostname, can be
+// empty.
+func ValidateDnsmasqConf(content []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value := line
+		if i := strings.Index(line, "="); i >= 0 {
+			value = line[i+1:]
+			if value == "" {
+				return fmt.Errorf("line %d: %s without a value", lineNum, line[:i])
+			}
+		}
+		for _, field := range strings.Split(value, ",") {
+			if strings.TrimSpace(field) == "" {
+				return fmt.Errorf("line %d: empty field in %q", lineNum, line)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,268 @@
+// Package snapshot gathers the runtime configuration of a node and what it
+// depends on into a directory, for must-gather and bug reports.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
+
+// ErrorsFile lists what could not be gathered, a snapshot is best effort
+const ErrorsFile = "errors.txt"
+
+var log = logging.Logger("snapshot")
+
+// DefaultFiles are the rendered configurations and the state files of the
+// monitors
+var DefaultFiles = []string{
+	"/etc/keepalived/keepalived.conf",
+	"/etc/haproxy/haproxy.cfg",
+	"/etc/coredns/Corefile",
+	"/etc/keepalived/monitor.conf*",
+	"/etc/keepalived/monitor-user.conf*",
+	"/var/run/keepalived/*",
+}
+
+// Options select what Take gathers
+type Options struct {
+	// Files are globs of the files copied under files/ with their path
+	Files []string
+	// Config and ConfigErr are what config.GetConfig returned
+	Config    config.Node
+	ConfigErr error
+	// Redact replaces the values of the config that identify the site
+	Redact bool
+}
+
+// command is the output of a program saved to a file, only the lines that
+// keep returns true for when keep is set
+type command struct {
+	file string
+	argv []string
+	keep func(line string) bool
+}
+
+// ocpFirewallLine keeps the tables and the chains and rules of the API and
+// ingress redirects, which are all named or commented OCP
+func ocpFirewallLine(line string) bool {
+	return strings.HasPrefix(line, "*") || line == "COMMIT" || strings.Contains(line, "OCP")
+}
+
+var commands = []command{
+	{"firewall/iptables.txt", []string{"iptables-save"}, ocpFirewallLine},
+	{"firewall/ip6tables.txt", []string{"ip6tables-save"}, ocpFirewallLine},
+	{"firewall/nft-ip-ocp_api_lb.txt", []string{"nft", "list", "table", "ip", "ocp_api_lb"}, nil},
+	{"firewall/nft-ip6-ocp_api_lb.txt", []string{"nft", "list", "table", "ip6", "ocp_api_lb"}, nil},
+	{"firewall/nft-ip-ocp_ingress_lb.txt", []string{"nft", "list", "table", "ip", "ocp_ingress_lb"}, nil},
+	{"firewall/nft-ip6-ocp_ingress_lb.txt", []string{"nft", "list", "table", "ip6", "ocp_ingress_lb"}, nil},
+}
+
+// swapped out by the tests
+var (
+	runCommand = func(argv []string) ([]byte, error) {
+		return exec.Command(argv[0], argv[1:]...).Output()
+	}
+	// listAddrs returns the addresses by link name
+	listAddrs = func() (map[string][]netlink.Addr, error) {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return nil, err
+		}
+		addrs := map[string][]netlink.Addr{}
+		for _, link := range links {
+			linkAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+			if err != nil {
+				return nil, err
+			}
+			addrs[link.Attrs().Name] = linkAddrs
+		}
+		return addrs, nil
+	}
+	listRoutes = func() ([]netlink.Route, error) {
+		return netlink.RouteList(nil, netlink.FAMILY_ALL)
+	}
+	linkName = func(index int) string {
+		if link, err := netlink.LinkByIndex(index); err == nil {
+			return link.Attrs().Name
+		}
+		return fmt.Sprintf("if%d", index)
+	}
+)
+
+// snapshot collects the errors of the gathering in dir
+type snapshot struct {
+	dir    string
+	errors []string
+}
+
+func (s *snapshot) failed(what string, err error) {
+	log.WithFields(logrus.Fields{
+		"what": what,
+	}).WithError(err).Warn("Failed to gather for the snapshot")
+	s.errors = append(s.errors, fmt.Sprintf("%s: %v", what, err))
+}
+
+func (s *snapshot) write(name string, data []byte) {
+	path := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		s.failed(name, err)
+		return
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		s.failed(name, err)
+	}
+}
+
+func (s *snapshot) copyFiles(globs []string) {
+	for _, glob := range globs {
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			s.failed(glob, err)
+			continue
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				s.failed(path, err)
+				continue
+			}
+			s.write(filepath.Join("files", path), data)
+		}
+	}
+}
+
+func (s *snapshot) writeConfig(node config.Node, cfgErr error, redact bool) {
+	if cfgErr != nil {
+		s.failed("config", cfgErr)
+		return
+	}
+	data, err := json.MarshalIndent(node.ForDisplay(redact), "", "  ")
+	if err != nil {
+		s.failed("config", err)
+		return
+	}
+	s.write("config.json", data)
+}
+
+func (s *snapshot) writeNetlink() {
+	addrs, err := listAddrs()
+	if err != nil {
+		s.failed("addresses", err)
+	} else {
+		lines := []string{}
+		for name, linkAddrs := range addrs {
+			for _, addr := range linkAddrs {
+				lines = append(lines, fmt.Sprintf("%s %s flags=%d", name, addr.IPNet, addr.Flags))
+			}
+		}
+		sort.Strings(lines)
+		s.write("network/addresses.txt", []byte(strings.Join(lines, "\n")+"\n"))
+	}
+	routes, err := listRoutes()
+	if err != nil {
+		s.failed("routes", err)
+	} else {
+		lines := []string{}
+		for _, route := range routes {
+			lines = append(lines, fmt.Sprintf("%s %s", linkName(route.LinkIndex), route))
+		}
+		s.write("network/routes.txt", []byte(strings.Join(lines, "\n")+"\n"))
+	}
+}
+
+func (s *snapshot) runCommands() {
+	for _, c := range commands {
+		out, err := runCommand(c.argv)
+		if err != nil {
+			s.failed(strings.Join(c.argv, " "), err)
+			continue
+		}
+		if c.keep != nil {
+			kept := []string{}
+			for _, line := range strings.Split(string(out), "\n") {
+				if c.keep(line) {
+					kept = append(kept, line)
+				}
+			}
+			out = []byte(strings.Join(kept, "\n") + "\n")
+		}
+		s.write(c.file, out)
+	}
+}
+
+// Take gathers the snapshot into dir, which is created if needed. What
+// cannot be gathered is listed in ErrorsFile instead of failing the
+// snapshot, only a dir that cannot be created is an error.
+func Take(dir string, opts Options) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s := &snapshot{dir: dir}
+	s.copyFiles(opts.Files)
+	s.writeConfig(opts.Config, opts.ConfigErr, opts.Redact)
+	s.writeNetlink()
+	s.runCommands()
+	if len(s.errors) > 0 {
+		s.write(ErrorsFile, []byte(strings.Join(s.errors, "\n")+"\n"))
+	}
+	return nil
+}
+
+// Archive writes dir as a gzipped tarball to w, with the paths relative to
+// the parent of dir
+func Archive(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	base := filepath.Dir(filepath.Clean(dir))
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(base, path); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
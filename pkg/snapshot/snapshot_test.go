@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+const iptablesSave = `*nat
+:PREROUTING ACCEPT [0:0]
+:OCP-API-LB-PREROUTING - [0:0]
+-A PREROUTING -m comment --comment OCP_API_LB_REDIRECT -j OCP-API-LB-PREROUTING
+-A PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES
+COMMIT`
+
+var _ = Describe("snapshot", func() {
+	var (
+		origRunCommand = runCommand
+		origListAddrs  = listAddrs
+		origListRoutes = listRoutes
+		origLinkName   = linkName
+	)
+	var dir, src string
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "snapshot")
+		Expect(err).NotTo(HaveOccurred())
+		src, err = ioutil.TempDir("", "snapshot-src")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(src, "keepalived.conf"), []byte("vrrp_instance"), 0644)).To(Succeed())
+
+		runCommand = func(argv []string) ([]byte, error) {
+			if argv[0] == "iptables-save" {
+				return []byte(iptablesSave), nil
+			}
+			return nil, errors.New("not found")
+		}
+		_, ipnet, _ := net.ParseCIDR("192.168.111.0/24")
+		listAddrs = func() (map[string][]netlink.Addr, error) {
+			return map[string][]netlink.Addr{"eth0": {{IPNet: ipnet}}}, nil
+		}
+		listRoutes = func() ([]netlink.Route, error) { return nil, errors.New("permission denied") }
+		linkName = func(int) string { return "eth0" }
+	})
+
+	AfterEach(func() {
+		runCommand = origRunCommand
+		listAddrs = origListAddrs
+		listRoutes = origListRoutes
+		linkName = origLinkName
+		os.RemoveAll(dir)
+		os.RemoveAll(src)
+	})
+
+	It("gathers what it can and lists the failures", func() {
+		node := config.Node{Cluster: config.Cluster{Name: "ostest", Domain: "ostest.example.com"}}
+		Expect(Take(dir, Options{Files: []string{filepath.Join(src, "*")}, Config: node, Redact: true})).To(Succeed())
+
+		Expect(read(filepath.Join("files", src, "keepalived.conf"))).To(Equal("vrrp_instance"))
+		Expect(read("config.json")).To(ContainSubstring(`"Name": "ostest"`))
+		Expect(read("config.json")).NotTo(ContainSubstring("example.com"))
+		Expect(read("network/addresses.txt")).To(Equal("eth0 192.168.111.0/24 flags=0\n"))
+		Expect(read("firewall/iptables.txt")).To(Equal(`*nat
+:OCP-API-LB-PREROUTING - [0:0]
+-A PREROUTING -m comment --comment OCP_API_LB_REDIRECT -j OCP-API-LB-PREROUTING
+COMMIT
+`))
+		errs := read(ErrorsFile)
+		Expect(errs).To(ContainSubstring("routes: permission denied"))
+		Expect(errs).To(ContainSubstring("ip6tables-save: not found"))
+	})
+
+	It("records a config failure", func() {
+		Expect(Take(dir, Options{ConfigErr: errors.New("no kubeconfig")})).To(Succeed())
+		_, err := os.Stat(filepath.Join(dir, "config.json"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(read(ErrorsFile)).To(ContainSubstring("config: no kubeconfig"))
+	})
+
+	It("archives the directory", func() {
+		Expect(Take(dir, Options{Files: []string{filepath.Join(src, "*")}})).To(Succeed())
+		buf := &bytes.Buffer{}
+		Expect(Archive(dir, buf)).To(Succeed())
+
+		gz, err := gzip.NewReader(buf)
+		Expect(err).NotTo(HaveOccurred())
+		tr := tar.NewReader(gz)
+		names := []string{}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, header.Name)
+		}
+		base := filepath.Base(dir)
+		Expect(names).To(ContainElement(base + "/network/addresses.txt"))
+		Expect(names).To(ContainElement(filepath.Join(base, "files", src, "keepalived.conf")))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Snapshot tests")
+}
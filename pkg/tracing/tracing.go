@@ -0,0 +1,88 @@
+// Package tracing times the phases of the configuration computation, so that
+// a slow API server or netlink call delaying a failover shows up in the logs
+// and in the metrics.
+//
+// A Span is started for an operation and a child span for each of its
+// phases. Every span is observed in a histogram, and the operation is logged
+// with the duration of its phases when it ends: at debug level, or at warning
+// level when it took longer than SlowThreshold.
+package tracing
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
+
+var log = logging.Logger("tracing")
+
+// SlowThreshold is the duration above which an operation is logged as slow
+var SlowThreshold = 2 * time.Second
+
+var (
+	spanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "baremetal_runtimecfg_span_duration_seconds",
+		Help:    "Duration of the configuration computation operations and of their phases",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"span"})
+
+	// now is swapped out by the tests
+	now = time.Now
+)
+
+func init() {
+	prometheus.MustRegister(spanDuration)
+}
+
+// Span times an operation or one of its phases. The methods of a nil Span
+// do nothing, so functions can take an optional parent.
+type Span struct {
+	name   string
+	start  time.Time
+	parent *Span
+	phases logrus.Fields
+}
+
+// Start starts the span of the operation called name
+func Start(name string) *Span {
+	return &Span{name: name, start: now(), phases: logrus.Fields{}}
+}
+
+// Phase starts the span of a phase of s, called after s and name. It
+// starts an operation when s is nil.
+func (s *Span) Phase(name string) *Span {
+	if s == nil {
+		return Start(name)
+	}
+	return &Span{name: s.name + "/" + name, start: now(), parent: s, phases: logrus.Fields{}}
+}
+
+// End ends the span and returns its duration. The duration of a phase is
+// added to its operation, the operation is logged.
+func (s *Span) End() time.Duration {
+	if s == nil {
+		return 0
+	}
+	d := now().Sub(s.start)
+	spanDuration.WithLabelValues(s.name).Observe(d.Seconds())
+	if s.parent != nil {
+		key := s.name[len(s.parent.name)+1:]
+		// A phase run several times is reported with its total duration
+		total, _ := s.parent.phases[key].(time.Duration)
+		s.parent.phases[key] = total + d
+		return d
+	}
+	entry := log.WithFields(s.phases).WithFields(logrus.Fields{
+		"span":     s.name,
+		"duration": d,
+	})
+	if d > SlowThreshold {
+		entry.Warn("Slow configuration operation")
+	} else {
+		entry.Debug("Configuration operation timing")
+	}
+	return d
+}
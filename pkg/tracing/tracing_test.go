@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("Span", func() {
+	var hook *test.Hook
+	var clock time.Time
+
+	BeforeEach(func() {
+		hook = test.NewLocal(log)
+		log.SetLevel(logrus.DebugLevel)
+		clock = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		now = func() time.Time { return clock }
+	})
+
+	AfterEach(func() {
+		now = time.Now
+	})
+
+	It("reports_the_phases_with_the_operation", func() {
+		span := Start("GetConfig")
+		for i := 0; i < 2; i++ {
+			phase := span.Phase("vrrp")
+			clock = clock.Add(300 * time.Millisecond)
+			Expect(phase.End()).To(Equal(300 * time.Millisecond))
+		}
+		clock = clock.Add(2 * time.Second)
+		Expect(span.End()).To(Equal(2600 * time.Millisecond))
+
+		entry := hook.LastEntry()
+		Expect(entry.Level).To(Equal(logrus.WarnLevel))
+		Expect(entry.Data["span"]).To(Equal("GetConfig"))
+		Expect(entry.Data["vrrp"]).To(Equal(600 * time.Millisecond))
+	})
+
+	It("ignores_nil_spans", func() {
+		var span *Span
+		Expect(span.End()).To(BeZero())
+		phase := span.Phase("render")
+		Expect(phase.End()).To(BeZero())
+		Expect(hook.LastEntry().Level).To(Equal(logrus.DebugLevel))
+		Expect(hook.LastEntry().Data["span"]).To(Equal("render"))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracing tests")
+}
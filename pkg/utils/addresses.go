@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/faults"
 )
 
 // AddressFilter is a function type to filter addresses
@@ -17,10 +21,73 @@ type AddressFilter func(netlink.Addr) bool
 // RouteFilter is a function type to filter routes
 type RouteFilter func(netlink.Route) bool
 
+// driverPrefix marks the exclusion patterns matching the driver of a device
+// instead of its name
+const driverPrefix = "driver:"
+
+// DefaultExcludedDevices never carry an address of the node: the OVN
+// management port and geneve tunnels, and the host end of the pod
+// interfaces
+var DefaultExcludedDevices = []string{"ovn-k8s-mp0", "genev_sys_*", "veth*", "cali*"}
+
+// ValidateExcludedDevices checks the glob patterns of the devices whose
+// addresses are ignored on top of DefaultExcludedDevices. A pattern prefixed
+// with "driver:" matches the driver of the device, e.g. driver:iavf for the
+// Intel SR-IOV VFs.
+func ValidateExcludedDevices(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(strings.TrimPrefix(pattern, driverPrefix), ""); err != nil {
+			return fmt.Errorf("Invalid excluded device pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// deviceDriver returns the driver of the device name, empty for the virtual
+// devices. It is swapped out by the tests.
+var deviceDriver = func(name string) string {
+	driver, err := os.Readlink(filepath.Join("/sys/class/net", name, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(driver)
+}
+
+// excludedDevice returns the pattern of DefaultExcludedDevices or
+// excludedDevices excluding the device name, empty when its addresses are
+// used
+func excludedDevice(excludedDevices []string, name string) string {
+	driver := ""
+	for _, pattern := range append(append([]string{}, DefaultExcludedDevices...), excludedDevices...) {
+		if driverPattern := strings.TrimPrefix(pattern, driverPrefix); driverPattern != pattern {
+			if driver == "" {
+				driver = deviceDriver(name)
+			}
+			if matched, _ := filepath.Match(driverPattern, driver); matched && driver != "" {
+				return pattern
+			}
+		} else if matched, _ := filepath.Match(pattern, name); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
 type addressMapFunc func(filter AddressFilter) (map[netlink.Link][]netlink.Addr, error)
 type routeMapFunc func(filter RouteFilter) (map[int][]netlink.Route, error)
 
-func getAddrs(filter AddressFilter) (addrMap map[netlink.Link][]netlink.Addr, err error) {
+// linkAddrs returns the addresses of the links of the node but the
+// excludedDevices
+func linkAddrs(excludedDevices []string) addressMapFunc {
+	return func(filter AddressFilter) (map[netlink.Link][]netlink.Addr, error) {
+		return getAddrs(excludedDevices, filter)
+	}
+}
+
+func getAddrs(excludedDevices []string, filter AddressFilter) (addrMap map[netlink.Link][]netlink.Addr, err error) {
+	if err := faults.NetlinkError("address list"); err != nil {
+		return nil, err
+	}
 	nlHandle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
 	if err != nil {
 		return nil, err
@@ -34,6 +101,10 @@ func getAddrs(filter AddressFilter) (addrMap map[netlink.Link][]netlink.Addr, er
 
 	addrMap = make(map[netlink.Link][]netlink.Addr)
 	for _, link := range links {
+		if pattern := excludedDevice(excludedDevices, link.Attrs().Name); pattern != "" {
+			log.Debugf("Ignoring the addresses of %s excluded by %s", link.Attrs().Name, pattern)
+			continue
+		}
 		addresses, err := nlHandle.AddrList(link, netlink.FAMILY_ALL)
 		if err != nil {
 			return nil, err
@@ -56,6 +127,9 @@ func getAddrs(filter AddressFilter) (addrMap map[netlink.Link][]netlink.Addr, er
 }
 
 func getRouteMap(filter RouteFilter) (routeMap map[int][]netlink.Route, err error) {
+	if err := faults.NetlinkError("route list"); err != nil {
+		return nil, err
+	}
 	nlHandle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
 	if err != nil {
 		return nil, err
@@ -105,14 +179,40 @@ func ValidNodeAddress(address netlink.Addr) bool {
 	return true
 }
 
+// DefaultOVNInternalSubnets returns the default OVN-Kubernetes masquerade
+// subnets of both families, whose addresses (e.g. 169.254.169.2 and fd69::2)
+// OVN assigns to the gateway bridge of the node
+func DefaultOVNInternalSubnets() []string {
+	return []string{"169.254.169.0/29", "fd69::/125"}
+}
+
+// OVNNodeAddressFilter returns a filter of the addresses suitable for a
+// node's primary IP that are not within one of the OVN internal subnets
+// cidrs
+func OVNNodeAddressFilter(cidrs []string) (AddressFilter, error) {
+	subnets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, subnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid OVN internal subnet %q: %w", cidr, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return func(address netlink.Addr) bool {
+		for _, subnet := range subnets {
+			if subnet.Contains(address.IP) {
+				return false
+			}
+		}
+		return ValidNodeAddress(address)
+	}, nil
+}
+
 // ValidOVNNodeAddress returns true if the address is suitable for a node's primary IP
-// and is not fd69::2
-// we intentionally don't filter ipv4 because it isn't necessary.
+// and is not within one of the DefaultOVNInternalSubnets
 func ValidOVNNodeAddress(address netlink.Addr) bool {
-	if IsIPv6(address.IP) && address.IP.String() == "fd69::2" {
-		return false
-	}
-	return ValidNodeAddress(address)
+	filter, _ := OVNNodeAddressFilter(DefaultOVNInternalSubnets())
+	return filter(address)
 }
 
 // usableIPv6Route returns true if the passed route is acceptable for AddressesRouting
@@ -133,9 +233,9 @@ func usableIPv6Route(route netlink.Route) bool {
 	return true
 }
 
-// AddressesRouting takes a slice of Virtual IPs and returns a configured address in the current network namespace that directly routes to at least one of those vips. If the interface containing that address is dual-stack, it will also return a single address of the opposite IP family. You can optionally pass an AddressFilter to further filter down which addresses are considered
-func AddressesRouting(vips []net.IP, af AddressFilter, preferIPv6 bool) ([]net.IP, error) {
-	return addressesRoutingInternal(vips, af, getAddrs, getRouteMap, preferIPv6)
+// AddressesRouting takes a slice of Virtual IPs and returns a configured address in the current network namespace that directly routes to at least one of those vips. If the interface containing that address is dual-stack, it will also return a single address of the opposite IP family. You can optionally pass an AddressFilter to further filter down which addresses are considered. The addresses of the excludedDevices are never considered.
+func AddressesRouting(vips []net.IP, af AddressFilter, preferIPv6 bool, excludedDevices []string) ([]net.IP, error) {
+	return addressesRoutingInternal(vips, af, linkAddrs(excludedDevices), getRouteMap, preferIPv6)
 }
 
 func addressesRoutingInternal(vips []net.IP, af AddressFilter, getAddrs addressMapFunc, getRouteMap routeMapFunc, preferIPv6 bool) ([]net.IP, error) {
@@ -223,9 +323,9 @@ func defaultRoute(route netlink.Route) bool {
 	return route.Dst == nil
 }
 
-// AddressesDefault returns a slice of configured addresses in the current network namespace associated with default routes; IPv4 first (if any), then IPv6 (if any). You can optionally pass an AddressFilter to further filter down which addresses are considered
-func AddressesDefault(preferIPv6 bool, af AddressFilter) ([]net.IP, error) {
-	return addressesDefaultInternal(preferIPv6, af, getAddrs, getRouteMap)
+// AddressesDefault returns a slice of configured addresses in the current network namespace associated with default routes; IPv4 first (if any), then IPv6 (if any). You can optionally pass an AddressFilter to further filter down which addresses are considered. The addresses of the excludedDevices are never considered.
+func AddressesDefault(preferIPv6 bool, af AddressFilter, excludedDevices []string) ([]net.IP, error) {
+	return addressesDefaultInternal(preferIPv6, af, linkAddrs(excludedDevices), getRouteMap)
 }
 
 type FoundAddress struct {
@@ -302,36 +402,100 @@ func addressesDefaultInternal(preferIPv6 bool, af AddressFilter, getAddrs addres
 	return matches, nil
 }
 
+// VLAN is the 802.1Q tag of a VLAN interface
+type VLAN struct {
+	ID int
+	// ParentIndex is the index of the interface the VLAN is tagged on
+	ParentIndex int
+}
+
+// listVLANs returns the VLAN interfaces by index, it is swapped out by the
+// tests
+var listVLANs = func() (map[int]VLAN, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	vlans := map[int]VLAN{}
+	for _, link := range links {
+		if vlan, ok := link.(*netlink.Vlan); ok {
+			vlans[vlan.Attrs().Index] = VLAN{ID: vlan.VlanId, ParentIndex: vlan.Attrs().ParentIndex}
+		}
+	}
+	return vlans, nil
+}
+
+// GetVLAN returns the VLAN of the interface, nil when it is untagged
+func GetVLAN(iface net.Interface) (*VLAN, error) {
+	vlans, err := listVLANs()
+	if err != nil {
+		return nil, err
+	}
+	if vlan, ok := vlans[iface.Index]; ok {
+		return &vlan, nil
+	}
+	return nil, nil
+}
+
+// interfaceAddrs are the addresses of an interface
+type interfaceAddrs struct {
+	iface net.Interface
+	addrs []net.Addr
+}
+
 // GetInterfaceWithCidrByIP returns the interface and network that has the passed IP address
 // configured. It allows to run in a non-strict mode in which it's not required to match the
 // exact IP address but only a subnet.
 //
 // E.g. for interface configured as "192.168.1.1/24" strict mode asked about "192.168.1.2" returns
 // FALSE whereas in non-strict mode it returns TRUE.
+//
+// The interface carrying the address wins over the ones whose subnet contains it, and a VLAN
+// interface over its parent when both have an address in the subnet.
 func GetInterfaceWithCidrByIP(ip net.IP, strictMatch bool) (*net.Interface, *net.IPNet, error) {
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return nil, nil, err
 	}
+	candidates := make([]interfaceAddrs, 0, len(interfaces))
 	for _, iface := range interfaces {
 		addrs, err := iface.Addrs()
 		if err != nil {
 			log.WithError(err).Warnf("Failed to get addresses for %s interface", iface.Name)
 			continue
 		}
-		for _, addr := range addrs {
+		candidates = append(candidates, interfaceAddrs{iface: iface, addrs: addrs})
+	}
+	vlans := map[int]VLAN{}
+	if !strictMatch {
+		if vlans, err = listVLANs(); err != nil {
+			log.WithError(err).Warn("Failed to list the VLAN interfaces")
+		}
+	}
+	return selectInterfaceWithCidrByIP(ip, strictMatch, candidates, vlans)
+}
+
+func selectInterfaceWithCidrByIP(ip net.IP, strictMatch bool, candidates []interfaceAddrs, vlans map[int]VLAN) (*net.Interface, *net.IPNet, error) {
+	type match struct {
+		iface *net.Interface
+		net   *net.IPNet
+	}
+	subnetMatches := []match{}
+	for i := range candidates {
+		iface := &candidates[i].iface
+		for _, addr := range candidates[i].addrs {
 			switch n := addr.(type) {
 			case *net.IPNet:
 				addrOffset := strings.Replace(addr.String(), "/128", "/64", 1)
 				ifaceIp, _, err := net.ParseCIDR(addrOffset)
 				if err == nil {
 					if ifaceIp.Equal(ip) {
-						return &iface, n, nil
+						return iface, n, nil
 					}
 					if !strictMatch {
-						match, _ := IpInCidr(ip.String(), addrOffset)
-						if match {
-							return &iface, n, nil
+						inCidr, _ := IpInCidr(ip.String(), addrOffset)
+						if inCidr {
+							subnetMatches = append(subnetMatches, match{iface, n})
 						}
 					}
 				}
@@ -340,5 +504,18 @@ func GetInterfaceWithCidrByIP(ip net.IP, strictMatch bool) (*net.Interface, *net
 			}
 		}
 	}
+	// The parent of a matching VLAN interface only matches through an
+	// address of the untagged network
+	parents := map[int]bool{}
+	for _, m := range subnetMatches {
+		if vlan, ok := vlans[m.iface.Index]; ok {
+			parents[vlan.ParentIndex] = true
+		}
+	}
+	for _, m := range subnetMatches {
+		if !parents[m.iface.Index] {
+			return m.iface, m.net, nil
+		}
+	}
 	return nil, nil, errors.New(fmt.Sprintf("failed find a interface for the ip %s", ip.String()))
 }
@@ -597,6 +597,149 @@ var _ = Describe("addresses", func() {
 	})
 })
 
+var _ = Describe("interface_with_cidr_by_ip", func() {
+	parent := net.Interface{Index: 2, Name: "eno1"}
+	vlan := net.Interface{Index: 3, Name: "eno1.100"}
+	other := net.Interface{Index: 4, Name: "eno2"}
+	vlans := map[int]VLAN{3: {ID: 100, ParentIndex: 2}}
+
+	ipNet := func(cidr string) net.Addr {
+		ip, n, err := net.ParseCIDR(cidr)
+		Expect(err).NotTo(HaveOccurred())
+		n.IP = ip
+		return n
+	}
+
+	It("prefers_the_interface_carrying_the_address", func() {
+		candidates := []interfaceAddrs{
+			{iface: parent, addrs: []net.Addr{ipNet("10.0.0.0/8")}},
+			{iface: vlan, addrs: []net.Addr{ipNet("10.100.0.20/24")}},
+		}
+		iface, addr, err := selectInterfaceWithCidrByIP(net.ParseIP("10.100.0.20"), false, candidates, vlans)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1.100"))
+		Expect(addr.String()).To(Equal("10.100.0.20/24"))
+
+		iface, _, err = selectInterfaceWithCidrByIP(net.ParseIP("10.100.0.20"), true, candidates, vlans)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1.100"))
+	})
+
+	It("prefers_the_vlan_over_its_parent", func() {
+		candidates := []interfaceAddrs{
+			{iface: parent, addrs: []net.Addr{ipNet("fd00:100::10/64")}},
+			{iface: vlan, addrs: []net.Addr{ipNet("fd00:100::20/64")}},
+		}
+		iface, _, err := selectInterfaceWithCidrByIP(net.ParseIP("fd00:100::5"), false, candidates, vlans)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1.100"))
+
+		// Without VLAN information the first interface wins
+		iface, _, err = selectInterfaceWithCidrByIP(net.ParseIP("fd00:100::5"), false, candidates, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1"))
+	})
+
+	It("keeps_the_parent_for_the_untagged_network", func() {
+		candidates := []interfaceAddrs{
+			{iface: parent, addrs: []net.Addr{ipNet("192.168.111.20/24")}},
+			{iface: vlan, addrs: []net.Addr{ipNet("10.100.0.20/24")}},
+			{iface: other, addrs: []net.Addr{ipNet("172.22.0.20/24")}},
+		}
+		iface, _, err := selectInterfaceWithCidrByIP(net.ParseIP("192.168.111.5"), false, candidates, vlans)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1"))
+
+		iface, _, err = selectInterfaceWithCidrByIP(net.ParseIP("10.100.0.5"), false, candidates, vlans)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(iface.Name).To(Equal("eno1.100"))
+
+		_, _, err = selectInterfaceWithCidrByIP(net.ParseIP("10.100.0.5"), true, candidates, vlans)
+		Expect(err).To(HaveOccurred())
+		_, _, err = selectInterfaceWithCidrByIP(net.ParseIP("10.200.0.5"), false, candidates, vlans)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns_the_vlan_of_an_interface", func() {
+		listed := listVLANs
+		defer func() { listVLANs = listed }()
+		listVLANs = func() (map[int]VLAN, error) { return vlans, nil }
+
+		v, err := GetVLAN(vlan)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(Equal(&VLAN{ID: 100, ParentIndex: 2}))
+		v, err = GetVLAN(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(BeNil())
+	})
+})
+
+var _ = Describe("ovn_internal_subnets", func() {
+	addr := func(ip string) netlink.Addr {
+		return netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP(ip)}, PreferedLft: 100}
+	}
+
+	It("rejects_the_default_masquerade_addresses", func() {
+		Expect(ValidOVNNodeAddress(addr("fd69::2"))).To(BeFalse())
+		Expect(ValidOVNNodeAddress(addr("169.254.169.2"))).To(BeFalse())
+		Expect(ValidOVNNodeAddress(addr("fd00::5"))).To(BeTrue())
+		Expect(ValidOVNNodeAddress(addr("192.168.1.10"))).To(BeTrue())
+	})
+
+	It("rejects_the_configured_subnets", func() {
+		filter, err := OVNNodeAddressFilter([]string{"100.88.0.0/16", "fd98::/64"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter(addr("100.88.0.2"))).To(BeFalse())
+		Expect(filter(addr("fd98::2"))).To(BeFalse())
+		Expect(filter(addr("fd69::2"))).To(BeTrue())
+	})
+
+	It("rejects_invalid_subnets", func() {
+		_, err := OVNNodeAddressFilter([]string{"fd98::"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("excluded_devices", func() {
+	var saved func(string) string
+
+	BeforeEach(func() {
+		saved = deviceDriver
+		deviceDriver = func(name string) string {
+			if name == "ens1f0v0" {
+				return "iavf"
+			}
+			return ""
+		}
+	})
+
+	AfterEach(func() {
+		deviceDriver = saved
+	})
+
+	It("excludes_the_ovn_and_pod_interfaces", func() {
+		Expect(excludedDevice(nil, "ovn-k8s-mp0")).To(Equal("ovn-k8s-mp0"))
+		Expect(excludedDevice(nil, "genev_sys_6081")).To(Equal("genev_sys_*"))
+		Expect(excludedDevice(nil, "veth1234")).To(Equal("veth*"))
+		Expect(excludedDevice(nil, "cali0abc")).To(Equal("cali*"))
+		Expect(excludedDevice(nil, "eth0")).To(BeEmpty())
+		Expect(excludedDevice(nil, "br-ex")).To(BeEmpty())
+	})
+
+	It("excludes_the_configured_names_and_drivers", func() {
+		excluded := []string{"ens2*", "driver:iavf"}
+		Expect(excludedDevice(excluded, "ens2f1")).To(Equal("ens2*"))
+		Expect(excludedDevice(excluded, "ens1f0v0")).To(Equal("driver:iavf"))
+		Expect(excludedDevice(excluded, "ens1f0")).To(BeEmpty())
+	})
+
+	It("rejects_invalid_patterns", func() {
+		Expect(ValidateExcludedDevices([]string{"eth*", "driver:mlx5_*"})).To(Succeed())
+		Expect(ValidateExcludedDevices([]string{"eth["})).NotTo(Succeed())
+		Expect(ValidateExcludedDevices([]string{"driver:["})).NotTo(Succeed())
+	})
+})
+
 func Test(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Addresses tests")
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	arpRequest = 1
+	arpReply   = 2
+
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+
+	ndOptSourceLinkAddr = 1
+	ndOptTargetLinkAddr = 2
+)
+
+// Htons returns v in network byte order, as the protocol of packet sockets
+// is given
+func Htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return binary.NativeEndian.Uint16(b)
+}
+
+// buildARPProbe returns an RFC 5227 ARP probe for ip: a request with an
+// all-zero sender address so that the neighbour caches of the hosts on the
+// link are left alone.
+func buildARPProbe(mac net.HardwareAddr, ip net.IP) []byte {
+	buf := make([]byte, 28)
+	binary.BigEndian.PutUint16(buf[0:2], 1) // ethernet
+	binary.BigEndian.PutUint16(buf[2:4], unix.ETH_P_IP)
+	buf[4] = 6
+	buf[5] = 4
+	binary.BigEndian.PutUint16(buf[6:8], arpRequest)
+	copy(buf[8:14], mac)
+	copy(buf[24:28], ip.To4())
+	return buf
+}
+
+// buildGratuitousARP returns a gratuitous ARP request announcing that mac
+// owns ip, so that the hosts on the link update their neighbour caches.
+func buildGratuitousARP(mac net.HardwareAddr, ip net.IP) []byte {
+	buf := buildARPProbe(mac, ip)
+	copy(buf[14:18], ip.To4())
+	return buf
+}
+
+// parseARPClaim returns the hardware address of an ARP packet sent by the
+// owner of ip, or nil if the packet is about anything else.
+func parseARPClaim(packet []byte, ip net.IP) net.HardwareAddr {
+	if len(packet) < 28 || packet[4] != 6 || packet[5] != 4 {
+		return nil
+	}
+	op := binary.BigEndian.Uint16(packet[6:8])
+	if op != arpReply && op != arpRequest {
+		return nil
+	}
+	// Both replies and announcements/gratuitous requests have the owner in
+	// the sender fields
+	if !net.IP(packet[14:18]).Equal(ip.To4()) {
+		return nil
+	}
+	return net.HardwareAddr(append([]byte{}, packet[8:14]...))
+}
+
+func solicitedNodeAddr(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+func buildNeighborSolicitation(mac net.HardwareAddr, ip net.IP) []byte {
+	// The checksum is filled in by the kernel for ICMPv6 raw sockets
+	buf := make([]byte, 24, 32)
+	buf[0] = icmpv6NeighborSolicitation
+	copy(buf[8:24], ip.To16())
+	buf = append(buf, ndOptSourceLinkAddr, 1)
+	return append(buf, mac...)
+}
+
+// buildUnsolicitedNeighborAdvertisement returns a neighbour advertisement
+// with the override flag announcing that mac owns ip
+func buildUnsolicitedNeighborAdvertisement(mac net.HardwareAddr, ip net.IP) []byte {
+	buf := make([]byte, 24, 32)
+	buf[0] = icmpv6NeighborAdvertisement
+	buf[4] = 0x20 // override
+	copy(buf[8:24], ip.To16())
+	buf = append(buf, ndOptTargetLinkAddr, 1)
+	return append(buf, mac...)
+}
+
+// parseNeighborAdvertisement returns the target link-layer address of a
+// neighbour advertisement for ip, or nil if the message is anything else.
+func parseNeighborAdvertisement(msg []byte, ip net.IP) net.HardwareAddr {
+	if len(msg) < 24 || msg[0] != icmpv6NeighborAdvertisement || !net.IP(msg[8:24]).Equal(ip) {
+		return nil
+	}
+	options := msg[24:]
+	for len(options) >= 8 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			return nil
+		}
+		if options[0] == ndOptTargetLinkAddr && length >= 8 {
+			return net.HardwareAddr(append([]byte{}, options[2:8]...))
+		}
+		options = options[length:]
+	}
+	return nil
+}
+
+// ProbeAddress looks for hosts using ip on iface, with an ARP probe for IPv4
+// and a neighbour solicitation for IPv6, and returns the hardware addresses
+// of the hosts that answered within timeout.
+func ProbeAddress(iface *net.Interface, ip net.IP, timeout time.Duration) ([]net.HardwareAddr, error) {
+	if ip.To4() != nil {
+		return probeIPv4(iface, ip, timeout)
+	}
+	return probeIPv6(iface, ip, timeout)
+}
+
+// AnnounceAddress announces that iface owns ip, with a gratuitous ARP for
+// IPv4 and an unsolicited neighbour advertisement for IPv6, so that the hosts
+// on the link send the traffic of ip to iface right away.
+func AnnounceAddress(iface *net.Interface, ip net.IP) error {
+	if ip.To4() != nil {
+		fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(Htons(unix.ETH_P_ARP)))
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+		bcast := &unix.SockaddrLinklayer{
+			Protocol: Htons(unix.ETH_P_ARP),
+			Ifindex:  iface.Index,
+			Halen:    6,
+		}
+		copy(bcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		return unix.Sendto(fd, buildGratuitousARP(iface.HardwareAddr, ip), 0, bcast)
+	}
+
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.BindToDevice(fd, iface.Name); err != nil {
+		return err
+	}
+	// Neighbour discovery messages must have a hop limit of 255
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return err
+	}
+	dst := &unix.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(dst.Addr[:], net.IPv6linklocalallnodes)
+	return unix.Sendto(fd, buildUnsolicitedNeighborAdvertisement(iface.HardwareAddr, ip), 0, dst)
+}
+
+func probeIPv4(iface *net.Interface, ip net.IP, timeout time.Duration) ([]net.HardwareAddr, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(Htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: Htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	if err := unix.Bind(fd, addr); err != nil {
+		return nil, err
+	}
+	bcast := *addr
+	copy(bcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := unix.Sendto(fd, buildARPProbe(iface.HardwareAddr, ip), 0, &bcast); err != nil {
+		return nil, err
+	}
+
+	return collectClaims(fd, timeout, func(packet []byte) net.HardwareAddr {
+		return parseARPClaim(packet, ip)
+	})
+}
+
+func probeIPv6(iface *net.Interface, ip net.IP, timeout time.Duration) ([]net.HardwareAddr, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.BindToDevice(fd, iface.Name); err != nil {
+		return nil, err
+	}
+	// Neighbour discovery messages must have a hop limit of 255
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return nil, err
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, 255); err != nil {
+		return nil, err
+	}
+
+	dst := &unix.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(dst.Addr[:], solicitedNodeAddr(ip))
+	if err := unix.Sendto(fd, buildNeighborSolicitation(iface.HardwareAddr, ip), 0, dst); err != nil {
+		return nil, err
+	}
+
+	return collectClaims(fd, timeout, func(msg []byte) net.HardwareAddr {
+		return parseNeighborAdvertisement(msg, ip)
+	})
+}
+
+// collectClaims reads from fd until timeout, returning the distinct hardware
+// addresses that parse extracts from the packets.
+func collectClaims(fd int, timeout time.Duration, parse func([]byte) net.HardwareAddr) ([]net.HardwareAddr, error) {
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Usec: 100000}); err != nil {
+		return nil, err
+	}
+
+	claims := []net.HardwareAddr{}
+	buf := make([]byte, 1500)
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read probe replies: %w", err)
+		}
+
+		mac := parse(buf[:n])
+		if mac == nil {
+			continue
+		}
+		known := false
+		for _, c := range claims {
+			if bytes.Equal(c, mac) {
+				known = true
+			}
+		}
+		if !known {
+			claims = append(claims, mac)
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("duplicate address detection", func() {
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	vip := net.ParseIP("192.168.111.5")
+
+	It("builds an ARP probe with an unspecified sender address", func() {
+		probe := buildARPProbe(mac, vip)
+		Expect(probe).To(HaveLen(28))
+		Expect(net.HardwareAddr(probe[8:14])).To(Equal(mac))
+		Expect(net.IP(probe[14:18]).Equal(net.IPv4zero.To4())).To(BeTrue())
+		Expect(net.IP(probe[24:28]).Equal(vip)).To(BeTrue())
+	})
+
+	It("extracts the owner of a VIP from an ARP reply", func() {
+		owner, _ := net.ParseMAC("52:54:00:ab:cd:ef")
+		reply := buildARPProbe(owner, net.ParseIP("192.168.111.20"))
+		reply[7] = arpReply
+		copy(reply[14:18], vip.To4())
+		Expect(parseARPClaim(reply, vip)).To(Equal(owner))
+		Expect(parseARPClaim(reply, net.ParseIP("192.168.111.6"))).To(BeNil())
+		Expect(parseARPClaim(reply[:20], vip)).To(BeNil())
+	})
+
+	It("solicits the solicited-node multicast address", func() {
+		Expect(solicitedNodeAddr(net.ParseIP("fd2e:6f44:5dd8::5:1234")).String()).To(Equal("ff02::1:ff05:1234"))
+	})
+
+	It("extracts the target link-layer address of a neighbour advertisement", func() {
+		vip6 := net.ParseIP("fd2e:6f44:5dd8::5")
+		owner, _ := net.ParseMAC("52:54:00:ab:cd:ef")
+		na := buildNeighborSolicitation(owner, vip6)
+		na[0] = icmpv6NeighborAdvertisement
+		na[24] = ndOptTargetLinkAddr
+		Expect(parseNeighborAdvertisement(na, vip6)).To(Equal(owner))
+		Expect(parseNeighborAdvertisement(na, net.ParseIP("fd2e:6f44:5dd8::6"))).To(BeNil())
+
+		ns := buildNeighborSolicitation(owner, vip6)
+		Expect(parseNeighborAdvertisement(ns, vip6)).To(BeNil())
+	})
+
+	It("announces the owner of a VIP", func() {
+		garp := buildGratuitousARP(mac, vip)
+		Expect(parseARPClaim(garp, vip)).To(Equal(mac))
+		Expect(net.IP(garp[24:28]).Equal(vip)).To(BeTrue())
+
+		vip6 := net.ParseIP("fd2e:6f44:5dd8::5")
+		Expect(parseNeighborAdvertisement(buildUnsolicitedNeighborAdvertisement(mac, vip6), vip6)).To(Equal(mac))
+	})
+})
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubeClient holds the clientset of a kubeconfig for the long running
+// monitors. The kubeconfig is read again on every call and the clientset
+// is built again when it changed, e.g. when the client certificate it
+// embeds was rotated. Certificates the kubeconfig references by path and
+// the token of the service account are reloaded by client-go itself.
+type KubeClient struct {
+	apiServerURL   string
+	kubeconfigPath string
+
+	lock      sync.Mutex
+	clientset *kubernetes.Clientset
+	checksum  [sha256.Size]byte
+}
+
+// NewKubeClient returns the client of the API server at apiServerURL (the
+// one of the kubeconfig when empty) through kubeconfigPath
+func NewKubeClient(apiServerURL, kubeconfigPath string) *KubeClient {
+	return &KubeClient{apiServerURL: apiServerURL, kubeconfigPath: kubeconfigPath}
+}
+
+type kubeClientKey struct {
+	apiServerURL   string
+	kubeconfigPath string
+}
+
+var (
+	kubeClientsLock sync.Mutex
+	kubeClients     = map[kubeClientKey]*KubeClient{}
+)
+
+// SharedKubeClient returns the client of the process for apiServerURL and
+// kubeconfigPath
+func SharedKubeClient(apiServerURL, kubeconfigPath string) *KubeClient {
+	kubeClientsLock.Lock()
+	defer kubeClientsLock.Unlock()
+	key := kubeClientKey{apiServerURL, kubeconfigPath}
+	if _, ok := kubeClients[key]; !ok {
+		kubeClients[key] = NewKubeClient(apiServerURL, kubeconfigPath)
+	}
+	return kubeClients[key]
+}
+
+// Clientset returns the clientset, built again when the kubeconfig changed
+// since the previous call
+func (k *KubeClient) Clientset() (*kubernetes.Clientset, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var checksum [sha256.Size]byte
+	if !IsInCluster(k.kubeconfigPath) {
+		data, err := os.ReadFile(k.kubeconfigPath)
+		if err != nil {
+			return nil, err
+		}
+		checksum = sha256.Sum256(data)
+	}
+	if k.clientset != nil && checksum == k.checksum {
+		return k.clientset, nil
+	}
+
+	config, err := GetClientConfig(k.apiServerURL, k.kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if k.clientset != nil {
+		log.WithFields(logrus.Fields{
+			"kubeconfigPath": k.kubeconfigPath,
+		}).Info("Kubeconfig changed, rebuilt the API client")
+	}
+	k.clientset = clientset
+	k.checksum = checksum
+	return clientset, nil
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KubeClient", func() {
+	var dir, path string
+
+	writeKubeconfig := func(server string) {
+		Expect(os.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: ostest
+  cluster:
+    server: %s
+contexts:
+- name: admin
+  context:
+    cluster: ostest
+    user: admin
+current-context: admin
+users:
+- name: admin
+  user:
+    token: admin
+`, server)), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "kubeclient")
+		Expect(err).ShouldNot(HaveOccurred())
+		path = filepath.Join(dir, "kubeconfig")
+		writeKubeconfig("https://api-int.ostest.test.metalkube.org:6443")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reuses the clientset while the kubeconfig is unchanged", func() {
+		client := NewKubeClient("", path)
+		first, err := client.Clientset()
+		Expect(err).ShouldNot(HaveOccurred())
+		second, err := client.Clientset()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+
+	It("rebuilds the clientset when the kubeconfig changes", func() {
+		client := NewKubeClient("", path)
+		first, err := client.Clientset()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// Rotations replace the file rather than rewriting it in place
+		Expect(os.Rename(path, path+".old")).To(Succeed())
+		writeKubeconfig("https://api-int.ostest.test.metalkube.org:6444")
+		second, err := client.Clientset()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(second).NotTo(BeIdenticalTo(first))
+		Expect(second.CoreV1().RESTClient().Get().URL().Host).To(Equal("api-int.ostest.test.metalkube.org:6444"))
+	})
+
+	It("fails while the kubeconfig is missing", func() {
+		client := NewKubeClient("", path)
+		Expect(os.Remove(path)).To(Succeed())
+		_, err := client.Clientset()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("shares the client of a kubeconfig", func() {
+		Expect(SharedKubeClient("", path)).To(BeIdenticalTo(SharedKubeClient("", path)))
+		Expect(SharedKubeClient("https://localhost:6443", path)).NotTo(BeIdenticalTo(SharedKubeClient("", path)))
+	})
+})
@@ -1,13 +1,22 @@
 package utils
 
-import "github.com/sirupsen/logrus"
+import (
+	"github.com/sirupsen/logrus"
 
-var log = logrus.New()
+	"github.com/openshift/baremetal-runtimecfg/pkg/logging"
+)
 
+const loggerName = "utils"
+
+var log = logging.Logger(loggerName)
+
+// SetDebugLogLevel raises the package logger to at least the debug level
 func SetDebugLogLevel() {
-	log.SetLevel(logrus.DebugLevel)
+	log.SetLevel(max(logging.Level(loggerName), logrus.DebugLevel))
 }
 
+// SetInfoLogLevel restores the level set through the logging package, info
+// by default
 func SetInfoLogLevel() {
-	log.SetLevel(logrus.InfoLevel)
+	log.SetLevel(logging.Level(loggerName))
 }
@@ -89,3 +89,23 @@ func GetLocalCIDRByIP(ip string) (string, error) {
 	// not the real configuration.
 	return strings.Replace(net.String(), "/128", "/64", 1), nil
 }
+
+// ReverseAddr returns the in-addr.arpa or ip6.arpa name used for PTR records
+// of ip, without the trailing dot. It returns an empty string for invalid
+// addresses.
+func ReverseAddr(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	const hexDigits = "0123456789abcdef"
+	labels := make([]string, 0, 2*net.IPv6len+1)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigits[ip16[i]&0xf]), string(hexDigits[ip16[i]>>4]))
+	}
+	labels = append(labels, "ip6.arpa")
+	return strings.Join(labels, ".")
+}
@@ -214,3 +214,15 @@ var _ = Describe("ConvertIpsToStrings", func() {
 		Expect(res[1]).To(Equal(sampleV6))
 	})
 })
+
+var _ = Describe("ReverseAddr", func() {
+	It("builds in-addr.arpa names for IPv4", func() {
+		Expect(ReverseAddr(net.ParseIP("192.168.1.10"))).To(Equal("10.1.168.192.in-addr.arpa"))
+	})
+	It("builds ip6.arpa names for IPv6", func() {
+		Expect(ReverseAddr(net.ParseIP("2001:db8::567:89ab"))).To(Equal("b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"))
+	})
+	It("returns empty for invalid input", func() {
+		Expect(ReverseAddr(nil)).To(BeEmpty())
+	})
+})
@@ -5,10 +5,12 @@ import (
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"net/http"
 	"os"
@@ -19,10 +21,26 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/faults"
 )
 
 const kubeClientTimeout = 30 * time.Second
 
+// InClusterKubeconfig is the kubeconfig path that, like an empty one, makes
+// the commands use the service account of their pod, e.g. when they run as
+// normal pods instead of static pods
+const InClusterKubeconfig = "in-cluster"
+
+// IsInCluster returns whether kubeconfigPath selects the service account of
+// the pod
+func IsInCluster(kubeconfigPath string) bool {
+	return kubeconfigPath == "" || kubeconfigPath == InClusterKubeconfig
+}
+
+// swapped out by the tests
+var inClusterConfig = rest.InClusterConfig
+
 func FletcherChecksum8(inp string) uint8 {
 	var ckA, ckB uint8
 	for i := 0; i < len(inp); i++ {
@@ -119,9 +137,20 @@ func GetFileMd5(filePath string) (string, error) {
 	return returnMD5String, nil
 }
 
-// getClientConfig returns a Kubernetes client Config.
+// getClientConfig returns a Kubernetes client Config. The service account
+// of the pod is used when kubeconfigPath is empty or InClusterKubeconfig,
+// with kubeApiServerUrl as the server when set.
 func GetClientConfig(kubeApiServerUrl, kubeconfigPath string) (*rest.Config, error) {
-	config, err := clientcmd.BuildConfigFromFlags(kubeApiServerUrl, kubeconfigPath)
+	var config *rest.Config
+	var err error
+	if IsInCluster(kubeconfigPath) {
+		config, err = inClusterConfig()
+		if err == nil && kubeApiServerUrl != "" {
+			config.Host = kubeApiServerUrl
+		}
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags(kubeApiServerUrl, kubeconfigPath)
+	}
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"err": err,
@@ -131,6 +160,9 @@ func GetClientConfig(kubeApiServerUrl, kubeconfigPath string) (*rest.Config, err
 	// Kubeapi can be not stable on installation process
 	// and we should free connection in case it was stuck
 	config.Timeout = kubeClientTimeout
+	if faults.Active() {
+		config.Wrap(faults.WrapTransport)
+	}
 	return config, err
 }
 
@@ -171,3 +203,26 @@ func GetNodeIPDebugStatus(clientset *kubernetes.Clientset) bool {
 
 	return false
 }
+
+// PatchNodeAnnotations sets annotations on the node called nodeName with a
+// merge patch, leaving its other annotations alone
+func PatchNodeAnnotations(kubeconfigPath, nodeName string, annotations map[string]string) error {
+	config, err := GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
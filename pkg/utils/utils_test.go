@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("GetClientConfig", func() {
+	var origInClusterConfig func() (*rest.Config, error)
+
+	BeforeEach(func() {
+		origInClusterConfig = inClusterConfig
+		inClusterConfig = func() (*rest.Config, error) {
+			return &rest.Config{Host: "https://172.30.0.1:443", BearerToken: "token"}, nil
+		}
+	})
+
+	AfterEach(func() {
+		inClusterConfig = origInClusterConfig
+	})
+
+	It("uses the service account without a kubeconfig", func() {
+		for _, path := range []string{"", InClusterKubeconfig} {
+			config, err := GetClientConfig("", path)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(config.Host).To(Equal("https://172.30.0.1:443"))
+			Expect(config.BearerToken).To(Equal("token"))
+			Expect(config.Timeout).To(Equal(kubeClientTimeout))
+		}
+	})
+
+	It("points the service account at the given server", func() {
+		config, err := GetClientConfig("https://localhost:6443", InClusterKubeconfig)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(config.Host).To(Equal("https://localhost:6443"))
+		Expect(config.BearerToken).To(Equal("token"))
+	})
+
+	It("reads the kubeconfig when given one", func() {
+		dir, err := os.MkdirTemp("", "kubeconfig")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "kubeconfig")
+		Expect(os.WriteFile(path, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: ostest
+  cluster:
+    server: https://api-int.ostest.test.metalkube.org:6443
+contexts:
+- name: admin
+  context:
+    cluster: ostest
+    user: admin
+current-context: admin
+users:
+- name: admin
+  user:
+    token: admin
+`), 0644)).To(Succeed())
+		config, err := GetClientConfig("", path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(config.Host).To(Equal("https://api-int.ostest.test.metalkube.org:6443"))
+	})
+})
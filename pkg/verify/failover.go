@@ -0,0 +1,199 @@
+package verify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+// FailoverOptions select the VIP that Failover moves away from the node
+type FailoverOptions struct {
+	VIP net.IP
+	// ControlSocket is the control socket of the local keepalived
+	ControlSocket string
+	// APIPort is where the API is probed through the VIP, zero skips the
+	// probe
+	APIPort uint16
+	// Timeout bounds how long the VIP may take to move and the API to
+	// answer through it
+	Timeout time.Duration
+}
+
+// swapped out by the tests
+var (
+	interfaceHolding   = vipHolder
+	localHardwareAddrs = func() map[string]bool {
+		known := map[string]bool{}
+		if ifaces, err := net.Interfaces(); err == nil {
+			for _, i := range ifaces {
+				if len(i.HardwareAddr) > 0 {
+					known[i.HardwareAddr.String()] = true
+				}
+			}
+		}
+		return known
+	}
+	probeAddress   = utils.ProbeAddress
+	sendKeepalived = writeControlSocket
+	apiReady       = apiReadyThrough
+	failoverPoll   = time.Second
+)
+
+// vipHolder returns the local interface that has vip, nil when no interface
+// has it
+func vipHolder(vip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(vip) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// writeControlSocket writes command to the keepalived control socket, where
+// stop stops keepalived and reload starts it again
+func writeControlSocket(path, command string) error {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(command + "\n"))
+	return err
+}
+
+// apiReadyThrough returns an error unless the readyz of the API answers ok
+// through vip
+func apiReadyThrough(vip net.IP, port uint16) error {
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   5 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(fmt.Sprintf("https://%s/readyz", net.JoinHostPort(vip.String(), strconv.Itoa(int(port)))))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if string(body) != "ok" {
+		return fmt.Errorf("readyz answered %q", string(body))
+	}
+	return nil
+}
+
+// waitFor calls check every failoverPoll until it succeeds or deadline
+// passes, and returns the last error along with how long it waited
+func waitFor(deadline time.Time, check func() error) (time.Duration, error) {
+	start := time.Now()
+	for {
+		err := check()
+		if err == nil || !time.Now().Add(failoverPoll).Before(deadline) {
+			return time.Since(start), err
+		}
+		time.Sleep(failoverPoll)
+	}
+}
+
+// Failover stops the local keepalived, which gives up the VIPs it holds with
+// a priority 0 advertisement, and checks that another peer takes over the
+// VIP of opts and that the API answers through it. keepalived is started
+// again whatever the outcome.
+func Failover(opts FailoverOptions) (report Report) {
+	target := opts.VIP.String()
+	defer func() {
+		report.Passed = len(report.Results) > 0
+		for _, r := range report.Results {
+			report.Passed = report.Passed && r.Passed
+		}
+	}()
+
+	iface, err := interfaceHolding(opts.VIP)
+	if err != nil || iface == nil {
+		message := "not held by this node, run the test on the node holding it"
+		if err != nil {
+			message = err.Error()
+		}
+		report.add(Result{Check: "vip-held", Target: target, Message: message})
+		return report
+	}
+	report.add(Result{Check: "vip-held", Target: target, Passed: true, Message: "held on " + iface.Name})
+
+	if err := sendKeepalived(opts.ControlSocket, "stop"); err != nil {
+		report.add(Result{Check: "keepalived-stop", Target: opts.ControlSocket, Message: err.Error()})
+		return report
+	}
+	report.add(Result{Check: "keepalived-stop", Target: opts.ControlSocket, Passed: true, Message: "keepalived stopped"})
+	defer func() {
+		result := Result{Check: "keepalived-restore", Target: opts.ControlSocket, Passed: true, Message: "keepalived started again"}
+		if err := sendKeepalived(opts.ControlSocket, "reload"); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+		}
+		report.add(result)
+	}()
+
+	deadline := time.Now().Add(opts.Timeout)
+	local := localHardwareAddrs()
+	var owner net.HardwareAddr
+	took, err := waitFor(deadline, func() error {
+		if held, err := interfaceHolding(opts.VIP); err != nil {
+			return err
+		} else if held != nil {
+			return fmt.Errorf("still held on %s", held.Name)
+		}
+		macs, err := probeAddress(iface, opts.VIP, failoverPoll)
+		if err != nil {
+			return err
+		}
+		for _, mac := range macs {
+			if !local[mac.String()] {
+				owner = mac
+				return nil
+			}
+		}
+		return fmt.Errorf("no peer answers for the VIP")
+	})
+	if err != nil {
+		report.add(Result{Check: "vip-moved", Target: target, Message: fmt.Sprintf("%v after %s", err, took.Round(time.Millisecond))})
+		return report
+	}
+	report.add(Result{Check: "vip-moved", Target: target, Passed: true, Message: fmt.Sprintf("answered by %s after %s", owner, took.Round(time.Millisecond))})
+
+	if opts.APIPort != 0 {
+		result := Result{Check: "api-through-vip", Target: net.JoinHostPort(target, strconv.Itoa(int(opts.APIPort)))}
+		took, err := waitFor(deadline, func() error {
+			return apiReady(opts.VIP, opts.APIPort)
+		})
+		if err != nil {
+			result.Message = err.Error()
+		} else {
+			result.Passed = true
+			result.Message = fmt.Sprintf("ready after %s", took.Round(time.Millisecond))
+		}
+		report.add(result)
+	}
+	return report
+}
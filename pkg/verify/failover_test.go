@@ -0,0 +1,103 @@
+package verify
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failover", func() {
+	vip := net.ParseIP("192.168.111.5")
+	eth0 := &net.Interface{Name: "eth0"}
+	peerMAC, _ := net.ParseMAC("52:54:00:00:00:02")
+	localMAC, _ := net.ParseMAC("52:54:00:00:00:01")
+	var held bool
+	var commands []string
+	var (
+		origInterfaceHolding   = interfaceHolding
+		origLocalHardwareAddrs = localHardwareAddrs
+		origProbeAddress       = probeAddress
+		origSendKeepalived     = sendKeepalived
+		origAPIReady           = apiReady
+		origFailoverPoll       = failoverPoll
+	)
+
+	BeforeEach(func() {
+		held = true
+		commands = nil
+		failoverPoll = time.Millisecond
+		interfaceHolding = func(net.IP) (*net.Interface, error) {
+			if held {
+				return eth0, nil
+			}
+			return nil, nil
+		}
+		localHardwareAddrs = func() map[string]bool {
+			return map[string]bool{localMAC.String(): true}
+		}
+		sendKeepalived = func(_, command string) error {
+			commands = append(commands, command)
+			if command == "stop" {
+				held = false
+			}
+			return nil
+		}
+		probeAddress = func(*net.Interface, net.IP, time.Duration) ([]net.HardwareAddr, error) {
+			return []net.HardwareAddr{peerMAC}, nil
+		}
+		apiReady = func(net.IP, uint16) error { return nil }
+	})
+
+	AfterEach(func() {
+		interfaceHolding = origInterfaceHolding
+		localHardwareAddrs = origLocalHardwareAddrs
+		probeAddress = origProbeAddress
+		sendKeepalived = origSendKeepalived
+		apiReady = origAPIReady
+		failoverPoll = origFailoverPoll
+	})
+
+	checks := func(report Report) []string {
+		names := []string{}
+		for _, r := range report.Results {
+			names = append(names, r.Check)
+		}
+		return names
+	}
+
+	It("passes when a peer takes the VIP over", func() {
+		report := Failover(FailoverOptions{VIP: vip, APIPort: 6443, Timeout: time.Second})
+		Expect(report.Passed).To(BeTrue())
+		Expect(checks(report)).To(Equal([]string{"vip-held", "keepalived-stop", "vip-moved", "api-through-vip", "keepalived-restore"}))
+		Expect(commands).To(Equal([]string{"stop", "reload"}))
+	})
+
+	It("leaves keepalived alone when the VIP is not held", func() {
+		held = false
+		report := Failover(FailoverOptions{VIP: vip, Timeout: time.Second})
+		Expect(report.Passed).To(BeFalse())
+		Expect(checks(report)).To(Equal([]string{"vip-held"}))
+		Expect(commands).To(BeEmpty())
+	})
+
+	It("starts keepalived again when no peer answers for the VIP", func() {
+		probeAddress = func(*net.Interface, net.IP, time.Duration) ([]net.HardwareAddr, error) {
+			return []net.HardwareAddr{localMAC}, nil
+		}
+		report := Failover(FailoverOptions{VIP: vip, Timeout: 10 * time.Millisecond})
+		Expect(report.Passed).To(BeFalse())
+		Expect(checks(report)).To(Equal([]string{"vip-held", "keepalived-stop", "vip-moved", "keepalived-restore"}))
+		Expect(commands).To(Equal([]string{"stop", "reload"}))
+	})
+
+	It("fails when the API does not answer through the VIP", func() {
+		apiReady = func(net.IP, uint16) error { return errors.New("connection refused") }
+		report := Failover(FailoverOptions{VIP: vip, APIPort: 6443, Timeout: 10 * time.Millisecond})
+		Expect(report.Passed).To(BeFalse())
+		Expect(report.Results[3].Passed).To(BeFalse())
+		Expect(report.Results[4].Passed).To(BeTrue())
+	})
+})
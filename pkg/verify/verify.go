@@ -0,0 +1,337 @@
+// Package verify runs sanity checks of the runtime configuration of a node and
+// of what it depends on, for must-gather and installer pre-flight reports.
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+	"github.com/openshift/baremetal-runtimecfg/pkg/utils"
+)
+
+const (
+	ovnHostCIDRsAnnotation     = "k8s.ovn.org/host-cidrs"
+	ovnHostAddressesAnnotation = "k8s.ovn.org/host-addresses"
+)
+
+// Result is the outcome of one check of one target
+type Result struct {
+	Check   string `json:"check"`
+	Target  string `json:"target,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// Report holds the results of every check
+type Report struct {
+	Passed  bool     `json:"passed"`
+	Results []Result `json:"results"`
+}
+
+func (r *Report) add(results ...Result) {
+	r.Results = append(r.Results, results...)
+}
+
+// Options select what Verify checks beyond the node configuration
+type Options struct {
+	KubeconfigPath string
+	// Sockets are the control sockets of the local services that must exist
+	Sockets    []string
+	DNSTimeout time.Duration
+}
+
+// swapped out by the tests
+var (
+	interfaceByIP = func(ip net.IP) (string, error) {
+		iface, _, err := utils.GetInterfaceWithCidrByIP(ip, false)
+		if err != nil {
+			return "", err
+		}
+		if iface == nil {
+			return "", nil
+		}
+		return iface.Name, nil
+	}
+	routeTo   = netlink.RouteGet
+	queryDNS  = queryNS
+	listNodes = listClusterNodes
+)
+
+// Verify checks the node configuration returned by config.GetConfig along
+// with cfgErr, the error GetConfig returned, and what opts select. Every
+// check is run even when others fail.
+func Verify(node config.Node, cfgErr error, opts Options) Report {
+	report := Report{}
+	if cfgErr != nil {
+		report.add(Result{Check: "config", Passed: false, Message: cfgErr.Error()})
+	} else {
+		report.add(Result{Check: "config", Passed: true, Message: "runtime configuration retrieved"})
+		report.add(checkVIPs(node)...)
+		report.add(checkVRIDs(node))
+		report.add(checkDNSUpstreams(node.DNSUpstreams, opts.DNSTimeout)...)
+	}
+	report.add(checkSockets(opts.Sockets)...)
+	if opts.KubeconfigPath != "" {
+		report.add(checkOVNAnnotations(opts.KubeconfigPath)...)
+	}
+
+	report.Passed = true
+	for _, r := range report.Results {
+		report.Passed = report.Passed && r.Passed
+	}
+	return report
+}
+
+// clusterConfigs returns the node and its nested configs
+func clusterConfigs(node config.Node) []config.Node {
+	if node.Configs == nil {
+		return []config.Node{node}
+	}
+	return append([]config.Node{node}, *node.Configs...)
+}
+
+type vip struct {
+	role    string
+	address string
+	vrid    uint8
+	cluster string
+}
+
+// nodeVIPs returns the distinct VIPs of the node and its nested configs
+func nodeVIPs(node config.Node) []vip {
+	vips := []vip{}
+	seen := map[string]bool{}
+	for _, c := range clusterConfigs(node) {
+		for _, v := range []vip{
+			{"api", c.Cluster.APIVIP, c.Cluster.APIVirtualRouterID, c.Cluster.Name},
+			{"ingress", c.Cluster.IngressVIP, c.Cluster.IngressVirtualRouterID, c.Cluster.Name},
+		} {
+			if v.address == "" || seen[v.role+v.address] {
+				continue
+			}
+			seen[v.role+v.address] = true
+			vips = append(vips, v)
+		}
+	}
+	return vips
+}
+
+// checkVIPs checks that every VIP parses and is either on a local subnet or
+// reachable through a route
+func checkVIPs(node config.Node) []Result {
+	results := []Result{}
+	for _, v := range nodeVIPs(node) {
+		result := Result{Check: "vip", Target: v.role + " " + v.address}
+		ip := net.ParseIP(v.address)
+		if ip == nil {
+			result.Message = "not a valid IP address"
+			results = append(results, result)
+			continue
+		}
+		if iface, err := interfaceByIP(ip); err == nil && iface != "" {
+			result.Passed = true
+			result.Message = "on-link on " + iface
+			results = append(results, result)
+			continue
+		}
+		routes, err := routeTo(ip)
+		switch {
+		case err != nil:
+			result.Message = fmt.Sprintf("no route: %v", err)
+		case len(routes) == 0:
+			result.Message = "no route"
+		default:
+			result.Passed = true
+			result.Message = "routed"
+			if routes[0].Gw != nil {
+				result.Message += " via " + routes[0].Gw.String()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkVRIDs checks that no two VRRP instances of the same address family
+// share a virtual router ID
+func checkVRIDs(node config.Node) Result {
+	result := Result{Check: "vrid"}
+	owners := map[string]string{}
+	collisions := []string{}
+	for _, v := range nodeVIPs(node) {
+		family := "ipv4"
+		if utils.IsIPv6(net.ParseIP(v.address)) {
+			family = "ipv6"
+		}
+		key := fmt.Sprintf("%s/%d", family, v.vrid)
+		owner := fmt.Sprintf("%s %s %s", v.cluster, v.role, v.address)
+		if other, ok := owners[key]; ok {
+			collisions = append(collisions, fmt.Sprintf("%s and %s share virtual router ID %d", other, owner, v.vrid))
+			continue
+		}
+		owners[key] = owner
+	}
+	if len(collisions) > 0 {
+		result.Message = strings.Join(collisions, "; ")
+		return result
+	}
+	result.Passed = true
+	result.Message = fmt.Sprintf("%d virtual router ID(s) without collision", len(owners))
+	return result
+}
+
+// queryNS asks server for the root name servers
+func queryNS(server string, timeout time.Duration) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := resolver.LookupNS(ctx, ".")
+	return err
+}
+
+// checkDNSUpstreams checks that every upstream answers. Any answer, even a
+// negative one, passes.
+func checkDNSUpstreams(upstreams []string, timeout time.Duration) []Result {
+	results := []Result{}
+	for _, upstream := range upstreams {
+		result := Result{Check: "dns-upstream", Target: upstream}
+		err := queryDNS(upstream, timeout)
+		var dnsErr *net.DNSError
+		switch {
+		case err == nil:
+			result.Passed = true
+			result.Message = "responded"
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			result.Passed = true
+			result.Message = "responded with no records"
+		default:
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkSockets checks that every path is a unix socket
+func checkSockets(paths []string) []Result {
+	results := []Result{}
+	for _, path := range paths {
+		result := Result{Check: "socket", Target: path}
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil:
+			result.Message = err.Error()
+		case fi.Mode()&os.ModeSocket == 0:
+			result.Message = "not a socket"
+		default:
+			result.Passed = true
+			result.Message = "exists"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func listClusterNodes(kubeconfigPath string) ([]v1.Node, error) {
+	clientConfig, err := utils.GetClientConfig("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// checkOVNAnnotations checks that the OVN address annotations of the nodes,
+// used when the node status lacks an address, parse
+func checkOVNAnnotations(kubeconfigPath string) []Result {
+	nodes, err := listNodes(kubeconfigPath)
+	if err != nil {
+		return []Result{{Check: "ovn-annotations", Message: fmt.Sprintf("failed to list nodes: %v", err)}}
+	}
+	results := []Result{}
+	for _, node := range nodes {
+		results = append(results, checkNodeOVNAnnotations(node)...)
+	}
+	return results
+}
+
+func checkNodeOVNAnnotations(node v1.Node) []Result {
+	results := []Result{}
+	for _, annotation := range []string{ovnHostCIDRsAnnotation, ovnHostAddressesAnnotation} {
+		value, ok := node.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		result := Result{Check: "ovn-annotations", Target: node.Name + " " + annotation}
+		var addresses []string
+		if err := json.Unmarshal([]byte(value), &addresses); err != nil {
+			result.Message = err.Error()
+			results = append(results, result)
+			continue
+		}
+		var err error
+		for _, address := range addresses {
+			if annotation == ovnHostCIDRsAnnotation {
+				_, _, err = net.ParseCIDR(address)
+			} else if net.ParseIP(address) == nil {
+				err = fmt.Errorf("invalid IP address %q", address)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			result.Message = err.Error()
+		} else {
+			result.Passed = true
+			result.Message = fmt.Sprintf("%d address(es)", len(addresses))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// WriteText writes the report as one PASS or FAIL line per result followed
+// by the overall verdict
+func (r Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, result := range r.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", verdict(result.Passed), result.Check, result.Target, result.Message)
+	}
+	fmt.Fprintf(tw, "%s\n", verdict(r.Passed))
+	return tw.Flush()
+}
+
+func verdict(passed bool) string {
+	if passed {
+		return "PASS"
+	}
+	return "FAIL"
+}
@@ -0,0 +1,158 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/baremetal-runtimecfg/pkg/config"
+)
+
+func testNode() config.Node {
+	node := config.Node{
+		Cluster: config.Cluster{
+			Name:                   "ostest",
+			APIVIP:                 "192.168.111.5",
+			APIVirtualRouterID:     10,
+			IngressVIP:             "192.168.111.4",
+			IngressVirtualRouterID: 11,
+		},
+		DNSUpstreams: []string{"10.0.0.53"},
+	}
+	ipv6 := node
+	ipv6.Cluster.APIVIP = "fd2e:6f44:5dd8::5"
+	ipv6.Cluster.IngressVIP = "fd2e:6f44:5dd8::4"
+	configs := []config.Node{node, ipv6}
+	node.Configs = &configs
+	return node
+}
+
+var _ = Describe("Verify", func() {
+	var (
+		origInterfaceByIP = interfaceByIP
+		origRouteTo       = routeTo
+		origQueryDNS      = queryDNS
+		origListNodes     = listNodes
+	)
+
+	BeforeEach(func() {
+		interfaceByIP = func(ip net.IP) (string, error) {
+			if ip.To4() != nil {
+				return "eth0", nil
+			}
+			return "", nil
+		}
+		routeTo = func(ip net.IP) ([]netlink.Route, error) {
+			return []netlink.Route{{Gw: net.ParseIP("fd2e:6f44:5dd8::1")}}, nil
+		}
+		queryDNS = func(server string, timeout time.Duration) error { return nil }
+		listNodes = func(string) ([]v1.Node, error) { return nil, nil }
+	})
+
+	AfterEach(func() {
+		interfaceByIP = origInterfaceByIP
+		routeTo = origRouteTo
+		queryDNS = origQueryDNS
+		listNodes = origListNodes
+	})
+
+	It("passes a sane configuration", func() {
+		report := Verify(testNode(), nil, Options{})
+		Expect(report.Passed).To(BeTrue())
+		Expect(report.Results).To(ContainElement(Result{Check: "vip", Target: "api 192.168.111.5", Passed: true, Message: "on-link on eth0"}))
+		Expect(report.Results).To(ContainElement(Result{Check: "vip", Target: "api fd2e:6f44:5dd8::5", Passed: true, Message: "routed via fd2e:6f44:5dd8::1"}))
+		Expect(report.Results).To(ContainElement(Result{Check: "vrid", Passed: true, Message: "4 virtual router ID(s) without collision"}))
+	})
+
+	It("reports the failure to get the config", func() {
+		report := Verify(config.Node{}, errors.New("no kubeconfig"), Options{})
+		Expect(report.Passed).To(BeFalse())
+		Expect(report.Results).To(Equal([]Result{{Check: "config", Message: "no kubeconfig"}}))
+	})
+
+	It("fails unroutable VIPs", func() {
+		routeTo = func(ip net.IP) ([]netlink.Route, error) { return nil, errors.New("network is unreachable") }
+		report := Verify(testNode(), nil, Options{})
+		Expect(report.Passed).To(BeFalse())
+		Expect(report.Results).To(ContainElement(Result{Check: "vip", Target: "ingress fd2e:6f44:5dd8::4", Message: "no route: network is unreachable"}))
+	})
+
+	It("fails colliding virtual router IDs", func() {
+		node := testNode()
+		node.Cluster.IngressVirtualRouterID = 10
+		Expect(checkVRIDs(node).Passed).To(BeFalse())
+	})
+
+	It("accepts negative DNS answers", func() {
+		queryDNS = func(server string, timeout time.Duration) error {
+			return &net.DNSError{Err: "no such host", IsNotFound: true}
+		}
+		Expect(checkDNSUpstreams([]string{"10.0.0.53"}, time.Second)[0].Passed).To(BeTrue())
+
+		queryDNS = func(server string, timeout time.Duration) error {
+			return &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+		}
+		Expect(checkDNSUpstreams([]string{"10.0.0.53"}, time.Second)[0].Passed).To(BeFalse())
+	})
+
+	It("checks the sockets", func() {
+		dir, err := os.MkdirTemp("", "verify")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		sockPath := filepath.Join(dir, "haproxy.sock")
+		listener, err := net.Listen("unix", sockPath)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer listener.Close()
+		filePath := filepath.Join(dir, "file")
+		Expect(os.WriteFile(filePath, nil, 0644)).To(Succeed())
+
+		results := checkSockets([]string{sockPath, filePath, filepath.Join(dir, "missing")})
+		Expect(results[0].Passed).To(BeTrue())
+		Expect(results[1]).To(Equal(Result{Check: "socket", Target: filePath, Message: "not a socket"}))
+		Expect(results[2].Passed).To(BeFalse())
+	})
+
+	It("checks the OVN annotations", func() {
+		listNodes = func(string) ([]v1.Node, error) {
+			return []v1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "master-0", Annotations: map[string]string{
+					"k8s.ovn.org/host-cidrs": `["192.168.111.20/24","fd2e:6f44:5dd8::20/64"]`,
+				}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "master-1", Annotations: map[string]string{
+					"k8s.ovn.org/host-addresses": `["192.168.111.21/24"]`,
+				}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "master-2", Annotations: map[string]string{
+					"k8s.ovn.org/host-cidrs": `192.168.111.22/24`,
+				}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "worker-0"}},
+			}, nil
+		}
+		results := checkOVNAnnotations("kubeconfig")
+		Expect(results).To(HaveLen(3))
+		Expect(results[0]).To(Equal(Result{Check: "ovn-annotations", Target: "master-0 k8s.ovn.org/host-cidrs", Passed: true, Message: "2 address(es)"}))
+		Expect(results[1].Passed).To(BeFalse())
+		Expect(results[2].Passed).To(BeFalse())
+	})
+
+	It("writes a text report", func() {
+		report := Report{Results: []Result{{Check: "socket", Target: "/run/a.sock", Message: "not a socket"}}}
+		buf := &bytes.Buffer{}
+		Expect(report.WriteText(buf)).To(Succeed())
+		Expect(buf.String()).To(Equal("FAIL  socket  /run/a.sock  not a socket\nFAIL\n"))
+	})
+})
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Verify tests")
+}